@@ -17,18 +17,23 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 
+	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/kvproto/pkg/import_sstpb"
 	uuid "github.com/satori/go.uuid"
 
 	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/restore"
 )
 
@@ -41,9 +46,10 @@ func main() {
 
 func run() error {
 	var (
-		compact, flagFetchMode                      *bool
+		compact, flagFetchMode, flagResolve         *bool
 		mode, flagImportEngine, flagCleanupEngine   *string
 		cpRemove, cpErrIgnore, cpErrDestroy, cpDump *string
+		cpRestore                                   *string
 
 		fsUsage func()
 	)
@@ -60,6 +66,9 @@ func run() error {
 		cpErrIgnore = fs.String("checkpoint-error-ignore", "", "ignore errors encoutered previously on the given table (value can be 'all' or '`db`.`table`'); may corrupt this table if used incorrectly")
 		cpErrDestroy = fs.String("checkpoint-error-destroy", "", "deletes imported data with table which has an error before (value can be 'all' or '`db`.`table`')")
 		cpDump = fs.String("checkpoint-dump", "", "dump the checkpoint information as two CSV files in the given folder")
+		cpRestore = fs.String("restore-checkpoint", "", "replace the checkpoints by the latest snapshot exported to the given storage URL (e.g. 's3://bucket/prefix')")
+
+		flagResolve = fs.Bool("resolve", false, "print the tables to be created and where every data file is routed to after filtering, without importing anything")
 
 		fsUsage = fs.Usage
 	}))
@@ -110,6 +119,12 @@ func run() error {
 	if len(*cpDump) != 0 {
 		return errors.Trace(checkpointDump(ctx, cfg, *cpDump))
 	}
+	if len(*cpRestore) != 0 {
+		return errors.Trace(checkpointRestore(ctx, cfg, *cpRestore))
+	}
+	if *flagResolve {
+		return errors.Trace(resolve(ctx, cfg, os.Stdout))
+	}
 
 	fsUsage()
 	return nil
@@ -191,7 +206,7 @@ func checkpointErrorDestroy(ctx context.Context, cfg *config.Config, tls *common
 	}
 	defer cpdb.Close()
 
-	target, err := restore.NewTiDBManager(cfg.TiDB, tls)
+	target, err := restore.NewTiDBManager(cfg.TiDB.DDLStore(), tls)
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -300,6 +315,25 @@ func checkpointDump(ctx context.Context, cfg *config.Config, dumpFolder string)
 	return nil
 }
 
+func checkpointRestore(ctx context.Context, cfg *config.Config, snapshotURL string) error {
+	u, err := storage.ParseBackend(snapshotURL, &storage.BackendOptions{})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	store, err := storage.Create(ctx, u, true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	return errors.Trace(checkpoints.ImportSnapshot(ctx, cpdb, store))
+}
+
 func unsafeCloseEngine(ctx context.Context, importer kv.Backend, engine string) (*kv.ClosedEngine, error) {
 	if index := strings.LastIndexByte(engine, ':'); index >= 0 {
 		tableName := engine[:index]
@@ -347,3 +381,59 @@ func cleanupEngine(ctx context.Context, cfg *config.Config, tls *common.TLS, eng
 
 	return errors.Trace(ce.Cleanup(ctx))
 }
+
+func resolve(ctx context.Context, cfg *config.Config, w io.Writer) error {
+	loader, err := mydump.NewMyDumpLoader(ctx, cfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(writeResolution(w, cfg, loader.GetDatabases(), loader.GetFilteredTables()))
+}
+
+// writeResolution prints the databases and tables to be created, the data
+// files routed to each table, and the tables excluded by the filters.
+func writeResolution(w io.Writer, cfg *config.Config, dbMetas []*mydump.MDDatabaseMeta, filtered []mydump.FilteredTable) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "DATABASE\tTABLE\tSCHEMA FILE")
+	for _, dbMeta := range dbMetas {
+		var dbName strings.Builder
+		common.WriteMySQLIdentifier(&dbName, dbMeta.Name)
+		fmt.Fprintf(tw, "%s\t\t%s\n", dbName.String(), orNone(dbMeta.SchemaFile))
+		for _, tableMeta := range dbMeta.Tables {
+			fmt.Fprintf(tw, "\t%s\t%s\n", common.UniqueTable(dbMeta.Name, tableMeta.Name), orNone(tableMeta.SchemaFile.FileMeta.Path))
+		}
+	}
+	fmt.Fprintln(tw)
+
+	var totalChunks int64
+	fmt.Fprintln(tw, "DATA FILE\tTABLE\tTYPE\tCOMPRESSION\tSIZE\tCHUNKS")
+	for _, dbMeta := range dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(dbMeta.Name, tableMeta.Name)
+			for _, dataFile := range tableMeta.DataFiles {
+				chunks := mydump.EstimateChunkCount(cfg, dataFile)
+				totalChunks += chunks
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\n",
+					dataFile.FileMeta.Path, tableName, dataFile.FileMeta.Type, dataFile.FileMeta.Compression, dataFile.Size, chunks)
+			}
+		}
+	}
+	fmt.Fprintf(tw, "total\t\t\t\t\t%d\n", totalChunks)
+
+	if len(filtered) > 0 {
+		fmt.Fprintln(tw)
+		fmt.Fprintln(tw, "FILTERED TABLE\tREASON")
+		for _, table := range filtered {
+			fmt.Fprintf(tw, "%s\t%s\n", common.UniqueTable(table.Schema, table.Name), table.Reason)
+		}
+	}
+	return errors.Trace(tw.Flush())
+}
+
+func orNone(path string) string {
+	if len(path) == 0 {
+		return "<none>"
+	}
+	return path
+}
@@ -14,9 +14,15 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"strings"
 	"testing"
+
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
 )
 
 func TestRunMain(t *testing.T) {
@@ -40,3 +46,53 @@ func TestRunMain(t *testing.T) {
 
 	<-waitCh
 }
+
+func TestWriteResolution(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.MaxRegionSize = 100
+	cfg.Mydumper.StrictFormat = true
+
+	dbMetas := []*mydump.MDDatabaseMeta{
+		{
+			Name:       "db",
+			SchemaFile: "db-schema-create.sql",
+			Tables: []*mydump.MDTableMeta{
+				{
+					DB:         "db",
+					Name:       "t",
+					SchemaFile: mydump.FileInfo{FileMeta: mydump.SourceFileMeta{Path: "db.t-schema.sql"}},
+					DataFiles: []mydump.FileInfo{
+						{FileMeta: mydump.SourceFileMeta{Path: "db.t.1.csv", Type: mydump.SourceTypeCSV}, Size: 250},
+						{FileMeta: mydump.SourceFileMeta{Path: "db.t.2.sql.gz", Type: mydump.SourceTypeSQL, Compression: mydump.CompressionGZ}, Size: 50},
+					},
+				},
+			},
+		},
+	}
+	filtered := []mydump.FilteredTable{
+		{Table: filter.Table{Schema: "db", Name: "big"}, Reason: "exceeds max-table-size"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeResolution(&buf, cfg, dbMetas, filtered); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Join([]string{
+		"DATABASE  TABLE     SCHEMA FILE",
+		"`db`                db-schema-create.sql",
+		"          `db`.`t`  db.t-schema.sql",
+		"",
+		"DATA FILE      TABLE     TYPE  COMPRESSION  SIZE  CHUNKS",
+		"db.t.1.csv     `db`.`t`  csv   none         250   3",
+		"db.t.2.sql.gz  `db`.`t`  sql   gz           50    1",
+		"total                                             4",
+		"",
+		"FILTERED TABLE  REASON",
+		"`db`.`big`      exceeds max-table-size",
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Fatalf("unexpected resolution:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
@@ -14,20 +14,36 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"runtime/debug"
 	"syscall"
 
+	"github.com/pingcap/errors"
 	"go.uber.org/zap"
 
 	"github.com/pingcap/tidb-lightning/lightning"
+	"github.com/pingcap/tidb-lightning/lightning/benchparse"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/restore"
+	"github.com/pingcap/tidb-lightning/lightning/wizard"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		runInit(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench-parse" {
+		runBenchParse(os.Args[2:])
+		return
+	}
+
 	cfg := config.Must(config.LoadGlobalConfig(os.Args[1:], nil))
 	fmt.Fprintf(os.Stdout, "Verbose debug logs will be written to %s\n\n", cfg.App.Config.File)
 
@@ -41,9 +57,18 @@ func main() {
 		syscall.SIGQUIT)
 
 	go func() {
-		sig := <-sc
-		log.L().Info("got signal to exit", zap.Stringer("signal", sig))
-		app.Stop()
+		for sig := range sc {
+			if sig == syscall.SIGHUP {
+				// reload the settings which can be changed at runtime,
+				// like `systemctl reload`.
+				log.L().Info("got signal to reload config", zap.Stringer("signal", sig))
+				reloadConfig(app)
+				continue
+			}
+			log.L().Info("got signal to exit", zap.Stringer("signal", sig))
+			app.Stop()
+			return
+		}
 	}()
 
 	logger := log.L()
@@ -79,7 +104,11 @@ func main() {
 	} else {
 		err = app.RunOnce()
 	}
-	if err != nil {
+	partial := errors.Cause(err) == restore.ErrImportPartial
+	if partial {
+		logger.Warn("tidb lightning stopped with a partial import", log.ShortError(err))
+		fmt.Fprintln(os.Stderr, "tidb lightning stopped with a partial import: ", err)
+	} else if err != nil {
 		logger.Error("tidb lightning encountered error stack info", zap.Error(err))
 		logger.Error("tidb lightning encountered error", log.ShortError(err))
 		fmt.Fprintln(os.Stderr, "tidb lightning encountered error: ", err)
@@ -96,7 +125,67 @@ func main() {
 		}
 	}
 
+	if partial {
+		// the import is resumable, which is distinguished from a failure.
+		os.Exit(2)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
+// reloadConfig re-reads the config file and the command line arguments.
+func reloadConfig(app *lightning.Lightning) {
+	cfg, err := config.LoadGlobalConfig(os.Args[1:], nil)
+	if err != nil {
+		log.L().Warn("reload config failed, keep the current config", log.ShortError(err))
+		return
+	}
+	app.Reload(cfg)
+}
+
+// runInit runs `tidb-lightning init`, which interviews the user and writes the
+// config file.
+func runInit(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	path := fs.String("config", "tidb-lightning.toml", "path of the config file to write")
+	_ = fs.Parse(args)
+
+	if err := wizard.New(os.Stdin, os.Stdout, *path).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, "tidb lightning init failed:", err)
+		os.Exit(1)
+	}
+}
+
+// runBenchParse runs `tidb-lightning bench-parse`, which measures the parse
+// throughput of a data file with the settings of the task config.
+func runBenchParse(args []string) {
+	fs := flag.NewFlagSet("bench-parse", flag.ExitOnError)
+	file := fs.String("file", "", "path of the data file to parse")
+	configPath := fs.String("config", "", "path of the task config file providing the parser settings")
+	expected := fs.String("expected", "", "path of a JSON file of the expected rows to validate against")
+	_ = fs.Parse(args)
+
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "tidb lightning bench-parse: --file is required")
+		os.Exit(1)
+	}
+	cfg := config.NewConfig()
+	if *configPath != "" {
+		data, err := ioutil.ReadFile(*configPath)
+		if err == nil {
+			err = cfg.LoadFromTOML(data)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "tidb lightning bench-parse: failed to load config:", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := benchparse.Run(context.Background(), cfg, *file, *expected)
 	if err != nil {
+		fmt.Fprintln(os.Stderr, "tidb lightning bench-parse failed:", err)
 		os.Exit(1)
 	}
+	result.Report(os.Stdout)
 }
@@ -4,8 +4,14 @@ go 1.13
 
 require (
 	cloud.google.com/go/bigquery v1.4.0 // indirect
+	cloud.google.com/go/storage v1.5.0
+	github.com/Azure/azure-storage-blob-go v0.13.0
+	github.com/Azure/go-autorest/autorest/adal v0.9.15
 	github.com/BurntSushi/toml v0.3.1
 	github.com/DATA-DOG/go-sqlmock v1.4.1
+	github.com/aliyun/aliyun-oss-go-sdk v3.0.2+incompatible
+	github.com/apache/arrow/go/arrow v0.0.0-20200730104253-651201b0f516
+	github.com/aws/aws-sdk-go v1.30.24
 	github.com/carlmjohnson/flagext v0.0.11
 	github.com/cockroachdb/pebble v0.0.0-20200617141519-3b241b76ed3b
 	github.com/coreos/go-semver v0.3.0
@@ -13,10 +19,15 @@ require (
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/gogo/protobuf v1.3.1
 	github.com/golang/mock v1.4.3
-	github.com/google/go-cmp v0.5.0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/golang/snappy v0.0.2-0.20190904063534-ff6b7dc882cf
+	github.com/google/go-cmp v0.5.5 // indirect
 	github.com/joho/sqltocsv v0.0.0-20190824231449-5650f27fd5b6
 	github.com/juju/loggo v0.0.0-20180524022052-584905176618 // indirect
+	github.com/klauspost/compress v1.9.7
+	github.com/linkedin/goavro/v2 v2.9.8
 	github.com/onsi/ginkgo v1.13.0 // indirect
+	github.com/pierrec/lz4 v2.0.5+incompatible
 	github.com/pingcap/br v0.0.0-20200903160657-0fcfd5be4b93
 	github.com/pingcap/check v0.0.0-20200212061837-5e12011dc712
 	github.com/pingcap/errors v0.11.5-0.20200729012136-4e113ddee29e
@@ -26,22 +37,28 @@ require (
 	github.com/pingcap/parser v0.0.0-20200821073936-cf85e80665c4
 	github.com/pingcap/tidb v1.1.0-beta.0.20200831085451-438945d2948e
 	github.com/pingcap/tidb-tools v4.0.5-0.20200820092506-34ea90c93237+incompatible
+	github.com/pkg/sftp v1.11.0
 	github.com/prometheus/client_golang v1.5.1
 	github.com/prometheus/client_model v0.2.0
 	github.com/satori/go.uuid v1.2.0
+	github.com/scritchley/orc v0.0.0-20210513144143-06dddf1ad665
 	github.com/shurcooL/httpgzip v0.0.0-20190720172056-320755c1c1b0
 	github.com/spaolacci/murmur3 v1.1.0 // indirect
 	github.com/tikv/pd v1.1.0-beta.0.20200818122340-ef1a4e920b2f
+	github.com/ulikunitz/xz v0.5.10
 	github.com/xitongsys/parquet-go v1.5.2
 	github.com/xitongsys/parquet-go-source v0.0.0-20190524061010-2b72cbee77d5
 	go.opencensus.io v0.22.3 // indirect
 	go.uber.org/zap v1.15.0
+	golang.org/x/crypto v0.0.0-20200204104054-c9f3fb736b72
 	golang.org/x/net v0.0.0-20200813134508-3edf25e44fcc
 	golang.org/x/sync v0.0.0-20200317015054-43a5402ce75a
 	golang.org/x/sys v0.0.0-20200814200057-3d37ad5750ed // indirect
 	golang.org/x/text v0.3.3
-	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
+	google.golang.org/api v0.15.1
 	google.golang.org/grpc v1.26.0
-	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce // indirect
+	google.golang.org/protobuf v1.28.1
+	gopkg.in/mgo.v2 v2.0.0-20180705113604-9856a29383ce
 	modernc.org/mathutil v1.0.0
 )
@@ -81,6 +81,27 @@ func (s *checkReqSuite) TestCheckVersion(c *C) {
 	c.Assert(err, ErrorMatches, "TiNB version too old.*")
 }
 
+func (s *checkReqSuite) TestNewCompat(c *C) {
+	c.Assert(NewCompat(*semver.New("4.0.0")), DeepEquals, &Compat{Version: *semver.New("4.0.0")})
+	c.Assert(NewCompat(*semver.New("4.0.8")), DeepEquals, &Compat{
+		Version:                  *semver.New("4.0.8"),
+		AutoRandomBase:           true,
+		AutoRandomExplicitInsert: true,
+	})
+	c.Assert(NewCompat(*semver.New("5.0.0-rc")), DeepEquals, &Compat{
+		Version:                  *semver.New("5.0.0-rc"),
+		AutoRandomBase:           true,
+		AutoRandomExplicitInsert: true,
+	})
+	c.Assert(NewCompat(*semver.New("5.1.0")), DeepEquals, &Compat{
+		Version:                  *semver.New("5.1.0"),
+		MultiIngest:              true,
+		AutoRandomBase:           true,
+		AutoRandomExplicitInsert: true,
+		GCLifeTimeVariable:       true,
+	})
+}
+
 func (s *checkReqSuite) TestCheckTiDBVersion(c *C) {
 	var version string
 
@@ -0,0 +1,113 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// columnTypeBackends are the backends which can encode the column types. The
+// types not listed are supported by all backends. The local and importer
+// backends encode the rows into KV pairs by the table codec, which cannot cast
+// the values of the spatial types, while the TiDB backend passes the values
+// through in the SQL statements.
+var columnTypeBackends = map[byte][]string{
+	mysql.TypeGeometry: {config.BackendTiDB},
+}
+
+// SupportsColumnType returns whether the backend can encode the values of the
+// column type.
+func SupportsColumnType(backend string, tp byte) bool {
+	backends, ok := columnTypeBackends[tp]
+	if !ok {
+		return true
+	}
+	for _, b := range backends {
+		if b == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedColumn is a column whose type the chosen backend cannot encode.
+type UnsupportedColumn struct {
+	Name string
+	Type string
+	// Backends are the backends which support the type.
+	Backends []string
+}
+
+func (c UnsupportedColumn) String() string {
+	return fmt.Sprintf("`%s` %s (supported by '%s')", c.Name, c.Type, strings.Join(c.Backends, "', '"))
+}
+
+// UnsupportedColumns returns the columns of the table whose types the backend
+// cannot encode, in the order of the table definition.
+func UnsupportedColumns(backend string, tableInfo *model.TableInfo) []UnsupportedColumn {
+	var columns []UnsupportedColumn
+	for _, col := range tableInfo.Columns {
+		if SupportsColumnType(backend, col.Tp) {
+			continue
+		}
+		columns = append(columns, UnsupportedColumn{
+			Name:     col.Name.O,
+			Type:     types.TypeToStr(col.Tp, col.Charset),
+			Backends: columnTypeBackends[col.Tp],
+		})
+	}
+	return columns
+}
+
+// UnsupportedColumnsError reports the tables having the columns whose types
+// the chosen backend cannot encode, so the task fails before importing any
+// table rather than on the first row of such a table.
+type UnsupportedColumnsError struct {
+	Backend string
+	// Tables are the unsupported columns keyed by the unique table names.
+	Tables map[string][]UnsupportedColumn
+}
+
+func (e *UnsupportedColumnsError) Error() string {
+	tableNames := make([]string, 0, len(e.Tables))
+	for tableName := range e.Tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "the %s backend cannot encode the column types of %d table(s):", e.Backend, len(tableNames))
+	for _, tableName := range tableNames {
+		sb.WriteString(" ")
+		sb.WriteString(tableName)
+		sb.WriteString(" [")
+		for i, col := range e.Tables[tableName] {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString(col.String())
+		}
+		sb.WriteString("];")
+	}
+	sb.WriteString(" please exclude these tables or change `tikv-importer.backend`")
+	return sb.String()
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+type columnTypesSuite struct{}
+
+var _ = Suite(&columnTypesSuite{})
+
+func (s *columnTypesSuite) TestSupportsColumnType(c *C) {
+	for _, backend := range []string{config.BackendLocal, config.BackendImporter, config.BackendTiDB} {
+		c.Assert(SupportsColumnType(backend, mysql.TypeLonglong), IsTrue)
+		c.Assert(SupportsColumnType(backend, mysql.TypeJSON), IsTrue)
+	}
+	c.Assert(SupportsColumnType(config.BackendTiDB, mysql.TypeGeometry), IsTrue)
+	c.Assert(SupportsColumnType(config.BackendLocal, mysql.TypeGeometry), IsFalse)
+	c.Assert(SupportsColumnType(config.BackendImporter, mysql.TypeGeometry), IsFalse)
+}
+
+func (s *columnTypesSuite) TestUnsupportedColumns(c *C) {
+	tableInfo := &model.TableInfo{Columns: []*model.ColumnInfo{
+		{Name: model.NewCIStr("id"), FieldType: *types.NewFieldType(mysql.TypeLonglong)},
+		{Name: model.NewCIStr("Shape"), FieldType: *types.NewFieldType(mysql.TypeGeometry)},
+	}}
+	columns := UnsupportedColumns(config.BackendImporter, tableInfo)
+	c.Assert(columns, DeepEquals, []UnsupportedColumn{
+		{Name: "Shape", Type: "geometry", Backends: []string{config.BackendTiDB}},
+	})
+	c.Assert(columns[0].String(), Equals, "`Shape` geometry (supported by 'tidb')")
+	c.Assert(UnsupportedColumns(config.BackendTiDB, tableInfo), HasLen, 0)
+}
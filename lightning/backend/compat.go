@@ -0,0 +1,55 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"github.com/coreos/go-semver/semver"
+)
+
+var (
+	multiIngestVersion    = *semver.New("5.0.0")
+	autoRandomBaseVersion = *semver.New("4.0.3")
+	gcLifeTimeVarVersion  = *semver.New("5.0.0")
+)
+
+// Compat is the set of the features which differ among the versions of the
+// target cluster. When the version of the target is pinned in the config,
+// Lightning follows these flags instead of probing the cluster, so the same
+// binary can import into the older 4.x and 5.x clusters.
+type Compat struct {
+	Version semver.Version
+	// MultiIngest is whether TiKV ingests multiple SSTs in one request.
+	MultiIngest bool
+	// AutoRandomBase is whether `ALTER TABLE ... AUTO_RANDOM_BASE` is
+	// supported to rebase the auto_random IDs after the import.
+	AutoRandomBase bool
+	// AutoRandomExplicitInsert is whether the session variable
+	// allow_auto_random_explicit_insert exists.
+	AutoRandomExplicitInsert bool
+	// GCLifeTimeVariable is whether the GC lifetime extended during the
+	// checksum is the system variable tidb_gc_life_time rather than the row
+	// of mysql.tidb.
+	GCLifeTimeVariable bool
+}
+
+// NewCompat returns the features of the target cluster of the version.
+func NewCompat(version semver.Version) *Compat {
+	return &Compat{
+		Version:                  version,
+		MultiIngest:              !version.LessThan(multiIngestVersion),
+		AutoRandomBase:           !version.LessThan(autoRandomBaseVersion),
+		AutoRandomExplicitInsert: !version.LessThan(autoRandomBaseVersion),
+		GCLifeTimeVariable:       !version.LessThan(gcLifeTimeVarVersion),
+	}
+}
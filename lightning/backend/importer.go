@@ -277,16 +277,22 @@ func extractTiDBVersion(version string) (*semver.Version, error) {
 	return semver.NewVersion(rawVersion)
 }
 
-func checkTiDBVersion(tls *common.TLS, requiredVersion semver.Version) error {
+// FetchTiDBVersion fetches the version of TiDB from its status port.
+func FetchTiDBVersion(tls *common.TLS) (*semver.Version, error) {
 	var status struct{ Version string }
 	err := tls.GetJSON("/status", &status)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	version, err := extractTiDBVersion(status.Version)
+	return version, errors.Trace(err)
+}
+
+func checkTiDBVersion(tls *common.TLS, requiredVersion semver.Version) error {
+	version, err := FetchTiDBVersion(tls)
 	if err != nil {
-		return errors.Trace(err)
+		return err
 	}
 	return checkVersion("TiDB", requiredVersion, *version)
 }
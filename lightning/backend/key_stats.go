@@ -0,0 +1,228 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pingcap/tidb/tablecodec"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	// maxKeyRangeStats bounds the statistics kept for each engine, which are
+	// merged pairwise when exceeded.
+	maxKeyRangeStats = 1024
+
+	// the lengths of the key prefixes `t{tableID}_r` and `t{tableID}_i{indexID}`.
+	recordPrefixLen = 1 + 8 + 2
+	indexPrefixLen  = recordPrefixLen + 8
+)
+
+// keyRangeStat is the minimum and the maximum keys, the total size and the
+// number of the KV pairs of the same table or index in a batch written to the
+// engine. The rows of a chunk are encoded in the order of their row IDs, so
+// the statistics of a data engine tell the actual distribution of its keys.
+type keyRangeStat struct {
+	MinKey []byte `json:"min_key"`
+	MaxKey []byte `json:"max_key"`
+	Size   int64  `json:"size"`
+	Count  int64  `json:"count"`
+}
+
+// keyStatPrefix returns the prefix of the table or the index of the key.
+func keyStatPrefix(key []byte) []byte {
+	switch {
+	case tablecodec.IsIndexKey(key) && len(key) >= indexPrefixLen:
+		return key[:indexPrefixLen]
+	case len(key) >= recordPrefixLen:
+		return key[:recordPrefixLen]
+	default:
+		return key
+	}
+}
+
+// collectKeyStats returns the statistics of the pairs of each table or index.
+func collectKeyStats(kvs kvPairs) []keyRangeStat {
+	stats := make([]keyRangeStat, 0, 1)
+	index := make(map[string]int, 1)
+	for _, pair := range kvs {
+		size := int64(len(pair.Key) + len(pair.Val))
+		prefix := keyStatPrefix(pair.Key)
+		i, ok := index[string(prefix)]
+		if !ok {
+			index[string(prefix)] = len(stats)
+			stats = append(stats, keyRangeStat{MinKey: pair.Key, MaxKey: pair.Key, Size: size, Count: 1})
+			continue
+		}
+		stat := &stats[i]
+		if bytes.Compare(pair.Key, stat.MinKey) < 0 {
+			stat.MinKey = pair.Key
+		}
+		if bytes.Compare(pair.Key, stat.MaxKey) > 0 {
+			stat.MaxKey = pair.Key
+		}
+		stat.Size += size
+		stat.Count++
+	}
+	// the keys are copied since the buffers of the pairs are reused.
+	for i := range stats {
+		stats[i].MinKey = append([]byte{}, stats[i].MinKey...)
+		stats[i].MaxKey = append([]byte{}, stats[i].MaxKey...)
+	}
+	return stats
+}
+
+// compactKeyStats merges the adjacent statistics in the order of the minimum
+// keys pairwise, until there are at most `limit`.
+func compactKeyStats(stats []keyRangeStat, limit int) []keyRangeStat {
+	if len(stats) <= limit {
+		return stats
+	}
+	sort.Slice(stats, func(i, j int) bool { return bytes.Compare(stats[i].MinKey, stats[j].MinKey) < 0 })
+	for len(stats) > limit {
+		merged := stats[:0]
+		for i := 0; i < len(stats); i += 2 {
+			stat := stats[i]
+			if i+1 < len(stats) {
+				next := stats[i+1]
+				if bytes.Compare(next.MaxKey, stat.MaxKey) > 0 {
+					stat.MaxKey = next.MaxKey
+				}
+				stat.Size += next.Size
+				stat.Count += next.Count
+			}
+			merged = append(merged, stat)
+		}
+		stats = merged
+	}
+	return stats
+}
+
+// addKeyStats records the statistics of the pairs written to the engine.
+func (e *LocalFile) addKeyStats(kvs kvPairs) {
+	stats := collectKeyStats(kvs)
+	e.statsMu.Lock()
+	defer e.statsMu.Unlock()
+	e.KeyStats = compactKeyStats(append(e.KeyStats, stats...), maxKeyRangeStats)
+}
+
+// splitKeysByStats estimates the keys splitting [start, end) into the ranges
+// of at most `targetSize` bytes and `targetCount` pairs. The pairs of each
+// statistic are assumed to be spread evenly over the intervals between the
+// boundary keys of all statistics within its range. Returns the split keys,
+// excluding start and end, and the estimated size and count of the largest
+// range, which exceed the targets if an interval cannot be split further.
+func splitKeysByStats(
+	stats []keyRangeStat,
+	start []byte,
+	end []byte,
+	targetSize int64,
+	targetCount int64,
+) (splitKeys [][]byte, maxSize int64, maxCount int64) {
+	bounds := make([][]byte, 0, 2*len(stats)+2)
+	bounds = append(bounds, start, end)
+	for _, stat := range stats {
+		bounds = append(bounds, stat.MinKey, nextKey(stat.MaxKey))
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bytes.Compare(bounds[i], bounds[j]) < 0 })
+	unique := bounds[:1]
+	for _, bound := range bounds[1:] {
+		if !bytes.Equal(bound, unique[len(unique)-1]) {
+			unique = append(unique, bound)
+		}
+	}
+	bounds = unique
+	search := func(key []byte) int {
+		return sort.Search(len(bounds), func(i int) bool { return bytes.Compare(bounds[i], key) >= 0 })
+	}
+
+	// the differences of the size and the count between the adjacent
+	// intervals, where the interval i is [bounds[i], bounds[i+1]).
+	sizeDiffs := make([]float64, len(bounds))
+	countDiffs := make([]float64, len(bounds))
+	for _, stat := range stats {
+		first, last := search(stat.MinKey), search(nextKey(stat.MaxKey))
+		intervals := float64(last - first)
+		sizeDiffs[first] += float64(stat.Size) / intervals
+		sizeDiffs[last] -= float64(stat.Size) / intervals
+		countDiffs[first] += float64(stat.Count) / intervals
+		countDiffs[last] -= float64(stat.Count) / intervals
+	}
+
+	var intervalSize, intervalCount, rangeSize, rangeCount float64
+	startIndex, endIndex := search(start), search(end)
+	for i := 0; i < endIndex; i++ {
+		intervalSize += sizeDiffs[i]
+		intervalCount += countDiffs[i]
+		if i < startIndex {
+			continue
+		}
+		// cut before the interval if it would exceed the targets.
+		if i > startIndex && (rangeSize+intervalSize > float64(targetSize) || rangeCount+intervalCount > float64(targetCount)) {
+			splitKeys = append(splitKeys, bounds[i])
+			rangeSize, rangeCount = 0, 0
+		}
+		rangeSize += intervalSize
+		rangeCount += intervalCount
+		if int64(rangeSize) > maxSize {
+			maxSize = int64(rangeSize)
+		}
+		if int64(rangeCount) > maxCount {
+			maxCount = int64(rangeCount)
+		}
+	}
+	return splitKeys, maxSize, maxCount
+}
+
+// rangesByKeyStats splits [start, end) into the ranges of the target size and
+// count by the statistics of the keys written, so the ranges follow the actual
+// distribution of the keys. Returns nil if the statistics do not cover all
+// pairs, e.g. the engine was written by an older version, or cannot split the
+// engine into the ranges within the limits, in which case the keys should be
+// sampled instead.
+func (e *LocalFile) rangesByKeyStats(start, end []byte, targetSize, targetCount, limitSize, limitCount int64) []Range {
+	e.statsMu.Lock()
+	stats := append([]keyRangeStat{}, e.KeyStats...)
+	e.statsMu.Unlock()
+
+	var count int64
+	for _, stat := range stats {
+		count += stat.Count
+	}
+	if len(stats) == 0 || count != atomic.LoadInt64(&e.Length) {
+		return nil
+	}
+	splitKeys, maxSize, maxCount := splitKeysByStats(stats, start, end, targetSize, targetCount)
+	if maxSize > limitSize || maxCount > limitCount {
+		log.L().Info("the key statistics cannot split the engine, fallback to sample the keys",
+			zap.Stringer("engine", e.Uuid), zap.Int("stats", len(stats)),
+			zap.Int64("maxSize", maxSize), zap.Int64("maxCount", maxCount))
+		return nil
+	}
+
+	ranges := make([]Range, 0, len(splitKeys)+1)
+	for _, key := range splitKeys {
+		ranges = append(ranges, Range{start: start, end: key})
+		start = key
+	}
+	ranges = append(ranges, Range{start: start, end: end})
+	log.L().Info("split engine by key statistics", zap.Stringer("engine", e.Uuid),
+		zap.Int("stats", len(stats)), zap.Int("ranges", len(ranges)), zap.Int64("maxSize", maxSize))
+	return ranges
+}
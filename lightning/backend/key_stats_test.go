@@ -0,0 +1,124 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
+)
+
+type keyStatsSuite struct{}
+
+var _ = Suite(&keyStatsSuite{})
+
+func rowKey(handle int64) []byte {
+	return codec.EncodeInt(append([]byte{}, tablecodec.GenTableRecordPrefix(1)...), handle)
+}
+
+func indexKey(value string) []byte {
+	return append(tablecodec.EncodeTableIndexPrefix(1, 2), value...)
+}
+
+func (s *keyStatsSuite) TestKeyStatPrefix(c *C) {
+	c.Assert(keyStatPrefix(rowKey(7)), DeepEquals, []byte(tablecodec.GenTableRecordPrefix(1)))
+	c.Assert(keyStatPrefix(indexKey("abc")), DeepEquals, tablecodec.EncodeTableIndexPrefix(1, 2))
+	c.Assert(keyStatPrefix([]byte("t")), DeepEquals, []byte("t"))
+}
+
+func (s *keyStatsSuite) TestCollectKeyStats(c *C) {
+	kvs := kvPairs{
+		{Key: rowKey(3), Val: []byte("ccc")},
+		{Key: indexKey("b"), Val: []byte("0")},
+		{Key: rowKey(1), Val: []byte("a")},
+		{Key: indexKey("a"), Val: []byte("0")},
+		{Key: rowKey(2), Val: []byte("bb")},
+	}
+	stats := collectKeyStats(kvs)
+	c.Assert(stats, DeepEquals, []keyRangeStat{
+		{MinKey: rowKey(1), MaxKey: rowKey(3), Size: 3*19 + 6, Count: 3},
+		{MinKey: indexKey("a"), MaxKey: indexKey("b"), Size: 2*20 + 2, Count: 2},
+	})
+	// the keys are copied.
+	kvs[2].Key[len(kvs[2].Key)-1] = 0xff
+	c.Assert(stats[0].MinKey, DeepEquals, rowKey(1))
+}
+
+func (s *keyStatsSuite) TestCompactKeyStats(c *C) {
+	stats := []keyRangeStat{
+		{MinKey: rowKey(40), MaxKey: rowKey(49), Size: 10, Count: 1},
+		{MinKey: rowKey(0), MaxKey: rowKey(9), Size: 10, Count: 1},
+		{MinKey: rowKey(20), MaxKey: rowKey(29), Size: 10, Count: 1},
+		{MinKey: rowKey(10), MaxKey: rowKey(39), Size: 10, Count: 1},
+		{MinKey: rowKey(30), MaxKey: rowKey(35), Size: 10, Count: 1},
+	}
+	c.Assert(compactKeyStats(stats, 5), HasLen, 5)
+	c.Assert(compactKeyStats(stats, 2), DeepEquals, []keyRangeStat{
+		{MinKey: rowKey(0), MaxKey: rowKey(39), Size: 40, Count: 4},
+		{MinKey: rowKey(40), MaxKey: rowKey(49), Size: 10, Count: 1},
+	})
+}
+
+func (s *keyStatsSuite) TestSplitKeysByStats(c *C) {
+	// the batches of the adjacent row IDs.
+	stats := []keyRangeStat{
+		{MinKey: rowKey(200), MaxKey: rowKey(299), Size: 60, Count: 10},
+		{MinKey: rowKey(0), MaxKey: rowKey(99), Size: 60, Count: 10},
+		{MinKey: rowKey(300), MaxKey: rowKey(399), Size: 60, Count: 10},
+		{MinKey: rowKey(100), MaxKey: rowKey(199), Size: 60, Count: 10},
+	}
+	start, end := rowKey(0), nextKey(rowKey(399))
+	splitKeys, maxSize, maxCount := splitKeysByStats(stats, start, end, 100, 100)
+	c.Assert(splitKeys, DeepEquals, [][]byte{rowKey(100), rowKey(200), rowKey(300)})
+	c.Assert(maxSize, Equals, int64(60))
+	c.Assert(maxCount, Equals, int64(10))
+
+	splitKeys, maxSize, maxCount = splitKeysByStats(stats, start, end, 1000, 20)
+	c.Assert(splitKeys, DeepEquals, [][]byte{rowKey(200)})
+	c.Assert(maxSize, Equals, int64(120))
+	c.Assert(maxCount, Equals, int64(20))
+
+	// the batch overlapping others is spread over the intervals.
+	stats = []keyRangeStat{
+		{MinKey: rowKey(0), MaxKey: rowKey(399), Size: 300, Count: 30},
+		{MinKey: rowKey(100), MaxKey: rowKey(199), Size: 100, Count: 10},
+	}
+	splitKeys, maxSize, _ = splitKeysByStats(stats, start, end, 250, 100)
+	c.Assert(splitKeys, DeepEquals, [][]byte{rowKey(100), nextKey(rowKey(199))})
+	c.Assert(maxSize, Equals, int64(200))
+
+	// a single batch cannot be split.
+	splitKeys, maxSize, _ = splitKeysByStats(stats[:1], start, end, 250, 100)
+	c.Assert(splitKeys, HasLen, 0)
+	c.Assert(maxSize, Equals, int64(300))
+}
+
+func (s *keyStatsSuite) TestRangesByKeyStats(c *C) {
+	engine := &LocalFile{}
+	engine.Length = 20
+	engine.KeyStats = []keyRangeStat{
+		{MinKey: rowKey(0), MaxKey: rowKey(99), Size: 60, Count: 10},
+		{MinKey: rowKey(100), MaxKey: rowKey(199), Size: 60, Count: 10},
+	}
+	start, end := rowKey(0), nextKey(rowKey(199))
+	c.Assert(engine.rangesByKeyStats(start, end, 100, 100, 200, 200), DeepEquals, []Range{
+		{start: start, end: rowKey(100)},
+		{start: rowKey(100), end: end},
+	})
+	// the largest range exceeds the limit.
+	c.Assert(engine.rangesByKeyStats(start, end, 100, 100, 50, 200), IsNil)
+	// the engine has the pairs not in the statistics.
+	engine.Length = 30
+	c.Assert(engine.rangesByKeyStats(start, end, 100, 100, 200, 200), IsNil)
+}
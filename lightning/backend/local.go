@@ -45,8 +45,10 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/log"
@@ -89,12 +91,18 @@ type localFileMeta struct {
 	Ts        uint64 `json:"ts"`
 	Length    int64  `json:"length"`
 	TotalSize int64  `json:"total_size"`
+	// KeyStats is the statistics of the keys written, which estimate the
+	// ranges to split the engine into.
+	KeyStats []keyRangeStat `json:"key_stats,omitempty"`
 }
 
 type LocalFile struct {
 	localFileMeta
 	db   *pebble.DB
 	Uuid uuid.UUID
+
+	// statsMu protects KeyStats.
+	statsMu sync.Mutex
 }
 
 func (e *LocalFile) Close() error {
@@ -131,6 +139,10 @@ type local struct {
 	ingestConcurrency *worker.Pool
 	batchWriteKVPairs int
 	checkpointEnabled bool
+
+	// supportMultiIngest indicates whether all TiKV stores support ingesting
+	// multiple SSTs in one request. Otherwise the SSTs are ingested one by one.
+	supportMultiIngest bool
 }
 
 // NewLocalBackend creates new connections to tikv.
@@ -143,6 +155,7 @@ func NewLocalBackend(
 	rangeConcurrency int,
 	sendKVPairs int,
 	enableCheckpoint bool,
+	compat *Compat,
 ) (Backend, error) {
 	pdCli, err := pd.NewClient([]string{pdAddr}, tls.ToPDSecurityOption())
 	if err != nil {
@@ -183,9 +196,60 @@ func NewLocalBackend(
 		checkpointEnabled: enableCheckpoint,
 	}
 	local.grpcClis.clis = make(map[uint64]*grpc.ClientConn)
+	if compat != nil {
+		local.supportMultiIngest = compat.MultiIngest
+		log.L().Info("decide multi-ingest by the target version",
+			zap.Stringer("version", compat.Version), zap.Bool("supported", compat.MultiIngest))
+	} else {
+		local.checkMultiIngestSupport(ctx, pdCli)
+	}
 	return MakeBackend(local), nil
 }
 
+// checkMultiIngestSupport probes every TiKV store with an empty MultiIngest
+// request, and falls back to the per-SST Ingest API if any store does not
+// implement it, e.g. the cluster is slightly older than Lightning.
+func (local *local) checkMultiIngestSupport(ctx context.Context, pdCli pd.Client) {
+	local.supportMultiIngest = false
+	stores, err := pdCli.GetAllStores(ctx, pd.WithExcludeTombstone())
+	if err != nil {
+		log.L().Warn("get stores failed, fallback to ingest SSTs one by one", log.ShortError(err))
+		return
+	}
+	for _, store := range stores {
+		if isTiFlashStore(store) {
+			continue
+		}
+		conn, err := local.getImportConn(ctx, &metapb.Peer{StoreId: store.GetId()})
+		if err != nil {
+			log.L().Warn("connect store failed, fallback to ingest SSTs one by one",
+				zap.Uint64("store", store.GetId()), log.ShortError(err))
+			return
+		}
+		if _, err = multiIngest(ctx, conn, &multiIngestRequest{}); err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				log.L().Info("multi-ingest is not supported, fallback to ingest SSTs one by one",
+					zap.Uint64("store", store.GetId()), zap.String("address", store.GetAddress()))
+			} else {
+				log.L().Warn("probe multi-ingest failed, fallback to ingest SSTs one by one",
+					zap.Uint64("store", store.GetId()), log.ShortError(err))
+			}
+			return
+		}
+	}
+	log.L().Info("multi-ingest is supported by all stores")
+	local.supportMultiIngest = true
+}
+
+func isTiFlashStore(store *metapb.Store) bool {
+	for _, label := range store.GetLabels() {
+		if label.GetKey() == "engine" && label.GetValue() == "tiflash" {
+			return true
+		}
+	}
+	return false
+}
+
 func (local *local) getGrpcConnLocked(ctx context.Context, storeID uint64) (*grpc.ClientConn, error) {
 
 	store, err := local.splitCli.GetStore(ctx, storeID)
@@ -278,7 +342,9 @@ func (local *local) openEngineDB(engineUUID uuid.UUID, readOnly bool) (*pebble.D
 }
 
 func (local *local) saveEngineMeta(engine *LocalFile) error {
+	engine.statsMu.Lock()
 	jsonBytes, err := json.Marshal(&engine.localFileMeta)
+	engine.statsMu.Unlock()
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -349,6 +415,14 @@ func (local *local) CloseEngine(ctx context.Context, engineUUID uuid.UUID) error
 }
 
 func (local *local) getImportClient(ctx context.Context, peer *metapb.Peer) (sst.ImportSSTClient, error) {
+	conn, err := local.getImportConn(ctx, peer)
+	if err != nil {
+		return nil, err
+	}
+	return sst.NewImportSSTClient(conn), nil
+}
+
+func (local *local) getImportConn(ctx context.Context, peer *metapb.Peer) (*grpc.ClientConn, error) {
 	local.grpcClis.mu.Lock()
 	defer local.grpcClis.mu.Unlock()
 	var err error
@@ -361,7 +435,7 @@ func (local *local) getImportClient(ctx context.Context, peer *metapb.Peer) (sst
 			return nil, err
 		}
 	}
-	return sst.NewImportSSTClient(conn), nil
+	return conn, nil
 }
 
 // WriteToTiKV writer engine key-value pairs to tikv and return the sst meta generated by tikv.
@@ -534,13 +608,15 @@ func (local *local) WriteToTiKV(
 	return leaderPeerMetas, remainRange, nil
 }
 
-func (local *local) Ingest(ctx context.Context, meta *sst.SSTMeta, region *split.RegionInfo) (*sst.IngestResponse, error) {
+// Ingest ingests the SSTs into the region. Multiple SSTs can only be ingested
+// in one request if multi-ingest is supported, see ingestBatches.
+func (local *local) Ingest(ctx context.Context, metas []*sst.SSTMeta, region *split.RegionInfo) (*sst.IngestResponse, error) {
 	leader := region.Leader
 	if leader == nil {
 		leader = region.Region.GetPeers()[0]
 	}
 
-	cli, err := local.getImportClient(ctx, leader)
+	conn, err := local.getImportConn(ctx, leader)
 	if err != nil {
 		return nil, err
 	}
@@ -550,17 +626,48 @@ func (local *local) Ingest(ctx context.Context, meta *sst.SSTMeta, region *split
 		Peer:        leader,
 	}
 
-	req := &sst.IngestRequest{
+	if !local.supportMultiIngest {
+		if len(metas) != 1 {
+			return nil, errors.Errorf("cannot ingest %d SSTs in one request without multi-ingest", len(metas))
+		}
+		req := &sst.IngestRequest{
+			Context: reqCtx,
+			Sst:     metas[0],
+		}
+		resp, err := sst.NewImportSSTClient(conn).Ingest(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	req := &multiIngestRequest{
 		Context: reqCtx,
-		Sst:     meta,
+		Ssts:    metas,
 	}
-	resp, err := cli.Ingest(ctx, req)
+	resp, err := multiIngest(ctx, conn, req)
 	if err != nil {
 		return nil, err
 	}
 	return resp, nil
 }
 
+// ingestBatches groups the SSTs written into a region for ingesting, all in
+// one batch with multi-ingest, otherwise one SST per batch.
+func (local *local) ingestBatches(metas []*sst.SSTMeta) [][]*sst.SSTMeta {
+	if len(metas) == 0 {
+		return nil
+	}
+	if local.supportMultiIngest {
+		return [][]*sst.SSTMeta{metas}
+	}
+	batches := make([][]*sst.SSTMeta, 0, len(metas))
+	for _, meta := range metas {
+		batches = append(batches, []*sst.SSTMeta{meta})
+	}
+	return batches
+}
+
 func (local *local) readAndSplitIntoRange(engineFile *LocalFile, engineUUID uuid.UUID) ([]Range, error) {
 	if engineFile.Length == 0 {
 		return nil, nil
@@ -594,8 +701,17 @@ func (local *local) readAndSplitIntoRange(engineFile *LocalFile, engineUUID uuid
 	// because we don't split very accurate, so wo try to split 1/4 more regions to avoid region to be too big
 	// estimiate regions size by the bigger of region size in bytes and kv count
 	splitTargetSize := (local.regionSplitSize*3 + 3) / 4
+	splitTargetCount := int64(regionMaxKeyCount * 3 / 4)
+
+	// split by the statistics of the keys written if they can, which follow
+	// the actual distribution of the keys rather than the sampled keys.
+	if ranges := engineFile.rangesByKeyStats(firstKey, endKey, splitTargetSize, splitTargetCount,
+		local.regionSplitSize, regionMaxKeyCount); ranges != nil {
+		return ranges, nil
+	}
+
 	n := (engineFile.TotalSize + splitTargetSize - 1) / splitTargetSize
-	numByKeyCount := engineFile.Length / (regionMaxKeyCount * 3 / 4)
+	numByKeyCount := engineFile.Length / splitTargetCount
 	if n < numByKeyCount {
 		n = numByKeyCount
 	}
@@ -887,14 +1003,16 @@ func (local *local) WriteAndIngestPairs(
 		return remainRange, err
 	}
 
-	for _, meta := range metas {
+	for _, batch := range local.ingestBatches(metas) {
+		// the SSTs written in one request share the same range.
+		meta := batch[0]
 		var err error
 		for i := 0; i < maxRetryTimes; i++ {
-			log.L().Debug("ingest meta", zap.Reflect("meta", meta))
+			log.L().Debug("ingest meta", zap.Reflect("meta", batch))
 			var resp *sst.IngestResponse
-			resp, err = local.Ingest(ctx, meta, region)
+			resp, err = local.Ingest(ctx, batch, region)
 			if err != nil {
-				log.L().Warn("ingest failed", zap.Error(err), zap.Reflect("meta", meta),
+				log.L().Warn("ingest failed", zap.Error(err), zap.Reflect("meta", batch),
 					zap.Reflect("region", region))
 				continue
 			}
@@ -1111,6 +1229,7 @@ func (local *local) WriteRows(
 	if err := wb.Commit(wo); err != nil {
 		return err
 	}
+	engineFile.addKeyStats(kvs)
 	atomic.AddInt64(&engineFile.Length, int64(len(kvs)))
 	atomic.AddInt64(&engineFile.TotalSize, size)
 	engineFile.Ts = ts
@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	. "github.com/pingcap/check"
+	sst "github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"github.com/pingcap/kvproto/pkg/metapb"
+)
+
+type localSuite struct{}
+
+var _ = Suite(&localSuite{})
+
+func (s *localSuite) TestIngestBatches(c *C) {
+	metas := []*sst.SSTMeta{{CfName: "default"}, {CfName: "write"}}
+
+	local := &local{}
+	c.Assert(local.ingestBatches(nil), HasLen, 0)
+	c.Assert(local.ingestBatches(metas), DeepEquals, [][]*sst.SSTMeta{metas[:1], metas[1:]})
+
+	local.supportMultiIngest = true
+	c.Assert(local.ingestBatches(metas), DeepEquals, [][]*sst.SSTMeta{metas})
+}
+
+func (s *localSuite) TestIsTiFlashStore(c *C) {
+	c.Assert(isTiFlashStore(&metapb.Store{}), IsFalse)
+	c.Assert(isTiFlashStore(&metapb.Store{
+		Labels: []*metapb.StoreLabel{{Key: "zone", Value: "z1"}, {Key: "engine", Value: "tiflash"}},
+	}), IsTrue)
+}
+
+func (s *localSuite) TestMultiIngestRequestMarshal(c *C) {
+	reqCtx := &kvrpcpb.Context{RegionId: 7, Peer: &metapb.Peer{Id: 3, StoreId: 2}}
+	metas := []*sst.SSTMeta{
+		{Uuid: []byte("uuid-1"), CfName: "default", RegionId: 7},
+		{Uuid: []byte("uuid-2"), CfName: "write", RegionId: 7},
+	}
+
+	// MultiIngestRequest shares the field numbers of IngestRequest, so a
+	// single SST must encode identically.
+	single, err := (&sst.IngestRequest{Context: reqCtx, Sst: metas[0]}).Marshal()
+	c.Assert(err, IsNil)
+	data, err := (&multiIngestRequest{Context: reqCtx, Ssts: metas[:1]}).Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, single)
+
+	// the repeated field is the concatenation of the messages.
+	second, err := (&sst.IngestRequest{Sst: metas[1]}).Marshal()
+	c.Assert(err, IsNil)
+	data, err = (&multiIngestRequest{Context: reqCtx, Ssts: metas}).Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, append(single, second...))
+
+	data, err = (&multiIngestRequest{}).Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+}
@@ -59,3 +59,13 @@ func VerifyRLimit(estimateMaxFiles uint64) error {
 	}
 	return nil
 }
+
+// DiskFreeSpace returns the space available to unprivileged users on the
+// file system containing the path.
+func DiskFreeSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, errors.Trace(err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
@@ -22,3 +22,7 @@ import (
 func VerifyRLimit(estimateMaxFiles uint64) error {
 	return errors.New("Local-backend is not tested on Windows. Run with --check-requirements=false to disable this check, but you are on your own risk.")
 }
+
+func DiskFreeSpace(path string) (uint64, error) {
+	return 0, errors.New("querying the disk free space is not supported on Windows")
+}
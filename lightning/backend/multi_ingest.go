@@ -0,0 +1,90 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pingcap/errors"
+	sst "github.com/pingcap/kvproto/pkg/import_sstpb"
+	"github.com/pingcap/kvproto/pkg/kvrpcpb"
+	"google.golang.org/grpc"
+)
+
+// multiIngestMethod is the full gRPC method name of ImportSST.MultiIngest.
+// The pinned kvproto predates this method, so it is invoked on the raw
+// connection instead of through the generated ImportSSTClient.
+const multiIngestMethod = "/import_sstpb.ImportSST/MultiIngest"
+
+// multiIngestRequest is wire compatible with the MultiIngestRequest message
+// of kvproto:
+//
+//	message MultiIngestRequest {
+//	    kvrpcpb.Context context = 1;
+//	    repeated SSTMeta ssts = 2;
+//	}
+//
+// The response is the same IngestResponse as of ImportSST.Ingest.
+type multiIngestRequest struct {
+	Context *kvrpcpb.Context
+	Ssts    []*sst.SSTMeta
+}
+
+func (m *multiIngestRequest) Reset() { *m = multiIngestRequest{} }
+
+func (m *multiIngestRequest) String() string {
+	return fmt.Sprintf("context:<%v> ssts:%v", m.Context, m.Ssts)
+}
+
+func (*multiIngestRequest) ProtoMessage() {}
+
+// Marshal encodes the request in the protobuf wire format. The gRPC codec
+// uses it in place of the reflection based encoder.
+func (m *multiIngestRequest) Marshal() ([]byte, error) {
+	buf := proto.NewBuffer(nil)
+	if m.Context != nil {
+		if err := encodeMessageField(buf, 1, m.Context); err != nil {
+			return nil, err
+		}
+	}
+	for _, meta := range m.Ssts {
+		if err := encodeMessageField(buf, 2, meta); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeMessageField(buf *proto.Buffer, field uint64, msg interface{ Marshal() ([]byte, error) }) error {
+	data, err := msg.Marshal()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := buf.EncodeVarint(field<<3 | proto.WireBytes); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(buf.EncodeRawBytes(data))
+}
+
+// multiIngest ingests all the SSTs of the request into the region at once.
+// TiKV returns codes.Unimplemented if it does not support multi-ingest.
+func multiIngest(ctx context.Context, conn *grpc.ClientConn, req *multiIngestRequest) (*sst.IngestResponse, error) {
+	resp := new(sst.IngestResponse)
+	if err := conn.Invoke(ctx, multiIngestMethod, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
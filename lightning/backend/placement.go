@@ -0,0 +1,93 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+// PlacementRuleGroup is the group of the default placement rule of PD, which
+// the rules of Lightning are added to.
+const PlacementRuleGroup = "pd"
+
+// LabelConstraint restricts the stores of a placement rule by a label, see
+// https://docs.pingcap.com/tidb/stable/configure-placement-rules.
+type LabelConstraint struct {
+	Key    string   `json:"key"`
+	Op     string   `json:"op"`
+	Values []string `json:"values"`
+}
+
+// PlacementRule places the replicas of the regions in the key range, whose
+// keys are the hex of the encoded keys.
+type PlacementRule struct {
+	GroupID          string            `json:"group_id"`
+	ID               string            `json:"id"`
+	Index            int               `json:"index"`
+	Override         bool              `json:"override"`
+	StartKeyHex      string            `json:"start_key"`
+	EndKeyHex        string            `json:"end_key"`
+	Role             string            `json:"role"`
+	Count            int               `json:"count"`
+	LabelConstraints []LabelConstraint `json:"label_constraints"`
+}
+
+// ReplicateConfig is the replication config of PD.
+type ReplicateConfig struct {
+	MaxReplicas int `json:"max-replicas"`
+	// EnablePlacementRules is a string or a bool depending on the version of
+	// PD.
+	EnablePlacementRules interface{} `json:"enable-placement-rules"`
+}
+
+// PlacementRulesEnabled returns whether the placement rules are enabled.
+func (c *ReplicateConfig) PlacementRulesEnabled() bool {
+	return fmt.Sprint(c.EnablePlacementRules) == "true"
+}
+
+// GetReplicateConfig obtains the replication config from the PD server given
+// by the HTTP client `tls`.
+func GetReplicateConfig(tls *common.TLS) (*ReplicateConfig, error) {
+	var cfg ReplicateConfig
+	if err := tls.GetJSON("/pd/api/v1/config/replicate", &cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &cfg, nil
+}
+
+// GetPlacementRules lists the placement rules of the group.
+func GetPlacementRules(tls *common.TLS, group string) ([]*PlacementRule, error) {
+	var rules []*PlacementRule
+	if err := tls.GetJSON("/pd/api/v1/config/rules/group/"+group, &rules); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return rules, nil
+}
+
+// SetPlacementRule adds or replaces the placement rule of the same group and
+// ID.
+func SetPlacementRule(tls *common.TLS, rule *PlacementRule) error {
+	return errors.Trace(tls.SendJSON(http.MethodPost, "/pd/api/v1/config/rule", rule))
+}
+
+// DeletePlacementRule deletes the placement rule. Deleting a missing rule is
+// not an error.
+func DeletePlacementRule(tls *common.TLS, group string, id string) error {
+	return errors.Trace(tls.SendJSON(http.MethodDelete, fmt.Sprintf("/pd/api/v1/config/rule/%s/%s", group, id), nil))
+}
@@ -176,6 +176,9 @@ type SessionOptions struct {
 	SQLMode          mysql.SQLMode
 	Timestamp        int64
 	RowFormatVersion string
+	// GroupRowsByKey makes the TiDB backend encode the primary key of each
+	// row, for grouping the rows by locality when writing.
+	GroupRowsByKey bool
 }
 
 func newSession(options *SessionOptions) *session {
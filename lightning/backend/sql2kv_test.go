@@ -210,6 +210,34 @@ func (s *kvSuite) TestEncodeTimestamp(c *C) {
 	}))
 }
 
+func (s *kvSuite) TestEncodeLegacyNumericLiterals(c *C) {
+	p := parser.New()
+	se := mock.NewContext()
+	node, err := p.ParseOneStmt("create table t (a varbinary(4), b bit(4), c decimal(10, 3), d double, e bigint)", "", "")
+	c.Assert(err, IsNil)
+	tblInfo, err := ddl.MockTableInfo(se, node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	tblInfo.State = model.StatePublic
+	tbl, err := tables.TableFromMeta(NewPanickingAllocators(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	logger := log.Logger{Logger: zap.NewNop()}
+	encoder := NewTableKVEncoder(tbl, &SessionOptions{
+		SQLMode:          mysql.ModeStrictAllTables,
+		RowFormatVersion: "1",
+	})
+	// the hex, bit and scientific literals produced by the SQL parser are
+	// accepted by the typed columns in the strict mode.
+	_, err = encoder.Encode(logger, []types.Datum{
+		types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0xab, 0xcd})),
+		types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0x0a})),
+		types.NewStringDatum("-2E-3"),
+		types.NewStringDatum("1.5e3"),
+		types.NewStringDatum("1e3"),
+	}, 1, []int{0, 1, 2, 3, 4, -1})
+	c.Assert(err, IsNil)
+}
+
 func (s *kvSuite) TestSplitIntoChunks(c *C) {
 	pairs := []common.KvPair{
 		{
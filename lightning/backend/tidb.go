@@ -14,19 +14,27 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	gmysql "github.com/go-sql-driver/mysql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/errno"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/codec"
 	uuid "github.com/satori/go.uuid"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -37,14 +45,29 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/verification"
 )
 
-type tidbRow string
+const (
+	// writeConflictMaxRetry is the number of retries of a single row meeting
+	// write conflicts, before leaving the error to the caller.
+	writeConflictMaxRetry = 5
+	// writeConflictBackoffBase and writeConflictBackoffMax bound the delay
+	// before retrying a range of rows meeting write conflicts.
+	writeConflictBackoffBase = 20 * time.Millisecond
+	writeConflictBackoffMax  = 2 * time.Second
+)
+
+type tidbRow struct {
+	values string
+	// key is the memcomparable encoding of the primary key, used to group the
+	// rows by locality. nil if the rows are not grouped.
+	key []byte
+}
 
 type tidbRows []tidbRow
 
 // MarshalLogArray implements the zapcore.ArrayMarshaler interface
 func (row tidbRows) MarshalLogArray(encoder zapcore.ArrayEncoder) error {
 	for _, r := range row {
-		encoder.AppendString(string(r))
+		encoder.AppendString(r.values)
 	}
 	return nil
 }
@@ -53,11 +76,58 @@ type tidbEncoder struct {
 	mode mysql.SQLMode
 	tbl  table.Table
 	se   *session
+
+	// groupByKey enables encoding the primary key of the rows.
+	groupByKey bool
+	// positions of the primary key columns in the encoded rows, initialized
+	// on the first row. nil if the table has no primary key or the key is
+	// not fully present.
+	keyFields      []int
+	keyInitialized bool
 }
 
 type tidbBackend struct {
 	db          *sql.DB
 	onDuplicate string
+
+	// versionColumn, if not empty, resolves rows with duplicated keys by
+	// keeping the one with the larger version. The tables without this column
+	// are written with the onDuplicate action.
+	versionColumn string
+	versionStats  versionConflictStats
+	// unversionedTables are the tables known to lack the version column.
+	unversionedTables sync.Map
+
+	// tableSQLModes maps the table names to the sql_mode of the sessions
+	// writing their rows, overriding the sql_mode of the connection pool.
+	tableSQLModes map[string]string
+}
+
+// versionConflictStats counts the rows written with the version column per
+// table, for reporting the resolved conflicts.
+type versionConflictStats struct {
+	mu     sync.Mutex
+	tables map[string]*versionConflictCount
+}
+
+type versionConflictCount struct {
+	rows     int64
+	affected int64
+}
+
+func (s *versionConflictStats) add(tableName string, rows, affected int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tables == nil {
+		s.tables = make(map[string]*versionConflictCount)
+	}
+	count, ok := s.tables[tableName]
+	if !ok {
+		count = &versionConflictCount{}
+		s.tables[tableName] = count
+	}
+	count.rows += rows
+	count.affected += affected
 }
 
 // NewTiDBBackend creates a new TiDB backend using the given database.
@@ -74,29 +144,71 @@ func NewTiDBBackend(db *sql.DB, onDuplicate string) Backend {
 	return MakeBackend(&tidbBackend{db: db, onDuplicate: onDuplicate})
 }
 
+// NewTiDBBackendWithVersionColumn creates a new TiDB backend which resolves
+// rows with duplicated keys by keeping the row with the larger value in the
+// version column, instead of overwriting with the last written row. The rows
+// of the tables without the version column are written with `onDuplicate`.
+//
+// The backend does not take ownership of `db`.
+func NewTiDBBackendWithVersionColumn(db *sql.DB, onDuplicate string, versionColumn string) Backend {
+	backend := NewTiDBBackend(db, onDuplicate)
+	backend.abstract.(*tidbBackend).versionColumn = versionColumn
+	return backend
+}
+
+// SetTiDBBackendTableSQLModes makes the TiDB backend write the rows of the
+// tables with the given sql_mode, where the keys are the lower-cased table
+// names in the form "`db`.`tbl`". It does nothing for other backends.
+func SetTiDBBackendTableSQLModes(backend Backend, modes map[string]string) {
+	if be, ok := backend.abstract.(*tidbBackend); ok {
+		be.tableSQLModes = modes
+	}
+}
+
 func (row tidbRow) ClassifyAndAppend(data *Rows, checksum *verification.KVChecksum, _ *Rows, _ *verification.KVChecksum) {
 	rows := (*data).(tidbRows)
 	*data = tidbRows(append(rows, row))
-	cs := verification.MakeKVChecksum(uint64(len(row)), 1, 0)
+	cs := verification.MakeKVChecksum(uint64(len(row.values)), 1, 0)
 	checksum.Add(&cs)
 }
 
+// SplitIntoChunks implements Rows. If the rows carry the primary keys, they
+// are sorted by the keys first, so each chunk covers a narrow key range and
+// touches as few regions as possible. The sort is stable so rows with the same
+// key are still written in the original order. The rows are not sorted if any
+// of them lacks the key.
 func (rows tidbRows) SplitIntoChunks(splitSize int) []Rows {
 	if len(rows) == 0 {
 		return nil
 	}
 
+	keyed := true
+	for _, row := range rows {
+		if row.key == nil {
+			keyed = false
+			break
+		}
+	}
+	if keyed {
+		sorted := make(tidbRows, len(rows))
+		copy(sorted, rows)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return bytes.Compare(sorted[i].key, sorted[j].key) < 0
+		})
+		rows = sorted
+	}
+
 	res := make([]Rows, 0, 1)
 	i := 0
 	cumSize := 0
 
 	for j, row := range rows {
-		if i < j && cumSize+len(row) > splitSize {
+		if i < j && cumSize+len(row.values) > splitSize {
 			res = append(res, rows[i:j])
 			i = j
 			cumSize = 0
 		}
-		cumSize += len(row)
+		cumSize += len(row.values)
 	}
 
 	return append(res, rows[i:])
@@ -236,6 +348,12 @@ func (enc *tidbEncoder) Encode(logger log.Logger, row []types.Datum, _ int64, co
 		if i != 0 {
 			encoded.WriteByte(',')
 		}
+		// the value missing from the data file is filled by the column
+		// default.
+		if columnPermutation[i] < 0 {
+			encoded.WriteString("DEFAULT")
+			continue
+		}
 		if err := enc.appendSQL(&encoded, &field, cols[columnPermutation[i]]); err != nil {
 			logger.Error("tidb encode failed",
 				zap.Array("original", rowArrayMarshaler(row)),
@@ -246,12 +364,120 @@ func (enc *tidbEncoder) Encode(logger log.Logger, row []types.Datum, _ int64, co
 		}
 	}
 	encoded.WriteByte(')')
-	return tidbRow(encoded.String()), nil
+
+	res := tidbRow{values: encoded.String()}
+	if enc.groupByKey {
+		key, err := enc.encodeKey(row, columnPermutation)
+		if err != nil {
+			logger.Error("tidb encode primary key failed",
+				zap.Array("original", rowArrayMarshaler(row)),
+				log.ShortError(err),
+			)
+			return nil, err
+		}
+		res.key = key
+	}
+	return res, nil
+}
+
+// encodeKey returns the memcomparable encoding of the primary key of the row,
+// after casting the values into the column types. Returns nil if the table has
+// no primary key, so the rows are kept in order.
+func (enc *tidbEncoder) encodeKey(row []types.Datum, columnPermutation []int) ([]byte, error) {
+	if !enc.keyInitialized {
+		enc.keyFields = primaryKeyFields(enc.tbl.Meta(), columnPermutation)
+		enc.keyInitialized = true
+	}
+	if enc.keyFields == nil {
+		return nil, nil
+	}
+
+	cols := enc.tbl.Cols()
+	values := make([]types.Datum, 0, len(enc.keyFields))
+	for _, i := range enc.keyFields {
+		// the default of a key column is unknown until inserted, so the rows
+		// are kept in order.
+		if columnPermutation[i] < 0 {
+			return nil, nil
+		}
+		value, err := table.CastValue(enc.se, row[i], cols[columnPermutation[i]].ToInfo(), false, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		values = append(values, value)
+	}
+	key, err := codec.EncodeKey(enc.se.vars.StmtCtx, nil, values...)
+	return key, errors.Trace(err)
+}
+
+// primaryKeyFields returns the positions of the primary key columns in the
+// encoded rows, or nil if the key is not fully present.
+func primaryKeyFields(tblInfo *model.TableInfo, columnPermutation []int) []int {
+	var keyOffsets []int
+	if tblInfo.PKIsHandle {
+		for _, col := range tblInfo.Columns {
+			if mysql.HasPriKeyFlag(col.Flag) {
+				keyOffsets = append(keyOffsets, col.Offset)
+			}
+		}
+	} else {
+		for _, index := range tblInfo.Indices {
+			if index.Primary {
+				for _, col := range index.Columns {
+					keyOffsets = append(keyOffsets, col.Offset)
+				}
+				break
+			}
+		}
+	}
+	if len(keyOffsets) == 0 {
+		return nil
+	}
+
+	fields := make([]int, 0, len(keyOffsets))
+	for _, offset := range keyOffsets {
+		field := -1
+		for i, colOffset := range columnPermutation {
+			if colOffset == offset {
+				field = i
+				break
+			}
+		}
+		if field < 0 {
+			return nil
+		}
+		fields = append(fields, field)
+	}
+	return fields
 }
 
 func (be *tidbBackend) Close() {
 	// *Not* going to close `be.db`. The db object is normally borrowed from a
 	// TidbManager, so we let the manager to close it.
+	be.versionStats.emitLog(be.versionColumn)
+}
+
+// emitLog reports the conflicts resolved by the version column. An inserted
+// row counts as 1 affected row, a replaced row as 2 and a discarded older row
+// as 0, so differences between the two numbers indicate resolved conflicts.
+func (s *versionConflictStats) emitLog(versionColumn string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for tableName, count := range s.tables {
+		if count.rows == count.affected {
+			continue
+		}
+		log.L().Info("resolved duplicated rows by version column",
+			zap.String("table", tableName),
+			zap.String("versionColumn", versionColumn),
+			zap.Int64("rows", count.rows),
+			zap.Int64("affectedRows", count.affected),
+		)
+		common.RecordWarning(tableName, common.WarningVersionConflict, fmt.Sprintf(
+			"rows with duplicated keys were resolved by version column `%s` (%d rows written, %d rows affected)",
+			versionColumn, count.rows, count.affected,
+		))
+	}
 }
 
 func (be *tidbBackend) MakeEmptyRows() Rows {
@@ -284,9 +510,11 @@ func (be *tidbBackend) NewEncoder(tbl table.Table, options *SessionOptions) Enco
 		se = newSession(options)
 		se.vars.SkipUTF8Check = false
 		se.vars.SkipASCIICheck = false
+	} else if options.GroupRowsByKey {
+		se = newSession(options)
 	}
 
-	return &tidbEncoder{mode: options.SQLMode, tbl: tbl, se: se}
+	return &tidbEncoder{mode: options.SQLMode, tbl: tbl, se: se, groupByKey: options.GroupRowsByKey}
 }
 
 func (be *tidbBackend) OpenEngine(context.Context, uuid.UUID) error {
@@ -311,13 +539,110 @@ func (be *tidbBackend) WriteRows(ctx context.Context, _ uuid.UUID, tableName str
 		return nil
 	}
 
+	// Retry on errors other than write conflicts will be done externally, so
+	// we're not going to retry them here.
+	err := be.writeRows(ctx, tableName, columnNames, rows, 0)
+	failpoint.Inject("FailIfImportedSomeRows", func() {
+		panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
+	})
+	return errors.Trace(err)
+}
+
+// writeRows writes the rows in a single statement. If the statement meets
+// write conflicts with the concurrent transactions, e.g. against an online
+// cluster, the rows are split into halves after backing off, and each half
+// is retried with its own backoff. Since the rows grouped by key are sorted,
+// the conflicting key ranges are narrowed down, instead of retrying the full
+// batch repeatedly.
+func (be *tidbBackend) writeRows(ctx context.Context, tableName string, columnNames []string, rows tidbRows, backoff time.Duration) error {
+	for retry := 0; ; retry++ {
+		stmt, versioned := be.buildInsertStmt(tableName, columnNames, rows)
+		res, err := be.execInsert(ctx, tableName, stmt)
+		if err == nil {
+			if versioned {
+				if affected, e := res.RowsAffected(); e == nil {
+					be.versionStats.add(tableName, int64(len(rows)), affected)
+				}
+			}
+			return nil
+		}
+		if !isWriteConflictError(err) || retry >= writeConflictMaxRetry {
+			log.L().Error("execute statement failed",
+				zap.Array("rows", rows), zap.String("stmt", stmt), zap.Error(err))
+			return err
+		}
+
+		backoff *= 2
+		if backoff < writeConflictBackoffBase {
+			backoff = writeConflictBackoffBase
+		} else if backoff > writeConflictBackoffMax {
+			backoff = writeConflictBackoffMax
+		}
+		log.L().Warn("write conflict, going to retry",
+			zap.String("table", tableName),
+			zap.Int("rows", len(rows)),
+			zap.Int("retryCnt", retry),
+			zap.Duration("backoff", backoff),
+			log.ShortError(err),
+		)
+		// sleep for a random duration in [backoff/2, backoff), so the retries
+		// of the concurrent writers are spread out.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))):
+		}
+
+		if len(rows) > 1 {
+			mid := len(rows) / 2
+			if err := be.writeRows(ctx, tableName, columnNames, rows[:mid], backoff); err != nil {
+				return err
+			}
+			return be.writeRows(ctx, tableName, columnNames, rows[mid:], backoff)
+		}
+	}
+}
+
+// execInsert executes the INSERT statement of the table, in a session of the
+// table's own sql_mode if it is overridden.
+func (be *tidbBackend) execInsert(ctx context.Context, tableName string, stmt string) (sql.Result, error) {
+	sqlMode, ok := be.tableSQLModes[strings.ToLower(tableName)]
+	if !ok {
+		return be.db.ExecContext(ctx, stmt)
+	}
+
+	conn, err := be.db.Conn(ctx)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET @lightning_saved_sql_mode = @@SESSION.sql_mode, SESSION sql_mode = ?", sqlMode); err != nil {
+		return nil, errors.Trace(err)
+	}
+	res, err := conn.ExecContext(ctx, stmt)
+	// the connection goes back to the pool, restore the sql_mode for the
+	// other tables. the rows are already written, so a failure here does not
+	// fail the write.
+	if _, e := conn.ExecContext(context.Background(), "SET SESSION sql_mode = @lightning_saved_sql_mode"); e != nil {
+		log.L().Warn("failed to restore the sql_mode of the connection", zap.String("table", tableName), log.ShortError(e))
+	}
+	return res, err
+}
+
+// buildInsertStmt builds the INSERT statement of the rows, and returns
+// whether the duplicated rows are resolved by the version column.
+func (be *tidbBackend) buildInsertStmt(tableName string, columnNames []string, rows tidbRows) (string, bool) {
+	versioned := be.hasVersionColumn(tableName, columnNames)
+
 	var insertStmt strings.Builder
-	switch be.onDuplicate {
-	case config.ReplaceOnDup:
+	switch {
+	case versioned:
+		insertStmt.WriteString("INSERT INTO ")
+	case be.onDuplicate == config.ReplaceOnDup:
 		insertStmt.WriteString("REPLACE INTO ")
-	case config.IgnoreOnDup:
+	case be.onDuplicate == config.IgnoreOnDup:
 		insertStmt.WriteString("INSERT IGNORE INTO ")
-	case config.ErrorOnDup:
+	case be.onDuplicate == config.ErrorOnDup:
 		insertStmt.WriteString("INSERT INTO ")
 	}
 
@@ -341,19 +666,88 @@ func (be *tidbBackend) WriteRows(ctx context.Context, _ uuid.UUID, tableName str
 		if i != 0 {
 			insertStmt.WriteByte(',')
 		}
-		insertStmt.WriteString(string(row))
+		insertStmt.WriteString(row.values)
 	}
 
-	// Retry will be done externally, so we're not going to retry here.
-	_, err := be.db.ExecContext(ctx, insertStmt.String())
-	if err != nil {
-		log.L().Error("execute statement failed",
-			zap.Array("rows", rows), zap.String("stmt", insertStmt.String()), zap.Error(err))
+	if versioned {
+		writeVersionedUpdate(&insertStmt, columnNames, be.versionColumn)
 	}
-	failpoint.Inject("FailIfImportedSomeRows", func() {
-		panic("forcing failure due to FailIfImportedSomeRows, before saving checkpoint")
-	})
-	return errors.Trace(err)
+	return insertStmt.String(), versioned
+}
+
+// hasVersionColumn returns whether the version column is among the written
+// columns. The tables without it are reported once.
+func (be *tidbBackend) hasVersionColumn(tableName string, columnNames []string) bool {
+	if len(be.versionColumn) == 0 {
+		return false
+	}
+	for _, colName := range columnNames {
+		if strings.EqualFold(colName, be.versionColumn) {
+			return true
+		}
+	}
+	if _, loaded := be.unversionedTables.LoadOrStore(tableName, struct{}{}); !loaded {
+		log.L().Warn("version column not found in the table, the duplicated rows are resolved by on-duplicate",
+			zap.String("table", tableName),
+			zap.String("versionColumn", be.versionColumn),
+			zap.String("onDuplicate", be.onDuplicate),
+		)
+	}
+	return false
+}
+
+// isWriteConflictError returns whether the statement failed due to conflicting
+// with the concurrent transactions. The failed statement is rolled back, so
+// it is safe to retry regardless of the action on duplicate.
+func isWriteConflictError(err error) bool {
+	merr, ok := errors.Cause(err).(*gmysql.MySQLError)
+	if !ok {
+		return false
+	}
+	switch merr.Number {
+	case errno.ErrWriteConflict, errno.ErrWriteConflictInTiDB, errno.ErrLockDeadlock:
+		return true
+	default:
+		return false
+	}
+}
+
+// writeVersionedUpdate appends the ON DUPLICATE KEY UPDATE clause which only
+// overwrites the existing row when the new row has a larger version, e.g.
+//
+//	ON DUPLICATE KEY UPDATE `a`=IF(IFNULL(VALUES(`v`)>`v`,`v` IS NULL AND VALUES(`v`) IS NOT NULL),VALUES(`a`),`a`),...
+//
+// A NULL version is older than any other version, so a row with a NULL
+// version never overwrites a versioned row. The assignments are evaluated from
+// left to right, so the version column must be updated the last.
+func writeVersionedUpdate(sb *strings.Builder, columnNames []string, versionColumn string) {
+	var quotedVersion strings.Builder
+	common.WriteMySQLIdentifier(&quotedVersion, versionColumn)
+	version := quotedVersion.String()
+	newer := "IFNULL(VALUES(" + version + ")>" + version + "," + version + " IS NULL AND VALUES(" + version + ") IS NOT NULL)"
+
+	writeAssignment := func(col string) {
+		sb.WriteString(col)
+		sb.WriteString("=IF(")
+		sb.WriteString(newer)
+		sb.WriteString(",VALUES(")
+		sb.WriteString(col)
+		sb.WriteString("),")
+		sb.WriteString(col)
+		sb.WriteString(")")
+	}
+
+	sb.WriteString(" ON DUPLICATE KEY UPDATE ")
+	for _, colName := range columnNames {
+		if strings.EqualFold(colName, versionColumn) {
+			continue
+		}
+		var quotedCol strings.Builder
+		common.WriteMySQLIdentifier(&quotedCol, colName)
+		writeAssignment(quotedCol.String())
+		sb.WriteByte(',')
+	}
+	writeAssignment(version)
 }
 
 func (be *tidbBackend) FetchRemoteTableModels(schemaName string) (tables []*model.TableInfo, err error) {
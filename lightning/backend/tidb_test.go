@@ -21,9 +21,11 @@ import (
 	"github.com/pingcap/parser/charset"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	gmysql "github.com/go-sql-driver/mysql"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/errno"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
 	"github.com/pingcap/tidb/types"
@@ -141,6 +143,36 @@ func (s *mysqlSuite) TestWriteRowsIgnoreOnDup(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *mysqlSuite) TestWriteRowsMissingValues(c *C) {
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`,`b`) VALUES(1,DEFAULT)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	bk := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	engine, err := bk.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := bk.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := bk.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	// the value of the dropped column is filled by the column default.
+	encoder := bk.NewEncoder(s.tbl, &kv.SessionOptions{})
+	row, err := encoder.Encode(logger, []types.Datum{
+		types.NewIntDatum(1),
+		{},
+	}, 1, []int{0, -1})
+	c.Assert(err, IsNil)
+	row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+
+	err = engine.WriteRows(ctx, []string{"a", "b"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
 func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	s.mockDB.
 		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1)\\E").
@@ -170,6 +202,203 @@ func (s *mysqlSuite) TestWriteRowsErrorOnDup(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *mysqlSuite) TestWriteRowsTableSQLMode(c *C) {
+	s.mockDB.
+		ExpectExec("\\QSET @lightning_saved_sql_mode = @@SESSION.sql_mode, SESSION sql_mode = ?\\E").
+		WithArgs("ALLOW_INVALID_DATES").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`Bar`(`a`) VALUES(1)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("\\QSET SESSION sql_mode = @lightning_saved_sql_mode\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	// other tables are written with the sql_mode of the pool.
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`baz`(`a`) VALUES(1)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	backend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	kv.SetTiDBBackendTableSQLModes(backend, map[string]string{"`foo`.`bar`": "ALLOW_INVALID_DATES"})
+	for _, tableName := range []string{"`foo`.`Bar`", "`foo`.`baz`"} {
+		engine, err := backend.OpenEngine(ctx, tableName, 1)
+		c.Assert(err, IsNil)
+
+		dataRows := backend.MakeEmptyRows()
+		dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+		indexRows := backend.MakeEmptyRows()
+		indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+		encoder := backend.NewEncoder(s.tbl, &kv.SessionOptions{})
+		row, err := encoder.Encode(logger, []types.Datum{
+			types.NewIntDatum(1),
+		}, 1, []int{0})
+		c.Assert(err, IsNil)
+		row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+
+		err = engine.WriteRows(ctx, []string{"a"}, dataRows)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *mysqlSuite) TestWriteRowsSplitOnWriteConflict(c *C) {
+	conflictErr := &gmysql.MySQLError{Number: errno.ErrWriteConflict, Message: "Write conflict"}
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1),(2),(3),(4)\\E").
+		WillReturnError(conflictErr)
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1),(2)\\E").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(3),(4)\\E").
+		WillReturnError(conflictErr)
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(3)\\E").
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(4)\\E").
+		WillReturnError(conflictErr)
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(4)\\E").
+		WillReturnResult(sqlmock.NewResult(4, 1))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	backend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := backend.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	encoder := backend.NewEncoder(s.tbl, &kv.SessionOptions{})
+	for i := int64(1); i <= 4; i++ {
+		row, err := encoder.Encode(logger, []types.Datum{
+			types.NewIntDatum(i),
+		}, i, []int{0})
+		c.Assert(err, IsNil)
+		row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+	}
+
+	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsNotSplitOnOtherErrors(c *C) {
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`) VALUES(1),(2)\\E").
+		WillReturnError(&gmysql.MySQLError{Number: errno.ErrDupEntry, Message: "Duplicate entry"})
+
+	ctx := context.Background()
+	logger := log.L()
+
+	backend := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	engine, err := backend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := backend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := backend.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	encoder := backend.NewEncoder(s.tbl, &kv.SessionOptions{})
+	for i := int64(1); i <= 2; i++ {
+		row, err := encoder.Encode(logger, []types.Datum{
+			types.NewIntDatum(i),
+		}, i, []int{0})
+		c.Assert(err, IsNil)
+		row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+	}
+
+	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
+	c.Assert(err, ErrorMatches, ".*Duplicate entry.*")
+}
+
+func (s *mysqlSuite) TestWriteRowsVersionColumn(c *C) {
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`a`,`v`) VALUES(1,2) ON DUPLICATE KEY UPDATE " +
+			"`a`=IF(IFNULL(VALUES(`v`)>`v`,`v` IS NULL AND VALUES(`v`) IS NOT NULL),VALUES(`a`),`a`)," +
+			"`v`=IF(IFNULL(VALUES(`v`)>`v`,`v` IS NULL AND VALUES(`v`) IS NOT NULL),VALUES(`v`),`v`)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	// the tables without the version column are written with on-duplicate.
+	s.mockDB.
+		ExpectExec("\\QREPLACE INTO `foo`.`bar`(`a`) VALUES(1,2)\\E").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	versionBackend := kv.NewTiDBBackendWithVersionColumn(s.dbHandle, config.ReplaceOnDup, "v")
+	engine, err := versionBackend.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := versionBackend.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := versionBackend.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	encoder := versionBackend.NewEncoder(s.tbl, &kv.SessionOptions{})
+	row, err := encoder.Encode(logger, []types.Datum{
+		types.NewIntDatum(1),
+		types.NewIntDatum(2),
+	}, 1, []int{0, 1})
+	c.Assert(err, IsNil)
+	row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+
+	err = engine.WriteRows(ctx, []string{"a", "v"}, dataRows)
+	c.Assert(err, IsNil)
+
+	err = engine.WriteRows(ctx, []string{"a"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
+func (s *mysqlSuite) TestWriteRowsGroupedByKey(c *C) {
+	s.mockDB.
+		ExpectExec("\\QINSERT INTO `foo`.`bar`(`id`,`name`) VALUES('9','b'),('10','a'),('10','c'),('100','d')\\E").
+		WillReturnResult(sqlmock.NewResult(1, 4))
+
+	ctx := context.Background()
+	logger := log.L()
+
+	ft := *types.NewFieldType(mysql.TypeLong)
+	ft.Flag = mysql.PriKeyFlag | mysql.NotNullFlag
+	col0 := &model.ColumnInfo{ID: 1, Name: model.NewCIStr("id"), State: model.StatePublic, Offset: 0, FieldType: ft}
+	col1 := &model.ColumnInfo{ID: 2, Name: model.NewCIStr("name"), State: model.StatePublic, Offset: 1, FieldType: *types.NewFieldType(mysql.TypeVarchar)}
+	tblInfo := &model.TableInfo{ID: 1, Columns: []*model.ColumnInfo{col0, col1}, PKIsHandle: true, State: model.StatePublic}
+	tbl, err := tables.TableFromMeta(kv.NewPanickingAllocators(0), tblInfo)
+	c.Assert(err, IsNil)
+
+	bk := kv.NewTiDBBackend(s.dbHandle, config.ErrorOnDup)
+	engine, err := bk.OpenEngine(ctx, "`foo`.`bar`", 1)
+	c.Assert(err, IsNil)
+
+	dataRows := bk.MakeEmptyRows()
+	dataChecksum := verification.MakeKVChecksum(0, 0, 0)
+	indexRows := bk.MakeEmptyRows()
+	indexChecksum := verification.MakeKVChecksum(0, 0, 0)
+
+	// the rows are sorted by the numeric value of the key, and rows of the
+	// same key keep the original order.
+	encoder := bk.NewEncoder(tbl, &kv.SessionOptions{GroupRowsByKey: true})
+	for _, values := range [][2]string{{"10", "a"}, {"9", "b"}, {"10", "c"}, {"100", "d"}} {
+		row, err := encoder.Encode(logger, []types.Datum{
+			types.NewStringDatum(values[0]),
+			types.NewStringDatum(values[1]),
+		}, 1, []int{0, 1})
+		c.Assert(err, IsNil)
+		row.ClassifyAndAppend(&dataRows, &dataChecksum, &indexRows, &indexChecksum)
+	}
+
+	err = engine.WriteRows(ctx, []string{"id", "name"}, dataRows)
+	c.Assert(err, IsNil)
+}
+
 func (s *mysqlSuite) TestStrictMode(c *C) {
 	ft := *types.NewFieldType(mysql.TypeVarchar)
 	ft.Charset = charset.CharsetUTF8MB4
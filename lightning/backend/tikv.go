@@ -71,11 +71,35 @@ func (ss *StoreState) UnmarshalJSON(content []byte) error {
 	return errors.New("Unknown store state")
 }
 
+// StoreLabel is a label of a TiKV store.
+type StoreLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
 // Store contains metadata about a TiKV store.
 type Store struct {
 	Address string
 	Version string
-	State   StoreState `json:"state_name"`
+	State   StoreState   `json:"state_name"`
+	Labels  []StoreLabel `json:"labels"`
+}
+
+// HasLabels returns whether the store has all the labels.
+func (s *Store) HasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		found := false
+		for _, label := range s.Labels {
+			if label.Key == key && label.Value == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 func withTiKVConnection(ctx context.Context, tls *common.TLS, tikvAddr string, action func(import_sstpb.ImportSSTClient) error) error {
@@ -162,6 +186,26 @@ func Compact(ctx context.Context, tls *common.TLS, tikvAddr string, level int32)
 	return err
 }
 
+// CompactRange performs a leveled compaction of the key range with the given
+// minimum level. The keys of the range should be in the encoded form.
+func CompactRange(ctx context.Context, tls *common.TLS, tikvAddr string, keyRange *import_sstpb.Range, level int32) error {
+	task := log.With(
+		zap.Int32("level", level),
+		zap.String("tikv", tikvAddr),
+		zap.Binary("startKey", keyRange.Start),
+		zap.Binary("endKey", keyRange.End),
+	).Begin(zap.InfoLevel, "compact range")
+	err := withTiKVConnection(ctx, tls, tikvAddr, func(client import_sstpb.ImportSSTClient) error {
+		_, err := client.Compact(ctx, &import_sstpb.CompactRequest{
+			Range:       keyRange,
+			OutputLevel: level,
+		})
+		return ignoreUnimplementedError(err, task.Logger)
+	})
+	task.End(zap.ErrorLevel, err)
+	return err
+}
+
 var fetchModeRegexp = regexp.MustCompile(`\btikv_config_rocksdb\{cf="default",name="hard_pending_compaction_bytes_limit"\} ([^\n]+)`)
 
 // FetchMode obtains the import mode status of the TiKV node.
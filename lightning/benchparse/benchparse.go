@@ -0,0 +1,247 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package benchparse implements `tidb-lightning bench-parse`, which measures
+// the parse throughput of a data file with the settings of a task config, and
+// optionally validates the parsed rows against a fixture.
+package benchparse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// Result is the measurement of parsing a file.
+type Result struct {
+	Rows int64
+	// Bytes is the size of the file on disk, which is compressed if the file
+	// is compressed.
+	Bytes   int64
+	Elapsed time.Duration
+	// Mallocs and AllocBytes are the heap allocations made during parsing.
+	Mallocs    uint64
+	AllocBytes uint64
+}
+
+// RowsPerSecond returns the parse throughput in rows.
+func (r *Result) RowsPerSecond() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Rows) / r.Elapsed.Seconds()
+}
+
+// Report writes the measurement in a human readable form.
+func (r *Result) Report(out io.Writer) {
+	fmt.Fprintf(out, "rows:         %d\n", r.Rows)
+	fmt.Fprintf(out, "bytes:        %d\n", r.Bytes)
+	fmt.Fprintf(out, "elapsed:      %s\n", r.Elapsed)
+	fmt.Fprintf(out, "rows/s:       %.0f\n", r.RowsPerSecond())
+	if r.Elapsed > 0 {
+		fmt.Fprintf(out, "MiB/s:        %.2f\n", float64(r.Bytes)/r.Elapsed.Seconds()/(1<<20))
+	}
+	if r.Rows > 0 {
+		fmt.Fprintf(out, "allocs/row:   %.1f\n", float64(r.Mallocs)/float64(r.Rows))
+		fmt.Fprintf(out, "bytes/row:    %.1f\n", float64(r.AllocBytes)/float64(r.Rows))
+	}
+}
+
+// Run parses the whole file with the parser chosen by the file routing rules
+// of the config. If expectedPath is not empty, the rows are compared with the
+// fixture there, which is a JSON array of the rows, each being an array of
+// strings or nulls.
+func Run(ctx context.Context, cfg *config.Config, path string, expectedPath string) (*Result, error) {
+	var expected [][]*string
+	if expectedPath != "" {
+		data, err := ioutil.ReadFile(expectedPath)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err := json.Unmarshal(data, &expected); err != nil {
+			return nil, errors.Annotatef(err, "invalid expected rows fixture %s", expectedPath)
+		}
+	}
+
+	dir, name := filepath.Split(path)
+	store, err := storage.NewLocalStorage(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	res, err := route(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ioWorkers := worker.NewPool(ctx, cfg.App.IOConcurrency, "io")
+	reader, err := mydump.OpenSourceFile(ctx, store, name, &cfg.Mydumper)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = reader.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, errors.Trace(err)
+	}
+	if res.Compression != mydump.CompressionNone {
+		decompressed, err := mydump.NewDecompressReader(reader, res.Compression)
+		if err != nil {
+			reader.Close()
+			return nil, errors.Annotatef(err, "open %s", path)
+		}
+		reader = decompressed
+	}
+
+	parser, err := newParser(ctx, cfg, res.Type, store, name, reader, ioWorkers)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	defer parser.Close()
+
+	result := &Result{Bytes: size}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for {
+		err := parser.ReadRow()
+		if errors.Cause(err) == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "parse %s at row %d", path, result.Rows+1)
+		}
+		row := parser.LastRow()
+		if expected != nil {
+			if err := compareRow(result.Rows, row.Row, expected); err != nil {
+				return nil, err
+			}
+		}
+		result.Rows++
+		parser.RecycleRow(row)
+	}
+	result.Elapsed = time.Since(start)
+	runtime.ReadMemStats(&after)
+	result.Mallocs = after.Mallocs - before.Mallocs
+	result.AllocBytes = after.TotalAlloc - before.TotalAlloc
+
+	if expected != nil && int64(len(expected)) != result.Rows {
+		return nil, errors.Errorf("expected %d rows but parsed %d rows", len(expected), result.Rows)
+	}
+	return result, nil
+}
+
+func route(cfg *config.Config, name string) (*mydump.RouteResult, error) {
+	rules := append([]*config.FileRouteRule(nil), cfg.Mydumper.FileRouters...)
+	if cfg.Mydumper.DefaultFileRules || len(rules) == 0 {
+		rules = append(rules, mydump.DefaultFileRouteRules()...)
+	}
+	router, err := mydump.NewFileRouter(rules)
+	if err != nil {
+		return nil, err
+	}
+	res, err := router.Route(name)
+	if err != nil {
+		return nil, err
+	}
+	if res == nil {
+		return nil, errors.Errorf("cannot detect the format of %s, which matches no file routing rule", name)
+	}
+	return res, nil
+}
+
+func newParser(
+	ctx context.Context,
+	cfg *config.Config,
+	sourceType mydump.SourceType,
+	store storage.ExternalStorage,
+	name string,
+	reader mydump.ReadSeekCloser,
+	ioWorkers *worker.Pool,
+) (mydump.Parser, error) {
+	blockBufSize := cfg.Mydumper.ReadBlockSize
+	switch sourceType {
+	case mydump.SourceTypeCSV:
+		return mydump.NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers, cfg.Mydumper.CSV.Header), nil
+	case mydump.SourceTypeSQL:
+		// the config is not adjusted, so the SQL mode is parsed here.
+		sqlMode, err := mysql.GetSQLMode(cfg.TiDB.StrSQLMode)
+		if err != nil {
+			return nil, errors.Annotate(err, "invalid config: `tidb.sql-mode` must be a valid SQL_MODE")
+		}
+		return mydump.NewChunkParser(sqlMode, reader, blockBufSize, ioWorkers), nil
+	case mydump.SourceTypeFixedWidth:
+		return mydump.NewFixedWidthParser(&cfg.Mydumper.FixedWidth, reader, blockBufSize, ioWorkers), nil
+	case mydump.SourceTypeMsgpack:
+		return mydump.NewMsgpackParser(reader, blockBufSize, ioWorkers), nil
+	case mydump.SourceTypeParquet:
+		return mydump.NewParquetParser(ctx, store, reader, name)
+	case mydump.SourceTypeAvro:
+		return mydump.NewAvroParser(reader)
+	case mydump.SourceTypeORC:
+		return mydump.NewORCParser(reader)
+	case mydump.SourceTypeArrow:
+		return mydump.NewArrowParser(reader)
+	default:
+		// the other formats need the columns of the target table or a sheet
+		// name to parse.
+		return nil, errors.Errorf("bench-parse does not support the %s files", sourceType)
+	}
+}
+
+func compareRow(index int64, row []types.Datum, expected [][]*string) error {
+	if index >= int64(len(expected)) {
+		return errors.Errorf("expected %d rows but parsed more", len(expected))
+	}
+	want := expected[index]
+	if len(row) != len(want) {
+		return errors.Errorf("row %d: expected %d columns but parsed %d columns", index+1, len(want), len(row))
+	}
+	for i := range row {
+		if row[i].IsNull() {
+			if want[i] != nil {
+				return errors.Errorf("row %d column %d: expected %q but parsed NULL", index+1, i+1, *want[i])
+			}
+			continue
+		}
+		str, err := row[i].ToString()
+		if err != nil {
+			return errors.Annotatef(err, "row %d column %d", index+1, i+1)
+		}
+		if want[i] == nil {
+			return errors.Errorf("row %d column %d: expected NULL but parsed %q", index+1, i+1, str)
+		}
+		if str != *want[i] {
+			return errors.Errorf("row %d column %d: expected %q but parsed %q", index+1, i+1, *want[i], str)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package benchparse
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+func TestBenchParse(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&benchParseSuite{})
+
+type benchParseSuite struct {
+	dir string
+}
+
+func (s *benchParseSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+func (s *benchParseSuite) write(c *C, name string, content string) string {
+	path := filepath.Join(s.dir, name)
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+	return path
+}
+
+func (s *benchParseSuite) TestCSV(c *C) {
+	path := s.write(c, "db.t.csv", "a,b\n1,x\n2,\\N\n3,\"z,z\"\n")
+	expected := s.write(c, "expected.json", `[["1","x"],["2",null],["3","z,z"]]`)
+
+	result, err := Run(context.Background(), config.NewConfig(), path, expected)
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, int64(3))
+	c.Assert(result.Bytes, Equals, int64(21))
+
+	var out bytes.Buffer
+	result.Report(&out)
+	c.Assert(out.String(), Matches, `(?s)rows: +3\n.*rows/s: .*allocs/row: .*`)
+
+	// the parsed rows depend on the config.
+	cfg := config.NewConfig()
+	cfg.Mydumper.CSV.Header = false
+	_, err = Run(context.Background(), cfg, path, expected)
+	c.Assert(err, ErrorMatches, `row 1 column 1: expected "1" but parsed "a"`)
+}
+
+func (s *benchParseSuite) TestSQL(c *C) {
+	path := s.write(c, "db.t.000001.sql", "INSERT INTO t VALUES (1,'x'),(2,NULL);\nINSERT INTO t VALUES (3,'y');\n")
+
+	result, err := Run(context.Background(), config.NewConfig(), path, "")
+	c.Assert(err, IsNil)
+	c.Assert(result.Rows, Equals, int64(3))
+
+	expected := s.write(c, "expected.json", `[["1","x"],["2",null]]`)
+	_, err = Run(context.Background(), config.NewConfig(), path, expected)
+	c.Assert(err, ErrorMatches, "expected 2 rows but parsed more")
+
+	expected = s.write(c, "expected.json", `[["1","x"],["2","y"],["3","y"]]`)
+	_, err = Run(context.Background(), config.NewConfig(), path, expected)
+	c.Assert(err, ErrorMatches, `row 2 column 2: expected "y" but parsed NULL`)
+}
+
+func (s *benchParseSuite) TestUnknownFormat(c *C) {
+	path := s.write(c, "notes.txt", "hello")
+	_, err := Run(context.Background(), config.NewConfig(), path, "")
+	c.Assert(err, ErrorMatches, "cannot detect the format of notes.txt.*")
+
+	path = s.write(c, "db.t.csv", "a\n1\n")
+	expected := s.write(c, "expected.json", `{"a": 1}`)
+	_, err = Run(context.Background(), config.NewConfig(), path, expected)
+	c.Assert(err, ErrorMatches, "invalid expected rows fixture .*")
+}
@@ -19,7 +19,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"os"
 	"sort"
@@ -64,12 +63,17 @@ const (
 	CheckpointTableNameTask   = "task_v1"
 	CheckpointTableNameTable  = "table_v6"
 	CheckpointTableNameEngine = "engine_v5"
-	CheckpointTableNameChunk  = "chunk_v5"
+	CheckpointTableNameChunk  = "chunk_v6"
+	// the tables whose schema has been created.
+	CheckpointTableNameSchema = "schema_v1"
+	// the source files filtered out by the file routers and the table filters.
+	CheckpointTableNameFiltered = "filtered_file_v1"
 )
 
 func IsCheckpointTable(name string) bool {
 	return name == CheckpointTableNameTask || name == CheckpointTableNameTable ||
-		name == CheckpointTableNameEngine || name == CheckpointTableNameChunk
+		name == CheckpointTableNameEngine || name == CheckpointTableNameChunk ||
+		name == CheckpointTableNameSchema || name == CheckpointTableNameFiltered
 }
 
 func (status CheckpointStatus) MetricName() string {
@@ -124,6 +128,9 @@ type ChunkCheckpoint struct {
 	Chunk             mydump.Chunk
 	Checksum          verify.KVChecksum
 	Timestamp         int64
+	// Worker is the ID of the region worker which restored the chunk last
+	// time, so the resumed chunk is restored by the same worker if it is idle.
+	Worker int64
 }
 
 func (ccp *ChunkCheckpoint) DeepCopy() *ChunkCheckpoint {
@@ -136,6 +143,7 @@ func (ccp *ChunkCheckpoint) DeepCopy() *ChunkCheckpoint {
 		Chunk:             ccp.Chunk,
 		Checksum:          ccp.Checksum,
 		Timestamp:         ccp.Timestamp,
+		Worker:            ccp.Worker,
 	}
 }
 
@@ -187,6 +195,7 @@ type chunkCheckpointDiff struct {
 	rowID             int64
 	checksum          verify.KVChecksum
 	columnPermutation []int
+	worker            int64
 }
 
 type engineCheckpointDiff struct {
@@ -260,6 +269,7 @@ func (cp *TableCheckpoint) Apply(cpd *TableCheckpointDiff) {
 			chunk.Chunk.Offset = diff.pos
 			chunk.Chunk.PrevRowIDMax = diff.rowID
 			chunk.Checksum = diff.checksum
+			chunk.Worker = diff.worker
 		}
 	}
 }
@@ -303,6 +313,7 @@ type ChunkCheckpointMerger struct {
 	Pos               int64
 	RowID             int64
 	ColumnPermutation []int
+	Worker            int64
 }
 
 func (merger *ChunkCheckpointMerger) MergeInto(cpd *TableCheckpointDiff) {
@@ -313,6 +324,7 @@ func (merger *ChunkCheckpointMerger) MergeInto(cpd *TableCheckpointDiff) {
 				rowID:             merger.RowID,
 				checksum:          merger.Checksum,
 				columnPermutation: merger.ColumnPermutation,
+				worker:            merger.Worker,
 			},
 		},
 	})
@@ -354,6 +366,18 @@ type CheckpointsDB interface {
 	// default values for the column permutations and checksums.
 	InsertEngineCheckpoints(ctx context.Context, tableName string, checkpoints map[int32]*EngineCheckpoint) error
 	Update(checkpointDiffs map[string]*TableCheckpointDiff)
+	// InsertSchemaCheckpoints records the tables whose schema has been created,
+	// so they are skipped when the schema phase is resumed.
+	InsertSchemaCheckpoints(ctx context.Context, tableNames []string) error
+	// GetSchemaCheckpoints returns the tables whose schema has been created.
+	GetSchemaCheckpoints(ctx context.Context) (map[string]struct{}, error)
+	// InsertFilteredFiles records the source files filtered out under the
+	// filter rules of the fingerprint, so they are not routed again on resume.
+	// The files recorded under other fingerprints are forgotten.
+	InsertFilteredFiles(ctx context.Context, fingerprint string, paths []string) error
+	// GetFilteredFiles returns the source files filtered out under the filter
+	// rules of the fingerprint.
+	GetFilteredFiles(ctx context.Context, fingerprint string) (map[string]struct{}, error)
 
 	RemoveCheckpoint(ctx context.Context, tableName string) error
 	// MoveCheckpoints renames the checkpoint schema to include a suffix
@@ -364,6 +388,10 @@ type CheckpointsDB interface {
 	DumpTables(ctx context.Context, csv io.Writer) error
 	DumpEngines(ctx context.Context, csv io.Writer) error
 	DumpChunks(ctx context.Context, csv io.Writer) error
+	// Snapshot returns a consistent copy of all checkpoints.
+	Snapshot(ctx context.Context) (*CheckpointsModel, error)
+	// RestoreSnapshot replaces all checkpoints by the snapshot.
+	RestoreSnapshot(ctx context.Context, snapshot *CheckpointsModel) error
 }
 
 // NullCheckpointsDB is a checkpoints database with no checkpoints.
@@ -398,6 +426,22 @@ func (*NullCheckpointsDB) InsertEngineCheckpoints(_ context.Context, _ string, _
 
 func (*NullCheckpointsDB) Update(map[string]*TableCheckpointDiff) {}
 
+func (*NullCheckpointsDB) InsertSchemaCheckpoints(context.Context, []string) error {
+	return nil
+}
+
+func (*NullCheckpointsDB) GetSchemaCheckpoints(context.Context) (map[string]struct{}, error) {
+	return map[string]struct{}{}, nil
+}
+
+func (*NullCheckpointsDB) InsertFilteredFiles(context.Context, string, []string) error {
+	return nil
+}
+
+func (*NullCheckpointsDB) GetFilteredFiles(context.Context, string) (map[string]struct{}, error) {
+	return map[string]struct{}{}, nil
+}
+
 type MySQLCheckpointsDB struct {
 	db     *sql.DB
 	schema string
@@ -487,6 +531,7 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 			kvc_bytes bigint unsigned NOT NULL DEFAULT 0,
 			kvc_kvs bigint unsigned NOT NULL DEFAULT 0,
 			kvc_checksum bigint unsigned NOT NULL DEFAULT 0,
+			worker bigint NOT NULL DEFAULT 0,
 			create_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 			PRIMARY KEY(table_name, engine_id, path(500), offset)
@@ -496,6 +541,27 @@ func NewMySQLCheckpointsDB(ctx context.Context, db *sql.DB, schemaName string, t
 		return nil, errors.Trace(err)
 	}
 
+	err = sql.Exec(ctx, "create schema checkpoints table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			table_name varchar(261) NOT NULL PRIMARY KEY,
+			create_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+	`, schema, CheckpointTableNameSchema))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	err = sql.Exec(ctx, "create filtered file checkpoints table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s.%s (
+			fingerprint char(64) NOT NULL,
+			path varchar(700) NOT NULL,
+			PRIMARY KEY (fingerprint, path)
+		);
+	`, schema, CheckpointTableNameFiltered))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
 	return &MySQLCheckpointsDB{
 		db:     db,
 		schema: schema,
@@ -516,7 +582,7 @@ func (cpdb *MySQLCheckpointsDB) Initialize(ctx context.Context, cfg *config.Conf
 			return errors.Trace(err)
 		}
 		defer taskStmt.Close()
-		_, err = taskStmt.ExecContext(ctx, cfg.TaskID, cfg.Mydumper.SourceDir, cfg.TikvImporter.Backend,
+		_, err = taskStmt.ExecContext(ctx, cfg.TaskID, cfg.Mydumper.TaskSourceDir(), cfg.TikvImporter.Backend,
 			cfg.TikvImporter.Addr, cfg.TiDB.Host, cfg.TiDB.Port, cfg.TiDB.PdAddr, cfg.TikvImporter.SortedKVDir)
 		if err != nil {
 			return errors.Trace(err)
@@ -630,7 +696,7 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 			SELECT
 				engine_id, path, offset, type, compression, sort_key, columns,
 				pos, end_offset, prev_rowid_max, rowid_max,
-				kvc_bytes, kvc_kvs, kvc_checksum, unix_timestamp(create_time)
+				kvc_bytes, kvc_kvs, kvc_checksum, worker, unix_timestamp(create_time)
 			FROM %s.%s WHERE table_name = ?
 			ORDER BY engine_id, path, offset;
 		`, cpdb.schema, CheckpointTableNameChunk)
@@ -652,7 +718,7 @@ func (cpdb *MySQLCheckpointsDB) Get(ctx context.Context, tableName string) (*Tab
 				&engineID, &value.Key.Path, &value.Key.Offset, &value.FileMeta.Type, &value.FileMeta.Compression,
 				&value.FileMeta.SortKey, &colPerm, &value.Chunk.Offset, &value.Chunk.EndOffset,
 				&value.Chunk.PrevRowIDMax, &value.Chunk.RowIDMax, &kvcBytes, &kvcKVs, &kvcChecksum,
-				&value.Timestamp,
+				&value.Worker, &value.Timestamp,
 			); err != nil {
 				return errors.Trace(err)
 			}
@@ -707,12 +773,12 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 				table_name, engine_id,
 				path, offset, type, compression, sort_key, columns, should_include_row_id,
 				pos, end_offset, prev_rowid_max, rowid_max,
-				kvc_bytes, kvc_kvs, kvc_checksum, create_time
+				kvc_bytes, kvc_kvs, kvc_checksum, worker, create_time
 			) VALUES (
 				?, ?,
 				?, ?, ?, ?, ?, ?, FALSE,
 				?, ?, ?, ?,
-				0, 0, 0, from_unixtime(?)
+				0, 0, 0, ?, from_unixtime(?)
 			);
 		`, cpdb.schema, CheckpointTableNameChunk))
 		if err != nil {
@@ -734,7 +800,7 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 					c, tableName, engineID,
 					value.Key.Path, value.Key.Offset, value.FileMeta.Type, value.FileMeta.Compression,
 					value.FileMeta.SortKey, columnPerm, value.Chunk.Offset, value.Chunk.EndOffset,
-					value.Chunk.PrevRowIDMax, value.Chunk.RowIDMax, value.Timestamp,
+					value.Chunk.PrevRowIDMax, value.Chunk.RowIDMax, value.Worker, value.Timestamp,
 				)
 				if err != nil {
 					return errors.Trace(err)
@@ -753,7 +819,7 @@ func (cpdb *MySQLCheckpointsDB) InsertEngineCheckpoints(ctx context.Context, tab
 
 func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpointDiff) {
 	chunkQuery := fmt.Sprintf(`
-		UPDATE %s.%s SET pos = ?, prev_rowid_max = ?, kvc_bytes = ?, kvc_kvs = ?, kvc_checksum = ?, columns = ?
+		UPDATE %s.%s SET pos = ?, prev_rowid_max = ?, kvc_bytes = ?, kvc_kvs = ?, kvc_checksum = ?, columns = ?, worker = ?
 		WHERE (table_name, engine_id, path, offset) = (?, ?, ?, ?);
 	`, cpdb.schema, CheckpointTableNameChunk)
 	rebaseQuery := fmt.Sprintf(`
@@ -814,7 +880,7 @@ func (cpdb *MySQLCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoi
 					if _, e := chunkStmt.ExecContext(
 						c,
 						diff.pos, diff.rowID, diff.checksum.SumSize(), diff.checksum.SumKVS(), diff.checksum.Sum(),
-						columnPerm, tableName, engineID, key.Path, key.Offset,
+						columnPerm, diff.worker, tableName, engineID, key.Path, key.Offset,
 					); e != nil {
 						return errors.Trace(e)
 					}
@@ -833,9 +899,22 @@ type FileCheckpointsDB struct {
 	lock        sync.Mutex // we need to ensure only a thread can access to `checkpoints` at a time
 	checkpoints CheckpointsModel
 	path        string
+	// salvage keeps the copy of the file in the target, nil if no target.
+	salvage *checkpointSalvage
 }
 
-func NewFileCheckpointsDB(path string) *FileCheckpointsDB {
+// NewFileCheckpointsDB opens the checkpoint file at the path, without a copy in
+// the target to salvage from.
+func NewFileCheckpointsDB(path string) (*FileCheckpointsDB, error) {
+	return NewFileCheckpointsDBWithTarget(context.Background(), path, nil)
+}
+
+// NewFileCheckpointsDBWithTarget opens the checkpoint file at the path, and
+// keeps a copy of it in the `lightning_task_info` schema of the target db. If
+// the file is missing or corrupted, e.g. partially written when Lightning was
+// killed, the checkpoints are salvaged from the copy. The db is closed with
+// the checkpoints db.
+func NewFileCheckpointsDBWithTarget(ctx context.Context, path string, db *sql.DB) (*FileCheckpointsDB, error) {
 	cpdb := &FileCheckpointsDB{
 		path: path,
 		checkpoints: CheckpointsModel{
@@ -843,45 +922,89 @@ func NewFileCheckpointsDB(path string) *FileCheckpointsDB {
 			Checkpoints:    map[string]*TableCheckpointModel{},
 		},
 	}
-	// ignore all errors -- file maybe not created yet (and it is fine).
-	content, err := ioutil.ReadFile(path)
+	if db != nil {
+		cpdb.salvage = &checkpointSalvage{db: db, path: path}
+	}
+
+	err := loadCheckpointFile(path, &cpdb.checkpoints)
 	if err == nil {
-		err2 := cpdb.checkpoints.Unmarshal(content)
-		if err2 != nil {
-			log.L().Error("checkpoint file is broken", zap.String("path", path), zap.Error(err2))
-		}
-		// FIXME: patch for empty map may need initialize manually, because currently
-		// FIXME: a map of zero size -> marshall -> unmarshall -> become nil, see checkpoint_test.go
-		if cpdb.checkpoints.Checkpoints == nil {
-			cpdb.checkpoints.Checkpoints = map[string]*TableCheckpointModel{}
-		}
-		for _, table := range cpdb.checkpoints.Checkpoints {
-			if table.Engines == nil {
-				table.Engines = map[int32]*EngineCheckpointModel{}
-			}
-			for _, engine := range table.Engines {
-				if engine.Chunks == nil {
-					engine.Chunks = map[string]*ChunkCheckpointModel{}
-				}
-			}
+		initEmptyMaps(&cpdb.checkpoints)
+		return cpdb, nil
+	}
+	missing := os.IsNotExist(errors.Cause(err))
+	if cpdb.salvage == nil {
+		if !missing {
+			return nil, errors.Errorf("checkpoint file %s is corrupted (%v), "+
+				"please remove the checkpoints by `tidb-lightning-ctl --checkpoint-remove=all` and clean up the target tables",
+				path, err)
 		}
 	} else {
-		log.L().Info("open checkpoint file failed, going to create a new one",
+		log.L().Warn("checkpoint file is missing or corrupted, salvage from the copy in the target",
 			zap.String("path", path),
 			log.ShortError(err),
 		)
+		cpdb.checkpoints.Reset()
+		err2 := cpdb.salvage.load(ctx, &cpdb.checkpoints)
+		switch {
+		case err2 == nil:
+			initEmptyMaps(&cpdb.checkpoints)
+			return cpdb, nil
+		case !missing || err2 != errNoSalvageCopy:
+			return nil, errors.Errorf("cannot salvage the checkpoint file %s (%v) from the target (%v), "+
+				"please remove the checkpoints by `tidb-lightning-ctl --checkpoint-remove=all` and clean up the target tables",
+				path, err, err2)
+		}
+	}
+
+	// file maybe not created yet (and it is fine).
+	log.L().Info("open checkpoint file failed, going to create a new one",
+		zap.String("path", path),
+		log.ShortError(err),
+	)
+	cpdb.checkpoints = CheckpointsModel{
+		TaskCheckpoint: &TaskCheckpointModel{},
+		Checkpoints:    map[string]*TableCheckpointModel{},
+	}
+	return cpdb, nil
+}
+
+// FIXME: patch for empty map may need initialize manually, because currently
+// FIXME: a map of zero size -> marshall -> unmarshall -> become nil, see checkpoint_test.go
+func initEmptyMaps(model *CheckpointsModel) {
+	if model.Checkpoints == nil {
+		model.Checkpoints = map[string]*TableCheckpointModel{}
+	}
+	for _, table := range model.Checkpoints {
+		if table.Engines == nil {
+			table.Engines = map[int32]*EngineCheckpointModel{}
+		}
+		for _, engine := range table.Engines {
+			if engine.Chunks == nil {
+				engine.Chunks = map[string]*ChunkCheckpointModel{}
+			}
+		}
 	}
-	return cpdb
 }
 
 func (cpdb *FileCheckpointsDB) save() error {
-	serialized, err := cpdb.checkpoints.Marshal()
+	serialized, err := encodeCheckpointFile(&cpdb.checkpoints)
 	if err != nil {
 		return errors.Trace(err)
 	}
-	if err := ioutil.WriteFile(cpdb.path, serialized, 0644); err != nil {
+	if err := writeCheckpointFile(cpdb.path, serialized); err != nil {
 		return errors.Trace(err)
 	}
+	if cpdb.salvage == nil {
+		return nil
+	}
+	// the local file is authoritative, so the checkpoints are kept going if
+	// the target is unavailable, with an older copy to salvage from.
+	if err := cpdb.salvage.save(context.Background(), serialized); err != nil {
+		log.L().Warn("save the copy of the checkpoint file to the target failed",
+			zap.String("path", cpdb.path),
+			log.ShortError(err),
+		)
+	}
 	return nil
 }
 
@@ -891,7 +1014,7 @@ func (cpdb *FileCheckpointsDB) Initialize(ctx context.Context, cfg *config.Confi
 
 	cpdb.checkpoints.TaskCheckpoint = &TaskCheckpointModel{
 		TaskId:       cfg.TaskID,
-		SourceDir:    cfg.Mydumper.SourceDir,
+		SourceDir:    cfg.Mydumper.TaskSourceDir(),
 		Backend:      cfg.TikvImporter.Backend,
 		ImporterAddr: cfg.TikvImporter.Addr,
 		TidbHost:     cfg.TiDB.Host,
@@ -944,7 +1067,13 @@ func (cpdb *FileCheckpointsDB) Close() error {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
 
-	return errors.Trace(cpdb.save())
+	err := cpdb.save()
+	if cpdb.salvage != nil {
+		if closeErr := cpdb.salvage.db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return errors.Trace(err)
 }
 
 func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableCheckpoint, error) {
@@ -994,6 +1123,7 @@ func (cpdb *FileCheckpointsDB) Get(_ context.Context, tableName string) (*TableC
 				},
 				Checksum:  verify.MakeKVChecksum(chunkModel.KvcBytes, chunkModel.KvcKvs, chunkModel.KvcChecksum),
 				Timestamp: chunkModel.Timestamp,
+				Worker:    chunkModel.Worker,
 			})
 		}
 
@@ -1035,6 +1165,7 @@ func (cpdb *FileCheckpointsDB) InsertEngineCheckpoints(_ context.Context, tableN
 			chunk.PrevRowidMax = value.Chunk.PrevRowIDMax
 			chunk.RowidMax = value.Chunk.RowIDMax
 			chunk.Timestamp = value.Timestamp
+			chunk.Worker = value.Worker
 			if len(value.ColumnPermutation) > 0 {
 				chunk.ColumnPermutation = intSlice2Int32Slice(value.ColumnPermutation)
 			}
@@ -1046,6 +1177,170 @@ func (cpdb *FileCheckpointsDB) InsertEngineCheckpoints(_ context.Context, tableN
 	return errors.Trace(cpdb.save())
 }
 
+func (cpdb *MySQLCheckpointsDB) InsertSchemaCheckpoints(ctx context.Context, tableNames []string) error {
+	if len(tableNames) == 0 {
+		return nil
+	}
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	return s.Transact(ctx, "insert schema checkpoints", func(c context.Context, tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT IGNORE INTO %s.%s (table_name) VALUES (?);
+		`, cpdb.schema, CheckpointTableNameSchema))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer stmt.Close()
+
+		for _, tableName := range tableNames {
+			if _, err := stmt.ExecContext(c, tableName); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (cpdb *MySQLCheckpointsDB) GetSchemaCheckpoints(ctx context.Context) (map[string]struct{}, error) {
+	var tableNames map[string]struct{}
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	err := s.Transact(ctx, "read schema checkpoints", func(c context.Context, tx *sql.Tx) error {
+		tableNames = make(map[string]struct{})
+		rows, err := tx.QueryContext(c, fmt.Sprintf("SELECT table_name FROM %s.%s;", cpdb.schema, CheckpointTableNameSchema))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var tableName string
+			if err := rows.Scan(&tableName); err != nil {
+				return errors.Trace(err)
+			}
+			tableNames[tableName] = struct{}{}
+		}
+		return errors.Trace(rows.Err())
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return tableNames, nil
+}
+
+func (cpdb *MySQLCheckpointsDB) InsertFilteredFiles(ctx context.Context, fingerprint string, paths []string) error {
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	return s.Transact(ctx, "insert filtered file checkpoints", func(c context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(c, fmt.Sprintf(`
+			DELETE FROM %s.%s WHERE fingerprint <> ?;
+		`, cpdb.schema, CheckpointTableNameFiltered), fingerprint); err != nil {
+			return errors.Trace(err)
+		}
+		if len(paths) == 0 {
+			return nil
+		}
+
+		stmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT IGNORE INTO %s.%s (fingerprint, path) VALUES (?, ?);
+		`, cpdb.schema, CheckpointTableNameFiltered))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer stmt.Close()
+
+		for _, path := range paths {
+			if _, err := stmt.ExecContext(c, fingerprint, path); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+func (cpdb *MySQLCheckpointsDB) GetFilteredFiles(ctx context.Context, fingerprint string) (map[string]struct{}, error) {
+	var paths map[string]struct{}
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	err := s.Transact(ctx, "read filtered file checkpoints", func(c context.Context, tx *sql.Tx) error {
+		paths = make(map[string]struct{})
+		rows, err := tx.QueryContext(c, fmt.Sprintf(
+			"SELECT path FROM %s.%s WHERE fingerprint = ?;", cpdb.schema, CheckpointTableNameFiltered), fingerprint)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				return errors.Trace(err)
+			}
+			paths[path] = struct{}{}
+		}
+		return errors.Trace(rows.Err())
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return paths, nil
+}
+
+func (cpdb *FileCheckpointsDB) InsertSchemaCheckpoints(_ context.Context, tableNames []string) error {
+	if len(tableNames) == 0 {
+		return nil
+	}
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	cpdb.checkpoints.CreatedTables = append(cpdb.checkpoints.CreatedTables, tableNames...)
+	return errors.Trace(cpdb.save())
+}
+
+func (cpdb *FileCheckpointsDB) GetSchemaCheckpoints(_ context.Context) (map[string]struct{}, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	tableNames := make(map[string]struct{}, len(cpdb.checkpoints.CreatedTables))
+	for _, tableName := range cpdb.checkpoints.CreatedTables {
+		tableNames[tableName] = struct{}{}
+	}
+	return tableNames, nil
+}
+
+func (cpdb *FileCheckpointsDB) InsertFilteredFiles(_ context.Context, fingerprint string, paths []string) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	if cpdb.checkpoints.FilterFingerprint != fingerprint {
+		cpdb.checkpoints.FilterFingerprint = fingerprint
+		cpdb.checkpoints.FilteredFiles = nil
+	}
+	cpdb.checkpoints.FilteredFiles = append(cpdb.checkpoints.FilteredFiles, paths...)
+	return errors.Trace(cpdb.save())
+}
+
+func (cpdb *FileCheckpointsDB) GetFilteredFiles(_ context.Context, fingerprint string) (map[string]struct{}, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	paths := make(map[string]struct{})
+	if cpdb.checkpoints.FilterFingerprint != fingerprint {
+		return paths, nil
+	}
+	for _, path := range cpdb.checkpoints.FilteredFiles {
+		paths[path] = struct{}{}
+	}
+	return paths, nil
+}
+
+// removeSchemaCheckpoints forgets the tables whose schema has been created, so
+// they are created again on the next run. This method is always called in
+// lock.
+func (cpdb *FileCheckpointsDB) removeSchemaCheckpoints(tableNames map[string]struct{}) {
+	createdTables := cpdb.checkpoints.CreatedTables[:0]
+	for _, tableName := range cpdb.checkpoints.CreatedTables {
+		if _, ok := tableNames[tableName]; !ok {
+			createdTables = append(createdTables, tableName)
+		}
+	}
+	cpdb.checkpoints.CreatedTables = createdTables
+}
+
 func (cpdb *FileCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpointDiff) {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
@@ -1072,6 +1367,7 @@ func (cpdb *FileCheckpointsDB) Update(checkpointDiffs map[string]*TableCheckpoin
 				chunkModel.KvcKvs = diff.checksum.SumKVS()
 				chunkModel.KvcChecksum = diff.checksum.Sum()
 				chunkModel.ColumnPermutation = intSlice2Int32Slice(diff.columnPermutation)
+				chunkModel.Worker = diff.worker
 			}
 		}
 	}
@@ -1120,6 +1416,7 @@ func (cpdb *MySQLCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName
 	deleteChunkQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, CheckpointTableNameChunk)
 	deleteEngineQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, CheckpointTableNameEngine)
 	deleteTableQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, CheckpointTableNameTable)
+	deleteSchemaQuery := fmt.Sprintf("DELETE FROM %s.%s WHERE table_name = ?", cpdb.schema, CheckpointTableNameSchema)
 
 	return s.Transact(ctx, "remove checkpoints", func(c context.Context, tx *sql.Tx) error {
 		if _, e := tx.ExecContext(c, deleteChunkQuery, tableName); e != nil {
@@ -1131,6 +1428,9 @@ func (cpdb *MySQLCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName
 		if _, e := tx.ExecContext(c, deleteTableQuery, tableName); e != nil {
 			return errors.Trace(e)
 		}
+		if _, e := tx.ExecContext(c, deleteSchemaQuery, tableName); e != nil {
+			return errors.Trace(e)
+		}
 		return nil
 	})
 }
@@ -1149,6 +1449,8 @@ func (cpdb *MySQLCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int6
 	moveChunkQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, CheckpointTableNameChunk)
 	moveEngineQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, CheckpointTableNameEngine)
 	moveTableQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, CheckpointTableNameTable)
+	moveSchemaQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, CheckpointTableNameSchema)
+	moveFilteredQuery := fmt.Sprintf("RENAME TABLE %[1]s.%[3]s TO %[2]s.%[3]s", cpdb.schema, newSchema, CheckpointTableNameFiltered)
 
 	if e := s.Exec(ctx, "create backup checkpoints schema", createSchemaQuery); e != nil {
 		return e
@@ -1162,6 +1464,12 @@ func (cpdb *MySQLCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int6
 	if e := s.Exec(ctx, "move table checkpoints table", moveTableQuery); e != nil {
 		return e
 	}
+	if e := s.Exec(ctx, "move schema checkpoints table", moveSchemaQuery); e != nil {
+		return e
+	}
+	if e := s.Exec(ctx, "move filtered file checkpoints table", moveFilteredQuery); e != nil {
+		return e
+	}
 	return nil
 }
 
@@ -1227,6 +1535,9 @@ func (cpdb *MySQLCheckpointsDB) DestroyErrorCheckpoint(ctx context.Context, tabl
 	deleteEngineQuery := fmt.Sprintf(`
 		DELETE FROM %[1]s.%[4]s WHERE table_name IN (SELECT table_name FROM %[1]s.%[5]s WHERE %[2]s = ? AND status <= %[3]d)
 	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, CheckpointTableNameEngine, CheckpointTableNameTable)
+	deleteSchemaQuery := fmt.Sprintf(`
+		DELETE FROM %[1]s.%[4]s WHERE table_name IN (SELECT table_name FROM %[1]s.%[5]s WHERE %[2]s = ? AND status <= %[3]d)
+	`, cpdb.schema, colName, CheckpointStatusMaxInvalid, CheckpointTableNameSchema, CheckpointTableNameTable)
 	deleteTableQuery := fmt.Sprintf(`
 		DELETE FROM %s.%s WHERE %s = ? AND status <= %d
 	`, cpdb.schema, CheckpointTableNameTable, colName, CheckpointStatusMaxInvalid)
@@ -1263,6 +1574,9 @@ func (cpdb *MySQLCheckpointsDB) DestroyErrorCheckpoint(ctx context.Context, tabl
 		if _, e := tx.ExecContext(c, deleteEngineQuery, tableName); e != nil {
 			return errors.Trace(e)
 		}
+		if _, e := tx.ExecContext(c, deleteSchemaQuery, tableName); e != nil {
+			return errors.Trace(e)
+		}
 		if _, e := tx.ExecContext(c, deleteTableQuery, tableName); e != nil {
 			return errors.Trace(e)
 		}
@@ -1330,6 +1644,7 @@ func (cpdb *MySQLCheckpointsDB) DumpChunks(ctx context.Context, writer io.Writer
 			kvc_bytes,
 			kvc_kvs,
 			kvc_checksum,
+			worker,
 			create_time,
 			update_time
 		FROM %s.%s;
@@ -1342,16 +1657,20 @@ func (cpdb *MySQLCheckpointsDB) DumpChunks(ctx context.Context, writer io.Writer
 	return errors.Trace(sqltocsv.Write(writer, rows))
 }
 
-func (cpdb *FileCheckpointsDB) RemoveCheckpoint(_ context.Context, tableName string) error {
+func (cpdb *FileCheckpointsDB) RemoveCheckpoint(ctx context.Context, tableName string) error {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
 
 	if tableName == "all" {
 		cpdb.checkpoints.Reset()
+		if err := cpdb.removeSalvageCopy(ctx); err != nil {
+			return errors.Trace(err)
+		}
 		return errors.Trace(os.Remove(cpdb.path))
 	}
 
 	delete(cpdb.checkpoints.Checkpoints, tableName)
+	cpdb.removeSchemaCheckpoints(map[string]struct{}{tableName: {}})
 	return errors.Trace(cpdb.save())
 }
 
@@ -1360,9 +1679,21 @@ func (cpdb *FileCheckpointsDB) MoveCheckpoints(ctx context.Context, taskID int64
 	defer cpdb.lock.Unlock()
 
 	newPath := fmt.Sprintf("%s.%d.bak", cpdb.path, taskID)
+	if err := cpdb.removeSalvageCopy(ctx); err != nil {
+		return errors.Trace(err)
+	}
 	return errors.Trace(os.Rename(cpdb.path, newPath))
 }
 
+// removeSalvageCopy removes the copy of the file in the target, so the removed
+// checkpoints are not salvaged by the next run.
+func (cpdb *FileCheckpointsDB) removeSalvageCopy(ctx context.Context) error {
+	if cpdb.salvage == nil {
+		return nil
+	}
+	return errors.Trace(cpdb.salvage.remove(ctx))
+}
+
 func (cpdb *FileCheckpointsDB) IgnoreErrorCheckpoint(_ context.Context, targetTableName string) error {
 	cpdb.lock.Lock()
 	defer cpdb.lock.Unlock()
@@ -1414,9 +1745,12 @@ func (cpdb *FileCheckpointsDB) DestroyErrorCheckpoint(_ context.Context, targetT
 	}
 
 	// Delete the checkpoints
+	destroyedTables := make(map[string]struct{}, len(targetTables))
 	for _, dtcp := range targetTables {
 		delete(cpdb.checkpoints.Checkpoints, dtcp.TableName)
+		destroyedTables[dtcp.TableName] = struct{}{}
 	}
+	cpdb.removeSchemaCheckpoints(destroyedTables)
 	if err := cpdb.save(); err != nil {
 		return nil, errors.Trace(err)
 	}
@@ -1437,7 +1771,7 @@ func (cpdb *FileCheckpointsDB) DumpChunks(context.Context, io.Writer) error {
 }
 
 func intSlice2Int32Slice(s []int) []int32 {
-	res := make([]int32, len(s))
+	res := make([]int32, 0, len(s))
 	for _, i := range s {
 		res = append(res, int32(i))
 	}
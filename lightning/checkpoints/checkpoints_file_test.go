@@ -6,6 +6,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/pingcap/br/pkg/storage"
 	. "github.com/pingcap/check"
 
 	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
@@ -39,14 +40,16 @@ func newTestConfig() *config.Config {
 
 func (s *cpFileSuite) SetUpTest(c *C) {
 	dir := c.MkDir()
-	s.cpdb = checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "cp.pb"))
+	s.path = filepath.Join(dir, "cp.pb")
+	cpdb, err := checkpoints.NewFileCheckpointsDB(s.path)
+	c.Assert(err, IsNil)
+	s.cpdb = cpdb
 
 	ctx := context.Background()
-	cpdb := s.cpdb
 
 	// 2. initialize with checkpoint data.
 	cfg := newTestConfig()
-	err := cpdb.Initialize(ctx, cfg, map[string]*checkpoints.TidbDBInfo{
+	err = cpdb.Initialize(ctx, cfg, map[string]*checkpoints.TidbDBInfo{
 		"db1": {
 			Name: "db1",
 			Tables: map[string]*checkpoints.TidbTableInfo{
@@ -123,6 +126,7 @@ func (s *cpFileSuite) SetUpTest(c *C) {
 		Checksum: verification.MakeKVChecksum(4491, 586, 486070148917),
 		Pos:      55904,
 		RowID:    681,
+		Worker:   2,
 	}
 	ccm.MergeInto(cpd)
 
@@ -182,6 +186,7 @@ func (s *cpFileSuite) TestGet(c *C) {
 						RowIDMax:     5000,
 					},
 					Checksum: verification.MakeKVChecksum(4491, 586, 486070148917),
+					Worker:   2,
 				}},
 			},
 		},
@@ -206,6 +211,42 @@ func (s *cpFileSuite) TestGet(c *C) {
 	})
 }
 
+func (s *cpFileSuite) TestResumeChunkWorker(c *C) {
+	ctx := context.Background()
+
+	// the worker of the chunk survives the restart.
+	c.Assert(s.cpdb.Close(), IsNil)
+	cpdb, err := checkpoints.NewFileCheckpointsDB(s.path)
+	c.Assert(err, IsNil)
+	s.cpdb = cpdb
+
+	cp, err := cpdb.Get(ctx, "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	chunk := cp.Engines[0].Chunks[0]
+	c.Assert(chunk.Worker, Equals, int64(2))
+	c.Assert(chunk.DeepCopy().Worker, Equals, int64(2))
+
+	// the chunk restored by another worker of the resumed task.
+	cpd := checkpoints.NewTableCheckpointDiff()
+	ccm := checkpoints.ChunkCheckpointMerger{
+		EngineID: 0,
+		Key:      chunk.Key,
+		Checksum: chunk.Checksum,
+		Pos:      102400,
+		RowID:    5000,
+		Worker:   4,
+	}
+	ccm.MergeInto(cpd)
+	cpdb.Update(map[string]*checkpoints.TableCheckpointDiff{"`db1`.`t2`": cpd})
+	cp.Apply(cpd)
+	c.Assert(cp.Engines[0].Chunks[0].Worker, Equals, int64(4))
+
+	cp, err = cpdb.Get(ctx, "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	c.Assert(cp.Engines[0].Chunks[0].Worker, Equals, int64(4))
+	c.Assert(cp.Engines[0].Chunks[0].Chunk.Offset, Equals, int64(102400))
+}
+
 func (s *cpFileSuite) TestRemoveAllCheckpoints(c *C) {
 	ctx := context.Background()
 
@@ -323,3 +364,103 @@ func (s *cpFileSuite) TestDestroyOneErrorCheckpoint(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(cp.Status, Equals, checkpoints.CheckpointStatusAllWritten/10)
 }
+
+func (s *cpFileSuite) TestSchemaCheckpoints(c *C) {
+	ctx := context.Background()
+
+	err := s.cpdb.InsertSchemaCheckpoints(ctx, []string{"`db1`.`t1`", "`db1`.`t2`", "`db2`.`t3`"})
+	c.Assert(err, IsNil)
+
+	s.setInvalidStatus()
+	_, err = s.cpdb.DestroyErrorCheckpoint(ctx, "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	err = s.cpdb.RemoveCheckpoint(ctx, "`db2`.`t3`")
+	c.Assert(err, IsNil)
+
+	tableNames, err := s.cpdb.GetSchemaCheckpoints(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, map[string]struct{}{"`db1`.`t1`": {}})
+
+	// the schema checkpoints survive restarts.
+	c.Assert(s.cpdb.Close(), IsNil)
+	s.cpdb, err = checkpoints.NewFileCheckpointsDB(s.path)
+	c.Assert(err, IsNil)
+	tableNames, err = s.cpdb.GetSchemaCheckpoints(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, map[string]struct{}{"`db1`.`t1`": {}})
+}
+
+func (s *cpFileSuite) TestFilteredFiles(c *C) {
+	ctx := context.Background()
+
+	err := s.cpdb.InsertFilteredFiles(ctx, "fp1", []string{"a.txt", "db.ignored.sql"})
+	c.Assert(err, IsNil)
+	err = s.cpdb.InsertFilteredFiles(ctx, "fp1", []string{"b.txt"})
+	c.Assert(err, IsNil)
+
+	// the filtered files survive restarts.
+	c.Assert(s.cpdb.Close(), IsNil)
+	s.cpdb, err = checkpoints.NewFileCheckpointsDB(s.path)
+	c.Assert(err, IsNil)
+	paths, err := s.cpdb.GetFilteredFiles(ctx, "fp1")
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, map[string]struct{}{"a.txt": {}, "db.ignored.sql": {}, "b.txt": {}})
+
+	// the files filtered under other rules are not reused.
+	paths, err = s.cpdb.GetFilteredFiles(ctx, "fp2")
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+	err = s.cpdb.InsertFilteredFiles(ctx, "fp2", []string{"c.txt"})
+	c.Assert(err, IsNil)
+	paths, err = s.cpdb.GetFilteredFiles(ctx, "fp2")
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, map[string]struct{}{"c.txt": {}})
+	paths, err = s.cpdb.GetFilteredFiles(ctx, "fp1")
+	c.Assert(err, IsNil)
+	c.Assert(paths, HasLen, 0)
+}
+
+func (s *cpFileSuite) TestSnapshot(c *C) {
+	ctx := context.Background()
+
+	err := s.cpdb.InsertSchemaCheckpoints(ctx, []string{"`db1`.`t1`"})
+	c.Assert(err, IsNil)
+	err = s.cpdb.InsertFilteredFiles(ctx, "fp1", []string{"a.txt"})
+	c.Assert(err, IsNil)
+
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	err = checkpoints.ExportSnapshot(ctx, s.cpdb, store)
+	c.Assert(err, IsNil)
+	expected, err := s.cpdb.Get(ctx, "`db1`.`t2`")
+	c.Assert(err, IsNil)
+
+	// the checkpoints lost with the host are restored from the snapshot.
+	cpdb, err := checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "restored.pb"))
+	c.Assert(err, IsNil)
+	err = checkpoints.ImportSnapshot(ctx, cpdb, store)
+	c.Assert(err, IsNil)
+	c.Assert(cpdb.Close(), IsNil)
+
+	cpdb, err = checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "restored.pb"))
+	c.Assert(err, IsNil)
+	defer cpdb.Close()
+	cp, err := cpdb.Get(ctx, "`db1`.`t2`")
+	c.Assert(err, IsNil)
+	c.Assert(cp, DeepEquals, expected)
+	taskCp, err := cpdb.TaskCheckpoint(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(taskCp.TaskId, Equals, int64(123))
+	tableNames, err := cpdb.GetSchemaCheckpoints(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, map[string]struct{}{"`db1`.`t1`": {}})
+	paths, err := cpdb.GetFilteredFiles(ctx, "fp1")
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, map[string]struct{}{"a.txt": {}})
+
+	err = store.Write(ctx, checkpoints.SnapshotName, []byte("LCPZ\x01corrupted"))
+	c.Assert(err, IsNil)
+	err = checkpoints.ImportSnapshot(ctx, cpdb, store)
+	c.Assert(err, ErrorMatches, "snapshot checkpoint-snapshot.pb: .*checkpoint file is corrupted")
+}
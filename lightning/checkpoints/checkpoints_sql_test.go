@@ -44,6 +44,12 @@ func (s *cpSQLSuite) SetUpTest(c *C) {
 	s.mock.
 		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.chunk_v\\d+ .+").
 		WillReturnResult(sqlmock.NewResult(5, 1))
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.schema_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(6, 1))
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `mock-schema`\\.filtered_file_v\\d+ .+").
+		WillReturnResult(sqlmock.NewResult(7, 1))
 
 	cpdb, err := checkpoints.NewMySQLCheckpointsDB(context.Background(), s.db, "mock-schema", 1234)
 	c.Assert(err, IsNil)
@@ -120,7 +126,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectPrepare("REPLACE INTO `mock-schema`\\.chunk_v\\d+ .+")
 	insertChunkStmt.
 		ExpectExec().
-		WithArgs("`db1`.`t2`", 0, "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, 0, "", []byte("null"), 12, 102400, 1, 5000, 1234567890).
+		WithArgs("`db1`.`t2`", 0, "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, 0, "", []byte("null"), 12, 102400, 1, 5000, 3, 1234567890).
 		WillReturnResult(sqlmock.NewResult(10, 1))
 	s.mock.ExpectCommit()
 
@@ -144,6 +150,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 					RowIDMax:     5000,
 				},
 				Timestamp: 1234567890,
+				Worker:    3,
 			}},
 		},
 		-1: {
@@ -178,6 +185,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		Checksum: verification.MakeKVChecksum(4491, 586, 486070148917),
 		Pos:      55904,
 		RowID:    681,
+		Worker:   2,
 	}
 	ccm.MergeInto(cpd)
 
@@ -186,7 +194,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 		ExpectPrepare("UPDATE `mock-schema`\\.chunk_v\\d+ SET pos = .+").
 		ExpectExec().
 		WithArgs(
-			55904, 681, 4491, 586, 486070148917, []byte("null"),
+			55904, 681, 4491, 586, 486070148917, []byte("null"), 2,
 			"`db1`.`t2`", 0, "/tmp/path/1.sql", 0,
 		).
 		WillReturnResult(sqlmock.NewResult(11, 1))
@@ -230,12 +238,12 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 			sqlmock.NewRows([]string{
 				"engine_id", "path", "offset", "type", "compression", "sort_key", "columns",
 				"pos", "end_offset", "prev_rowid_max", "rowid_max",
-				"kvc_bytes", "kvc_kvs", "kvc_checksum", "unix_timestamp(create_time)",
+				"kvc_bytes", "kvc_kvs", "kvc_checksum", "worker", "unix_timestamp(create_time)",
 			}).
 				AddRow(
 					0, "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, 0, "", "[]",
 					55904, 102400, 681, 5000,
-					4491, 586, 486070148917, 1234567894,
+					4491, 586, 486070148917, 2, 1234567894,
 				),
 		)
 	s.mock.
@@ -275,6 +283,7 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 					},
 					Checksum:  verification.MakeKVChecksum(4491, 586, 486070148917),
 					Timestamp: 1234567894,
+					Worker:    2,
 				}},
 			},
 		},
@@ -282,6 +291,79 @@ func (s *cpSQLSuite) TestNormalOperations(c *C) {
 	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
 }
 
+func (s *cpSQLSuite) TestSchemaCheckpoints(c *C) {
+	ctx := context.Background()
+
+	s.mock.ExpectBegin()
+	insertStmt := s.mock.ExpectPrepare("INSERT IGNORE INTO `mock-schema`\\.schema_v\\d+")
+	insertStmt.ExpectExec().
+		WithArgs("`db1`.`t1`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	insertStmt.ExpectExec().
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mock.ExpectCommit()
+
+	err := s.cpdb.InsertSchemaCheckpoints(ctx, []string{"`db1`.`t1`", "`db1`.`t2`"})
+	c.Assert(err, IsNil)
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT table_name FROM `mock-schema`\\.schema_v\\d+").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"table_name"}).
+				AddRow("`db1`.`t1`").
+				AddRow("`db1`.`t2`"),
+		)
+	s.mock.ExpectCommit()
+
+	tableNames, err := s.cpdb.GetSchemaCheckpoints(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tableNames, DeepEquals, map[string]struct{}{
+		"`db1`.`t1`": {},
+		"`db1`.`t2`": {},
+	})
+}
+
+func (s *cpSQLSuite) TestFilteredFiles(c *C) {
+	ctx := context.Background()
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.filtered_file_v\\d+ WHERE fingerprint <> \\?").
+		WithArgs("fp").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	insertStmt := s.mock.ExpectPrepare("INSERT IGNORE INTO `mock-schema`\\.filtered_file_v\\d+")
+	insertStmt.ExpectExec().
+		WithArgs("fp", "a.txt").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	insertStmt.ExpectExec().
+		WithArgs("fp", "db.ignored.sql").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mock.ExpectCommit()
+
+	err := s.cpdb.InsertFilteredFiles(ctx, "fp", []string{"a.txt", "db.ignored.sql"})
+	c.Assert(err, IsNil)
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT path FROM `mock-schema`\\.filtered_file_v\\d+ WHERE fingerprint = \\?").
+		WithArgs("fp").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"path"}).
+				AddRow("a.txt").
+				AddRow("db.ignored.sql"),
+		)
+	s.mock.ExpectCommit()
+
+	paths, err := s.cpdb.GetFilteredFiles(ctx, "fp")
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, map[string]struct{}{
+		"a.txt":          {},
+		"db.ignored.sql": {},
+	})
+}
+
 func (s *cpSQLSuite) TestRemoveAllCheckpoints(c *C) {
 	s.mock.ExpectExec("DROP SCHEMA `mock-schema`").WillReturnResult(sqlmock.NewResult(0, 1))
 
@@ -303,6 +385,10 @@ func (s *cpSQLSuite) TestRemoveOneCheckpoint(c *C) {
 		ExpectExec("DELETE FROM `mock-schema`\\.table_v\\d+ WHERE table_name = \\?").
 		WithArgs("`db1`.`t2`").
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.schema_v\\d+ WHERE table_name = \\?").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.ExpectCommit()
 
 	err := s.cpdb.RemoveCheckpoint(context.Background(), "`db1`.`t2`")
@@ -358,6 +444,10 @@ func (s *cpSQLSuite) TestDestroyAllErrorCheckpoints(c *C) {
 		ExpectExec("DELETE FROM `mock-schema`\\.engine_v\\d+ WHERE table_name IN .+ 'all' = \\?").
 		WithArgs(sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(0, 3))
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.schema_v\\d+ WHERE table_name IN .+ 'all' = \\?").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
 	s.mock.
 		ExpectExec("DELETE FROM `mock-schema`\\.table_v\\d+ WHERE 'all' = \\?").
 		WithArgs(sqlmock.AnyArg()).
@@ -390,6 +480,10 @@ func (s *cpSQLSuite) TestDestroyOneErrorCheckpoints(c *C) {
 		ExpectExec("DELETE FROM `mock-schema`\\.engine_v\\d+ WHERE .+table_name = \\?").
 		WithArgs("`db1`.`t2`").
 		WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.
+		ExpectExec("DELETE FROM `mock-schema`\\.schema_v\\d+ WHERE .+table_name = \\?").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 	s.mock.
 		ExpectExec("DELETE FROM `mock-schema`\\.table_v\\d+ WHERE table_name = \\?").
 		WithArgs("`db1`.`t2`").
@@ -415,12 +509,12 @@ func (s *cpSQLSuite) TestDump(c *C) {
 			sqlmock.NewRows([]string{
 				"table_name", "path", "offset", "type", "compression", "sort_key", "columns",
 				"pos", "end_offset", "prev_rowid_max", "rowid_max",
-				"kvc_bytes", "kvc_kvs", "kvc_checksum",
+				"kvc_bytes", "kvc_kvs", "kvc_checksum", "worker",
 				"create_time", "update_time",
 			}).AddRow(
 				"`db1`.`t2`", "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, mydump.CompressionNone, "", "[]",
 				55904, 102400, 681, 5000,
-				4491, 586, 486070148917,
+				4491, 586, 486070148917, 2,
 				t, t,
 			),
 		)
@@ -429,8 +523,8 @@ func (s *cpSQLSuite) TestDump(c *C) {
 	err := s.cpdb.DumpChunks(ctx, &csvBuilder)
 	c.Assert(err, IsNil)
 	c.Assert(csvBuilder.String(), Equals,
-		"table_name,path,offset,type,compression,sort_key,columns,pos,end_offset,prev_rowid_max,rowid_max,kvc_bytes,kvc_kvs,kvc_checksum,create_time,update_time\n"+
-			"`db1`.`t2`,/tmp/path/1.sql,0,3,0,,[],55904,102400,681,5000,4491,586,486070148917,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
+		"table_name,path,offset,type,compression,sort_key,columns,pos,end_offset,prev_rowid_max,rowid_max,kvc_bytes,kvc_kvs,kvc_checksum,worker,create_time,update_time\n"+
+			"`db1`.`t2`,/tmp/path/1.sql,0,3,0,,[],55904,102400,681,5000,4491,586,486070148917,2,2019-04-18 02:45:55 +0000 UTC,2019-04-18 02:45:55 +0000 UTC\n",
 	)
 
 	s.mock.
@@ -481,7 +575,146 @@ func (s *cpSQLSuite) TestMoveCheckpoints(c *C) {
 	s.mock.
 		ExpectExec("RENAME TABLE `mock-schema`\\.table_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.table_v\\d+").
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("RENAME TABLE `mock-schema`\\.schema_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.schema_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("RENAME TABLE `mock-schema`\\.filtered_file_v\\d+ TO `mock-schema\\.12345678\\.bak`\\.filtered_file_v\\d+").
+		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	err := s.cpdb.MoveCheckpoints(ctx, 12345678)
 	c.Assert(err, IsNil)
 }
+
+func (s *cpSQLSuite) TestSnapshot(c *C) {
+	ctx := context.Background()
+
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT .+ FROM `mock-schema`\\.task_v\\d+ WHERE id = 1").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"task_id", "source_dir", "backend", "importer_addr", "tidb_host", "tidb_port", "pd_addr", "sorted_kv_dir"}).
+				AddRow(123, "/data", "local", "", "127.0.0.1", 4000, "127.0.0.1:2379", "/tmp/sorted-kv"),
+		)
+	s.mock.
+		ExpectQuery("SELECT .+ FROM `mock-schema`\\.table_v\\d+").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"table_name", "hash", "status", "alloc_base", "table_id"}).
+				AddRow("`db1`.`t2`", []byte("0"), 90, 132861, 2),
+		)
+	s.mock.
+		ExpectQuery("SELECT .+ FROM `mock-schema`\\.engine_v\\d+").
+		WillReturnRows(
+			sqlmock.NewRows([]string{"table_name", "engine_id", "status"}).
+				AddRow("`db1`.`t2`", -1, 30).
+				AddRow("`db1`.`t2`", 0, 120),
+		)
+	s.mock.
+		ExpectQuery("SELECT (?s:.+) FROM `mock-schema`\\.chunk_v\\d+").
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"table_name", "engine_id", "path", "offset", "type", "compression", "sort_key", "columns",
+				"pos", "end_offset", "prev_rowid_max", "rowid_max",
+				"kvc_bytes", "kvc_kvs", "kvc_checksum", "unix_timestamp(create_time)",
+			}).AddRow(
+				"`db1`.`t2`", 0, "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, mydump.CompressionNone, "", "[1,0]",
+				55904, 102400, 681, 5000,
+				4491, 586, 486070148917, 1234567894,
+			),
+		)
+	s.mock.
+		ExpectQuery("SELECT table_name FROM `mock-schema`\\.schema_v\\d+").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name"}).AddRow("`db1`.`t2`"))
+	s.mock.
+		ExpectQuery("SELECT fingerprint, path FROM `mock-schema`\\.filtered_file_v\\d+").
+		WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "path"}).AddRow("fp1", "a.txt"))
+	s.mock.ExpectCommit()
+
+	snapshot, err := s.cpdb.Snapshot(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+	c.Assert(snapshot, DeepEquals, &checkpoints.CheckpointsModel{
+		TaskCheckpoint: &checkpoints.TaskCheckpointModel{
+			TaskId:      123,
+			SourceDir:   "/data",
+			Backend:     "local",
+			TidbHost:    "127.0.0.1",
+			TidbPort:    4000,
+			PdAddr:      "127.0.0.1:2379",
+			SortedKvDir: "/tmp/sorted-kv",
+		},
+		Checkpoints: map[string]*checkpoints.TableCheckpointModel{
+			"`db1`.`t2`": {
+				Hash:      []byte("0"),
+				Status:    90,
+				AllocBase: 132861,
+				TableID:   2,
+				Engines: map[int32]*checkpoints.EngineCheckpointModel{
+					-1: {Status: 30, Chunks: map[string]*checkpoints.ChunkCheckpointModel{}},
+					0: {Status: 120, Chunks: map[string]*checkpoints.ChunkCheckpointModel{
+						"/tmp/path/1.sql:0": {
+							Path:              "/tmp/path/1.sql",
+							Type:              int32(mydump.SourceTypeSQL),
+							ColumnPermutation: []int32{1, 0},
+							Pos:               55904,
+							EndOffset:         102400,
+							PrevRowidMax:      681,
+							RowidMax:          5000,
+							KvcBytes:          4491,
+							KvcKvs:            586,
+							KvcChecksum:       486070148917,
+							Timestamp:         1234567894,
+						},
+					}},
+				},
+			},
+		},
+		CreatedTables:     []string{"`db1`.`t2`"},
+		FilterFingerprint: "fp1",
+		FilteredFiles:     []string{"a.txt"},
+	})
+
+	// restore the snapshot into the checkpoint tables.
+
+	s.mock.ExpectBegin()
+	for _, table := range []string{"task", "table", "engine", "chunk", "schema", "filtered_file"} {
+		s.mock.
+			ExpectExec("DELETE FROM `mock-schema`\\." + table + "_v\\d+").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	s.mock.
+		ExpectExec("INSERT INTO `mock-schema`\\.task_v\\d+").
+		WithArgs(123, "/data", "local", "", "127.0.0.1", 4000, "127.0.0.1:2379", "/tmp/sorted-kv").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	tableStmt := s.mock.ExpectPrepare("INSERT INTO `mock-schema`\\.table_v\\d+")
+	engineStmt := s.mock.ExpectPrepare("INSERT INTO `mock-schema`\\.engine_v\\d+")
+	chunkStmt := s.mock.ExpectPrepare("INSERT INTO `mock-schema`\\.chunk_v\\d+")
+	tableStmt.ExpectExec().
+		WithArgs(123, "`db1`.`t2`", []byte("0"), 90, 132861, 2).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	engineStmt.ExpectExec().
+		WithArgs("`db1`.`t2`", -1, 30).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	engineStmt.ExpectExec().
+		WithArgs("`db1`.`t2`", 0, 120).
+		WillReturnResult(sqlmock.NewResult(4, 1))
+	chunkStmt.ExpectExec().
+		WithArgs("`db1`.`t2`", 0, "/tmp/path/1.sql", 0, mydump.SourceTypeSQL, 0, "", []byte("[1,0]"),
+			55904, 102400, 681, 5000, 4491, 586, 486070148917, 1234567894).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	s.mock.
+		ExpectExec("INSERT IGNORE INTO `mock-schema`\\.schema_v\\d+").
+		WithArgs("`db1`.`t2`").
+		WillReturnResult(sqlmock.NewResult(6, 1))
+	s.mock.
+		ExpectExec("INSERT IGNORE INTO `mock-schema`\\.filtered_file_v\\d+").
+		WithArgs("fp1", "a.txt").
+		WillReturnResult(sqlmock.NewResult(7, 1))
+	s.mock.ExpectCommit()
+
+	s.mock.MatchExpectationsInOrder(false)
+	err = s.cpdb.RestoreSnapshot(ctx, snapshot)
+	s.mock.MatchExpectationsInOrder(true)
+	c.Assert(err, IsNil)
+	c.Assert(s.mock.ExpectationsWereMet(), IsNil)
+}
@@ -1,10 +1,16 @@
 package checkpoints
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
 
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/verification"
@@ -293,14 +299,115 @@ func (s *checkpointSuite) TestApplyDiff(c *C) {
 
 func (s *checkpointSuite) TestCheckpointMarshallUnmarshall(c *C) {
 	path := filepath.Join(c.MkDir(), "filecheckpoint")
-	fileChkp := NewFileCheckpointsDB(path)
+	fileChkp, err := NewFileCheckpointsDB(path)
+	c.Assert(err, IsNil)
 	fileChkp.checkpoints.Checkpoints["a"] = &TableCheckpointModel{
 		Status:  uint32(CheckpointStatusLoaded),
 		Engines: map[int32]*EngineCheckpointModel{},
 	}
 	fileChkp.Close()
 
-	fileChkp2 := NewFileCheckpointsDB(path)
+	fileChkp2, err := NewFileCheckpointsDB(path)
+	c.Assert(err, IsNil)
 	// if not recover empty map explicitly, it will become nil
 	c.Assert(fileChkp2.checkpoints.Checkpoints["a"].Engines, NotNil)
 }
+
+func (s *checkpointSuite) TestCheckpointFileCorruption(c *C) {
+	ctx := context.Background()
+	path := filepath.Join(c.MkDir(), "filecheckpoint")
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	expectInit := func() {
+		mock.ExpectExec("CREATE DATABASE IF NOT EXISTS `lightning_task_info`").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS `lightning_task_info`\\.`file_checkpoints` .+").
+			WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	expectLoad := func(rows *sqlmock.Rows) {
+		expectInit()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT content FROM `lightning_task_info`\\.`file_checkpoints` WHERE path = \\?").
+			WithArgs(path).
+			WillReturnRows(rows)
+		mock.ExpectCommit()
+	}
+	expectSave := func() {
+		mock.ExpectExec("REPLACE INTO `lightning_task_info`\\.`file_checkpoints` \\(path, content\\) VALUES \\(\\?, \\?\\)").
+			WithArgs(path, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	// a missing file without a copy in the target starts afresh.
+	expectLoad(sqlmock.NewRows([]string{"content"}))
+	fileChkp, err := NewFileCheckpointsDBWithTarget(ctx, path, db)
+	c.Assert(err, IsNil)
+	c.Assert(fileChkp.checkpoints.Checkpoints, HasLen, 0)
+
+	// every version of the file is copied into the target.
+	expectSave()
+	fileChkp.checkpoints.Checkpoints["a"] = &TableCheckpointModel{Status: uint32(CheckpointStatusLoaded)}
+	c.Assert(fileChkp.save(), IsNil)
+	expectSave()
+	fileChkp.checkpoints.Checkpoints["b"] = &TableCheckpointModel{Status: uint32(CheckpointStatusLoaded)}
+	c.Assert(fileChkp.save(), IsNil)
+
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(bytes.HasPrefix(content, checkpointFileMagic), IsTrue)
+
+	// a partially written file is detected, and salvaged from the target.
+	c.Assert(ioutil.WriteFile(path, content[:len(content)/2], 0644), IsNil)
+	var model CheckpointsModel
+	c.Assert(errors.Cause(loadCheckpointFile(path, &model)), Equals, errCorruptedCheckpointFile)
+	expectLoad(sqlmock.NewRows([]string{"content"}).AddRow(content))
+	fileChkp2, err := NewFileCheckpointsDBWithTarget(ctx, path, db)
+	c.Assert(err, IsNil)
+	c.Assert(fileChkp2.checkpoints.Checkpoints, HasLen, 2)
+	c.Assert(fileChkp2.checkpoints.Checkpoints["a"], NotNil)
+
+	// a missing file is salvaged too.
+	c.Assert(os.Remove(path), IsNil)
+	expectLoad(sqlmock.NewRows([]string{"content"}).AddRow(content))
+	fileChkp3, err := NewFileCheckpointsDBWithTarget(ctx, path, db)
+	c.Assert(err, IsNil)
+	c.Assert(fileChkp3.checkpoints.Checkpoints, HasLen, 2)
+
+	// if the copy is corrupted too, the checkpoints cannot be salvaged.
+	c.Assert(ioutil.WriteFile(path, content[:len(content)-1], 0644), IsNil)
+	expectLoad(sqlmock.NewRows([]string{"content"}).AddRow([]byte("garbage")))
+	_, err = NewFileCheckpointsDBWithTarget(ctx, path, db)
+	c.Assert(err, ErrorMatches, "cannot salvage the checkpoint file .* from the target .*")
+
+	// a corrupted file is not salvaged without the target.
+	_, err = NewFileCheckpointsDB(path)
+	c.Assert(err, ErrorMatches, "checkpoint file .* is corrupted .*")
+
+	// removing the checkpoints removes the copy too.
+	mock.ExpectExec("DELETE FROM `lightning_task_info`\\.`file_checkpoints` WHERE path = \\?").
+		WithArgs(path).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	c.Assert(fileChkp3.RemoveCheckpoint(ctx, "all"), IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), IsTrue)
+
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *checkpointSuite) TestLegacyCheckpointFile(c *C) {
+	path := filepath.Join(c.MkDir(), "filecheckpoint")
+	model := CheckpointsModel{
+		TaskCheckpoint: &TaskCheckpointModel{TaskId: 123},
+		Checkpoints:    map[string]*TableCheckpointModel{"a": {Status: uint32(CheckpointStatusLoaded)}},
+	}
+	serialized, err := model.Marshal()
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(path, serialized, 0644), IsNil)
+
+	fileChkp, err := NewFileCheckpointsDB(path)
+	c.Assert(err, IsNil)
+	c.Assert(fileChkp.checkpoints.TaskCheckpoint.TaskId, Equals, int64(123))
+	c.Assert(fileChkp.checkpoints.Checkpoints["a"], NotNil)
+}
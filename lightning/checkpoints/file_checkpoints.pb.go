@@ -29,6 +29,12 @@ type CheckpointsModel struct {
 	// key is table_name
 	Checkpoints    map[string]*TableCheckpointModel `protobuf:"bytes,1,rep,name=checkpoints,proto3" json:"checkpoints,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	TaskCheckpoint *TaskCheckpointModel             `protobuf:"bytes,2,opt,name=task_checkpoint,json=taskCheckpoint,proto3" json:"task_checkpoint,omitempty"`
+	// tables whose schema has been created
+	CreatedTables []string `protobuf:"bytes,3,rep,name=created_tables,json=createdTables,proto3" json:"created_tables,omitempty"`
+	// fingerprint of the filter rules the filtered_files are decided under
+	FilterFingerprint string `protobuf:"bytes,4,opt,name=filter_fingerprint,json=filterFingerprint,proto3" json:"filter_fingerprint,omitempty"`
+	// source files filtered out by the file routers and the table filters
+	FilteredFiles []string `protobuf:"bytes,5,rep,name=filtered_files,json=filteredFiles,proto3" json:"filtered_files,omitempty"`
 }
 
 func (m *CheckpointsModel) Reset()         { *m = CheckpointsModel{} }
@@ -203,6 +209,7 @@ type ChunkCheckpointModel struct {
 	Type              int32   `protobuf:"varint,14,opt,name=type,proto3" json:"type,omitempty"`
 	Compression       int32   `protobuf:"varint,15,opt,name=compression,proto3" json:"compression,omitempty"`
 	SortKey           string  `protobuf:"bytes,16,opt,name=sort_key,json=sortKey,proto3" json:"sort_key,omitempty"`
+	Worker            int64   `protobuf:"varint,17,opt,name=worker,proto3" json:"worker,omitempty"`
 }
 
 func (m *ChunkCheckpointModel) Reset()         { *m = ChunkCheckpointModel{} }
@@ -254,57 +261,57 @@ func init() {
 }
 
 var fileDescriptor_deb32a9bf46ada61 = []byte{
-	// 787 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0xcf, 0x6f, 0x1c, 0x35,
-	0x14, 0xce, 0xec, 0x64, 0x27, 0xbb, 0x9e, 0x4d, 0xba, 0x35, 0x69, 0x31, 0x01, 0x56, 0xc3, 0xc2,
-	0x61, 0x11, 0xed, 0x46, 0x2a, 0x17, 0x54, 0xc1, 0x81, 0x24, 0x95, 0xa8, 0xa2, 0x8a, 0xc8, 0x2a,
-	0x17, 0x2e, 0x23, 0xcf, 0xd8, 0xd9, 0x19, 0xcd, 0x0f, 0x8f, 0x6c, 0xcf, 0xd0, 0x3d, 0x73, 0x46,
-	0xe2, 0x1f, 0xe1, 0x4f, 0xe0, 0xde, 0x63, 0x8f, 0x1c, 0x21, 0xb9, 0xf3, 0x37, 0x20, 0xbf, 0x99,
-	0xb0, 0xb3, 0xd5, 0xaa, 0xea, 0xcd, 0xef, 0xfb, 0xbe, 0xf7, 0xd9, 0xef, 0xf9, 0xd9, 0xe8, 0x51,
-	0x9e, 0xae, 0x12, 0x53, 0xa6, 0xe5, 0xea, 0x34, 0x4e, 0x44, 0x9c, 0x55, 0x32, 0x2d, 0x8d, 0x3e,
-	0xbd, 0x4e, 0x73, 0x11, 0xf6, 0x80, 0x65, 0xa5, 0xa4, 0x91, 0x27, 0x8f, 0x57, 0xa9, 0x49, 0xea,
-	0x68, 0x19, 0xcb, 0xe2, 0x74, 0x25, 0x57, 0xf2, 0x14, 0xe0, 0xa8, 0xbe, 0x86, 0x08, 0x02, 0x58,
-	0xb5, 0xf2, 0xf9, 0xbf, 0x0e, 0x9a, 0x9e, 0x6f, 0x4c, 0x5e, 0x48, 0x2e, 0x72, 0x7c, 0x81, 0xfc,
-	0x9e, 0x31, 0x71, 0x02, 0x77, 0xe1, 0x3f, 0x99, 0x2f, 0xdf, 0xd6, 0xf5, 0x81, 0x67, 0xa5, 0x51,
-	0x6b, 0xda, 0x4f, 0xc3, 0xdf, 0xa1, 0x7b, 0x86, 0xe9, 0xac, 0x77, 0x46, 0x32, 0x08, 0x9c, 0x85,
-	0xff, 0xe4, 0x78, 0xf9, 0x92, 0xe9, 0x6c, 0x93, 0x0c, 0x66, 0xf4, 0xc8, 0x6c, 0x81, 0x27, 0x3f,
-	0x6d, 0x1d, 0x0c, 0xfc, 0xf1, 0x14, 0xb9, 0x99, 0x58, 0x13, 0x27, 0x70, 0x16, 0x63, 0x6a, 0x97,
-	0xf8, 0x2b, 0x34, 0x6c, 0x58, 0x5e, 0x8b, 0xce, 0xfa, 0xc1, 0xf2, 0x25, 0x8b, 0x72, 0xf1, 0xb6,
-	0x77, 0xab, 0x79, 0x3a, 0xf8, 0xc6, 0x99, 0xff, 0x3a, 0x40, 0x1f, 0xec, 0xd8, 0x1e, 0x7f, 0x88,
-	0x0e, 0xe0, 0xb4, 0x29, 0x07, 0x7b, 0x97, 0x7a, 0x36, 0x7c, 0xce, 0xf1, 0xa7, 0x08, 0x69, 0x59,
-	0xab, 0x58, 0x84, 0x3c, 0x55, 0xb0, 0xcd, 0x98, 0x8e, 0x5b, 0xe4, 0x22, 0x55, 0x98, 0xa0, 0x83,
-	0x88, 0xc5, 0x99, 0x28, 0x39, 0x71, 0x81, 0xbb, 0x0b, 0xf1, 0xe7, 0xe8, 0x30, 0x2d, 0x2a, 0xa9,
-	0x8c, 0x50, 0x21, 0xe3, 0x5c, 0x91, 0x7d, 0xe0, 0x27, 0x77, 0xe0, 0xf7, 0x9c, 0x2b, 0xfc, 0x31,
-	0x1a, 0x9b, 0x94, 0x47, 0x61, 0x22, 0xb5, 0x21, 0x43, 0x10, 0x8c, 0x2c, 0xf0, 0x83, 0xd4, 0xe6,
-	0x7f, 0xd2, 0xea, 0x89, 0x17, 0x38, 0x8b, 0x61, 0x4b, 0x5e, 0x49, 0x65, 0xec, 0x81, 0x2b, 0xde,
-	0x1a, 0x1f, 0x40, 0x9e, 0x57, 0x71, 0xb0, 0x9c, 0xa3, 0x43, 0x6d, 0x37, 0xe0, 0x61, 0xd6, 0xc0,
-	0x99, 0x47, 0x40, 0xfb, 0x2d, 0x78, 0xd9, 0x5c, 0xa4, 0x6a, 0xfe, 0xdb, 0x00, 0x1d, 0xef, 0xea,
-	0x14, 0xc6, 0x68, 0x3f, 0x61, 0x3a, 0x81, 0x1e, 0x4c, 0x28, 0xac, 0xf1, 0x43, 0xe4, 0x69, 0xc3,
-	0x4c, 0xad, 0xa1, 0xc2, 0x43, 0xda, 0x45, 0xb6, 0x33, 0x2c, 0xcf, 0x65, 0x1c, 0x46, 0x4c, 0x0b,
-	0xa8, 0xce, 0xa5, 0x63, 0x40, 0xce, 0x98, 0x16, 0xf8, 0x5b, 0x74, 0x20, 0xca, 0x55, 0x5a, 0x0a,
-	0x4d, 0x46, 0xdd, 0x04, 0xed, 0xda, 0x72, 0xf9, 0xac, 0x15, 0xb5, 0x13, 0x74, 0x97, 0x62, 0xfb,
-	0x6a, 0xac, 0xfa, 0xf9, 0x05, 0x19, 0x83, 0xf3, 0x5d, 0x78, 0x42, 0xd1, 0xa4, 0x9f, 0xd2, 0x1f,
-	0x8a, 0xfb, 0xed, 0x50, 0x3c, 0xda, 0x1e, 0x8a, 0x87, 0xdd, 0x16, 0xef, 0x98, 0x8a, 0x3f, 0x1d,
-	0xf4, 0x60, 0xa7, 0xa8, 0x57, 0xbc, 0xb3, 0x55, 0xfc, 0x53, 0xe4, 0xc5, 0x49, 0x5d, 0x66, 0x9a,
-	0x0c, 0xba, 0xe2, 0x76, 0xe6, 0x2f, 0xcf, 0x41, 0xd4, 0x16, 0xd7, 0x65, 0x9c, 0x5c, 0x21, 0xbf,
-	0x07, 0xbf, 0xcf, 0x54, 0x83, 0xfc, 0x1d, 0xe7, 0xff, 0xc3, 0x45, 0xc7, 0xbb, 0x34, 0xf6, 0x3e,
-	0x2b, 0x66, 0x92, 0xce, 0x1c, 0xd6, 0xb6, 0x24, 0x79, 0x7d, 0xad, 0x45, 0xfb, 0x1e, 0x5d, 0xda,
-	0x45, 0xf8, 0x31, 0xc2, 0xb1, 0xcc, 0xeb, 0xa2, 0x0c, 0x2b, 0xa1, 0x8a, 0xda, 0x30, 0x93, 0xca,
-	0x92, 0x4c, 0x02, 0x77, 0x31, 0xa4, 0xf7, 0x5b, 0xe6, 0x6a, 0x43, 0xd8, 0xeb, 0x17, 0x25, 0x0f,
-	0x3b, 0xab, 0x61, 0x7b, 0xfd, 0xa2, 0xe4, 0x3f, 0xb6, 0x6e, 0x53, 0xe4, 0x56, 0x52, 0xc3, 0xd8,
-	0xba, 0xd4, 0x2e, 0xf1, 0x17, 0xe8, 0xa8, 0x52, 0xa2, 0x09, 0x95, 0xfc, 0x25, 0xe5, 0x61, 0xc1,
-	0x5e, 0xc1, 0xe0, 0xba, 0x74, 0x62, 0x51, 0x6a, 0xc1, 0x17, 0xec, 0x95, 0x1d, 0xfa, 0x8d, 0x60,
-	0x04, 0x82, 0x91, 0xea, 0x91, 0x59, 0x13, 0x87, 0xd1, 0xda, 0x08, 0x0d, 0x73, 0xb1, 0x4f, 0x47,
-	0x59, 0x13, 0x9f, 0xd9, 0xd8, 0xbe, 0x08, 0x4b, 0x66, 0x8d, 0x26, 0x08, 0x28, 0x2f, 0x6b, 0xe2,
-	0xcb, 0x46, 0xe3, 0xcf, 0xd0, 0xc4, 0x12, 0xf0, 0x11, 0xe9, 0xba, 0x20, 0x7e, 0xe0, 0x2c, 0x3c,
-	0xea, 0x67, 0x4d, 0x7c, 0xde, 0x41, 0xf8, 0x13, 0xfb, 0xd4, 0x0a, 0xa1, 0x0d, 0x2b, 0x2a, 0x72,
-	0x18, 0x38, 0x8b, 0x29, 0xdd, 0x00, 0xb6, 0x8b, 0x66, 0x5d, 0x09, 0x72, 0x04, 0x6f, 0x10, 0xd6,
-	0x38, 0x40, 0x7e, 0x2c, 0x8b, 0x4a, 0x09, 0xad, 0x6d, 0x9b, 0xee, 0x01, 0xd5, 0x87, 0xf0, 0x47,
-	0x68, 0x64, 0xdf, 0x5c, 0x68, 0x2f, 0x77, 0xda, 0xfe, 0x0d, 0x36, 0xbe, 0x14, 0xeb, 0xb3, 0x2f,
-	0x5f, 0xff, 0x33, 0xdb, 0x7b, 0x7d, 0x33, 0x73, 0xde, 0xdc, 0xcc, 0x9c, 0xbf, 0x6f, 0x66, 0xce,
-	0xef, 0xb7, 0xb3, 0xbd, 0x37, 0xb7, 0xb3, 0xbd, 0xbf, 0x6e, 0x67, 0x7b, 0x3f, 0xf7, 0xbf, 0xd1,
-	0xc8, 0x83, 0x8f, 0xfa, 0xeb, 0xff, 0x02, 0x00, 0x00, 0xff, 0xff, 0x55, 0x2a, 0x75, 0x30, 0x07,
-	0x06, 0x00, 0x00,
+	// 785 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8d, 0x54, 0x4b, 0x6f, 0xd3, 0x40,
+	0x10, 0x6e, 0x92, 0xe6, 0xb5, 0x49, 0xda, 0x74, 0x69, 0x8b, 0x09, 0x50, 0x95, 0x00, 0x12, 0x88,
+	0x36, 0x91, 0xe0, 0x82, 0x2a, 0x38, 0xd0, 0x87, 0x44, 0x55, 0x55, 0x54, 0x56, 0xb9, 0x70, 0xb1,
+	0x1c, 0x7b, 0x13, 0x5b, 0x89, 0xbd, 0x96, 0x77, 0x1d, 0x9a, 0x33, 0x67, 0x24, 0x2e, 0xfc, 0x0c,
+	0x7e, 0x02, 0xf7, 0x1e, 0x7b, 0xe4, 0xc8, 0xe3, 0x8f, 0x30, 0x3b, 0xeb, 0x10, 0xa7, 0x8a, 0x2a,
+	0x0e, 0x2b, 0xcd, 0x7c, 0xdf, 0xcc, 0xec, 0xcc, 0xee, 0xb7, 0x4b, 0x76, 0x46, 0xfe, 0xc0, 0x93,
+	0xa1, 0x1f, 0x0e, 0xba, 0x8e, 0xc7, 0x9c, 0x61, 0xc4, 0xfd, 0x50, 0x8a, 0x6e, 0xdf, 0x1f, 0x31,
+	0x2b, 0x03, 0x74, 0xa2, 0x98, 0x4b, 0xde, 0xda, 0x1d, 0xf8, 0xd2, 0x4b, 0x7a, 0x1d, 0x87, 0x07,
+	0xdd, 0x01, 0x1f, 0xf0, 0x2e, 0xc2, 0xbd, 0xa4, 0x8f, 0x1e, 0x3a, 0x68, 0xe9, 0xf0, 0xf6, 0xd7,
+	0x3c, 0x69, 0x1e, 0xcc, 0x8a, 0x9c, 0x72, 0x97, 0x8d, 0xe8, 0x21, 0xa9, 0x65, 0x0a, 0x1b, 0xb9,
+	0xed, 0xc2, 0x93, 0xda, 0xf3, 0x76, 0xe7, 0x7a, 0x5c, 0x16, 0x38, 0x0a, 0x65, 0x3c, 0x31, 0xb3,
+	0x69, 0xf4, 0x35, 0x59, 0x95, 0xb6, 0x18, 0x66, 0x7a, 0x34, 0xf2, 0xdb, 0x39, 0xa8, 0xb4, 0xde,
+	0x39, 0x07, 0x7c, 0x96, 0x8c, 0xc5, 0xcc, 0x15, 0x39, 0x07, 0xd2, 0xc7, 0x64, 0xc5, 0x89, 0x99,
+	0x2d, 0x99, 0x6b, 0x49, 0xbb, 0x37, 0x62, 0xc2, 0x28, 0x40, 0x1f, 0x55, 0xb3, 0x91, 0xa2, 0xe7,
+	0x08, 0xb6, 0xde, 0xcf, 0xf5, 0x8f, 0x6d, 0xd0, 0x26, 0x29, 0x0c, 0xd9, 0x04, 0xfa, 0xce, 0x41,
+	0xbc, 0x32, 0xe9, 0x33, 0x52, 0x1c, 0xdb, 0xa3, 0x84, 0xa5, 0x1d, 0x6c, 0x74, 0x30, 0xfb, 0x7a,
+	0x0b, 0x3a, 0x66, 0x2f, 0xff, 0x32, 0xd7, 0xfe, 0x94, 0x27, 0xb7, 0x16, 0x74, 0x49, 0x6f, 0x93,
+	0x32, 0x0e, 0xe5, 0xbb, 0x58, 0xbe, 0x60, 0x96, 0x94, 0x7b, 0xec, 0xd2, 0xfb, 0x84, 0x08, 0x9e,
+	0xc4, 0x0e, 0xb3, 0x5c, 0x3f, 0xc6, 0x6d, 0xaa, 0x66, 0x55, 0x23, 0x87, 0x7e, 0x4c, 0x0d, 0x52,
+	0xee, 0xd9, 0xce, 0x90, 0x85, 0x2e, 0x8c, 0xa1, 0xb8, 0xa9, 0x4b, 0x1f, 0x92, 0x86, 0x1f, 0x44,
+	0x3c, 0x96, 0x2c, 0xb6, 0x6c, 0xd7, 0x8d, 0x8d, 0x65, 0xe4, 0xeb, 0x53, 0xf0, 0x0d, 0x60, 0xf4,
+	0x2e, 0xa9, 0x4a, 0xdf, 0xed, 0x59, 0x1e, 0x17, 0xd2, 0x28, 0x62, 0x40, 0x45, 0x01, 0x6f, 0xc1,
+	0xff, 0x47, 0xaa, 0x78, 0xa3, 0x04, 0x64, 0x51, 0x93, 0x67, 0xe0, 0xab, 0x86, 0x23, 0x57, 0x17,
+	0x2e, 0x63, 0x5e, 0x29, 0x72, 0xb1, 0x64, 0x9b, 0x34, 0x84, 0xda, 0xc0, 0xb5, 0x86, 0x63, 0xec,
+	0xb9, 0x82, 0x74, 0x4d, 0x83, 0x27, 0x63, 0xe8, 0xba, 0xfd, 0x39, 0x4f, 0xd6, 0x17, 0x9d, 0x14,
+	0xa5, 0x64, 0xd9, 0xb3, 0x85, 0x87, 0x67, 0x50, 0x37, 0xd1, 0xa6, 0x9b, 0xa4, 0x24, 0xa4, 0x2d,
+	0x13, 0x81, 0x13, 0x36, 0xcc, 0xd4, 0x53, 0x27, 0x63, 0x8f, 0x46, 0xdc, 0xb1, 0x7a, 0xb6, 0x60,
+	0x38, 0x5d, 0xc1, 0xac, 0x22, 0xb2, 0x0f, 0x00, 0x7d, 0x45, 0xca, 0x2c, 0x1c, 0xf8, 0x21, 0x5c,
+	0x70, 0x25, 0x15, 0xda, 0xa2, 0x2d, 0x3b, 0x47, 0x3a, 0x48, 0x0b, 0x6d, 0x9a, 0xa2, 0xce, 0x15,
+	0xd5, 0x71, 0x7c, 0x68, 0x54, 0xb1, 0xf2, 0xd4, 0x6d, 0x99, 0xa4, 0x9e, 0x4d, 0xc9, 0x8a, 0x62,
+	0x4d, 0x8b, 0x62, 0x67, 0x5e, 0x14, 0x9b, 0xe9, 0x16, 0x37, 0xa8, 0xe2, 0x7b, 0x8e, 0x6c, 0x2c,
+	0x0c, 0xca, 0x0c, 0x9f, 0x9b, 0x1b, 0x7e, 0x8f, 0x94, 0x1c, 0x2f, 0x09, 0x87, 0x02, 0x36, 0xd1,
+	0xc3, 0x2d, 0xcc, 0x87, 0xa7, 0xa4, 0x82, 0xf4, 0x70, 0x69, 0x46, 0xeb, 0x8c, 0xd4, 0x32, 0xf0,
+	0xff, 0xa8, 0x1a, 0xc3, 0x6f, 0xe8, 0xff, 0x5b, 0x81, 0xac, 0x2f, 0x8a, 0x51, 0xf7, 0x19, 0xd9,
+	0xd2, 0x4b, 0x8b, 0xa3, 0xad, 0x46, 0xe2, 0xfd, 0xbe, 0x60, 0xfa, 0xd9, 0x82, 0xd2, 0xb5, 0x47,
+	0x77, 0x09, 0x75, 0xf8, 0x28, 0x09, 0x42, 0x2b, 0x62, 0x71, 0x90, 0xc0, 0x9c, 0x3e, 0x0f, 0x8d,
+	0x3a, 0x8c, 0x57, 0x34, 0xd7, 0x34, 0x73, 0x36, 0x23, 0xd4, 0xf5, 0x83, 0xcc, 0xad, 0xb4, 0x54,
+	0x51, 0x5f, 0x3f, 0x20, 0xef, 0x74, 0x35, 0x98, 0x2a, 0xe2, 0x02, 0x65, 0x5b, 0x30, 0x95, 0x49,
+	0x1f, 0x91, 0x95, 0x28, 0x66, 0x63, 0x2b, 0xe6, 0x1f, 0x7d, 0xd7, 0x0a, 0xec, 0x0b, 0x14, 0x6e,
+	0xc1, 0xac, 0x2b, 0xd4, 0x54, 0xe0, 0xa9, 0x7d, 0xa1, 0x44, 0x3f, 0x0b, 0xa8, 0x60, 0x40, 0x25,
+	0xce, 0x90, 0xc3, 0x31, 0x08, 0x6e, 0x22, 0x41, 0x55, 0x4a, 0x17, 0xcb, 0x66, 0x05, 0x80, 0x7d,
+	0xe5, 0xab, 0x17, 0xa1, 0xc8, 0xe1, 0x58, 0x18, 0x04, 0xa9, 0x12, 0xb8, 0x27, 0x63, 0x41, 0x1f,
+	0x90, 0xba, 0x22, 0xf0, 0xbf, 0x12, 0x49, 0x60, 0xd4, 0x80, 0x2d, 0x99, 0x35, 0xc0, 0x0e, 0x52,
+	0x88, 0xde, 0x53, 0x4f, 0x2d, 0x60, 0x70, 0xb9, 0x41, 0x64, 0x34, 0x80, 0x6f, 0x9a, 0x33, 0x40,
+	0x9d, 0xa2, 0x9c, 0x44, 0xcc, 0x58, 0xc1, 0x37, 0x88, 0x36, 0xdd, 0x86, 0xbf, 0x94, 0x07, 0xd0,
+	0xba, 0x10, 0xea, 0x98, 0x56, 0x91, 0xca, 0x42, 0xf4, 0x0e, 0xa9, 0xa8, 0x37, 0x67, 0xa9, 0xcb,
+	0x6d, 0xea, 0xbf, 0x41, 0xf9, 0x27, 0x6c, 0xb2, 0xff, 0xf4, 0xf2, 0xd7, 0xd6, 0xd2, 0xe5, 0xef,
+	0xad, 0xdc, 0x15, 0xac, 0x9f, 0xb0, 0xbe, 0xfc, 0xd9, 0x5a, 0xba, 0x82, 0xf5, 0x03, 0xd6, 0x87,
+	0xec, 0x6f, 0xdb, 0x2b, 0xe1, 0x7f, 0xfe, 0xe2, 0x2f, 0x94, 0xb1, 0xbd, 0x40, 0x2e, 0x06, 0x00,
+	0x00,
 }
 
 func (m *CheckpointsModel) Marshal() (dAtA []byte, err error) {
@@ -327,6 +334,31 @@ func (m *CheckpointsModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.FilteredFiles) > 0 {
+		for iNdEx := len(m.FilteredFiles) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.FilteredFiles[iNdEx])
+			copy(dAtA[i:], m.FilteredFiles[iNdEx])
+			i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.FilteredFiles[iNdEx])))
+			i--
+			dAtA[i] = 0x2a
+		}
+	}
+	if len(m.FilterFingerprint) > 0 {
+		i -= len(m.FilterFingerprint)
+		copy(dAtA[i:], m.FilterFingerprint)
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.FilterFingerprint)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if len(m.CreatedTables) > 0 {
+		for iNdEx := len(m.CreatedTables) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.CreatedTables[iNdEx])
+			copy(dAtA[i:], m.CreatedTables[iNdEx])
+			i = encodeVarintFileCheckpoints(dAtA, i, uint64(len(m.CreatedTables[iNdEx])))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
 	if m.TaskCheckpoint != nil {
 		{
 			size, err := m.TaskCheckpoint.MarshalToSizedBuffer(dAtA[:i])
@@ -586,6 +618,13 @@ func (m *ChunkCheckpointModel) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if m.Worker != 0 {
+		i = encodeVarintFileCheckpoints(dAtA, i, uint64(m.Worker))
+		i--
+		dAtA[i] = 0x1
+		i--
+		dAtA[i] = 0x88
+	}
 	if len(m.SortKey) > 0 {
 		i -= len(m.SortKey)
 		copy(dAtA[i:], m.SortKey)
@@ -715,6 +754,22 @@ func (m *CheckpointsModel) Size() (n int) {
 		l = m.TaskCheckpoint.Size()
 		n += 1 + l + sovFileCheckpoints(uint64(l))
 	}
+	if len(m.CreatedTables) > 0 {
+		for _, s := range m.CreatedTables {
+			l = len(s)
+			n += 1 + l + sovFileCheckpoints(uint64(l))
+		}
+	}
+	l = len(m.FilterFingerprint)
+	if l > 0 {
+		n += 1 + l + sovFileCheckpoints(uint64(l))
+	}
+	if len(m.FilteredFiles) > 0 {
+		for _, s := range m.FilteredFiles {
+			l = len(s)
+			n += 1 + l + sovFileCheckpoints(uint64(l))
+		}
+	}
 	return n
 }
 
@@ -871,6 +926,9 @@ func (m *ChunkCheckpointModel) Size() (n int) {
 	if l > 0 {
 		n += 2 + l + sovFileCheckpoints(uint64(l))
 	}
+	if m.Worker != 0 {
+		n += 2 + sovFileCheckpoints(uint64(m.Worker))
+	}
 	return n
 }
 
@@ -1074,6 +1132,102 @@ func (m *CheckpointsModel) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field CreatedTables", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.CreatedTables = append(m.CreatedTables, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FilterFingerprint", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.FilterFingerprint = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field FilteredFiles", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthFileCheckpoints
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.FilteredFiles = append(m.FilteredFiles, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
@@ -2204,6 +2358,25 @@ func (m *ChunkCheckpointModel) Unmarshal(dAtA []byte) error {
 			}
 			m.SortKey = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 17:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Worker", wireType)
+			}
+			m.Worker = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowFileCheckpoints
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Worker |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipFileCheckpoints(dAtA[iNdEx:])
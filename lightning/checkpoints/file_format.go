@@ -0,0 +1,234 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// The checkpoint file is laid out as
+//
+//	magic | gzip(protobuf of CheckpointsModel) | crc32 of the gzip payload
+//
+// Files not starting with the magic are in the legacy format, which is the
+// uncompressed protobuf.
+var checkpointFileMagic = []byte("LCPZ\x01")
+
+const checkpointFileFooterSize = 4
+
+var errCorruptedCheckpointFile = errors.New("checkpoint file is corrupted")
+
+const (
+	// checkpointSalvageSchema is the schema on the target keeping a copy of
+	// the checkpoint files, to salvage the checkpoints from if the local file
+	// is missing or corrupted.
+	checkpointSalvageSchema = "lightning_task_info"
+	checkpointSalvageTable  = "file_checkpoints"
+)
+
+var errNoSalvageCopy = errors.New("no copy of the checkpoint file on the target")
+
+func encodeCheckpointFile(model *CheckpointsModel) ([]byte, error) {
+	serialized, err := model.Marshal()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(checkpointFileMagic)
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(serialized); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var footer [checkpointFileFooterSize]byte
+	binary.BigEndian.PutUint32(footer[:], crc32.ChecksumIEEE(buf.Bytes()[len(checkpointFileMagic):]))
+	buf.Write(footer[:])
+	return buf.Bytes(), nil
+}
+
+func decodeCheckpointFile(content []byte, model *CheckpointsModel) error {
+	if !bytes.HasPrefix(content, checkpointFileMagic) {
+		if err := model.Unmarshal(content); err != nil {
+			return errors.Annotate(errCorruptedCheckpointFile, err.Error())
+		}
+		return nil
+	}
+
+	content = content[len(checkpointFileMagic):]
+	if len(content) < checkpointFileFooterSize {
+		return errors.Annotate(errCorruptedCheckpointFile, "missing CRC footer")
+	}
+	payload := content[:len(content)-checkpointFileFooterSize]
+	expected := binary.BigEndian.Uint32(content[len(payload):])
+	if actual := crc32.ChecksumIEEE(payload); actual != expected {
+		return errors.Annotatef(errCorruptedCheckpointFile, "CRC mismatch, expected %08x, got %08x", expected, actual)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return errors.Annotate(errCorruptedCheckpointFile, err.Error())
+	}
+	serialized, err := ioutil.ReadAll(gz)
+	if err != nil {
+		return errors.Annotate(errCorruptedCheckpointFile, err.Error())
+	}
+	if err := model.Unmarshal(serialized); err != nil {
+		return errors.Annotate(errCorruptedCheckpointFile, err.Error())
+	}
+	return nil
+}
+
+func loadCheckpointFile(path string, model *CheckpointsModel) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotatef(decodeCheckpointFile(content, model), "file %s", path)
+}
+
+// writeCheckpointFile replaces the checkpoint file atomically.
+func writeCheckpointFile(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Trace(err)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpPath, path))
+}
+
+// checkpointSalvage keeps the copy of a checkpoint file in the target, keyed by
+// the path of the file. The copy is in the same format as the file, so it is
+// validated by the CRC when loaded.
+type checkpointSalvage struct {
+	db          *sql.DB
+	path        string
+	initialized bool
+}
+
+func (s *checkpointSalvage) exec() common.SQLWithRetry {
+	return common.SQLWithRetry{
+		DB:           s.db,
+		Logger:       log.With(zap.String("path", s.path)),
+		HideQueryLog: true,
+	}
+}
+
+func (s *checkpointSalvage) tableName() string {
+	return common.UniqueTable(checkpointSalvageSchema, checkpointSalvageTable)
+}
+
+func (s *checkpointSalvage) init(ctx context.Context) error {
+	if s.initialized {
+		return nil
+	}
+	var schema strings.Builder
+	common.WriteMySQLIdentifier(&schema, checkpointSalvageSchema)
+	exec := s.exec()
+	err := exec.Exec(ctx, "create checkpoint salvage database", "CREATE DATABASE IF NOT EXISTS "+schema.String()+";")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	err = exec.Exec(ctx, "create checkpoint salvage table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			path varchar(512) NOT NULL PRIMARY KEY,
+			content longblob NOT NULL,
+			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);
+	`, s.tableName()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	s.initialized = true
+	return nil
+}
+
+// save replaces the copy of the checkpoint file with the content.
+func (s *checkpointSalvage) save(ctx context.Context, content []byte) error {
+	if err := s.init(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.exec().Exec(ctx, "save checkpoint salvage copy",
+		fmt.Sprintf("REPLACE INTO %s (path, content) VALUES (?, ?);", s.tableName()),
+		s.path, content))
+}
+
+// load decodes the copy of the checkpoint file into the model. Returns
+// errNoSalvageCopy if the target has no copy.
+func (s *checkpointSalvage) load(ctx context.Context, model *CheckpointsModel) error {
+	if err := s.init(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	var content []byte
+	found := false
+	err := s.exec().Transact(ctx, "load checkpoint salvage copy", func(c context.Context, tx *sql.Tx) error {
+		err := tx.QueryRowContext(c,
+			fmt.Sprintf("SELECT content FROM %s WHERE path = ?;", s.tableName()),
+			s.path,
+		).Scan(&content)
+		// sql.ErrNoRows is not a MySQL error, and would be retried.
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		found = err == nil
+		return errors.Trace(err)
+	})
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if !found {
+		return errNoSalvageCopy
+	}
+	return errors.Annotatef(decodeCheckpointFile(content, model), "copy in %s", s.tableName())
+}
+
+// remove drops the copy of the checkpoint file, so it is not salvaged from
+// after the checkpoints are removed.
+func (s *checkpointSalvage) remove(ctx context.Context) error {
+	if err := s.init(ctx); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(s.exec().Exec(ctx, "remove checkpoint salvage copy",
+		fmt.Sprintf("DELETE FROM %s WHERE path = ?;", s.tableName()),
+		s.path))
+}
@@ -0,0 +1,387 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checkpoints
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// SnapshotName is the name of the snapshot of the checkpoints in the external
+// storage. Each export replaces the previous snapshot.
+const SnapshotName = "checkpoint-snapshot.pb"
+
+// ExportSnapshot writes a consistent snapshot of the checkpoints into the
+// storage, in the format of the checkpoint file of the file driver, whatever
+// the driver of the checkpoints is.
+func ExportSnapshot(ctx context.Context, cpdb CheckpointsDB, store storage.ExternalStorage) error {
+	snapshot, err := cpdb.Snapshot(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	content, err := encodeCheckpointFile(snapshot)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Annotate(store.Write(ctx, SnapshotName, content), "export checkpoint snapshot")
+}
+
+// ImportSnapshot replaces the checkpoints by the snapshot in the storage,
+// which is exported by ExportSnapshot.
+func ImportSnapshot(ctx context.Context, cpdb CheckpointsDB, store storage.ExternalStorage) error {
+	content, err := store.Read(ctx, SnapshotName)
+	if err != nil {
+		return errors.Annotate(err, "read checkpoint snapshot")
+	}
+	snapshot := &CheckpointsModel{}
+	if err := decodeCheckpointFile(content, snapshot); err != nil {
+		return errors.Annotatef(err, "snapshot %s", SnapshotName)
+	}
+	initEmptyMaps(snapshot)
+	return errors.Trace(cpdb.RestoreSnapshot(ctx, snapshot))
+}
+
+func (*NullCheckpointsDB) Snapshot(context.Context) (*CheckpointsModel, error) {
+	return nil, errors.Trace(cannotManageNullDB)
+}
+
+func (*NullCheckpointsDB) RestoreSnapshot(context.Context, *CheckpointsModel) error {
+	return errors.Trace(cannotManageNullDB)
+}
+
+// Snapshot reads all checkpoint tables in a single transaction, so the
+// snapshot is consistent.
+func (cpdb *MySQLCheckpointsDB) Snapshot(ctx context.Context) (*CheckpointsModel, error) {
+	var snapshot *CheckpointsModel
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	err := s.Transact(ctx, "read checkpoint snapshot", func(c context.Context, tx *sql.Tx) error {
+		snapshot = &CheckpointsModel{
+			TaskCheckpoint: &TaskCheckpointModel{},
+			Checkpoints:    map[string]*TableCheckpointModel{},
+		}
+
+		// 1. The task checkpoint, which is missing before the task is initialized.
+
+		task := snapshot.TaskCheckpoint
+		err := tx.QueryRowContext(c, fmt.Sprintf(`
+			SELECT task_id, source_dir, backend, importer_addr, tidb_host, tidb_port, pd_addr, sorted_kv_dir
+			FROM %s.%s WHERE id = 1;
+		`, cpdb.schema, CheckpointTableNameTask)).Scan(
+			&task.TaskId, &task.SourceDir, &task.Backend, &task.ImporterAddr,
+			&task.TidbHost, &task.TidbPort, &task.PdAddr, &task.SortedKvDir,
+		)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.Trace(err)
+		}
+
+		// 2. The tables.
+
+		tableRows, err := tx.QueryContext(c, fmt.Sprintf(`
+			SELECT table_name, hash, status, alloc_base, table_id FROM %s.%s;
+		`, cpdb.schema, CheckpointTableNameTable))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer tableRows.Close()
+		for tableRows.Next() {
+			var (
+				tableName string
+				table     = &TableCheckpointModel{Engines: map[int32]*EngineCheckpointModel{}}
+			)
+			if err := tableRows.Scan(&tableName, &table.Hash, &table.Status, &table.AllocBase, &table.TableID); err != nil {
+				return errors.Trace(err)
+			}
+			snapshot.Checkpoints[tableName] = table
+		}
+		if err := tableRows.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		// 3. The engines.
+
+		engineRows, err := tx.QueryContext(c, fmt.Sprintf(`
+			SELECT table_name, engine_id, status FROM %s.%s;
+		`, cpdb.schema, CheckpointTableNameEngine))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer engineRows.Close()
+		for engineRows.Next() {
+			var (
+				tableName string
+				engineID  int32
+				engine    = &EngineCheckpointModel{Chunks: map[string]*ChunkCheckpointModel{}}
+			)
+			if err := engineRows.Scan(&tableName, &engineID, &engine.Status); err != nil {
+				return errors.Trace(err)
+			}
+			table, ok := snapshot.Checkpoints[tableName]
+			if !ok {
+				return errors.Errorf("the engine checkpoint %s:%d has no table checkpoint", tableName, engineID)
+			}
+			table.Engines[engineID] = engine
+		}
+		if err := engineRows.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		// 4. The chunks.
+
+		chunkRows, err := tx.QueryContext(c, fmt.Sprintf(`
+			SELECT
+				table_name, engine_id, path, offset, type, compression, sort_key, columns,
+				pos, end_offset, prev_rowid_max, rowid_max,
+				kvc_bytes, kvc_kvs, kvc_checksum, worker, unix_timestamp(create_time)
+			FROM %s.%s;
+		`, cpdb.schema, CheckpointTableNameChunk))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer chunkRows.Close()
+		for chunkRows.Next() {
+			var (
+				tableName string
+				engineID  int32
+				colPerm   []byte
+				chunk     = &ChunkCheckpointModel{}
+			)
+			if err := chunkRows.Scan(
+				&tableName, &engineID, &chunk.Path, &chunk.Offset, &chunk.Type, &chunk.Compression,
+				&chunk.SortKey, &colPerm, &chunk.Pos, &chunk.EndOffset, &chunk.PrevRowidMax, &chunk.RowidMax,
+				&chunk.KvcBytes, &chunk.KvcKvs, &chunk.KvcChecksum, &chunk.Worker, &chunk.Timestamp,
+			); err != nil {
+				return errors.Trace(err)
+			}
+			var columnPermutation []int
+			if err := json.Unmarshal(colPerm, &columnPermutation); err != nil {
+				return errors.Trace(err)
+			}
+			chunk.ColumnPermutation = intSlice2Int32Slice(columnPermutation)
+			table, ok := snapshot.Checkpoints[tableName]
+			if !ok || table.Engines[engineID] == nil {
+				return errors.Errorf("the chunk checkpoint %s has no engine checkpoint %s:%d", chunk.Path, tableName, engineID)
+			}
+			key := ChunkCheckpointKey{Path: chunk.Path, Offset: chunk.Offset}
+			table.Engines[engineID].Chunks[key.String()] = chunk
+		}
+		if err := chunkRows.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		// 5. The tables whose schema has been created.
+
+		schemaRows, err := tx.QueryContext(c, fmt.Sprintf(`
+			SELECT table_name FROM %s.%s ORDER BY table_name;
+		`, cpdb.schema, CheckpointTableNameSchema))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer schemaRows.Close()
+		for schemaRows.Next() {
+			var tableName string
+			if err := schemaRows.Scan(&tableName); err != nil {
+				return errors.Trace(err)
+			}
+			snapshot.CreatedTables = append(snapshot.CreatedTables, tableName)
+		}
+		if err := schemaRows.Err(); err != nil {
+			return errors.Trace(err)
+		}
+
+		// 6. The filtered files, which are recorded under a single fingerprint.
+
+		filteredRows, err := tx.QueryContext(c, fmt.Sprintf(`
+			SELECT fingerprint, path FROM %s.%s ORDER BY path;
+		`, cpdb.schema, CheckpointTableNameFiltered))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer filteredRows.Close()
+		for filteredRows.Next() {
+			var path string
+			if err := filteredRows.Scan(&snapshot.FilterFingerprint, &path); err != nil {
+				return errors.Trace(err)
+			}
+			snapshot.FilteredFiles = append(snapshot.FilteredFiles, path)
+		}
+		return errors.Trace(filteredRows.Err())
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot replaces the content of all checkpoint tables in a single
+// transaction.
+func (cpdb *MySQLCheckpointsDB) RestoreSnapshot(ctx context.Context, snapshot *CheckpointsModel) error {
+	s := common.SQLWithRetry{DB: cpdb.db, Logger: log.L()}
+	return s.Transact(ctx, "restore checkpoint snapshot", func(c context.Context, tx *sql.Tx) error {
+		for _, tableName := range []string{
+			CheckpointTableNameTask,
+			CheckpointTableNameTable,
+			CheckpointTableNameEngine,
+			CheckpointTableNameChunk,
+			CheckpointTableNameSchema,
+			CheckpointTableNameFiltered,
+		} {
+			if _, err := tx.ExecContext(c, fmt.Sprintf("DELETE FROM %s.%s;", cpdb.schema, tableName)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		taskID := cpdb.taskID
+		if task := snapshot.TaskCheckpoint; task != nil && task.TaskId != 0 {
+			taskID = task.TaskId
+			_, err := tx.ExecContext(c, fmt.Sprintf(`
+				INSERT INTO %s.%s (id, task_id, source_dir, backend, importer_addr, tidb_host, tidb_port, pd_addr, sorted_kv_dir)
+				VALUES (1, ?, ?, ?, ?, ?, ?, ?, ?);
+			`, cpdb.schema, CheckpointTableNameTask),
+				task.TaskId, task.SourceDir, task.Backend, task.ImporterAddr,
+				task.TidbHost, task.TidbPort, task.PdAddr, task.SortedKvDir,
+			)
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+
+		tableStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT INTO %s.%s (task_id, table_name, hash, status, alloc_base, table_id) VALUES (?, ?, ?, ?, ?, ?);
+		`, cpdb.schema, CheckpointTableNameTable))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer tableStmt.Close()
+
+		engineStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT INTO %s.%s (table_name, engine_id, status) VALUES (?, ?, ?);
+		`, cpdb.schema, CheckpointTableNameEngine))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer engineStmt.Close()
+
+		chunkStmt, err := tx.PrepareContext(c, fmt.Sprintf(`
+			INSERT INTO %s.%s (
+				table_name, engine_id,
+				path, offset, type, compression, sort_key, columns, should_include_row_id,
+				pos, end_offset, prev_rowid_max, rowid_max,
+				kvc_bytes, kvc_kvs, kvc_checksum, worker, create_time
+			) VALUES (
+				?, ?,
+				?, ?, ?, ?, ?, ?, FALSE,
+				?, ?, ?, ?,
+				?, ?, ?, ?, from_unixtime(?)
+			);
+		`, cpdb.schema, CheckpointTableNameChunk))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer chunkStmt.Close()
+
+		for tableName, table := range snapshot.Checkpoints {
+			// the hash column is not nullable.
+			hash := table.Hash
+			if hash == nil {
+				hash = []byte{}
+			}
+			if _, err := tableStmt.ExecContext(c, taskID, tableName, hash, table.Status, table.AllocBase, table.TableID); err != nil {
+				return errors.Trace(err)
+			}
+			for engineID, engine := range table.Engines {
+				if _, err := engineStmt.ExecContext(c, tableName, engineID, engine.Status); err != nil {
+					return errors.Trace(err)
+				}
+				for _, chunk := range engine.Chunks {
+					columnPerm := make([]int, 0, len(chunk.ColumnPermutation))
+					for _, col := range chunk.ColumnPermutation {
+						columnPerm = append(columnPerm, int(col))
+					}
+					colPerm, err := json.Marshal(columnPerm)
+					if err != nil {
+						return errors.Trace(err)
+					}
+					_, err = chunkStmt.ExecContext(
+						c, tableName, engineID,
+						chunk.Path, chunk.Offset, chunk.Type, chunk.Compression, chunk.SortKey, colPerm,
+						chunk.Pos, chunk.EndOffset, chunk.PrevRowidMax, chunk.RowidMax,
+						chunk.KvcBytes, chunk.KvcKvs, chunk.KvcChecksum, chunk.Worker, chunk.Timestamp,
+					)
+					if err != nil {
+						return errors.Trace(err)
+					}
+				}
+			}
+		}
+
+		for _, tableName := range snapshot.CreatedTables {
+			if _, err := tx.ExecContext(c, fmt.Sprintf(`
+				INSERT IGNORE INTO %s.%s (table_name) VALUES (?);
+			`, cpdb.schema, CheckpointTableNameSchema), tableName); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		for _, path := range snapshot.FilteredFiles {
+			if _, err := tx.ExecContext(c, fmt.Sprintf(`
+				INSERT IGNORE INTO %s.%s (fingerprint, path) VALUES (?, ?);
+			`, cpdb.schema, CheckpointTableNameFiltered), snapshot.FilterFingerprint, path); err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	})
+}
+
+// Snapshot copies the checkpoints in memory, which are always consistent.
+func (cpdb *FileCheckpointsDB) Snapshot(context.Context) (*CheckpointsModel, error) {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	return cloneCheckpointsModel(&cpdb.checkpoints)
+}
+
+// RestoreSnapshot replaces the checkpoints in memory and saves them into the
+// checkpoint file.
+func (cpdb *FileCheckpointsDB) RestoreSnapshot(_ context.Context, snapshot *CheckpointsModel) error {
+	cpdb.lock.Lock()
+	defer cpdb.lock.Unlock()
+
+	model, err := cloneCheckpointsModel(snapshot)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	initEmptyMaps(model)
+	cpdb.checkpoints = *model
+	return errors.Trace(cpdb.save())
+}
+
+func cloneCheckpointsModel(model *CheckpointsModel) (*CheckpointsModel, error) {
+	serialized, err := model.Marshal()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	clone := &CheckpointsModel{}
+	if err := clone.Unmarshal(serialized); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return clone, nil
+}
@@ -149,6 +149,11 @@ func (tc *TLS) GetJSON(path string, v interface{}) error {
 	return GetJSON(tc.client, tc.url+path, v)
 }
 
+// SendJSON sends the request of the method with v encoded as the JSON body.
+func (tc *TLS) SendJSON(method string, path string, v interface{}) error {
+	return SendJSON(tc.client, method, tc.url+path, v)
+}
+
 func (tc *TLS) ToPDSecurityOption() pd.SecurityOption {
 	return pd.SecurityOption{
 		CAPath:   tc.caPath,
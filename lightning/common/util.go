@@ -14,6 +14,7 @@
 package common
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
@@ -294,6 +295,40 @@ func GetJSON(client *http.Client, url string, v interface{}) error {
 	return errors.Trace(json.NewDecoder(resp.Body).Decode(v))
 }
 
+// SendJSON sends the request of the method with v encoded as the JSON body, or
+// without the body if v is nil. Any 2xx status is regarded as success.
+func SendJSON(client *http.Client, method string, url string, v interface{}) error {
+	var body io.Reader
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if v != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Errorf("%s %s http status code %d, message %s", method, url, resp.StatusCode, string(message))
+	}
+	return nil
+}
+
 // KillMySelf sends sigint to current process, used in integration test only
 //
 // Only works on Unix. Signaling on Windows is not supported.
@@ -83,6 +83,35 @@ func (s *utilSuite) TestGetJSON(c *C) {
 	c.Assert(err, ErrorMatches, ".*http status code != 200.*")
 }
 
+func (s *utilSuite) TestSendJSON(c *C) {
+	type TestPayload struct {
+		Username string `json:"username"`
+	}
+	var received []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var payload TestPayload
+		if req.Method == http.MethodPost {
+			c.Assert(req.Header.Get("Content-Type"), Equals, "application/json")
+			c.Assert(json.NewDecoder(req.Body).Decode(&payload), IsNil)
+		}
+		received = append(received, req.Method+" "+req.URL.Path+" "+payload.Username)
+		if req.URL.Path == "/missing" {
+			res.WriteHeader(http.StatusNotFound)
+			_, _ = res.Write([]byte("not found"))
+		}
+	}))
+	defer testServer.Close()
+
+	client := &http.Client{Timeout: time.Second}
+	err := common.SendJSON(client, http.MethodPost, testServer.URL+"/users", &TestPayload{Username: "lightning"})
+	c.Assert(err, IsNil)
+	err = common.SendJSON(client, http.MethodDelete, testServer.URL+"/users", nil)
+	c.Assert(err, IsNil)
+	err = common.SendJSON(client, http.MethodDelete, testServer.URL+"/missing", nil)
+	c.Assert(err, ErrorMatches, "DELETE .*/missing http status code 404, message not found")
+	c.Assert(received, DeepEquals, []string{"POST /users lightning", "DELETE /users ", "DELETE /missing "})
+}
+
 func (s *utilSuite) TestIsRetryableError(c *C) {
 	c.Assert(common.IsRetryableError(context.Canceled), IsFalse)
 	c.Assert(common.IsRetryableError(context.DeadlineExceeded), IsFalse)
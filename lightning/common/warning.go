@@ -0,0 +1,174 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	// WarningDeprecatedConfig is reported when a deprecated config item is used.
+	WarningDeprecatedConfig = "deprecated-config"
+	// WarningSkippedFile is reported when a source file is ignored on purpose,
+	// e.g. views which Lightning does not restore.
+	WarningSkippedFile = "skipped-file"
+	// WarningFilteredTable is reported when a table is excluded by the
+	// attribute-based table filters.
+	WarningFilteredTable = "filtered-table"
+	// WarningMissingColumn is reported when a column of the target table is
+	// absent in the data file and filled with the default value.
+	WarningMissingColumn = "missing-column"
+	// WarningHungRead is reported when a read on a source file makes no
+	// progress within the read timeout and is retried.
+	WarningHungRead = "hung-read"
+	// WarningRetriedRead is reported when a failed read of the data source,
+	// e.g. a transient EIO, is retried.
+	WarningRetriedRead = "retried-read"
+	// WarningStagingRule is reported when the placement rule of the staging
+	// stores cannot be deleted after the table is imported.
+	WarningStagingRule = "staging-rule"
+	// WarningVersionConflict is reported when rows with duplicated keys are
+	// resolved by the version column.
+	WarningVersionConflict = "version-conflict"
+	// WarningOversizedRow is reported when a row exceeding the max row size is
+	// skipped, truncated or routed to a sidecar file.
+	WarningOversizedRow = "oversized-row"
+	// WarningOverlongValue is reported when a row with a value longer than
+	// the column is skipped or truncated.
+	WarningOverlongValue = "overlong-value"
+	// WarningCompactFailed is reported when the compaction of a table after
+	// import failed, which leaves the compaction to TiKV itself.
+	WarningCompactFailed = "compact-failed"
+	// WarningSourceCharset is reported when a source file is detected to be
+	// in a charset other than the expected one.
+	WarningSourceCharset = "source-charset"
+	// WarningTTLPaused is reported when the TTL jobs of a table are left
+	// disabled because the import of the table failed.
+	WarningTTLPaused = "ttl-paused"
+	// WarningTemporaryTable is reported when the data files of a temporary
+	// table are skipped.
+	WarningTemporaryTable = "temporary-table"
+	// WarningSchemaDrift is reported when the schema files of the shards
+	// merged into the same table are not equivalent.
+	WarningSchemaDrift = "schema-drift"
+	// WarningSortedKVDirSpace is reported when the free space of the
+	// sorted-kv-dir is forecast to be insufficient.
+	WarningSortedKVDirSpace = "sorted-kv-dir-space"
+	// WarningUnfinishedDump is reported when the metadata file of the dump
+	// does not record the end of the dump.
+	WarningUnfinishedDump = "unfinished-dump"
+	// WarningInconsistentDump is reported when a consistent snapshot is
+	// expected but the dump was taken without consistency.
+	WarningInconsistentDump = "inconsistent-dump"
+)
+
+// Warning is an aggregated non-fatal issue found during the import.
+type Warning struct {
+	Table   string `json:"table,omitempty"`
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+type warningKey struct {
+	table   string
+	kind    string
+	message string
+}
+
+// WarningCollector aggregates warnings by table, kind and message, so a
+// repeated issue is reported once with a count instead of flooding the log.
+//
+// The zero value is ready for use.
+type WarningCollector struct {
+	mu     sync.Mutex
+	counts map[warningKey]int
+}
+
+// Record adds a warning to the collector. The warning is logged only on its
+// first occurrence.
+func (wc *WarningCollector) Record(table, kind, message string) {
+	key := warningKey{table: table, kind: kind, message: message}
+
+	wc.mu.Lock()
+	if wc.counts == nil {
+		wc.counts = make(map[warningKey]int)
+	}
+	wc.counts[key]++
+	first := wc.counts[key] == 1
+	wc.mu.Unlock()
+
+	if first {
+		log.L().Warn(message, zap.String("table", table), zap.String("kind", kind))
+	}
+}
+
+// Reset removes all recorded warnings.
+func (wc *WarningCollector) Reset() {
+	wc.mu.Lock()
+	wc.counts = nil
+	wc.mu.Unlock()
+}
+
+// Summary returns all recorded warnings sorted by table, kind and message.
+func (wc *WarningCollector) Summary() []Warning {
+	wc.mu.Lock()
+	res := make([]Warning, 0, len(wc.counts))
+	for key, count := range wc.counts {
+		res = append(res, Warning{Table: key.table, Kind: key.kind, Message: key.message, Count: count})
+	}
+	wc.mu.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := &res[i], &res[j]
+		if a.Table != b.Table {
+			return a.Table < b.Table
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Message < b.Message
+	})
+	return res
+}
+
+// EmitLog writes the summary of all warnings into the log.
+func (wc *WarningCollector) EmitLog(logger log.Logger) {
+	summary := wc.Summary()
+	if len(summary) == 0 {
+		return
+	}
+	logger.Warn("warnings found during import", zap.Int("count", len(summary)))
+	for _, w := range summary {
+		logger.Warn("-",
+			zap.String("table", w.Table),
+			zap.String("kind", w.Kind),
+			zap.String("message", w.Message),
+			zap.Int("count", w.Count),
+		)
+	}
+}
+
+// Warnings is the warning collector of the current task.
+var Warnings WarningCollector
+
+// RecordWarning adds a warning to the collector of the current task.
+func RecordWarning(table, kind, message string) {
+	Warnings.Record(table, kind, message)
+}
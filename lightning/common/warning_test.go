@@ -0,0 +1,43 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common_test
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+)
+
+var _ = Suite(&warningSuite{})
+
+type warningSuite struct{}
+
+func (s *warningSuite) TestWarningCollector(c *C) {
+	var wc common.WarningCollector
+	c.Assert(wc.Summary(), HasLen, 0)
+
+	wc.Record("`db`.`b`", common.WarningMissingColumn, "column c missing")
+	wc.Record("`db`.`a`", common.WarningMissingColumn, "column c missing")
+	wc.Record("`db`.`b`", common.WarningMissingColumn, "column c missing")
+	wc.Record("", common.WarningDeprecatedConfig, "black-white-list is deprecated")
+
+	c.Assert(wc.Summary(), DeepEquals, []common.Warning{
+		{Table: "", Kind: common.WarningDeprecatedConfig, Message: "black-white-list is deprecated", Count: 1},
+		{Table: "`db`.`a`", Kind: common.WarningMissingColumn, Message: "column c missing", Count: 1},
+		{Table: "`db`.`b`", Kind: common.WarningMissingColumn, Message: "column c missing", Count: 2},
+	})
+
+	wc.Reset()
+	c.Assert(wc.Summary(), HasLen, 0)
+}
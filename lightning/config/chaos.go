@@ -0,0 +1,108 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+const enableChaosFlag = "enable-chaos"
+
+// Chaos injects failures at specific points of the import, so that the
+// operators can rehearse the recovery from the checkpoints before the real
+// import. It is only set by the hidden command line flags behind
+// `--enable-chaos`, and never from the config file.
+type Chaos struct {
+	// FailAfterEngineImports fails after the given number of engines are
+	// imported, counting both the data and the index engines.
+	FailAfterEngineImports int `json:"fail-after-engine-imports"`
+	// FailDuringChecksum fails the checksum of the given table, or of every
+	// table if it is "*".
+	FailDuringChecksum string `json:"fail-during-checksum"`
+	// Kill kills the process at the failure instead of returning an error,
+	// which rehearses a crash rather than a failed table.
+	Kill bool `json:"kill"`
+}
+
+// Enabled returns whether any failure is injected.
+func (c *Chaos) Enabled() bool {
+	return c.FailAfterEngineImports > 0 || len(c.FailDuringChecksum) > 0
+}
+
+// chaosFlags are the hidden flags, which are only accepted with
+// `--enable-chaos` and not shown by `-h`.
+type chaosFlags struct {
+	enable                 *bool
+	failAfterEngineImports *int
+	failDuringChecksum     *string
+	kill                   *bool
+}
+
+func registerChaosFlags(fs *flag.FlagSet) *chaosFlags {
+	return &chaosFlags{
+		enable:                 fs.Bool(enableChaosFlag, false, "allow injecting failures to rehearse the recovery"),
+		failAfterEngineImports: fs.Int("chaos-fail-after-engine-imports", 0, "fail after the given number of engines are imported"),
+		failDuringChecksum:     fs.String("chaos-fail-during-checksum", "", "fail the checksum of the given table, or of every table if '*'"),
+		kill:                   fs.Bool("chaos-kill", false, "kill the process at the injected failure instead of returning an error"),
+	}
+}
+
+func isChaosFlag(name string) bool {
+	return name == enableChaosFlag || strings.HasPrefix(name, "chaos-")
+}
+
+// hideChaosFlags prints the usage of the flag set without the chaos flags.
+func hideChaosFlags(fs *flag.FlagSet) {
+	fs.Usage = func() {
+		visible := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		visible.SetOutput(fs.Output())
+		fs.VisitAll(func(f *flag.Flag) {
+			if !isChaosFlag(f.Name) {
+				visible.Var(f.Value, f.Name, f.Usage)
+			}
+		})
+		if len(fs.Name()) == 0 {
+			fmt.Fprintln(fs.Output(), "Usage:")
+		} else {
+			fmt.Fprintf(fs.Output(), "Usage of %s:\n", fs.Name())
+		}
+		visible.PrintDefaults()
+	}
+}
+
+// apply sets the chaos config after the flag set is parsed.
+func (f *chaosFlags) apply(fs *flag.FlagSet, chaos *Chaos) error {
+	if !*f.enable {
+		var err error
+		fs.Visit(func(set *flag.Flag) {
+			if err == nil && isChaosFlag(set.Name) && set.Name != enableChaosFlag {
+				err = errors.Errorf("the flag -%s requires --%s", set.Name, enableChaosFlag)
+			}
+		})
+		return err
+	}
+	if *f.failAfterEngineImports < 0 {
+		return errors.New("-chaos-fail-after-engine-imports must not be negative")
+	}
+	*chaos = Chaos{
+		FailAfterEngineImports: *f.failAfterEngineImports,
+		FailDuringChecksum:     *f.failDuringChecksum,
+		Kill:                   *f.kill,
+	}
+	return nil
+}
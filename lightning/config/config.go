@@ -14,17 +14,21 @@
 package config
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/url"
+	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/coreos/go-semver/semver"
 	gomysql "github.com/go-sql-driver/mysql"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/parser/mysql"
@@ -43,6 +47,16 @@ const (
 	// NormalMode defines mode of normal for tikv.
 	NormalMode = "normal"
 
+	// RunModeImport runs Lightning to import the data source.
+	RunModeImport = "import"
+	// RunModePrecheckData runs Lightning to only parse the whole data source
+	// and report the issues found, without writing into the target.
+	RunModePrecheckData = "precheck-data"
+	// RunModeSimulateImpact runs Lightning to only analyze the headroom of the
+	// target cluster from its metrics in Prometheus, and recommend the
+	// concurrency and rate limit of the import, without writing into the target.
+	RunModeSimulateImpact = "simulate-impact"
+
 	// BackendTiDB is a constant for choosing the "TiDB" backend in the configuration.
 	BackendTiDB = "tidb"
 	// BackendImporter is a constant for choosing the "Importer" backend in the configuration.
@@ -62,11 +76,63 @@ const (
 	IgnoreOnDup = "ignore"
 	// ErrorOnDup indicates using INSERT INTO to insert data, which would violate PK or UNIQUE constraint
 	ErrorOnDup = "error"
+
+	// OversizedRowError fails the import on an oversized row.
+	OversizedRowError = "error"
+	// OversizedRowSkip skips the oversized rows and reports them as warnings.
+	OversizedRowSkip = "skip"
+	// OversizedRowTruncate truncates the `mydumper.truncate-columns` of the
+	// oversized rows to fit the size limit.
+	OversizedRowTruncate = "truncate"
+	// OversizedRowRoute writes the oversized rows into the files under
+	// `mydumper.oversized-row-dir` for manual handling.
+	OversizedRowRoute = "route"
+
+	// OverlongValueNone leaves the values longer than the columns to the
+	// encoder, which fails or truncates them depending on the SQL mode.
+	OverlongValueNone = "none"
+	// OverlongValueError fails the import on a value longer than the column.
+	OverlongValueError = "error"
+	// OverlongValueSkip skips the rows with any value longer than the column
+	// and reports them as warnings.
+	OverlongValueSkip = "skip"
+	// OverlongValueTruncate truncates the values to the length of the columns
+	// and reports them as warnings.
+	OverlongValueTruncate = "truncate"
+
+	// StreamSourceDir is the `mydumper.data-source-dir` reading the data of
+	// `mydumper.stream-table` from stdin.
+	StreamSourceDir = "-"
+	// StreamFormatSQL is the default format of the stream, which consists of
+	// INSERT statements, e.g. the output of `mysqldump --no-create-info`.
+	StreamFormatSQL = "sql"
+	// StreamFormatCSV is the format of the stream given by `mydumper.csv`.
+	StreamFormatCSV = "csv"
+
+	// CSVFormatCSV is the default format of `mydumper.csv`, whose fields are
+	// separated and quoted as configured.
+	CSVFormatCSV = "csv"
+	// CSVFormatTSV is the tab-separated format without quoting, written by
+	// `SELECT ... INTO OUTFILE` of MySQL by default.
+	CSVFormatTSV = "tsv"
+
+	// JSONMissingKeyDefault fills the default values into the columns missing
+	// from a JSON object, like the columns omitted from an INSERT statement.
+	JSONMissingKeyDefault = "default"
+	// JSONMissingKeyNull fills NULL into the columns missing from a JSON object.
+	JSONMissingKeyNull = "null"
+	// JSONMissingKeyError fails the import on a JSON object missing any column.
+	JSONMissingKeyError = "error"
+	// JSONExtraKeyIgnore ignores the keys of a JSON object which are not columns.
+	JSONExtraKeyIgnore = "ignore"
+	// JSONExtraKeyError fails the import on a JSON object with any key which
+	// is not a column.
+	JSONExtraKeyError = "error"
 )
 
 var (
 	defaultConfigPaths    = []string{"tidb-lightning.toml", "conf/tidb-lightning.toml"}
-	supportedStorageTypes = []string{"file", "local", "s3"}
+	supportedStorageTypes = []string{"file", "local", "s3", "gcs", "gs", "azure", "azblob", "webhdfs", "swebhdfs", "webdav", "webdavs", "sftp", "http", "https", "oss"}
 )
 
 type DBStore struct {
@@ -83,10 +149,56 @@ type DBStore struct {
 	SQLMode          mysql.SQLMode `toml:"-" json:"-"`
 	MaxAllowedPacket uint64        `toml:"max-allowed-packet" json:"max-allowed-packet"`
 
+	// StrTargetVersion pins the version of the target cluster, e.g. "v4.0.8".
+	// The features which differ among the versions are then decided by the
+	// version instead of probing the cluster.
+	StrTargetVersion string          `toml:"target-version" json:"target-version"`
+	TargetVersion    *semver.Version `toml:"-" json:"-"`
+
+	// TableSQLModes overrides the SQL mode used for encoding the rows of
+	// specific tables.
+	TableSQLModes []*TableSQLMode `toml:"table-sql-modes" json:"table-sql-modes"`
+
 	DistSQLScanConcurrency     int `toml:"distsql-scan-concurrency" json:"distsql-scan-concurrency"`
 	BuildStatsConcurrency      int `toml:"build-stats-concurrency" json:"build-stats-concurrency"`
 	IndexSerialScanConcurrency int `toml:"index-serial-scan-concurrency" json:"index-serial-scan-concurrency"`
 	ChecksumTableConcurrency   int `toml:"checksum-table-concurrency" json:"checksum-table-concurrency"`
+
+	// PauseTTLJobs disables the TTL jobs of the tables with TTL options during
+	// the import, so the imported expired rows are not deleted before the
+	// checksum. The jobs are enabled again after the table is imported.
+	PauseTTLJobs bool `toml:"pause-ttl-jobs" json:"pause-ttl-jobs"`
+
+	// DDLUser and DDLPsw are the credentials of the connection executing the
+	// DDL and system variable statements, if they differ from those writing
+	// the data.
+	DDLUser string `toml:"ddl-user" json:"ddl-user"`
+	DDLPsw  string `toml:"ddl-password" json:"-"`
+}
+
+// DDLStore returns the settings of the connection executing the DDL and system
+// variable statements, which logs in with the DDL credentials if configured.
+func (d *DBStore) DDLStore() DBStore {
+	ddl := *d
+	if len(d.DDLUser) > 0 {
+		ddl.User = d.DDLUser
+		ddl.Psw = d.DDLPsw
+	}
+	return ddl
+}
+
+// HasDDLUser returns whether the DDL statements are executed by a separate
+// user.
+func (d *DBStore) HasDDLUser() bool {
+	return len(d.DDLUser) > 0
+}
+
+type TableSQLMode struct {
+	Schema     string `toml:"schema" json:"schema"`
+	Table      string `toml:"table" json:"table"`
+	StrSQLMode string `toml:"sql-mode" json:"sql-mode"`
+
+	SQLMode mysql.SQLMode `toml:"-" json:"-"`
 }
 
 type Config struct {
@@ -100,8 +212,12 @@ type Config struct {
 	TikvImporter TikvImporter        `toml:"tikv-importer" json:"tikv-importer"`
 	PostRestore  PostRestore         `toml:"post-restore" json:"post-restore"`
 	Cron         Cron                `toml:"cron" json:"cron"`
+	Metrics      Metrics             `toml:"metrics" json:"metrics"`
+	Simulate     Simulate            `toml:"simulate" json:"simulate"`
+	Report       Report              `toml:"report" json:"report"`
 	Routes       []*router.TableRule `toml:"routes" json:"routes"`
 	Security     Security            `toml:"security" json:"security"`
+	Chaos        Chaos               `toml:"-" json:"-"`
 
 	BWList filter.MySQLReplicationRules `toml:"black-white-list" json:"black-white-list"`
 }
@@ -125,6 +241,27 @@ type Lightning struct {
 	RegionConcurrency int  `toml:"region-concurrency" json:"region-concurrency"`
 	IOConcurrency     int  `toml:"io-concurrency" json:"io-concurrency"`
 	CheckRequirements bool `toml:"check-requirements" json:"check-requirements"`
+
+	// maximum allowed difference between the local clock and the TSO of PD,
+	// only checked with the local and importer backends. zero (default)
+	// disables the check.
+	MaxClockSkew Duration `toml:"max-clock-skew" json:"max-clock-skew"`
+
+	// the table "schema.table" on the target to report the import progress
+	// of each table into. empty disables the report.
+	ProgressTable string `toml:"progress-table" json:"progress-table"`
+
+	// the duration after which no more tables or engines are started, and the
+	// task exits after the running engines are imported. zero means no limit.
+	MaxDuration Duration `toml:"max-duration" json:"max-duration"`
+
+	// what to run, either "import", "precheck-data" or "simulate-impact".
+	Mode string `toml:"mode" json:"mode"`
+
+	// roll back the changes on the target if the task fails, with the
+	// statements saved in the rollback file.
+	Atomic       bool   `toml:"atomic" json:"atomic"`
+	RollbackFile string `toml:"rollback-file" json:"rollback-file"`
 }
 
 // PostRestore has some options which will be executed after kv restored.
@@ -133,9 +270,34 @@ type PostRestore struct {
 	Compact       bool `toml:"compact" json:"compact"`
 	Checksum      bool `toml:"checksum" json:"checksum"`
 	Analyze       bool `toml:"analyze" json:"analyze"`
+
+	// run the checksum and analyze of the imported tables in the background
+	// with this concurrency, while the index workers continue to import the
+	// remaining tables. 0 runs them in the index workers.
+	ChecksumConcurrency int `toml:"checksum-concurrency" json:"checksum-concurrency"`
+
+	// compact the key ranges of each table after it is imported, which can be
+	// overridden per table.
+	TableCompact  bool                `toml:"table-compact" json:"table-compact"`
+	TableCompacts []*TableCompactRule `toml:"table-compacts" json:"table-compacts"`
+
+	// execute the `*-schema-post.sql` files, e.g. the GRANT and CREATE USER
+	// statements, after all tables are imported.
+	ExecPostSchema bool `toml:"exec-post-schema" json:"exec-post-schema"`
+}
+
+// TableCompactRule requests or skips the compaction of a table after import.
+type TableCompactRule struct {
+	Schema  string `toml:"schema" json:"schema"`
+	Table   string `toml:"table" json:"table"`
+	Compact bool   `toml:"compact" json:"compact"`
 }
 
 type CSVConfig struct {
+	// Format is a preset of the separator and the delimiter, which overrides
+	// them if not "csv". The escaping and the NULL value are configured by
+	// `backslash-escape` and `null` in all formats.
+	Format          string `toml:"format" json:"format"`
 	Separator       string `toml:"separator" json:"separator"`
 	Delimiter       string `toml:"delimiter" json:"delimiter"`
 	Header          bool   `toml:"header" json:"header"`
@@ -145,20 +307,395 @@ type CSVConfig struct {
 	BackslashEscape bool   `toml:"backslash-escape" json:"backslash-escape"`
 }
 
+// JSONConfig is the config of the newline-delimited JSON files, whose lines
+// are the objects keyed by the column names.
+type JSONConfig struct {
+	MissingKey string `toml:"missing-key" json:"missing-key"`
+	ExtraKey   string `toml:"extra-key" json:"extra-key"`
+}
+
+// FixedWidthConfig is the layout of the fixed-width text files, whose fields
+// are at the fixed byte offsets of every record.
+type FixedWidthConfig struct {
+	// the length of every record in bytes. zero means the records are
+	// separated by newlines.
+	RecordLength int                 `toml:"record-length" json:"record-length"`
+	Trim         bool                `toml:"trim" json:"trim"`
+	NotNull      bool                `toml:"not-null" json:"not-null"`
+	Null         string              `toml:"null" json:"null"`
+	Columns      []*FixedWidthColumn `toml:"columns" json:"columns"`
+}
+
+// FixedWidthColumn is a field of the fixed-width records, which is imported
+// into the column of the name.
+type FixedWidthColumn struct {
+	Name   string `toml:"name" json:"name"`
+	Offset int    `toml:"offset" json:"offset"`
+	Width  int    `toml:"width" json:"width"`
+}
+
+// BSONConfig is the mapping of the MongoDB documents to the columns, for both
+// the BSON files of mongodump and the JSON files of mongoexport.
+type BSONConfig struct {
+	// the fields of the documents imported into the columns. If empty, the
+	// top-level fields are imported into the columns of the same names.
+	Fields []*BSONField `toml:"fields" json:"fields"`
+	// the column which receives the JSON object of the top-level fields not
+	// imported into any column. If empty, these fields are dropped.
+	ExtraColumn string `toml:"extra-column" json:"extra-column"`
+}
+
+// BSONField maps a field of the documents, given as the dot-separated path
+// like "address.city" or "tags.0", to the column.
+type BSONField struct {
+	Path   string `toml:"path" json:"path"`
+	Column string `toml:"column" json:"column"`
+}
+
+// ProtobufConfig is the message types of the tables imported from the files
+// of varint-delimited protobuf messages.
+type ProtobufConfig struct {
+	// the FileDescriptorSet compiled by `protoc --include_imports
+	// --descriptor_set_out`, used by the tables not giving their own.
+	DescriptorSet string           `toml:"descriptor-set" json:"descriptor-set"`
+	Tables        []*ProtobufTable `toml:"tables" json:"tables"`
+}
+
+// ProtobufTable is the message type of the records of a table, whose fields
+// are imported into the columns of the same names.
+type ProtobufTable struct {
+	Schema string `toml:"schema" json:"schema"`
+	Table  string `toml:"table" json:"table"`
+	// the full name of the message type, e.g. "events.v1.Event".
+	Message       string `toml:"message" json:"message"`
+	DescriptorSet string `toml:"descriptor-set" json:"descriptor-set"`
+}
+
+// XLSXConfig is the config of the sheets of the Excel workbooks.
+type XLSXConfig struct {
+	// whether the first row of each sheet names the columns.
+	Header bool `toml:"header" json:"header"`
+}
+
 type MydumperRuntime struct {
 	ReadBlockSize    int64            `toml:"read-block-size" json:"read-block-size"`
 	BatchSize        int64            `toml:"batch-size" json:"batch-size"`
 	BatchImportRatio float64          `toml:"batch-import-ratio" json:"batch-import-ratio"`
-	SourceDir        string           `toml:"data-source-dir" json:"data-source-dir"`
+	SourceDir        string           `toml:"-" json:"data-source-dir"`
+	SourceDirs       SourceDirs       `toml:"data-source-dir" json:"data-source-dirs,omitempty"`
 	NoSchema         bool             `toml:"no-schema" json:"no-schema"`
 	CharacterSet     string           `toml:"character-set" json:"character-set"`
 	CSV              CSVConfig        `toml:"csv" json:"csv"`
+	JSON             JSONConfig       `toml:"json" json:"json"`
+	FixedWidth       FixedWidthConfig `toml:"fixed-width" json:"fixed-width"`
+	XLSX             XLSXConfig       `toml:"xlsx" json:"xlsx"`
+	BSON             BSONConfig       `toml:"bson" json:"bson"`
+	Protobuf         ProtobufConfig   `toml:"protobuf" json:"protobuf"`
 	CaseSensitive    bool             `toml:"case-sensitive" json:"case-sensitive"`
 	StrictFormat     bool             `toml:"strict-format" json:"strict-format"`
 	MaxRegionSize    int64            `toml:"max-region-size" json:"max-region-size"`
 	Filter           []string         `toml:"filter" json:"filter"`
 	FileRouters      []*FileRouteRule `toml:"files" json:"files"`
 	DefaultFileRules bool             `toml:"default-file-rules" json:"default-file-rules"`
+
+	// attribute-based table filters, applied after the name-based filter.
+	MaxTableSize       int64    `toml:"max-table-size" json:"max-table-size"`
+	ExcludeColumnTypes []string `toml:"exclude-column-types" json:"exclude-column-types"`
+	// path to a TOML or JSON file mapping table names to lists of tags, and
+	// the tags of which at least one must be present to import a table.
+	TableTagsFile string   `toml:"table-tags-file" json:"table-tags-file"`
+	IncludeTags   []string `toml:"include-tags" json:"include-tags"`
+
+	// timeouts of opening and reading a source file. zero means no timeout.
+	OpenTimeout Duration `toml:"open-timeout" json:"open-timeout"`
+	ReadTimeout Duration `toml:"read-timeout" json:"read-timeout"`
+	ReadRetry   int      `toml:"read-retry" json:"read-retry"`
+
+	// retries of the failed reads of each source file, e.g. on the transient
+	// EIO of an overloaded NFS mount, after the backoff doubled on every
+	// retry. 0 means no retry.
+	ReadErrorRetry   int      `toml:"read-error-retry" json:"read-error-retry"`
+	ReadRetryBackoff Duration `toml:"read-retry-backoff" json:"read-retry-backoff"`
+	// the bytes read from the data source per second. 0 means no limit.
+	ReadBandwidth int64 `toml:"read-bandwidth" json:"read-bandwidth"`
+
+	// the maximum number of the xz files decoded at the same time, since the
+	// xz decoding is CPU heavy. 0 means half of the region concurrency.
+	XZConcurrency int `toml:"xz-concurrency" json:"xz-concurrency"`
+	// the number of the goroutines decoding a gzip file. 1 disables the
+	// parallel decoding. Only the BGZF files are decoded in parallel, and the
+	// other gzip files are decoded by a single goroutine ahead of the parser,
+	// so they do not get faster with more goroutines. 0 means 4.
+	GzipConcurrency int `toml:"gzip-concurrency" json:"gzip-concurrency"`
+
+	ColumnDecoders []*ColumnDecodeRule `toml:"column-decoders" json:"column-decoders"`
+	ColumnMasks    []*ColumnMaskRule   `toml:"column-masks" json:"column-masks"`
+
+	// path to a TOML or JSON file mapping table names to import priorities.
+	TablePriorityFile string `toml:"table-priority-file" json:"table-priority-file"`
+
+	// path to a CSV or JSON file listing the source files, which are then not
+	// listed from the data source.
+	ManifestFile string `toml:"manifest-file" json:"manifest-file"`
+
+	// the subdirectories of data-source-dir holding the incremental dumps,
+	// which are applied in order over the base dump.
+	Generations []string `toml:"generations" json:"generations"`
+
+	// assigns the implicit row IDs of the tables without primary keys in the
+	// order of the source files, which are sorted by their numeric sort keys.
+	PreserveRowOrder bool `toml:"preserve-row-order" json:"preserve-row-order"`
+
+	// groups of related tables imported in the same window and checksummed
+	// together.
+	TableGroups []*TableGroup `toml:"table-groups" json:"table-groups"`
+
+	// import only a deterministic sample of each table. 0 means all rows.
+	SampleRatio float64 `toml:"sample-ratio" json:"sample-ratio"`
+
+	// guard against rows exceeding the entry size limit of TiKV. 0 means no
+	// limit.
+	MaxRowSize      int64    `toml:"max-row-size" json:"max-row-size"`
+	OnOversizedRow  string   `toml:"on-oversized-row" json:"on-oversized-row"`
+	TruncateColumns []string `toml:"truncate-columns" json:"truncate-columns"`
+	OversizedRowDir string   `toml:"oversized-row-dir" json:"oversized-row-dir"`
+
+	// check the strings against the lengths and the charsets of the target
+	// columns before encoding.
+	OnOverlongValue string `toml:"on-overlong-value" json:"on-overlong-value"`
+
+	// detect the charset of each source file from a sample of its content.
+	DetectCharset     bool    `toml:"detect-charset" json:"detect-charset"`
+	CharsetConfidence float64 `toml:"charset-confidence" json:"charset-confidence"`
+
+	// detect the compression of the data files from their magic bytes when
+	// the file routing rules do not tell it.
+	DetectCompression bool `toml:"detect-compression" json:"detect-compression"`
+
+	// read the `-- lightning: key=value, ...` lines at the top of the CSV and
+	// SQL files, which override the parsing settings of each file.
+	FileDirectives bool `toml:"file-directives" json:"file-directives"`
+
+	// warn if the metadata file of the dump tells that the tables were not
+	// dumped from a consistent snapshot.
+	ExpectConsistentSnapshot bool `toml:"expect-consistent-snapshot" json:"expect-consistent-snapshot"`
+
+	// the base64 of the AES-256 key encrypting the objects of the data source
+	// in S3 (SSE-C) or GCS (CSEK).
+	SourceEncryptionKey string `toml:"source-encryption-key" json:"-"`
+
+	// the advanced options of the data source in S3, which BR does not
+	// support.
+	S3 S3Config `toml:"s3" json:"s3"`
+
+	// import a single existing table, given as "db.table", from the stream
+	// of data-source-dir, which is "-" for stdin or the path of a named pipe.
+	StreamTable  string `toml:"stream-table" json:"stream-table"`
+	StreamFormat string `toml:"stream-format" json:"stream-format"`
+}
+
+// SourceDirs is the data sources of `mydumper.data-source-dir`, which is
+// either a single URL or path, or a list of them merged into one import.
+// SourceDir is the data source, or the first of them, and SourceDirs is only
+// kept if there are more than one.
+type SourceDirs []string
+
+// UnmarshalTOML implements toml.Unmarshaler, accepting a single string as the
+// list of one data source.
+func (d *SourceDirs) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case string:
+		*d = SourceDirs{v}
+	case []interface{}:
+		dirs := make(SourceDirs, 0, len(v))
+		for _, item := range v {
+			dir, ok := item.(string)
+			if !ok {
+				return errors.Errorf("invalid config: `data-source-dir` must be a string or a list of strings, but got %v", v)
+			}
+			dirs = append(dirs, dir)
+		}
+		*d = dirs
+	default:
+		return errors.Errorf("invalid config: `data-source-dir` must be a string or a list of strings, but got %v", v)
+	}
+	return nil
+}
+
+// split returns the first data source, and the list only if there are more
+// than one.
+func (d SourceDirs) split() (string, SourceDirs) {
+	switch len(d) {
+	case 0:
+		return "", nil
+	case 1:
+		return d[0], nil
+	default:
+		return d[0], d
+	}
+}
+
+// DataSources returns the data sources merged into one import.
+func (m *MydumperRuntime) DataSources() []string {
+	if len(m.SourceDirs) > 1 {
+		return m.SourceDirs
+	}
+	return []string{m.SourceDir}
+}
+
+// TaskSourceDir returns the data sources recorded in the task checkpoint,
+// i.e. the data source, or the list of them joined by commas.
+func (m *MydumperRuntime) TaskSourceDir() string {
+	return strings.Join(m.DataSources(), ",")
+}
+
+// IsStream returns whether the data source is a stream of a single table.
+func (m *MydumperRuntime) IsStream() bool {
+	return len(m.StreamTable) > 0
+}
+
+// StreamTableName returns the schema and the table name of `stream-table`.
+func (m *MydumperRuntime) StreamTableName() (schema string, table string, err error) {
+	parts := strings.SplitN(m.StreamTable, ".", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", errors.Errorf("invalid config: `mydumper.stream-table` must be in the form of \"db.table\", but got '%s'", m.StreamTable)
+	}
+	return parts[0], parts[1], nil
+}
+
+// SourceCustomerKey returns the decoded source-encryption-key, or nil if it
+// is not set.
+func (m *MydumperRuntime) SourceCustomerKey() ([]byte, error) {
+	if len(m.SourceEncryptionKey) == 0 {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(m.SourceEncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("invalid config: `mydumper.source-encryption-key` must be the base64 of a 256-bit key")
+	}
+	return key, nil
+}
+
+// MarshalJSON implements json.Marshaler, hiding the credentials in the data
+// source URL from the logs and the status API.
+func (m MydumperRuntime) MarshalJSON() ([]byte, error) {
+	type plain MydumperRuntime
+	p := plain(m)
+	p.SourceDir = RedactURL(p.SourceDir)
+	if len(p.SourceDirs) > 0 {
+		p.SourceDirs = make(SourceDirs, 0, len(m.SourceDirs))
+		for _, dir := range m.SourceDirs {
+			p.SourceDirs = append(p.SourceDirs, RedactURL(dir))
+		}
+	}
+	return json.Marshal(p)
+}
+
+// S3Config is the options of the data source in S3 beyond the parameters of
+// the URL. The data source is read by the S3 client of Lightning instead of
+// BR if any of them is set.
+type S3Config struct {
+	// RoleARN is the IAM role assumed to read the bucket, e.g. of another
+	// account, with the credentials of the URL or the default credential
+	// chain of AWS.
+	RoleARN string `toml:"role-arn" json:"role-arn"`
+	// ExternalID is required by the trust policy of the role to be assumed.
+	ExternalID string `toml:"external-id" json:"external-id"`
+	// RequesterPays acknowledges that the requests to the bucket are charged
+	// to the reader rather than the owner of the bucket.
+	RequesterPays bool `toml:"requester-pays" json:"requester-pays"`
+	// BucketEndpoints overrides the endpoint of each bucket by its name, if
+	// the URL of the data source does not give the endpoint.
+	BucketEndpoints map[string]string `toml:"bucket-endpoints" json:"bucket-endpoints"`
+}
+
+// IsSet returns whether any option is set.
+func (c *S3Config) IsSet() bool {
+	return c != nil && (len(c.RoleARN) > 0 || len(c.ExternalID) > 0 || c.RequesterPays || len(c.BucketEndpoints) > 0)
+}
+
+func (c *S3Config) adjust() error {
+	if len(c.RoleARN) > 0 && !strings.HasPrefix(c.RoleARN, "arn:") {
+		return errors.Errorf("invalid config: `mydumper.s3.role-arn` must be an ARN like \"arn:aws:iam::123456789012:role/name\", but got '%s'", c.RoleARN)
+	}
+	if len(c.ExternalID) > 0 && len(c.RoleARN) == 0 {
+		return errors.New("invalid config: `mydumper.s3.external-id` requires `mydumper.s3.role-arn`")
+	}
+	for bucket, endpoint := range c.BucketEndpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.Errorf("invalid config: the endpoint of the bucket '%s' in `mydumper.s3.bucket-endpoints` must be an http or https URL, but got '%s'", bucket, endpoint)
+		}
+	}
+	return nil
+}
+
+// TableGroup is a family of related tables, e.g. the shards of a partitioned
+// table or a set of tables related by foreign keys. The tables are given as
+// table filter patterns like "db.orders_*".
+type TableGroup struct {
+	Name   string   `toml:"name" json:"name"`
+	Tables []string `toml:"tables" json:"tables"`
+}
+
+// ColumnDecodeRule specifies how the raw field values of a column should be
+// decoded before inserting, e.g. decoders = ["base64", "gzip"] for a column
+// storing gzip+base64 payloads.
+type ColumnDecodeRule struct {
+	Schema   string   `toml:"schema" json:"schema"`
+	Table    string   `toml:"table" json:"table"`
+	Column   string   `toml:"column" json:"column"`
+	Decoders []string `toml:"decoders" json:"decoders"`
+}
+
+// The built-in maskers of ColumnMaskRule.
+const (
+	ColumnMaskerHash      = "hash"
+	ColumnMaskerRedact    = "redact"
+	ColumnMaskerShuffle   = "shuffle"
+	ColumnMaskerFakeEmail = "fake-email"
+	ColumnMaskerFakeName  = "fake-name"
+)
+
+// ColumnMaskRule specifies the masker to anonymize the columns matching the
+// pattern "db.tbl.col", where each part may contain wildcards, e.g.
+// "*.users.email".
+type ColumnMaskRule struct {
+	Column string `toml:"column" json:"column"`
+	Masker string `toml:"masker" json:"masker"`
+}
+
+// Match returns whether the column matches the pattern of the rule.
+func (r *ColumnMaskRule) Match(schema, table, column string) bool {
+	return MatchColumnPattern(r.Column, schema, table, column)
+}
+
+// MatchColumnPattern returns whether the column matches the case-insensitive
+// pattern "db.tbl.col", where each part may contain wildcards.
+func MatchColumnPattern(pattern, schema, table, column string) bool {
+	parts := strings.Split(strings.ToLower(pattern), ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for i, name := range []string{schema, table, column} {
+		if ok, err := path.Match(parts[i], strings.ToLower(name)); !ok || err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func checkColumnPattern(item string, pattern string) error {
+	parts := strings.Split(pattern, ".")
+	if len(parts) != 3 {
+		return errors.Errorf("invalid config: `%s` pattern '%s' must be in the form 'db.tbl.col'", item, pattern)
+	}
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return errors.Annotatef(err, "invalid config: `%s` pattern '%s'", item, pattern)
+		}
+	}
+	return nil
 }
 
 type FileRouteRule struct {
@@ -169,6 +706,11 @@ type FileRouteRule struct {
 	Type        string `json:"type" toml:"type" yaml:"type"`
 	Key         string `json:"key" toml:"key" yaml:"key"`
 	Compression string `json:"compression" toml:"compression" yaml:"compression"`
+	// Directives are the parsing settings of the matched files, in the same
+	// syntax as the directive lines at the top of the data files, e.g.
+	// "separator='|', header=false". They let the files of different formats
+	// be restored into the same table.
+	Directives string `json:"directives" toml:"directives" yaml:"directives"`
 }
 
 type TikvImporter struct {
@@ -180,6 +722,24 @@ type TikvImporter struct {
 	RegionSplitSize  int64  `toml:"region-split-size" json:"region-split-size"`
 	SortedKVDir      string `toml:"sorted-kv-dir" json:"sorted-kv-dir"`
 	RangeConcurrency int    `toml:"range-concurrency" json:"range-concurrency"`
+	// VersionColumn resolves rows with duplicated keys by keeping the one with
+	// the larger value in this column. The tables without this column use
+	// OnDuplicate. Only supported by the TiDB backend.
+	VersionColumn string `toml:"version-column" json:"version-column"`
+	// GroupRowsByKey sorts the rows by the primary key before batching them
+	// into INSERT statements, so each statement touches fewer regions. Only
+	// supported by the TiDB backend.
+	GroupRowsByKey bool `toml:"group-rows-by-key" json:"group-rows-by-key"`
+	// VerifyKeyEncoding cross-checks the index keys encoded by Lightning for
+	// the non-binary collation string columns against the target TiDB using
+	// sample rows, before importing each table. Not needed by the TiDB backend.
+	VerifyKeyEncoding bool `toml:"verify-key-encoding" json:"verify-key-encoding"`
+	// StagingStoreLabels places the regions of each table on the TiKV stores
+	// of these labels while the table is imported, so the burst of ingesting
+	// the SSTs lands on the dedicated stores. PD moves the regions back to
+	// all stores after the table is imported. Requires the placement rules
+	// of PD. Not supported by the TiDB backend.
+	StagingStoreLabels map[string]string `toml:"staging-store-labels" json:"staging-store-labels"`
 }
 
 type Checkpoint struct {
@@ -188,11 +748,76 @@ type Checkpoint struct {
 	DSN              string `toml:"dsn" json:"-"` // DSN may contain password, don't expose this to JSON.
 	Driver           string `toml:"driver" json:"driver"`
 	KeepAfterSuccess bool   `toml:"keep-after-success" json:"keep-after-success"`
+	// the URL of the external storage, e.g. "s3://bucket/prefix", which the
+	// snapshots of the checkpoints are periodically exported to, so the task
+	// can be resumed on another host after restoring the latest snapshot by
+	// `tidb-lightning-ctl --restore-checkpoint`. empty disables the export.
+	SnapshotURL string `toml:"snapshot-url" json:"-"` // the URL may contain the credentials.
+	// the interval between the exports of the snapshots.
+	SnapshotInterval Duration `toml:"snapshot-interval" json:"snapshot-interval"`
+	// whether to record the tables imported and checksummed on the target, and
+	// to skip the recorded tables still intact on the target when they have no
+	// progress in the checkpoints, e.g. after the checkpoints are lost.
+	ResumeFromTarget bool `toml:"resume-from-target" json:"resume-from-target"`
 }
 
 type Cron struct {
 	SwitchMode  Duration `toml:"switch-mode" json:"switch-mode"`
 	LogProgress Duration `toml:"log-progress" json:"log-progress"`
+	// ReportProgress is the interval to write the progress into the
+	// `lightning.progress-table`.
+	ReportProgress Duration `toml:"report-progress" json:"report-progress"`
+}
+
+// Report is the config of uploading the report of the task when it ends,
+// either succeeded or failed, so the tasks on the ephemeral runners leave a
+// durable audit trail.
+type Report struct {
+	// the URL of the external storage, e.g. "s3://bucket/prefix". The names
+	// of the files of each task are prefixed with the task ID. empty disables
+	// the upload.
+	UploadURL string `toml:"upload-url" json:"-"` // the URL may contain the credentials.
+	// the size of the tail of the log file uploaded with the report. zero
+	// disables the upload of the log.
+	LogTailSize int64 `toml:"log-tail-size" json:"log-tail-size"`
+	// whether to upload the snapshot of the checkpoints.
+	Checkpoints bool `toml:"checkpoints" json:"checkpoints"`
+}
+
+// Metrics is the config of pushing the metrics, for the environments like the
+// batch jobs where Prometheus cannot scrape the status address.
+type Metrics struct {
+	// the URL of the Prometheus Pushgateway. empty disables the push.
+	PushGatewayAddr string `toml:"push-gateway-addr" json:"push-gateway-addr"`
+	// the URL of the Prometheus remote write receiver. empty disables the
+	// remote write.
+	RemoteWriteURL string `toml:"remote-write-url" json:"remote-write-url"`
+	// the job label of the pushed metrics.
+	Job string `toml:"job" json:"job"`
+	// the labels added to all pushed metrics, e.g. to tell the instances.
+	Labels map[string]string `toml:"labels" json:"labels"`
+	// the interval between the pushes. The metrics are always pushed once
+	// more when the task ends.
+	PushInterval Duration `toml:"push-interval" json:"push-interval"`
+}
+
+// Simulate is the config of the simulate-impact mode, which simulates the
+// impact of the import on the target cluster from the historical metrics.
+type Simulate struct {
+	// the URLs of the Prometheus servers scraping the target cluster, queried
+	// in order until one answers.
+	PrometheusAddrs []string `toml:"prometheus-addrs" json:"prometheus-addrs"`
+	// the period of the history whose peak usage is taken as the baseline.
+	Window Duration `toml:"window" json:"window"`
+	// the bytes each TiKV store can write to the disk per second, which is
+	// not exposed by the metrics.
+	StoreWriteBandwidth int64 `toml:"store-write-bandwidth" json:"store-write-bandwidth"`
+	// the region count of each TiKV store beyond which the store is regarded
+	// as overloaded.
+	MaxRegionsPerStore int64 `toml:"max-regions-per-store" json:"max-regions-per-store"`
+	// the fraction of the CPU and disk bandwidth of each store allowed to be
+	// used, including the usage by the existing workload.
+	MaxUtilization float64 `toml:"max-utilization" json:"max-utilization"`
 }
 
 type Security struct {
@@ -244,9 +869,11 @@ func NewConfig() *Config {
 			IndexConcurrency:  0,
 			IOConcurrency:     5,
 			CheckRequirements: true,
+			Mode:              RunModeImport,
 		},
 		Checkpoint: Checkpoint{
-			Enable: true,
+			Enable:           true,
+			SnapshotInterval: Duration{Duration: 10 * time.Minute},
 		},
 		TiDB: DBStore{
 			Host:                       "127.0.0.1",
@@ -260,12 +887,28 @@ func NewConfig() *Config {
 			ChecksumTableConcurrency:   16,
 		},
 		Cron: Cron{
-			SwitchMode:  Duration{Duration: 5 * time.Minute},
-			LogProgress: Duration{Duration: 5 * time.Minute},
+			SwitchMode:     Duration{Duration: 5 * time.Minute},
+			LogProgress:    Duration{Duration: 5 * time.Minute},
+			ReportProgress: Duration{Duration: time.Minute},
+		},
+		Metrics: Metrics{
+			Job:          "tidb-lightning",
+			PushInterval: Duration{Duration: 15 * time.Second},
+		},
+		Simulate: Simulate{
+			Window:              Duration{Duration: 24 * time.Hour},
+			StoreWriteBandwidth: 256 * _M,
+			MaxRegionsPerStore:  30000,
+			MaxUtilization:      0.8,
+		},
+		Report: Report{
+			LogTailSize: _M,
+			Checkpoints: true,
 		},
 		Mydumper: MydumperRuntime{
 			ReadBlockSize: ReadBlockSize,
 			CSV: CSVConfig{
+				Format:          CSVFormatCSV,
 				Separator:       ",",
 				Delimiter:       `"`,
 				Header:          true,
@@ -274,9 +917,21 @@ func NewConfig() *Config {
 				BackslashEscape: true,
 				TrimLastSep:     false,
 			},
-			StrictFormat:  false,
-			MaxRegionSize: MaxRegionSize,
-			Filter:        []string{"*.*"},
+			JSON: JSONConfig{
+				MissingKey: JSONMissingKeyDefault,
+				ExtraKey:   JSONExtraKeyIgnore,
+			},
+			FixedWidth: FixedWidthConfig{
+				Trim: true,
+			},
+			XLSX: XLSXConfig{
+				Header: true,
+			},
+			StrictFormat:     false,
+			MaxRegionSize:    MaxRegionSize,
+			ReadRetry:        3,
+			ReadRetryBackoff: Duration{Duration: time.Second},
+			Filter:           []string{"*.*"},
 		},
 		TikvImporter: TikvImporter{
 			Backend:         BackendImporter,
@@ -297,6 +952,9 @@ func (cfg *Config) LoadFromGlobal(global *GlobalConfig) error {
 	if err := cfg.LoadFromTOML(global.ConfigFileContent); err != nil {
 		return err
 	}
+	if err := cfg.LoadFromTOML(global.ConfigOverrides); err != nil {
+		return errors.Annotate(err, "invalid --set flags")
+	}
 
 	cfg.TiDB.Host = global.TiDB.Host
 	cfg.TiDB.Port = global.TiDB.Port
@@ -305,6 +963,7 @@ func (cfg *Config) LoadFromGlobal(global *GlobalConfig) error {
 	cfg.TiDB.StatusPort = global.TiDB.StatusPort
 	cfg.TiDB.PdAddr = global.TiDB.PdAddr
 	cfg.Mydumper.SourceDir = global.Mydumper.SourceDir
+	cfg.Mydumper.SourceDirs = global.Mydumper.SourceDirs
 	cfg.Mydumper.NoSchema = global.Mydumper.NoSchema
 	cfg.Mydumper.Filter = global.Mydumper.Filter
 	cfg.TikvImporter.Addr = global.TikvImporter.Addr
@@ -314,7 +973,9 @@ func (cfg *Config) LoadFromGlobal(global *GlobalConfig) error {
 	cfg.PostRestore.Checksum = global.PostRestore.Checksum
 	cfg.PostRestore.Analyze = global.PostRestore.Analyze
 	cfg.App.CheckRequirements = global.App.CheckRequirements
+	cfg.App.Mode = global.App.Mode
 	cfg.Security = global.Security
+	cfg.Chaos = global.Chaos
 
 	return nil
 }
@@ -336,6 +997,9 @@ func (cfg *Config) LoadFromTOML(data []byte) error {
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if metaData.IsDefined("mydumper", "data-source-dir") {
+		cfg.Mydumper.SourceDir, cfg.Mydumper.SourceDirs = cfg.Mydumper.SourceDirs.split()
+	}
 
 	unusedConfigKeys := metaData.Undecoded()
 	if len(unusedConfigKeys) == 0 {
@@ -382,8 +1046,35 @@ func (cfg *Config) LoadFromTOML(data []byte) error {
 
 // Adjust fixes the invalid or unspecified settings to reasonable valid values.
 func (cfg *Config) Adjust() error {
+	// Resolve the secrets referred from the environment, files or Vault.
+	var err error
+	if cfg.TiDB.Psw, err = ResolveSecret(cfg.TiDB.Psw); err != nil {
+		return errors.Annotate(err, "invalid config: `tidb.password`")
+	}
+	if cfg.TiDB.DDLPsw, err = ResolveSecret(cfg.TiDB.DDLPsw); err != nil {
+		return errors.Annotate(err, "invalid config: `tidb.ddl-password`")
+	}
+	if cfg.Checkpoint.DSN, err = ResolveSecret(cfg.Checkpoint.DSN); err != nil {
+		return errors.Annotate(err, "invalid config: `checkpoint.dsn`")
+	}
+	if cfg.Mydumper.SourceEncryptionKey, err = ResolveSecret(cfg.Mydumper.SourceEncryptionKey); err != nil {
+		return errors.Annotate(err, "invalid config: `mydumper.source-encryption-key`")
+	}
+
 	// Reject problematic CSV configurations.
 	csv := &cfg.Mydumper.CSV
+	csv.Format = strings.ToLower(csv.Format)
+	switch csv.Format {
+	case "", CSVFormatCSV:
+		csv.Format = CSVFormatCSV
+	case CSVFormatTSV:
+		// the fields of `SELECT ... INTO OUTFILE` are separated by tabs and
+		// not quoted, the tabs and the newlines in the values are escaped.
+		csv.Separator = "\t"
+		csv.Delimiter = ""
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.csv.format` (%s)", csv.Format)
+	}
 	if len(csv.Separator) != 1 {
 		return errors.New("invalid config: `mydumper.csv.separator` must be exactly one byte long")
 	}
@@ -405,6 +1096,29 @@ func (cfg *Config) Adjust() error {
 		}
 	}
 
+	cfg.Mydumper.JSON.MissingKey = strings.ToLower(cfg.Mydumper.JSON.MissingKey)
+	switch cfg.Mydumper.JSON.MissingKey {
+	case JSONMissingKeyDefault, JSONMissingKeyNull, JSONMissingKeyError:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.json.missing-key` (%s)", cfg.Mydumper.JSON.MissingKey)
+	}
+	cfg.Mydumper.JSON.ExtraKey = strings.ToLower(cfg.Mydumper.JSON.ExtraKey)
+	switch cfg.Mydumper.JSON.ExtraKey {
+	case JSONExtraKeyIgnore, JSONExtraKeyError:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.json.extra-key` (%s)", cfg.Mydumper.JSON.ExtraKey)
+	}
+
+	if err := cfg.Mydumper.FixedWidth.adjust(cfg.Mydumper.FileRouters); err != nil {
+		return err
+	}
+	if err := cfg.Mydumper.BSON.adjust(); err != nil {
+		return err
+	}
+	if err := cfg.Mydumper.Protobuf.adjust(); err != nil {
+		return err
+	}
+
 	// enable default file route rule if no rules are set
 	if len(cfg.Mydumper.FileRouters) == 0 {
 		cfg.Mydumper.DefaultFileRules = true
@@ -452,12 +1166,64 @@ func (cfg *Config) Adjust() error {
 			return errors.Errorf("invalid config: unsupported `tikv-importer.on-duplicate` (%s)", cfg.TikvImporter.OnDuplicate)
 		}
 	}
+	if len(cfg.TikvImporter.VersionColumn) > 0 && cfg.TikvImporter.Backend != BackendTiDB {
+		return errors.New("invalid config: `tikv-importer.version-column` is only supported by the 'tidb' backend")
+	}
+	if cfg.TikvImporter.GroupRowsByKey && cfg.TikvImporter.Backend != BackendTiDB {
+		return errors.New("invalid config: `tikv-importer.group-rows-by-key` is only supported by the 'tidb' backend")
+	}
+	if len(cfg.Mydumper.Generations) > 0 {
+		if cfg.TikvImporter.Backend != BackendTiDB ||
+			(cfg.TikvImporter.OnDuplicate != ReplaceOnDup && len(cfg.TikvImporter.VersionColumn) == 0) {
+			return errors.New("invalid config: `mydumper.generations` requires the 'tidb' backend with `tikv-importer.on-duplicate = \"replace\"` or `tikv-importer.version-column`")
+		}
+		dirs := make(map[string]struct{}, len(cfg.Mydumper.Generations))
+		for i, dir := range cfg.Mydumper.Generations {
+			dir = strings.Trim(filepath.ToSlash(dir), "/")
+			if len(dir) == 0 {
+				return errors.New("invalid config: `mydumper.generations` must not contain the data source dir itself")
+			}
+			if _, ok := dirs[dir]; ok {
+				return errors.Errorf("invalid config: duplicated directory '%s' in `mydumper.generations`", dir)
+			}
+			dirs[dir] = struct{}{}
+			cfg.Mydumper.Generations[i] = dir
+		}
+	}
+	if cfg.TikvImporter.VerifyKeyEncoding && cfg.TikvImporter.Backend == BackendTiDB {
+		return errors.New("invalid config: `tikv-importer.verify-key-encoding` is not supported by the 'tidb' backend")
+	}
+	if len(cfg.TikvImporter.StagingStoreLabels) > 0 {
+		if cfg.TikvImporter.Backend == BackendTiDB {
+			return errors.New("invalid config: `tikv-importer.staging-store-labels` is not supported by the 'tidb' backend")
+		}
+		for key, value := range cfg.TikvImporter.StagingStoreLabels {
+			if len(key) == 0 || len(value) == 0 {
+				return errors.Errorf("invalid config: the label '%s' of `tikv-importer.staging-store-labels` must have both the key and the value", key)
+			}
+		}
+	}
 
-	var err error
 	cfg.TiDB.SQLMode, err = mysql.GetSQLMode(cfg.TiDB.StrSQLMode)
 	if err != nil {
 		return errors.Annotate(err, "invalid config: `mydumper.tidb.sql_mode` must be a valid SQL_MODE")
 	}
+	for _, tableMode := range cfg.TiDB.TableSQLModes {
+		if len(tableMode.Schema) == 0 || len(tableMode.Table) == 0 {
+			return errors.New("invalid config: `tidb.table-sql-modes` requires both schema and table")
+		}
+		tableMode.SQLMode, err = mysql.GetSQLMode(tableMode.StrSQLMode)
+		if err != nil {
+			return errors.Annotatef(err, "invalid config: `tidb.table-sql-modes` of `%s`.`%s` must be a valid SQL_MODE", tableMode.Schema, tableMode.Table)
+		}
+	}
+
+	if len(cfg.TiDB.StrTargetVersion) > 0 {
+		cfg.TiDB.TargetVersion, err = semver.NewVersion(strings.TrimPrefix(cfg.TiDB.StrTargetVersion, "v"))
+		if err != nil {
+			return errors.Annotate(err, "invalid config: `tidb.target-version` must be a valid version like 'v4.0.8'")
+		}
+	}
 
 	if cfg.TiDB.Security == nil {
 		cfg.TiDB.Security = &cfg.Security
@@ -541,24 +1307,211 @@ func (cfg *Config) Adjust() error {
 	if cfg.Mydumper.ReadBlockSize <= 0 {
 		cfg.Mydumper.ReadBlockSize = ReadBlockSize
 	}
+	if cfg.Mydumper.XZConcurrency < 0 {
+		return errors.New("invalid config: `mydumper.xz-concurrency` must not be negative")
+	}
+	if cfg.Mydumper.XZConcurrency == 0 {
+		cfg.Mydumper.XZConcurrency = cfg.App.RegionConcurrency / 2
+		if cfg.Mydumper.XZConcurrency < 1 {
+			cfg.Mydumper.XZConcurrency = 1
+		}
+	}
+	if cfg.Mydumper.GzipConcurrency < 0 {
+		return errors.New("invalid config: `mydumper.gzip-concurrency` must not be negative")
+	}
+	if cfg.Mydumper.GzipConcurrency == 0 {
+		cfg.Mydumper.GzipConcurrency = defaultGzipConcurrency
+	}
 	if len(cfg.Mydumper.CharacterSet) == 0 {
 		cfg.Mydumper.CharacterSet = "auto"
 	}
+	if len(cfg.App.ProgressTable) > 0 {
+		if parts := strings.Split(cfg.App.ProgressTable, "."); len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return errors.New("invalid config: `lightning.progress-table` must be in the form 'schema.table'")
+		}
+		if cfg.Cron.ReportProgress.Duration <= 0 {
+			return errors.New("invalid config: `cron.report-progress` must be positive")
+		}
+	}
+	if err := cfg.Report.adjust(); err != nil {
+		return err
+	}
+	if err := cfg.Metrics.adjust(); err != nil {
+		return err
+	}
+	if cfg.Mydumper.CharsetConfidence == 0 {
+		cfg.Mydumper.CharsetConfidence = 0.8
+	}
+	if cfg.Mydumper.CharsetConfidence < 0 || cfg.Mydumper.CharsetConfidence > 1 {
+		return errors.New("invalid config: `mydumper.charset-confidence` must be between 0 and 1")
+	}
+	if cfg.App.MaxClockSkew.Duration < 0 {
+		return errors.New("invalid config: `lightning.max-clock-skew` must not be negative")
+	}
+	if cfg.App.MaxDuration.Duration < 0 {
+		return errors.New("invalid config: `lightning.max-duration` must not be negative")
+	}
+	if cfg.App.MaxDuration.Duration > 0 && !cfg.Checkpoint.Enable {
+		return errors.New("invalid config: `lightning.max-duration` requires `checkpoint.enable` to resume the import")
+	}
+	if cfg.App.Atomic {
+		if cfg.App.MaxDuration.Duration > 0 {
+			return errors.New("invalid config: `lightning.atomic` cannot be used with `lightning.max-duration`, which leaves the import partial")
+		}
+		if len(cfg.App.RollbackFile) == 0 {
+			cfg.App.RollbackFile = "tidb-lightning-rollback.sql"
+		}
+	}
+	cfg.App.Mode = strings.ToLower(cfg.App.Mode)
+	switch cfg.App.Mode {
+	case "":
+		cfg.App.Mode = RunModeImport
+	case RunModeImport, RunModePrecheckData:
+	case RunModeSimulateImpact:
+		if err := cfg.Simulate.adjust(); err != nil {
+			return err
+		}
+	default:
+		return errors.Errorf("invalid config: unsupported `lightning.mode` (%s)", cfg.App.Mode)
+	}
+	if cfg.Mydumper.MaxTableSize < 0 {
+		return errors.New("invalid config: `mydumper.max-table-size` must not be negative")
+	}
+	for i, tp := range cfg.Mydumper.ExcludeColumnTypes {
+		cfg.Mydumper.ExcludeColumnTypes[i] = strings.ToLower(strings.TrimSpace(tp))
+	}
+	for i, tag := range cfg.Mydumper.IncludeTags {
+		cfg.Mydumper.IncludeTags[i] = strings.ToLower(strings.TrimSpace(tag))
+	}
+	if len(cfg.Mydumper.IncludeTags) > 0 && len(cfg.Mydumper.TableTagsFile) == 0 {
+		return errors.New("invalid config: `mydumper.include-tags` requires `mydumper.table-tags-file`")
+	}
+	if cfg.Mydumper.OpenTimeout.Duration < 0 || cfg.Mydumper.ReadTimeout.Duration < 0 {
+		return errors.New("invalid config: `mydumper.open-timeout` and `mydumper.read-timeout` must not be negative")
+	}
+	if cfg.Mydumper.ReadRetry < 0 {
+		return errors.New("invalid config: `mydumper.read-retry` must not be negative")
+	}
+	if cfg.Mydumper.ReadErrorRetry < 0 || cfg.Mydumper.ReadBandwidth < 0 {
+		return errors.New("invalid config: `mydumper.read-error-retry` and `mydumper.read-bandwidth` must not be negative")
+	}
+	if cfg.Mydumper.ReadErrorRetry > 0 && cfg.Mydumper.ReadRetryBackoff.Duration <= 0 {
+		return errors.New("invalid config: `mydumper.read-retry-backoff` must be positive")
+	}
+	for _, rule := range cfg.Mydumper.ColumnMasks {
+		if err := checkColumnPattern("mydumper.column-masks", rule.Column); err != nil {
+			return err
+		}
+		rule.Masker = strings.ToLower(strings.TrimSpace(rule.Masker))
+		switch rule.Masker {
+		case ColumnMaskerHash, ColumnMaskerRedact, ColumnMaskerShuffle, ColumnMaskerFakeEmail, ColumnMaskerFakeName:
+		default:
+			return errors.Errorf("invalid config: unknown `mydumper.column-masks` masker '%s' of '%s'", rule.Masker, rule.Column)
+		}
+	}
+	if cfg.Mydumper.SampleRatio < 0 || cfg.Mydumper.SampleRatio > 1 {
+		return errors.New("invalid config: `mydumper.sample-ratio` must be between 0 and 1")
+	}
+	if cfg.Mydumper.MaxRowSize < 0 {
+		return errors.New("invalid config: `mydumper.max-row-size` must not be negative")
+	}
+	cfg.Mydumper.OnOversizedRow = strings.ToLower(strings.TrimSpace(cfg.Mydumper.OnOversizedRow))
+	switch cfg.Mydumper.OnOversizedRow {
+	case "":
+		cfg.Mydumper.OnOversizedRow = OversizedRowError
+	case OversizedRowError, OversizedRowSkip:
+	case OversizedRowTruncate:
+		if len(cfg.Mydumper.TruncateColumns) == 0 {
+			return errors.New("invalid config: `mydumper.truncate-columns` must not be empty when `mydumper.on-oversized-row` is \"truncate\"")
+		}
+	case OversizedRowRoute:
+		if len(cfg.Mydumper.OversizedRowDir) == 0 {
+			return errors.New("invalid config: `mydumper.oversized-row-dir` must not be empty when `mydumper.on-oversized-row` is \"route\"")
+		}
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.on-oversized-row` (%s)", cfg.Mydumper.OnOversizedRow)
+	}
+	for _, pattern := range cfg.Mydumper.TruncateColumns {
+		if err := checkColumnPattern("mydumper.truncate-columns", pattern); err != nil {
+			return err
+		}
+	}
+	cfg.Mydumper.OnOverlongValue = strings.ToLower(strings.TrimSpace(cfg.Mydumper.OnOverlongValue))
+	switch cfg.Mydumper.OnOverlongValue {
+	case "":
+		cfg.Mydumper.OnOverlongValue = OverlongValueNone
+	case OverlongValueNone, OverlongValueError, OverlongValueSkip, OverlongValueTruncate:
+	default:
+		return errors.Errorf("invalid config: unsupported `mydumper.on-overlong-value` (%s)", cfg.Mydumper.OnOverlongValue)
+	}
+	for _, rule := range cfg.Mydumper.ColumnDecoders {
+		if len(rule.Schema) == 0 || len(rule.Table) == 0 || len(rule.Column) == 0 || len(rule.Decoders) == 0 {
+			return errors.New("invalid config: `mydumper.column-decoders` requires schema, table, column and decoders")
+		}
+		for i, decoder := range rule.Decoders {
+			rule.Decoders[i] = strings.ToLower(strings.TrimSpace(decoder))
+		}
+	}
+
+	groupNames := make(map[string]struct{}, len(cfg.Mydumper.TableGroups))
+	for _, group := range cfg.Mydumper.TableGroups {
+		if len(group.Name) == 0 || len(group.Tables) == 0 {
+			return errors.New("invalid config: `mydumper.table-groups` requires name and tables")
+		}
+		if _, ok := groupNames[group.Name]; ok {
+			return errors.Errorf("invalid config: duplicated table group name '%s' in `mydumper.table-groups`", group.Name)
+		}
+		groupNames[group.Name] = struct{}{}
+		if _, err := filter.Parse(group.Tables); err != nil {
+			return errors.Annotatef(err, "invalid config: tables of table group '%s'", group.Name)
+		}
+	}
+
+	if cfg.PostRestore.ChecksumConcurrency < 0 {
+		return errors.New("invalid config: `post-restore.checksum-concurrency` must not be negative")
+	}
+	for _, rule := range cfg.PostRestore.TableCompacts {
+		if len(rule.Schema) == 0 || len(rule.Table) == 0 {
+			return errors.New("invalid config: `post-restore.table-compacts` requires schema and table")
+		}
+	}
 
 	if len(cfg.Checkpoint.Schema) == 0 {
 		cfg.Checkpoint.Schema = "tidb_lightning_checkpoint"
 	}
+	if len(cfg.Checkpoint.SnapshotURL) > 0 {
+		if !cfg.Checkpoint.Enable {
+			return errors.New("invalid config: `checkpoint.snapshot-url` requires `checkpoint.enable`")
+		}
+		if cfg.Checkpoint.SnapshotInterval.Duration <= 0 {
+			return errors.New("invalid config: `checkpoint.snapshot-interval` must be positive")
+		}
+	}
+	if cfg.Checkpoint.ResumeFromTarget {
+		if !cfg.Checkpoint.Enable {
+			return errors.New("invalid config: `checkpoint.resume-from-target` requires `checkpoint.enable`")
+		}
+		// only the checksummed tables are recorded.
+		if !cfg.PostRestore.Checksum {
+			return errors.New("invalid config: `checkpoint.resume-from-target` requires `post-restore.checksum`")
+		}
+		if cfg.TikvImporter.Backend == BackendTiDB {
+			return errors.New("invalid config: `checkpoint.resume-from-target` is not supported by the 'tidb' backend")
+		}
+	}
 	if len(cfg.Checkpoint.Driver) == 0 {
 		cfg.Checkpoint.Driver = CheckpointDriverFile
 	}
 	if len(cfg.Checkpoint.DSN) == 0 {
 		switch cfg.Checkpoint.Driver {
 		case CheckpointDriverMySQL:
+			// the checkpoint schema is created by the DDL user.
+			ddlStore := cfg.TiDB.DDLStore()
 			param := common.MySQLConnectParam{
 				Host:             cfg.TiDB.Host,
 				Port:             cfg.TiDB.Port,
-				User:             cfg.TiDB.User,
-				Password:         cfg.TiDB.Psw,
+				User:             ddlStore.User,
+				Password:         ddlStore.Psw,
 				SQLMode:          mysql.DefaultSQLMode,
 				MaxAllowedPacket: defaultMaxAllowedPacket,
 				TLS:              cfg.TiDB.TLS,
@@ -569,40 +1522,333 @@ func (cfg *Config) Adjust() error {
 		}
 	}
 
-	u, err := url.Parse(cfg.Mydumper.SourceDir)
+	if cfg.Mydumper.IsStream() || cfg.Mydumper.SourceDir == StreamSourceDir {
+		if len(cfg.Mydumper.SourceDirs) > 1 {
+			return errors.New("invalid config: `mydumper.data-source-dir` must be a single stream to import `mydumper.stream-table`")
+		}
+		if len(cfg.Mydumper.ManifestFile) > 0 {
+			return errors.New("invalid config: `mydumper.manifest-file` is not supported with `mydumper.stream-table`")
+		}
+		return cfg.adjustStream()
+	}
+	return cfg.adjustSourceDirs()
+}
+
+// adjustSourceDir converts the local path of the data source to the file URL,
+// and returns the scheme of the data source.
+func adjustSourceDir(dir string) (string, string, error) {
+	u, err := url.Parse(dir)
 	if err != nil {
-		return errors.Trace(err)
+		return "", "", errors.Trace(err)
 	}
 	// convert path and relative path to a valid file url
 	if u.Scheme == "" {
-		if !common.IsDirExists(cfg.Mydumper.SourceDir) {
-			return errors.Errorf("%s: mydumper dir does not exist", cfg.Mydumper.SourceDir)
+		if !common.IsDirExists(dir) {
+			return "", "", errors.Errorf("%s: mydumper dir does not exist", dir)
 		}
-		absPath, err := filepath.Abs(cfg.Mydumper.SourceDir)
+		absPath, err := filepath.Abs(dir)
 		if err != nil {
-			return errors.Annotatef(err, "covert data-source-dir '%s' to absolute path failed", cfg.Mydumper.SourceDir)
+			return "", "", errors.Annotatef(err, "covert data-source-dir '%s' to absolute path failed", dir)
 		}
-		cfg.Mydumper.SourceDir = fmt.Sprintf("file://%s", absPath)
-		u.Path = absPath
-		u.Scheme = "file"
+		return fmt.Sprintf("file://%s", absPath), "file", nil
 	}
 
-	found := false
+	// the "faulty+" prefix injects faults into the storage for testing.
+	scheme := strings.TrimPrefix(u.Scheme, "faulty+")
 	for _, t := range supportedStorageTypes {
-		if u.Scheme == t {
-			found = true
-			break
+		if scheme == t {
+			return dir, scheme, nil
+		}
+	}
+	return "", "", errors.Errorf("Unsupported data-source-dir url '%s'", dir)
+}
+
+// adjustSourceDirs checks the data sources, and the options of the data
+// sources against their schemes. With multiple data sources, the options are
+// applied to the data sources supporting them.
+func (cfg *Config) adjustSourceDirs() error {
+	m := &cfg.Mydumper
+	dirs := m.DataSources()
+	if len(dirs) > 1 && len(m.Generations) > 0 {
+		return errors.New("invalid config: `mydumper.generations` is not supported with multiple `mydumper.data-source-dir`")
+	}
+	schemes := make(map[string]struct{}, len(dirs))
+	adjusted := make(map[string]struct{}, len(dirs))
+	for i, dir := range dirs {
+		dir, scheme, err := adjustSourceDir(dir)
+		if err != nil {
+			return err
+		}
+		if _, ok := adjusted[dir]; ok {
+			return errors.Errorf("invalid config: duplicated data source '%s' in `mydumper.data-source-dir`", RedactURL(dir))
+		}
+		adjusted[dir] = struct{}{}
+		schemes[scheme] = struct{}{}
+		dirs[i] = dir
+	}
+	m.SourceDir = dirs[0]
+
+	hasScheme := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := schemes[name]; ok {
+				return true
+			}
 		}
+		return false
+	}
+	schemeNames := make([]string, 0, len(schemes))
+	for scheme := range schemes {
+		schemeNames = append(schemeNames, scheme)
+	}
+	sort.Strings(schemeNames)
+	if len(m.SourceEncryptionKey) > 0 {
+		if !hasScheme("s3", "gcs", "gs") {
+			return errors.Errorf("invalid config: `mydumper.source-encryption-key` is only supported by the data source in s3 or gcs, but got '%s'",
+				strings.Join(schemeNames, "', '"))
+		}
+		if _, err := m.SourceCustomerKey(); err != nil {
+			return err
+		}
+	}
+	if m.S3.IsSet() {
+		if !hasScheme("s3") {
+			return errors.Errorf("invalid config: `mydumper.s3` is only supported by the data source in s3, but got '%s'",
+				strings.Join(schemeNames, "', '"))
+		}
+		if err := m.S3.adjust(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// adjustStream checks the config of importing a single table from stdin or a
+// named pipe. The stream is read only once, from the start to the end, so the
+// features reading the data files ahead of the import are not supported.
+func (cfg *Config) adjustStream() error {
+	m := &cfg.Mydumper
+	if !m.IsStream() {
+		return errors.New("invalid config: `mydumper.stream-table` must be set to import from stdin")
+	}
+	if _, _, err := m.StreamTableName(); err != nil {
+		return err
 	}
-	if !found {
-		return errors.Errorf("Unsupported data-source-dir url '%s'", cfg.Mydumper.SourceDir)
+	switch m.StreamFormat {
+	case "":
+		m.StreamFormat = StreamFormatSQL
+	case StreamFormatSQL, StreamFormatCSV:
+	default:
+		return errors.Errorf("invalid config: `mydumper.stream-format` must be one of 'sql' or 'csv', but got '%s'", m.StreamFormat)
+	}
+
+	if m.SourceDir != StreamSourceDir {
+		if len(m.SourceDir) == 0 || common.IsDirExists(m.SourceDir) {
+			return errors.Errorf("invalid config: `mydumper.data-source-dir` must be \"-\" or the path of a named pipe to import `mydumper.stream-table`, but got '%s'", m.SourceDir)
+		}
+		absPath, err := filepath.Abs(m.SourceDir)
+		if err != nil {
+			return errors.Annotatef(err, "covert data-source-dir '%s' to absolute path failed", m.SourceDir)
+		}
+		m.SourceDir = absPath
 	}
 
+	if m.DetectCharset || m.DetectCompression || m.FileDirectives || m.ReadTimeout.Duration > 0 || m.ReadErrorRetry > 0 ||
+		len(m.Generations) > 0 || len(m.SourceEncryptionKey) > 0 || cfg.TikvImporter.VerifyKeyEncoding {
+		return errors.New("invalid config: the stream of `mydumper.stream-table` can only be read once, which conflicts with " +
+			"`mydumper.detect-charset`, `mydumper.detect-compression`, `mydumper.file-directives`, `mydumper.read-timeout`, " +
+			"`mydumper.read-error-retry`, `mydumper.generations`, `mydumper.source-encryption-key` and `tikv-importer.verify-key-encoding`")
+	}
+	// the table is created in the target before importing.
+	m.NoSchema = true
 	return nil
 }
 
+// TableSQLMode returns the SQL mode for encoding the rows of the table, where
+// the table name is in the form "`db`.`tbl`".
+func (cfg *Config) TableSQLMode(tableName string) mysql.SQLMode {
+	for _, tableMode := range cfg.TiDB.TableSQLModes {
+		if strings.EqualFold(common.UniqueTable(tableMode.Schema, tableMode.Table), tableName) {
+			return tableMode.SQLMode
+		}
+	}
+	return cfg.TiDB.SQLMode
+}
+
+// ShouldCompactTable returns whether the key ranges of the table should be
+// compacted after import, where the table name is in the form "`db`.`tbl`".
+func (cfg *Config) ShouldCompactTable(tableName string) bool {
+	for _, rule := range cfg.PostRestore.TableCompacts {
+		if strings.EqualFold(common.UniqueTable(rule.Schema, rule.Table), tableName) {
+			return rule.Compact
+		}
+	}
+	return cfg.PostRestore.TableCompact
+}
+
+// DataGeneration returns the generation of the data file at the path relative
+// to the data source dir: 0 for the base dump, or i for the i-th directory in
+// `mydumper.generations`.
+func DataGeneration(generations []string, path string) int {
+	path = filepath.ToSlash(path)
+	for i, dir := range generations {
+		if strings.HasPrefix(path, dir+"/") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
 // HasLegacyBlackWhiteList checks whether the deprecated [black-white-list] section
 // was defined.
 func (cfg *Config) HasLegacyBlackWhiteList() bool {
 	return len(cfg.BWList.DoTables) != 0 || len(cfg.BWList.DoDBs) != 0 || len(cfg.BWList.IgnoreTables) != 0 || len(cfg.BWList.IgnoreDBs) != 0
 }
+
+func (fw *FixedWidthConfig) adjust(rules []*FileRouteRule) error {
+	if fw.RecordLength < 0 {
+		return errors.New("invalid config: `mydumper.fixed-width.record-length` must not be negative")
+	}
+	if len(fw.Columns) == 0 {
+		for _, rule := range rules {
+			if strings.EqualFold(rule.Type, "fixed-width") {
+				return errors.New("invalid config: `mydumper.fixed-width.columns` must be set to import the fixed-width files")
+			}
+		}
+		return nil
+	}
+	names := make(map[string]struct{}, len(fw.Columns))
+	for _, col := range fw.Columns {
+		name := strings.ToLower(col.Name)
+		if len(name) == 0 {
+			return errors.New("invalid config: the name of `mydumper.fixed-width.columns` must not be empty")
+		}
+		if _, ok := names[name]; ok {
+			return errors.Errorf("invalid config: duplicated column '%s' in `mydumper.fixed-width.columns`", col.Name)
+		}
+		names[name] = struct{}{}
+		if col.Offset < 0 || col.Width <= 0 {
+			return errors.Errorf("invalid config: column '%s' of `mydumper.fixed-width.columns` must have a non-negative offset and a positive width", col.Name)
+		}
+		if fw.RecordLength > 0 && col.Offset+col.Width > fw.RecordLength {
+			return errors.Errorf("invalid config: column '%s' of `mydumper.fixed-width.columns` exceeds `mydumper.fixed-width.record-length`", col.Name)
+		}
+	}
+	return nil
+}
+
+func (b *BSONConfig) adjust() error {
+	columns := make(map[string]struct{}, len(b.Fields))
+	for _, field := range b.Fields {
+		if len(field.Path) == 0 || len(field.Column) == 0 {
+			return errors.New("invalid config: `mydumper.bson.fields` requires path and column")
+		}
+		for _, segment := range strings.Split(field.Path, ".") {
+			if len(segment) == 0 {
+				return errors.Errorf("invalid config: path '%s' of `mydumper.bson.fields` has an empty field name", field.Path)
+			}
+		}
+		column := strings.ToLower(field.Column)
+		if _, ok := columns[column]; ok {
+			return errors.Errorf("invalid config: duplicated column '%s' in `mydumper.bson.fields`", field.Column)
+		}
+		columns[column] = struct{}{}
+	}
+	if _, ok := columns[strings.ToLower(b.ExtraColumn)]; ok {
+		return errors.Errorf("invalid config: `mydumper.bson.extra-column` '%s' is also in `mydumper.bson.fields`", b.ExtraColumn)
+	}
+	return nil
+}
+
+func (r *Report) adjust() error {
+	if r.LogTailSize < 0 {
+		return errors.New("invalid config: `report.log-tail-size` must not be negative")
+	}
+	return nil
+}
+
+func (m *Metrics) adjust() error {
+	for _, target := range []struct{ name, addr string }{
+		{"metrics.push-gateway-addr", m.PushGatewayAddr},
+		{"metrics.remote-write-url", m.RemoteWriteURL},
+	} {
+		if len(target.addr) == 0 {
+			continue
+		}
+		u, err := url.Parse(target.addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.Errorf("invalid config: `%s` must be an http or https URL (%s)", target.name, target.addr)
+		}
+	}
+	if len(m.PushGatewayAddr) == 0 && len(m.RemoteWriteURL) == 0 {
+		return nil
+	}
+	if len(m.Job) == 0 {
+		return errors.New("invalid config: `metrics.job` must not be empty")
+	}
+	if m.PushInterval.Duration <= 0 {
+		return errors.New("invalid config: `metrics.push-interval` must be positive")
+	}
+	return nil
+}
+
+func (s *Simulate) adjust() error {
+	if len(s.PrometheusAddrs) == 0 {
+		return errors.New("invalid config: `simulate.prometheus-addrs` must not be empty in the simulate-impact mode")
+	}
+	for _, addr := range s.PrometheusAddrs {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return errors.Errorf("invalid config: `simulate.prometheus-addrs` must be http or https URLs (%s)", addr)
+		}
+	}
+	if s.Window.Duration < time.Minute {
+		return errors.New("invalid config: `simulate.window` must be at least 1m")
+	}
+	if s.StoreWriteBandwidth <= 0 {
+		return errors.New("invalid config: `simulate.store-write-bandwidth` must be positive")
+	}
+	if s.MaxRegionsPerStore <= 0 {
+		return errors.New("invalid config: `simulate.max-regions-per-store` must be positive")
+	}
+	if s.MaxUtilization <= 0 || s.MaxUtilization > 1 {
+		return errors.New("invalid config: `simulate.max-utilization` must be in (0, 1]")
+	}
+	return nil
+}
+
+func (pb *ProtobufConfig) adjust() error {
+	tables := make(map[string]struct{}, len(pb.Tables))
+	for _, t := range pb.Tables {
+		if len(t.Schema) == 0 || len(t.Table) == 0 || len(t.Message) == 0 {
+			return errors.New("invalid config: `mydumper.protobuf.tables` requires schema, table and message")
+		}
+		if len(t.DescriptorSet) == 0 && len(pb.DescriptorSet) == 0 {
+			return errors.Errorf("invalid config: no `descriptor-set` for the message of table %s in `mydumper.protobuf.tables`",
+				common.UniqueTable(t.Schema, t.Table))
+		}
+		// the leading dot of the fully-qualified names is optional.
+		t.Message = strings.TrimPrefix(t.Message, ".")
+		name := strings.ToLower(common.UniqueTable(t.Schema, t.Table))
+		if _, ok := tables[name]; ok {
+			return errors.Errorf("invalid config: duplicated table %s in `mydumper.protobuf.tables`", common.UniqueTable(t.Schema, t.Table))
+		}
+		tables[name] = struct{}{}
+	}
+	return nil
+}
+
+// Table returns the message type of the table "schema.table", or nil
+// if it is not configured. The descriptor set falls back to the default one.
+func (pb *ProtobufConfig) Table(tableName string) *ProtobufTable {
+	for _, t := range pb.Tables {
+		if strings.EqualFold(common.UniqueTable(t.Schema, t.Table), tableName) {
+			result := *t
+			if len(result.DescriptorSet) == 0 {
+				result.DescriptorSet = pb.DescriptorSet
+			}
+			return &result
+		}
+	}
+	return nil
+}
@@ -27,4 +27,5 @@ const (
 	BufferSizeScale = 5
 
 	defaultMaxAllowedPacket = 64 * 1024 * 1024
+	defaultGzipConcurrency  = 4
 )
@@ -19,6 +19,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
@@ -34,6 +37,7 @@ type GlobalLightning struct {
 	StatusAddr        string `toml:"status-addr" json:"status-addr"`
 	ServerMode        bool   `toml:"server-mode" json:"server-mode"`
 	CheckRequirements bool   `toml:"check-requirements" json:"check-requirements"`
+	Mode              string `toml:"mode" json:"mode"`
 
 	// The legacy alias for setting "status-addr". The value should always the
 	// same as StatusAddr, and will not be published in the JSON encoding.
@@ -51,9 +55,10 @@ type GlobalTiDB struct {
 }
 
 type GlobalMydumper struct {
-	SourceDir string   `toml:"data-source-dir" json:"data-source-dir"`
-	NoSchema  bool     `toml:"no-schema" json:"no-schema"`
-	Filter    []string `toml:"filter" json:"filter"`
+	SourceDir  string     `toml:"-" json:"data-source-dir"`
+	SourceDirs SourceDirs `toml:"data-source-dir" json:"data-source-dirs,omitempty"`
+	NoSchema   bool       `toml:"no-schema" json:"no-schema"`
+	Filter     []string   `toml:"filter" json:"filter"`
 }
 
 type GlobalImporter struct {
@@ -70,8 +75,12 @@ type GlobalConfig struct {
 	TikvImporter GlobalImporter    `toml:"tikv-importer" json:"tikv-importer"`
 	PostRestore  GlobalPostRestore `toml:"post-restore" json:"post-restore"`
 	Security     Security          `toml:"security" json:"security"`
+	Chaos        Chaos             `toml:"-" json:"-"`
 
 	ConfigFileContent []byte
+	// ConfigOverrides is the TOML converted from the `--set` flags, which is
+	// applied after the config file.
+	ConfigOverrides []byte
 }
 
 type GlobalCheckpoint struct {
@@ -88,6 +97,7 @@ func NewGlobalConfig() *GlobalConfig {
 		App: GlobalLightning{
 			ServerMode:        false,
 			CheckRequirements: true,
+			Mode:              RunModeImport,
 		},
 		Checkpoint: GlobalCheckpoint{
 			Enable: true,
@@ -165,13 +175,19 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 
 	statusAddr := fs.String("status-addr", "", "the Lightning server address")
 	serverMode := fs.Bool("server-mode", false, "start Lightning in server mode, wait for multiple tasks instead of starting immediately")
+	mode := flagext.ChoiceVar(fs, "mode", "", `what to run: import, precheck-data, simulate-impact (default import)`, "", RunModeImport, RunModePrecheckData, RunModeSimulateImpact)
 
 	var filter []string
 	flagext.StringsVar(fs, &filter, "f", "select tables to import")
+	var sets []string
+	flagext.StringsVar(fs, &sets, "set", "override a config item, e.g. --set mydumper.csv.separator='|' (can be repeated)")
+
+	chaos := registerChaosFlags(fs)
 
 	if extraFlags != nil {
 		extraFlags(fs)
 	}
+	hideChaosFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		return nil, errors.Trace(err)
@@ -191,6 +207,19 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 		}
 		cfg.ConfigFileContent = data
 	}
+	if len(sets) > 0 {
+		overrides, err := parseConfigOverrides(sets)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if err = toml.Unmarshal(overrides, cfg); err != nil {
+			return nil, errors.Annotate(err, "Cannot apply the --set flags")
+		}
+		cfg.ConfigOverrides = overrides
+	}
+	if len(cfg.Mydumper.SourceDirs) > 0 {
+		cfg.Mydumper.SourceDir, cfg.Mydumper.SourceDirs = cfg.Mydumper.SourceDirs.split()
+	}
 
 	if *logLevel != "" {
 		cfg.App.Config.Level = *logLevel
@@ -224,6 +253,7 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	}
 	if *dataSrcPath != "" {
 		cfg.Mydumper.SourceDir = *dataSrcPath
+		cfg.Mydumper.SourceDirs = nil
 	}
 	if *importerAddr != "" {
 		cfg.TikvImporter.Addr = *importerAddr
@@ -234,6 +264,9 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	if *statusAddr != "" {
 		cfg.App.StatusAddr = *statusAddr
 	}
+	if *mode != "" {
+		cfg.App.Mode = *mode
+	}
 	if *backend != "" {
 		cfg.TikvImporter.Backend = *backend
 	}
@@ -270,6 +303,9 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	if len(filter) > 0 {
 		cfg.Mydumper.Filter = filter
 	}
+	if err := chaos.apply(fs, &cfg.Chaos); err != nil {
+		return nil, errors.Trace(err)
+	}
 
 	if cfg.App.StatusAddr == "" && cfg.App.ServerMode {
 		return nil, errors.New("If server-mode is enabled, the status-addr must be a valid listen address")
@@ -278,3 +314,126 @@ func LoadGlobalConfig(args []string, extraFlags func(*flag.FlagSet)) (*GlobalCon
 	cfg.App.Config.Adjust()
 	return cfg, nil
 }
+
+var bareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// parseConfigOverrides converts the `--set key=value` flags into TOML, where
+// the key is the dotted path of the config item such as "tidb.port". A value
+// which is not a valid TOML value is treated as a plain string. If a key is
+// set multiple times, the last one wins.
+func parseConfigOverrides(sets []string) ([]byte, error) {
+	type table struct {
+		keys   []string
+		values map[string]string
+	}
+	var tablePaths []string
+	tables := make(map[string]*table)
+
+	for _, set := range sets {
+		eq := strings.IndexByte(set, '=')
+		if eq < 0 {
+			return nil, errors.Errorf("invalid --set flag '%s', must be in the form 'key=value'", set)
+		}
+		parts := strings.Split(strings.TrimSpace(set[:eq]), ".")
+		if len(parts) < 2 {
+			return nil, errors.Errorf("invalid --set flag '%s', the key must include the section, e.g. 'tidb.port'", set)
+		}
+		for _, part := range parts {
+			if !bareKeyRegexp.MatchString(part) {
+				return nil, errors.Errorf("invalid --set flag '%s', bad key '%s'", set, part)
+			}
+		}
+
+		tablePath := strings.Join(parts[:len(parts)-1], ".")
+		key := parts[len(parts)-1]
+		t, ok := tables[tablePath]
+		if !ok {
+			t = &table{values: make(map[string]string)}
+			tables[tablePath] = t
+			tablePaths = append(tablePaths, tablePath)
+		}
+		if _, ok := t.values[key]; !ok {
+			t.keys = append(t.keys, key)
+		}
+		t.values[key] = tomlValue(strings.TrimSpace(set[eq+1:]), overrideFieldType(parts))
+	}
+
+	var sb strings.Builder
+	for _, tablePath := range tablePaths {
+		t := tables[tablePath]
+		fmt.Fprintf(&sb, "[%s]\n", tablePath)
+		for _, key := range t.keys {
+			fmt.Fprintf(&sb, "%s = %s\n", key, t.values[key])
+		}
+	}
+	return []byte(sb.String()), nil
+}
+
+// overrideFieldType returns the type of the config item at the dotted path,
+// looked up by the TOML keys of the task config and then the global config.
+// Returns nil if the path is unknown.
+func overrideFieldType(path []string) reflect.Type {
+	for _, typ := range []reflect.Type{reflect.TypeOf(Config{}), reflect.TypeOf(GlobalConfig{})} {
+		if field := lookupTOMLField(typ, path); field != nil {
+			return field
+		}
+	}
+	return nil
+}
+
+func lookupTOMLField(typ reflect.Type, path []string) reflect.Type {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if len(path) == 0 {
+		return typ
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		name := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" && field.Anonymous {
+			if res := lookupTOMLField(field.Type, path); res != nil {
+				return res
+			}
+			continue
+		}
+		if name == path[0] {
+			return lookupTOMLField(field.Type, path[1:])
+		}
+	}
+	return nil
+}
+
+// tomlValue returns the value as is if it is a valid TOML value of the config
+// item, otherwise quotes it as a TOML string. The value of a string item is
+// always a string, so e.g. `tidb.password=123456` is not decoded as a number.
+func tomlValue(value string, typ reflect.Type) string {
+	var v map[string]interface{}
+	if _, err := toml.Decode("v = "+value, &v); err == nil && !strings.ContainsAny(value, "\r\n") {
+		if _, isString := v["v"].(string); isString || typ == nil || typ.Kind() != reflect.String {
+			return value
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range value {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, "\\u%04X", r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
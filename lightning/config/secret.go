@@ -0,0 +1,153 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	secretPrefixPlain = "plain:"
+	secretPrefixEnv   = "env:"
+	secretPrefixFile  = "file:"
+	secretPrefixVault = "vault:"
+
+	vaultTimeout = 10 * time.Second
+
+	redactedSecret = "xxxxx"
+)
+
+// ResolveSecret returns the secret referred by the value, which may be one of
+//
+//	env:NAME                the environment variable NAME
+//	file:/path/to/secret    the content of the file, without the trailing newline
+//	vault:path/to/secret#key the field `key` of the secret at the path in the
+//	                        Vault KV engine, reached by $VAULT_ADDR with the
+//	                        token $VAULT_TOKEN
+//	plain:value             the value itself, for secrets starting with any of
+//	                        these prefixes
+//
+// Values without any of these prefixes are returned unchanged.
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretPrefixPlain):
+		return strings.TrimPrefix(value, secretPrefixPlain), nil
+
+	case strings.HasPrefix(value, secretPrefixEnv):
+		name := strings.TrimPrefix(value, secretPrefixEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, secretPrefixFile):
+		path := strings.TrimPrefix(value, secretPrefixFile)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Annotate(err, "cannot read secret file")
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+
+	case strings.HasPrefix(value, secretPrefixVault):
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		defer cancel()
+		return readVaultSecret(ctx, os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN"), strings.TrimPrefix(value, secretPrefixVault))
+
+	default:
+		return value, nil
+	}
+}
+
+// readVaultSecret reads a field of a secret from the KV secrets engine of
+// Vault. Both version 1 and version 2 of the engine are supported, where the
+// path of version 2 must include the `data/` segment, e.g. `secret/data/tidb`.
+func readVaultSecret(ctx context.Context, addr string, token string, ref string) (string, error) {
+	sep := strings.LastIndexByte(ref, '#')
+	if sep <= 0 || sep == len(ref)-1 {
+		return "", errors.Errorf("vault secret '%s' must be in the form 'path#key'", ref)
+	}
+	path, key := ref[:sep], ref[sep+1:]
+	if len(addr) == 0 {
+		return "", errors.New("VAULT_ADDR is not set")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", errors.Annotatef(err, "cannot read vault secret %s", path)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("cannot read vault secret %s: %s", path, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Annotatef(err, "cannot read vault secret %s", path)
+	}
+	data := body.Data
+	// the KV version 2 engine nests the fields under "data" again.
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+	secret, ok := data[key].(string)
+	if !ok {
+		return "", errors.Errorf("vault secret %s has no string field '%s'", path, key)
+	}
+	return secret, nil
+}
+
+// RedactURL hides the password and the query parameters carrying credentials
+// (e.g. the `secret-access-key` of S3) of the URL, so it can be logged.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.User == nil && len(u.RawQuery) == 0) {
+		return rawURL
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), redactedSecret)
+	}
+	query := u.Query()
+	redacted := false
+	for name := range query {
+		lower := strings.ToLower(name)
+		if strings.Contains(lower, "key") || strings.Contains(lower, "secret") ||
+			strings.Contains(lower, "token") || strings.Contains(lower, "password") {
+			query.Set(name, redactedSecret)
+			redacted = true
+		}
+	}
+	if redacted {
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
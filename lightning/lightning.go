@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,6 +33,7 @@ import (
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/errors"
 	"github.com/pingcap/failpoint"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/shurcooL/httpgzip"
 	"go.uber.org/zap"
@@ -42,14 +44,27 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/metric"
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 	"github.com/pingcap/tidb-lightning/lightning/restore"
 	"github.com/pingcap/tidb-lightning/lightning/web"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+const (
+	// defaultStuckThreshold is how long a busy worker may make no progress
+	// before it is suspected to be stuck.
+	defaultStuckThreshold = 30 * time.Minute
+	// a leak of goroutines is suspected when there are more goroutines than
+	// goroutineBase plus goroutinesPerWorker for every worker of all pools.
+	goroutineBase       = 1000
+	goroutinesPerWorker = 16
 )
 
 type Lightning struct {
-	globalCfg *config.GlobalConfig
-	globalTLS *common.TLS
+	globalCfgLock sync.RWMutex
+	globalCfg     *config.GlobalConfig
+	globalTLS     *common.TLS
 	// taskCfgs is the list of task configurations enqueued in the server mode
 	taskCfgs   *config.ConfigList
 	ctx        context.Context
@@ -87,7 +102,13 @@ func New(globalCfg *config.GlobalConfig) *Lightning {
 }
 
 func (l *Lightning) GoServe() error {
-	if len(l.globalCfg.App.StatusAddr) == 0 {
+	// the status address is replaced by the socket from systemd if socket
+	// activated.
+	listener, err := activatedListener()
+	if err != nil {
+		return err
+	}
+	if listener == nil && len(l.globalCfg.App.StatusAddr) == 0 {
 		return nil
 	}
 
@@ -100,12 +121,14 @@ func (l *Lightning) GoServe() error {
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/health", handleHealth)
 
 	handleTasks := http.StripPrefix("/tasks", http.HandlerFunc(l.handleTask))
 	mux.Handle("/tasks", handleTasks)
 	mux.Handle("/tasks/", handleTasks)
 	mux.HandleFunc("/progress/task", handleProgressTask)
 	mux.HandleFunc("/progress/table", handleProgressTable)
+	mux.HandleFunc("/progress/warnings", handleProgressWarnings)
 	mux.HandleFunc("/pause", handlePause)
 	mux.HandleFunc("/resume", handleResume)
 
@@ -120,9 +143,11 @@ func (l *Lightning) GoServe() error {
 		},
 	})))
 
-	listener, err := net.Listen("tcp", l.globalCfg.App.StatusAddr)
-	if err != nil {
-		return err
+	if listener == nil {
+		listener, err = net.Listen("tcp", l.globalCfg.App.StatusAddr)
+		if err != nil {
+			return err
+		}
 	}
 	l.serverAddr = listener.Addr()
 	l.server.Handler = mux
@@ -138,7 +163,7 @@ func (l *Lightning) GoServe() error {
 // Run Lightning using the global config as the same as the task config.
 func (l *Lightning) RunOnce() error {
 	cfg := config.NewConfig()
-	if err := cfg.LoadFromGlobal(l.globalCfg); err != nil {
+	if err := cfg.LoadFromGlobal(l.globalConfig()); err != nil {
 		return err
 	}
 	if err := cfg.Adjust(); err != nil {
@@ -149,6 +174,7 @@ func (l *Lightning) RunOnce() error {
 	failpoint.Inject("SetTaskID", func(val failpoint.Value) {
 		cfg.TaskID = int64(val.(int))
 	})
+	sdNotify(sdNotifyReady, sdStatus("importing"))
 	return l.run(cfg)
 }
 
@@ -158,14 +184,19 @@ func (l *Lightning) RunServer() error {
 		"Lightning server is running, post to /tasks to start an import task",
 		zap.Stringer("address", l.serverAddr),
 	)
+	sdNotify(sdNotifyReady, sdStatus("waiting for tasks"))
 
 	for {
 		task, err := l.taskCfgs.Pop(l.ctx)
 		if err != nil {
 			return err
 		}
+		sdNotify(sdStatus(fmt.Sprintf("importing task %d", task.TaskID)))
 		err = l.run(task)
-		if err != nil {
+		sdNotify(sdStatus("waiting for tasks"))
+		if errors.Cause(err) == restore.ErrImportPartial {
+			log.L().Warn("tidb lightning stopped with a partial import", log.ShortError(err))
+		} else if err != nil {
 			restore.DeliverPauser.Pause() // force pause the progress on error
 			log.L().Error("tidb lightning encountered error", zap.Error(err))
 		}
@@ -187,6 +218,11 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 	l.curTask = taskCfg
 	l.cancelLock.Unlock()
 	web.BroadcastStartTask()
+	common.Warnings.Reset()
+	if taskCfg.HasLegacyBlackWhiteList() {
+		common.RecordWarning("", common.WarningDeprecatedConfig,
+			"the config `black-white-list` has been deprecated, please replace with `mydumper.filter`")
+	}
 
 	defer func() {
 		cancel()
@@ -196,6 +232,35 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 		web.BroadcastEndTask(err)
 	}()
 
+	// the metadata of the dump is read after opening the data source, and
+	// included in the report if found.
+	var dumpMeta *mydump.DumpMetadata
+	if len(taskCfg.Report.UploadURL) > 0 {
+		startTime := time.Now()
+		defer func() {
+			if e := uploadReport(taskCfg, l.globalConfig().App.Config.File, startTime, dumpMeta, err); e != nil {
+				log.L().Warn("upload the report of the task failed", log.ShortError(e))
+			}
+		}()
+	}
+
+	if metrics := taskCfg.Metrics; len(metrics.PushGatewayAddr) > 0 || len(metrics.RemoteWriteURL) > 0 {
+		pusher := metric.NewPusher(metric.PushConfig{
+			PushGatewayAddr: metrics.PushGatewayAddr,
+			RemoteWriteURL:  metrics.RemoteWriteURL,
+			Job:             metrics.Job,
+			Labels:          metrics.Labels,
+			Interval:        metrics.PushInterval.Duration,
+		}, prometheus.DefaultGatherer)
+		pusher.Start(ctx)
+		// push after the task ends, so the short tasks leave the metrics too.
+		defer func() {
+			if err := pusher.Stop(); err != nil {
+				log.L().Warn("push metrics failed", log.ShortError(err))
+			}
+		}()
+	}
+
 	failpoint.Inject("SkipRunTask", func() error {
 		if recorder, ok := l.ctx.Value(&taskCfgRecorderKey).(chan *config.Config); ok {
 			select {
@@ -207,22 +272,49 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 		return nil
 	})
 
-	u, err := storage.ParseBackend(taskCfg.Mydumper.SourceDir, &storage.BackendOptions{})
-	if err != nil {
-		return errors.Trace(err)
+	mydump.SetXZConcurrency(taskCfg.Mydumper.XZConcurrency)
+	mydump.SetGzipConcurrency(taskCfg.Mydumper.GzipConcurrency)
+	var s storage.ExternalStorage
+	if taskCfg.Mydumper.IsStream() {
+		// the single table is read from stdin or a named pipe.
+		s = mydump.NewStreamStorage(taskCfg.Mydumper.SourceDir)
+	} else {
+		s, err = mydump.OpenDataSources(ctx, &taskCfg.Mydumper, &storage.BackendOptions{})
+		if err != nil {
+			return errors.Trace(err)
+		}
 	}
-	s, err := storage.Create(ctx, u, true)
+	s = mydump.WrapRetryStorage(s, &taskCfg.Mydumper)
+
+	dumpMeta, err = checkDumpMetadata(ctx, taskCfg, s)
 	if err != nil {
 		return errors.Trace(err)
 	}
 
 	loadTask := log.L().Begin(zap.InfoLevel, "load data source")
 	var mdl *mydump.MDLoader
-	mdl, err = mydump.NewMyDumpLoaderWithStore(ctx, taskCfg, s)
+	mdl, err = loadDataSource(ctx, taskCfg, s)
 	loadTask.End(zap.ErrorLevel, err)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if taskCfg.App.Mode == config.RunModePrecheckData {
+		precheckTask := log.L().Begin(zap.InfoLevel, "precheck data")
+		_, err = restore.PrecheckData(ctx, taskCfg, mdl.GetDatabases(), s)
+		precheckTask.End(zap.ErrorLevel, err)
+		return errors.Trace(err)
+	}
+	if taskCfg.App.Mode == config.RunModeSimulateImpact {
+		var tls *common.TLS
+		tls, err = taskCfg.ToTLS()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		simulateTask := log.L().Begin(zap.InfoLevel, "simulate impact")
+		_, err = restore.SimulateImpact(ctx, taskCfg, mdl.GetDatabases(), tls)
+		simulateTask.End(zap.ErrorLevel, err)
+		return errors.Trace(err)
+	}
 	err = checkSystemRequirement(taskCfg, mdl.GetDatabases())
 	if err != nil {
 		log.L().Error("check system requirements failed", zap.Error(err))
@@ -251,6 +343,7 @@ func (l *Lightning) run(taskCfg *config.Config) (err error) {
 }
 
 func (l *Lightning) Stop() {
+	sdNotify(sdNotifyStopping)
 	if err := l.server.Shutdown(l.ctx); err != nil {
 		log.L().Warn("failed to shutdown HTTP server", log.ShortError(err))
 	}
@@ -393,7 +486,7 @@ func (l *Lightning) handlePostTask(w http.ResponseWriter, req *http.Request) {
 	log.L().Debug("received task config", zap.ByteString("content", data))
 
 	cfg := config.NewConfig()
-	if err = cfg.LoadFromGlobal(l.globalCfg); err != nil {
+	if err = cfg.LoadFromGlobal(l.globalConfig()); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, "cannot restore from global config", err)
 		return
 	}
@@ -518,6 +611,17 @@ func handleProgressTable(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+func handleProgressWarnings(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	res, err := web.MarshalWarnings()
+	if err == nil {
+		writeBytesCompressed(w, req, res)
+	} else {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(err.Error())
+	}
+}
+
 func handlePause(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
@@ -554,6 +658,110 @@ func handleResume(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+type healthReport struct {
+	Healthy    bool                `json:"healthy"`
+	Paused     bool                `json:"paused"`
+	Goroutines int                 `json:"goroutines"`
+	Pools      []worker.PoolStatus `json:"pools"`
+	Suspects   []string            `json:"suspects"`
+}
+
+// checkHealth flags the busy workers without progress for longer than the
+// threshold, which are likely deadlocked, and the number of goroutines far
+// exceeding what the worker pools need, which are likely leaked. Workers are
+// not flagged while the progress is paused.
+func checkHealth(now time.Time, pools []worker.PoolStatus, goroutines int, paused bool, threshold time.Duration) healthReport {
+	report := healthReport{
+		Paused:     paused,
+		Goroutines: goroutines,
+		Pools:      pools,
+		Suspects:   []string{},
+	}
+
+	totalWorkers := 0
+	for _, pool := range pools {
+		totalWorkers += pool.Limit
+		if paused {
+			continue
+		}
+		for _, busy := range pool.Busy {
+			if idle := now.Sub(busy.LastActive); idle > threshold {
+				report.Suspects = append(report.Suspects,
+					fmt.Sprintf("worker %d of pool %s made no progress for %s", busy.ID, pool.Name, idle.Round(time.Second)))
+			}
+		}
+	}
+	if limit := goroutineBase + goroutinesPerWorker*totalWorkers; goroutines > limit {
+		report.Suspects = append(report.Suspects,
+			fmt.Sprintf("%d goroutines exceed the expected maximum %d", goroutines, limit))
+	}
+
+	report.Healthy = len(report.Suspects) == 0
+	return report
+}
+
+func handleHealth(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSONError(w, http.StatusMethodNotAllowed, "only GET is allowed", nil)
+		return
+	}
+
+	threshold := defaultStuckThreshold
+	if value := req.URL.Query().Get("stuck-threshold"); len(value) > 0 {
+		var err error
+		if threshold, err = time.ParseDuration(value); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid stuck-threshold", err)
+			return
+		}
+	}
+
+	report := checkHealth(time.Now(), worker.AllPoolStatus(), runtime.NumGoroutine(), restore.DeliverPauser.IsPaused(), threshold)
+	w.Header().Set("Content-Type", "application/json")
+	if report.Healthy {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// loadDataSource loads the data source. With the checkpoints enabled, the
+// files filtered out in the previous runs are skipped without routing, and
+// the newly filtered files are recorded for the next run, which saves the
+// startup time of the sources with lots of files excluded by the filters.
+func loadDataSource(ctx context.Context, cfg *config.Config, s storage.ExternalStorage) (*mydump.MDLoader, error) {
+	if !cfg.Checkpoint.Enable {
+		return mydump.NewMyDumpLoaderWithStore(ctx, cfg, s)
+	}
+
+	cpdb, err := restore.OpenCheckpointsDB(ctx, cfg)
+	if err != nil {
+		return nil, errors.Annotate(err, "open checkpoints to read the filtered files")
+	}
+	defer cpdb.Close()
+
+	fingerprint := mydump.FilterFingerprint(cfg)
+	filteredFiles, err := cpdb.GetFilteredFiles(ctx, fingerprint)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	mdl, err := mydump.NewMyDumpLoaderWithFilteredFiles(ctx, cfg, s, filteredFiles)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	newFilteredFiles := mdl.GetNewFilteredFiles()
+	if len(newFilteredFiles) > 0 {
+		if err := cpdb.InsertFilteredFiles(ctx, fingerprint, newFilteredFiles); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+	log.L().Info("skipped the filtered files",
+		zap.Int("known", len(filteredFiles)),
+		zap.Int("new", len(newFilteredFiles)))
+	return mdl, nil
+}
+
 func checkSystemRequirement(cfg *config.Config, dbsMeta []*mydump.MDDatabaseMeta) error {
 	// in local mode, we need to read&write a lot of L0 sst files, so we need to check system max open files limit
 	if cfg.TikvImporter.Backend == config.BackendLocal {
@@ -581,6 +789,25 @@ func checkSystemRequirement(cfg *config.Config, dbsMeta []*mydump.MDDatabaseMeta
 	return nil
 }
 
+// checkDumpMetadata reads the metadata file of the dump, and warns if the
+// dump is unfinished, or inconsistent while a consistent snapshot is expected.
+func checkDumpMetadata(ctx context.Context, cfg *config.Config, s storage.ExternalStorage) (*mydump.DumpMetadata, error) {
+	meta, err := mydump.ReadDumpMetadata(ctx, s)
+	if err != nil || meta == nil {
+		return nil, errors.Trace(err)
+	}
+	log.L().Info("found the dump metadata", zap.Reflect("metadata", meta))
+	if !meta.Finished() {
+		common.RecordWarning("", common.WarningUnfinishedDump,
+			"the metadata file does not record the end of the dump, the dump may be interrupted")
+	}
+	if cfg.Mydumper.ExpectConsistentSnapshot && meta.Consistency == mydump.ConsistencyNone {
+		common.RecordWarning("", common.WarningInconsistentDump,
+			"a consistent snapshot is expected, but the dump was taken with the consistency none")
+	}
+	return meta, nil
+}
+
 /// checkSchemaConflict return error if checkpoint table scheme is conflict with data files
 func checkSchemaConflict(cfg *config.Config, dbsMeta []*mydump.MDDatabaseMeta) error {
 	if cfg.Checkpoint.Enable && cfg.Checkpoint.Driver == config.CheckpointDriverMySQL {
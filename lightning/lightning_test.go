@@ -17,9 +17,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -28,10 +32,15 @@ import (
 
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 
+	"github.com/pingcap/br/pkg/storage"
 	. "github.com/pingcap/check"
 	"github.com/pingcap/failpoint"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
 )
 
 type lightningSuite struct{}
@@ -409,6 +418,54 @@ func (s *lightningServerSuite) TestHTTPAPIOutsideServerMode(c *C) {
 	c.Assert(<-errCh, Equals, context.Canceled)
 }
 
+func (s *lightningServerSuite) TestHealth(c *C) {
+	url := "http://" + s.lightning.serverAddr.String() + "/debug/health"
+
+	resp, err := http.Get(url)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	var report healthReport
+	err = json.NewDecoder(resp.Body).Decode(&report)
+	resp.Body.Close()
+	c.Assert(err, IsNil)
+	c.Assert(report.Healthy, IsTrue)
+	c.Assert(report.Goroutines, Greater, 0)
+
+	resp, err = http.Get(url + "?stuck-threshold=forever")
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusBadRequest)
+	resp.Body.Close()
+
+	resp, err = http.Post(url, "application/json", nil)
+	c.Assert(err, IsNil)
+	c.Assert(resp.StatusCode, Equals, http.StatusMethodNotAllowed)
+	resp.Body.Close()
+}
+
+func (s *lightningSuite) TestCheckHealth(c *C) {
+	now := time.Now()
+	pools := []worker.PoolStatus{
+		{Name: "region", Limit: 4, Busy: []worker.BusyWorker{
+			{ID: 1, LastActive: now.Add(-time.Minute)},
+			{ID: 3, LastActive: now.Add(-time.Hour)},
+		}},
+		{Name: "io", Limit: 2},
+	}
+
+	report := checkHealth(now, pools, 10, false, 30*time.Minute)
+	c.Assert(report.Healthy, IsFalse)
+	c.Assert(report.Suspects, DeepEquals, []string{"worker 3 of pool region made no progress for 1h0m0s"})
+
+	// the workers are not stuck while paused.
+	report = checkHealth(now, pools, 10, true, 30*time.Minute)
+	c.Assert(report.Healthy, IsTrue)
+	c.Assert(report.Suspects, HasLen, 0)
+
+	report = checkHealth(now, pools, 2000, false, 2*time.Hour)
+	c.Assert(report.Healthy, IsFalse)
+	c.Assert(report.Suspects, DeepEquals, []string{"2000 goroutines exceed the expected maximum 1096"})
+}
+
 func (s *lightningServerSuite) TestCheckSystemRequirement(c *C) {
 	if runtime.GOOS == "windows" {
 		c.Skip("Local-backend is not supported on Windows")
@@ -527,3 +584,98 @@ func (s *lightningServerSuite) TestCheckSchemaConflict(c *C) {
 	c.Assert(err, IsNil)
 
 }
+
+func (s *lightningSuite) TestCheckDumpMetadata(c *C) {
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	cfg.Mydumper.ExpectConsistentSnapshot = true
+
+	// no metadata file.
+	meta, err := checkDumpMetadata(ctx, cfg, store)
+	c.Assert(err, IsNil)
+	c.Assert(meta, IsNil)
+
+	content := "Started dump at: 2020-11-10 10:40:19\nConsistency: none\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "metadata"), []byte(content), 0644), IsNil)
+	meta, err = checkDumpMetadata(ctx, cfg, store)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, &mydump.DumpMetadata{StartTime: "2020-11-10 10:40:19", Consistency: "none"})
+	warnings := common.Warnings.Summary()
+	c.Assert(warnings, HasLen, 2)
+	c.Assert(warnings[0].Kind, Equals, common.WarningInconsistentDump)
+	c.Assert(warnings[1].Kind, Equals, common.WarningUnfinishedDump)
+
+	common.Warnings.Reset()
+	cfg.Mydumper.ExpectConsistentSnapshot = false
+	_, err = checkDumpMetadata(ctx, cfg, store)
+	c.Assert(err, IsNil)
+	c.Assert(common.Warnings.Summary(), HasLen, 1)
+}
+
+func (s *lightningSuite) TestSDNotify(c *C) {
+	path := filepath.Join(c.MkDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	c.Assert(os.Setenv("NOTIFY_SOCKET", path), IsNil)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	sdNotify(sdNotifyReady, sdStatus("waiting for tasks"))
+
+	buf := make([]byte, 64)
+	c.Assert(conn.SetReadDeadline(time.Now().Add(5*time.Second)), IsNil)
+	n, err := conn.Read(buf)
+	c.Assert(err, IsNil)
+	c.Assert(string(buf[:n]), Equals, "READY=1\nSTATUS=waiting for tasks\n")
+}
+
+func (s *lightningSuite) TestActivatedListener(c *C) {
+	c.Assert(os.Setenv("LISTEN_PID", "1"), IsNil)
+	c.Assert(os.Setenv("LISTEN_FDS", "1"), IsNil)
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+
+	// the sockets are passed to another process.
+	listener, err := activatedListener()
+	c.Assert(err, IsNil)
+	c.Assert(listener, IsNil)
+
+	c.Assert(os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid())), IsNil)
+	c.Assert(os.Setenv("LISTEN_FDS", "2"), IsNil)
+	_, err = activatedListener()
+	c.Assert(err, ErrorMatches, "the status address takes a single socket from systemd, but got 2")
+}
+
+func (s *lightningSuite) TestReload(c *C) {
+	cfg := config.NewGlobalConfig()
+	cfg.App.StatusAddr = ":8289"
+	cfg.App.Config.Level = "info"
+	cfg.Mydumper.SourceDir = "file://old"
+	lightning := New(cfg)
+	oldLevel := log.SetLevel(zapcore.InfoLevel)
+	defer log.SetLevel(oldLevel)
+
+	newCfg := config.NewGlobalConfig()
+	newCfg.App.StatusAddr = ":8290"
+	newCfg.App.Config.Level = "warning"
+	newCfg.Mydumper.SourceDir = "file://new"
+	lightning.Reload(newCfg)
+
+	globalCfg := lightning.globalConfig()
+	c.Assert(globalCfg.App.StatusAddr, Equals, ":8289")
+	c.Assert(globalCfg.App.Config.Level, Equals, "warning")
+	c.Assert(globalCfg.Mydumper.SourceDir, Equals, "file://new")
+	c.Assert(log.SetLevel(zapcore.WarnLevel), Equals, zapcore.WarnLevel)
+
+	// the invalid level is ignored.
+	newCfg = config.NewGlobalConfig()
+	newCfg.App.Config.Level = "verbose"
+	lightning.Reload(newCfg)
+	c.Assert(lightning.globalConfig().App.Config.Level, Equals, "warning")
+}
@@ -28,6 +28,7 @@ const (
 	TableStateImported       = "imported"
 	TableStateAlteredAutoInc = "altered_auto_inc"
 	TableStateChecksum       = "checksum"
+	TableStateCompacted      = "compacted"
 	TableStateCompleted      = "completed"
 
 	// results used for the TableCounter labels
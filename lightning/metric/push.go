@@ -0,0 +1,292 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/pingcap/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// pushTimeout limits the time of each push to either target.
+const pushTimeout = 30 * time.Second
+
+// PushConfig is where and how often the metrics are pushed.
+type PushConfig struct {
+	// PushGatewayAddr is the URL of the Prometheus Pushgateway, or empty.
+	PushGatewayAddr string
+	// RemoteWriteURL is the URL of the Prometheus remote write receiver, or
+	// empty.
+	RemoteWriteURL string
+	Job            string
+	Labels         map[string]string
+	Interval       time.Duration
+}
+
+// Pusher pushes the gathered metrics to the Pushgateway and the remote write
+// receiver periodically, so the metrics of the tasks not scraped by Prometheus
+// are still recorded.
+type Pusher struct {
+	cfg      PushConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	gateway  *push.Pusher
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewPusher creates a pusher of the metrics of the gatherer.
+func NewPusher(cfg PushConfig, gatherer prometheus.Gatherer) *Pusher {
+	p := &Pusher{
+		cfg:      cfg,
+		gatherer: gatherer,
+		client:   &http.Client{Timeout: pushTimeout},
+	}
+	if len(cfg.PushGatewayAddr) > 0 {
+		p.gateway = push.New(cfg.PushGatewayAddr, cfg.Job).Gatherer(gatherer).Client(p.client)
+		names := make([]string, 0, len(cfg.Labels))
+		for name := range cfg.Labels {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			p.gateway = p.gateway.Grouping(name, cfg.Labels[name])
+		}
+	}
+	return p
+}
+
+// Start pushes the metrics every interval in the background until Stop.
+func (p *Pusher) Start(ctx context.Context) {
+	ctx, p.cancel = context.WithCancel(ctx)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := p.Push(); err != nil {
+					log.L().Warn("push metrics failed", log.ShortError(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the background pushes, and pushes the metrics for the last
+// time, so the final values of a short task are still recorded.
+func (p *Pusher) Stop() error {
+	if p.cancel != nil {
+		p.cancel()
+		p.wg.Wait()
+	}
+	return p.Push()
+}
+
+// Push pushes the current metrics to all targets once. A failed target does
+// not stop pushing to the other.
+func (p *Pusher) Push() error {
+	var err error
+	if p.gateway != nil {
+		// PUT replaces all metrics of the group with the gathered ones.
+		if e := p.gateway.Push(); e != nil {
+			err = errors.Annotate(e, "push to the Pushgateway")
+		}
+	}
+	if len(p.cfg.RemoteWriteURL) > 0 {
+		if e := p.remoteWrite(); e != nil {
+			e = errors.Annotate(e, "remote write")
+			if err == nil {
+				err = e
+			} else {
+				log.L().Warn("push metrics failed", log.ShortError(e))
+			}
+		}
+	}
+	return err
+}
+
+func (p *Pusher) remoteWrite() error {
+	families, err := p.gatherer.Gather()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	labels := make(map[string]string, len(p.cfg.Labels)+1)
+	for name, value := range p.cfg.Labels {
+		labels[name] = value
+	}
+	labels["job"] = p.cfg.Job
+	body := snappy.Encode(nil, encodeWriteRequest(families, labels, time.Now().UnixNano()/int64(time.Millisecond)))
+
+	req, err := http.NewRequest(http.MethodPost, p.cfg.RemoteWriteURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+		return errors.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	log.L().Debug("remote write metrics", zap.Int("families", len(families)), zap.Int("size", len(body)))
+	return nil
+}
+
+// encodeWriteRequest encodes the metric families as the protobuf WriteRequest
+// of the Prometheus remote write protocol, i.e.
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//
+// The histograms and the summaries are expanded into the series of the
+// buckets or the quantiles, the sum and the count like the text exposition.
+// The extra labels are added unless the metric has the labels of the names.
+func encodeWriteRequest(families []*dto.MetricFamily, extra map[string]string, now int64) []byte {
+	var buf []byte
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			labels := make(map[string]string, len(m.GetLabel())+len(extra)+2)
+			for n, v := range extra {
+				labels[n] = v
+			}
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			series := func(suffix string, value float64, labelName string, labelValue string) {
+				labels["__name__"] = name + suffix
+				if len(labelName) > 0 {
+					labels[labelName] = labelValue
+					defer delete(labels, labelName)
+				}
+				buf = appendProtoBytes(buf, 1, encodeTimeSeries(labels, value, ts))
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				series("", m.GetCounter().GetValue(), "", "")
+			case dto.MetricType_GAUGE:
+				series("", m.GetGauge().GetValue(), "", "")
+			case dto.MetricType_UNTYPED:
+				series("", m.GetUntyped().GetValue(), "", "")
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					series("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				series("_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				series("_sum", h.GetSampleSum(), "", "")
+				series("_count", float64(h.GetSampleCount()), "", "")
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					series("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				series("_sum", s.GetSampleSum(), "", "")
+				series("_count", float64(s.GetSampleCount()), "", "")
+			}
+		}
+	}
+	return buf
+}
+
+// encodeTimeSeries encodes a TimeSeries of one sample. The labels are sorted
+// by the names as required by the receivers.
+func encodeTimeSeries(labels map[string]string, value float64, ts int64) []byte {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf []byte
+	for _, name := range names {
+		var label []byte
+		label = appendProtoBytes(label, 1, []byte(name))
+		label = appendProtoBytes(label, 2, []byte(labels[name]))
+		buf = appendProtoBytes(buf, 1, label)
+	}
+	var sample []byte
+	sample = appendProtoKey(sample, 1, 1)
+	var bits [8]byte
+	binary.LittleEndian.PutUint64(bits[:], math.Float64bits(value))
+	sample = append(sample, bits[:]...)
+	sample = appendProtoKey(sample, 2, 0)
+	sample = appendProtoVarint(sample, uint64(ts))
+	return appendProtoBytes(buf, 2, sample)
+}
+
+func appendProtoVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+func appendProtoKey(buf []byte, field int, wireType int) []byte {
+	return appendProtoVarint(buf, uint64(field<<3|wireType))
+}
+
+// appendProtoBytes appends the length-delimited field.
+func appendProtoBytes(buf []byte, field int, data []byte) []byte {
+	buf = appendProtoKey(buf, field, 2)
+	buf = appendProtoVarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/golang/snappy"
+	. "github.com/pingcap/check"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var _ = Suite(&testPushSuite{})
+
+type testPushSuite struct{}
+
+type testSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+// decodeProtoFields splits the protobuf message into the fields, keeping the
+// varints and the fixed64 as the integers and the others as the bytes.
+func decodeProtoFields(c *C, data []byte) (fields []int, values []interface{}) {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		c.Assert(n, Greater, 0)
+		data = data[n:]
+		fields = append(fields, int(key>>3))
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(data)
+			c.Assert(n, Greater, 0)
+			data = data[n:]
+			values = append(values, v)
+		case 1:
+			values = append(values, binary.LittleEndian.Uint64(data))
+			data = data[8:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			c.Assert(n, Greater, 0)
+			values = append(values, data[n:n+int(length)])
+			data = data[n+int(length):]
+		default:
+			c.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+	return
+}
+
+func decodeWriteRequest(c *C, data []byte) []testSeries {
+	var result []testSeries
+	_, tss := decodeProtoFields(c, data)
+	for _, ts := range tss {
+		series := testSeries{labels: make(map[string]string)}
+		fields, values := decodeProtoFields(c, ts.([]byte))
+		var lastName string
+		for i, field := range fields {
+			_, parts := decodeProtoFields(c, values[i].([]byte))
+			if field == 1 {
+				name, value := string(parts[0].([]byte)), string(parts[1].([]byte))
+				c.Assert(name > lastName, IsTrue, Commentf("labels must be sorted"))
+				lastName = name
+				series.labels[name] = value
+			} else {
+				series.value = math.Float64frombits(parts[0].(uint64))
+				series.timestamp = int64(parts[1].(uint64))
+			}
+		}
+		result = append(result, series)
+	}
+	return result
+}
+
+func (s *testPushSuite) TestEncodeWriteRequest(c *C) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "rows"}, []string{"table"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "seconds", Buckets: []float64{0.5, 2}})
+	registry.MustRegister(counter, histogram)
+	counter.WithLabelValues("t1").Add(3)
+	histogram.Observe(1)
+
+	families, err := registry.Gather()
+	c.Assert(err, IsNil)
+	series := decodeWriteRequest(c, encodeWriteRequest(families, map[string]string{"job": "j", "table": "ignored"}, 1000))
+
+	c.Assert(series, DeepEquals, []testSeries{
+		{labels: map[string]string{"__name__": "rows", "job": "j", "table": "t1"}, value: 3, timestamp: 1000},
+		{labels: map[string]string{"__name__": "seconds_bucket", "job": "j", "table": "ignored", "le": "0.5"}, value: 0, timestamp: 1000},
+		{labels: map[string]string{"__name__": "seconds_bucket", "job": "j", "table": "ignored", "le": "2"}, value: 1, timestamp: 1000},
+		{labels: map[string]string{"__name__": "seconds_bucket", "job": "j", "table": "ignored", "le": "+Inf"}, value: 1, timestamp: 1000},
+		{labels: map[string]string{"__name__": "seconds_sum", "job": "j", "table": "ignored"}, value: 1, timestamp: 1000},
+		{labels: map[string]string{"__name__": "seconds_count", "job": "j", "table": "ignored"}, value: 1, timestamp: 1000},
+	})
+}
+
+func (s *testPushSuite) TestPusher(c *C) {
+	registry := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "progress"})
+	registry.MustRegister(gauge)
+	gauge.Set(0.5)
+
+	var (
+		mu          sync.Mutex
+		gatewayReqs []string
+		remoteReqs  [][]testSeries
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		gatewayReqs = append(gatewayReqs, req.Method+" "+req.URL.Path)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer gateway.Close()
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		c.Check(req.Header.Get("Content-Encoding"), Equals, "snappy")
+		body, err := ioutil.ReadAll(req.Body)
+		c.Check(err, IsNil)
+		data, err := snappy.Decode(nil, body)
+		c.Check(err, IsNil)
+		remoteReqs = append(remoteReqs, decodeWriteRequest(c, data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer remote.Close()
+
+	pusher := NewPusher(PushConfig{
+		PushGatewayAddr: gateway.URL,
+		RemoteWriteURL:  remote.URL,
+		Job:             "j",
+		Labels:          map[string]string{"instance": "a"},
+	}, registry)
+	// the final push happens even if the pusher is never started.
+	c.Assert(pusher.Stop(), IsNil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	c.Assert(gatewayReqs, DeepEquals, []string{"PUT /metrics/job/j/instance/a"})
+	c.Assert(remoteReqs, HasLen, 1)
+	c.Assert(remoteReqs[0], HasLen, 1)
+	c.Assert(remoteReqs[0][0].labels, DeepEquals, map[string]string{"__name__": "progress", "job": "j", "instance": "a"})
+	c.Assert(remoteReqs[0][0].value, Equals, 0.5)
+}
+
+func (s *testPushSuite) TestPushFailure(c *C) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer remote.Close()
+
+	pusher := NewPusher(PushConfig{RemoteWriteURL: remote.URL, Job: "j"}, prometheus.NewRegistry())
+	c.Assert(pusher.Push(), ErrorMatches, "remote write: unexpected status 400 Bad Request: out of order sample")
+}
@@ -0,0 +1,439 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+)
+
+type archiveKind int
+
+const (
+	archiveNone archiveKind = iota
+	archiveZip
+	archiveTar
+	archiveTarGz
+)
+
+func archiveKindOf(name string) archiveKind {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	default:
+		return archiveNone
+	}
+}
+
+// splitArchivePath splits the path of a member into the path of the archive
+// and the name of the member in the archive.
+func splitArchivePath(name string) (archive string, member string, ok bool) {
+	parts := strings.Split(name, "/")
+	for i := 0; i < len(parts)-1; i++ {
+		if archiveKindOf(parts[i]) != archiveNone {
+			return strings.Join(parts[:i+1], "/"), strings.Join(parts[i+1:], "/"), true
+		}
+	}
+	return name, "", false
+}
+
+// cleanMemberName normalizes the name of a member, which may start with "./"
+// in the tar archives.
+func cleanMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// archiveStorage wraps a storage to treat the zip and tar archives as the
+// directories, so the dumps delivered as a single archive are imported
+// without the extraction. The member `db.t.sql` of `dump.zip` is listed and
+// opened as `dump.zip/db.t.sql`. The methods not overridden are passed to the
+// wrapped storage as is.
+type archiveStorage struct {
+	storage.ExternalStorage
+}
+
+// NewArchiveStorage wraps the storage to read the members of the archives.
+func NewArchiveStorage(inner storage.ExternalStorage) storage.ExternalStorage {
+	return &archiveStorage{ExternalStorage: inner}
+}
+
+func (s *archiveStorage) WalkDir(ctx context.Context, opt *storage.WalkOption, fn func(path string, size int64) error) error {
+	return s.ExternalStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+		if archiveKindOf(path) == archiveNone {
+			return fn(path, size)
+		}
+		return errors.Annotatef(s.walkArchive(ctx, path, fn), "failed to list the members of archive '%s'", path)
+	})
+}
+
+func (s *archiveStorage) walkArchive(ctx context.Context, archive string, fn func(path string, size int64) error) error {
+	if archiveKindOf(archive) == archiveZip {
+		zr, file, err := s.openZip(ctx, archive)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			if err := fn(archive+"/"+cleanMemberName(f.Name), int64(f.UncompressedSize64)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tr, file, err := s.openTar(ctx, archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := fn(archive+"/"+cleanMemberName(hdr.Name), hdr.Size); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *archiveStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	archive, member, ok := splitArchivePath(name)
+	if !ok {
+		return s.ExternalStorage.Open(ctx, name)
+	}
+	switch archiveKindOf(archive) {
+	case archiveZip:
+		return s.openZipMember(ctx, archive, member)
+	case archiveTar:
+		return s.openTarMember(ctx, archive, member)
+	}
+
+	r := &memberReader{open: func() (io.Reader, io.Closer, error) {
+		tr, file, err := s.openTar(ctx, archive)
+		if err != nil {
+			return nil, nil, err
+		}
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				file.Close()
+				return nil, nil, errors.NotFoundf("member %s of archive %s", member, archive)
+			}
+			if err != nil {
+				file.Close()
+				return nil, nil, errors.Trace(err)
+			}
+			if cleanMemberName(hdr.Name) == member {
+				return tr, file, nil
+			}
+		}
+	}}
+	if err := r.reopen(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *archiveStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	if _, _, ok := splitArchivePath(name); !ok {
+		return s.ExternalStorage.Read(ctx, name)
+	}
+	r, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	return data, errors.Trace(err)
+}
+
+func (s *archiveStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	if _, _, ok := splitArchivePath(name); !ok {
+		return s.ExternalStorage.FileExists(ctx, name)
+	}
+	r, err := s.Open(ctx, name)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, r.Close()
+}
+
+func (s *archiveStorage) openZip(ctx context.Context, archive string) (*zip.Reader, storage.ReadSeekCloser, error) {
+	file, err := s.ExternalStorage.Open(ctx, archive)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Trace(err)
+	}
+	zr, err := zip.NewReader(&readerAt{file: file}, size)
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Trace(err)
+	}
+	for method, compression := range zipMethods {
+		zr.RegisterDecompressor(method, zipDecompressor(compression))
+	}
+	return zr, file, nil
+}
+
+// zipMethods are the compression methods of the zip members supported besides
+// the deflate, which are used by 7-Zip and Info-ZIP for the better ratio.
+var zipMethods = map[uint16]Compression{
+	12: CompressionBZ2,
+	93: CompressionZStd,
+	95: CompressionXZ,
+}
+
+func zipDecompressor(compression Compression) zip.Decompressor {
+	return func(r io.Reader) io.ReadCloser {
+		d, err := newDecoder(compression, r)
+		if err != nil {
+			return errReadCloser{err: err}
+		}
+		return decoderReadCloser{decoder: d}
+	}
+}
+
+type decoderReadCloser struct {
+	decoder
+}
+
+func (r decoderReadCloser) Close() error {
+	r.decoder.Close()
+	return nil
+}
+
+// errReadCloser reports the error of creating the decompressor on reading,
+// since zip.Decompressor cannot return it.
+type errReadCloser struct {
+	err error
+}
+
+func (r errReadCloser) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func (r errReadCloser) Close() error {
+	return nil
+}
+
+func (s *archiveStorage) openZipMember(ctx context.Context, archive string, member string) (storage.ReadSeekCloser, error) {
+	zr, file, err := s.openZip(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if cleanMemberName(f.Name) != member {
+			continue
+		}
+		// the stored members are read in place with seeking.
+		if f.Method == zip.Store {
+			offset, err := f.DataOffset()
+			if err != nil {
+				file.Close()
+				return nil, errors.Trace(err)
+			}
+			section := io.NewSectionReader(&readerAt{file: file}, offset, int64(f.UncompressedSize64))
+			return &sectionReadCloser{SectionReader: section, file: file}, nil
+		}
+		f := f
+		r := &memberReader{open: func() (io.Reader, io.Closer, error) {
+			rc, err := f.Open()
+			return rc, rc, errors.Trace(err)
+		}, file: file}
+		if err := r.reopen(); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return r, nil
+	}
+	file.Close()
+	return nil, errors.NotFoundf("member %s of archive %s", member, archive)
+}
+
+// openTarMember opens a member of an uncompressed tar archive, which is read
+// in place with seeking like the stored zip members, so the chunks of the
+// member are read without scanning the archive from the start.
+func (s *archiveStorage) openTarMember(ctx context.Context, archive string, member string) (storage.ReadSeekCloser, error) {
+	file, err := s.ExternalStorage.Open(ctx, archive)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	// the tar reader skips the data of the other members by seeking.
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			file.Close()
+			return nil, errors.NotFoundf("member %s of archive %s", member, archive)
+		}
+		if err != nil {
+			file.Close()
+			return nil, errors.Trace(err)
+		}
+		if cleanMemberName(hdr.Name) != member {
+			continue
+		}
+		// the header is followed by the data immediately.
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			file.Close()
+			return nil, errors.Trace(err)
+		}
+		section := io.NewSectionReader(&readerAt{file: file}, offset, hdr.Size)
+		return &sectionReadCloser{SectionReader: section, file: file}, nil
+	}
+}
+
+func (s *archiveStorage) openTar(ctx context.Context, archive string) (*tar.Reader, io.Closer, error) {
+	file, err := s.ExternalStorage.Open(ctx, archive)
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+	if archiveKindOf(archive) != archiveTarGz {
+		return tar.NewReader(file), file, nil
+	}
+	gr, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, errors.Trace(err)
+	}
+	return tar.NewReader(gr), file, nil
+}
+
+// readerAt reads a file at the offsets for the zip reader.
+type readerAt struct {
+	mu   sync.Mutex
+	file storage.ReadSeekCloser
+}
+
+func (r *readerAt) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.file.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.file, p)
+}
+
+type sectionReadCloser struct {
+	*io.SectionReader
+	file io.Closer
+}
+
+func (r *sectionReadCloser) Close() error {
+	return r.file.Close()
+}
+
+// memberReader reads a compressed member of an archive, which can only be
+// read sequentially. Seeking backwards reopens the member from the start.
+type memberReader struct {
+	open func() (io.Reader, io.Closer, error)
+	// file is the archive kept open across the reopening, if any.
+	file io.Closer
+
+	reader io.Reader
+	closer io.Closer
+	pos    int64
+}
+
+func (r *memberReader) reopen() error {
+	if r.closer != nil {
+		r.closer.Close()
+	}
+	reader, closer, err := r.open()
+	if err != nil {
+		r.reader, r.closer = nil, nil
+		return err
+	}
+	r.reader, r.closer, r.pos = reader, closer, 0
+	return nil
+}
+
+func (r *memberReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		return 0, errors.New("read a closed archive member")
+	}
+	n, err := r.reader.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *memberReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, errors.New("cannot seek from the end of an archive member")
+	}
+	if offset < 0 {
+		return r.pos, errors.Errorf("invalid seek offset %d", offset)
+	}
+	if offset < r.pos {
+		if err := r.reopen(); err != nil {
+			return r.pos, err
+		}
+	}
+	if offset > r.pos {
+		n, err := io.CopyN(ioutil.Discard, r.reader, offset-r.pos)
+		r.pos += n
+		if err != nil {
+			return r.pos, errors.Annotatef(err, "seek to offset %d of the archive member", offset)
+		}
+	}
+	return r.pos, nil
+}
+
+func (r *memberReader) Close() error {
+	if r.closer != nil {
+		r.closer.Close()
+		r.reader, r.closer = nil, nil
+	}
+	if r.file != nil {
+		return r.file.Close()
+	}
+	return nil
+}
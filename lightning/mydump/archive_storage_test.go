@@ -0,0 +1,200 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testArchiveStorageSuite{})
+
+type testArchiveStorageSuite struct{}
+
+var archiveMembers = []struct {
+	name string
+	data string
+}{
+	{"db-schema-create.sql", "CREATE DATABASE db;"},
+	{"./db.t-schema.sql", "CREATE TABLE t (a INT);"},
+	{"sub/db.t.0001.sql", strings.Repeat("INSERT INTO t VALUES (1);\n", 100)},
+}
+
+func writeZip(c *C, path string) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, m := range archiveMembers {
+		// the first member is stored without the compression.
+		method := zip.Deflate
+		if i == 0 {
+			method = zip.Store
+		}
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: m.name, Method: method})
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(m.data))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(ioutil.WriteFile(path, buf.Bytes(), 0644), IsNil)
+}
+
+func writeTar(c *C, path string, gzipped bool) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	gw := gzip.NewWriter(&buf)
+	if gzipped {
+		w = gw
+	}
+	tw := tar.NewWriter(w)
+	c.Assert(tw.WriteHeader(&tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755}), IsNil)
+	for _, m := range archiveMembers {
+		c.Assert(tw.WriteHeader(&tar.Header{Name: m.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(m.data))}), IsNil)
+		_, err := tw.Write([]byte(m.data))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(tw.Close(), IsNil)
+	if gzipped {
+		c.Assert(gw.Close(), IsNil)
+	}
+	c.Assert(ioutil.WriteFile(path, buf.Bytes(), 0644), IsNil)
+}
+
+func (s *testArchiveStorageSuite) TestArchiveStorage(c *C) {
+	dir := c.MkDir()
+	writeZip(c, filepath.Join(dir, "dump.zip"))
+	writeTar(c, filepath.Join(dir, "dump.tar.gz"), true)
+	writeTar(c, filepath.Join(dir, "dump.tar"), false)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.u.sql"), []byte("INSERT INTO u VALUES (1);"), 0644), IsNil)
+
+	inner, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	store := mydump.NewArchiveStorage(inner)
+	ctx := context.Background()
+
+	files := make(map[string]int64)
+	err = store.WalkDir(ctx, &storage.WalkOption{}, func(path string, size int64) error {
+		files[filepath.ToSlash(path)] = size
+		return nil
+	})
+	c.Assert(err, IsNil)
+	expected := map[string]int64{"db.u.sql": 25}
+	for _, archive := range []string{"dump.zip", "dump.tar.gz", "dump.tar"} {
+		expected[archive+"/db-schema-create.sql"] = int64(len(archiveMembers[0].data))
+		expected[archive+"/db.t-schema.sql"] = int64(len(archiveMembers[1].data))
+		expected[archive+"/sub/db.t.0001.sql"] = int64(len(archiveMembers[2].data))
+	}
+	c.Assert(files, DeepEquals, expected)
+
+	for path := range expected {
+		if !strings.HasSuffix(path, "db.t.0001.sql") {
+			continue
+		}
+		r, err := store.Open(ctx, path)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, archiveMembers[2].data)
+
+		// seeking backwards reopens the member.
+		pos, err := r.Seek(26, io.SeekStart)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, int64(26))
+		data, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, archiveMembers[2].data[26:])
+		c.Assert(r.Close(), IsNil)
+	}
+
+	data, err := store.Read(ctx, "dump.zip/db-schema-create.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, archiveMembers[0].data)
+
+	exists, err := store.FileExists(ctx, "dump.tar.gz/db.t-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "dump.zip/db.t-schema.sql.gz")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+}
+
+func (s *testArchiveStorageSuite) TestLoadArchive(c *C) {
+	dir := c.MkDir()
+	writeZip(c, filepath.Join(dir, "dump.zip"))
+
+	cfg := newConfigWithSourceDir(dir)
+	loader, err := mydump.NewMyDumpLoader(context.Background(), cfg)
+	c.Assert(err, IsNil)
+	dbs := loader.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Name, Equals, "db")
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	c.Assert(dbs[0].Tables[0].SchemaFile.FileMeta.Path, Equals, "dump.zip/db.t-schema.sql")
+	c.Assert(dbs[0].Tables[0].DataFiles, HasLen, 1)
+	c.Assert(dbs[0].Tables[0].DataFiles[0].FileMeta.Path, Equals, "dump.zip/sub/db.t.0001.sql")
+}
+
+func (s *testArchiveStorageSuite) TestLoadTarGz(c *C) {
+	dir := c.MkDir()
+	writeTar(c, filepath.Join(dir, "dump.tar.gz"), true)
+
+	cfg := newConfigWithSourceDir(dir)
+	loader, err := mydump.NewMyDumpLoader(context.Background(), cfg)
+	c.Assert(err, IsNil)
+	dbs := loader.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	tblMeta := dbs[0].Tables[0]
+	c.Assert(tblMeta.SchemaFile.FileMeta.Path, Equals, "dump.tar.gz/db.t-schema.sql")
+	c.Assert(tblMeta.DataFiles, HasLen, 1)
+	c.Assert(tblMeta.DataFiles[0].FileMeta.Path, Equals, "dump.tar.gz/sub/db.t.0001.sql")
+	c.Assert(tblMeta.DataFiles[0].Size, Equals, int64(len(archiveMembers[2].data)))
+	c.Assert(tblMeta.GetSchema(context.Background(), loader.GetStore()), Equals, archiveMembers[1].data)
+}
+
+func (s *testArchiveStorageSuite) TestZipZStdMember(c *C) {
+	dir := c.MkDir()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	// 93 is the zstd method of the zip members.
+	zw.RegisterCompressor(93, func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w)
+	})
+	data := strings.Repeat("1,2,3\n", 1000)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "db.t.csv", Method: 93})
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "dump.zip"), buf.Bytes(), 0644), IsNil)
+
+	inner, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	store := mydump.NewArchiveStorage(inner)
+	read, err := store.Read(context.Background(), "dump.zip/db.t.csv")
+	c.Assert(err, IsNil)
+	c.Assert(string(read), Equals, data)
+}
@@ -0,0 +1,426 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	arrowMagic     = "ARROW1"
+	featherV1Magic = "FEA1"
+	// arrowContinuation starts the messages of the stream format since Arrow
+	// 0.15.
+	arrowContinuation = 0xFFFFFFFF
+)
+
+// arrowReaderAt adapts the reader for the arrow file reader, which reads the
+// footer and the record batches at their offsets.
+type arrowReaderAt struct {
+	ReadSeekCloser
+}
+
+func (r arrowReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// recoverArrowPanic turns the panics of the arrow library on the data it
+// does not support, e.g. the dictionary encoded columns, into errors.
+func recoverArrowPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = errors.Errorf("unsupported arrow file: %v", r)
+	}
+}
+
+// checkArrowType returns an error if the values of the type cannot be
+// converted to datums.
+func checkArrowType(typ arrow.DataType) error {
+	switch typ.(type) {
+	case *arrow.NullType, *arrow.BooleanType,
+		*arrow.Int8Type, *arrow.Int16Type, *arrow.Int32Type, *arrow.Int64Type,
+		*arrow.Uint8Type, *arrow.Uint16Type, *arrow.Uint32Type, *arrow.Uint64Type,
+		*arrow.Float32Type, *arrow.Float64Type, *arrow.Decimal128Type,
+		*arrow.Date32Type, *arrow.Date64Type, *arrow.Time32Type, *arrow.Time64Type, *arrow.TimestampType,
+		*arrow.BinaryType, *arrow.StringType, *arrow.FixedSizeBinaryType:
+		return nil
+	default:
+		return errors.Errorf("unsupported arrow type %s", typ.Name())
+	}
+}
+
+// arrowUnitNanos returns the nanoseconds of the time unit.
+func arrowUnitNanos(unit arrow.TimeUnit) int64 {
+	switch unit {
+	case arrow.Second:
+		return int64(time.Second)
+	case arrow.Millisecond:
+		return int64(time.Millisecond)
+	case arrow.Microsecond:
+		return int64(time.Microsecond)
+	default:
+		return 1
+	}
+}
+
+// arrowUnitTime returns the time of the value in the time unit since the
+// epoch.
+func arrowUnitTime(v int64, unit arrow.TimeUnit) time.Time {
+	nanos := arrowUnitNanos(unit)
+	return time.Unix(v/(int64(time.Second)/nanos), v%(int64(time.Second)/nanos)*nanos).UTC()
+}
+
+// setArrowDatum converts the i-th value of the column into the datum. The
+// bytes are copied, since the buffers of the record batch are reused once the
+// next batch is read.
+func setArrowDatum(d *types.Datum, column array.Interface, i int) {
+	if column.IsNull(i) {
+		d.SetNull()
+		return
+	}
+	switch a := column.(type) {
+	case *array.Boolean:
+		if a.Value(i) {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case *array.Int8:
+		d.SetInt64(int64(a.Value(i)))
+	case *array.Int16:
+		d.SetInt64(int64(a.Value(i)))
+	case *array.Int32:
+		d.SetInt64(int64(a.Value(i)))
+	case *array.Int64:
+		d.SetInt64(a.Value(i))
+	case *array.Uint8:
+		d.SetUint64(uint64(a.Value(i)))
+	case *array.Uint16:
+		d.SetUint64(uint64(a.Value(i)))
+	case *array.Uint32:
+		d.SetUint64(uint64(a.Value(i)))
+	case *array.Uint64:
+		d.SetUint64(a.Value(i))
+	case *array.Float32:
+		d.SetFloat32(a.Value(i))
+	case *array.Float64:
+		d.SetFloat64(a.Value(i))
+	case *array.Decimal128:
+		v := a.Value(i)
+		unscaled := new(big.Int).Lsh(big.NewInt(v.HighBits()), 64)
+		unscaled.Or(unscaled, new(big.Int).SetUint64(v.LowBits()))
+		scale := a.DataType().(*arrow.Decimal128Type).Scale
+		d.SetString(formatScaledDecimal(unscaled, int(scale)), "")
+	case *array.Date32:
+		days := int64(a.Value(i))
+		d.SetString(time.Unix(days*24*60*60, 0).UTC().Format("2006-01-02"), "")
+	case *array.Date64:
+		d.SetString(arrowUnitTime(int64(a.Value(i)), arrow.Millisecond).Format("2006-01-02"), "")
+	case *array.Time32:
+		t := time.Unix(0, int64(a.Value(i))*arrowUnitNanos(a.DataType().(*arrow.Time32Type).Unit)).UTC()
+		d.SetString(t.Format("15:04:05.999999999"), "")
+	case *array.Time64:
+		t := time.Unix(0, int64(a.Value(i))*arrowUnitNanos(a.DataType().(*arrow.Time64Type).Unit)).UTC()
+		d.SetString(t.Format("15:04:05.999999999"), "")
+	case *array.Timestamp:
+		// the timestamps with time zones are normalized to UTC, and the ones
+		// without are the wall clock time.
+		t := arrowUnitTime(int64(a.Value(i)), a.DataType().(*arrow.TimestampType).Unit)
+		d.SetString(t.Format("2006-01-02 15:04:05.999999999"), "")
+	case *array.FixedSizeBinary:
+		d.SetBytes(append([]byte(nil), a.Value(i)...))
+	case *array.Binary:
+		d.SetBytes(append([]byte(nil), a.Value(i)...))
+	case *array.String:
+		d.SetString(a.Value(i), "")
+	default:
+		d.SetNull()
+	}
+}
+
+// arrowBatch is a record batch in the arrow file.
+type arrowBatch struct {
+	startRow int64
+	rows     int64
+	// size is the size of the buffers of the batch.
+	size int64
+}
+
+// ArrowParser reads the rows from an Arrow IPC file with the arrow library,
+// either in the file format (Feather V2) or the stream format. Only the flat
+// columns of the primitive types are supported, and the dictionary encoded
+// columns are not. Like the parquet files, the position is the number of rows
+// read.
+//
+// The record batches are read once when the file is opened to index their
+// rows, so the parser can seek to any row. The file format reads the record
+// batch containing the row directly, while the stream format reads the
+// stream again from the start when seeking back.
+type ArrowParser struct {
+	reader  ReadSeekCloser
+	file    *ipc.FileReader
+	stream  *ipc.Reader
+	schema  *arrow.Schema
+	columns []string
+	batches []arrowBatch
+	numRows int64
+	// streamIndex is the index of the next record batch of the stream.
+	streamIndex int
+
+	batchIndex int
+	record     array.Record
+	rowInBatch int64
+	pos        int64
+	lastRow    Row
+	logger     log.Logger
+}
+
+func NewArrowParser(reader ReadSeekCloser) (*ArrowParser, error) {
+	parser := &ArrowParser{
+		reader:     reader,
+		batchIndex: -1,
+		logger:     log.L(),
+	}
+	if err := parser.readIndex(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return parser, nil
+}
+
+// readIndex opens the file, checks the schema and counts the rows of the
+// record batches.
+func (p *ArrowParser) readIndex() (err error) {
+	defer recoverArrowPanic(&err)
+
+	head := make([]byte, len(arrowMagic))
+	n, err := io.ReadFull(p.reader, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return errors.Trace(err)
+	}
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, []byte(featherV1Magic)):
+		return errors.New("feather v1 files are not supported, please convert them to feather v2")
+	case bytes.HasPrefix(head, []byte(arrowMagic)):
+		if p.file, err = ipc.NewFileReader(arrowReaderAt{p.reader}); err != nil {
+			return errors.Annotate(err, "not an arrow file")
+		}
+		p.schema = p.file.Schema()
+	case len(head) < 4 || binary.LittleEndian.Uint32(head) != arrowContinuation:
+		return errors.New("not an arrow file")
+	default:
+		if err = p.openStream(); err != nil {
+			return errors.Annotate(err, "not an arrow file")
+		}
+		p.schema = p.stream.Schema()
+	}
+
+	fields := p.schema.Fields()
+	p.columns = make([]string, 0, len(fields))
+	for _, field := range fields {
+		if err := checkArrowType(field.Type); err != nil {
+			return errors.Annotatef(err, "column '%s'", field.Name)
+		}
+		p.columns = append(p.columns, strings.ToLower(field.Name))
+	}
+
+	for i := 0; ; i++ {
+		record, err := p.readBatch(i)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Annotatef(err, "invalid arrow record batch %d", i)
+		}
+		batch := arrowBatch{startRow: p.numRows, rows: record.NumRows()}
+		for _, column := range record.Columns() {
+			for _, buf := range column.Data().Buffers() {
+				if buf != nil {
+					batch.size += int64(buf.Len())
+				}
+			}
+		}
+		p.batches = append(p.batches, batch)
+		p.numRows += batch.rows
+	}
+	return nil
+}
+
+// openStream reads the stream format from the start.
+func (p *ArrowParser) openStream() error {
+	if _, err := p.reader.Seek(0, io.SeekStart); err != nil {
+		return errors.Trace(err)
+	}
+	if p.stream != nil {
+		p.stream.Release()
+	}
+	stream, err := ipc.NewReader(bufio.NewReader(p.reader))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	p.stream = stream
+	p.streamIndex = 0
+	return nil
+}
+
+// readBatch reads the record batch of the index. It returns io.EOF if there
+// are no more batches. The record is valid until the next batch is read.
+func (p *ArrowParser) readBatch(index int) (array.Record, error) {
+	if p.file != nil {
+		if index >= p.file.NumRecords() {
+			return nil, io.EOF
+		}
+		record, err := p.file.Record(index)
+		return record, errors.Trace(err)
+	}
+
+	if index < p.streamIndex {
+		if err := p.openStream(); err != nil {
+			return nil, err
+		}
+	}
+	for ; p.streamIndex <= index; p.streamIndex++ {
+		if !p.stream.Next() {
+			if err := p.stream.Err(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			return nil, io.EOF
+		}
+	}
+	return p.stream.Record(), nil
+}
+
+func (p *ArrowParser) loadBatch(index int) (err error) {
+	defer recoverArrowPanic(&err)
+
+	record, err := p.readBatch(index)
+	if err == io.EOF {
+		return errors.Errorf("arrow record batch %d is missing", index)
+	} else if err != nil {
+		return errors.Annotatef(err, "failed to read record batch %d", index)
+	}
+	p.record = record
+	p.batchIndex = index
+	p.rowInBatch = 0
+	return nil
+}
+
+// Pos returns the number of rows read from the arrow file.
+func (p *ArrowParser) Pos() (pos int64, rowID int64) {
+	return p.pos, p.lastRow.RowID
+}
+
+// SetPos moves to the row, by loading the record batch containing it.
+func (p *ArrowParser) SetPos(pos int64, rowID int64) error {
+	if pos < 0 || pos > p.numRows {
+		return errors.Errorf("cannot seek arrow file to row %d, the file has %d rows", pos, p.numRows)
+	}
+	p.pos = pos
+	p.lastRow.RowID = rowID
+
+	index := sort.Search(len(p.batches), func(i int) bool {
+		return p.batches[i].startRow+p.batches[i].rows > pos
+	})
+	if index >= len(p.batches) {
+		p.batchIndex = len(p.batches)
+		p.record = nil
+		p.rowInBatch = 0
+		return nil
+	}
+	if index != p.batchIndex || p.record == nil {
+		if err := p.loadBatch(index); err != nil {
+			return err
+		}
+	}
+	p.rowInBatch = pos - p.batches[index].startRow
+	return nil
+}
+
+func (p *ArrowParser) Close() error {
+	if p.stream != nil {
+		p.stream.Release()
+	}
+	if p.file != nil {
+		p.file.Close()
+	}
+	return p.reader.Close()
+}
+
+func (p *ArrowParser) ReadRow() error {
+	for p.record == nil || p.rowInBatch >= p.record.NumRows() {
+		if p.batchIndex+1 >= len(p.batches) {
+			return io.EOF
+		}
+		if err := p.loadBatch(p.batchIndex + 1); err != nil {
+			return err
+		}
+	}
+
+	columns := p.record.Columns()
+	p.lastRow.RowID++
+	if cap(p.lastRow.Row) < len(columns) {
+		p.lastRow.Row = make([]types.Datum, len(columns))
+	} else {
+		p.lastRow.Row = p.lastRow.Row[:len(columns)]
+	}
+	for i, column := range columns {
+		setArrowDatum(&p.lastRow.Row[i], column, int(p.rowInBatch))
+	}
+	p.rowInBatch++
+	p.pos++
+	return nil
+}
+
+func (p *ArrowParser) LastRow() Row {
+	return p.lastRow
+}
+
+func (p *ArrowParser) RecycleRow(row Row) {
+}
+
+// Columns returns the _lower-case_ column names corresponding to values in
+// the LastRow.
+func (p *ArrowParser) Columns() []string {
+	return p.columns
+}
+
+// SetColumns set restored column names to parser
+func (p *ArrowParser) SetColumns(cols []string) {
+	// just do nothing
+}
+
+func (p *ArrowParser) SetLogger(l log.Logger) {
+	p.logger = l
+}
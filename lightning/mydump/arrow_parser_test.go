@@ -0,0 +1,227 @@
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/apache/arrow/go/arrow"
+	"github.com/apache/arrow/go/arrow/array"
+	"github.com/apache/arrow/go/arrow/decimal128"
+	"github.com/apache/arrow/go/arrow/ipc"
+	"github.com/apache/arrow/go/arrow/memory"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+)
+
+type testArrowParserSuite struct{}
+
+var _ = Suite(testArrowParserSuite{})
+
+var arrowTestColors = []string{"red", "green"}
+
+var arrowTestSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "ID", Type: arrow.PrimitiveTypes.Int64},
+	{Name: "Name", Type: arrow.BinaryTypes.String, Nullable: true},
+	{Name: "price", Type: &arrow.Decimal128Type{Precision: 10, Scale: 2}},
+	{Name: "day", Type: arrow.PrimitiveTypes.Date32},
+	{Name: "at", Type: arrow.FixedWidthTypes.Timestamp_us},
+	{Name: "ok", Type: arrow.FixedWidthTypes.Boolean},
+	{Name: "color", Type: arrow.BinaryTypes.String},
+}, nil)
+
+// arrowRecordWriter is implemented by both the file and the stream writers.
+type arrowRecordWriter interface {
+	Write(rec array.Record) error
+	Close() error
+}
+
+// writeArrowTestFile writes 3 record batches of 2 rows, with the columns
+// (id int64, name utf8, price decimal(10, 2), day date32, at timestamp[us],
+// ok bool, color utf8).
+func writeArrowTestFile(c *C, path string, fileFormat bool) {
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	var w arrowRecordWriter
+	if fileFormat {
+		w, err = ipc.NewFileWriter(f, ipc.WithSchema(arrowTestSchema))
+		c.Assert(err, IsNil)
+	} else {
+		w = ipc.NewWriter(f, ipc.WithSchema(arrowTestSchema))
+	}
+
+	b := array.NewRecordBuilder(memory.NewGoAllocator(), arrowTestSchema)
+	defer b.Release()
+	for start := 0; start < 6; start += 2 {
+		for i := start; i < start+2; i++ {
+			b.Field(0).(*array.Int64Builder).Append(int64(i + 1))
+			if i == 3 {
+				b.Field(1).(*array.StringBuilder).AppendNull()
+			} else {
+				b.Field(1).(*array.StringBuilder).Append(fmt.Sprintf("name%d", i))
+			}
+			unscaled := int64(i*100 + 5)
+			if i == 4 {
+				unscaled = -unscaled
+			}
+			b.Field(2).(*array.Decimal128Builder).Append(decimal128.FromI64(unscaled))
+			b.Field(3).(*array.Date32Builder).Append(arrow.Date32(18262 + i))
+			b.Field(4).(*array.TimestampBuilder).Append(arrow.Timestamp(1577836800000000 + int64(i)*1500000))
+			b.Field(5).(*array.BooleanBuilder).Append(i%2 == 0)
+			b.Field(6).(*array.StringBuilder).Append(arrowTestColors[i%2])
+		}
+		record := b.NewRecord()
+		c.Assert(w.Write(record), IsNil)
+		record.Release()
+	}
+	c.Assert(w.Close(), IsNil)
+}
+
+func (s testArrowParserSuite) verifyRow(c *C, parser *ArrowParser, i int, comment CommentInterface) {
+	pos, rowID := parser.Pos()
+	c.Assert(pos, Equals, int64(i+1), comment)
+	c.Assert(rowID, Equals, int64(i+1), comment)
+	row := parser.LastRow().Row
+	c.Assert(row, HasLen, 7, comment)
+	c.Assert(row[0], DeepEquals, types.NewIntDatum(int64(i+1)), comment)
+	if i == 3 {
+		c.Assert(row[1].IsNull(), IsTrue, comment)
+	} else {
+		c.Assert(row[1], DeepEquals, types.NewCollationStringDatum(fmt.Sprintf("name%d", i), "", 0), comment)
+	}
+	price := fmt.Sprintf("%d.05", i)
+	if i == 4 {
+		price = "-4.05"
+	}
+	c.Assert(row[2], DeepEquals, types.NewCollationStringDatum(price, "", 0), comment)
+	c.Assert(row[3], DeepEquals, types.NewCollationStringDatum(fmt.Sprintf("2020-01-%02d", i+1), "", 0), comment)
+	at := []string{"00:00:00", "00:00:01.5", "00:00:03", "00:00:04.5", "00:00:06", "00:00:07.5"}[i]
+	c.Assert(row[4], DeepEquals, types.NewCollationStringDatum("2020-01-01 "+at, "", 0), comment)
+	c.Assert(row[5], DeepEquals, types.NewIntDatum(int64(1-i%2)), comment)
+	c.Assert(row[6], DeepEquals, types.NewCollationStringDatum(arrowTestColors[i%2], "", 0), comment)
+}
+
+func (s testArrowParserSuite) TestArrowParser(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	for _, fileFormat := range []bool{true, false} {
+		comment := Commentf("file format: %v", fileFormat)
+		name := "db.tbl.arrows"
+		if fileFormat {
+			name = "db.tbl.arrow"
+		}
+		writeArrowTestFile(c, filepath.Join(dir, name), fileFormat)
+
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		parser, err := NewArrowParser(r)
+		c.Assert(err, IsNil, comment)
+		c.Assert(parser.Columns(), DeepEquals, []string{"id", "name", "price", "day", "at", "ok", "color"}, comment)
+		c.Assert(parser.numRows, Equals, int64(6), comment)
+
+		for i := 0; i < 6; i++ {
+			c.Assert(parser.ReadRow(), IsNil, comment)
+			s.verifyRow(c, parser, i, comment)
+		}
+		c.Assert(parser.ReadRow(), Equals, io.EOF, comment)
+
+		// seek into the middle of a record batch, and back.
+		c.Assert(parser.SetPos(3, 3), IsNil)
+		c.Assert(parser.ReadRow(), IsNil)
+		s.verifyRow(c, parser, 3, comment)
+		c.Assert(parser.SetPos(0, 0), IsNil)
+		c.Assert(parser.ReadRow(), IsNil)
+		s.verifyRow(c, parser, 0, comment)
+		c.Assert(parser.SetPos(6, 6), IsNil)
+		c.Assert(parser.ReadRow(), Equals, io.EOF, comment)
+		c.Assert(parser.SetPos(7, 7), ErrorMatches, "cannot seek arrow file to row 7, the file has 6 rows")
+		c.Assert(parser.Close(), IsNil)
+	}
+}
+
+func (s testArrowParserSuite) TestMakeArrowFileRegions(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	writeArrowTestFile(c, filepath.Join(dir, "db.tbl.arrow"), true)
+
+	meta := &MDTableMeta{DB: "db", Name: "tbl"}
+	dataFile := FileInfo{FileMeta: SourceFileMeta{Path: "db.tbl.arrow", Type: SourceTypeArrow}}
+	rowIDMax, regions, sizes, err := makeArrowFileRegions(context.TODO(), store, meta, dataFile, 256<<20, 5)
+	c.Assert(err, IsNil)
+	c.Assert(rowIDMax, Equals, int64(11))
+	c.Assert(regions, HasLen, 1)
+	c.Assert(sizes, HasLen, 1)
+	c.Assert(regions[0].Chunk, DeepEquals, Chunk{Offset: 0, EndOffset: 6, PrevRowIDMax: 5, RowIDMax: 11})
+
+	// every record batch makes a region.
+	rowIDMax, regions, sizes, err = makeArrowFileRegions(context.TODO(), store, meta, dataFile, 1, 5)
+	c.Assert(err, IsNil)
+	c.Assert(rowIDMax, Equals, int64(11))
+	c.Assert(regions, HasLen, 3)
+	c.Assert(sizes, HasLen, 3)
+	for i, region := range regions {
+		c.Assert(region.Chunk, DeepEquals, Chunk{
+			Offset:       int64(i * 2),
+			EndOffset:    int64(i*2 + 2),
+			PrevRowIDMax: int64(5 + i*2),
+			RowIDMax:     int64(7 + i*2),
+		})
+	}
+
+	// the chunk of a region starts from the row of the region.
+	r, err := store.Open(context.TODO(), "db.tbl.arrow")
+	c.Assert(err, IsNil)
+	parser, err := NewArrowParser(r)
+	c.Assert(err, IsNil)
+	defer parser.Close()
+	c.Assert(parser.SetPos(regions[2].Chunk.Offset, 0), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row[0], DeepEquals, types.NewIntDatum(5))
+}
+
+func (s testArrowParserSuite) TestInvalidArrowFile(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	writeArrowTestFile(c, filepath.Join(dir, "valid"), true)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "valid"))
+	c.Assert(err, IsNil)
+
+	var nested bytes.Buffer
+	w := ipc.NewWriter(&nested, ipc.WithSchema(arrow.NewSchema([]arrow.Field{
+		{Name: "a", Type: arrow.ListOf(arrow.PrimitiveTypes.Int64)},
+	}, nil)))
+	c.Assert(w.Close(), IsNil)
+
+	files := map[string][]byte{
+		"not-arrow":  []byte("INSERT INTO t VALUES (1);"),
+		"feather-v1": []byte("FEA1\x00\x00\x00\x00FEA1"),
+		"truncated":  bytes.TrimSuffix(content, []byte(arrowMagic)),
+		"nested":     nested.Bytes(),
+	}
+	expected := map[string]string{
+		"not-arrow":  "not an arrow file",
+		"feather-v1": "feather v1 files are not supported, please convert them to feather v2",
+		"truncated":  "not an arrow file.*",
+		"nested":     "column 'a': unsupported arrow type list",
+	}
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), content, 0644), IsNil)
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		_, err = NewArrowParser(r)
+		c.Assert(err, ErrorMatches, expected[name], Commentf("file: %s", name))
+		c.Assert(r.Close(), IsNil)
+	}
+}
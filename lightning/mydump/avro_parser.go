@@ -0,0 +1,267 @@
+package mydump
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// avroColumn describes a field of the top level record in the Avro schema.
+type avroColumn struct {
+	name        string
+	logicalType string
+	scale       int
+	// union is true if the field is a union, whose non-null values are
+	// wrapped by goavro as `{"type": value}`.
+	union bool
+}
+
+// parseAvroColumns extracts the fields of the top level record schema. The
+// schema itself is already validated by goavro.
+func parseAvroColumns(schema string) ([]avroColumn, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return nil, errors.Annotate(err, "invalid avro schema")
+	}
+	record, _ := v.(map[string]interface{})
+	if typ := record["type"]; typ != "record" {
+		if record != nil {
+			v = typ
+		}
+		return nil, errors.Errorf("the avro schema must be a record, but got %v", v)
+	}
+	fields, _ := record["fields"].([]interface{})
+
+	columns := make([]avroColumn, 0, len(fields))
+	for _, f := range fields {
+		field, _ := f.(map[string]interface{})
+		name, _ := field["name"].(string)
+		column := avroColumn{name: name}
+		typ := field["type"]
+		if branches, ok := typ.([]interface{}); ok {
+			column.union = true
+			// the logical type of a nullable column is taken from the first
+			// non-null branch.
+			for _, branch := range branches {
+				if branch != "null" {
+					typ = branch
+					break
+				}
+			}
+		}
+		if m, ok := typ.(map[string]interface{}); ok {
+			column.logicalType, _ = m["logicalType"].(string)
+			scale, _ := m["scale"].(float64)
+			column.scale = int(scale)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// setAvroDatum converts a native value decoded by goavro into the datum. The
+// complex values are converted to JSON.
+func setAvroDatum(datum *types.Datum, v interface{}, column *avroColumn) error {
+	if m, ok := v.(map[string]interface{}); ok && column.union && len(m) == 1 {
+		for _, value := range m {
+			v = value
+		}
+	}
+
+	switch v := v.(type) {
+	case nil:
+		datum.SetNull()
+	case bool:
+		if v {
+			datum.SetInt64(1)
+		} else {
+			datum.SetInt64(0)
+		}
+	case int32:
+		datum.SetInt64(int64(v))
+	case int64:
+		datum.SetInt64(v)
+	case float32:
+		datum.SetFloat32(v)
+	case float64:
+		datum.SetFloat64(v)
+	case string:
+		datum.SetString(v, "")
+	case []byte:
+		datum.SetBytes(v)
+	case time.Time:
+		datum.SetString(formatAvroTime(v, column.logicalType), "")
+	case time.Duration:
+		datum.SetString(time.Unix(0, 0).UTC().Add(v).Format("15:04:05.999999"), "")
+	case *big.Rat:
+		datum.SetString(v.FloatString(column.scale), "")
+	default:
+		js, err := json.Marshal(v)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		datum.SetString(string(js), "")
+	}
+	return nil
+}
+
+// formatAvroTime formats the value of the date and the timestamp logical types.
+func formatAvroTime(t time.Time, logicalType string) string {
+	if logicalType == "date" {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04:05.999999")
+}
+
+// formatScaledDecimal formats the decimal of the unscaled value and the scale.
+func formatScaledDecimal(unscaled *big.Int, scale int) string {
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)
+	return new(big.Rat).SetFrac(unscaled, denom).FloatString(scale)
+}
+
+// AvroParser reads the records from an Avro object container file with goavro.
+// The top level schema must be a record, whose fields are the columns. Like
+// the parquet files, the position is the number of rows read.
+type AvroParser struct {
+	reader  ReadSeekCloser
+	ocf     *goavro.OCFReader
+	columns []avroColumn
+	names   []string
+
+	pos     int64
+	lastRow Row
+	logger  log.Logger
+}
+
+func NewAvroParser(reader ReadSeekCloser) (*AvroParser, error) {
+	ocf, err := goavro.NewOCFReader(bufio.NewReader(reader))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	columns, err := parseAvroColumns(ocf.Codec().Schema())
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	names := make([]string, 0, len(columns))
+	for _, column := range columns {
+		names = append(names, strings.ToLower(column.name))
+	}
+	return &AvroParser{
+		reader:  reader,
+		ocf:     ocf,
+		columns: columns,
+		names:   names,
+		logger:  log.L(),
+	}, nil
+}
+
+// countRows returns the number of the remaining rows in the file. The blocks
+// are skipped without decoding the records in them.
+func (p *AvroParser) countRows() (int64, error) {
+	var rows int64
+	for p.ocf.Scan() {
+		rows += p.ocf.RemainingBlockItems()
+		p.ocf.SkipThisBlockAndReset()
+	}
+	return rows, errors.Trace(p.ocf.Err())
+}
+
+// Pos returns the number of rows read from the avro file.
+func (p *AvroParser) Pos() (pos int64, rowID int64) {
+	return p.pos, p.lastRow.RowID
+}
+
+// SetPos skips the rows before pos. The whole blocks before pos are skipped
+// without decoding the records in them. Seeking back is not supported.
+func (p *AvroParser) SetPos(pos int64, rowID int64) error {
+	if pos < p.pos {
+		return errors.Errorf("cannot seek back avro file from %d to %d", p.pos, pos)
+	}
+	p.lastRow.RowID = rowID
+
+	for p.pos < pos {
+		if !p.ocf.Scan() {
+			if err := p.ocf.Err(); err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Errorf("cannot seek avro file to %d, which only has %d rows", pos, p.pos)
+		}
+		if remaining := p.ocf.RemainingBlockItems(); p.pos+remaining <= pos {
+			p.ocf.SkipThisBlockAndReset()
+			p.pos += remaining
+			continue
+		}
+		if _, err := p.ocf.Read(); err != nil {
+			return errors.Trace(err)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *AvroParser) Close() error {
+	return p.reader.Close()
+}
+
+func (p *AvroParser) ReadRow() error {
+	if !p.ocf.Scan() {
+		if err := p.ocf.Err(); err != nil {
+			return errors.Trace(err)
+		}
+		return io.EOF
+	}
+	v, err := p.ocf.Read()
+	if err != nil {
+		return errors.Annotatef(err, "failed to decode row %d", p.pos)
+	}
+	record, ok := v.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("unexpected avro record %T at row %d", v, p.pos)
+	}
+
+	p.lastRow.RowID++
+	if cap(p.lastRow.Row) < len(p.columns) {
+		p.lastRow.Row = make([]types.Datum, len(p.columns))
+	} else {
+		p.lastRow.Row = p.lastRow.Row[:len(p.columns)]
+	}
+	for i := range p.columns {
+		column := &p.columns[i]
+		if err := setAvroDatum(&p.lastRow.Row[i], record[column.name], column); err != nil {
+			return errors.Annotatef(err, "failed to decode column '%s' at row %d", column.name, p.pos)
+		}
+	}
+	p.pos++
+	return nil
+}
+
+func (p *AvroParser) LastRow() Row {
+	return p.lastRow
+}
+
+func (p *AvroParser) RecycleRow(row Row) {
+}
+
+// Columns returns the _lower-case_ column names corresponding to values in
+// the LastRow.
+func (p *AvroParser) Columns() []string {
+	return p.names
+}
+
+// SetColumns set restored column names to parser
+func (p *AvroParser) SetColumns(cols []string) {
+	// just do nothing
+}
+
+func (p *AvroParser) SetLogger(l log.Logger) {
+	p.logger = l
+}
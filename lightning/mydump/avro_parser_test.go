@@ -0,0 +1,242 @@
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+)
+
+type testAvroParserSuite struct{}
+
+var _ = Suite(testAvroParserSuite{})
+
+const testAvroSchema = `{
+	"type": "record",
+	"name": "Row",
+	"namespace": "test",
+	"fields": [
+		{"name": "ID", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "score", "type": ["null", "double"]},
+		{"name": "color", "type": {"type": "enum", "name": "Color", "symbols": ["RED", "GREEN"]}},
+		{"name": "born", "type": {"type": "int", "logicalType": "date"}},
+		{"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 10, "scale": 2}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "hue", "type": "Color"}
+	]
+}`
+
+func makeAvroTestRow(i int) map[string]interface{} {
+	var score interface{}
+	if i%2 != 0 {
+		score = goavro.Union("double", float64(i)*1.5)
+	}
+	colors := []string{"RED", "GREEN"}
+	return map[string]interface{}{
+		"ID":     int64(i),
+		"name":   "n" + strconv.Itoa(i),
+		"score":  score,
+		"color":  colors[i%2],
+		"born":   time.Unix(int64(i)*24*60*60, 0).UTC(),
+		"amount": big.NewRat(1234, 100),
+		"tags":   []interface{}{"a", "b"},
+		"hue":    colors[(i+1)%2],
+	}
+}
+
+func writeAvroTestFile(c *C, dir string, name string, codec string) {
+	var buf bytes.Buffer
+	w, err := goavro.NewOCFWriter(goavro.OCFConfig{W: &buf, Schema: testAvroSchema, CompressionName: codec})
+	c.Assert(err, IsNil)
+	// the rows are split into blocks of 4, 4 and 2 rows.
+	for i := 0; i < 10; i += 4 {
+		var rows []interface{}
+		for j := i; j < i+4 && j < 10; j++ {
+			rows = append(rows, makeAvroTestRow(j))
+		}
+		c.Assert(w.Append(rows), IsNil)
+	}
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644), IsNil)
+}
+
+// makeAvroHeader encodes the header of an object container file, which may
+// be rejected by goavro.
+func makeAvroHeader(schema string, codec string) []byte {
+	str := func(s string) []byte {
+		b := make([]byte, binary.MaxVarintLen64)
+		return append(b[:binary.PutVarint(b, int64(len(s)))], s...)
+	}
+	var buf bytes.Buffer
+	buf.WriteString("Obj\x01")
+	buf.Write([]byte{4})
+	buf.Write(str("avro.schema"))
+	buf.Write(str(schema))
+	buf.Write(str("avro.codec"))
+	buf.Write(str(codec))
+	buf.Write([]byte{0})
+	buf.WriteString("0123456789abcdef")
+	return buf.Bytes()
+}
+
+func (s testAvroParserSuite) TestAvroParser(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	colors := []string{"RED", "GREEN"}
+	for _, codec := range []string{goavro.CompressionNullLabel, goavro.CompressionDeflateLabel, goavro.CompressionSnappyLabel} {
+		name := "db.tbl." + codec + ".avro"
+		writeAvroTestFile(c, dir, name, codec)
+
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		reader, err := NewAvroParser(r)
+		c.Assert(err, IsNil)
+
+		c.Assert(reader.Columns(), DeepEquals, []string{"id", "name", "score", "color", "born", "amount", "tags", "hue"})
+
+		verifyRow := func(i int) {
+			comment := Commentf("codec: %s, row: %d", codec, i)
+			pos, rowID := reader.Pos()
+			c.Assert(pos, Equals, int64(i+1), comment)
+			c.Assert(rowID, Equals, int64(i+1), comment)
+			row := reader.LastRow().Row
+			c.Assert(row, HasLen, 8, comment)
+			c.Assert(row[0], DeepEquals, types.NewIntDatum(int64(i)), comment)
+			c.Assert(row[1], DeepEquals, types.NewCollationStringDatum("n"+strconv.Itoa(i), "", 0), comment)
+			if i%2 == 0 {
+				c.Assert(row[2].IsNull(), IsTrue, comment)
+			} else {
+				c.Assert(row[2], DeepEquals, types.NewFloat64Datum(float64(i)*1.5), comment)
+			}
+			c.Assert(row[3], DeepEquals, types.NewCollationStringDatum(colors[i%2], "", 0), comment)
+			c.Assert(row[4], DeepEquals, types.NewCollationStringDatum(fmt.Sprintf("1970-01-%02d", i+1), "", 0), comment)
+			c.Assert(row[5], DeepEquals, types.NewCollationStringDatum("12.34", "", 0), comment)
+			c.Assert(row[6], DeepEquals, types.NewCollationStringDatum(`["a","b"]`, "", 0), comment)
+			c.Assert(row[7], DeepEquals, types.NewCollationStringDatum(colors[(i+1)%2], "", 0), comment)
+		}
+
+		for i := 0; i < 3; i++ {
+			c.Assert(reader.ReadRow(), IsNil)
+			verifyRow(i)
+		}
+
+		// skip the rest of the first block, and into the second block.
+		c.Assert(reader.SetPos(6, 6), IsNil)
+		for i := 6; i < 10; i++ {
+			c.Assert(reader.ReadRow(), IsNil)
+			verifyRow(i)
+		}
+		c.Assert(reader.ReadRow(), Equals, io.EOF)
+		c.Assert(reader.SetPos(2, 2), ErrorMatches, "cannot seek back avro file from 10 to 2")
+		c.Assert(reader.Close(), IsNil)
+
+		// skip the whole blocks.
+		r, err = store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		reader, err = NewAvroParser(r)
+		c.Assert(err, IsNil)
+		c.Assert(reader.SetPos(8, 8), IsNil)
+		c.Assert(reader.ReadRow(), IsNil)
+		verifyRow(8)
+		c.Assert(reader.Close(), IsNil)
+	}
+}
+
+func (s testAvroParserSuite) TestMakeAvroFileRegion(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	writeAvroTestFile(c, dir, "db.tbl.avro", goavro.CompressionDeflateLabel)
+
+	meta := &MDTableMeta{DB: "db", Name: "tbl"}
+	dataFile := FileInfo{FileMeta: SourceFileMeta{Path: "db.tbl.avro", Type: SourceTypeAvro}}
+	rowIDMax, region, err := makeAvroFileRegion(context.TODO(), store, meta, dataFile, 5)
+	c.Assert(err, IsNil)
+	c.Assert(rowIDMax, Equals, int64(15))
+	c.Assert(region.Chunk, DeepEquals, Chunk{
+		Offset:       0,
+		EndOffset:    10,
+		PrevRowIDMax: 5,
+		RowIDMax:     15,
+	})
+}
+
+func (s testAvroParserSuite) TestInvalidAvroFile(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	files := map[string][]byte{
+		"not-avro":    []byte("INSERT INTO t VALUES (1);"),
+		"zstandard":   makeAvroHeader(testAvroSchema, "zstandard"),
+		"not-record":  makeAvroHeader(`"long"`, goavro.CompressionNullLabel),
+		"unknown-ref": makeAvroHeader(`{"type": "record", "name": "r", "fields": [{"name": "a", "type": "Unknown"}]}`, goavro.CompressionNullLabel),
+	}
+	expected := map[string]string{
+		"not-avro":    ".*invalid magic bytes.*",
+		"zstandard":   `.*unrecognized compression algorithm from avro.codec: "zstandard"`,
+		"not-record":  "the avro schema must be a record, but got long",
+		"unknown-ref": ".*invalid avro.schema.*Unknown.*",
+	}
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), content, 0644), IsNil)
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		_, err = NewAvroParser(r)
+		c.Assert(err, ErrorMatches, expected[name])
+		c.Assert(r.Close(), IsNil)
+	}
+
+	// the sync marker after the block is corrupted.
+	writeAvroTestFile(c, dir, "corrupted", goavro.CompressionNullLabel)
+	content, err := ioutil.ReadFile(filepath.Join(dir, "corrupted"))
+	c.Assert(err, IsNil)
+	content[len(content)-1] ^= 0xff
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "corrupted"), content, 0644), IsNil)
+	r, err := store.Open(context.TODO(), "corrupted")
+	c.Assert(err, IsNil)
+	reader, err := NewAvroParser(r)
+	c.Assert(err, IsNil)
+	c.Assert(reader.SetPos(8, 8), IsNil)
+	c.Assert(reader.ReadRow(), ErrorMatches, ".*sync marker mismatch.*")
+	c.Assert(reader.Close(), IsNil)
+}
+
+func (s testAvroParserSuite) TestAvroNativeValues(c *C) {
+	testCases := []struct {
+		value    interface{}
+		column   avroColumn
+		expected types.Datum
+	}{
+		{time.Unix(18000*24*60*60, 0).UTC(), avroColumn{logicalType: "date"}, types.NewCollationStringDatum("2019-04-14", "", 0)},
+		{time.Unix(1500000000, 123000000).UTC(), avroColumn{logicalType: "timestamp-millis"}, types.NewCollationStringDatum("2017-07-14 02:40:00.123", "", 0)},
+		{time.Unix(1500000000, 1000).UTC(), avroColumn{logicalType: "timestamp-micros"}, types.NewCollationStringDatum("2017-07-14 02:40:00.000001", "", 0)},
+		{3723004 * time.Millisecond, avroColumn{logicalType: "time-millis"}, types.NewCollationStringDatum("01:02:03.004", "", 0)},
+		{big.NewRat(-1234, 100), avroColumn{logicalType: "decimal", scale: 2}, types.NewCollationStringDatum("-12.34", "", 0)},
+		{big.NewRat(5, 1000), avroColumn{logicalType: "decimal", scale: 3}, types.NewCollationStringDatum("0.005", "", 0)},
+		{true, avroColumn{}, types.NewIntDatum(1)},
+		{int32(-7), avroColumn{}, types.NewIntDatum(-7)},
+		{map[string]interface{}{"long": int64(5)}, avroColumn{union: true}, types.NewIntDatum(5)},
+		{nil, avroColumn{union: true}, types.Datum{}},
+		// the unions nested in the complex values are kept in the JSON encoding of Avro.
+		{map[string]interface{}{"a": map[string]interface{}{"long": int64(5)}, "b": nil}, avroColumn{}, types.NewCollationStringDatum(`{"a":{"long":5},"b":null}`, "", 0)},
+	}
+	for _, tc := range testCases {
+		var datum types.Datum
+		c.Assert(setAvroDatum(&datum, tc.value, &tc.column), IsNil)
+		c.Assert(datum, DeepEquals, tc.expected, Commentf("value: %v", tc.value))
+	}
+}
@@ -0,0 +1,311 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	azblobAccountName = "account-name"
+	azblobAccountKey  = "account-key"
+	azblobSASToken    = "sas-token"
+	azblobEndpoint    = "endpoint"
+	azblobClientID    = "client-id"
+
+	// azblobResource is the resource of the OAuth tokens of the managed
+	// identity.
+	azblobResource = "https://storage.azure.com/"
+	// azblobRetryReads is the number of the retried requests when the
+	// download of a blob is interrupted.
+	azblobRetryReads = 3
+)
+
+// isAzureBlobURL returns whether the data source is in Azure Blob Storage,
+// i.e. `azure://container/path` or `azblob://container/path`.
+func isAzureBlobURL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "azure://") || strings.HasPrefix(sourceDir, "azblob://")
+}
+
+// AzureBlobOptions locates the container and the credentials of the data
+// source in Azure Blob Storage.
+type AzureBlobOptions struct {
+	Container string
+	Prefix    string
+	// Endpoint is the URL of the blob service, which defaults to
+	// `https://<account-name>.blob.core.windows.net`.
+	Endpoint    string
+	AccountName string
+	// AccountKey signs the requests with the shared key.
+	AccountKey string
+	// SASToken is appended to the URLs of the requests.
+	SASToken string
+	// ClientID selects the user-assigned managed identity, which is used
+	// without the account key and the SAS token.
+	ClientID string
+}
+
+// ParseAzureBlobURL parses the options from the data source URL, e.g.
+//
+//	azure://container/path?account-name=NAME&sas-token=TOKEN
+//
+// The account name, the account key and the SAS token default to the
+// environment variables AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY and
+// AZURE_STORAGE_SAS_TOKEN respectively. The managed identity of the host is
+// used if neither the key nor the SAS token is given.
+func ParseAzureBlobURL(sourceDir string) (*AzureBlobOptions, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("missing the container of the azure blob url")
+	}
+	query := u.Query()
+	param := func(name string, env string) string {
+		if value := query.Get(name); len(value) > 0 {
+			return value
+		}
+		return os.Getenv(env)
+	}
+
+	opts := &AzureBlobOptions{
+		Container:   u.Host,
+		Prefix:      strings.Trim(u.Path, "/"),
+		Endpoint:    query.Get(azblobEndpoint),
+		AccountName: param(azblobAccountName, "AZURE_STORAGE_ACCOUNT"),
+		AccountKey:  param(azblobAccountKey, "AZURE_STORAGE_KEY"),
+		SASToken:    strings.TrimPrefix(param(azblobSASToken, "AZURE_STORAGE_SAS_TOKEN"), "?"),
+		ClientID:    query.Get(azblobClientID),
+	}
+	if len(opts.Endpoint) == 0 {
+		if len(opts.AccountName) == 0 {
+			return nil, errors.New("missing the account name of the azure blob storage, please set `account-name` or AZURE_STORAGE_ACCOUNT")
+		}
+		opts.Endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", opts.AccountName)
+	}
+	if len(opts.AccountKey) > 0 && len(opts.AccountName) == 0 {
+		return nil, errors.New("the account key of the azure blob storage requires the account name")
+	}
+	return opts, nil
+}
+
+// azblobStorage reads the data source from a container of Azure Blob Storage.
+// The methods of storage.ExternalStorage not overridden, which are not used on
+// the data source, are not supported.
+type azblobStorage struct {
+	storage.ExternalStorage
+	container azblob.ContainerURL
+	prefix    string
+}
+
+// NewAzureBlobStorage opens the container of the options.
+func NewAzureBlobStorage(ctx context.Context, opts *AzureBlobOptions) (storage.ExternalStorage, error) {
+	credential, err := newAzureBlobCredential(ctx, opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	endpoint, err := url.Parse(strings.TrimSuffix(opts.Endpoint, "/") + "/" + opts.Container)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid azure blob endpoint")
+	}
+	if len(opts.SASToken) > 0 {
+		endpoint.RawQuery = opts.SASToken
+	}
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{
+		Retry: azblob.RetryOptions{MaxTries: 5},
+	})
+
+	prefix := opts.Prefix
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	return &azblobStorage{
+		container: azblob.NewContainerURL(*endpoint, pipeline),
+		prefix:    prefix,
+	}, nil
+}
+
+func newAzureBlobCredential(ctx context.Context, opts *AzureBlobOptions) (azblob.Credential, error) {
+	switch {
+	case len(opts.AccountKey) > 0:
+		return azblob.NewSharedKeyCredential(opts.AccountName, opts.AccountKey)
+	case len(opts.SASToken) > 0:
+		return azblob.NewAnonymousCredential(), nil
+	}
+
+	msiEndpoint, err := adal.GetMSIVMEndpoint()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	var token *adal.ServicePrincipalToken
+	if len(opts.ClientID) > 0 {
+		token, err = adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(msiEndpoint, azblobResource, opts.ClientID)
+	} else {
+		token, err = adal.NewServicePrincipalTokenFromMSI(msiEndpoint, azblobResource)
+	}
+	if err != nil {
+		return nil, errors.Annotate(err, "azure managed identity")
+	}
+	if err := token.RefreshWithContext(ctx); err != nil {
+		return nil, errors.Annotate(err, "azure managed identity")
+	}
+	log.L().Info("access azure blob storage with the managed identity", zap.String("clientID", opts.ClientID))
+
+	// the token is refreshed 2 minutes before it expires.
+	return azblob.NewTokenCredential(token.Token().AccessToken, func(credential azblob.TokenCredential) time.Duration {
+		if err := token.Refresh(); err != nil {
+			log.L().Warn("failed to refresh the azure managed identity token", log.ShortError(err))
+			return time.Minute
+		}
+		credential.SetToken(token.Token().AccessToken)
+		if d := time.Until(token.Token().Expires()) - 2*time.Minute; d > time.Minute {
+			return d
+		}
+		return time.Minute
+	}), nil
+}
+
+func (s *azblobStorage) blob(name string) azblob.BlockBlobURL {
+	return s.container.NewBlockBlobURL(s.prefix + name)
+}
+
+func (s *azblobStorage) Write(ctx context.Context, name string, data []byte) error {
+	_, err := azblob.UploadBufferToBlockBlob(ctx, data, s.blob(name), azblob.UploadToBlockBlobOptions{})
+	return errors.Annotatef(err, "write %s", name)
+}
+
+func (s *azblobStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *azblobStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.blob(name).GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if isAzureBlobNotFound(err) {
+		return false, nil
+	}
+	return false, errors.Annotatef(err, "stat %s", name)
+}
+
+func (s *azblobStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	props, err := s.blob(name).GetProperties(ctx, azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	return &azblobReader{ctx: ctx, blob: s.blob(name), name: name, size: props.ContentLength()}, nil
+}
+
+// WalkDir lists all blobs under the prefix. The blobs are listed in the
+// lexicographical order of their names.
+func (s *azblobStorage) WalkDir(ctx context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := s.container.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: s.prefix})
+		if err != nil {
+			return errors.Annotate(err, "list azure blobs")
+		}
+		for _, item := range resp.Segment.BlobItems {
+			var size int64
+			if item.Properties.ContentLength != nil {
+				size = *item.Properties.ContentLength
+			}
+			if err := fn(strings.TrimPrefix(item.Name, s.prefix), size); err != nil {
+				return err
+			}
+		}
+		marker = resp.NextMarker
+	}
+	return nil
+}
+
+func isAzureBlobNotFound(err error) bool {
+	storageErr, ok := errors.Cause(err).(azblob.StorageError)
+	return ok && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound
+}
+
+// azblobReader reads a blob from the offset with a single ranged download,
+// which restarts after seeking.
+type azblobReader struct {
+	ctx    context.Context
+	blob   azblob.BlockBlobURL
+	name   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *azblobReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		resp, err := r.blob.Download(r.ctx, r.offset, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+		if err != nil {
+			return 0, errors.Annotatef(err, "read %s", r.name)
+		}
+		r.body = resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: azblobRetryReads})
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *azblobReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.Errorf("invalid seek offset %d of %s", offset, r.name)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *azblobReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"os"
+
+	. "github.com/pingcap/check"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testAzureBlobSuite{})
+
+type testAzureBlobSuite struct{}
+
+func (s *testAzureBlobSuite) TestParseURL(c *C) {
+	opts, err := ParseAzureBlobURL("azure://dumps/2020/db/?account-name=acct&sas-token=%3Fsv%3D2019-12-12%26sig%3Dxyz")
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &AzureBlobOptions{
+		Container:   "dumps",
+		Prefix:      "2020/db",
+		Endpoint:    "https://acct.blob.core.windows.net",
+		AccountName: "acct",
+		SASToken:    "sv=2019-12-12&sig=xyz",
+	})
+
+	opts, err = ParseAzureBlobURL("azblob://dumps?endpoint=http://127.0.0.1:10000/devstoreaccount1&account-name=devstoreaccount1&account-key=a2V5")
+	c.Assert(err, IsNil)
+	c.Assert(opts.Container, Equals, "dumps")
+	c.Assert(opts.Prefix, Equals, "")
+	c.Assert(opts.Endpoint, Equals, "http://127.0.0.1:10000/devstoreaccount1")
+	c.Assert(opts.AccountKey, Equals, "a2V5")
+
+	// the managed identity is used without the key and the SAS token.
+	opts, err = ParseAzureBlobURL("azure://dumps/db?account-name=acct&client-id=1234")
+	c.Assert(err, IsNil)
+	c.Assert(opts.AccountKey, Equals, "")
+	c.Assert(opts.SASToken, Equals, "")
+	c.Assert(opts.ClientID, Equals, "1234")
+}
+
+func (s *testAzureBlobSuite) TestParseURLFromEnv(c *C) {
+	os.Setenv("AZURE_STORAGE_ACCOUNT", "envacct")
+	os.Setenv("AZURE_STORAGE_KEY", "ZW52a2V5")
+	defer os.Unsetenv("AZURE_STORAGE_ACCOUNT")
+	defer os.Unsetenv("AZURE_STORAGE_KEY")
+
+	opts, err := ParseAzureBlobURL("azure://dumps/db")
+	c.Assert(err, IsNil)
+	c.Assert(opts.AccountName, Equals, "envacct")
+	c.Assert(opts.AccountKey, Equals, "ZW52a2V5")
+	c.Assert(opts.Endpoint, Equals, "https://envacct.blob.core.windows.net")
+
+	// the URL takes precedence over the environment.
+	opts, err = ParseAzureBlobURL("azure://dumps/db?account-name=acct")
+	c.Assert(err, IsNil)
+	c.Assert(opts.AccountName, Equals, "acct")
+}
+
+func (s *testAzureBlobSuite) TestParseInvalidURL(c *C) {
+	_, err := ParseAzureBlobURL("azure:///db?account-name=acct")
+	c.Assert(err, ErrorMatches, "missing the container of the azure blob url")
+	_, err = ParseAzureBlobURL("azure://dumps/db")
+	c.Assert(err, ErrorMatches, "missing the account name of the azure blob storage.*")
+	_, err = ParseAzureBlobURL("azure://dumps/db?endpoint=http://127.0.0.1:10000&account-key=a2V5")
+	c.Assert(err, ErrorMatches, "the account key of the azure blob storage requires the account name")
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// extJSONMaxDepth limits the nesting of the documents and the arrays.
+const extJSONMaxDepth = 100
+
+var errExtJSONNotObject = errors.NewNoStackError("each line must be a JSON object")
+
+// decodeExtendedJSON decodes a line written by mongoexport, i.e. a document
+// in the MongoDB extended JSON, either canonical or relaxed. The wrappers like
+// {"$oid": "..."} and {"$date": "..."} are converted to the values decoded
+// from the BSON files by gopkg.in/mgo.v2/bson, whose own extended JSON
+// support only covers the legacy mongoexport format.
+func decodeExtendedJSON(line []byte) (bson.D, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	value, err := readExtJSONValue(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	doc, ok := value.(bson.D)
+	if !ok {
+		return nil, errExtJSONNotObject
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected content after the JSON object")
+	}
+	return doc, nil
+}
+
+func readExtJSONValue(dec *json.Decoder, depth int) (interface{}, error) {
+	if depth >= extJSONMaxDepth {
+		return nil, errors.New("JSON value nested too deeply")
+	}
+	token, err := dec.Token()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			var values []interface{}
+			for dec.More() {
+				value, err := readExtJSONValue(dec, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			if _, err := dec.Token(); err != nil {
+				return nil, errors.Trace(err)
+			}
+			return values, nil
+		}
+
+		doc := bson.D{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			value, err := readExtJSONValue(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{Name: key.(string), Value: value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return convertExtJSON(doc)
+	case json.Number:
+		if i, err := token.Int64(); err == nil {
+			return i, nil
+		}
+		if strings.ContainsAny(string(token), ".eE") {
+			return token.Float64()
+		}
+		// keep the integers out of the range of int64 exact if possible.
+		if d, err := bson.ParseDecimal128(string(token)); err == nil {
+			return d, nil
+		}
+		return token.Float64()
+	default:
+		// string, bool or nil.
+		return token, nil
+	}
+}
+
+// convertExtJSON converts the wrapper object to the value it represents, or
+// returns the object itself if it is not a wrapper.
+func convertExtJSON(doc bson.D) (interface{}, error) {
+	if len(doc) == 0 || !strings.HasPrefix(doc[0].Name, "$") {
+		return doc, nil
+	}
+	key, value := doc[0].Name, doc[0].Value
+	invalid := errors.Errorf("invalid extended JSON value of '%s'", key)
+
+	if len(doc) == 2 {
+		switch {
+		case key == "$binary" && doc[1].Name == "$type":
+			// the legacy binary.
+			data, ok := value.(string)
+			if !ok {
+				return nil, invalid
+			}
+			b, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return nil, invalid
+			}
+			return b, nil
+		case key == "$regex" && doc[1].Name == "$options":
+			pattern, ok1 := value.(string)
+			options, ok2 := doc[1].Value.(string)
+			if !ok1 || !ok2 {
+				return nil, invalid
+			}
+			return bson.RegEx{Pattern: pattern, Options: options}, nil
+		}
+	}
+	if len(doc) != 1 {
+		return doc, nil
+	}
+
+	switch key {
+	case "$oid":
+		s, _ := value.(string)
+		if !bson.IsObjectIdHex(s) {
+			return nil, invalid
+		}
+		return bson.ObjectIdHex(s), nil
+	case "$date":
+		switch v := value.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, invalid
+			}
+			return t.UTC(), nil
+		case int64:
+			return time.Unix(v/1000, v%1000*int64(time.Millisecond)).UTC(), nil
+		default:
+			return nil, invalid
+		}
+	case "$numberInt", "$numberLong":
+		s, _ := value.(string)
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return i, nil
+	case "$numberDouble":
+		s, _ := value.(string)
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, invalid
+		}
+		return f, nil
+	case "$numberDecimal":
+		s, _ := value.(string)
+		d, err := bson.ParseDecimal128(s)
+		if err != nil {
+			return nil, invalid
+		}
+		return d, nil
+	case "$binary":
+		v, ok := value.(bson.D)
+		if !ok {
+			return nil, invalid
+		}
+		for _, elem := range v {
+			if elem.Name != "base64" {
+				continue
+			}
+			data, _ := elem.Value.(string)
+			b, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return nil, invalid
+			}
+			return b, nil
+		}
+		return nil, invalid
+	case "$timestamp":
+		v, ok := value.(bson.D)
+		if !ok {
+			return nil, invalid
+		}
+		var (
+			t, i int64
+			hasT bool
+		)
+		for _, elem := range v {
+			switch elem.Name {
+			case "t":
+				t, hasT = elem.Value.(int64)
+			case "i":
+				i, _ = elem.Value.(int64)
+			}
+		}
+		if !hasT {
+			return nil, invalid
+		}
+		return bson.MongoTimestamp(t<<32 | i&0xffffffff), nil
+	case "$regularExpression":
+		v, ok := value.(bson.D)
+		if !ok {
+			return nil, invalid
+		}
+		var r bson.RegEx
+		for _, elem := range v {
+			switch elem.Name {
+			case "pattern":
+				r.Pattern, _ = elem.Value.(string)
+			case "options":
+				r.Options, _ = elem.Value.(string)
+			}
+		}
+		return r, nil
+	case "$symbol":
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid
+		}
+		return bson.Symbol(s), nil
+	case "$code":
+		s, ok := value.(string)
+		if !ok {
+			return nil, invalid
+		}
+		return bson.JavaScript{Code: s}, nil
+	case "$undefined":
+		return bson.Undefined, nil
+	case "$minKey":
+		return bson.MinKey, nil
+	case "$maxKey":
+		return bson.MaxKey, nil
+	default:
+		return doc, nil
+	}
+}
@@ -0,0 +1,366 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// bsonLengthSize is the size of the little-endian length at the start of
+// every BSON document, which includes the length itself.
+const bsonLengthSize = 4
+
+const bsonTimeFormat = "2006-01-02 15:04:05.999"
+
+// BSONParser reads the documents of a MongoDB collection, either the BSON
+// files written by mongodump, or the newline-delimited extended JSON files
+// written by mongoexport. The fields of the documents are mapped to the
+// columns by name, or by the paths in `mydumper.bson.fields`, and the nested
+// documents and arrays are kept as the JSON text for the JSON columns. The
+// documents are decoded into bson.D by gopkg.in/mgo.v2/bson.
+type BSONParser struct {
+	blockParser
+	cfg          *config.BSONConfig
+	extendedJSON bool
+
+	// paths are the field paths of the columns, nil for the extra column.
+	paths [][]string
+	// extraIndex is the index of the extra column, or -1 if there is none.
+	extraIndex int
+	// mapped are the top-level fields mapped to the columns, which are not
+	// put into the extra column.
+	mapped map[string]struct{}
+}
+
+// NewBSONParser creates a parser of the BSON file, or the extended JSON file
+// if extendedJSON is true, mapping the fields of the documents to the
+// columns.
+func NewBSONParser(
+	cfg *config.BSONConfig,
+	reader ReadSeekCloser,
+	blockBufSize int64,
+	ioWorkers *worker.Pool,
+	columns []string,
+	extendedJSON bool,
+) *BSONParser {
+	parser := &BSONParser{
+		blockParser:  makeBlockParser(reader, blockBufSize, ioWorkers),
+		cfg:          cfg,
+		extendedJSON: extendedJSON,
+	}
+	parser.SetColumns(columns)
+	return parser
+}
+
+// SetColumns sets the columns which the fields of the documents are mapped to.
+func (parser *BSONParser) SetColumns(columns []string) {
+	fieldPaths := make(map[string][]string, len(parser.cfg.Fields))
+	for _, field := range parser.cfg.Fields {
+		fieldPaths[strings.ToLower(field.Column)] = strings.Split(field.Path, ".")
+	}
+	extraColumn := strings.ToLower(parser.cfg.ExtraColumn)
+
+	parser.columns = make([]string, 0, len(columns))
+	parser.paths = make([][]string, 0, len(columns))
+	parser.extraIndex = -1
+	parser.mapped = make(map[string]struct{}, len(columns))
+	for i, column := range columns {
+		column = strings.ToLower(column)
+		parser.columns = append(parser.columns, column)
+
+		var path []string
+		switch {
+		case column == extraColumn:
+			parser.extraIndex = i
+		case len(parser.cfg.Fields) > 0:
+			path = fieldPaths[column]
+		default:
+			path = []string{column}
+		}
+		parser.paths = append(parser.paths, path)
+		if len(path) > 0 {
+			parser.mapped[path[0]] = struct{}{}
+		}
+	}
+}
+
+// readDocument reads the next document of the BSON file. The result is only
+// valid until the next read.
+func (parser *BSONParser) readDocument() ([]byte, error) {
+	for len(parser.buf) < bsonLengthSize && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) == 0 {
+		return nil, io.EOF
+	}
+	if len(parser.buf) < bsonLengthSize {
+		return nil, errors.Errorf("truncated bson document length at offset %d", parser.pos)
+	}
+
+	size := int(int32(binary.LittleEndian.Uint32(parser.buf)))
+	if size < bsonLengthSize+1 {
+		return nil, errors.Errorf("invalid bson document length %d at offset %d", size, parser.pos)
+	}
+	for len(parser.buf) < size && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) < size {
+		return nil, errors.Errorf("truncated bson document at offset %d, expecting %d bytes but only %d remaining",
+			parser.pos, size, len(parser.buf))
+	}
+	document := parser.buf[:size]
+	parser.buf = parser.buf[size:]
+	parser.pos += int64(size)
+	return document, nil
+}
+
+// ReadRow reads a row from the datafile. The blank lines of the extended
+// JSON file are skipped.
+func (parser *BSONParser) ReadRow() error {
+	var (
+		doc bson.D
+		err error
+	)
+	start := parser.pos
+	if parser.extendedJSON {
+		var line []byte
+		for len(line) == 0 {
+			start = parser.pos
+			if line, err = parser.readLine(); err != nil {
+				return errors.Trace(err)
+			}
+			line = bytes.TrimSpace(line)
+		}
+		doc, err = decodeExtendedJSON(line)
+	} else {
+		var data []byte
+		if data, err = parser.readDocument(); err != nil {
+			return errors.Trace(err)
+		}
+		err = bson.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return errors.Annotatef(err, "invalid document at offset %d", start)
+	}
+
+	row := &parser.lastRow
+	row.RowID++
+	row.Row = parser.acquireDatumSlice()
+	if cap(row.Row) >= len(parser.columns) {
+		row.Row = row.Row[:len(parser.columns)]
+	} else {
+		row.Row = make([]types.Datum, len(parser.columns))
+	}
+	for i, path := range parser.paths {
+		if i == parser.extraIndex {
+			continue
+		}
+		value, _ := lookupBSONPath(doc, path, len(parser.cfg.Fields) == 0)
+		setBSONDatum(&row.Row[i], value)
+	}
+	if parser.extraIndex >= 0 {
+		var extra bson.D
+		for _, elem := range doc {
+			if _, ok := parser.mapped[parser.fieldKey(elem.Name)]; !ok {
+				extra = append(extra, elem)
+			}
+		}
+		row.Row[parser.extraIndex].SetString(string(appendBSONJSON(nil, extra)), "")
+	}
+	return nil
+}
+
+// fieldKey returns the key of the top-level field used in the mapping. The
+// fields are matched case-insensitively to the column names unless the paths
+// are configured.
+func (parser *BSONParser) fieldKey(key string) string {
+	if len(parser.cfg.Fields) == 0 {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+// lookupBSONPath finds the value of the field path in the document, where the
+// segments are the field names of the documents or the indices of the arrays.
+// Only the first segment is matched case-insensitively if fold is true.
+func lookupBSONPath(doc bson.D, path []string, fold bool) (interface{}, bool) {
+	if len(path) == 0 {
+		return nil, false
+	}
+	var value interface{} = doc
+	for i, name := range path {
+		switch next := value.(type) {
+		case bson.D:
+			found := false
+			for _, elem := range next {
+				if elem.Name == name || (fold && i == 0 && strings.EqualFold(elem.Name, name)) {
+					value, found = elem.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(next) {
+				return nil, false
+			}
+			value = next[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setBSONDatum converts the value to the datum. The missing fields are NULL.
+func setBSONDatum(d *types.Datum, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		d.SetNull()
+	case bool:
+		if v {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case int:
+		d.SetInt64(int64(v))
+	case int64:
+		d.SetInt64(v)
+	case float64:
+		d.SetFloat64(v)
+	case string:
+		d.SetString(v, "")
+	case []byte:
+		d.SetBytes(v)
+	case bson.Binary:
+		d.SetBytes(v.Data)
+	case bson.D, []interface{}:
+		d.SetString(string(appendBSONJSON(nil, v)), "")
+	default:
+		if s, ok := formatBSONScalar(v); ok {
+			d.SetString(s, "")
+		} else {
+			d.SetNull()
+		}
+	}
+}
+
+// formatBSONScalar formats the values of the BSON types without the native Go
+// types as the text. The time of a timestamp is its seconds. The undefined,
+// the min key and the max key have no text.
+func formatBSONScalar(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(bsonTimeFormat), true
+	case bson.MongoTimestamp:
+		return time.Unix(int64(v)>>32, 0).UTC().Format(bsonTimeFormat), true
+	case bson.ObjectId:
+		return v.Hex(), true
+	case bson.Decimal128:
+		return v.String(), true
+	case bson.RegEx:
+		return "/" + v.Pattern + "/" + v.Options, true
+	case bson.Symbol:
+		return string(v), true
+	case bson.JavaScript:
+		return v.Code, true
+	default:
+		return "", false
+	}
+}
+
+// appendBSONJSON appends the value as the compact JSON text, keeping the
+// order of the fields of the documents.
+func appendBSONJSON(buf []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, "null"...)
+	case bool:
+		return strconv.AppendBool(buf, v)
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(buf, v, 10)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return appendJSONString(buf, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		return strconv.AppendFloat(buf, v, 'g', -1, 64)
+	case string:
+		return appendJSONString(buf, v)
+	case []byte:
+		return appendJSONString(buf, base64.StdEncoding.EncodeToString(v))
+	case bson.Binary:
+		return appendJSONString(buf, base64.StdEncoding.EncodeToString(v.Data))
+	case bson.Decimal128:
+		// NaN and Inf are not the JSON numbers.
+		s := v.String()
+		if !strings.ContainsAny(s[:1], "-0123456789") || !json.Valid([]byte(s)) {
+			return appendJSONString(buf, s)
+		}
+		return append(buf, s...)
+	case bson.D:
+		buf = append(buf, '{')
+		for i, elem := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, elem.Name)
+			buf = append(buf, ':')
+			buf = appendBSONJSON(buf, elem.Value)
+		}
+		return append(buf, '}')
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, elem := range v {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendBSONJSON(buf, elem)
+		}
+		return append(buf, ']')
+	default:
+		if s, ok := formatBSONScalar(v); ok {
+			return appendJSONString(buf, s)
+		}
+		return append(buf, "null"...)
+	}
+}
+
+func appendJSONString(buf []byte, s string) []byte {
+	text, _ := json.Marshal(s)
+	return append(buf, text...)
+}
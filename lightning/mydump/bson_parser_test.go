@@ -0,0 +1,239 @@
+package mydump_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testMydumpBSONParserSuite{})
+
+type testMydumpBSONParserSuite struct {
+	ioWorkers *worker.Pool
+}
+
+func (s *testMydumpBSONParserSuite) SetUpSuite(c *C) {
+	s.ioWorkers = worker.NewPool(context.Background(), 5, "test_bson")
+}
+
+// bsonDoc encodes the document of the elements, each of which is the type,
+// the key and the value, prefixed by the length of the document.
+func bsonDoc(elems ...string) string {
+	body := strings.Join(elems, "") + "\x00"
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(body)+4))
+	return string(length) + body
+}
+
+func bsonElem(tag byte, key string, value string) string {
+	return string([]byte{tag}) + key + "\x00" + value
+}
+
+func bsonInt32(v int32) string {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	return string(b)
+}
+
+func bsonInt64(v int64) string {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(v))
+	return string(b)
+}
+
+func bsonStr(s string) string {
+	return bsonInt32(int32(len(s)+1)) + s + "\x00"
+}
+
+func bsonString(s string) types.Datum {
+	return types.NewCollationStringDatum(s, "", 0)
+}
+
+func (s *testMydumpBSONParserSuite) TestReadBSONDocuments(c *C) {
+	doc1 := bsonDoc(
+		bsonElem(0x07, "ID", "\x5e\x0b\xe1\x00\xaa\xbb\xcc\xdd\xee\xff\x00\x11"),
+		bsonElem(0x02, "name", bsonStr("Ann")),
+		bsonElem(0x10, "age", bsonInt32(30)),
+		bsonElem(0x03, "address", bsonDoc(
+			bsonElem(0x02, "city", bsonStr("X")),
+			bsonElem(0x12, "zip", bsonInt64(7)),
+		)),
+		// 1.5 as the coefficient 15 and the exponent -1.
+		bsonElem(0x13, "score", bsonInt64(15)+bsonInt64(6175<<49)),
+		bsonElem(0x04, "tags", bsonDoc(
+			bsonElem(0x02, "0", bsonStr("a")),
+			bsonElem(0x08, "1", "\x01"),
+		)),
+		bsonElem(0x01, "ignored", bsonInt64(0)),
+	)
+	doc2 := bsonDoc(
+		bsonElem(0x0a, "name", ""),
+		bsonElem(0x01, "age", bsonInt64(0x4004000000000000)),
+		bsonElem(0x09, "address", bsonInt64(1577836800500)),
+		bsonElem(0x05, "tags", bsonInt32(2)+"\x00\x00\xff"),
+		bsonElem(0x0b, "score", "^a\x00i\x00"),
+	)
+
+	columns := []string{"id", "name", "age", "address", "score", "tags"}
+	cfg := config.BSONConfig{}
+	// the small block size makes the documents span blocks.
+	parser := mydump.NewBSONParser(&cfg, mydump.NewStringReader(doc1+doc2), 8, s.ioWorkers, columns, false)
+	c.Assert(parser.Columns(), DeepEquals, columns)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row: []types.Datum{
+			bsonString("5e0be100aabbccddeeff0011"),
+			bsonString("Ann"),
+			types.NewIntDatum(30),
+			bsonString(`{"city":"X","zip":7}`),
+			bsonString("1.5"),
+			bsonString(`["a",true]`),
+		},
+	})
+	c.Assert(parser, posEq, len(doc1), 1)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 2,
+		Row: []types.Datum{
+			nullDatum,
+			nullDatum,
+			types.NewFloat64Datum(2.5),
+			bsonString("2020-01-01 00:00:00.5"),
+			bsonString("/^a/i"),
+			types.NewBytesDatum([]byte{0x00, 0xff}),
+		},
+	})
+	c.Assert(parser, posEq, len(doc1)+len(doc2), 2)
+
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpBSONParserSuite) TestFieldsAndExtraColumn(c *C) {
+	doc := bsonDoc(
+		bsonElem(0x10, "_id", bsonInt32(1)),
+		bsonElem(0x03, "address", bsonDoc(bsonElem(0x02, "city", bsonStr("Y")))),
+		bsonElem(0x04, "tags", bsonDoc(
+			bsonElem(0x02, "0", bsonStr("a")),
+			bsonElem(0x02, "1", bsonStr("b")),
+		)),
+		bsonElem(0x02, "note", bsonStr("n")),
+		bsonElem(0x09, "when", bsonInt64(1577836800000)),
+	)
+
+	cfg := config.BSONConfig{
+		Fields: []*config.BSONField{
+			{Path: "_id", Column: "id"},
+			{Path: "address.city", Column: "City"},
+			{Path: "tags.1", Column: "tag"},
+			{Path: "tags.2", Column: "missing"},
+		},
+		ExtraColumn: "rest",
+	}
+	columns := []string{"id", "city", "tag", "missing", "rest", "other"}
+	parser := mydump.NewBSONParser(&cfg, mydump.NewStringReader(doc), 1024, s.ioWorkers, columns, false)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row: []types.Datum{
+			types.NewIntDatum(1),
+			bsonString("Y"),
+			bsonString("b"),
+			nullDatum,
+			bsonString(`{"note":"n","when":"2020-01-01 00:00:00"}`),
+			nullDatum,
+		},
+	})
+}
+
+func (s *testMydumpBSONParserSuite) TestReadExtendedJSON(c *C) {
+	line1 := `{"_id":{"$oid":"5e0be100aabbccddeeff0011"},"n":{"$numberLong":"9007199254740993"},` +
+		`"d":{"$date":"2020-01-01T00:00:00.5Z"},"big":{"$numberDecimal":"12345678901234567890.5"},` +
+		`"bin":{"$binary":{"base64":"AP8=","subType":"00"}},"t":{"$timestamp":{"t":1577836800,"i":1}},` +
+		`"r":{"$regularExpression":{"pattern":"^a","options":"i"}}}`
+	line2 := `{"_id":1,"n":18446744073709551616,"d":{"$date":{"$numberLong":"1577836800000"}},` +
+		`"big":[{"$numberDouble":"NaN"},{"$numberDecimal":"1E+3"}],"bin":{"$binary":"AP8=","$type":"00"},` +
+		`"t":{"a":{"$minKey":1}},"r":{"$regex":"x","$options":""}}`
+	input := line1 + "\n\n" + line2 + "\n"
+
+	columns := []string{"_id", "n", "d", "big", "bin", "t", "r"}
+	cfg := config.BSONConfig{}
+	parser := mydump.NewBSONParser(&cfg, mydump.NewStringReader(input), 16, s.ioWorkers, columns, true)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row: []types.Datum{
+			bsonString("5e0be100aabbccddeeff0011"),
+			types.NewIntDatum(9007199254740993),
+			bsonString("2020-01-01 00:00:00.5"),
+			bsonString("12345678901234567890.5"),
+			types.NewBytesDatum([]byte{0x00, 0xff}),
+			bsonString("2020-01-01 00:00:00"),
+			bsonString("/^a/i"),
+		},
+	})
+	c.Assert(parser, posEq, len(line1)+1, 1)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 2,
+		Row: []types.Datum{
+			types.NewIntDatum(1),
+			bsonString("18446744073709551616"),
+			bsonString("2020-01-01 00:00:00"),
+			bsonString(`["NaN",1E+3]`),
+			types.NewBytesDatum([]byte{0x00, 0xff}),
+			bsonString(`{"a":null}`),
+			bsonString("/x/"),
+		},
+	})
+	c.Assert(parser, posEq, len(input), 2)
+
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpBSONParserSuite) TestSetPos(c *C) {
+	doc1 := bsonDoc(bsonElem(0x10, "a", bsonInt32(1)))
+	doc2 := bsonDoc(bsonElem(0x10, "a", bsonInt32(2)))
+	cfg := config.BSONConfig{}
+	parser := mydump.NewBSONParser(&cfg, mydump.NewStringReader(doc1+doc2), 1024, s.ioWorkers, []string{"a"}, false)
+	c.Assert(parser.SetPos(int64(len(doc1)), 5), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 6, Row: []types.Datum{types.NewIntDatum(2)}})
+	c.Assert(parser, posEq, len(doc1)+len(doc2), 6)
+}
+
+func (s *testMydumpBSONParserSuite) TestInvalidDocuments(c *C) {
+	cases := []struct {
+		input        string
+		extendedJSON bool
+		err          string
+	}{
+		{"\x10\x00\x00\x00\x00", false, "truncated bson document at offset 0, expecting 16 bytes but only 5 remaining"},
+		{"\x03\x00\x00\x00", false, "invalid bson document length 3 at offset 0"},
+		{"\x05\x00", false, "truncated bson document length at offset 0"},
+		{bsonDoc(bsonElem(0x20, "a", "")), false, `invalid document at offset 0: Unknown element kind \(0x20\)`},
+		{bsonDoc(bsonElem(0x02, "a", bsonStr("xyz")[:6])), false, "invalid document at offset 0: Document is corrupted"},
+		{"[1]\n", true, "invalid document at offset 0: each line must be a JSON object"},
+		{"\n" + `{"a":{"$oid":"zz"}}`, true, `invalid document at offset 1: invalid extended JSON value of '\$oid'`},
+		{`{"a":1} 2`, true, "invalid document at offset 0: unexpected content after the JSON object"},
+	}
+	cfg := config.BSONConfig{}
+	for _, tc := range cases {
+		parser := mydump.NewBSONParser(&cfg, mydump.NewStringReader(tc.input), 1024, s.ioWorkers, []string{"a"}, tc.extendedJSON)
+		c.Assert(parser.ReadRow(), ErrorMatches, tc.err, Commentf("input = %q", tc.input))
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// charsetSampleSize is the number of leading bytes of a file used to detect
+// its charset.
+const charsetSampleSize = 64 * 1024
+
+// CharsetGuess is the likely charset of a piece of text, together with the
+// confidence between 0 and 1 of the guess.
+type CharsetGuess struct {
+	Charset    string
+	Confidence float64
+}
+
+// FileCharset is the charset detected on a source file.
+type FileCharset struct {
+	Path string
+	CharsetGuess
+}
+
+// DetectCharset guesses the charset of the sample among utf8mb4, gb18030 and
+// latin1. The sample may be cut in the middle of a character.
+//
+// The more non-ASCII characters the sample contains, the more confident the
+// guess is. A sample of pure ASCII is reported as utf8mb4 with confidence 1,
+// since it decodes identically in all supported charsets.
+func DetectCharset(sample []byte) CharsetGuess {
+	if isASCII(sample) {
+		return CharsetGuess{Charset: "utf8mb4", Confidence: 1}
+	}
+	if trimmed := trimIncompleteRune(sample); utf8.Valid(trimmed) {
+		n := 0
+		for _, r := range string(trimmed) {
+			if r >= utf8.RuneSelf {
+				n++
+			}
+		}
+		return CharsetGuess{Charset: "utf8mb4", Confidence: 1 - math.Pow(0.1, float64(n))}
+	}
+
+	best := CharsetGuess{Charset: "latin1", Confidence: latin1Confidence(sample)}
+	if confidence := gb18030Confidence(sample); confidence > best.Confidence {
+		best = CharsetGuess{Charset: "gb18030", Confidence: confidence}
+	}
+	return best
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// trimIncompleteRune removes the trailing bytes of a UTF-8 character cut by
+// the end of the sample.
+func trimIncompleteRune(data []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				return data[:len(data)-i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// gb18030Confidence returns the ratio of CJK characters among the non-ASCII
+// characters of the decoded sample, or 0 if the sample is not valid gb18030.
+func gb18030Confidence(sample []byte) float64 {
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(sample)
+	if err != nil {
+		return 0
+	}
+	// a replacement character at the end is caused by the cut of the sample.
+	decoded = bytes.TrimSuffix(decoded, []byte("\ufffd"))
+	if bytes.ContainsRune(decoded, '\ufffd') {
+		return 0
+	}
+
+	total, cjk := 0, 0
+	for _, r := range string(decoded) {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		total++
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) ||
+			(r >= 0x3000 && r <= 0x303f) || (r >= 0xff00 && r <= 0xffef) {
+			cjk++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(cjk) / float64(total) * (1 - math.Pow(0.25, float64(total)))
+}
+
+// latin1Confidence returns the ratio of non-ASCII bytes which look like
+// accented letters between ASCII characters. Text in multi-byte charsets
+// rarely has such isolated bytes.
+func latin1Confidence(sample []byte) float64 {
+	total, letters := 0, 0
+	for i, b := range sample {
+		if b < utf8.RuneSelf {
+			continue
+		}
+		total++
+		isolated := (i == 0 || sample[i-1] < utf8.RuneSelf) && (i == len(sample)-1 || sample[i+1] < utf8.RuneSelf)
+		if isolated && b >= 0xc0 && b != 0xd7 && b != 0xf7 {
+			letters++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	// never fully confident, since any byte sequence is valid latin1.
+	return 0.9 * float64(letters) / float64(total) * (1 - math.Pow(0.25, float64(total)))
+}
+
+// detectFileCharset guesses the charset from the leading bytes of the file.
+func detectFileCharset(ctx context.Context, store storage.ExternalStorage, path string) (CharsetGuess, error) {
+	reader, err := store.Open(ctx, path)
+	if err != nil {
+		return CharsetGuess{}, errors.Trace(err)
+	}
+	defer reader.Close()
+
+	sample := make([]byte, charsetSampleSize)
+	n, err := io.ReadFull(reader, sample)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CharsetGuess{}, errors.Annotatef(err, "failed to read %s", path)
+	}
+	return DetectCharset(sample[:n]), nil
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testCharsetDetectSuite{})
+
+type testCharsetDetectSuite struct{}
+
+func (s *testCharsetDetectSuite) TestDetectCharset(c *C) {
+	cases := []struct {
+		sample  string
+		charset string
+		minConf float64
+		maxConf float64
+	}{
+		{sample: "INSERT INTO t VALUES (1);", charset: "utf8mb4", minConf: 1, maxConf: 1},
+		{sample: "INSERT INTO t VALUES ('总案例');", charset: "utf8mb4", minConf: 0.99, maxConf: 1},
+		// the last character is cut in the middle.
+		{sample: "INSERT INTO t VALUES ('总案\xe4\xbe", charset: "utf8mb4", minConf: 0.98, maxConf: 1},
+		{sample: "INSERT INTO t VALUES ('\xD7\xDC\xB0\xB8\xC0\xFD');", charset: "gb18030", minConf: 0.95, maxConf: 1},
+		{sample: "INSERT INTO t VALUES ('\xD7\xDC\xB0\xB8\xC0", charset: "gb18030", minConf: 0.9, maxConf: 1},
+		{sample: "INSERT INTO t VALUES ('caf\xe9'), ('r\xe9sum\xe9'), ('na\xefve');", charset: "latin1", minConf: 0.85, maxConf: 0.9},
+		{sample: "INSERT INTO t VALUES ('caf\xe9');", charset: "latin1", minConf: 0.6, maxConf: 0.7},
+	}
+	for _, tc := range cases {
+		guess := mydump.DetectCharset([]byte(tc.sample))
+		comment := Commentf("sample %q", tc.sample)
+		c.Assert(guess.Charset, Equals, tc.charset, comment)
+		c.Assert(guess.Confidence >= tc.minConf && guess.Confidence <= tc.maxConf, IsTrue,
+			Commentf("sample %q, confidence %f", tc.sample, guess.Confidence))
+	}
+}
+
+func (s *testCharsetDetectSuite) TestDetectCharsetGibberish(c *C) {
+	guess := mydump.DetectCharset([]byte("\x9e\x02\xdc\xfbZ/=n\xf3\xf2N8\xc1\xf2\xe9\xaa\xd0\x85\xc5}\x97\x07\xae6\x97\x99\x9c\x08\xcb\xe8;"))
+	c.Assert(guess.Confidence < 0.5, IsTrue, Commentf("guess %+v", guess))
+}
@@ -0,0 +1,125 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+)
+
+// ColumnDecoder decodes the raw value of a column field read from the data
+// file, e.g. a column storing gzip+base64 payloads.
+type ColumnDecoder func(data []byte) ([]byte, error)
+
+var columnDecoders = map[string]ColumnDecoder{
+	"base64": decodeBase64,
+	"hex":    decodeHex,
+	"gzip":   decodeGzip,
+	"zlib":   decodeZlib,
+	"snappy": decodeSnappy,
+}
+
+// NewColumnDecoder chains the named decoders, which are applied in the given
+// order. For instance, a gzip+base64 payload should use ["base64", "gzip"].
+func NewColumnDecoder(names []string) (ColumnDecoder, error) {
+	decoders := make([]ColumnDecoder, 0, len(names))
+	for _, name := range names {
+		decoder, ok := columnDecoders[name]
+		if !ok {
+			return nil, errors.Errorf("unknown column decoder '%s'", name)
+		}
+		decoders = append(decoders, decoder)
+	}
+
+	return func(data []byte) ([]byte, error) {
+		var err error
+		for i, decoder := range decoders {
+			if data, err = decoder(data); err != nil {
+				return nil, errors.Annotatef(err, "column decoder '%s' failed", names[i])
+			}
+		}
+		return data, nil
+	}, nil
+}
+
+// DecodeColumns applies the decoders on the row in place. The decoders are
+// indexed by the field position in the data file. NULL and non-string values
+// are left unchanged.
+func DecodeColumns(row []types.Datum, decoders map[int]ColumnDecoder) error {
+	for i, decoder := range decoders {
+		if i >= len(row) {
+			continue
+		}
+		if kind := row[i].Kind(); kind != types.KindString && kind != types.KindBytes {
+			continue
+		}
+		data, err := decoder(row[i].GetBytes())
+		if err != nil {
+			return errors.Annotatef(err, "failed to decode field #%d", i+1)
+		}
+		row[i].SetBytes(data)
+	}
+	return nil
+}
+
+func decodeBase64(data []byte) ([]byte, error) {
+	res := make([]byte, base64.StdEncoding.DecodedLen(len(data)))
+	n, err := base64.StdEncoding.Decode(res, data)
+	return res[:n], errors.Trace(err)
+}
+
+func decodeHex(data []byte) ([]byte, error) {
+	res := make([]byte, hex.DecodedLen(len(data)))
+	n, err := hex.Decode(res, data)
+	return res[:n], errors.Trace(err)
+}
+
+func decodeGzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return readAllAndClose(r)
+}
+
+func decodeZlib(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return readAllAndClose(r)
+}
+
+func decodeSnappy(data []byte) ([]byte, error) {
+	res, err := snappy.Decode(nil, data)
+	return res, errors.Trace(err)
+}
+
+func readAllAndClose(r io.ReadCloser) ([]byte, error) {
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return nil, errors.Trace(err)
+	}
+	return res, errors.Trace(r.Close())
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testColumnDecoderSuite{})
+
+type testColumnDecoderSuite struct{}
+
+func (s *testColumnDecoderSuite) TestNewColumnDecoder(c *C) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte("some large text"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	payload := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	decoder, err := NewColumnDecoder([]string{"base64", "gzip"})
+	c.Assert(err, IsNil)
+	data, err := decoder([]byte(payload))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "some large text")
+
+	decoder, err = NewColumnDecoder([]string{"hex", "snappy"})
+	c.Assert(err, IsNil)
+	data, err = decoder([]byte("0d3068656c6c6f2c20736e61707079"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "hello, snappy")
+
+	_, err = decoder([]byte("not hex"))
+	c.Assert(err, ErrorMatches, "column decoder 'hex' failed.*")
+
+	_, err = NewColumnDecoder([]string{"base64", "rot13"})
+	c.Assert(err, ErrorMatches, "unknown column decoder 'rot13'")
+}
+
+func (s *testColumnDecoderSuite) TestDecodeColumns(c *C) {
+	decoder, err := NewColumnDecoder([]string{"base64"})
+	c.Assert(err, IsNil)
+
+	row := []types.Datum{
+		types.NewIntDatum(1),
+		types.NewBytesDatum([]byte("aGVsbG8=")),
+		{},
+		types.NewBytesDatum([]byte("aGVsbG8=")),
+	}
+	err = DecodeColumns(row, map[int]ColumnDecoder{0: decoder, 1: decoder, 2: decoder})
+	c.Assert(err, IsNil)
+	c.Assert(row[0].GetInt64(), Equals, int64(1))
+	c.Assert(row[1].GetBytes(), DeepEquals, []byte("hello"))
+	c.Assert(row[2].IsNull(), IsTrue)
+	c.Assert(row[3].GetBytes(), DeepEquals, []byte("aGVsbG8="))
+}
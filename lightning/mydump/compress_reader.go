@@ -0,0 +1,353 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// TableFileSizeINF is the end offset of the region of a compressed file,
+	// whose decompressed size is unknown, so the file is read until EOF.
+	TableFileSizeINF = math.MaxInt64 / 2
+
+	// compressSizeFactor enlarges the estimated decompressed size to compute
+	// the row IDs, since the beginning of a file may compress worse than the
+	// rest of it.
+	compressSizeFactor = 2
+
+	// compressRatioSampleSize is the size of the decompressed data sampled to
+	// estimate the compress ratio of a file.
+	compressRatioSampleSize = 4 * 1024 * 1024
+)
+
+// compressionMagics are the leading bytes of the compressed files.
+var compressionMagics = []struct {
+	magic       []byte
+	compression Compression
+}{
+	{[]byte{0x1f, 0x8b}, CompressionGZ},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, CompressionZStd},
+	{[]byte{0x04, 0x22, 0x4d, 0x18}, CompressionLZ4},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, CompressionXZ},
+	{[]byte{'B', 'Z', 'h'}, CompressionBZ2},
+	{[]byte{0xff, 0x06, 0x00, 0x00, 's', 'N', 'a', 'P', 'p', 'Y'}, CompressionSnappy},
+}
+
+// compressionMagicSize is the length of the longest magic bytes.
+const compressionMagicSize = 10
+
+// DetectCompression returns the compression indicated by the leading bytes
+// of a file, or CompressionNone if they match none of the magic bytes.
+func DetectCompression(header []byte) Compression {
+	for _, m := range compressionMagics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.compression
+		}
+	}
+	return CompressionNone
+}
+
+// detectFileCompression detects the compression of a file by its leading
+// bytes.
+func detectFileCompression(ctx context.Context, store storage.ExternalStorage, path string) (Compression, error) {
+	file, err := store.Open(ctx, path)
+	if err != nil {
+		return CompressionNone, errors.Trace(err)
+	}
+	defer file.Close()
+
+	header := make([]byte, compressionMagicSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return CompressionNone, errors.Trace(err)
+	}
+	return DetectCompression(header[:n]), nil
+}
+
+// decoder is a decompressor which can restart on a new stream.
+type decoder interface {
+	io.Reader
+	Reset(r io.Reader) error
+	Close()
+}
+
+type gzipDecoder struct {
+	*gzip.Reader
+}
+
+func (d gzipDecoder) Close() {
+	_ = d.Reader.Close()
+}
+
+type zstdDecoder struct {
+	*zstd.Decoder
+}
+
+type lz4Decoder struct {
+	*lz4.Reader
+}
+
+func (d lz4Decoder) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+func (d lz4Decoder) Close() {}
+
+// snappyDecoder reads the snappy framing format, not the raw snappy blocks.
+type snappyDecoder struct {
+	*snappy.Reader
+}
+
+func (d snappyDecoder) Reset(r io.Reader) error {
+	d.Reader.Reset(r)
+	return nil
+}
+
+func (d snappyDecoder) Close() {}
+
+// bzip2Decoder wraps the bzip2 reader of the standard library, which cannot
+// be reset, so a new reader is created instead.
+type bzip2Decoder struct {
+	io.Reader
+}
+
+func (d *bzip2Decoder) Reset(r io.Reader) error {
+	d.Reader = bzip2.NewReader(r)
+	return nil
+}
+
+func (d *bzip2Decoder) Close() {}
+
+// xzDecoder reads the concatenated xz streams with github.com/ulikunitz/xz,
+// which cannot be reset, so a new reader is created instead. It holds a slot
+// of the xz limiter until closed.
+type xzDecoder struct {
+	*xz.Reader
+	slots chan struct{}
+	once  sync.Once
+}
+
+func (d *xzDecoder) Reset(r io.Reader) error {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	d.Reader = xr
+	return nil
+}
+
+func (d *xzDecoder) Close() {
+	d.once.Do(func() { <-d.slots })
+}
+
+var (
+	xzLimiterMu sync.Mutex
+	// xzLimiter limits the xz files decoded at the same time, since the xz
+	// decoding is CPU heavy and competes with the encoding of the rows.
+	xzLimiter = make(chan struct{}, runtime.NumCPU())
+)
+
+// SetXZConcurrency sets the maximum number of the xz files decoded at the
+// same time. The decoders already opened keep their slots of the previous
+// limit until closed.
+func SetXZConcurrency(n int) {
+	if n <= 0 {
+		n = 1
+	}
+	xzLimiterMu.Lock()
+	xzLimiter = make(chan struct{}, n)
+	xzLimiterMu.Unlock()
+}
+
+func newXZDecoder(r io.Reader) (decoder, error) {
+	xzLimiterMu.Lock()
+	slots := xzLimiter
+	xzLimiterMu.Unlock()
+
+	slots <- struct{}{}
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		<-slots
+		return nil, errors.Trace(err)
+	}
+	return &xzDecoder{Reader: xr, slots: slots}, nil
+}
+
+func newDecoder(compression Compression, r io.Reader) (decoder, error) {
+	switch compression {
+	case CompressionGZ:
+		if n := int(atomic.LoadInt32(&gzipConcurrency)); n > 1 {
+			d, err := newParallelGzipDecoder(r, n)
+			if err != nil {
+				return nil, err
+			}
+			return d, nil
+		}
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return gzipDecoder{gr}, nil
+	case CompressionZStd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return zstdDecoder{zr}, nil
+	case CompressionLZ4:
+		return lz4Decoder{lz4.NewReader(r)}, nil
+	case CompressionXZ:
+		return newXZDecoder(r)
+	case CompressionBZ2:
+		return &bzip2Decoder{bzip2.NewReader(r)}, nil
+	case CompressionSnappy:
+		return snappyDecoder{snappy.NewReader(r)}, nil
+	default:
+		return nil, errors.Errorf("unsupported compression type %s", compression)
+	}
+}
+
+// decompressReader reads the decompressed data of a file. The offsets are of
+// the decompressed data, and seeking backwards restarts from the beginning of
+// the file, so it should be avoided except for rewinding to the start. With
+// the index of a gzip file, seeking restarts from the nearest indexed member
+// instead.
+type decompressReader struct {
+	file        ReadSeekCloser
+	compression Compression
+	decoder     decoder
+	index       GzipIndex
+	pos         int64
+}
+
+// NewDecompressReader wraps the reader of a compressed file to read the
+// decompressed data. The file is closed with the returned reader.
+func NewDecompressReader(file ReadSeekCloser, compression Compression) (ReadSeekCloser, error) {
+	d, err := newDecoder(compression, file)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{file: file, compression: compression, decoder: d}, nil
+}
+
+// NewIndexedDecompressReader is like NewDecompressReader for a gzip file, but
+// seeks to any offset by restarting from the nearest member in the index.
+func NewIndexedDecompressReader(file ReadSeekCloser, index GzipIndex) (ReadSeekCloser, error) {
+	d, err := newDecoder(CompressionGZ, file)
+	if err != nil {
+		return nil, err
+	}
+	return &decompressReader{file: file, compression: CompressionGZ, decoder: d, index: index}, nil
+}
+
+func (r *decompressReader) Read(p []byte) (int, error) {
+	n, err := r.decoder.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func (r *decompressReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	default:
+		return r.pos, errors.New("cannot seek from the end of a compressed file")
+	}
+	if offset < 0 {
+		return r.pos, errors.Errorf("invalid seek offset %d", offset)
+	}
+
+	if entry := r.index.lookup(offset); offset < r.pos || entry.uncompressedOffset > r.pos {
+		if _, err := r.file.Seek(entry.compressedOffset, io.SeekStart); err != nil {
+			return r.pos, errors.Trace(err)
+		}
+		if err := r.decoder.Reset(r.file); err != nil {
+			return r.pos, errors.Trace(err)
+		}
+		r.pos = entry.uncompressedOffset
+	}
+	if offset > r.pos {
+		n, err := io.CopyN(ioutil.Discard, r.decoder, offset-r.pos)
+		r.pos += n
+		if err != nil {
+			return r.pos, errors.Annotatef(err, "seek to offset %d of the decompressed data", offset)
+		}
+	}
+	return r.pos, nil
+}
+
+func (r *decompressReader) Close() error {
+	r.decoder.Close()
+	return r.file.Close()
+}
+
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// EstimateRealSize estimates the decompressed size of a compressed file by
+// decompressing its beginning. The exact size is returned if the sample
+// reaches the end of the file.
+func EstimateRealSize(ctx context.Context, store storage.ExternalStorage, dataFile FileInfo) (int64, error) {
+	file, err := store.Open(ctx, dataFile.FileMeta.Path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer file.Close()
+
+	counter := &countingReader{Reader: file}
+	d, err := newDecoder(dataFile.FileMeta.Compression, counter)
+	if err != nil {
+		return 0, errors.Annotatef(err, "decompress %s", dataFile.FileMeta.Path)
+	}
+	defer d.Close()
+
+	n, err := io.CopyN(ioutil.Discard, d, compressRatioSampleSize)
+	switch {
+	case err == io.EOF:
+		return n, nil
+	case err != nil:
+		return 0, errors.Annotatef(err, "decompress %s", dataFile.FileMeta.Path)
+	case counter.n == 0:
+		return dataFile.Size, nil
+	}
+	return int64(float64(dataFile.Size) * float64(n) / float64(counter.n)), nil
+}
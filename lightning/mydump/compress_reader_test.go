@@ -0,0 +1,287 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testCompressReaderSuite{})
+
+type testCompressReaderSuite struct{}
+
+func compress(c *C, compression Compression, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case CompressionGZ:
+		w = gzip.NewWriter(&buf)
+	case CompressionZStd:
+		zw, err := zstd.NewWriter(&buf)
+		c.Assert(err, IsNil)
+		w = zw
+	case CompressionLZ4:
+		w = lz4.NewWriter(&buf)
+	case CompressionSnappy:
+		w = snappy.NewBufferedWriter(&buf)
+	default:
+		c.Fatalf("unexpected compression %s", compression)
+	}
+	_, err := w.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	return buf.Bytes()
+}
+
+type bytesReadSeekCloser struct {
+	*bytes.Reader
+}
+
+func (bytesReadSeekCloser) Close() error {
+	return nil
+}
+
+func (s *testCompressReaderSuite) TestDecompressReader(c *C) {
+	data := []byte(strings.Repeat("1,2,3\n", 1000))
+	for _, compression := range []Compression{CompressionGZ, CompressionZStd, CompressionLZ4, CompressionSnappy} {
+		file := bytesReadSeekCloser{bytes.NewReader(compress(c, compression, data))}
+		r, err := NewDecompressReader(file, compression)
+		c.Assert(err, IsNil)
+
+		content, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(content, DeepEquals, data)
+
+		// seeking backwards rewinds the file.
+		pos, err := r.Seek(600, io.SeekStart)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, int64(600))
+		pos, err = r.Seek(6, io.SeekCurrent)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, int64(606))
+		content, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(content, DeepEquals, data[606:])
+
+		_, err = r.Seek(0, io.SeekEnd)
+		c.Assert(err, ErrorMatches, "cannot seek from the end of a compressed file")
+		c.Assert(r.Close(), IsNil)
+	}
+
+	_, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(data)}, CompressionGZ)
+	c.Assert(err, NotNil)
+	_, err = NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(data)}, CompressionXZ)
+	c.Assert(err, ErrorMatches, "not a xz file")
+}
+
+func (s *testCompressReaderSuite) TestDecompressBZip2(c *C) {
+	// the output of `bzip2` of strings.Repeat("1,2,3\n", 1000), since the
+	// standard library cannot compress to bzip2.
+	compressed, err := hex.DecodeString("425a6839314159265359a20bf8b20007cfd80000100004380020005066804d54636a419520da90654836a9070bb9229c28485105fc5900")
+	c.Assert(err, IsNil)
+	data := []byte(strings.Repeat("1,2,3\n", 1000))
+
+	r, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(compressed)}, CompressionBZ2)
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(content, DeepEquals, data)
+
+	pos, err := r.Seek(606, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(606))
+	content, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(content, DeepEquals, data[606:])
+	c.Assert(r.Close(), IsNil)
+
+	r, err = NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(data)}, CompressionBZ2)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, NotNil)
+}
+
+// the outputs of `xz --check=<check>` of strings.Repeat("1,2,3\n", 1000).
+var xzTestFiles = map[string]string{
+	"crc64":  "fd377a585a000004e6d6b44604c02ff02e21011600000000000000009d1192bbe0176f00275d00188b02a936b3041a921a8474510892e2958996bf29a9b001ac932637b0571e8debef32d3ba5676000096671bea0976a85000014bf02e00000022a613aeb1c467fb020000000004595a",
+	"crc32":  "fd377a585a0000016922de3604c02ff02e21011600000000000000009d1192bbe0176f00275d00188b02a936b3041a921a8474510892e2958996bf29a9b001ac932637b0571e8debef32d3ba56760000c90ca51e000147f02e0000005966d1d93e300d8b020000000001595a",
+	"sha256": "fd377a585a00000ae1fb0ca104c02ff02e21011600000000000000009d1192bbe0176f00275d00188b02a936b3041a921a8474510892e2958996bf29a9b001ac932637b0571e8debef32d3ba56760000ff8751768fe3633672904c76a68a04fe98bd31edcc85b8ad0e54457505e506f2000163f02e0000007921ec45b6e9df1c02000000000a595a",
+	"none":   "fd377a585a000000ff12d94104c02ff02e21011600000000000000009d1192bbe0176f00275d00188b02a936b3041a921a8474510892e2958996bf29a9b001ac932637b0571e8debef32d3ba56760000000143f02e0000004f244042a8000afc020000000000595a",
+}
+
+func xzTestFile(c *C, check string) []byte {
+	data, err := hex.DecodeString(xzTestFiles[check])
+	c.Assert(err, IsNil)
+	return data
+}
+
+func decompressXZ(compressed []byte) ([]byte, error) {
+	r, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(compressed)}, CompressionXZ)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (s *testCompressReaderSuite) TestDecompressXZ(c *C) {
+	expected := []byte(strings.Repeat("1,2,3\n", 1000))
+	for check := range xzTestFiles {
+		data, err := decompressXZ(xzTestFile(c, check))
+		c.Assert(err, IsNil, Commentf("check: %s", check))
+		c.Assert(data, DeepEquals, expected, Commentf("check: %s", check))
+	}
+
+	// the streams can be concatenated with the paddings between them.
+	var buf bytes.Buffer
+	buf.Write(xzTestFile(c, "crc64"))
+	buf.Write(make([]byte, 8))
+	buf.Write(xzTestFile(c, "sha256"))
+	data, err := decompressXZ(buf.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, append(expected, expected...))
+
+	// seeking backwards decodes the file from the start.
+	r, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(xzTestFile(c, "crc32"))}, CompressionXZ)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	pos, err := r.Seek(606, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(606))
+	data, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, expected[606:])
+	c.Assert(r.Close(), IsNil)
+}
+
+func (s *testCompressReaderSuite) TestCorruptedXZ(c *C) {
+	_, err := decompressXZ([]byte("1,2,3\n"))
+	c.Assert(err, NotNil)
+
+	// the check of the block.
+	data := xzTestFile(c, "crc64")
+	data[len(data)-30] ^= 0x01
+	_, err = decompressXZ(data)
+	c.Assert(err, ErrorMatches, "xz: checksum error for block")
+
+	// the compressed data.
+	data = xzTestFile(c, "none")
+	data[40] ^= 0x01
+	_, err = decompressXZ(data)
+	c.Assert(err, NotNil)
+
+	data = xzTestFile(c, "crc32")
+	_, err = decompressXZ(data[:len(data)-20])
+	c.Assert(err, NotNil)
+
+	// the padding between the streams must be multiple of 4 bytes.
+	data = append(xzTestFile(c, "crc32"), 0, 0)
+	_, err = decompressXZ(data)
+	c.Assert(err, NotNil)
+}
+
+func (s *testCompressReaderSuite) TestXZConcurrency(c *C) {
+	SetXZConcurrency(1)
+	defer SetXZConcurrency(4)
+
+	r1, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(xzTestFile(c, "crc64"))}, CompressionXZ)
+	c.Assert(err, IsNil)
+
+	opened := make(chan ReadSeekCloser)
+	go func() {
+		r2, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(xzTestFile(c, "crc64"))}, CompressionXZ)
+		c.Check(err, IsNil)
+		opened <- r2
+	}()
+	select {
+	case <-opened:
+		c.Fatal("the second xz file is decoded before the first one is closed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Assert(r1.Close(), IsNil)
+	select {
+	case r2 := <-opened:
+		c.Assert(r2.Close(), IsNil)
+	case <-time.After(5 * time.Second):
+		c.Fatal("the second xz file is not decoded after the first one is closed")
+	}
+}
+
+func (s *testCompressReaderSuite) TestDetectCompression(c *C) {
+	data := []byte(strings.Repeat("1,2,3\n", 1000))
+	for _, compression := range []Compression{CompressionGZ, CompressionZStd, CompressionLZ4, CompressionSnappy} {
+		c.Assert(DetectCompression(compress(c, compression, data)), Equals, compression)
+	}
+	c.Assert(DetectCompression([]byte("\xfd7zXZ\x00\x00\x04")), Equals, CompressionXZ)
+	c.Assert(DetectCompression([]byte("BZh91AY&SY")), Equals, CompressionBZ2)
+	c.Assert(DetectCompression(data), Equals, CompressionNone)
+	c.Assert(DetectCompression([]byte{0x1f}), Equals, CompressionNone)
+	c.Assert(DetectCompression(nil), Equals, CompressionNone)
+}
+
+func (s *testCompressReaderSuite) TestCompressedFileRegion(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	data := []byte(strings.Repeat("1,2,3\n", 1000))
+	compressed := compress(c, CompressionZStd, data)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.csv.zst"), compressed, 0644), IsNil)
+	dataFile := FileInfo{
+		FileMeta: SourceFileMeta{Path: "db.t.csv.zst", Type: SourceTypeCSV, Compression: CompressionZStd},
+		Size:     int64(len(compressed)),
+	}
+
+	// the whole file is sampled, so the size is exact.
+	size, err := EstimateRealSize(context.Background(), store, dataFile)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(len(data)))
+
+	cfg := &config.Config{
+		Mydumper: config.MydumperRuntime{
+			CSV:              config.CSVConfig{Separator: ",", Delimiter: `"`},
+			StrictFormat:     true,
+			MaxRegionSize:    1000,
+			BatchSize:        100 * 1024 * 1024 * 1024,
+			BatchImportRatio: 0.75,
+		},
+	}
+	meta := &MDTableMeta{DB: "db", Name: "t", DataFiles: []FileInfo{dataFile}}
+	regions, err := MakeTableRegions(context.Background(), meta, 3, cfg, worker.NewPool(context.Background(), 1, "io"), store)
+	c.Assert(err, IsNil)
+	c.Assert(regions, HasLen, 1)
+	c.Assert(regions[0].Chunk.EndOffset, Equals, int64(TableFileSizeINF))
+	c.Assert(regions[0].Chunk.RowIDMax, Equals, int64(len(data)*2/3))
+	c.Assert(EstimateChunkCount(cfg, dataFile), Equals, int64(1))
+}
@@ -181,6 +181,10 @@ func (parser *CSVParser) readUntil(findIndexFunc func([]byte) int) ([]byte, byte
 }
 
 func (parser *CSVParser) readRecord(dst []string) ([]string, error) {
+	if cap(parser.recordBuffer) > 2*len(parser.blockBuf) {
+		// release the memory held by the previous long row.
+		parser.recordBuffer = nil
+	}
 	parser.recordBuffer = parser.recordBuffer[:0]
 	parser.fieldIndexes = parser.fieldIndexes[:0]
 
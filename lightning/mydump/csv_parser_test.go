@@ -344,6 +344,29 @@ func (s *testMydumpCSVParserSuite) TestSyntaxError(c *C) {
 	s.runFailingTestCases(c, &cfg, config.ReadBlockSize, []string{`"\`})
 }
 
+func (s *testMydumpCSVParserSuite) TestLongValues(c *C) {
+	cfg := config.CSVConfig{
+		Separator:       ",",
+		Delimiter:       `"`,
+		BackslashEscape: true,
+	}
+
+	long := strings.Repeat(`abc""d\ne,`, 50000)
+	expected := strings.Repeat("abc\"d\ne,", 50000)
+
+	s.runTestCases(c, &cfg, 7, []testCase{
+		{
+			input: "1,\"" + long + "\"\n2,x\n3,\"" + long + "\"\n4,y\n",
+			expected: [][]types.Datum{
+				{types.NewStringDatum("1"), types.NewStringDatum(expected)},
+				{types.NewStringDatum("2"), types.NewStringDatum("x")},
+				{types.NewStringDatum("3"), types.NewStringDatum(expected)},
+				{types.NewStringDatum("4"), types.NewStringDatum("y")},
+			},
+		},
+	})
+}
+
 func (s *testMydumpCSVParserSuite) TestTSV(c *C) {
 	cfg := config.CSVConfig{
 		Separator:       "\t",
@@ -405,6 +428,48 @@ func (s *testMydumpCSVParserSuite) TestTSV(c *C) {
 	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
 }
 
+func (s *testMydumpCSVParserSuite) TestTSVOutfile(c *C) {
+	// the preset of `format = "tsv"`, matching `SELECT ... INTO OUTFILE`.
+	cfg := config.CSVConfig{
+		Format:          config.CSVFormatTSV,
+		Separator:       "\t",
+		Delimiter:       "",
+		BackslashEscape: true,
+		Null:            `\N`,
+	}
+
+	s.runTestCases(c, &cfg, config.ReadBlockSize, []testCase{
+		{
+			input: "1\tfoo\\tbar\\nbaz\t\\\\\t\\N\n",
+			expected: [][]types.Datum{{
+				types.NewStringDatum("1"),
+				types.NewStringDatum("foo\tbar\nbaz"),
+				types.NewStringDatum(`\`),
+				nullDatum,
+			}},
+		},
+		{
+			// OUTFILE escapes the tabs and the newlines in the values by a
+			// backslash before them.
+			input: "2\ta\\\tb\tc\\\nd\t\\0\t\n",
+			expected: [][]types.Datum{{
+				types.NewStringDatum("2"),
+				types.NewStringDatum("a\tb"),
+				types.NewStringDatum("c\nd"),
+				types.NewStringDatum("\x00"),
+				types.NewStringDatum(""),
+			}},
+		},
+		{
+			input: "\\N\t\\\\N\n3\t\n",
+			expected: [][]types.Datum{
+				{nullDatum, types.NewStringDatum(`\N`)},
+				{types.NewStringDatum("3"), types.NewStringDatum("")},
+			},
+		},
+	})
+}
+
 func (s *testMydumpCSVParserSuite) TestCsvWithWhiteSpaceLine(c *C) {
 	cfg := config.CSVConfig{
 		Separator: ",",
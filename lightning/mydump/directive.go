@@ -0,0 +1,294 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/simplifiedchinese"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+const (
+	// directivePrefix starts the directive lines at the top of a data file.
+	directivePrefix = "-- lightning:"
+	// maxDirectiveSize limits the directive lines read from a data file.
+	maxDirectiveSize = 64 * 1024
+)
+
+// FileDirectives are the parsing settings declared by a data file itself in
+// the directive lines at its top, e.g.
+//
+//	-- lightning: charset=gbk, null=\N
+//	-- lightning: separator='|', header=true
+//
+// which override the task config for the file. The values containing commas
+// or spaces are quoted by single or double quotes. FileDirectives is not safe
+// for concurrent use.
+type FileDirectives struct {
+	// Length is the size of the directive lines, which are skipped by the
+	// parser.
+	Length int64
+	// Charset is the charset of the strings in the file, which are converted
+	// to utf8mb4 before encoding.
+	Charset string
+
+	separator       *string
+	delimiter       *string
+	null            *string
+	header          *bool
+	notNull         *bool
+	backslashEscape *bool
+
+	decoder *encoding.Decoder
+}
+
+// ReadFileDirectives reads the directive lines at the top of the file, and
+// returns nil if there is none.
+func ReadFileDirectives(ctx context.Context, store storage.ExternalStorage, fileMeta SourceFileMeta) (*FileDirectives, error) {
+	return readFileDirectives(ctx, store, fileMeta, nil)
+}
+
+// LoadFileDirectives returns the parsing settings of the data file, which are
+// the directives of its routing rule overridden by the directive lines at the
+// top of the file if readFile is true. It returns nil if there is neither.
+func LoadFileDirectives(ctx context.Context, store storage.ExternalStorage, fileMeta SourceFileMeta, readFile bool) (*FileDirectives, error) {
+	var d *FileDirectives
+	if len(fileMeta.Directives) > 0 {
+		d = &FileDirectives{}
+		if err := d.parse(fileMeta.Directives); err != nil {
+			return nil, errors.Annotatef(err, "invalid directives of the routing rule of %s", fileMeta.Path)
+		}
+	}
+	if !readFile {
+		return d, nil
+	}
+	return readFileDirectives(ctx, store, fileMeta, d)
+}
+
+// readFileDirectives reads the directive lines at the top of the file into
+// d, which is created on the first line if nil.
+func readFileDirectives(ctx context.Context, store storage.ExternalStorage, fileMeta SourceFileMeta, d *FileDirectives) (*FileDirectives, error) {
+	file, err := store.Open(ctx, fileMeta.Path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	reader := ReadSeekCloser(file)
+	if fileMeta.Compression != CompressionNone {
+		if reader, err = NewDecompressReader(file, fileMeta.Compression); err != nil {
+			file.Close()
+			return nil, errors.Annotatef(err, "open %s", fileMeta.Path)
+		}
+	}
+	defer reader.Close()
+
+	br := bufio.NewReader(io.LimitReader(reader, maxDirectiveSize+1))
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Trace(err)
+		}
+		if !strings.HasPrefix(line, directivePrefix) {
+			break
+		}
+		if d == nil {
+			d = &FileDirectives{}
+		}
+		d.Length += int64(len(line))
+		if d.Length > maxDirectiveSize {
+			return nil, errors.Errorf("the directive lines of %s exceed %d bytes", fileMeta.Path, maxDirectiveSize)
+		}
+		body := strings.TrimSpace(strings.TrimPrefix(line, directivePrefix))
+		if parseErr := d.parse(body); parseErr != nil {
+			return nil, errors.Annotatef(parseErr, "invalid directive in %s", fileMeta.Path)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return d, nil
+}
+
+// splitDirective splits the directive into the `key=value` items separated
+// by commas outside the quotes.
+func splitDirective(body string) ([]string, error) {
+	var (
+		items []string
+		item  strings.Builder
+		quote byte
+	)
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ',':
+			items = append(items, item.String())
+			item.Reset()
+			continue
+		}
+		item.WriteByte(c)
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("unclosed quote in %q", body)
+	}
+	return append(items, item.String()), nil
+}
+
+func unquoteDirectiveValue(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func (d *FileDirectives) parse(body string) error {
+	items, err := splitDirective(body)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if len(strings.TrimSpace(item)) == 0 {
+			continue
+		}
+		eq := strings.IndexByte(item, '=')
+		if eq < 0 {
+			return errors.Errorf("expected key=value but got %q", strings.TrimSpace(item))
+		}
+		key := strings.ToLower(strings.TrimSpace(item[:eq]))
+		value := unquoteDirectiveValue(item[eq+1:])
+		switch key {
+		case "charset":
+			if err := d.setCharset(value); err != nil {
+				return err
+			}
+		case "separator":
+			if len(value) == 0 {
+				return errors.New("separator must not be empty")
+			}
+			d.separator = &value
+		case "delimiter":
+			d.delimiter = &value
+		case "null":
+			d.null = &value
+		case "header", "not-null", "backslash-escape":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return errors.Errorf("%s must be a boolean but got %q", key, value)
+			}
+			switch key {
+			case "header":
+				d.header = &b
+			case "not-null":
+				d.notNull = &b
+			default:
+				d.backslashEscape = &b
+			}
+		default:
+			return errors.Errorf("unknown directive %q", key)
+		}
+	}
+	return nil
+}
+
+func (d *FileDirectives) setCharset(charset string) error {
+	charset = strings.ToLower(charset)
+	var enc encoding.Encoding
+	switch charset {
+	case "utf8", "utf8mb4":
+		charset = "utf8mb4"
+	case "binary":
+	case "gbk":
+		enc = simplifiedchinese.GBK
+	case "gb18030":
+		enc = simplifiedchinese.GB18030
+	case "latin1":
+		// MySQL's latin1 is actually cp1252.
+		enc = charmap.Windows1252
+	default:
+		return errors.Errorf("unsupported charset %q", charset)
+	}
+	d.Charset = charset
+	d.decoder = nil
+	if enc != nil {
+		d.decoder = enc.NewDecoder()
+	}
+	return nil
+}
+
+// CSV returns the CSV config of the file, which is the task config with the
+// settings declared by the file.
+func (d *FileDirectives) CSV(cfg *config.CSVConfig) *config.CSVConfig {
+	if d == nil {
+		return cfg
+	}
+	res := *cfg
+	if d.separator != nil {
+		res.Separator = *d.separator
+	}
+	if d.delimiter != nil {
+		res.Delimiter = *d.delimiter
+	}
+	if d.null != nil {
+		res.Null = *d.null
+	}
+	if d.header != nil {
+		res.Header = *d.header
+	}
+	if d.notNull != nil {
+		res.NotNull = *d.notNull
+	}
+	if d.backslashEscape != nil {
+		res.BackslashEscape = *d.backslashEscape
+	}
+	return &res
+}
+
+// DecodeStrings converts the strings in the row from the charset of the file
+// to utf8mb4.
+func (d *FileDirectives) DecodeStrings(row []types.Datum) error {
+	if d == nil || d.decoder == nil {
+		return nil
+	}
+	for i := range row {
+		if kind := row[i].Kind(); kind != types.KindString && kind != types.KindBytes {
+			continue
+		}
+		decoded, err := d.decoder.Bytes(row[i].GetBytes())
+		if err != nil {
+			return errors.Annotatef(err, "failed to decode field #%d as %s", i+1, d.Charset)
+		}
+		if bytes.ContainsRune(decoded, '\ufffd') {
+			return errors.Errorf("field #%d is not a valid %s string", i+1, d.Charset)
+		}
+		row[i].SetBytes(decoded)
+	}
+	return nil
+}
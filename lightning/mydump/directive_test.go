@@ -0,0 +1,156 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testDirectiveSuite{})
+
+type testDirectiveSuite struct {
+	dir   string
+	store storage.ExternalStorage
+}
+
+func (s *testDirectiveSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	store, err := storage.NewLocalStorage(s.dir)
+	c.Assert(err, IsNil)
+	s.store = store
+}
+
+func (s *testDirectiveSuite) read(c *C, name string, content string) (*FileDirectives, error) {
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644), IsNil)
+	return ReadFileDirectives(context.Background(), s.store, SourceFileMeta{Path: name, Type: SourceTypeCSV})
+}
+
+func (s *testDirectiveSuite) TestReadFileDirectives(c *C) {
+	directives := "-- lightning: charset=GBK, null=\\N\n" +
+		"-- lightning: separator=',', delimiter=\"'\", header=true\n"
+	// "\xd6\xd0\xce\xc4" is the GBK encoding of "中文".
+	d, err := s.read(c, "t.csv", directives+"a|b\n1|\xd6\xd0\xce\xc4\n")
+	c.Assert(err, IsNil)
+	c.Assert(d, NotNil)
+	c.Assert(d.Length, Equals, int64(len(directives)))
+	c.Assert(d.Charset, Equals, "gbk")
+
+	csvCfg := &config.CSVConfig{Separator: "|", Delimiter: `"`, Null: "NULL", BackslashEscape: true}
+	c.Assert(d.CSV(csvCfg), DeepEquals, &config.CSVConfig{
+		Separator:       ",",
+		Delimiter:       "'",
+		Null:            `\N`,
+		Header:          true,
+		BackslashEscape: true,
+	})
+	// the task config is not changed.
+	c.Assert(csvCfg.Separator, Equals, "|")
+
+	row := []types.Datum{types.NewIntDatum(1), types.NewStringDatum("\xd6\xd0\xce\xc4"), nullDatum}
+	c.Assert(d.DecodeStrings(row), IsNil)
+	c.Assert(row[0].GetInt64(), Equals, int64(1))
+	c.Assert(row[1].GetString(), Equals, "中文")
+	c.Assert(row[2].IsNull(), IsTrue)
+
+	row = []types.Datum{types.NewStringDatum("\xff")}
+	c.Assert(d.DecodeStrings(row), ErrorMatches, "field #1 is not a valid gbk string")
+}
+
+func (s *testDirectiveSuite) TestNoDirectives(c *C) {
+	d, err := s.read(c, "t.csv", "-- a comment\n-- lightning: null=\\N\n")
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+
+	d, err = s.read(c, "empty.csv", "")
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+
+	// the methods are usable on the nil directives.
+	csvCfg := &config.CSVConfig{Separator: ","}
+	c.Assert(d.CSV(csvCfg), Equals, csvCfg)
+	c.Assert(d.DecodeStrings([]types.Datum{types.NewStringDatum("\xff")}), IsNil)
+}
+
+func (s *testDirectiveSuite) TestInvalidDirectives(c *C) {
+	cases := []struct {
+		directive string
+		err       string
+	}{
+		{"charset=ebcdic", `invalid directive in t.csv: unsupported charset "ebcdic"`},
+		{"quote=x", `invalid directive in t.csv: unknown directive "quote"`},
+		{"header=maybe", `invalid directive in t.csv: header must be a boolean but got "maybe"`},
+		{"separator=''", `invalid directive in t.csv: separator must not be empty`},
+		{"null", `invalid directive in t.csv: expected key=value but got "null"`},
+		{"separator='|", `invalid directive in t.csv: unclosed quote in .*`},
+	}
+	for _, tc := range cases {
+		_, err := s.read(c, "t.csv", "-- lightning: "+tc.directive+"\n1,2\n")
+		c.Assert(err, ErrorMatches, tc.err, Commentf("directive %s", tc.directive))
+	}
+}
+
+func (s *testDirectiveSuite) TestParseAfterDirectives(c *C) {
+	content := "-- lightning: separator='|', null=\\N, header=true\na|b\n1|\\N\n"
+	d, err := s.read(c, "t.csv", content)
+	c.Assert(err, IsNil)
+
+	reader, err := s.store.Open(context.Background(), "t.csv")
+	c.Assert(err, IsNil)
+	csvCfg := d.CSV(&config.CSVConfig{Separator: ",", Delimiter: `"`})
+	parser := NewCSVParser(csvCfg, reader, 64, worker.NewPool(context.Background(), 1, "io"), csvCfg.Header)
+	defer parser.Close()
+	c.Assert(parser.SetPos(d.Length, 0), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.Columns(), DeepEquals, []string{"a", "b"})
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewStringDatum("1"), nullDatum})
+}
+
+func (s *testDirectiveSuite) TestLoadFileDirectives(c *C) {
+	ctx := context.Background()
+	fileMeta := SourceFileMeta{Path: "t.csv", Type: SourceTypeCSV, Directives: "separator='|', null=\\N"}
+	directives := "-- lightning: separator=';'\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "t.csv"), []byte(directives+"1;\\N\n"), 0644), IsNil)
+
+	// the directives of the routing rule are used without the file.
+	d, err := LoadFileDirectives(ctx, s.store, fileMeta, false)
+	c.Assert(err, IsNil)
+	c.Assert(d.Length, Equals, int64(0))
+	csvCfg := &config.CSVConfig{Separator: ",", Delimiter: `"`, Header: true}
+	c.Assert(d.CSV(csvCfg), DeepEquals, &config.CSVConfig{Separator: "|", Delimiter: `"`, Null: `\N`, Header: true})
+
+	// the directive lines of the file override the routing rule.
+	d, err = LoadFileDirectives(ctx, s.store, fileMeta, true)
+	c.Assert(err, IsNil)
+	c.Assert(d.Length, Equals, int64(len(directives)))
+	c.Assert(d.CSV(csvCfg), DeepEquals, &config.CSVConfig{Separator: ";", Delimiter: `"`, Null: `\N`, Header: true})
+
+	fileMeta.Directives = ""
+	d, err = LoadFileDirectives(ctx, s.store, fileMeta, false)
+	c.Assert(err, IsNil)
+	c.Assert(d, IsNil)
+
+	fileMeta.Directives = "quote=x"
+	_, err = LoadFileDirectives(ctx, s.store, fileMeta, false)
+	c.Assert(err, ErrorMatches, `invalid directives of the routing rule of t.csv: unknown directive "quote"`)
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+)
+
+// DumpMetadataFile is the name of the metadata file written by Mydumper and
+// Dumpling at the root of the dump.
+const DumpMetadataFile = "metadata"
+
+// ConsistencyNone is the consistency mode of the dumps taken without any
+// lock or snapshot, so the tables may be dumped at different points of time.
+const ConsistencyNone = "none"
+
+// BinlogPosition is the position of the binlog when the dump started.
+type BinlogPosition struct {
+	// Host is the master of the slave, empty for the master status.
+	Host string `json:"host,omitempty"`
+	Log  string `json:"log,omitempty"`
+	Pos  string `json:"pos,omitempty"`
+	GTID string `json:"gtid,omitempty"`
+}
+
+// DumpMetadata is the content of the metadata file, like
+//
+//	Started dump at: 2020-11-10 10:40:19
+//	SHOW MASTER STATUS:
+//		Log: mysql-bin.000001
+//		Pos: 1234
+//		GTID:
+//
+//	Finished dump at: 2020-11-10 10:40:20
+//
+// The times are kept as written, which are in the time zone of the dumper.
+type DumpMetadata struct {
+	StartTime  string `json:"start-time,omitempty"`
+	FinishTime string `json:"finish-time,omitempty"`
+	// Consistency is the consistency mode of the dump if recorded, e.g.
+	// "snapshot", "flush", "lock" or "none".
+	Consistency  string          `json:"consistency,omitempty"`
+	MasterStatus *BinlogPosition `json:"master-status,omitempty"`
+	SlaveStatus  *BinlogPosition `json:"slave-status,omitempty"`
+}
+
+// Finished returns whether the dumper recorded the end of the dump, which is
+// missing if the dumper was interrupted.
+func (m *DumpMetadata) Finished() bool {
+	return len(m.FinishTime) > 0
+}
+
+// ParseDumpMetadata parses the metadata file. The unknown lines are ignored.
+func ParseDumpMetadata(data []byte) (*DumpMetadata, error) {
+	meta := &DumpMetadata{}
+	var section *BinlogPosition
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 {
+			section = nil
+			continue
+		}
+		// the fields of the status are indented under the section.
+		if section != nil && (line[0] == ' ' || line[0] == '\t') {
+			if i := strings.IndexByte(trimmed, ':'); i >= 0 {
+				value := strings.TrimSpace(trimmed[i+1:])
+				switch trimmed[:i] {
+				case "Host":
+					section.Host = value
+				case "Log":
+					section.Log = value
+				case "Pos":
+					section.Pos = value
+				case "GTID":
+					section.GTID = value
+				}
+			}
+			continue
+		}
+
+		section = nil
+		switch {
+		case strings.HasPrefix(trimmed, "Started dump at:"):
+			meta.StartTime = strings.TrimSpace(strings.TrimPrefix(trimmed, "Started dump at:"))
+		case strings.HasPrefix(trimmed, "Finished dump at:"):
+			meta.FinishTime = strings.TrimSpace(strings.TrimPrefix(trimmed, "Finished dump at:"))
+		case strings.HasPrefix(trimmed, "Consistency:"):
+			meta.Consistency = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(trimmed, "Consistency:")))
+		case trimmed == "SHOW MASTER STATUS:":
+			meta.MasterStatus = &BinlogPosition{}
+			section = meta.MasterStatus
+		case trimmed == "SHOW SLAVE STATUS:":
+			meta.SlaveStatus = &BinlogPosition{}
+			section = meta.SlaveStatus
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+	return meta, nil
+}
+
+// ReadDumpMetadata reads the metadata file of the dump, or returns nil if the
+// file does not exist, e.g. the data source is not dumped by Mydumper or
+// Dumpling.
+func ReadDumpMetadata(ctx context.Context, store storage.ExternalStorage) (*DumpMetadata, error) {
+	exists, err := store.FileExists(ctx, DumpMetadataFile)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !exists {
+		return nil, nil
+	}
+	data, err := store.Read(ctx, DumpMetadataFile)
+	if err != nil {
+		return nil, errors.Annotate(err, "read the dump metadata file")
+	}
+	return ParseDumpMetadata(data)
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testDumpMetadataSuite{})
+
+type testDumpMetadataSuite struct{}
+
+func (s *testDumpMetadataSuite) TestParseMydumper(c *C) {
+	meta, err := ParseDumpMetadata([]byte(`Started dump at: 2020-11-10 10:40:19
+SHOW MASTER STATUS:
+	Log: mysql-bin.000003
+	Pos: 194
+	GTID:3ccc8e5e-1ab6-11eb-a5c5-0242ac110002:1-25
+
+SHOW SLAVE STATUS:
+	Host: 10.0.0.1
+	Log: mysql-bin.000012
+	Pos: 1024
+	GTID:
+
+Finished dump at: 2020-11-10 10:42:07
+`))
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, &DumpMetadata{
+		StartTime:  "2020-11-10 10:40:19",
+		FinishTime: "2020-11-10 10:42:07",
+		MasterStatus: &BinlogPosition{
+			Log:  "mysql-bin.000003",
+			Pos:  "194",
+			GTID: "3ccc8e5e-1ab6-11eb-a5c5-0242ac110002:1-25",
+		},
+		SlaveStatus: &BinlogPosition{Host: "10.0.0.1", Log: "mysql-bin.000012", Pos: "1024"},
+	})
+	c.Assert(meta.Finished(), IsTrue)
+}
+
+func (s *testDumpMetadataSuite) TestParseUnfinished(c *C) {
+	meta, err := ParseDumpMetadata([]byte("Started dump at: 2020-11-10 10:40:19\nConsistency: None\nSHOW MASTER STATUS:\n\tLog: tidb-binlog\n"))
+	c.Assert(err, IsNil)
+	c.Assert(meta.Consistency, Equals, ConsistencyNone)
+	c.Assert(meta.MasterStatus, DeepEquals, &BinlogPosition{Log: "tidb-binlog"})
+	c.Assert(meta.Finished(), IsFalse)
+}
+
+func (s *testDumpMetadataSuite) TestRead(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	meta, err := ReadDumpMetadata(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(meta, IsNil)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, DumpMetadataFile), []byte("Finished dump at: 2020-11-10 10:42:07\n"), 0644), IsNil)
+	meta, err = ReadDumpMetadata(ctx, store)
+	c.Assert(err, IsNil)
+	c.Assert(meta, DeepEquals, &DumpMetadata{FinishTime: "2020-11-10 10:42:07"})
+}
@@ -0,0 +1,222 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// FaultySchemePrefix is the prefix of the data source URL scheme wrapping the
+// storage with fault injection, e.g. `faulty+s3://bucket/path`.
+const FaultySchemePrefix = "faulty+"
+
+const (
+	faultErrorRate    = "fault-error-rate"
+	faultLatency      = "fault-latency"
+	faultTruncateRate = "fault-truncate-rate"
+	faultSeed         = "fault-seed"
+)
+
+// ErrInjectedFault is the error returned by the faulty storage.
+var ErrInjectedFault = errors.New("injected storage fault")
+
+// FaultOptions configures the faults injected into the storage.
+type FaultOptions struct {
+	// ErrorRate is the probability of every operation, including every read
+	// of an opened file, to fail with ErrInjectedFault.
+	ErrorRate float64
+	// Latency is the delay added before every operation.
+	Latency time.Duration
+	// TruncateRate is the probability of an opened file to end with
+	// io.ErrUnexpectedEOF at a random offset.
+	TruncateRate float64
+	// Seed is the seed of the random faults, to reproduce a run.
+	Seed int64
+}
+
+// faultyStorage wraps a storage to inject the faults, for testing the retry
+// logic and validating the configuration against transient failures. The
+// methods not overridden are passed to the wrapped storage as is.
+type faultyStorage struct {
+	storage.ExternalStorage
+	opts FaultOptions
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewFaultyStorage wraps the storage to inject the faults.
+func NewFaultyStorage(inner storage.ExternalStorage, opts FaultOptions) storage.ExternalStorage {
+	return &faultyStorage{
+		ExternalStorage: inner,
+		opts:            opts,
+		rnd:             rand.New(rand.NewSource(opts.Seed)),
+	}
+}
+
+func (s *faultyStorage) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Float64() < rate
+}
+
+func (s *faultyStorage) int63n(n int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rnd.Int63n(n)
+}
+
+// inject sleeps for the latency, and returns the injected error if any.
+func (s *faultyStorage) inject(ctx context.Context, op string, name string) error {
+	if s.opts.Latency > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.opts.Latency):
+		}
+	}
+	if s.chance(s.opts.ErrorRate) {
+		log.L().Debug("inject storage fault", zap.String("op", op), zap.String("path", name))
+		return errors.Annotatef(ErrInjectedFault, "%s %s", op, name)
+	}
+	return nil
+}
+
+func (s *faultyStorage) Write(ctx context.Context, name string, data []byte) error {
+	if err := s.inject(ctx, "write", name); err != nil {
+		return err
+	}
+	return s.ExternalStorage.Write(ctx, name, data)
+}
+
+func (s *faultyStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	if err := s.inject(ctx, "read", name); err != nil {
+		return nil, err
+	}
+	data, err := s.ExternalStorage.Read(ctx, name)
+	if err == nil && len(data) > 0 && s.chance(s.opts.TruncateRate) {
+		return data[:s.int63n(int64(len(data)))], errors.Annotatef(io.ErrUnexpectedEOF, "read %s", name)
+	}
+	return data, err
+}
+
+func (s *faultyStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	if err := s.inject(ctx, "stat", name); err != nil {
+		return false, err
+	}
+	return s.ExternalStorage.FileExists(ctx, name)
+}
+
+func (s *faultyStorage) Open(ctx context.Context, path string) (storage.ReadSeekCloser, error) {
+	if err := s.inject(ctx, "open", path); err != nil {
+		return nil, err
+	}
+	reader, err := s.ExternalStorage.Open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	truncateAt := int64(-1)
+	if s.chance(s.opts.TruncateRate) {
+		// the truncation is within the first MiB, so it is likely reached.
+		truncateAt = s.int63n(1 << 20)
+	}
+	return &faultyReader{ReadSeekCloser: reader, storage: s, path: path, truncateAt: truncateAt}, nil
+}
+
+func (s *faultyStorage) WalkDir(ctx context.Context, opt *storage.WalkOption, fn func(path string, size int64) error) error {
+	if err := s.inject(ctx, "walk", ""); err != nil {
+		return err
+	}
+	return s.ExternalStorage.WalkDir(ctx, opt, fn)
+}
+
+type faultyReader struct {
+	storage.ReadSeekCloser
+	storage    *faultyStorage
+	path       string
+	offset     int64
+	truncateAt int64
+}
+
+func (r *faultyReader) Read(p []byte) (int, error) {
+	if err := r.storage.inject(context.Background(), "read", r.path); err != nil {
+		return 0, err
+	}
+	if r.truncateAt >= 0 {
+		if r.offset >= r.truncateAt {
+			return 0, errors.Annotatef(io.ErrUnexpectedEOF, "read %s", r.path)
+		}
+		if remain := r.truncateAt - r.offset; int64(len(p)) > remain {
+			p = p[:remain]
+		}
+	}
+	n, err := r.ReadSeekCloser.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *faultyReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := r.ReadSeekCloser.Seek(offset, whence)
+	if err == nil {
+		r.offset = pos
+	}
+	return pos, err
+}
+
+// parseFaultOptions removes the fault options from the query of the URL.
+func parseFaultOptions(u *url.URL) (FaultOptions, error) {
+	opts := FaultOptions{Seed: time.Now().UnixNano()}
+	query := u.Query()
+	var err error
+	for name, values := range query {
+		if !strings.HasPrefix(name, "fault-") || len(values) == 0 {
+			continue
+		}
+		value := values[0]
+		switch name {
+		case faultErrorRate:
+			opts.ErrorRate, err = strconv.ParseFloat(value, 64)
+		case faultTruncateRate:
+			opts.TruncateRate, err = strconv.ParseFloat(value, 64)
+		case faultLatency:
+			opts.Latency, err = time.ParseDuration(value)
+		case faultSeed:
+			opts.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			err = errors.New("unknown option")
+		}
+		if err != nil {
+			return opts, errors.Annotatef(err, "invalid fault injection option %s=%s", name, value)
+		}
+		query.Del(name)
+	}
+	u.RawQuery = query.Encode()
+	return opts, nil
+}
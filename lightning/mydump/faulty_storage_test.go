@@ -0,0 +1,121 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testFaultyStorageSuite{})
+
+type testFaultyStorageSuite struct{}
+
+func (s *testFaultyStorageSuite) prepare(c *C) (string, storage.ExternalStorage) {
+	dir := c.MkDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "db.t.sql"), bytes.Repeat([]byte("x"), 4096), 0644)
+	c.Assert(err, IsNil)
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	return dir, store
+}
+
+func (s *testFaultyStorageSuite) TestNoFault(c *C) {
+	_, inner := s.prepare(c)
+	store := mydump.NewFaultyStorage(inner, mydump.FaultOptions{})
+	ctx := context.Background()
+
+	data, err := store.Read(ctx, "db.t.sql")
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 4096)
+
+	reader, err := store.Open(ctx, "db.t.sql")
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 4096)
+	c.Assert(reader.Close(), IsNil)
+}
+
+func (s *testFaultyStorageSuite) TestErrors(c *C) {
+	_, inner := s.prepare(c)
+	store := mydump.NewFaultyStorage(inner, mydump.FaultOptions{ErrorRate: 1, Latency: time.Millisecond})
+	ctx := context.Background()
+
+	_, err := store.Read(ctx, "db.t.sql")
+	c.Assert(errors.Cause(err), Equals, mydump.ErrInjectedFault)
+	_, err = store.Open(ctx, "db.t.sql")
+	c.Assert(errors.Cause(err), Equals, mydump.ErrInjectedFault)
+	err = store.WalkDir(ctx, &storage.WalkOption{}, func(string, int64) error { return nil })
+	c.Assert(errors.Cause(err), Equals, mydump.ErrInjectedFault)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = mydump.NewFaultyStorage(inner, mydump.FaultOptions{Latency: time.Hour}).Read(canceled, "db.t.sql")
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *testFaultyStorageSuite) TestTruncate(c *C) {
+	_, inner := s.prepare(c)
+	store := mydump.NewFaultyStorage(inner, mydump.FaultOptions{TruncateRate: 1, Seed: 1})
+	ctx := context.Background()
+
+	data, err := store.Read(ctx, "db.t.sql")
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	c.Assert(len(data), Less, 4096)
+
+	reader, err := store.Open(ctx, "db.t.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	_, err = ioutil.ReadAll(reader)
+	// the file may end before the truncation.
+	if err != nil {
+		c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	}
+}
+
+func (s *testFaultyStorageSuite) TestOpenStorage(c *C) {
+	dir, _ := s.prepare(c)
+	ctx := context.Background()
+
+	store, err := mydump.OpenStorage(ctx, "file://"+dir, nil, nil, nil)
+	c.Assert(err, IsNil)
+	_, err = store.Read(ctx, "db.t.sql")
+	c.Assert(err, IsNil)
+
+	store, err = mydump.OpenStorage(ctx, "faulty+file://"+dir+"?fault-error-rate=1&fault-seed=7", nil, nil, nil)
+	c.Assert(err, IsNil)
+	_, err = store.Read(ctx, "db.t.sql")
+	c.Assert(errors.Cause(err), Equals, mydump.ErrInjectedFault)
+
+	_, err = mydump.OpenStorage(ctx, "faulty+file://"+dir+"?fault-latency=soon", nil, nil, nil)
+	c.Assert(err, ErrorMatches, "invalid fault injection option fault-latency=soon.*")
+	_, err = mydump.OpenStorage(ctx, "faulty+file://"+dir+"?fault-unknown=1", nil, nil, nil)
+	c.Assert(err, ErrorMatches, "invalid fault injection option fault-unknown=1: unknown option")
+	_, err = mydump.OpenStorage(ctx, "faulty+file://"+dir, nil, make([]byte, 32), nil)
+	c.Assert(err, ErrorMatches, "the customer-supplied encryption key is only supported by the data source in s3 or gcs")
+	_, err = mydump.OpenStorage(ctx, "faulty+file://"+dir, nil, nil, &config.S3Config{RequesterPays: true})
+	c.Assert(err, ErrorMatches, "the `mydumper.s3` options are only supported by the data source in s3")
+}
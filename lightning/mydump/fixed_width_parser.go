@@ -0,0 +1,141 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// FixedWidthParser reads the fixed-width text files, e.g. the exports of the
+// mainframes, whose fields are at the byte offsets given by the config. The
+// records are either separated by newlines, or of `record-length` bytes
+// without any separator.
+type FixedWidthParser struct {
+	blockParser
+	cfg *config.FixedWidthConfig
+}
+
+// NewFixedWidthParser creates a parser of the fixed-width file. The columns
+// of the rows are in the order of `mydumper.fixed-width.columns`.
+func NewFixedWidthParser(
+	cfg *config.FixedWidthConfig,
+	reader ReadSeekCloser,
+	blockBufSize int64,
+	ioWorkers *worker.Pool,
+) *FixedWidthParser {
+	parser := &FixedWidthParser{
+		blockParser: makeBlockParser(reader, blockBufSize, ioWorkers),
+		cfg:         cfg,
+	}
+	parser.columns = make([]string, 0, len(cfg.Columns))
+	for _, col := range cfg.Columns {
+		parser.columns = append(parser.columns, strings.ToLower(col.Name))
+	}
+	return parser
+}
+
+// readRecord reads the next record without the line terminator. The result is
+// only valid until the next read.
+func (parser *FixedWidthParser) readRecord() ([]byte, error) {
+	if parser.cfg.RecordLength > 0 {
+		return parser.readFixedLength(parser.cfg.RecordLength)
+	}
+
+	searched := 0
+	for {
+		if index := bytes.IndexByte(parser.buf[searched:], '\n'); index >= 0 {
+			index += searched
+			record := parser.buf[:index]
+			parser.buf = parser.buf[index+1:]
+			parser.pos += int64(index + 1)
+			return bytes.TrimSuffix(record, []byte{'\r'}), nil
+		}
+		searched = len(parser.buf)
+		if parser.isLastChunk {
+			return parser.readFixedLength(len(parser.buf))
+		}
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readFixedLength reads the record of the length, or the rest of the file if
+// it is shorter.
+func (parser *FixedWidthParser) readFixedLength(length int) ([]byte, error) {
+	for len(parser.buf) < length && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) == 0 {
+		return nil, io.EOF
+	}
+	if length > len(parser.buf) {
+		length = len(parser.buf)
+	}
+	record := parser.buf[:length]
+	parser.buf = parser.buf[length:]
+	parser.pos += int64(length)
+	return record, nil
+}
+
+// ReadRow reads a row from the datafile, skipping the empty lines. The fields
+// beyond the end of a short record are empty.
+func (parser *FixedWidthParser) ReadRow() error {
+	var record []byte
+	for len(record) == 0 {
+		var err error
+		if record, err = parser.readRecord(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	row := &parser.lastRow
+	row.RowID++
+	row.Row = parser.acquireDatumSlice()
+	if cap(row.Row) >= len(parser.cfg.Columns) {
+		row.Row = row.Row[:len(parser.cfg.Columns)]
+	} else {
+		row.Row = make([]types.Datum, len(parser.cfg.Columns))
+	}
+	for i, col := range parser.cfg.Columns {
+		var field []byte
+		if col.Offset < len(record) {
+			end := col.Offset + col.Width
+			if end > len(record) {
+				end = len(record)
+			}
+			field = record[col.Offset:end]
+		}
+		if parser.cfg.Trim {
+			field = bytes.Trim(field, " ")
+		}
+		value := string(field)
+		if !parser.cfg.NotNull && value == parser.cfg.Null {
+			row.Row[i].SetNull()
+		} else {
+			row.Row[i].SetString(value, "utf8mb4_bin")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package mydump_test
+
+import (
+	"context"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testMydumpFixedWidthParserSuite{})
+
+type testMydumpFixedWidthParserSuite struct {
+	ioWorkers *worker.Pool
+}
+
+func (s *testMydumpFixedWidthParserSuite) SetUpSuite(c *C) {
+	s.ioWorkers = worker.NewPool(context.Background(), 5, "test_fixed_width")
+}
+
+func newFixedWidthConfig(recordLength int) *config.FixedWidthConfig {
+	return &config.FixedWidthConfig{
+		RecordLength: recordLength,
+		Trim:         true,
+		Columns: []*config.FixedWidthColumn{
+			{Name: "ID", Offset: 0, Width: 4},
+			{Name: "name", Offset: 4, Width: 6},
+			{Name: "code", Offset: 10, Width: 2},
+		},
+	}
+}
+
+func (s *testMydumpFixedWidthParserSuite) TestReadLines(c *C) {
+	input := "0001alice AB\r\n\n0002 bob  CD\n0003carol\n0004"
+
+	// the small block size makes the records span blocks.
+	parser := mydump.NewFixedWidthParser(newFixedWidthConfig(0), mydump.NewStringReader(input), 4, s.ioWorkers)
+	c.Assert(parser.Columns(), DeepEquals, []string{"id", "name", "code"})
+
+	expected := [][]types.Datum{
+		{jsonString("0001"), jsonString("alice"), jsonString("AB")},
+		{jsonString("0002"), jsonString("bob"), jsonString("CD")},
+		{jsonString("0003"), jsonString("carol"), nullDatum},
+		{jsonString("0004"), nullDatum, nullDatum},
+	}
+	positions := []int{14, 28, 38, 42}
+	for i, row := range expected {
+		c.Assert(parser.ReadRow(), IsNil)
+		c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: int64(i) + 1, Row: row}, Commentf("row = %d", i+1))
+		c.Assert(parser, posEq, positions[i], i+1)
+	}
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpFixedWidthParserSuite) TestReadFixedLength(c *C) {
+	cfg := newFixedWidthConfig(12)
+	cfg.Trim = false
+	cfg.Null = "  "
+	input := "0001alice AB0002bob     0003"
+
+	parser := mydump.NewFixedWidthParser(cfg, mydump.NewStringReader(input), 5, s.ioWorkers)
+	expected := [][]types.Datum{
+		{jsonString("0001"), jsonString("alice "), jsonString("AB")},
+		{jsonString("0002"), jsonString("bob   "), nullDatum},
+		{jsonString("0003"), jsonString(""), jsonString("")},
+	}
+	positions := []int{12, 24, 28}
+	for i, row := range expected {
+		c.Assert(parser.ReadRow(), IsNil)
+		c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: int64(i) + 1, Row: row}, Commentf("row = %d", i+1))
+		c.Assert(parser, posEq, positions[i], i+1)
+	}
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpFixedWidthParserSuite) TestNotNull(c *C) {
+	cfg := newFixedWidthConfig(0)
+	cfg.NotNull = true
+	parser := mydump.NewFixedWidthParser(cfg, mydump.NewStringReader("0001"), 1024, s.ioWorkers)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{jsonString("0001"), jsonString(""), jsonString("")})
+}
+
+func (s *testMydumpFixedWidthParserSuite) TestSetPos(c *C) {
+	parser := mydump.NewFixedWidthParser(newFixedWidthConfig(12), mydump.NewStringReader("0001alice AB0002bob   CD"), 1024, s.ioWorkers)
+	c.Assert(parser.SetPos(12, 7), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 8, Row: []types.Datum{jsonString("0002"), jsonString("bob"), jsonString("CD")}})
+	c.Assert(parser, posEq, 24, 8)
+}
@@ -0,0 +1,231 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+const (
+	gcsEndpoint        = "endpoint"
+	gcsCredentialsFile = "credentials-file"
+)
+
+// isGCSURL returns whether the data source is in Google Cloud Storage, i.e.
+// `gcs://bucket/path` or `gs://bucket/path`.
+func isGCSURL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "gcs://") || strings.HasPrefix(sourceDir, "gs://")
+}
+
+// GCSOptions locates the bucket and the credentials of the data source in
+// Google Cloud Storage, in the same parameters as BR.
+type GCSOptions struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	// CredentialsFile is the JSON key file of the service account. The
+	// application default credentials are used without it.
+	CredentialsFile string
+	// CustomerKey is the AES-256 customer-supplied encryption key (CSEK) of
+	// the objects, which is sent with every request reading or writing them.
+	CustomerKey []byte
+}
+
+// ParseGCSURL parses the options from the data source URL, e.g.
+//
+//	gcs://bucket/path?credentials-file=/etc/lightning/gcs.json
+func ParseGCSURL(sourceDir string, customerKey []byte) (*GCSOptions, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("missing the bucket of the gcs url")
+	}
+	query := u.Query()
+	return &GCSOptions{
+		Bucket:          u.Host,
+		Prefix:          strings.Trim(u.Path, "/"),
+		Endpoint:        query.Get(gcsEndpoint),
+		CredentialsFile: query.Get(gcsCredentialsFile),
+		CustomerKey:     customerKey,
+	}, nil
+}
+
+// gcsStorage reads the data source from a bucket of GCS encrypted with the
+// customer-supplied key, which BR does not support. The methods of
+// storage.ExternalStorage not overridden, which are not used on the data
+// source, are not supported.
+type gcsStorage struct {
+	storage.ExternalStorage
+	bucket      *gcs.BucketHandle
+	prefix      string
+	customerKey []byte
+}
+
+// NewGCSStorage opens the bucket of the options.
+func NewGCSStorage(ctx context.Context, opts *GCSOptions) (storage.ExternalStorage, error) {
+	var clientOpts []option.ClientOption
+	if len(opts.Endpoint) > 0 {
+		clientOpts = append(clientOpts, option.WithEndpoint(opts.Endpoint))
+	}
+	if len(opts.CredentialsFile) > 0 {
+		clientOpts = append(clientOpts, option.WithCredentialsFile(opts.CredentialsFile))
+	}
+	client, err := gcs.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, errors.Annotate(err, "create the gcs client")
+	}
+
+	prefix := opts.Prefix
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	return &gcsStorage{bucket: client.Bucket(opts.Bucket), prefix: prefix, customerKey: opts.CustomerKey}, nil
+}
+
+func (s *gcsStorage) object(name string) *gcs.ObjectHandle {
+	object := s.bucket.Object(s.prefix + name)
+	if len(s.customerKey) > 0 {
+		object = object.Key(s.customerKey)
+	}
+	return object
+}
+
+func (s *gcsStorage) Write(ctx context.Context, name string, data []byte) error {
+	writer := s.object(name).NewWriter(ctx)
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		return errors.Annotatef(err, "write %s", name)
+	}
+	return errors.Annotatef(writer.Close(), "write %s", name)
+}
+
+func (s *gcsStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *gcsStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.object(name).Attrs(ctx)
+	switch err {
+	case nil:
+		return true, nil
+	case gcs.ErrObjectNotExist:
+		return false, nil
+	default:
+		return false, errors.Annotatef(err, "stat %s", name)
+	}
+}
+
+func (s *gcsStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	attrs, err := s.object(name).Attrs(ctx)
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	return &gcsReader{ctx: ctx, storage: s, name: name, size: attrs.Size}, nil
+}
+
+// WalkDir lists all objects under the prefix. The objects are listed in the
+// lexicographical order of their names.
+func (s *gcsStorage) WalkDir(ctx context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	it := s.bucket.Objects(ctx, &gcs.Query{Prefix: s.prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return errors.Annotate(err, "list gcs objects")
+		}
+		// skip the placeholders of the directories.
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+		if err := fn(strings.TrimPrefix(attrs.Name, s.prefix), attrs.Size); err != nil {
+			return err
+		}
+	}
+}
+
+// gcsReader reads an object from the offset with a single ranged GET, which
+// restarts after seeking.
+type gcsReader struct {
+	ctx     context.Context
+	storage *gcsStorage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *gcsReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.object(r.name).NewRangeReader(r.ctx, r.offset, -1)
+		if err != nil {
+			return 0, errors.Annotatef(err, "read %s", r.name)
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *gcsReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.Errorf("invalid seek offset %d of %s", offset, r.name)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *gcsReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
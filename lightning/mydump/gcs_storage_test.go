@@ -0,0 +1,25 @@
+package mydump
+
+import (
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testGCSSuite{})
+
+type testGCSSuite struct{}
+
+func (s *testGCSSuite) TestParseURL(c *C) {
+	opts, err := ParseGCSURL("gs://dumps/2020/db/?credentials-file=/etc/gcs.json&endpoint=https://storage.example.com", []byte("key"))
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &GCSOptions{
+		Bucket:          "dumps",
+		Prefix:          "2020/db",
+		Endpoint:        "https://storage.example.com",
+		CredentialsFile: "/etc/gcs.json",
+		CustomerKey:     []byte("key"),
+	})
+	c.Assert(isGCSURL("gcs://dumps/db"), IsTrue)
+
+	_, err = ParseGCSURL("gcs:///db", nil)
+	c.Assert(err, ErrorMatches, "missing the bucket of the gcs url")
+}
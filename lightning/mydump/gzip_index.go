@@ -0,0 +1,118 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+)
+
+// GzipIndexSuffix is appended to the path of a gzip file to find its index.
+const GzipIndexSuffix = ".gzi"
+
+// gzipIndexEntry is a point where the decompression can restart, which is the
+// beginning of a gzip member.
+type gzipIndexEntry struct {
+	compressedOffset   int64
+	uncompressedOffset int64
+}
+
+// GzipIndex is the index of a BGZF file in the format written by `bgzip -i`:
+// the number of entries, followed by the compressed and the uncompressed
+// offsets of each member except the first, all as little-endian uint64.
+type GzipIndex []gzipIndexEntry
+
+// ParseGzipIndex parses the content of a .gzi file.
+func ParseGzipIndex(data []byte) (GzipIndex, error) {
+	if len(data) < 8 {
+		return nil, errors.New("gzip index is too short")
+	}
+	count := binary.LittleEndian.Uint64(data)
+	data = data[8:]
+	if count > uint64(len(data)/16) || uint64(len(data)) != count*16 {
+		return nil, errors.Errorf("gzip index has %d bytes for %d entries", len(data), count)
+	}
+
+	index := make(GzipIndex, 0, count)
+	var prev gzipIndexEntry
+	for ; len(data) > 0; data = data[16:] {
+		entry := gzipIndexEntry{
+			compressedOffset:   int64(binary.LittleEndian.Uint64(data)),
+			uncompressedOffset: int64(binary.LittleEndian.Uint64(data[8:])),
+		}
+		if entry.compressedOffset <= prev.compressedOffset || entry.uncompressedOffset < prev.uncompressedOffset {
+			return nil, errors.Errorf("gzip index entry %d is out of order", len(index))
+		}
+		index = append(index, entry)
+		prev = entry
+	}
+	return index, nil
+}
+
+// ReadGzipIndex reads the index next to the gzip file, and returns nil if
+// there is none.
+func ReadGzipIndex(ctx context.Context, store storage.ExternalStorage, path string) (GzipIndex, error) {
+	exists, err := store.FileExists(ctx, path+GzipIndexSuffix)
+	if err != nil || !exists {
+		return nil, errors.Trace(err)
+	}
+	data, err := store.Read(ctx, path+GzipIndexSuffix)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	index, err := ParseGzipIndex(data)
+	return index, errors.Annotatef(err, "read %s", path+GzipIndexSuffix)
+}
+
+// lookup returns the last entry at or before the uncompressed offset.
+func (index GzipIndex) lookup(offset int64) gzipIndexEntry {
+	i := sort.Search(len(index), func(i int) bool {
+		return index[i].uncompressedOffset > offset
+	})
+	if i == 0 {
+		return gzipIndexEntry{}
+	}
+	return index[i-1]
+}
+
+// gzipRealSize returns the exact decompressed size of an indexed gzip file,
+// which only decompresses the members after the last entry.
+func gzipRealSize(ctx context.Context, store storage.ExternalStorage, path string, index GzipIndex) (int64, error) {
+	file, err := store.Open(ctx, path)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	reader, err := NewIndexedDecompressReader(file, index)
+	if err != nil {
+		file.Close()
+		return 0, errors.Annotatef(err, "open %s", path)
+	}
+	defer reader.Close()
+
+	last := index.lookup(TableFileSizeINF)
+	if _, err := reader.Seek(last.uncompressedOffset, io.SeekStart); err != nil {
+		return 0, errors.Annotatef(err, "decompress %s", path)
+	}
+	n, err := io.Copy(ioutil.Discard, reader)
+	if err != nil {
+		return 0, errors.Annotatef(err, "decompress %s", path)
+	}
+	return last.uncompressedOffset + n, nil
+}
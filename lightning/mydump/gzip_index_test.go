@@ -0,0 +1,157 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testGzipIndexSuite{})
+
+type testGzipIndexSuite struct{}
+
+// indexBGZF builds the .gzi index of the BGZF data of blockSize bytes per
+// member, as written by `bgzip -i`.
+func indexBGZF(compressed []byte, blockSize int, dataSize int) []byte {
+	var entries [][2]uint64
+	compressedOffset, uncompressedOffset := 0, 0
+	for compressedOffset < len(compressed) {
+		compressedOffset += int(binary.LittleEndian.Uint16(compressed[compressedOffset+16:])) + 1
+		uncompressedOffset += blockSize
+		if compressedOffset < len(compressed) && uncompressedOffset < dataSize {
+			entries = append(entries, [2]uint64{uint64(compressedOffset), uint64(uncompressedOffset)})
+		}
+	}
+	index := make([]byte, 8, 8+16*len(entries))
+	binary.LittleEndian.PutUint64(index, uint64(len(entries)))
+	for _, entry := range entries {
+		index = append(index, make([]byte, 16)...)
+		binary.LittleEndian.PutUint64(index[len(index)-16:], entry[0])
+		binary.LittleEndian.PutUint64(index[len(index)-8:], entry[1])
+	}
+	return index
+}
+
+func (s *testGzipIndexSuite) TestParseGzipIndex(c *C) {
+	data := gzipTestData()
+	compressed := compressBGZF(c, data, 30000)
+	index, err := ParseGzipIndex(indexBGZF(compressed, 30000, len(data)))
+	c.Assert(err, IsNil)
+	c.Assert(index, HasLen, (len(data)-1)/30000)
+
+	_, err = ParseGzipIndex([]byte{1, 0, 0})
+	c.Assert(err, ErrorMatches, "gzip index is too short")
+	_, err = ParseGzipIndex([]byte{2, 0, 0, 0, 0, 0, 0, 0, 1})
+	c.Assert(err, ErrorMatches, "gzip index has 1 bytes for 2 entries")
+	unordered := make([]byte, 8+32)
+	unordered[0] = 2
+	unordered[8] = 100
+	unordered[24] = 50
+	_, err = ParseGzipIndex(unordered)
+	c.Assert(err, ErrorMatches, "gzip index entry 1 is out of order")
+}
+
+func (s *testGzipIndexSuite) TestIndexedSeek(c *C) {
+	data := gzipTestData()
+	compressed := compressBGZF(c, data, 30000)
+	index, err := ParseGzipIndex(indexBGZF(compressed, 30000, len(data)))
+	c.Assert(err, IsNil)
+
+	reader, err := NewIndexedDecompressReader(bytesReadSeekCloser{bytes.NewReader(compressed)}, index)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+
+	for _, offset := range []int64{123456, 30000, 0, int64(len(data)) - 10} {
+		pos, err := reader.Seek(offset, io.SeekStart)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, offset)
+		buf := make([]byte, 10)
+		_, err = io.ReadFull(reader, buf)
+		c.Assert(err, IsNil)
+		c.Assert(buf, DeepEquals, data[offset:offset+10])
+	}
+}
+
+func (s *testGzipIndexSuite) TestSplitIndexedFile(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	data := gzipTestData()
+	compressed := compressBGZF(c, data, 30000)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.csv.gz"), compressed, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.csv.gz.gzi"), indexBGZF(compressed, 30000, len(data)), 0644), IsNil)
+	dataFile := FileInfo{
+		FileMeta: SourceFileMeta{Path: "db.t.csv.gz", Type: SourceTypeCSV, Compression: CompressionGZ},
+		Size:     int64(len(compressed)),
+	}
+
+	cfg := &config.Config{
+		Mydumper: config.MydumperRuntime{
+			ReadBlockSize:    config.ReadBlockSize,
+			CSV:              config.CSVConfig{Separator: ",", Delimiter: `"`},
+			StrictFormat:     true,
+			MaxRegionSize:    100000,
+			BatchSize:        100 * 1024 * 1024 * 1024,
+			BatchImportRatio: 0.75,
+		},
+	}
+	ioWorkers := worker.NewPool(context.Background(), 1, "io")
+	meta := &MDTableMeta{DB: "db", Name: "t", DataFiles: []FileInfo{dataFile}}
+	regions, err := MakeTableRegions(context.Background(), meta, 3, cfg, ioWorkers, store)
+	c.Assert(err, IsNil)
+	c.Assert(len(regions), Greater, 1)
+
+	// every region starts at a line, and the regions cover the whole file.
+	offset := int64(0)
+	for _, region := range regions {
+		c.Assert(region.Chunk.Offset, Equals, offset)
+		if offset > 0 {
+			c.Assert(data[offset-1], Equals, byte('\n'))
+		}
+		offset = region.Chunk.EndOffset
+	}
+	c.Assert(offset, Equals, int64(len(data)))
+
+	file, err := store.Open(context.Background(), "db.t.csv.gz")
+	c.Assert(err, IsNil)
+	index, err := ReadGzipIndex(context.Background(), store, "db.t.csv.gz")
+	c.Assert(err, IsNil)
+	reader, err := NewIndexedDecompressReader(file, index)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	last := regions[len(regions)-1].Chunk
+	_, err = reader.Seek(last.Offset, io.SeekStart)
+	c.Assert(err, IsNil)
+	rest, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(rest, DeepEquals, data[last.Offset:])
+
+	// a file without the index has no index.
+	index, err = ReadGzipIndex(context.Background(), store, "db.t.csv")
+	c.Assert(err, IsNil)
+	c.Assert(index, IsNil)
+}
@@ -0,0 +1,260 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// gzipReadAheadBlockSize is the size of the blocks decoded ahead from a
+	// gzip stream which cannot be decoded in parallel.
+	gzipReadAheadBlockSize = 256 * 1024
+	// bgzfHeaderSize is the size of the header of a BGZF block up to BSIZE.
+	bgzfHeaderSize = 18
+)
+
+// gzipConcurrency is the number of the goroutines decoding a gzip file.
+var gzipConcurrency int32 = 1
+
+// SetGzipConcurrency sets the number of the goroutines decoding a gzip file.
+// 1 or less disables the parallel decoding.
+func SetGzipConcurrency(n int) {
+	atomic.StoreInt32(&gzipConcurrency, int32(n))
+}
+
+// gzipBlock is a decoded block of the parallel gzip decoder.
+type gzipBlock struct {
+	data []byte
+	err  error
+}
+
+// parallelGzipDecoder decodes a gzip file with multiple goroutines. The BGZF
+// files (e.g. compressed by `bgzip`), which are the gzip members of at most
+// 64 KiB recording their sizes in the header, are split into the members
+// which are decoded in parallel. The other files are decoded sequentially in
+// a goroutine ahead of the reader, so the decoding still overlaps with the
+// parsing.
+type parallelGzipDecoder struct {
+	concurrency int
+
+	// blocks are the decoded blocks in order, each being received from a
+	// channel filled by a worker.
+	blocks  chan chan gzipBlock
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	cur []byte
+	err error
+}
+
+func newParallelGzipDecoder(r io.Reader, concurrency int) (*parallelGzipDecoder, error) {
+	d := &parallelGzipDecoder{concurrency: concurrency}
+	if err := d.start(r); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *parallelGzipDecoder) start(r io.Reader) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	// report the invalid header at once like gzip.NewReader.
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return errors.Trace(err)
+	}
+	if len(magic) < 2 {
+		return errors.Trace(io.EOF)
+	}
+	if magic[0] != 0x1f || magic[1] != 0x8b {
+		return errors.Trace(gzip.ErrHeader)
+	}
+
+	d.blocks = make(chan chan gzipBlock, d.concurrency*4)
+	d.closing = make(chan struct{})
+	d.cur, d.err = nil, nil
+	jobs := make(chan gzipJob)
+
+	d.wg.Add(d.concurrency + 1)
+	for i := 0; i < d.concurrency; i++ {
+		go d.decodeBlocks(jobs)
+	}
+	go d.split(br, jobs, d.closing)
+	return nil
+}
+
+type gzipJob struct {
+	member []byte
+	result chan gzipBlock
+}
+
+// split splits the BGZF members for the workers, and decodes the rest of the
+// file sequentially once a member is not in BGZF.
+func (d *parallelGzipDecoder) split(br *bufio.Reader, jobs chan<- gzipJob, closing <-chan struct{}) {
+	defer d.wg.Done()
+	defer close(d.blocks)
+	defer close(jobs)
+
+	for {
+		size, ok, err := peekBGZFMemberSize(br)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			d.post(gzipBlock{err: err}, closing)
+			return
+		}
+		if !ok {
+			d.decodeSequentially(br, closing)
+			return
+		}
+		member := make([]byte, size)
+		if _, err := io.ReadFull(br, member); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			d.post(gzipBlock{err: errors.Trace(err)}, closing)
+			return
+		}
+		result := make(chan gzipBlock, 1)
+		select {
+		case d.blocks <- result:
+		case <-closing:
+			return
+		}
+		select {
+		case jobs <- gzipJob{member: member, result: result}:
+		case <-closing:
+			return
+		}
+	}
+}
+
+// peekBGZFMemberSize returns the size of the next member if it is in BGZF,
+// i.e. having the extra subfield "BC" of the member size.
+func peekBGZFMemberSize(br *bufio.Reader) (int, bool, error) {
+	header, err := br.Peek(bgzfHeaderSize)
+	if len(header) == 0 && err == io.EOF {
+		return 0, false, io.EOF
+	}
+	if len(header) < bgzfHeaderSize {
+		// too short to be a BGZF member, leave the error to the gzip reader.
+		return 0, false, nil
+	}
+	const flagExtra = 1 << 2
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&flagExtra == 0 {
+		return 0, false, nil
+	}
+	xlen := int(binary.LittleEndian.Uint16(header[10:12]))
+	if xlen != 6 || header[12] != 'B' || header[13] != 'C' || binary.LittleEndian.Uint16(header[14:16]) != 2 {
+		return 0, false, nil
+	}
+	return int(binary.LittleEndian.Uint16(header[16:18])) + 1, true, nil
+}
+
+func (d *parallelGzipDecoder) decodeBlocks(jobs <-chan gzipJob) {
+	defer d.wg.Done()
+	for job := range jobs {
+		var block gzipBlock
+		zr, err := gzip.NewReader(bytes.NewReader(job.member))
+		if err == nil {
+			zr.Multistream(false)
+			block.data, err = ioutil.ReadAll(zr)
+		}
+		block.err = errors.Trace(err)
+		job.result <- block
+	}
+}
+
+func (d *parallelGzipDecoder) decodeSequentially(br *bufio.Reader, closing <-chan struct{}) {
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		d.post(gzipBlock{err: errors.Trace(err)}, closing)
+		return
+	}
+	for {
+		buf := make([]byte, gzipReadAheadBlockSize)
+		n := 0
+		for n < len(buf) && err == nil {
+			var m int
+			m, err = zr.Read(buf[n:])
+			n += m
+		}
+		if n > 0 && !d.post(gzipBlock{data: buf[:n]}, closing) {
+			return
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			d.post(gzipBlock{err: errors.Trace(err)}, closing)
+			return
+		}
+	}
+}
+
+// post sends a block decoded by the splitter, and returns false if the
+// decoder is closed.
+func (d *parallelGzipDecoder) post(block gzipBlock, closing <-chan struct{}) bool {
+	result := make(chan gzipBlock, 1)
+	result <- block
+	select {
+	case d.blocks <- result:
+		return true
+	case <-closing:
+		return false
+	}
+}
+
+func (d *parallelGzipDecoder) Read(p []byte) (int, error) {
+	for len(d.cur) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		result, ok := <-d.blocks
+		if !ok {
+			d.err = io.EOF
+			continue
+		}
+		block := <-result
+		d.cur, d.err = block.data, block.err
+	}
+	n := copy(p, d.cur)
+	d.cur = d.cur[n:]
+	return n, nil
+}
+
+func (d *parallelGzipDecoder) Reset(r io.Reader) error {
+	d.Close()
+	return d.start(r)
+}
+
+// Close stops the goroutines. The decoder can be reused by Reset.
+func (d *parallelGzipDecoder) Close() {
+	if d.closing == nil {
+		return
+	}
+	close(d.closing)
+	d.closing = nil
+	d.wg.Wait()
+}
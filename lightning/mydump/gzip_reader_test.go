@@ -0,0 +1,123 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	. "github.com/pingcap/check"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testGzipReaderSuite{})
+
+type testGzipReaderSuite struct{}
+
+func (s *testGzipReaderSuite) SetUpTest(c *C) {
+	SetGzipConcurrency(4)
+}
+
+func (s *testGzipReaderSuite) TearDownTest(c *C) {
+	SetGzipConcurrency(1)
+}
+
+// compressBGZF compresses the data into the BGZF members of blockSize bytes
+// at most, ending with an empty member like bgzip.
+func compressBGZF(c *C, data []byte, blockSize int) []byte {
+	var out bytes.Buffer
+	for {
+		n := blockSize
+		if n > len(data) {
+			n = len(data)
+		}
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		// the subfield "BC" holds the member size minus 1, patched below.
+		w.Header.Extra = []byte{'B', 'C', 2, 0, 0, 0}
+		_, err := w.Write(data[:n])
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+		member := buf.Bytes()
+		binary.LittleEndian.PutUint16(member[16:18], uint16(len(member)-1))
+		out.Write(member)
+		if n == 0 {
+			return out.Bytes()
+		}
+		data = data[n:]
+	}
+}
+
+func gzipTestData() []byte {
+	var sb strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&sb, "%d,%d,%x\n", i, i*7, i*13)
+	}
+	return []byte(sb.String())
+}
+
+func (s *testGzipReaderSuite) TestDecompress(c *C) {
+	data := gzipTestData()
+	cases := map[string][]byte{
+		"bgzf":  compressBGZF(c, data, 30000),
+		"plain": compress(c, CompressionGZ, data),
+		// the members not in BGZF are decoded sequentially.
+		"mixed": append(compressBGZF(c, data[:100000], 30000), compress(c, CompressionGZ, data[100000:])...),
+	}
+	for name, compressed := range cases {
+		r, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(compressed)}, CompressionGZ)
+		c.Assert(err, IsNil)
+		content, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil, Commentf("case %s", name))
+		c.Assert(content, DeepEquals, data, Commentf("case %s", name))
+
+		// seeking backwards restarts the decoding.
+		pos, err := r.Seek(12345, io.SeekStart)
+		c.Assert(err, IsNil)
+		c.Assert(pos, Equals, int64(12345))
+		content, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(content, DeepEquals, data[12345:], Commentf("case %s", name))
+		c.Assert(r.Close(), IsNil)
+	}
+}
+
+func (s *testGzipReaderSuite) TestCorrupted(c *C) {
+	data := gzipTestData()
+	compressed := compressBGZF(c, data, 30000)
+
+	r, err := NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(compressed[:len(compressed)/2])}, CompressionGZ)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, ErrorMatches, ".*unexpected EOF")
+	c.Assert(r.Close(), IsNil)
+
+	// flip a byte of the compressed data of the second member.
+	corrupted := append([]byte(nil), compressed...)
+	corrupted[len(corrupted)/2] ^= 0xff
+	r, err = NewDecompressReader(bytesReadSeekCloser{bytes.NewReader(corrupted)}, CompressionGZ)
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, NotNil)
+	c.Assert(r.Close(), IsNil)
+
+	_, err = NewDecompressReader(bytesReadSeekCloser{bytes.NewReader([]byte("1,2,3\n"))}, CompressionGZ)
+	c.Assert(err, ErrorMatches, ".*invalid header")
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var errJSONNotObject = errors.NewNoStackError("each line must be a JSON object")
+
+// JSONParser reads the newline-delimited JSON files (a.k.a. JSON Lines), whose
+// lines are the objects keyed by the column names. The values of every row are
+// in the order of the columns given to the parser, regardless of the order of
+// the keys in the object.
+type JSONParser struct {
+	blockParser
+	cfg *config.JSONConfig
+
+	// columnIndex maps the lower-case column names to the index in the row.
+	columnIndex map[string]int
+	filled      []bool
+	missing     []bool
+}
+
+// NewJSONParser creates a parser of the JSON file, mapping the keys of the
+// objects to the columns.
+func NewJSONParser(
+	cfg *config.JSONConfig,
+	reader ReadSeekCloser,
+	blockBufSize int64,
+	ioWorkers *worker.Pool,
+	columns []string,
+) *JSONParser {
+	parser := &JSONParser{
+		blockParser: makeBlockParser(reader, blockBufSize, ioWorkers),
+		cfg:         cfg,
+	}
+	parser.SetColumns(columns)
+	return parser
+}
+
+// SetColumns sets the columns which the keys of the objects are mapped to.
+func (parser *JSONParser) SetColumns(columns []string) {
+	parser.columns = make([]string, 0, len(columns))
+	parser.columnIndex = make(map[string]int, len(columns))
+	for i, column := range columns {
+		column = strings.ToLower(column)
+		parser.columns = append(parser.columns, column)
+		parser.columnIndex[column] = i
+	}
+	parser.filled = make([]bool, len(columns))
+	parser.missing = make([]bool, len(columns))
+}
+
+// readLine reads the next line without the line terminator. The result is
+// only valid until the next read.
+func (parser *blockParser) readLine() ([]byte, error) {
+	searched := 0
+	for {
+		if index := bytes.IndexByte(parser.buf[searched:], '\n'); index >= 0 {
+			index += searched
+			line := parser.buf[:index]
+			parser.buf = parser.buf[index+1:]
+			parser.pos += int64(index + 1)
+			return line, nil
+		}
+		searched = len(parser.buf)
+		if parser.isLastChunk {
+			if len(parser.buf) == 0 {
+				return nil, io.EOF
+			}
+			line := parser.buf
+			parser.buf = nil
+			parser.pos += int64(len(line))
+			return line, nil
+		}
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadRow reads a row from the datafile, skipping the blank lines.
+func (parser *JSONParser) ReadRow() error {
+	var line []byte
+	for len(line) == 0 {
+		var err error
+		if line, err = parser.readLine(); err != nil {
+			return errors.Trace(err)
+		}
+		line = bytes.TrimSpace(line)
+	}
+
+	var object map[string]json.RawMessage
+	err := errJSONNotObject
+	if line[0] == '{' {
+		err = json.Unmarshal(line, &object)
+	}
+	if err != nil {
+		content := line
+		if len(content) > 256 {
+			content = content[:256]
+		}
+		parser.Logger.Error("syntax error", zap.Int64("pos", parser.pos), zap.ByteString("content", content))
+		return errors.Annotate(err, "syntax error")
+	}
+
+	row := &parser.lastRow
+	row.RowID++
+	row.Row = parser.acquireDatumSlice()
+	if cap(row.Row) >= len(parser.columns) {
+		row.Row = row.Row[:len(parser.columns)]
+	} else {
+		row.Row = make([]types.Datum, len(parser.columns))
+	}
+	row.Missing = nil
+	for i := range parser.filled {
+		parser.filled[i] = false
+	}
+
+	for key, value := range object {
+		i, ok := parser.columnIndex[strings.ToLower(key)]
+		if !ok {
+			if parser.cfg.ExtraKey == config.JSONExtraKeyError {
+				return errors.Errorf("unknown column '%s' in the JSON object", key)
+			}
+			continue
+		}
+		if err := setJSONDatum(&row.Row[i], value); err != nil {
+			return errors.Annotatef(err, "invalid value of column '%s'", key)
+		}
+		parser.filled[i] = true
+	}
+	for i, filled := range parser.filled {
+		if filled {
+			continue
+		}
+		switch parser.cfg.MissingKey {
+		case config.JSONMissingKeyError:
+			return errors.Errorf("column '%s' missing from the JSON object", parser.columns[i])
+		case config.JSONMissingKeyDefault:
+			// the encoder fills the default value of the column.
+			if row.Missing == nil {
+				for j := range parser.missing {
+					parser.missing[j] = false
+				}
+				row.Missing = parser.missing
+			}
+			row.Missing[i] = true
+		}
+		row.Row[i].SetNull()
+	}
+	return nil
+}
+
+// setJSONDatum converts the JSON value to the datum like the CSV fields. The
+// numbers keep the literal to not lose the precision, and the objects and the
+// arrays are kept as the compact JSON text for the JSON columns.
+func setJSONDatum(d *types.Datum, value json.RawMessage) error {
+	if len(value) == 0 {
+		d.SetNull()
+		return nil
+	}
+	switch value[0] {
+	case 'n':
+		d.SetNull()
+	case 't':
+		d.SetInt64(1)
+	case 'f':
+		d.SetInt64(0)
+	case '"':
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return errors.Trace(err)
+		}
+		d.SetString(s, "utf8mb4_bin")
+	case '{', '[':
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err != nil {
+			return errors.Trace(err)
+		}
+		d.SetString(compact.String(), "utf8mb4_bin")
+	default:
+		d.SetString(string(value), "utf8mb4_bin")
+	}
+	return nil
+}
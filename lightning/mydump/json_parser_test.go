@@ -0,0 +1,119 @@
+package mydump_test
+
+import (
+	"context"
+	"io"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testMydumpJSONParserSuite{})
+
+type testMydumpJSONParserSuite struct {
+	ioWorkers *worker.Pool
+}
+
+func (s *testMydumpJSONParserSuite) SetUpSuite(c *C) {
+	s.ioWorkers = worker.NewPool(context.Background(), 5, "test_json")
+}
+
+func newJSONConfig() *config.JSONConfig {
+	return &config.JSONConfig{
+		MissingKey: config.JSONMissingKeyNull,
+		ExtraKey:   config.JSONExtraKeyIgnore,
+	}
+}
+
+func jsonString(s string) types.Datum {
+	return types.NewCollationStringDatum(s, "utf8mb4_bin", 0)
+}
+
+func (s *testMydumpJSONParserSuite) TestReadRows(c *C) {
+	input := `{"id": 1, "Name": "a\nb", "score": 1.50, "tags": ["x", {"y": 1}]}
+
+{"name": "é", "id": 2, "extra": true, "ok": false}
+  {"id": 3, "name": null, "ok": true}` + "\r\n" + `{"id": 12345678901234567890}`
+
+	// the small block size makes the lines span blocks.
+	parser := mydump.NewJSONParser(newJSONConfig(), mydump.NewStringReader(input), 8, s.ioWorkers,
+		[]string{"ID", "name", "score", "tags", "ok"})
+	c.Assert(parser.Columns(), DeepEquals, []string{"id", "name", "score", "tags", "ok"})
+
+	expected := [][]types.Datum{
+		{jsonString("1"), jsonString("a\nb"), jsonString("1.50"), jsonString(`["x",{"y":1}]`), nullDatum},
+		{jsonString("2"), jsonString("é"), nullDatum, nullDatum, types.NewIntDatum(0)},
+		{jsonString("3"), nullDatum, nullDatum, nullDatum, types.NewIntDatum(1)},
+		{jsonString("12345678901234567890"), nullDatum, nullDatum, nullDatum, nullDatum},
+	}
+	positions := []int{66, 119, 158, 186}
+	for i, row := range expected {
+		c.Assert(parser.ReadRow(), IsNil)
+		c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: int64(i) + 1, Row: row}, Commentf("row = %d", i+1))
+		c.Assert(parser, posEq, positions[i], i+1)
+	}
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpJSONParserSuite) TestSetPos(c *C) {
+	input := "{\"a\": 1}\n{\"a\": 2}\n{\"a\": 3}\n"
+	parser := mydump.NewJSONParser(newJSONConfig(), mydump.NewStringReader(input), 1024, s.ioWorkers, []string{"a"})
+	c.Assert(parser.SetPos(9, 5), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 6, Row: []types.Datum{jsonString("2")}})
+	c.Assert(parser, posEq, 18, 6)
+}
+
+func (s *testMydumpJSONParserSuite) TestKeyHandling(c *C) {
+	cfg := newJSONConfig()
+	cfg.MissingKey = config.JSONMissingKeyError
+	parser := mydump.NewJSONParser(cfg, mydump.NewStringReader(`{"a": 1}`), 1024, s.ioWorkers, []string{"a", "b"})
+	c.Assert(parser.ReadRow(), ErrorMatches, "column 'b' missing from the JSON object")
+
+	cfg = newJSONConfig()
+	cfg.ExtraKey = config.JSONExtraKeyError
+	parser = mydump.NewJSONParser(cfg, mydump.NewStringReader(`{"a": 1, "c": 2}`), 1024, s.ioWorkers, []string{"a"})
+	c.Assert(parser.ReadRow(), ErrorMatches, "unknown column 'c' in the JSON object")
+}
+
+func (s *testMydumpJSONParserSuite) TestMissingKeyDefault(c *C) {
+	cfg := newJSONConfig()
+	cfg.MissingKey = config.JSONMissingKeyDefault
+	input := `{"a": 1}` + "\n" + `{"a": 2, "b": null}` + "\n" + `{"b": 3}`
+	parser := mydump.NewJSONParser(cfg, mydump.NewStringReader(input), 1024, s.ioWorkers, []string{"a", "b"})
+
+	// the missing keys are marked, unlike the explicit nulls.
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID:   1,
+		Row:     []types.Datum{jsonString("1"), nullDatum},
+		Missing: []bool{false, true},
+	})
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 2, Row: []types.Datum{jsonString("2"), nullDatum}})
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID:   3,
+		Row:     []types.Datum{nullDatum, jsonString("3")},
+		Missing: []bool{true, false},
+	})
+}
+
+func (s *testMydumpJSONParserSuite) TestSyntaxError(c *C) {
+	inputs := []string{
+		`[1, 2]`,
+		`null`,
+		`{"a": 1`,
+		`{"a": 1} {"a": 2}`,
+		"{\"a\": 1,\n\"b\": 2}",
+	}
+	for _, input := range inputs {
+		parser := mydump.NewJSONParser(newJSONConfig(), mydump.NewStringReader(input), 1024, s.ioWorkers, []string{"a"})
+		c.Assert(parser.ReadRow(), ErrorMatches, "syntax error: .*", Commentf("input = %q", input))
+	}
+}
@@ -15,15 +15,27 @@ package mydump
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
 
 	"github.com/pingcap/br/pkg/storage"
 	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	router "github.com/pingcap/tidb-tools/pkg/table-router"
 	"go.uber.org/zap"
 
+	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
 	"github.com/pingcap/tidb-lightning/lightning/log"
 )
@@ -32,7 +44,10 @@ type MDDatabaseMeta struct {
 	Name       string
 	SchemaFile string
 	Tables     []*MDTableMeta
-	charSet    string
+	// PostSchemaFiles are the `*-schema-post.sql` files of the database, which
+	// are executed after all tables are imported.
+	PostSchemaFiles []FileInfo
+	charSet         string
 }
 
 type MDTableMeta struct {
@@ -42,17 +57,44 @@ type MDTableMeta struct {
 	DataFiles  []FileInfo
 	charSet    string
 	TotalSize  int64
+	// Priority is the import priority from the table priority file. Tables
+	// with higher priority are imported first.
+	Priority int
+	// Group is the name of the table group the table belongs to, or empty if
+	// the table is not in any group.
+	Group string
+	// ShardSchemaFiles are the other table schema files routed into this
+	// table, which are expected to be equivalent to SchemaFile.
+	ShardSchemaFiles []FileInfo
+	// ShardDataFiles maps the path of the data files from the shards of
+	// ShardSchemaFiles to the schema files of their shards.
+	ShardDataFiles map[string]FileInfo
+
+	// schema caches the statements in SchemaFile after the first read.
+	schemaMu sync.Mutex
+	schema   *string
 }
 
+// MaxSchemaFileSize is the size limit of a table schema file. Splitting the
+// statements of a file takes a few times its size in memory, so a larger file
+// is rejected instead of exhausting the memory.
+const MaxSchemaFileSize int64 = 256 << 20
+
 type SourceFileMeta struct {
 	Path        string
 	Type        SourceType
 	Compression Compression
 	SortKey     string
+	// Directives are the parsing settings given by the routing rule of the
+	// file, see config.FileRouteRule.
+	Directives string
 }
 
+// GetSchema returns the statements in the schema file of the table, or an
+// empty string if the file cannot be read. The error is only logged, use
+// GetSchemaErr to handle it.
 func (m *MDTableMeta) GetSchema(ctx context.Context, store storage.ExternalStorage) string {
-	schema, err := ExportStatement(ctx, store, m.SchemaFile, m.charSet)
+	schema, err := m.GetSchemaErr(ctx, store)
 	if err != nil {
 		log.L().Error("failed to extract table schema",
 			zap.String("Path", m.SchemaFile.FileMeta.Path),
@@ -60,7 +102,49 @@ func (m *MDTableMeta) GetSchema(ctx context.Context, store storage.ExternalStora
 		)
 		return ""
 	}
-	return string(schema)
+	return schema
+}
+
+// GetSchemaErr returns the statements in the schema file of the table. The
+// file is read and converted once, and the later calls return the cached
+// statements. A failed read is not cached.
+func (m *MDTableMeta) GetSchemaErr(ctx context.Context, store storage.ExternalStorage) (string, error) {
+	m.schemaMu.Lock()
+	defer m.schemaMu.Unlock()
+	if m.schema != nil {
+		return *m.schema, nil
+	}
+
+	if m.SchemaFile.Size > MaxSchemaFileSize {
+		return "", errors.Errorf("the schema file %s is too large (%d bytes), the limit is %d bytes",
+			m.SchemaFile.FileMeta.Path, m.SchemaFile.Size, MaxSchemaFileSize)
+	}
+	data, err := ExportStatement(ctx, store, m.SchemaFile, m.charSet)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to extract table schema from %s", m.SchemaFile.FileMeta.Path)
+	}
+	schema := string(data)
+	m.schema = &schema
+	return schema, nil
+}
+
+// ReadSchemaFile returns the statements in a schema file of the table, e.g.
+// one of the ShardSchemaFiles.
+func (m *MDTableMeta) ReadSchemaFile(ctx context.Context, store storage.ExternalStorage, file FileInfo) (string, error) {
+	schema, err := ExportStatement(ctx, store, file, m.charSet)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to extract table schema from %s", file.FileMeta.Path)
+	}
+	return string(schema), nil
+}
+
+// GetPostSchema returns the statements in the post schema file.
+func (m *MDDatabaseMeta) GetPostSchema(ctx context.Context, store storage.ExternalStorage, file FileInfo) (string, error) {
+	statements, err := ExportStatement(ctx, store, file, m.charSet)
+	if err != nil {
+		return "", errors.Annotatef(err, "failed to extract post schema from %s", file.FileMeta.Path)
+	}
+	return string(statements), nil
 }
 
 /*
@@ -74,6 +158,53 @@ type MDLoader struct {
 	router     *router.Table
 	fileRouter FileRouter
 	charSet    string
+	sqlMode    mysql.SQLMode
+
+	maxTableSize       int64
+	excludeColumnTypes []string
+	tableTags          map[filter.Table][]string
+	includeTags        []string
+	filteredTables     []FilteredTable
+
+	caseSensitive   bool
+	tablePriorities map[filter.Table]int
+	tableGroups     []tableGroupFilter
+	execPostSchema  bool
+	generations     []string
+	// preserveRowOrder sorts the data files by the numbers in the sort keys
+	// rather than the text.
+	preserveRowOrder bool
+
+	detectCompression bool
+
+	detectCharset     bool
+	charsetConfidence float64
+	charsetGuesses    []FileCharset
+
+	// knownFilteredFiles are the files filtered out in the previous runs,
+	// which are skipped without routing.
+	knownFilteredFiles map[string]struct{}
+	// newFilteredFiles are the files filtered out in this run.
+	newFilteredFiles []string
+
+	// sourceDirs is the data sources merged into one import if there are
+	// more than one, whose files are listed by the source-qualified paths.
+	sourceDirs []string
+	// manifest lists the source files instead of walking the data source if
+	// `mydumper.manifest-file` is set.
+	manifest []ManifestEntry
+}
+
+type tableGroupFilter struct {
+	name   string
+	filter filter.Filter
+}
+
+// FilteredTable is a table excluded by the attribute-based filters, together
+// with the reason of the decision.
+type FilteredTable struct {
+	filter.Table
+	Reason string
 }
 
 type mdLoaderSetup struct {
@@ -81,24 +212,42 @@ type mdLoaderSetup struct {
 	dbSchemas     []FileInfo
 	tableSchemas  []FileInfo
 	tableDatas    []FileInfo
+	postSchemas   []FileInfo
 	dbIndexMap    map[string]int
 	tableIndexMap map[filter.Table]int
+	// sourceTables maps the path of the routed files to the tables they
+	// belong to before routing.
+	sourceTables map[string]filter.Table
+	// tableSources maps the tables before routing to the indices of the data
+	// sources of their files.
+	tableSources map[filter.Table]int
 }
 
 func NewMyDumpLoader(ctx context.Context, cfg *config.Config) (*MDLoader, error) {
-	u, err := storage.ParseBackend(cfg.Mydumper.SourceDir, nil)
-	if err != nil {
-		return nil, err
+	if cfg.Mydumper.IsStream() {
+		return NewMyDumpLoaderWithStore(ctx, cfg, WrapRetryStorage(NewStreamStorage(cfg.Mydumper.SourceDir), &cfg.Mydumper))
 	}
-	s, err := storage.Create(ctx, u, true)
+	s, err := OpenDataSources(ctx, &cfg.Mydumper, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewMyDumpLoaderWithStore(ctx, cfg, s)
+	return NewMyDumpLoaderWithStore(ctx, cfg, WrapRetryStorage(s, &cfg.Mydumper))
 }
 
 func NewMyDumpLoaderWithStore(ctx context.Context, cfg *config.Config, store storage.ExternalStorage) (*MDLoader, error) {
+	return NewMyDumpLoaderWithFilteredFiles(ctx, cfg, store, nil)
+}
+
+// NewMyDumpLoaderWithFilteredFiles is like NewMyDumpLoaderWithStore, except
+// that the files known to be filtered out, e.g. recorded in the checkpoints
+// of the previous run, are skipped without routing.
+func NewMyDumpLoaderWithFilteredFiles(
+	ctx context.Context,
+	cfg *config.Config,
+	store storage.ExternalStorage,
+	filteredFiles map[string]struct{},
+) (*MDLoader, error) {
 	var r *router.Table
 	var err error
 
@@ -143,13 +292,87 @@ func NewMyDumpLoaderWithStore(ctx context.Context, cfg *config.Config, store sto
 		filter:     f,
 		router:     r,
 		charSet:    cfg.Mydumper.CharacterSet,
+		sqlMode:    cfg.TiDB.SQLMode,
 		fileRouter: fileRouter,
+
+		maxTableSize:       cfg.Mydumper.MaxTableSize,
+		excludeColumnTypes: cfg.Mydumper.ExcludeColumnTypes,
+		includeTags:        cfg.Mydumper.IncludeTags,
+
+		caseSensitive:  cfg.Mydumper.CaseSensitive,
+		execPostSchema: cfg.PostRestore.ExecPostSchema,
+		generations:    cfg.Mydumper.Generations,
+
+		preserveRowOrder:  cfg.Mydumper.PreserveRowOrder,
+		detectCompression: cfg.Mydumper.DetectCompression,
+
+		detectCharset:     cfg.Mydumper.DetectCharset,
+		charsetConfidence: cfg.Mydumper.CharsetConfidence,
+
+		knownFilteredFiles: filteredFiles,
+		sourceDirs:         cfg.Mydumper.SourceDirs,
+	}
+
+	if len(cfg.Mydumper.ManifestFile) > 0 {
+		if mdl.manifest, err = LoadManifest(cfg.Mydumper.ManifestFile); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if len(cfg.Mydumper.TablePriorityFile) > 0 {
+		priorities, err := LoadTablePriorities(cfg.Mydumper.TablePriorityFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		mdl.tablePriorities = make(map[filter.Table]int, len(priorities))
+		for table, priority := range priorities {
+			if !mdl.caseSensitive {
+				table = filter.Table{Schema: strings.ToLower(table.Schema), Name: strings.ToLower(table.Name)}
+			}
+			mdl.tablePriorities[table] = priority
+		}
+	}
+
+	if len(cfg.Mydumper.TableTagsFile) > 0 {
+		tags, err := LoadTableTags(cfg.Mydumper.TableTagsFile)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		mdl.tableTags = make(map[filter.Table][]string, len(tags))
+		for table, tableTags := range tags {
+			if !mdl.caseSensitive {
+				table = filter.Table{Schema: strings.ToLower(table.Schema), Name: strings.ToLower(table.Name)}
+			}
+			mdl.tableTags[table] = tableTags
+		}
+	}
+
+	for _, group := range cfg.Mydumper.TableGroups {
+		f, err := filter.Parse(group.Tables)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid tables of table group '%s'", group.Name)
+		}
+		if !mdl.caseSensitive {
+			f = filter.CaseInsensitive(f)
+		}
+		mdl.tableGroups = append(mdl.tableGroups, tableGroupFilter{name: group.Name, filter: f})
+	}
+
+	if cfg.Mydumper.IsStream() {
+		// the only data file is the stream, which is not routed, and the table
+		// is created in the target before importing.
+		mdl.noSchema = true
+		if mdl.dbs, err = streamDatabases(&cfg.Mydumper); err != nil {
+			return nil, err
+		}
+		return mdl, nil
 	}
 
 	setup := mdLoaderSetup{
 		loader:        mdl,
 		dbIndexMap:    make(map[string]int),
 		tableIndexMap: make(map[filter.Table]int),
+		tableSources:  make(map[filter.Table]int),
 	}
 
 	if err := setup.setup(ctx, mdl.store); err != nil {
@@ -219,19 +442,25 @@ func (s *mdLoaderSetup) setup(ctx context.Context, store storage.ExternalStorage
 		if len(s.dbSchemas) == 0 {
 			return errors.New("missing {schema}-schema-create.sql")
 		}
+		// the database may be created in each of the data sources.
+		multiSource := len(s.loader.sourceDirs) > 1
 		for _, fileInfo := range s.dbSchemas {
-			if _, dbExists := s.insertDB(fileInfo.TableName.Schema, fileInfo.FileMeta.Path); dbExists && s.loader.router == nil {
+			if _, dbExists := s.insertDB(fileInfo.TableName.Schema, fileInfo.FileMeta.Path); dbExists && s.loader.router == nil && !multiSource {
 				return errors.Errorf("invalid database schema file, duplicated item - %s", fileInfo.FileMeta.Path)
 			}
 		}
 
 		// setup table schema
 		for _, fileInfo := range s.tableSchemas {
-			_, dbExists, tableExists := s.insertTable(fileInfo)
+			tableMeta, dbExists, tableExists := s.insertTable(fileInfo)
 			if !dbExists {
 				return errors.Errorf("invalid table schema file, cannot find db '%s' - %s", fileInfo.TableName.Schema, fileInfo.FileMeta.Path)
 			} else if tableExists && s.loader.router == nil {
 				return errors.Errorf("invalid table schema file, duplicated item - %s", fileInfo.FileMeta.Path)
+			} else if tableExists {
+				// the table is created with the first schema file, the others
+				// are only verified to be equivalent.
+				tableMeta.ShardSchemaFiles = append(tableMeta.ShardSchemaFiles, fileInfo)
 			}
 		}
 	}
@@ -248,20 +477,78 @@ func (s *mdLoaderSetup) setup(ctx context.Context, store storage.ExternalStorage
 			}
 		}
 		tableMeta.DataFiles = append(tableMeta.DataFiles, fileInfo)
-		tableMeta.TotalSize += fileInfo.Size
+		size := fileInfo.Size
+		// the framed snappy files compress to a fraction of the size, which
+		// misplaces the table in the order by size, so the decompressed size
+		// is estimated from the beginning of the file.
+		if fileInfo.FileMeta.Compression == CompressionSnappy {
+			estimated, err := EstimateRealSize(ctx, store, fileInfo)
+			if err != nil {
+				return errors.Annotatef(err, "estimate the size of %s", fileInfo.FileMeta.Path)
+			}
+			size = estimated
+		}
+		tableMeta.TotalSize += size
+	}
+	s.matchShardDataFiles()
+
+	// attach the post schema files to the databases they belong to
+	for _, fileInfo := range s.postSchemas {
+		dbIndex, ok := s.dbIndexMap[fileInfo.TableName.Schema]
+		if !ok {
+			common.RecordWarning(fileInfo.TableName.Schema, common.WarningSkippedFile,
+				"post schema is not restored since the database is not imported: "+fileInfo.FileMeta.Path)
+			continue
+		}
+		dbMeta := s.loader.dbs[dbIndex]
+		dbMeta.PostSchemaFiles = append(dbMeta.PostSchemaFiles, fileInfo)
+	}
+
+	if s.loader.detectCharset {
+		if err := s.detectCharsets(ctx, store); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err := s.filterByAttributes(ctx, store); err != nil {
+		return errors.Trace(err)
 	}
 
 	for _, dbMeta := range s.loader.dbs {
+		for _, tblMeta := range dbMeta.Tables {
+			tblMeta.Priority = s.loader.tablePriority(tblMeta.DB, tblMeta.Name)
+			tblMeta.Group = s.loader.tableGroup(tblMeta.DB, tblMeta.Name)
+		}
+
 		// Put the small table in the front of the slice which can avoid large table
 		// take a long time to import and block small table to release index worker.
+		// The table priority takes precedence over the size.
 		sort.SliceStable(dbMeta.Tables, func(i, j int) bool {
-			return dbMeta.Tables[i].TotalSize < dbMeta.Tables[j].TotalSize
+			a, b := dbMeta.Tables[i], dbMeta.Tables[j]
+			if a.Priority != b.Priority {
+				return a.Priority > b.Priority
+			}
+			return a.TotalSize < b.TotalSize
 		})
 
-		// sort each table source files by sort-key
+		// sort each table source files by generation and sort-key
 		for _, tbMeta := range dbMeta.Tables {
 			dataFiles := tbMeta.DataFiles
 			sort.SliceStable(dataFiles, func(i, j int) bool {
+				gi := config.DataGeneration(s.loader.generations, dataFiles[i].FileMeta.Path)
+				gj := config.DataGeneration(s.loader.generations, dataFiles[j].FileMeta.Path)
+				if gi != gj {
+					return gi < gj
+				}
+				if s.loader.preserveRowOrder {
+					// the files of the merged shards sharing the sort keys
+					// are ordered by the paths.
+					ki, kj := dataFiles[i].FileMeta.SortKey, dataFiles[j].FileMeta.SortKey
+					if ki != kj {
+						return naturalLess(ki, kj)
+					}
+					return naturalLess(dataFiles[i].FileMeta.Path, dataFiles[j].FileMeta.Path)
+				}
 				return dataFiles[i].FileMeta.SortKey < dataFiles[j].FileMeta.SortKey
 			})
 		}
@@ -270,31 +557,126 @@ func (s *mdLoaderSetup) setup(ctx context.Context, store storage.ExternalStorage
 	return nil
 }
 
+// naturalLess compares the strings with the runs of digits compared by their
+// numeric values, e.g. "tbl.2" < "tbl.10". The strings equal in this way,
+// like "01" and "1", are compared as the text.
+func naturalLess(a, b string) bool {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if !isDigit(a[i]) || !isDigit(b[j]) {
+			if a[i] != b[j] {
+				return a[i] < b[j]
+			}
+			i++
+			j++
+			continue
+		}
+
+		// compare the numbers by the digits without the leading zeros.
+		si, sj := i, j
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		na := strings.TrimLeft(a[si:i], "0")
+		nb := strings.TrimLeft(b[sj:j], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+	}
+	if len(a)-i != len(b)-j {
+		return len(a)-i < len(b)-j
+	}
+	return a < b
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
 func (s *mdLoaderSetup) listFiles(ctx context.Context, store storage.ExternalStorage) error {
 	// `filepath.Walk` yields the paths in a deterministic (lexicographical) order,
 	// meaning the file and chunk orders will be the same everytime it is called
 	// (as long as the source is immutable).
-	err := store.WalkDir(ctx, &storage.WalkOption{}, func(path string, size int64) error {
+	//
+	// the files listed in the manifest with the types are not routed by the
+	// rules, in which case res is the routing result given by the manifest.
+	route := func(path string, size int64, res *RouteResult) error {
+		if _, ok := s.loader.knownFilteredFiles[path]; ok {
+			return nil
+		}
 		logger := log.With(zap.String("path", path))
 
-		res, err := s.loader.fileRouter.Route(filepath.ToSlash(path))
-		if err != nil {
-			return errors.Annotatef(err, "apply file routing on file '%s' failed", path)
+		// the files of the merged data sources are routed by the paths
+		// relative to their data sources.
+		source, relPath := 0, path
+		if len(s.loader.sourceDirs) > 1 {
+			source, relPath = SplitSourcePath(path)
+		}
+		if res == nil {
+			var err error
+			res, err = s.loader.fileRouter.Route(filepath.ToSlash(relPath))
+			if err != nil {
+				return errors.Annotatef(err, "apply file routing on file '%s' failed", path)
+			}
 		}
 		if res == nil {
 			logger.Info("[loader] file is filtered by file router")
+			s.loader.newFilteredFiles = append(s.loader.newFilteredFiles, path)
+			return nil
+		}
+		if res.Type == SourceTypeXLSX {
+			if workbook, _ := SplitXLSXSheetPath(path); !isXLSXFile(workbook) {
+				return errors.Errorf("file '%s' is routed as xlsx but it is not a sheet of an xlsx file", path)
+			}
+		}
+		if res.Type == SourceTypeIgnore && strings.HasSuffix(strings.ToLower(path), "-schema-view.sql") {
+			common.RecordWarning("", common.WarningSkippedFile, "view is not restored, please create it manually: "+path)
+		}
+		if res.Type == SourceTypeSchemaPost && !s.loader.execPostSchema {
+			common.RecordWarning("", common.WarningSkippedFile,
+				"post schema is not restored, please execute it manually or enable `post-restore.exec-post-schema`: "+path)
 			return nil
 		}
 
+		// the schema of the incremental dumps is defined by the base dump.
+		if config.DataGeneration(s.loader.generations, relPath) > 0 {
+			switch res.Type {
+			case SourceTypeSQL, SourceTypeCSV, SourceTypeParquet, SourceTypeAvro, SourceTypeORC, SourceTypeJSON, SourceTypeFixedWidth, SourceTypeXLSX, SourceTypeArrow,
+				SourceTypeMsgpack, SourceTypeBSON, SourceTypeMongoExport, SourceTypeProtobuf:
+			default:
+				logger.Debug("[loader] ignoring non-data file of incremental dump")
+				return nil
+			}
+		}
+
+		// the compression of the files not telling it by their names is
+		// detected from their content.
+		if s.loader.detectCompression && res.Compression == CompressionNone && isStreamDataType(res.Type) {
+			compression, err := detectFileCompression(ctx, store, path)
+			if err != nil {
+				return errors.Annotatef(err, "detect the compression of file '%s' failed", path)
+			}
+			if compression != CompressionNone {
+				logger.Info("[loader] detected compression", zap.Stringer("compression", compression))
+			}
+			res.Compression = compression
+		}
+
 		info := FileInfo{
 			TableName: filter.Table{Schema: res.Schema, Name: res.Name},
-			FileMeta:  SourceFileMeta{Path: path, Type: res.Type, Compression: res.Compression, SortKey: res.Key},
+			FileMeta:  SourceFileMeta{Path: path, Type: res.Type, Compression: res.Compression, SortKey: res.Key, Directives: res.Directives},
 			Size:      size,
 		}
 
 		if s.loader.shouldSkip(&info.TableName) {
 			logger.Debug("[filter] ignoring table file")
-
+			s.loader.newFilteredFiles = append(s.loader.newFilteredFiles, path)
 			return nil
 		}
 
@@ -302,20 +684,98 @@ func (s *mdLoaderSetup) listFiles(ctx context.Context, store storage.ExternalSto
 		case SourceTypeSchemaSchema:
 			s.dbSchemas = append(s.dbSchemas, info)
 		case SourceTypeTableSchema:
+			if err := s.checkTableSource(info, source); err != nil {
+				return err
+			}
 			s.tableSchemas = append(s.tableSchemas, info)
-		case SourceTypeSQL, SourceTypeCSV, SourceTypeParquet:
+		case SourceTypeSQL, SourceTypeCSV, SourceTypeParquet, SourceTypeAvro, SourceTypeORC, SourceTypeJSON, SourceTypeFixedWidth, SourceTypeXLSX, SourceTypeArrow,
+			SourceTypeMsgpack, SourceTypeBSON, SourceTypeMongoExport, SourceTypeProtobuf:
+			if err := s.checkTableSource(info, source); err != nil {
+				return err
+			}
 			s.tableDatas = append(s.tableDatas, info)
+		case SourceTypeSchemaPost:
+			s.postSchemas = append(s.postSchemas, info)
 		}
 
 		logger.Info("file route result", zap.String("schema", res.Schema),
 			zap.String("table", res.Name), zap.Stringer("type", res.Type))
 
 		return nil
-	})
+	}
+
+	visit := func(path string, size int64) error {
+		// the sheets of the workbooks are routed as the files under the
+		// workbooks.
+		if isXLSXFile(path) {
+			sheets, err := ListXLSXSheets(ctx, store, path)
+			if err != nil {
+				return errors.Annotatef(err, "failed to read the sheets of xlsx file '%s'", path)
+			}
+			for _, sheet := range sheets {
+				if err := route(XLSXSheetPath(path, sheet.Name), sheet.Size, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return route(path, size, nil)
+	}
+
+	// listing the buckets of millions of unrelated objects is slow, where the
+	// files are listed in the manifest instead.
+	if s.loader.manifest != nil {
+		for i := range s.loader.manifest {
+			entry := &s.loader.manifest[i]
+			var err error
+			if res := entry.routeResult(); res != nil {
+				err = route(entry.Path, entry.Size, res)
+			} else {
+				err = visit(entry.Path, entry.Size)
+			}
+			if err != nil {
+				return errors.Trace(err)
+			}
+		}
+		return nil
+	}
 
+	err := store.WalkDir(ctx, &storage.WalkOption{}, visit)
 	return errors.Trace(err)
 }
 
+// checkTableSource returns an error if the files of the same table are found
+// in more than one data source, which are not merged into the table unless
+// they are of different tables routed to it.
+func (s *mdLoaderSetup) checkTableSource(info FileInfo, source int) error {
+	if len(s.loader.sourceDirs) <= 1 {
+		return nil
+	}
+	table := info.TableName
+	if !s.loader.caseSensitive {
+		table = filter.Table{Schema: strings.ToLower(table.Schema), Name: strings.ToLower(table.Name)}
+	}
+	if other, ok := s.tableSources[table]; ok && other != source {
+		return errors.Errorf("table %s is found in both data sources '%s' and '%s' - %s",
+			common.UniqueTable(info.TableName.Schema, info.TableName.Name),
+			config.RedactURL(s.loader.sourceDirs[other]), config.RedactURL(s.loader.sourceDirs[source]), info.FileMeta.Path)
+	}
+	s.tableSources[table] = source
+	return nil
+}
+
+// isStreamDataType returns whether the data files of the type are read
+// sequentially, so they may be compressed as a whole.
+func isStreamDataType(t SourceType) bool {
+	switch t {
+	case SourceTypeSQL, SourceTypeCSV, SourceTypeJSON, SourceTypeFixedWidth, SourceTypeMsgpack, SourceTypeBSON,
+		SourceTypeMongoExport, SourceTypeProtobuf:
+		return true
+	default:
+		return false
+	}
+}
+
 func (l *MDLoader) shouldSkip(table *filter.Table) bool {
 	if len(table.Name) == 0 {
 		return !l.filter.MatchSchema(table.Schema)
@@ -323,11 +783,271 @@ func (l *MDLoader) shouldSkip(table *filter.Table) bool {
 	return !l.filter.MatchTable(table.Schema, table.Name)
 }
 
+// filterByAttributes removes the tables rejected by the attribute-based
+// filters (table size, column types and tags) from `s.loader.dbs`. This must be
+// called after all tables are inserted since the table indices are not
+// maintained after the removal.
+func (s *mdLoaderSetup) filterByAttributes(ctx context.Context, store storage.ExternalStorage) error {
+	l := s.loader
+	if l.maxTableSize <= 0 && len(l.excludeColumnTypes) == 0 && len(l.includeTags) == 0 {
+		return nil
+	}
+
+	for _, dbMeta := range l.dbs {
+		// https://github.com/golang/go/wiki/SliceTricks#filtering-without-allocating
+		remainingTables := dbMeta.Tables[:0]
+		for _, tblMeta := range dbMeta.Tables {
+			reason, err := l.attributeFilterReason(ctx, store, tblMeta)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if len(reason) == 0 {
+				remainingTables = append(remainingTables, tblMeta)
+				continue
+			}
+			common.RecordWarning(common.UniqueTable(tblMeta.DB, tblMeta.Name), common.WarningFilteredTable,
+				"table is excluded by attribute filter: "+reason)
+			l.filteredTables = append(l.filteredTables, FilteredTable{
+				Table:  filter.Table{Schema: tblMeta.DB, Name: tblMeta.Name},
+				Reason: reason,
+			})
+		}
+		dbMeta.Tables = remainingTables
+	}
+	return nil
+}
+
+// detectCharsets guesses the charset of every schema, SQL and CSV file. The
+// charset of the table schema is chosen from the guess if `character-set` is
+// "auto", refusing to guess if the confidence is below the threshold. Data
+// files are always read as binary, so they are only reported.
+func (s *mdLoaderSetup) detectCharsets(ctx context.Context, store storage.ExternalStorage) error {
+	l := s.loader
+	for _, dbMeta := range l.dbs {
+		for _, tblMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(tblMeta.DB, tblMeta.Name)
+
+			if len(tblMeta.SchemaFile.FileMeta.Path) > 0 {
+				guess, err := l.guessCharset(ctx, store, tblMeta.SchemaFile.FileMeta.Path)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				switch {
+				case tblMeta.charSet == "auto":
+					if guess.Confidence < l.charsetConfidence {
+						return errors.Errorf("cannot detect the charset of %s confidently, best guess is %s with confidence %.2f, please set `mydumper.character-set` explicitly",
+							tblMeta.SchemaFile.FileMeta.Path, guess.Charset, guess.Confidence)
+					}
+					tblMeta.charSet = guess.Charset
+				case tblMeta.charSet != "binary" && guess.Charset != tblMeta.charSet && guess.Confidence >= l.charsetConfidence:
+					common.RecordWarning(tableName, common.WarningSourceCharset,
+						fmt.Sprintf("schema file '%s' looks like %s rather than %s", tblMeta.SchemaFile.FileMeta.Path, guess.Charset, tblMeta.charSet))
+				}
+			}
+
+			for _, dataFile := range tblMeta.DataFiles {
+				if dataFile.FileMeta.Type == SourceTypeParquet || dataFile.FileMeta.Type == SourceTypeAvro || dataFile.FileMeta.Type == SourceTypeORC || dataFile.FileMeta.Type == SourceTypeXLSX || dataFile.FileMeta.Type == SourceTypeArrow ||
+					dataFile.FileMeta.Type == SourceTypeMsgpack || dataFile.FileMeta.Type == SourceTypeBSON || dataFile.FileMeta.Type == SourceTypeProtobuf ||
+					dataFile.FileMeta.Compression != CompressionNone {
+					continue
+				}
+				guess, err := l.guessCharset(ctx, store, dataFile.FileMeta.Path)
+				if err != nil {
+					return errors.Trace(err)
+				}
+				if guess.Charset != "utf8mb4" && guess.Confidence >= l.charsetConfidence {
+					common.RecordWarning(tableName, common.WarningSourceCharset,
+						fmt.Sprintf("data file '%s' looks like %s and is imported without conversion", dataFile.FileMeta.Path, guess.Charset))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (l *MDLoader) guessCharset(ctx context.Context, store storage.ExternalStorage, path string) (CharsetGuess, error) {
+	guess, err := detectFileCharset(ctx, store, path)
+	if err != nil {
+		return guess, errors.Trace(err)
+	}
+	log.L().Info("detected source file charset",
+		zap.String("path", path),
+		zap.String("charset", guess.Charset),
+		zap.Float64("confidence", guess.Confidence),
+	)
+	l.charsetGuesses = append(l.charsetGuesses, FileCharset{Path: path, CharsetGuess: guess})
+	return guess, nil
+}
+
+// attributeFilterReason returns a non-empty reason if the table should be
+// excluded by the attribute-based filters.
+func (l *MDLoader) attributeFilterReason(ctx context.Context, store storage.ExternalStorage, tblMeta *MDTableMeta) (string, error) {
+	if len(l.includeTags) > 0 {
+		if reason := l.tagFilterReason(tblMeta); len(reason) > 0 {
+			return reason, nil
+		}
+	}
+	if l.maxTableSize > 0 && tblMeta.TotalSize > l.maxTableSize {
+		return fmt.Sprintf("total data size %d exceeds max-table-size %d", tblMeta.TotalSize, l.maxTableSize), nil
+	}
+
+	// the column types are only known from the schema file.
+	if len(l.excludeColumnTypes) == 0 || len(tblMeta.SchemaFile.FileMeta.Path) == 0 {
+		return "", nil
+	}
+	schema, err := ExportStatement(ctx, store, tblMeta.SchemaFile, tblMeta.charSet)
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+	columnTypes, err := parseColumnTypes(string(schema), l.sqlMode)
+	if err != nil {
+		// the excluded types (e.g. the spatial ones) may be exactly what the
+		// parser does not understand, so fall back to searching the type names
+		// in the text rather than letting the table through.
+		log.L().Warn("[filter] cannot parse table schema, matching the column types by text",
+			zap.String("path", tblMeta.SchemaFile.FileMeta.Path),
+			log.ShortError(err),
+		)
+		words := schemaWords(string(schema))
+		for _, tp := range l.excludeColumnTypes {
+			if _, ok := words[tp]; ok {
+				return fmt.Sprintf("unparsable schema mentions excluded type %s", tp), nil
+			}
+		}
+		return "", nil
+	}
+	for _, col := range columnTypes {
+		for _, tp := range l.excludeColumnTypes {
+			if col.tp == tp {
+				return fmt.Sprintf("column `%s` has excluded type %s", col.name, tp), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func (l *MDLoader) tagFilterReason(tblMeta *MDTableMeta) string {
+	key := filter.Table{Schema: tblMeta.DB, Name: tblMeta.Name}
+	if !l.caseSensitive {
+		key.Schema = strings.ToLower(key.Schema)
+		key.Name = strings.ToLower(key.Name)
+	}
+	tags := l.tableTags[key]
+	for _, tag := range tags {
+		for _, include := range l.includeTags {
+			if tag == include {
+				return ""
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return fmt.Sprintf("table has no tags, include-tags is %s", strings.Join(l.includeTags, ","))
+	}
+	return fmt.Sprintf("tags %s do not match include-tags %s", strings.Join(tags, ","), strings.Join(l.includeTags, ","))
+}
+
+// schemaWords returns the lower-cased bare words of the schema, skipping the
+// quoted identifiers, the strings and the comments, so that a column named
+// after a type is not mistaken for the type.
+func schemaWords(schema string) map[string]struct{} {
+	words := make(map[string]struct{})
+	isWord := func(c byte) bool {
+		return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	}
+	for i := 0; i < len(schema); {
+		c := schema[i]
+		switch {
+		case c == '`' || c == '\'' || c == '"':
+			j := i + 1
+			for j < len(schema) && schema[j] != c {
+				if schema[j] == '\\' && c != '`' {
+					j++
+				}
+				j++
+			}
+			i = j + 1
+		case c == '-' && strings.HasPrefix(schema[i:], "-- "), c == '#':
+			j := strings.IndexByte(schema[i:], '\n')
+			if j < 0 {
+				return words
+			}
+			i += j + 1
+		case c == '/' && strings.HasPrefix(schema[i:], "/*") && !strings.HasPrefix(schema[i:], "/*!"):
+			j := strings.Index(schema[i+2:], "*/")
+			if j < 0 {
+				return words
+			}
+			i += j + 4
+		case isWord(c):
+			j := i
+			for j < len(schema) && isWord(schema[j]) {
+				j++
+			}
+			words[strings.ToLower(schema[i:j])] = struct{}{}
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+type columnType struct {
+	name string
+	tp   string
+}
+
+// parseColumnTypes extracts the lower-case column type names (e.g. "int",
+// "text", "geometry") from the CREATE TABLE statements in the schema.
+func parseColumnTypes(schema string, sqlMode mysql.SQLMode) ([]columnType, error) {
+	p := parser.New()
+	p.SetSQLMode(sqlMode)
+	stmts, _, err := p.Parse(schema, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var res []columnType
+	for _, stmt := range stmts {
+		createTable, ok := stmt.(*ast.CreateTableStmt)
+		if !ok {
+			continue
+		}
+		for _, col := range createTable.Cols {
+			res = append(res, columnType{
+				name: col.Name.Name.O,
+				tp:   strings.ToLower(types.TypeToStr(col.Tp.Tp, col.Tp.Charset)),
+			})
+		}
+	}
+	return res, nil
+}
+
+func (l *MDLoader) tablePriority(schema, table string) int {
+	key := filter.Table{Schema: schema, Name: table}
+	if !l.caseSensitive {
+		key.Schema = strings.ToLower(key.Schema)
+		key.Name = strings.ToLower(key.Name)
+	}
+	return l.tablePriorities[key]
+}
+
+// tableGroup returns the name of the first table group matching the table.
+func (l *MDLoader) tableGroup(schema, table string) string {
+	for _, group := range l.tableGroups {
+		if group.filter.MatchTable(schema, table) {
+			return group.name
+		}
+	}
+	return ""
+}
+
 func (s *mdLoaderSetup) route() error {
 	r := s.loader.router
 	if r == nil {
 		return nil
 	}
+	s.sourceTables = make(map[string]filter.Table)
 
 	type dbInfo struct {
 		fileMeta SourceFileMeta
@@ -353,6 +1073,7 @@ func (s *mdLoaderSetup) route() error {
 			if err != nil {
 				return errors.Trace(err)
 			}
+			s.sourceTables[info.FileMeta.Path] = info.TableName
 			if dbName != info.TableName.Schema {
 				oldInfo := knownDBNames[info.TableName.Schema]
 				oldInfo.count--
@@ -380,6 +1101,14 @@ func (s *mdLoaderSetup) route() error {
 	if err := run(s.tableDatas); err != nil {
 		return errors.Trace(err)
 	}
+	// the post schema files follow their databases, but never create one.
+	for i, info := range s.postSchemas {
+		dbName, tableName, err := r.Route(info.TableName.Schema, info.TableName.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		s.postSchemas[i].TableName = filter.Table{Schema: dbName, Name: tableName}
+	}
 
 	// remove all schemas which has been entirely routed away
 	// https://github.com/golang/go/wiki/SliceTricks#filtering-without-allocating
@@ -394,6 +1123,32 @@ func (s *mdLoaderSetup) route() error {
 	return nil
 }
 
+// matchShardDataFiles finds the schema files of the shards the data files
+// belong to, for the tables merged from shards with their own schema files.
+func (s *mdLoaderSetup) matchShardDataFiles() {
+	for _, dbMeta := range s.loader.dbs {
+		for _, tblMeta := range dbMeta.Tables {
+			if len(tblMeta.ShardSchemaFiles) == 0 {
+				continue
+			}
+			shardSchemas := make(map[filter.Table]FileInfo, len(tblMeta.ShardSchemaFiles))
+			for _, schemaFile := range tblMeta.ShardSchemaFiles {
+				shardSchemas[s.sourceTables[schemaFile.FileMeta.Path]] = schemaFile
+			}
+			for _, dataFile := range tblMeta.DataFiles {
+				schemaFile, ok := shardSchemas[s.sourceTables[dataFile.FileMeta.Path]]
+				if !ok {
+					continue
+				}
+				if tblMeta.ShardDataFiles == nil {
+					tblMeta.ShardDataFiles = make(map[string]FileInfo)
+				}
+				tblMeta.ShardDataFiles[dataFile.FileMeta.Path] = schemaFile
+			}
+		}
+	}
+}
+
 func (s *mdLoaderSetup) insertDB(dbName string, path string) (*MDDatabaseMeta, bool) {
 	dbIndex, ok := s.dbIndexMap[dbName]
 	if ok {
@@ -436,3 +1191,68 @@ func (l *MDLoader) GetDatabases() []*MDDatabaseMeta {
 func (l *MDLoader) GetStore() storage.ExternalStorage {
 	return l.store
 }
+
+// GetCharsetGuesses returns the detected charset of each source file, if
+// `mydumper.detect-charset` is enabled.
+func (l *MDLoader) GetCharsetGuesses() []FileCharset {
+	return l.charsetGuesses
+}
+
+// GetFilteredTables returns the tables excluded by the attribute-based
+// filters, with the reason of each decision.
+func (l *MDLoader) GetFilteredTables() []FilteredTable {
+	return l.filteredTables
+}
+
+// GetNewFilteredFiles returns the files filtered out by the file routers and
+// the table filters, except the known ones given to the loader.
+func (l *MDLoader) GetNewFilteredFiles() []string {
+	return l.newFilteredFiles
+}
+
+// FilterFingerprint returns the fingerprint of the config deciding which
+// source files and tables are filtered out, so the filtered files recorded in
+// the checkpoints are only reused under the same rules. The content of the
+// table tags file is included, since editing the tags changes the tables
+// selected by `include-tags`.
+func FilterFingerprint(cfg *config.Config) string {
+	rules := struct {
+		SourceDir          string
+		Filter             []string
+		BWList             filter.MySQLReplicationRules
+		CaseSensitive      bool
+		FileRouters        []*config.FileRouteRule
+		DefaultFileRules   bool
+		Routes             []*router.TableRule
+		Generations        []string
+		MaxTableSize       int64
+		ExcludeColumnTypes []string
+		IncludeTags        []string
+		TableTagsFile      string
+		TableTags          string
+	}{
+		SourceDir:          cfg.Mydumper.TaskSourceDir(),
+		Filter:             cfg.Mydumper.Filter,
+		BWList:             cfg.BWList,
+		CaseSensitive:      cfg.Mydumper.CaseSensitive,
+		FileRouters:        cfg.Mydumper.FileRouters,
+		DefaultFileRules:   cfg.Mydumper.DefaultFileRules,
+		Routes:             cfg.Routes,
+		Generations:        cfg.Mydumper.Generations,
+		MaxTableSize:       cfg.Mydumper.MaxTableSize,
+		ExcludeColumnTypes: cfg.Mydumper.ExcludeColumnTypes,
+		IncludeTags:        cfg.Mydumper.IncludeTags,
+		TableTagsFile:      cfg.Mydumper.TableTagsFile,
+	}
+	if len(cfg.Mydumper.TableTagsFile) > 0 {
+		// an unreadable file fails the loader anyway.
+		if data, err := ioutil.ReadFile(cfg.Mydumper.TableTagsFile); err == nil {
+			sum := sha256.Sum256(data)
+			rules.TableTags = hex.EncodeToString(sum[:])
+		}
+	}
+	// the rules are plain values, so the marshalling never fails.
+	data, _ := json.Marshal(rules)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -14,13 +14,18 @@
 package mydump_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
 
+	"github.com/golang/snappy"
+	"github.com/pingcap/br/pkg/storage"
 	. "github.com/pingcap/check"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	router "github.com/pingcap/tidb-tools/pkg/table-router"
@@ -129,6 +134,91 @@ func (s *testMydumpLoaderSuite) TestDuplicatedDB(c *C) {
 	c.Assert(err, ErrorMatches, `invalid database schema file, duplicated item - .*[/\\]db-schema-create\.sql`)
 }
 
+func (s *testMydumpLoaderSuite) TestMultipleSources(c *C) {
+	/*
+		Path/
+			db-schema-create.sql
+			db.t1-schema.sql
+			db.t1.sql
+		Other/
+			db-schema-create.sql
+			db.t2-schema.sql
+			db.t2.sql
+	*/
+	other := c.MkDir()
+	for _, name := range []string{"db-schema-create.sql", "db.t1-schema.sql", "db.t1.sql"} {
+		s.touch(c, name)
+	}
+	for _, name := range []string{"db-schema-create.sql", "db.t2-schema.sql", "db.t2.sql"} {
+		c.Assert(ioutil.WriteFile(filepath.Join(other, name), nil, 0644), IsNil)
+	}
+	s.cfg.Mydumper.SourceDirs = config.SourceDirs{s.cfg.Mydumper.SourceDir, "file://" + other}
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Name, Equals, "db")
+	tables := dbMetas[0].Tables
+	c.Assert(tables, HasLen, 2)
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	c.Assert(tables[0].Name, Equals, "t1")
+	c.Assert(tables[0].SchemaFile.FileMeta.Path, Equals, "#0/db.t1-schema.sql")
+	c.Assert(tables[0].DataFiles[0].FileMeta.Path, Equals, "#0/db.t1.sql")
+	c.Assert(tables[1].Name, Equals, "t2")
+	c.Assert(tables[1].SchemaFile.FileMeta.Path, Equals, "#1/db.t2-schema.sql")
+	c.Assert(tables[1].DataFiles[0].FileMeta.Path, Equals, "#1/db.t2.sql")
+
+	// the same table in both data sources.
+	c.Assert(ioutil.WriteFile(filepath.Join(other, "db.t1.000000001.sql"), nil, 0644), IsNil)
+	_, err = md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, ErrorMatches, "list file failed: table `db`.`t1` is found in both data sources 'file://.*' and 'file://.*' - #1/db.t1.000000001.sql")
+}
+
+func (s *testMydumpLoaderSuite) TestManifest(c *C) {
+	/*
+		Path/
+			db-schema-create.sql
+			db.t-schema.sql
+			export/part-0001.csv
+			export/part-0002.csv
+			db.unlisted.sql
+	*/
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.t-schema.sql")
+	s.touch(c, "db.unlisted.sql")
+	s.mkdir(c, "export")
+	s.touch(c, "export", "part-0001.csv")
+	s.touch(c, "export", "part-0002.csv")
+
+	// the files without the types are routed by the rules.
+	manifest := filepath.Join(c.MkDir(), "manifest.csv")
+	err := ioutil.WriteFile(manifest, []byte(`path,size,type,schema,table,key
+export/part-0002.csv,200,csv,db,t,2
+export/part-0001.csv,100,csv,db,t,1
+db.t-schema.sql,30,,,,
+db-schema-create.sql,20,,,,
+`), 0644)
+	c.Assert(err, IsNil)
+	s.cfg.Mydumper.ManifestFile = manifest
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Name, Equals, "db")
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	table := dbMetas[0].Tables[0]
+	c.Assert(table.Name, Equals, "t")
+	c.Assert(table.SchemaFile.FileMeta.Path, Equals, "db.t-schema.sql")
+	c.Assert(table.DataFiles, HasLen, 2)
+	c.Assert(table.DataFiles[0].FileMeta.Path, Equals, "export/part-0001.csv")
+	c.Assert(table.DataFiles[0].FileMeta.Type, Equals, md.SourceTypeCSV)
+	c.Assert(table.DataFiles[0].Size, Equals, int64(100))
+	c.Assert(table.DataFiles[1].FileMeta.Path, Equals, "export/part-0002.csv")
+	c.Assert(table.TotalSize, Equals, int64(300))
+}
+
 func (s *testMydumpLoaderSuite) TestTableNoHostDB(c *C) {
 	/*
 		Path/
@@ -252,6 +342,34 @@ func (s *testMydumpLoaderSuite) TestTablesWithDots(c *C) {
 	}})
 }
 
+func (s *testMydumpLoaderSuite) TestPostSchema(c *C) {
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.tbl-schema.sql")
+	s.touch(c, "db.tbl.sql")
+	s.touch(c, "db-schema-post.sql")
+	s.touch(c, "db.tbl-schema-post.sql")
+	s.touch(c, "other-schema-post.sql")
+
+	// the post schema files are ignored by default.
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].PostSchemaFiles, HasLen, 0)
+
+	s.cfg.PostRestore.ExecPostSchema = true
+	mdl, err = md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	dbs = mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Name, Equals, "db")
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	c.Assert(dbs[0].PostSchemaFiles, DeepEquals, []md.FileInfo{
+		{TableName: filter.Table{Schema: "db"}, FileMeta: md.SourceFileMeta{Path: "db-schema-post.sql", Type: md.SourceTypeSchemaPost}},
+		{TableName: filter.Table{Schema: "db", Name: "tbl"}, FileMeta: md.SourceFileMeta{Path: "db.tbl-schema-post.sql", Type: md.SourceTypeSchemaPost}},
+	})
+}
+
 func (s *testMydumpLoaderSuite) TestRouter(c *C) {
 	s.cfg.Routes = []*router.TableRule{
 		{
@@ -334,6 +452,14 @@ func (s *testMydumpLoaderSuite) TestRouter(c *C) {
 						{TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a0.t1.1.sql", Type: md.SourceTypeSQL, SortKey: "1"}},
 						{TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a1.t2.1.sql", Type: md.SourceTypeSQL, SortKey: "1"}},
 					},
+					ShardSchemaFiles: []md.FileInfo{
+						{TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a0.t1-schema.sql", Type: md.SourceTypeTableSchema}},
+						{TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a1.t2-schema.sql", Type: md.SourceTypeTableSchema}},
+					},
+					ShardDataFiles: map[string]md.FileInfo{
+						"a0.t1.1.sql": {TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a0.t1-schema.sql", Type: md.SourceTypeTableSchema}},
+						"a1.t2.1.sql": {TableName: filter.Table{Schema: "b", Name: "u"}, FileMeta: md.SourceFileMeta{Path: "a1.t2-schema.sql", Type: md.SourceTypeTableSchema}},
+					},
 				},
 			},
 		},
@@ -437,3 +563,453 @@ func (s *testMydumpLoaderSuite) TestFileRouting(c *C) {
 		},
 	})
 }
+
+func (s *testMydumpLoaderSuite) TestMixedFormatRouting(c *C) {
+	// the daily CSV appends are restored into the table of the SQL dump.
+	s.cfg.Mydumper.FileRouters = []*config.FileRouteRule{
+		{
+			Pattern:    `^daily/([^/.]+)\.([^/.]+)\.([0-9]+)\.csv$`,
+			Schema:     "$1",
+			Table:      "$2",
+			Type:       "csv",
+			Key:        "$3",
+			Directives: "separator='|', header=false",
+		},
+	}
+
+	s.mkdir(c, "daily")
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.t-schema.sql")
+	s.touch(c, "db.t.000000000.sql")
+	s.touch(c, "daily/db.t.20201110.csv")
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	dbs := mdl.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	fileMetas := make(map[string]md.SourceFileMeta)
+	for _, dataFile := range dbs[0].Tables[0].DataFiles {
+		fileMetas[dataFile.FileMeta.Path] = dataFile.FileMeta
+	}
+	c.Assert(fileMetas, DeepEquals, map[string]md.SourceFileMeta{
+		"db.t.000000000.sql":      {Path: "db.t.000000000.sql", Type: md.SourceTypeSQL, SortKey: "000000000"},
+		"daily/db.t.20201110.csv": {Path: "daily/db.t.20201110.csv", Type: md.SourceTypeCSV, SortKey: "20201110", Directives: "separator='|', header=false"},
+	})
+}
+
+func (s *testMydumpLoaderSuite) TestAttributeFilter(c *C) {
+	/*
+		Path/
+			db-schema-create.sql
+			db.small-schema.sql
+			db.small.sql
+			db.big-schema.sql
+			db.big.sql
+			db.doc-schema.sql
+			db.doc.sql
+	*/
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	write("db.small-schema.sql", "CREATE TABLE small (a INT, b VARCHAR(10));")
+	write("db.small.sql", "INSERT INTO small VALUES (1, 'x');")
+	write("db.big-schema.sql", "CREATE TABLE big (a INT);")
+	write("db.big.sql", "INSERT INTO big VALUES (1), (2), (3), (4), (5), (6), (7), (8), (9), (10);")
+	write("db.doc-schema.sql", "CREATE TABLE doc (a INT, `payload` JSON);")
+	write("db.doc.sql", "INSERT INTO doc VALUES (1, '{}');")
+
+	s.cfg.Mydumper.MaxTableSize = 40
+	s.cfg.Mydumper.ExcludeColumnTypes = []string{"json"}
+	s.cfg.Mydumper.CharacterSet = "auto"
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	c.Assert(dbMetas[0].Tables[0].Name, Equals, "small")
+
+	filtered := mdl.GetFilteredTables()
+	c.Assert(filtered, HasLen, 2)
+	c.Assert(filtered[0].Table, Equals, filter.Table{Schema: "db", Name: "big"})
+	c.Assert(filtered[0].Reason, Matches, "total data size .* exceeds max-table-size 40")
+	c.Assert(filtered[1].Table, Equals, filter.Table{Schema: "db", Name: "doc"})
+	c.Assert(filtered[1].Reason, Equals, "column `payload` has excluded type json")
+}
+
+func (s *testMydumpLoaderSuite) TestTablePriority(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	write("db.small-schema.sql", "CREATE TABLE small (a INT);")
+	write("db.small.sql", "INSERT INTO small VALUES (1);")
+	write("db.big-schema.sql", "CREATE TABLE big (a INT);")
+	write("db.big.sql", "INSERT INTO big VALUES (1), (2), (3), (4), (5), (6);")
+	write("db.ref-schema.sql", "CREATE TABLE ref (a INT);")
+	write("db.ref.sql", "INSERT INTO ref VALUES (1), (2), (3), (4), (5), (6), (7), (8), (9);")
+
+	priorityFile := filepath.Join(c.MkDir(), "priority.toml")
+	err := ioutil.WriteFile(priorityFile, []byte("[DB]\nRef = 10\n"), 0644)
+	c.Assert(err, IsNil)
+	s.cfg.Mydumper.TablePriorityFile = priorityFile
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	tables := dbMetas[0].Tables
+	c.Assert(tables, HasLen, 3)
+	c.Assert(tables[0].Name, Equals, "ref")
+	c.Assert(tables[0].Priority, Equals, 10)
+	c.Assert(tables[1].Name, Equals, "small")
+	c.Assert(tables[2].Name, Equals, "big")
+}
+
+func (s *testMydumpLoaderSuite) TestTableGroups(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	write("db.orders_1-schema.sql", "CREATE TABLE orders_1 (a INT);")
+	write("db.Orders_2-schema.sql", "CREATE TABLE Orders_2 (a INT);")
+	write("db.items-schema.sql", "CREATE TABLE items (a INT);")
+	write("db.users-schema.sql", "CREATE TABLE users (a INT);")
+
+	s.cfg.Mydumper.TableGroups = []*config.TableGroup{
+		{Name: "orders", Tables: []string{"db.orders_*"}},
+		{Name: "all", Tables: []string{"db.*"}},
+	}
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	groups := make(map[string]string)
+	for _, tblMeta := range dbMetas[0].Tables {
+		groups[tblMeta.Name] = tblMeta.Group
+	}
+	c.Assert(groups, DeepEquals, map[string]string{
+		"orders_1": "orders",
+		"Orders_2": "orders",
+		"items":    "all",
+		"users":    "all",
+	})
+}
+
+func (s *testMydumpLoaderSuite) TestGenerations(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		c.Assert(err, IsNil)
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	write("db.t-schema.sql", "CREATE TABLE t (a INT PRIMARY KEY);")
+	write("db.t.1.sql", "INSERT INTO t VALUES (1);")
+	write("db.t.2.sql", "INSERT INTO t VALUES (2);")
+	write("a-incr/db-schema-create.sql", "CREATE DATABASE db;")
+	write("a-incr/db.t-schema.sql", "CREATE TABLE t (a INT PRIMARY KEY);")
+	write("a-incr/db.t.1.sql", "REPLACE INTO t VALUES (2);")
+
+	s.cfg.Mydumper.Generations = []string{"a-incr"}
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	tblMeta := dbMetas[0].Tables[0]
+	c.Assert(tblMeta.SchemaFile.FileMeta.Path, Equals, "db.t-schema.sql")
+	var paths []string
+	for _, dataFile := range tblMeta.DataFiles {
+		paths = append(paths, dataFile.FileMeta.Path)
+	}
+	c.Assert(paths, DeepEquals, []string{"db.t.1.sql", "db.t.2.sql", "a-incr/db.t.1.sql"})
+}
+
+func (s *testMydumpLoaderSuite) TestPreserveRowOrder(c *C) {
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.t-schema.sql")
+	s.touch(c, "db.t.2.sql")
+	s.touch(c, "db.t.10.sql")
+	s.touch(c, "db.t.1.sql")
+
+	dataPaths := func() []string {
+		mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+		c.Assert(err, IsNil)
+		var paths []string
+		for _, dataFile := range mdl.GetDatabases()[0].Tables[0].DataFiles {
+			paths = append(paths, dataFile.FileMeta.Path)
+		}
+		return paths
+	}
+	c.Assert(dataPaths(), DeepEquals, []string{"db.t.1.sql", "db.t.10.sql", "db.t.2.sql"})
+
+	s.cfg.Mydumper.PreserveRowOrder = true
+	c.Assert(dataPaths(), DeepEquals, []string{"db.t.1.sql", "db.t.2.sql", "db.t.10.sql"})
+}
+
+func (s *testMydumpLoaderSuite) TestDetectCompression(c *C) {
+	dir := s.sourceDir
+	write := func(name string, content []byte) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", []byte("CREATE DATABASE db;"))
+	write("db.t-schema.sql", []byte("CREATE TABLE t (a INT);"))
+	write("db.t.1.sql", compress(c, md.CompressionGZ, []byte("INSERT INTO t VALUES (1);")))
+	write("db.t.2.sql", []byte("INSERT INTO t VALUES (2);"))
+	write("db.t.3.sql", compress(c, md.CompressionZStd, []byte("INSERT INTO t VALUES (3);")))
+
+	compressions := func() []md.Compression {
+		mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+		c.Assert(err, IsNil)
+		var compressions []md.Compression
+		for _, dataFile := range mdl.GetDatabases()[0].Tables[0].DataFiles {
+			compressions = append(compressions, dataFile.FileMeta.Compression)
+		}
+		return compressions
+	}
+	c.Assert(compressions(), DeepEquals, []md.Compression{md.CompressionNone, md.CompressionNone, md.CompressionNone})
+
+	s.cfg.Mydumper.DetectCompression = true
+	c.Assert(compressions(), DeepEquals, []md.Compression{md.CompressionGZ, md.CompressionNone, md.CompressionZStd})
+}
+
+func (s *testMydumpLoaderSuite) TestGetSchemaErr(c *C) {
+	schemaPath := filepath.Join(s.sourceDir, "db.t-schema.sql")
+	c.Assert(ioutil.WriteFile(filepath.Join(s.sourceDir, "db-schema-create.sql"), []byte("CREATE DATABASE db;"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(schemaPath, []byte("CREATE TABLE t (a INT);"), 0644), IsNil)
+	s.cfg.Mydumper.CharacterSet = "auto"
+
+	loadTable := func() (*md.MDTableMeta, storage.ExternalStorage) {
+		mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+		c.Assert(err, IsNil)
+		return mdl.GetDatabases()[0].Tables[0], mdl.GetStore()
+	}
+	tblMeta, store := loadTable()
+	uncached, _ := loadTable()
+	oversized, _ := loadTable()
+
+	schema, err := tblMeta.GetSchemaErr(context.Background(), store)
+	c.Assert(err, IsNil)
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT);")
+
+	// the schema is cached after the first read.
+	c.Assert(os.Remove(schemaPath), IsNil)
+	schema, err = tblMeta.GetSchemaErr(context.Background(), store)
+	c.Assert(err, IsNil)
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT);")
+	c.Assert(tblMeta.GetSchema(context.Background(), store), Equals, "CREATE TABLE t (a INT);")
+
+	// the failure is returned, and not cached.
+	_, err = uncached.GetSchemaErr(context.Background(), store)
+	c.Assert(err, ErrorMatches, "failed to extract table schema from db.t-schema.sql.*")
+	c.Assert(uncached.GetSchema(context.Background(), store), Equals, "")
+	c.Assert(ioutil.WriteFile(schemaPath, []byte("CREATE TABLE t (b INT);"), 0644), IsNil)
+	schema, err = uncached.GetSchemaErr(context.Background(), store)
+	c.Assert(err, IsNil)
+	c.Assert(schema, Equals, "CREATE TABLE t (b INT);")
+
+	// the pathological schema files are rejected without reading them.
+	oversized.SchemaFile.Size = md.MaxSchemaFileSize + 1
+	_, err = oversized.GetSchemaErr(context.Background(), store)
+	c.Assert(err, ErrorMatches, "the schema file db.t-schema.sql is too large \\(268435457 bytes\\), the limit is 268435456 bytes")
+}
+
+func (s *testMydumpLoaderSuite) TestDetectCharset(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	// "\xD7\xDC\xB0\xB8\xC0\xFD" is the GB-18030 encoding of "总案例".
+	write("db.t-schema.sql", "CREATE TABLE t (a VARCHAR(10) COMMENT '\xD7\xDC\xB0\xB8\xC0\xFD');")
+	write("db.t.sql", "INSERT INTO t VALUES ('caf\xe9'), ('r\xe9sum\xe9'), ('na\xefve');")
+
+	s.cfg.Mydumper.CharacterSet = "auto"
+	s.cfg.Mydumper.DetectCharset = true
+	s.cfg.Mydumper.CharsetConfidence = 0.8
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	guesses := mdl.GetCharsetGuesses()
+	c.Assert(guesses, HasLen, 2)
+	c.Assert(guesses[0].Path, Equals, "db.t-schema.sql")
+	c.Assert(guesses[0].Charset, Equals, "gb18030")
+	c.Assert(guesses[1].Path, Equals, "db.t.sql")
+	c.Assert(guesses[1].Charset, Equals, "latin1")
+
+	tblMeta := mdl.GetDatabases()[0].Tables[0]
+	c.Assert(tblMeta.GetSchema(context.Background(), mdl.GetStore()), Equals, "CREATE TABLE t (a VARCHAR(10) COMMENT '总案例');")
+
+	// a single non-ASCII byte is not enough to tell the charset.
+	write("db.t-schema.sql", "CREATE TABLE t (a VARCHAR(10) COMMENT 'caf\xe9');")
+	_, err = md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, ErrorMatches, "cannot detect the charset of db.t-schema.sql confidently, best guess is latin1 with confidence 0\\.6\\d.*")
+}
+
+func (s *testMydumpLoaderSuite) TestKnownFilteredFiles(c *C) {
+	s.touch(c, "db-schema-create.sql")
+	s.touch(c, "db.t-schema.sql")
+	s.touch(c, "db.t.sql")
+	s.touch(c, "other.u-schema.sql")
+	s.touch(c, "other.u.sql")
+	s.touch(c, "readme.txt")
+	s.cfg.Mydumper.Filter = []string{"db.*"}
+
+	ctx := context.Background()
+	mdl, err := md.NewMyDumpLoader(ctx, s.cfg)
+	c.Assert(err, IsNil)
+	filteredFiles := mdl.GetNewFilteredFiles()
+	sort.Strings(filteredFiles)
+	c.Assert(filteredFiles, DeepEquals, []string{"other.u-schema.sql", "other.u.sql", "readme.txt"})
+
+	known := make(map[string]struct{}, len(filteredFiles))
+	for _, path := range filteredFiles {
+		known[path] = struct{}{}
+	}
+	mdl, err = md.NewMyDumpLoaderWithFilteredFiles(ctx, s.cfg, mdl.GetStore(), known)
+	c.Assert(err, IsNil)
+	c.Assert(mdl.GetNewFilteredFiles(), HasLen, 0)
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	c.Assert(dbMetas[0].Tables[0].DataFiles, HasLen, 1)
+
+	// the fingerprint changes with the filter rules.
+	fingerprint := md.FilterFingerprint(s.cfg)
+	c.Assert(md.FilterFingerprint(s.cfg), Equals, fingerprint)
+	s.cfg.Mydumper.Filter = []string{"*.*"}
+	c.Assert(md.FilterFingerprint(s.cfg), Not(Equals), fingerprint)
+
+	// so do the attribute-based filters.
+	fingerprint = md.FilterFingerprint(s.cfg)
+	s.cfg.Mydumper.ExcludeColumnTypes = []string{"json"}
+	c.Assert(md.FilterFingerprint(s.cfg), Not(Equals), fingerprint)
+	fingerprint = md.FilterFingerprint(s.cfg)
+	s.cfg.Mydumper.MaxTableSize = 1 << 30
+	c.Assert(md.FilterFingerprint(s.cfg), Not(Equals), fingerprint)
+
+	// and the content of the table tags file.
+	tagsPath := filepath.Join(c.MkDir(), "tags.toml")
+	c.Assert(ioutil.WriteFile(tagsPath, []byte(`"db.t" = ["hot"]`), 0644), IsNil)
+	s.cfg.Mydumper.TableTagsFile = tagsPath
+	s.cfg.Mydumper.IncludeTags = []string{"hot"}
+	fingerprint = md.FilterFingerprint(s.cfg)
+	c.Assert(md.FilterFingerprint(s.cfg), Equals, fingerprint)
+	c.Assert(ioutil.WriteFile(tagsPath, []byte(`"db.t" = ["cold"]`), 0644), IsNil)
+	c.Assert(md.FilterFingerprint(s.cfg), Not(Equals), fingerprint)
+}
+
+func (s *testMydumpLoaderSuite) TestSnappyTotalSize(c *C) {
+	dir := s.sourceDir
+	write := func(name string, content []byte) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), content, 0644)
+		c.Assert(err, IsNil)
+	}
+	data := []byte(strings.Repeat("1,2,3\n", 1000))
+	var buf bytes.Buffer
+	w := snappy.NewBufferedWriter(&buf)
+	_, err := w.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	write("db-schema-create.sql", []byte("CREATE DATABASE db;"))
+	write("db.t-schema.sql", []byte("CREATE TABLE t (a INT, b INT, c INT);"))
+	write("db.t.0001.csv.snappy", buf.Bytes())
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+	tblMeta := mdl.GetDatabases()[0].Tables[0]
+	c.Assert(tblMeta.DataFiles, HasLen, 1)
+	c.Assert(tblMeta.DataFiles[0].FileMeta.Compression, Equals, md.CompressionSnappy)
+	c.Assert(tblMeta.DataFiles[0].Size, Less, int64(len(data)))
+	// the file is smaller than the sample, so the estimate is exact.
+	c.Assert(tblMeta.TotalSize, Equals, int64(len(data)))
+}
+
+func (s *testMydumpLoaderSuite) TestAttributeFilterUnparsableSchema(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	// the parser may not understand the spatial index, the type is still
+	// found by the text search.
+	write("db.geo-schema.sql", "CREATE TABLE geo (a INT, g GEOMETRY NOT NULL SRID 4326, SPATIAL KEY (g));")
+	write("db.geo.sql", "INSERT INTO geo VALUES (1, NULL);")
+	// the column named after the type is not mistaken for the type.
+	write("db.named-schema.sql", "CREATE TABLE named (a INT, `geometry` INT) SOMETHING WRONG;")
+	write("db.named.sql", "INSERT INTO named VALUES (1, 2);")
+
+	s.cfg.Mydumper.ExcludeColumnTypes = []string{"geometry"}
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	c.Assert(dbMetas[0].Tables[0].Name, Equals, "named")
+
+	filtered := mdl.GetFilteredTables()
+	c.Assert(filtered, HasLen, 1)
+	c.Assert(filtered[0].Table, Equals, filter.Table{Schema: "db", Name: "geo"})
+	c.Assert(filtered[0].Reason, Matches, ".*excluded type geometry")
+}
+
+func (s *testMydumpLoaderSuite) TestTagFilter(c *C) {
+	dir := s.sourceDir
+	write := func(name, content string) {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	for _, tbl := range []string{"hot", "cold", "untagged"} {
+		write("db."+tbl+"-schema.sql", "CREATE TABLE "+tbl+" (a INT);")
+		write("db."+tbl+".sql", "INSERT INTO "+tbl+" VALUES (1);")
+	}
+	tagsFile := filepath.Join(c.MkDir(), "tags.toml")
+	err := ioutil.WriteFile(tagsFile, []byte(`
+[db]
+hot = ["daily", "small"]
+cold = ["archive"]
+`), 0644)
+	c.Assert(err, IsNil)
+
+	s.cfg.Mydumper.TableTagsFile = tagsFile
+	s.cfg.Mydumper.IncludeTags = []string{"daily"}
+
+	mdl, err := md.NewMyDumpLoader(context.Background(), s.cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Tables, HasLen, 1)
+	c.Assert(dbMetas[0].Tables[0].Name, Equals, "hot")
+
+	filtered := mdl.GetFilteredTables()
+	c.Assert(filtered, HasLen, 2)
+	c.Assert(filtered[0].Table, Equals, filter.Table{Schema: "db", Name: "cold"})
+	c.Assert(filtered[0].Reason, Equals, "tags archive do not match include-tags daily")
+	c.Assert(filtered[1].Table, Equals, filter.Table{Schema: "db", Name: "untagged"})
+	c.Assert(filtered[1].Reason, Equals, "table has no tags, include-tags is daily")
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb-tools/pkg/filter"
+)
+
+// ManifestEntry is a source file listed in the manifest file.
+type ManifestEntry struct {
+	// Path is relative to the data source, or qualified by the index of the
+	// data source if there are more than one, see SourcePath.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Type is the source type as in the file routing rules. The file listed
+	// without a type is routed by the rules.
+	Type   string `json:"type"`
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// Compression is inferred from the extension of the path if not given.
+	Compression string `json:"compression"`
+	Key         string `json:"key"`
+}
+
+var manifestColumns = map[string]func(*ManifestEntry, string) error{
+	"path": func(e *ManifestEntry, v string) error { e.Path = v; return nil },
+	"size": func(e *ManifestEntry, v string) (err error) {
+		e.Size, err = strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		return errors.Annotatef(err, "invalid size '%s'", v)
+	},
+	"type":        func(e *ManifestEntry, v string) error { e.Type = v; return nil },
+	"schema":      func(e *ManifestEntry, v string) error { e.Schema = v; return nil },
+	"table":       func(e *ManifestEntry, v string) error { e.Table = v; return nil },
+	"compression": func(e *ManifestEntry, v string) error { e.Compression = v; return nil },
+	"key":         func(e *ManifestEntry, v string) error { e.Key = v; return nil },
+}
+
+// LoadManifest reads the manifest file listing the source files, which are
+// then not listed from the data source. The file is parsed as a JSON array of
+// the entries if the name ends with ".json", otherwise as CSV whose header
+// names the columns, e.g.
+//
+//	path,size,type,schema,table
+//	db-schema-create.sql,19,schema-schema,db,
+//	db.t-schema.sql,35,table-schema,db,t
+//	data/part-0001.csv.gz,1048576,csv,db,t
+//
+// The entries are sorted by the paths, so the files are visited in the same
+// order as listed from the data source.
+func LoadManifest(path string) ([]ManifestEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read manifest file '%s'", path)
+	}
+
+	var entries []ManifestEntry
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &entries)
+	} else {
+		entries, err = parseManifestCSV(data)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to parse manifest file '%s'", path)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.Errorf("no files are listed in manifest file '%s'", path)
+	}
+	for i := range entries {
+		if err := entries[i].validate(); err != nil {
+			return nil, errors.Annotatef(err, "invalid manifest file '%s'", path)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func parseManifestCSV(data []byte) ([]ManifestEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+	setters := make([]func(*ManifestEntry, string) error, 0, len(header))
+	columns := make(map[string]struct{}, len(header))
+	for _, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		setter, ok := manifestColumns[column]
+		if !ok {
+			return nil, errors.Errorf("unknown column '%s'", column)
+		}
+		setters = append(setters, setter)
+		columns[column] = struct{}{}
+	}
+	for _, column := range []string{"path", "size"} {
+		if _, ok := columns[column]; !ok {
+			return nil, errors.Errorf("missing column '%s'", column)
+		}
+	}
+
+	var entries []ManifestEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var entry ManifestEntry
+		for i, value := range record {
+			if err := setters[i](&entry, value); err != nil {
+				return nil, errors.Annotatef(err, "entry %d", len(entries)+1)
+			}
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func (e *ManifestEntry) validate() error {
+	if len(e.Path) == 0 {
+		return errors.New("the path of the file is missing")
+	}
+	if e.Size < 0 {
+		return errors.Errorf("the size of '%s' is negative", e.Path)
+	}
+	if len(e.Type) == 0 {
+		return nil
+	}
+	tp, err := parseSourceType(e.Type)
+	if err != nil {
+		return errors.Annotatef(err, "the type of '%s'", e.Path)
+	}
+	switch tp {
+	case SourceTypeIgnore:
+	case SourceTypeSchemaSchema, SourceTypeSchemaPost:
+		if len(e.Schema) == 0 {
+			return errors.Errorf("the schema of '%s' is missing", e.Path)
+		}
+	default:
+		if len(e.Schema) == 0 || len(e.Table) == 0 {
+			return errors.Errorf("the schema or the table of '%s' is missing", e.Path)
+		}
+	}
+	if _, err := parseCompressionType(e.Compression); err != nil {
+		return errors.Annotatef(err, "the compression of '%s'", e.Path)
+	}
+	return nil
+}
+
+// routeResult returns the routing result given by the entry, or nil if the
+// type is not given, where the file is routed by the file routing rules.
+func (e *ManifestEntry) routeResult() *RouteResult {
+	if len(e.Type) == 0 {
+		return nil
+	}
+	// the entry is validated when the manifest is loaded.
+	tp, _ := parseSourceType(e.Type)
+	compression, _ := parseCompressionType(e.Compression)
+	if len(e.Compression) == 0 {
+		if c, err := parseCompressionType(strings.TrimPrefix(filepath.Ext(e.Path), ".")); err == nil {
+			compression = c
+		}
+	}
+	return &RouteResult{
+		Table:       filter.Table{Schema: e.Schema, Name: e.Table},
+		Key:         e.Key,
+		Compression: compression,
+		Type:        tp,
+	}
+}
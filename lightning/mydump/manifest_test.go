@@ -0,0 +1,94 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb-tools/pkg/filter"
+)
+
+var _ = Suite(&testManifestSuite{})
+
+type testManifestSuite struct{}
+
+func (s *testManifestSuite) writeManifest(c *C, name string, content string) string {
+	path := filepath.Join(c.MkDir(), name)
+	c.Assert(ioutil.WriteFile(path, []byte(content), 0644), IsNil)
+	return path
+}
+
+func (s *testManifestSuite) TestLoadCSV(c *C) {
+	path := s.writeManifest(c, "manifest.csv", `Path, Size, Type, Schema, Table
+"data/db.t.2.sql.gz",200,sql,db,t
+data/db.t.1.sql.gz,100,sql,db,t
+db-schema-create.sql,20,,,
+`)
+	entries, err := LoadManifest(path)
+	c.Assert(err, IsNil)
+	c.Assert(entries, DeepEquals, []ManifestEntry{
+		{Path: "data/db.t.1.sql.gz", Size: 100, Type: "sql", Schema: "db", Table: "t"},
+		{Path: "data/db.t.2.sql.gz", Size: 200, Type: "sql", Schema: "db", Table: "t"},
+		{Path: "db-schema-create.sql", Size: 20},
+	})
+
+	// the compression is inferred from the extension.
+	c.Assert(entries[0].routeResult(), DeepEquals, &RouteResult{
+		Table:       filter.Table{Schema: "db", Name: "t"},
+		Compression: CompressionGZ,
+		Type:        SourceTypeSQL,
+	})
+	c.Assert(entries[2].routeResult(), IsNil)
+}
+
+func (s *testManifestSuite) TestLoadJSON(c *C) {
+	path := s.writeManifest(c, "manifest.json", `[
+		{"path": "#1/part-0001.csv", "size": 100, "type": "csv", "schema": "db", "table": "t", "compression": "zstd", "key": "1"},
+		{"path": "#0/db.t-schema.sql", "size": 30, "type": "table-schema", "schema": "db", "table": "t"}
+	]`)
+	entries, err := LoadManifest(path)
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries[0].Path, Equals, "#0/db.t-schema.sql")
+	c.Assert(entries[1].routeResult(), DeepEquals, &RouteResult{
+		Table:       filter.Table{Schema: "db", Name: "t"},
+		Key:         "1",
+		Compression: CompressionZStd,
+		Type:        SourceTypeCSV,
+	})
+}
+
+func (s *testManifestSuite) TestLoadInvalid(c *C) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"manifest.csv", "", "no files are listed in manifest file .*"},
+		{"manifest.csv", "path,type\na.sql,sql\n", "failed to parse manifest file .*: missing column 'size'"},
+		{"manifest.csv", "path,size,owner\na.sql,1,me\n", "failed to parse manifest file .*: unknown column 'owner'"},
+		{"manifest.csv", "path,size\na.sql,one\n", "failed to parse manifest file .*: entry 1: invalid size 'one'.*"},
+		{"manifest.csv", "path,size,type\na.sql,1,sql\n", "invalid manifest file .*: the schema or the table of 'a.sql' is missing"},
+		{"manifest.csv", "path,size,type,schema,table\na.dat,1,dat,db,t\n", "invalid manifest file .*: the type of 'a.dat': unknown source type 'dat'"},
+		{"manifest.json", `[{"path": "", "size": 1}]`, "invalid manifest file .*: the path of the file is missing"},
+		{"manifest.json", `[{"path": "a.sql", "size": -1}]`, "invalid manifest file .*: the size of 'a.sql' is negative"},
+		{"manifest.json", `[{"path": "a.sql", "size": 1, "type": "sql", "schema": "db", "table": "t", "compression": "rar"}]`,
+			"invalid manifest file .*: the compression of 'a.sql': invalid compression type 'rar'"},
+	} {
+		_, err := LoadManifest(s.writeManifest(c, tc.name, tc.content))
+		c.Assert(err, ErrorMatches, tc.err, Commentf("manifest %q", tc.content))
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// ColumnMasker replaces the value of a column field to anonymize the data.
+// All maskers are deterministic, so equal values are still equal after
+// masking, and joins across tables are preserved.
+type ColumnMasker func(data []byte) []byte
+
+type columnMaskerSpec struct {
+	masker ColumnMasker
+	// maxLen is the maximum length of the masked values, or 0 if the maskers
+	// keep the length of the values.
+	maxLen int
+	// decimalMasker, if not nil, masks the decimal columns keeping the values
+	// valid decimals of the same precision.
+	decimalMasker ColumnMasker
+}
+
+var columnMaskers = map[string]columnMaskerSpec{
+	config.ColumnMaskerHash:      {masker: maskHash, maxLen: 16},
+	config.ColumnMaskerRedact:    {masker: maskRedact},
+	config.ColumnMaskerShuffle:   {masker: maskShuffle, decimalMasker: maskShuffleDigits},
+	config.ColumnMaskerFakeEmail: {masker: maskFakeEmail, maxLen: len("user-0123456789abcdef@example.com")},
+	config.ColumnMaskerFakeName:  {masker: maskFakeName, maxLen: maxFakeNameLen},
+}
+
+// NewColumnMasker returns the built-in masker of the given name for the
+// column of the field type. The maskers produce strings, so they only accept
+// the string columns long enough for the masked values, except that shuffle
+// also accepts the decimal columns.
+func NewColumnMasker(name string, ft *types.FieldType) (ColumnMasker, error) {
+	spec, ok := columnMaskers[name]
+	if !ok {
+		return nil, errors.Errorf("unknown column masker '%s'", name)
+	}
+	switch {
+	case ft.Tp == mysql.TypeNewDecimal && spec.decimalMasker != nil:
+		return spec.decimalMasker, nil
+	case types.IsString(ft.Tp):
+		if spec.maxLen > 0 && ft.Flen > 0 && ft.Flen < spec.maxLen {
+			return nil, errors.Errorf("column masker '%s' produces values of up to %d characters, longer than the column type %s", name, spec.maxLen, ft.String())
+		}
+	default:
+		return nil, errors.Errorf("column masker '%s' does not support the column type %s", name, ft.String())
+	}
+	return spec.masker, nil
+}
+
+// MaskColumns applies the maskers on the row in place. The maskers are indexed
+// by the field position in the data file. NULL values are left unchanged.
+func MaskColumns(row []types.Datum, maskers map[int]ColumnMasker) {
+	for i, masker := range maskers {
+		if i >= len(row) || row[i].IsNull() {
+			continue
+		}
+		str, err := row[i].ToString()
+		if err != nil {
+			// not a value we know how to mask, erase it to be safe.
+			row[i].SetNull()
+			continue
+		}
+		row[i].SetBytes(masker([]byte(str)))
+	}
+}
+
+// maskHash replaces the value by the first 16 hex digits of its SHA-256.
+func maskHash(data []byte) []byte {
+	d := sha256.Sum256(data)
+	res := make([]byte, 16)
+	hex.Encode(res, d[:8])
+	return res
+}
+
+// maskRedact replaces every character by '*', keeping the length.
+func maskRedact(data []byte) []byte {
+	return []byte(strings.Repeat("*", utf8.RuneCount(data)))
+}
+
+// maskShuffle replaces every digit by another digit, and every letter by
+// another letter of the same case, keeping the format of values like phone
+// numbers and IDs.
+func maskShuffle(data []byte) []byte {
+	return shuffle(data, true)
+}
+
+// maskShuffleDigits replaces every digit by another digit, keeping the other
+// characters like the sign, the point and the exponent of numbers.
+func maskShuffleDigits(data []byte) []byte {
+	return shuffle(data, false)
+}
+
+func shuffle(data []byte, letters bool) []byte {
+	d := sha256.Sum256(data)
+	seed := binary.LittleEndian.Uint64(d[:8])
+	next := func(n uint64) uint64 {
+		// xorshift64*
+		seed ^= seed >> 12
+		seed ^= seed << 25
+		seed ^= seed >> 27
+		return (seed * 2685821657736338717) % n
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(data))
+	for _, r := range string(data) {
+		switch {
+		case r >= '0' && r <= '9':
+			sb.WriteByte(byte('0' + next(10)))
+		case !letters:
+			sb.WriteRune(r)
+		case r >= 'a' && r <= 'z':
+			sb.WriteByte(byte('a' + next(26)))
+		case r >= 'A' && r <= 'Z':
+			sb.WriteByte(byte('A' + next(26)))
+		case unicode.IsLetter(r):
+			sb.WriteByte('x')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return []byte(sb.String())
+}
+
+// maskFakeEmail replaces the value by an email address like
+// "user-1a2b3c4d5e6f7a8b@example.com". The 64-bit hash keeps the masked
+// values of a unique column unique in practice.
+func maskFakeEmail(data []byte) []byte {
+	d := sha256.Sum256(data)
+	return []byte(fmt.Sprintf("user-%x@example.com", d[:8]))
+}
+
+var (
+	fakeFirstNames = []string{"Alice", "Bob", "Carol", "David", "Erin", "Frank", "Grace", "Heidi", "Ivan", "Judy", "Mallory", "Niaj", "Olivia", "Peggy", "Rupert", "Sybil", "Trent", "Victor", "Walter", "Zoe"}
+	fakeLastNames  = []string{"Smith", "Johnson", "Brown", "Garcia", "Miller", "Davis", "Wilson", "Moore", "Taylor", "Thomas", "Lee", "Clark", "Lewis", "Walker", "Young", "King"}
+)
+
+// maxFakeNameLen is the length of the longest name, "Mallory Johnson", with
+// the suffix.
+const maxFakeNameLen = len("Mallory Johnson 0123456789abcdef")
+
+// maskFakeName replaces the value by a person name like "Alice Smith" with
+// a suffix of the 64-bit hash, e.g. "Alice Smith 1a2b3c4d5e6f7a8b", since the
+// few hundreds of the names alone would collide in a unique column.
+func maskFakeName(data []byte) []byte {
+	d := sha256.Sum256(data)
+	first := fakeFirstNames[int(d[8])%len(fakeFirstNames)]
+	last := fakeLastNames[int(d[9])%len(fakeLastNames)]
+	return []byte(fmt.Sprintf("%s %s %x", first, last, d[:8]))
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"fmt"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testColumnMaskSuite{})
+
+type testColumnMaskSuite struct{}
+
+var varcharType = types.NewFieldType(mysql.TypeVarchar)
+
+func (s *testColumnMaskSuite) mask(c *C, name string, value string) string {
+	masker, err := NewColumnMasker(name, varcharType)
+	c.Assert(err, IsNil)
+	return string(masker([]byte(value)))
+}
+
+func (s *testColumnMaskSuite) TestMaskers(c *C) {
+	hash := s.mask(c, "hash", "secret")
+	c.Assert(hash, Matches, "[0-9a-f]{16}")
+	c.Assert(s.mask(c, "hash", "secret"), Equals, hash)
+	c.Assert(s.mask(c, "hash", "secret2"), Not(Equals), hash)
+
+	c.Assert(s.mask(c, "redact", "密码abc"), Equals, "*****")
+
+	phone := s.mask(c, "shuffle", "+1 (555) 123-4567")
+	c.Assert(phone, Matches, `\+[0-9] \([0-9]{3}\) [0-9]{3}-[0-9]{4}`)
+	c.Assert(s.mask(c, "shuffle", "+1 (555) 123-4567"), Equals, phone)
+	c.Assert(s.mask(c, "shuffle", "Ab-12"), Matches, "[A-Z][a-z]-[0-9]{2}")
+
+	email := s.mask(c, "fake-email", "alice@pingcap.com")
+	c.Assert(email, Matches, "user-[0-9a-f]{16}@example.com")
+	c.Assert(s.mask(c, "fake-email", "alice@pingcap.com"), Equals, email)
+
+	c.Assert(s.mask(c, "fake-name", "John Doe"), Matches, "[A-Z][a-z]+ [A-Z][a-z]+ [0-9a-f]{16}")
+	c.Assert(s.mask(c, "fake-name", "John Doe"), Equals, s.mask(c, "fake-name", "John Doe"))
+
+	// the fake values of distinct values do not collide.
+	names := make(map[string]struct{})
+	for i := 0; i < 10000; i++ {
+		names[s.mask(c, "fake-name", fmt.Sprintf("name %d", i))] = struct{}{}
+	}
+	c.Assert(names, HasLen, 10000)
+
+	_, err := NewColumnMasker("rot13", varcharType)
+	c.Assert(err, ErrorMatches, "unknown column masker 'rot13'")
+}
+
+func (s *testColumnMaskSuite) TestMaskerColumnTypes(c *C) {
+	intType := types.NewFieldType(mysql.TypeLong)
+	_, err := NewColumnMasker("hash", intType)
+	c.Assert(err, ErrorMatches, "column masker 'hash' does not support the column type int.*")
+	_, err = NewColumnMasker("shuffle", types.NewFieldType(mysql.TypeDate))
+	c.Assert(err, ErrorMatches, "column masker 'shuffle' does not support the column type date.*")
+
+	shortType := types.NewFieldType(mysql.TypeVarchar)
+	shortType.Flen = 8
+	_, err = NewColumnMasker("fake-email", shortType)
+	c.Assert(err, ErrorMatches, "column masker 'fake-email' produces values of up to 33 characters, longer than the column type varchar\\(8\\).*")
+	_, err = NewColumnMasker("redact", shortType)
+	c.Assert(err, IsNil)
+
+	// the decimals are shuffled keeping the sign, the point and the exponent.
+	decimalType := types.NewFieldType(mysql.TypeNewDecimal)
+	masker, err := NewColumnMasker("shuffle", decimalType)
+	c.Assert(err, IsNil)
+	c.Assert(string(masker([]byte("-123.45e2"))), Matches, `-[0-9]{3}\.[0-9]{2}e[0-9]`)
+}
+
+func (s *testColumnMaskSuite) TestMaskColumns(c *C) {
+	redact, err := NewColumnMasker("redact", varcharType)
+	c.Assert(err, IsNil)
+
+	row := []types.Datum{
+		types.NewStringDatum("secret"),
+		types.NewDatum(nil),
+		types.NewIntDatum(12345),
+		types.NewStringDatum("public"),
+	}
+	MaskColumns(row, map[int]ColumnMasker{0: redact, 1: redact, 2: redact, 9: redact})
+
+	c.Assert(row[0].GetString(), Equals, "******")
+	c.Assert(row[1].IsNull(), IsTrue)
+	c.Assert(row[2].GetString(), Equals, "*****")
+	c.Assert(row[3].GetString(), Equals, "public")
+}
@@ -0,0 +1,402 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+const (
+	// msgpackLengthSize is the size of the big-endian length before every
+	// record.
+	msgpackLengthSize = 4
+	// msgpackMaxDepth limits the nesting of the arrays and the maps in a
+	// value.
+	msgpackMaxDepth = 64
+	// msgpackExtTimestamp is the extension type of the timestamps.
+	msgpackExtTimestamp = -1
+)
+
+var (
+	errMsgpackTruncated = errors.New("truncated msgpack value")
+	errMsgpackNotArray  = errors.New("each record must be a msgpack array")
+)
+
+// MsgpackParser reads the files of the MessagePack records, each of which is
+// an array of the values of a row, preceded by the length of the record as a
+// 4-byte big-endian integer. Like the CSV files without the header, the
+// values are in the order of the columns of the table. The position is the
+// byte offset of the next record, so an interrupted chunk resumes from the
+// record after the last one written.
+type MsgpackParser struct {
+	blockParser
+}
+
+// NewMsgpackParser creates a parser of the MessagePack records.
+func NewMsgpackParser(reader ReadSeekCloser, blockBufSize int64, ioWorkers *worker.Pool) *MsgpackParser {
+	return &MsgpackParser{
+		blockParser: makeBlockParser(reader, blockBufSize, ioWorkers),
+	}
+}
+
+// readRecord reads the next record without the length. The result is only
+// valid until the next read.
+func (parser *MsgpackParser) readRecord() ([]byte, error) {
+	for len(parser.buf) < msgpackLengthSize && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) == 0 {
+		return nil, io.EOF
+	}
+	if len(parser.buf) < msgpackLengthSize {
+		return nil, errors.Errorf("truncated msgpack record length at offset %d", parser.pos)
+	}
+
+	length := int(binary.BigEndian.Uint32(parser.buf))
+	size := msgpackLengthSize + length
+	for len(parser.buf) < size && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) < size {
+		return nil, errors.Errorf("truncated msgpack record at offset %d, expecting %d bytes but only %d remaining",
+			parser.pos, length, len(parser.buf)-msgpackLengthSize)
+	}
+	record := parser.buf[msgpackLengthSize:size]
+	parser.buf = parser.buf[size:]
+	parser.pos += int64(size)
+	return record, nil
+}
+
+// ReadRow reads a row from the datafile.
+func (parser *MsgpackParser) ReadRow() error {
+	start := parser.pos
+	record, err := parser.readRecord()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	dec := msgpackDecoder{data: record}
+	n, err := dec.readArrayHeader()
+	if err != nil {
+		return errors.Annotatef(err, "invalid msgpack record at offset %d", start)
+	}
+
+	row := &parser.lastRow
+	row.RowID++
+	row.Row = parser.acquireDatumSlice()
+	if cap(row.Row) >= n {
+		row.Row = row.Row[:n]
+	} else {
+		row.Row = make([]types.Datum, n)
+	}
+	for i := range row.Row {
+		if err := dec.readDatum(&row.Row[i]); err != nil {
+			return errors.Annotatef(err, "invalid msgpack record at offset %d, value %d", start, i)
+		}
+	}
+	if dec.pos != len(dec.data) {
+		return errors.Errorf("invalid msgpack record at offset %d, %d bytes after the array", start, len(dec.data)-dec.pos)
+	}
+	return nil
+}
+
+// msgpackDecoder decodes the values of a record.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (dec *msgpackDecoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(dec.data)-dec.pos {
+		return nil, errMsgpackTruncated
+	}
+	b := dec.data[dec.pos : dec.pos+n]
+	dec.pos += n
+	return b, nil
+}
+
+// readUint reads a big-endian unsigned integer of the size.
+func (dec *msgpackDecoder) readUint(size int) (uint64, error) {
+	b, err := dec.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	default:
+		return binary.BigEndian.Uint64(b), nil
+	}
+}
+
+// readLength reads the length of a value, and checks the remaining data is
+// at least minSize bytes per element.
+func (dec *msgpackDecoder) readLength(size int, minSize int) (int, error) {
+	n, err := dec.readUint(size)
+	if err != nil {
+		return 0, err
+	}
+	if n*uint64(minSize) > uint64(len(dec.data)-dec.pos) {
+		return 0, errMsgpackTruncated
+	}
+	return int(n), nil
+}
+
+func (dec *msgpackDecoder) readArrayHeader() (int, error) {
+	b, err := dec.take(1)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case b[0]&0xf0 == 0x90:
+		return int(b[0] & 0x0f), nil
+	case b[0] == 0xdc:
+		return dec.readLength(2, 1)
+	case b[0] == 0xdd:
+		return dec.readLength(4, 1)
+	default:
+		return 0, errMsgpackNotArray
+	}
+}
+
+// readDatum reads a value as a datum. Like the JSON values, the nested arrays
+// and maps are converted to the JSON text.
+func (dec *msgpackDecoder) readDatum(d *types.Datum) error {
+	v, err := dec.readValue(0)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		d.SetNull()
+	case bool:
+		if v {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case int64:
+		d.SetInt64(v)
+	case uint64:
+		d.SetUint64(v)
+	case float32:
+		d.SetFloat32(v)
+	case float64:
+		d.SetFloat64(v)
+	case string:
+		d.SetString(v, "")
+	case []byte:
+		d.SetBytes(v)
+	case time.Time:
+		d.SetString(v.Format("2006-01-02 15:04:05.999999999"), "")
+	default:
+		text, err := json.Marshal(v)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		d.SetString(string(text), "")
+	}
+	return nil
+}
+
+// readValue reads a value. The strings and the binaries are copied, so the
+// value outlives the record.
+func (dec *msgpackDecoder) readValue(depth int) (interface{}, error) {
+	b, err := dec.take(1)
+	if err != nil {
+		return nil, err
+	}
+	tag := b[0]
+	switch {
+	case tag <= 0x7f:
+		return int64(tag), nil
+	case tag >= 0xe0:
+		return int64(int8(tag)), nil
+	case tag&0xf0 == 0x80:
+		return dec.readMap(int(tag&0x0f), depth)
+	case tag&0xf0 == 0x90:
+		return dec.readArray(int(tag&0x0f), depth)
+	case tag&0xe0 == 0xa0:
+		return dec.readString(int(tag & 0x1f))
+	}
+
+	switch tag {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := dec.readLength(1<<(tag-0xc4), 1)
+		if err != nil {
+			return nil, err
+		}
+		data, err := dec.take(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), data...), nil
+	case 0xc7, 0xc8, 0xc9:
+		n, err := dec.readLength(1<<(tag-0xc7), 1)
+		if err != nil {
+			return nil, err
+		}
+		return dec.readExt(n)
+	case 0xca:
+		u, err := dec.readUint(4)
+		return math.Float32frombits(uint32(u)), err
+	case 0xcb:
+		u, err := dec.readUint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := dec.readUint(1 << (tag - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		if u <= math.MaxInt64 {
+			return int64(u), nil
+		}
+		return u, nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (tag - 0xd0)
+		u, err := dec.readUint(size)
+		if err != nil {
+			return nil, err
+		}
+		// sign-extend the integer of the size.
+		shift := uint(64 - size*8)
+		return int64(u<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return dec.readExt(1 << (tag - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := dec.readLength(1<<(tag-0xd9), 1)
+		if err != nil {
+			return nil, err
+		}
+		return dec.readString(n)
+	case 0xdc, 0xdd:
+		n, err := dec.readLength(2<<(tag-0xdc), 1)
+		if err != nil {
+			return nil, err
+		}
+		return dec.readArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := dec.readLength(2<<(tag-0xde), 2)
+		if err != nil {
+			return nil, err
+		}
+		return dec.readMap(n, depth)
+	default:
+		return nil, errors.Errorf("invalid msgpack type 0x%02x", tag)
+	}
+}
+
+func (dec *msgpackDecoder) readString(n int) (string, error) {
+	data, err := dec.take(n)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (dec *msgpackDecoder) readArray(n int, depth int) ([]interface{}, error) {
+	if depth >= msgpackMaxDepth {
+		return nil, errors.New("msgpack value nested too deeply")
+	}
+	values := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := dec.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// readMap reads a map as the JSON object, whose keys are the text of the keys.
+func (dec *msgpackDecoder) readMap(n int, depth int) (map[string]interface{}, error) {
+	if depth >= msgpackMaxDepth {
+		return nil, errors.New("msgpack value nested too deeply")
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := dec.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch key := key.(type) {
+		case string:
+			object[key] = value
+		case []byte:
+			object[string(key)] = value
+		default:
+			object[fmt.Sprint(key)] = value
+		}
+	}
+	return object, nil
+}
+
+// readExt reads the extension value of the data size. Only the timestamps
+// are supported.
+func (dec *msgpackDecoder) readExt(n int) (interface{}, error) {
+	b, err := dec.take(1)
+	if err != nil {
+		return nil, err
+	}
+	extType := int8(b[0])
+	data, err := dec.take(n)
+	if err != nil {
+		return nil, err
+	}
+	if extType != msgpackExtTimestamp {
+		return nil, errors.Errorf("unsupported msgpack extension type %d", extType)
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	default:
+		return nil, errors.Errorf("invalid msgpack timestamp of %d bytes", n)
+	}
+}
@@ -0,0 +1,112 @@
+package mydump_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testMydumpMsgpackParserSuite{})
+
+type testMydumpMsgpackParserSuite struct {
+	ioWorkers *worker.Pool
+}
+
+func (s *testMydumpMsgpackParserSuite) SetUpSuite(c *C) {
+	s.ioWorkers = worker.NewPool(context.Background(), 5, "test_msgpack")
+}
+
+// msgpackRecord prefixes the encoded record with its length.
+func msgpackRecord(record ...byte) string {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(record)))
+	return string(length) + string(record)
+}
+
+func msgpackString(s string) types.Datum {
+	return types.NewCollationStringDatum(s, "", 0)
+}
+
+func (s *testMydumpMsgpackParserSuite) TestReadRows(c *C) {
+	input := msgpackRecord(
+		0x97,
+		0x01,
+		0xa2, 'a', 'b',
+		0xc0,
+		0xc3,
+		0xfd,
+		0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xc4, 0x02, 0x00, 0xff,
+	) + msgpackRecord(
+		0x96,
+		0xcf, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xd1, 0xfe, 0xd4,
+		0xd9, 0x02, 0xc3, 0xa9,
+		0x81, 0xa1, 'k', 0x92, 0x01, 0xc2,
+		0xd6, 0xff, 0x5e, 0x0b, 0xe1, 0x00,
+		0xca, 0x3f, 0x00, 0x00, 0x00,
+	) + msgpackRecord(
+		0xdc, 0x00, 0x02,
+		0xd7, 0xff, 0x77, 0x35, 0x94, 0x00, 0x5e, 0x0b, 0xe1, 0x00,
+		0x90,
+	)
+
+	// the small block size makes the records span blocks.
+	parser := mydump.NewMsgpackParser(mydump.NewStringReader(input), 8, s.ioWorkers)
+	c.Assert(parser.Columns(), IsNil)
+
+	expected := [][]types.Datum{
+		{
+			types.NewIntDatum(1), msgpackString("ab"), nullDatum, types.NewIntDatum(1), types.NewIntDatum(-3),
+			types.NewFloat64Datum(1.5), types.NewBytesDatum([]byte{0x00, 0xff}),
+		},
+		{
+			types.NewUintDatum(math.MaxUint64), types.NewIntDatum(-300), msgpackString("é"), msgpackString(`{"k":[1,false]}`),
+			msgpackString("2020-01-01 00:00:00"), types.NewFloat32Datum(0.5),
+		},
+		{msgpackString("2020-01-01 00:00:00.5"), msgpackString("[]")},
+	}
+	positions := []int{25, 63, 81}
+	for i, row := range expected {
+		c.Assert(parser.ReadRow(), IsNil)
+		c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: int64(i) + 1, Row: row}, Commentf("row = %d", i+1))
+		c.Assert(parser, posEq, positions[i], i+1)
+	}
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpMsgpackParserSuite) TestSetPos(c *C) {
+	input := msgpackRecord(0x91, 0x01) + msgpackRecord(0x91, 0x02) + msgpackRecord(0x91, 0x03)
+	parser := mydump.NewMsgpackParser(mydump.NewStringReader(input), 1024, s.ioWorkers)
+	c.Assert(parser.SetPos(6, 5), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 6, Row: []types.Datum{types.NewIntDatum(2)}})
+	c.Assert(parser, posEq, 12, 6)
+}
+
+func (s *testMydumpMsgpackParserSuite) TestInvalidRecords(c *C) {
+	cases := []struct {
+		input string
+		err   string
+	}{
+		{"\x00\x00\x00\x0a\x91\x01\x02", "truncated msgpack record at offset 0, expecting 10 bytes but only 3 remaining"},
+		{"\x00\x00", "truncated msgpack record length at offset 0"},
+		{msgpackRecord(0x01), "invalid msgpack record at offset 0: each record must be a msgpack array"},
+		{msgpackRecord(0x91, 0x01, 0x02), "invalid msgpack record at offset 0, 1 bytes after the array"},
+		{msgpackRecord(0x92, 0x01, 0xa3, 'a'), "invalid msgpack record at offset 0, value 1: truncated msgpack value"},
+		{msgpackRecord(0x91, 0xd4, 0x05, 0x00), "invalid msgpack record at offset 0, value 0: unsupported msgpack extension type 5"},
+		{msgpackRecord(0x91, 0xc1), "invalid msgpack record at offset 0, value 0: invalid msgpack type 0xc1"},
+	}
+	for _, tc := range cases {
+		parser := mydump.NewMsgpackParser(mydump.NewStringReader(tc.input), 1024, s.ioWorkers)
+		c.Assert(parser.ReadRow(), ErrorMatches, tc.err, Commentf("input = %q", tc.input))
+	}
+}
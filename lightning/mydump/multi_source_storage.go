@@ -0,0 +1,154 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// sourcePathPrefix starts the paths qualified by the index of the data source
+// in `mydumper.data-source-dir`.
+const sourcePathPrefix = "#"
+
+// SourcePath qualifies the path relative to the data source by the index of
+// the data source, e.g. "#1/db.t.000000000.sql" is in the second one.
+func SourcePath(source int, path string) string {
+	return fmt.Sprintf("%s%d/%s", sourcePathPrefix, source, path)
+}
+
+// SplitSourcePath returns the index of the data source and the path relative
+// to it. The path not qualified, e.g. of the dump metadata, is in the first
+// data source.
+func SplitSourcePath(path string) (int, string) {
+	if !strings.HasPrefix(path, sourcePathPrefix) {
+		return 0, path
+	}
+	i := strings.IndexByte(path, '/')
+	if i < 0 {
+		return 0, path
+	}
+	source, err := strconv.Atoi(path[len(sourcePathPrefix):i])
+	if err != nil || source < 0 {
+		return 0, path
+	}
+	return source, path[i+1:]
+}
+
+// multiSourceStorage merges the data sources of `mydumper.data-source-dir`,
+// which may be of different schemes, into one storage. The files are listed
+// by their source-qualified paths, so the files of the same path in different
+// data sources are told apart, also in the checkpoints. The methods not
+// overridden are passed to the first data source.
+type multiSourceStorage struct {
+	storage.ExternalStorage
+	sources []storage.ExternalStorage
+}
+
+// NewMultiSourceStorage merges the data sources.
+func NewMultiSourceStorage(sources []storage.ExternalStorage) storage.ExternalStorage {
+	return &multiSourceStorage{ExternalStorage: sources[0], sources: sources}
+}
+
+func (s *multiSourceStorage) source(name string) (storage.ExternalStorage, string, error) {
+	source, path := SplitSourcePath(name)
+	if source >= len(s.sources) {
+		return nil, "", errors.Errorf("the data source of %s does not exist", name)
+	}
+	return s.sources[source], path, nil
+}
+
+func (s *multiSourceStorage) Write(ctx context.Context, name string, data []byte) error {
+	store, path, err := s.source(name)
+	if err != nil {
+		return err
+	}
+	return store.Write(ctx, path, data)
+}
+
+func (s *multiSourceStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	store, path, err := s.source(name)
+	if err != nil {
+		return nil, err
+	}
+	return store.Read(ctx, path)
+}
+
+func (s *multiSourceStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	store, path, err := s.source(name)
+	if err != nil {
+		return false, nil
+	}
+	return store.FileExists(ctx, path)
+}
+
+func (s *multiSourceStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	store, path, err := s.source(name)
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(ctx, path)
+}
+
+// WalkDir lists the files of the data sources in turn, by the qualified paths.
+func (s *multiSourceStorage) WalkDir(ctx context.Context, opt *storage.WalkOption, fn func(path string, size int64) error) error {
+	for i, store := range s.sources {
+		err := store.WalkDir(ctx, opt, func(path string, size int64) error {
+			return fn(SourcePath(i, path), size)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenDataSources opens the storage of `mydumper.data-source-dir`, merging the
+// data sources if there are more than one. The customer key and the S3 options
+// are only applied to the data sources supporting them.
+func OpenDataSources(ctx context.Context, m *config.MydumperRuntime, opts *storage.BackendOptions) (storage.ExternalStorage, error) {
+	customerKey, err := m.SourceCustomerKey()
+	if err != nil {
+		return nil, err
+	}
+	dirs := m.DataSources()
+	if len(dirs) == 1 {
+		return OpenStorage(ctx, dirs[0], opts, customerKey, &m.S3)
+	}
+
+	sources := make([]storage.ExternalStorage, 0, len(dirs))
+	for _, dir := range dirs {
+		var key []byte
+		var s3Cfg *config.S3Config
+		switch target := strings.TrimPrefix(dir, FaultySchemePrefix); {
+		case isS3URL(target):
+			key, s3Cfg = customerKey, &m.S3
+		case isGCSURL(target):
+			key = customerKey
+		}
+		store, err := OpenStorage(ctx, dir, opts, key, s3Cfg)
+		if err != nil {
+			return nil, errors.Annotatef(err, "open the data source '%s'", config.RedactURL(dir))
+		}
+		sources = append(sources, store)
+	}
+	return NewMultiSourceStorage(sources), nil
+}
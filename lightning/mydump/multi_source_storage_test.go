@@ -0,0 +1,98 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testMultiSourceSuite{})
+
+type testMultiSourceSuite struct{}
+
+func (s *testMultiSourceSuite) TestSplitSourcePath(c *C) {
+	c.Assert(SourcePath(1, "db.t.sql"), Equals, "#1/db.t.sql")
+
+	for _, tc := range []struct {
+		path   string
+		source int
+		rel    string
+	}{
+		{path: "#0/db.t.sql", source: 0, rel: "db.t.sql"},
+		{path: "#12/sub/#3/db.t.sql", source: 12, rel: "sub/#3/db.t.sql"},
+		{path: "metadata", source: 0, rel: "metadata"},
+		{path: "#a/db.t.sql", source: 0, rel: "#a/db.t.sql"},
+		{path: "#1", source: 0, rel: "#1"},
+	} {
+		source, rel := SplitSourcePath(tc.path)
+		c.Assert(source, Equals, tc.source, Commentf("path %s", tc.path))
+		c.Assert(rel, Equals, tc.rel, Commentf("path %s", tc.path))
+	}
+}
+
+func (s *testMultiSourceSuite) TestStorage(c *C) {
+	ctx := context.Background()
+	dirs := []string{c.MkDir(), c.MkDir()}
+	sources := make([]storage.ExternalStorage, 0, len(dirs))
+	for _, dir := range dirs {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.sql"), []byte(dir), 0644), IsNil)
+		source, err := storage.NewLocalStorage(dir)
+		c.Assert(err, IsNil)
+		sources = append(sources, source)
+	}
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs[0], "metadata"), []byte("Started dump at: 2020-11-11 11:11:11"), 0644), IsNil)
+	store := NewMultiSourceStorage(sources)
+
+	var paths []string
+	err := store.WalkDir(ctx, &storage.WalkOption{}, func(path string, size int64) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"#0/db.t.sql", "#0/metadata", "#1/db.t.sql"})
+
+	for i, dir := range dirs {
+		data, err := store.Read(ctx, SourcePath(i, "db.t.sql"))
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, dir)
+	}
+	reader, err := store.Open(ctx, "#1/db.t.sql")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, dirs[1])
+	c.Assert(reader.Close(), IsNil)
+
+	// the paths not qualified are in the first data source.
+	exists, err := store.FileExists(ctx, "metadata")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "#2/db.t.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+	_, err = store.Open(ctx, "#2/db.t.sql")
+	c.Assert(err, ErrorMatches, "the data source of #2/db.t.sql does not exist")
+
+	c.Assert(store.Write(ctx, "#1/checkpoint.pb", []byte("checkpoint")), IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(dirs[1], "checkpoint.pb"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "checkpoint")
+}
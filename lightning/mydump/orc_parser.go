@@ -0,0 +1,217 @@
+package mydump
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"github.com/scritchley/orc"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// orcReaderAt adapts the reader for the orc reader, which reads the footer and
+// the stripes at their offsets. The reader is closed by the parser, not by the
+// orc reader.
+type orcReaderAt struct {
+	reader ReadSeekCloser
+	size   int64
+}
+
+func (r *orcReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if _, err := r.reader.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r.reader, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (r *orcReaderAt) Size() int64 {
+	return r.size
+}
+
+// checkORCType returns an error if the values of the type cannot be converted
+// to datums.
+func checkORCType(typ *orc.TypeDescription) error {
+	name := typ.String()
+	for _, nested := range []string{"array<", "map<", "struct<", "uniontype<"} {
+		if strings.HasPrefix(name, nested) {
+			return errors.Errorf("unsupported orc type %s", name)
+		}
+	}
+	return nil
+}
+
+// setORCDatum converts the value read by the orc library into the datum.
+func setORCDatum(d *types.Datum, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		d.SetNull()
+	case bool:
+		if v {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case int64:
+		d.SetInt64(v)
+	case float32:
+		d.SetFloat32(v)
+	case float64:
+		d.SetFloat64(v)
+	case string:
+		d.SetString(v, "")
+	case []byte:
+		d.SetBytes(append([]byte(nil), v...))
+	case orc.Decimal:
+		d.SetString(formatScaledDecimal(v.Int, int(v.Scale)), "")
+	case orc.Date:
+		d.SetString(v.UTC().Format("2006-01-02"), "")
+	case time.Time:
+		// the timestamps are the wall clock time of the writer.
+		d.SetString(v.UTC().Format("2006-01-02 15:04:05.999999999"), "")
+	default:
+		return errors.Errorf("unsupported orc value %T", value)
+	}
+	return nil
+}
+
+// ORCParser reads the rows from an ORC file with the orc library. The root
+// type must be a struct, whose fields are the columns, and only the columns of
+// the primitive types are supported. Like the parquet files, the position is
+// the number of rows read.
+type ORCParser struct {
+	reader  ReadSeekCloser
+	file    *orc.Reader
+	cursor  *orc.Cursor
+	columns []string
+	numRows int64
+
+	pos     int64
+	lastRow Row
+	logger  log.Logger
+}
+
+func NewORCParser(reader ReadSeekCloser) (*ORCParser, error) {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	file, err := orc.NewReader(&orcReaderAt{reader: reader, size: size})
+	if err != nil {
+		return nil, errors.Annotate(err, "not an orc file")
+	}
+
+	schema := file.Schema()
+	names := schema.Columns()
+	fieldTypes := schema.Types()
+	if len(names) == 0 || len(names) != len(fieldTypes) {
+		return nil, errors.New("the root type of the orc file must be a struct")
+	}
+	columns := make([]string, 0, len(names))
+	for i, name := range names {
+		if err := checkORCType(fieldTypes[i]); err != nil {
+			return nil, errors.Annotatef(err, "column '%s'", name)
+		}
+		columns = append(columns, strings.ToLower(name))
+	}
+
+	return &ORCParser{
+		reader:  reader,
+		file:    file,
+		cursor:  file.Select(names...),
+		columns: columns,
+		numRows: int64(file.NumRows()),
+		logger:  log.L(),
+	}, nil
+}
+
+// next moves the cursor to the next row, reading the next stripe if the
+// current one is exhausted.
+func (p *ORCParser) next() error {
+	for !p.cursor.Next() {
+		if err := p.cursor.Err(); err != nil {
+			return errors.Annotatef(err, "failed to read orc row %d", p.pos)
+		}
+		if !p.cursor.Stripes() {
+			if err := p.cursor.Err(); err != nil {
+				return errors.Annotate(err, "failed to read orc stripe")
+			}
+			return io.EOF
+		}
+	}
+	return nil
+}
+
+// Pos returns the number of rows read from the orc file.
+func (p *ORCParser) Pos() (pos int64, rowID int64) {
+	return p.pos, p.lastRow.RowID
+}
+
+// SetPos skips the rows before pos. The skipped rows are still decoded by the
+// cursor of the orc library. Seeking back is not supported.
+func (p *ORCParser) SetPos(pos int64, rowID int64) error {
+	if pos < p.pos {
+		return errors.Errorf("cannot seek back orc file from %d to %d", p.pos, pos)
+	}
+	p.lastRow.RowID = rowID
+	for p.pos < pos {
+		if err := p.next(); err != nil {
+			return errors.Trace(err)
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *ORCParser) Close() error {
+	return p.reader.Close()
+}
+
+func (p *ORCParser) ReadRow() error {
+	if err := p.next(); err != nil {
+		return err
+	}
+
+	values := p.cursor.Row()
+	p.lastRow.RowID++
+	if cap(p.lastRow.Row) < len(values) {
+		p.lastRow.Row = make([]types.Datum, len(values))
+	} else {
+		p.lastRow.Row = p.lastRow.Row[:len(values)]
+	}
+	for i, value := range values {
+		if err := setORCDatum(&p.lastRow.Row[i], value); err != nil {
+			return errors.Annotatef(err, "failed to decode column '%s' at row %d", p.columns[i], p.pos)
+		}
+	}
+	p.pos++
+	return nil
+}
+
+func (p *ORCParser) LastRow() Row {
+	return p.lastRow
+}
+
+func (p *ORCParser) RecycleRow(row Row) {
+}
+
+// Columns returns the _lower-case_ column names corresponding to values in
+// the LastRow.
+func (p *ORCParser) Columns() []string {
+	return p.columns
+}
+
+// SetColumns set restored column names to parser
+func (p *ORCParser) SetColumns(cols []string) {
+	// just do nothing
+}
+
+func (p *ORCParser) SetLogger(l log.Logger) {
+	p.logger = l
+}
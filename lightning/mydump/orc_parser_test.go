@@ -0,0 +1,182 @@
+package mydump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+	"github.com/scritchley/orc"
+)
+
+type testORCParserSuite struct{}
+
+var _ = Suite(testORCParserSuite{})
+
+const testORCSchema = "struct<ID:bigint,name:string,score:double,color:varchar(10),amount:decimal(10,2),born:date,at:timestamp,ok:boolean>"
+
+// writeORCFile writes the rows into an ORC file with the writer of the orc
+// library.
+func writeORCFile(c *C, path string, schema string, codec orc.CompressionCodec, rows [][]interface{}) {
+	typ, err := orc.ParseSchema(schema)
+	c.Assert(err, IsNil)
+	f, err := os.Create(path)
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	// the small stripes make the rows spread over several stripes.
+	w, err := orc.NewWriter(f, orc.SetSchema(typ), orc.SetCompression(codec), orc.SetStripeTargetSize(256))
+	c.Assert(err, IsNil)
+	for _, row := range rows {
+		c.Assert(w.Write(row...), IsNil)
+	}
+	c.Assert(w.Close(), IsNil)
+}
+
+// writeORCTestFile writes 10 rows, with the score of the odd rows only.
+func writeORCTestFile(c *C, dir string, name string, codec orc.CompressionCodec) {
+	colors := []string{"RED", "GREEN"}
+	rows := make([][]interface{}, 0, 10)
+	for i := 0; i < 10; i++ {
+		var score interface{}
+		if i%2 == 1 {
+			score = float64(i) * 1.5
+		}
+		rows = append(rows, []interface{}{
+			int64(i),
+			"n" + strconv.Itoa(i),
+			score,
+			colors[i%2],
+			orc.Decimal{Int: big.NewInt(int64(-1234 * i)), Scale: 2},
+			orc.Date{Time: time.Date(1970, 1, i+1, 0, 0, 0, 0, time.UTC)},
+			time.Date(2015, 1, 1, 0, 0, i, 123000000, time.UTC),
+			i%3 == 0,
+		})
+	}
+	writeORCFile(c, filepath.Join(dir, name), testORCSchema, codec, rows)
+}
+
+func (s testORCParserSuite) TestORCParser(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	colors := []string{"RED", "GREEN"}
+	codecs := map[string]orc.CompressionCodec{
+		"none":   orc.CompressionNone{},
+		"zlib":   orc.CompressionZlib{},
+		"snappy": orc.CompressionSnappy{},
+	}
+	for compression, codec := range codecs {
+		name := fmt.Sprintf("db.tbl.%s.orc", compression)
+		writeORCTestFile(c, dir, name, codec)
+
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		reader, err := NewORCParser(r)
+		c.Assert(err, IsNil)
+
+		c.Assert(reader.Columns(), DeepEquals, []string{"id", "name", "score", "color", "amount", "born", "at", "ok"})
+
+		verifyRow := func(i int) {
+			comment := Commentf("compression: %s, row: %d", compression, i)
+			pos, rowID := reader.Pos()
+			c.Assert(pos, Equals, int64(i+1), comment)
+			c.Assert(rowID, Equals, int64(i+1), comment)
+			row := reader.LastRow().Row
+			c.Assert(row, HasLen, 8, comment)
+			c.Assert(row[0], DeepEquals, types.NewIntDatum(int64(i)), comment)
+			c.Assert(row[1], DeepEquals, types.NewCollationStringDatum("n"+strconv.Itoa(i), "", 0), comment)
+			if i%2 == 0 {
+				c.Assert(row[2].IsNull(), IsTrue, comment)
+			} else {
+				c.Assert(row[2], DeepEquals, types.NewFloat64Datum(float64(i)*1.5), comment)
+			}
+			c.Assert(row[3], DeepEquals, types.NewCollationStringDatum(colors[i%2], "", 0), comment)
+			amount := fmt.Sprintf("%.2f", float64(-1234*i)/100)
+			c.Assert(row[4], DeepEquals, types.NewCollationStringDatum(amount, "", 0), comment)
+			c.Assert(row[5], DeepEquals, types.NewCollationStringDatum(fmt.Sprintf("1970-01-%02d", i+1), "", 0), comment)
+			c.Assert(row[6], DeepEquals, types.NewCollationStringDatum(fmt.Sprintf("2015-01-01 00:00:%02d.123", i), "", 0), comment)
+			ok := int64(0)
+			if i%3 == 0 {
+				ok = 1
+			}
+			c.Assert(row[7], DeepEquals, types.NewIntDatum(ok), comment)
+		}
+
+		for i := 0; i < 3; i++ {
+			c.Assert(reader.ReadRow(), IsNil)
+			verifyRow(i)
+		}
+
+		c.Assert(reader.SetPos(6, 6), IsNil)
+		for i := 6; i < 10; i++ {
+			c.Assert(reader.ReadRow(), IsNil)
+			verifyRow(i)
+		}
+		c.Assert(reader.ReadRow(), Equals, io.EOF)
+		c.Assert(reader.SetPos(2, 2), ErrorMatches, "cannot seek back orc file from 10 to 2")
+		c.Assert(reader.Close(), IsNil)
+
+		// skip from the beginning of the file.
+		r, err = store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		reader, err = NewORCParser(r)
+		c.Assert(err, IsNil)
+		c.Assert(reader.SetPos(8, 8), IsNil)
+		c.Assert(reader.ReadRow(), IsNil)
+		verifyRow(8)
+		c.Assert(reader.Close(), IsNil)
+	}
+}
+
+func (s testORCParserSuite) TestMakeORCFileRegion(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	writeORCTestFile(c, dir, "db.tbl.orc", orc.CompressionZlib{})
+
+	meta := &MDTableMeta{DB: "db", Name: "tbl"}
+	dataFile := FileInfo{FileMeta: SourceFileMeta{Path: "db.tbl.orc", Type: SourceTypeORC}}
+	rowIDMax, region, err := makeORCFileRegion(context.TODO(), store, meta, dataFile, 5)
+	c.Assert(err, IsNil)
+	c.Assert(rowIDMax, Equals, int64(15))
+	c.Assert(region.Chunk, DeepEquals, Chunk{
+		Offset:       0,
+		EndOffset:    10,
+		PrevRowIDMax: 5,
+		RowIDMax:     15,
+	})
+}
+
+func (s testORCParserSuite) TestInvalidORCFile(c *C) {
+	dir := c.MkDir()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	f, err := os.Create(filepath.Join(dir, "not-orc"))
+	c.Assert(err, IsNil)
+	_, err = f.WriteString("INSERT INTO t VALUES (1);")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+	writeORCFile(c, filepath.Join(dir, "nested"), "struct<a:map<string,int>>", orc.CompressionNone{}, nil)
+
+	expected := map[string]string{
+		"not-orc": "not an orc file.*",
+		"nested":  "column 'a': unsupported orc type map.*",
+	}
+	for name, msg := range expected {
+		r, err := store.Open(context.TODO(), name)
+		c.Assert(err, IsNil)
+		_, err = NewORCParser(r)
+		c.Assert(err, ErrorMatches, msg)
+		c.Assert(r.Close(), IsNil)
+	}
+}
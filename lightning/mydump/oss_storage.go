@@ -0,0 +1,355 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	ossEndpoint        = "endpoint"
+	ossRegion          = "region"
+	ossInternal        = "internal"
+	ossAccessKeyID     = "access-key-id"
+	ossAccessKeySecret = "access-key-secret"
+	ossSecurityToken   = "security-token"
+	ossRAMRole         = "ram-role"
+
+	// ossListPageSize is the number of the objects listed in each request.
+	ossListPageSize = 1000
+)
+
+// ossMetadataEndpoint is the instance metadata service of ECS, which issues
+// the STS credentials of the RAM role attached to the instance.
+var ossMetadataEndpoint = "http://100.100.100.200/latest/meta-data/ram/security-credentials/"
+
+// isOSSURL returns whether the data source is in Alibaba Cloud OSS, i.e.
+// `oss://bucket/path`.
+func isOSSURL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "oss://")
+}
+
+// OSSOptions locates the bucket and the credentials of the data source in
+// Alibaba Cloud OSS.
+type OSSOptions struct {
+	Bucket string
+	Prefix string
+	// Endpoint is the URL of the OSS service, which defaults to
+	// `https://oss-<region>.aliyuncs.com`, or the internal endpoint
+	// `https://oss-<region>-internal.aliyuncs.com` free of the traffic
+	// charge from the ECS instances in the same region.
+	Endpoint string
+	Region   string
+	Internal bool
+	// AccessKeyID, AccessKeySecret and SecurityToken are the static
+	// credentials. The security token is required by the temporary STS
+	// credentials.
+	AccessKeyID     string
+	AccessKeySecret string
+	SecurityToken   string
+	// RAMRole is the RAM role attached to the ECS instance, of which the STS
+	// credentials are fetched from the instance metadata and refreshed
+	// before they expire. It is used without the access key.
+	RAMRole string
+}
+
+// ParseOSSURL parses the options from the data source URL, e.g.
+//
+//	oss://bucket/path?region=cn-hangzhou&internal=true&ram-role=lightning
+//
+// The access key ID, the access key secret and the security token default
+// to the environment variables ALIBABA_CLOUD_ACCESS_KEY_ID,
+// ALIBABA_CLOUD_ACCESS_KEY_SECRET and ALIBABA_CLOUD_SECURITY_TOKEN
+// respectively.
+func ParseOSSURL(sourceDir string) (*OSSOptions, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("missing the bucket of the oss url")
+	}
+	query := u.Query()
+	param := func(name string, env string) string {
+		if value := query.Get(name); len(value) > 0 {
+			return value
+		}
+		return os.Getenv(env)
+	}
+
+	opts := &OSSOptions{
+		Bucket:          u.Host,
+		Prefix:          strings.Trim(u.Path, "/"),
+		Endpoint:        query.Get(ossEndpoint),
+		Region:          query.Get(ossRegion),
+		AccessKeyID:     param(ossAccessKeyID, "ALIBABA_CLOUD_ACCESS_KEY_ID"),
+		AccessKeySecret: param(ossAccessKeySecret, "ALIBABA_CLOUD_ACCESS_KEY_SECRET"),
+		SecurityToken:   param(ossSecurityToken, "ALIBABA_CLOUD_SECURITY_TOKEN"),
+		RAMRole:         query.Get(ossRAMRole),
+	}
+	if internal := query.Get(ossInternal); len(internal) > 0 {
+		opts.Internal, err = strconv.ParseBool(internal)
+		if err != nil {
+			return nil, errors.Errorf("invalid oss internal '%s'", internal)
+		}
+	}
+	if len(opts.Endpoint) == 0 {
+		if len(opts.Region) == 0 {
+			return nil, errors.New("missing the region of the oss bucket, please set `region` or `endpoint`")
+		}
+		if opts.Internal {
+			opts.Endpoint = fmt.Sprintf("https://oss-%s-internal.aliyuncs.com", opts.Region)
+		} else {
+			opts.Endpoint = fmt.Sprintf("https://oss-%s.aliyuncs.com", opts.Region)
+		}
+	}
+	if len(opts.RAMRole) == 0 && (len(opts.AccessKeyID) == 0 || len(opts.AccessKeySecret) == 0) {
+		return nil, errors.New("missing the credentials of the oss bucket, please set `access-key-id` and `access-key-secret`, or `ram-role`")
+	}
+	return opts, nil
+}
+
+// ossStorage reads the data source from a bucket of Alibaba Cloud OSS. The
+// methods of storage.ExternalStorage not overridden, which are not used on
+// the data source, are not supported.
+type ossStorage struct {
+	storage.ExternalStorage
+	bucket *oss.Bucket
+	prefix string
+}
+
+// NewOSSStorage opens the bucket of the options.
+func NewOSSStorage(ctx context.Context, opts *OSSOptions) (storage.ExternalStorage, error) {
+	var clientOpts []oss.ClientOption
+	if len(opts.RAMRole) > 0 {
+		provider := &ossRAMRoleCredentials{role: opts.RAMRole}
+		if _, err := provider.GetCredentialsE(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		log.L().Info("access oss with the ram role", zap.String("role", opts.RAMRole))
+		clientOpts = append(clientOpts, oss.SetCredentialsProvider(provider))
+	} else if len(opts.SecurityToken) > 0 {
+		clientOpts = append(clientOpts, oss.SecurityToken(opts.SecurityToken))
+	}
+	client, err := oss.New(opts.Endpoint, opts.AccessKeyID, opts.AccessKeySecret, clientOpts...)
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid oss endpoint")
+	}
+	bucket, err := client.Bucket(opts.Bucket)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return newOSSStorage(bucket, opts.Prefix), nil
+}
+
+func newOSSStorage(bucket *oss.Bucket, prefix string) *ossStorage {
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	return &ossStorage{bucket: bucket, prefix: prefix}
+}
+
+// ossCredentials are the STS credentials of the RAM role.
+type ossCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	AccessKeySecret string    `json:"AccessKeySecret"`
+	SecurityToken   string    `json:"SecurityToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func (c *ossCredentials) GetAccessKeyID() string     { return c.AccessKeyID }
+func (c *ossCredentials) GetAccessKeySecret() string { return c.AccessKeySecret }
+func (c *ossCredentials) GetSecurityToken() string   { return c.SecurityToken }
+
+// ossRAMRoleCredentials fetches the STS credentials of the RAM role from the
+// instance metadata, and refreshes them 5 minutes before they expire.
+type ossRAMRoleCredentials struct {
+	role  string
+	mu    sync.Mutex
+	creds *ossCredentials
+}
+
+func (p *ossRAMRoleCredentials) GetCredentials() oss.Credentials {
+	creds, err := p.GetCredentialsE()
+	if err != nil {
+		log.L().Warn("failed to refresh the oss ram role credentials", log.ShortError(err))
+	}
+	return creds
+}
+
+// GetCredentialsE returns the cached credentials, or the stale ones with the
+// error if the refresh failed.
+func (p *ossRAMRoleCredentials) GetCredentialsE() (oss.Credentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.creds != nil && time.Until(p.creds.Expiration) > 5*time.Minute {
+		return p.creds, nil
+	}
+
+	resp, err := http.Get(ossMetadataEndpoint + p.role)
+	if err != nil {
+		return p.stale(), errors.Annotate(err, "fetch the oss ram role credentials")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return p.stale(), errors.Errorf("fetch the oss ram role credentials failed with status %s", resp.Status)
+	}
+	creds := &ossCredentials{}
+	if err := json.NewDecoder(resp.Body).Decode(creds); err != nil {
+		return p.stale(), errors.Annotate(err, "fetch the oss ram role credentials")
+	}
+	p.creds = creds
+	return creds, nil
+}
+
+func (p *ossRAMRoleCredentials) stale() oss.Credentials {
+	if p.creds == nil {
+		return &ossCredentials{}
+	}
+	return p.creds
+}
+
+func (s *ossStorage) Write(ctx context.Context, name string, data []byte) error {
+	err := s.bucket.PutObject(s.prefix+name, bytes.NewReader(data), oss.WithContext(ctx))
+	return errors.Annotatef(err, "write %s", name)
+}
+
+func (s *ossStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *ossStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	exists, err := s.bucket.IsObjectExist(s.prefix+name, oss.WithContext(ctx))
+	return exists, errors.Annotatef(err, "stat %s", name)
+}
+
+func (s *ossStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	header, err := s.bucket.GetObjectDetailedMeta(s.prefix+name, oss.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s: invalid content length", name)
+	}
+	return &ossReader{ctx: ctx, storage: s, name: name, size: size}, nil
+}
+
+// WalkDir lists all objects under the prefix. The objects are listed in the
+// lexicographical order of their keys.
+func (s *ossStorage) WalkDir(ctx context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	marker := ""
+	for {
+		res, err := s.bucket.ListObjects(oss.Prefix(s.prefix), oss.Marker(marker),
+			oss.MaxKeys(ossListPageSize), oss.WithContext(ctx))
+		if err != nil {
+			return errors.Annotate(err, "list oss objects")
+		}
+		for _, object := range res.Objects {
+			// skip the placeholders of the directories.
+			if strings.HasSuffix(object.Key, "/") {
+				continue
+			}
+			if err := fn(strings.TrimPrefix(object.Key, s.prefix), object.Size); err != nil {
+				return err
+			}
+		}
+		if !res.IsTruncated {
+			return nil
+		}
+		marker = res.NextMarker
+	}
+}
+
+// ossReader reads an object from the offset with a single ranged GET, which
+// restarts after seeking.
+type ossReader struct {
+	ctx     context.Context
+	storage *ossStorage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *ossReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.storage.bucket.GetObject(r.storage.prefix+r.name,
+			oss.NormalizedRange(fmt.Sprintf("%d-", r.offset)), oss.WithContext(r.ctx))
+		if err != nil {
+			return 0, errors.Annotatef(err, "read %s", r.name)
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *ossReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.Errorf("invalid seek offset %d of %s", offset, r.name)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *ossReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
@@ -0,0 +1,236 @@
+package mydump
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aliyun/aliyun-oss-go-sdk/oss"
+	. "github.com/pingcap/check"
+)
+
+var _ = Suite(&testOSSSuite{})
+
+type testOSSSuite struct{}
+
+func (s *testOSSSuite) TestParseURL(c *C) {
+	opts, err := ParseOSSURL("oss://dumps/2020/db/?region=cn-hangzhou&internal=true&ram-role=lightning")
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &OSSOptions{
+		Bucket:   "dumps",
+		Prefix:   "2020/db",
+		Endpoint: "https://oss-cn-hangzhou-internal.aliyuncs.com",
+		Region:   "cn-hangzhou",
+		Internal: true,
+		RAMRole:  "lightning",
+	})
+
+	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_ID", "envid")
+	os.Setenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET", "envsecret")
+	defer os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_ID")
+	defer os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	opts, err = ParseOSSURL("oss://dumps?region=cn-beijing&security-token=sts")
+	c.Assert(err, IsNil)
+	c.Assert(opts.Endpoint, Equals, "https://oss-cn-beijing.aliyuncs.com")
+	c.Assert(opts.AccessKeyID, Equals, "envid")
+	c.Assert(opts.AccessKeySecret, Equals, "envsecret")
+	c.Assert(opts.SecurityToken, Equals, "sts")
+
+	opts, err = ParseOSSURL("oss://dumps/db?endpoint=http://127.0.0.1:9000&access-key-id=id")
+	c.Assert(err, IsNil)
+	c.Assert(opts.Endpoint, Equals, "http://127.0.0.1:9000")
+	c.Assert(opts.AccessKeyID, Equals, "id")
+
+	_, err = ParseOSSURL("oss:///db?region=cn-hangzhou")
+	c.Assert(err, ErrorMatches, "missing the bucket of the oss url")
+	_, err = ParseOSSURL("oss://dumps/db")
+	c.Assert(err, ErrorMatches, "missing the region of the oss bucket.*")
+	_, err = ParseOSSURL("oss://dumps/db?region=cn-hangzhou&internal=maybe")
+	c.Assert(err, ErrorMatches, "invalid oss internal 'maybe'")
+	os.Unsetenv("ALIBABA_CLOUD_ACCESS_KEY_SECRET")
+	_, err = ParseOSSURL("oss://dumps/db?region=cn-hangzhou")
+	c.Assert(err, ErrorMatches, "missing the credentials of the oss bucket.*")
+}
+
+// fakeOSS serves the OSS operations used by the data source over the objects
+// of a bucket in memory, with the path-style URLs.
+type fakeOSS struct {
+	c       *C
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeOSS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.c.Assert(strings.HasPrefix(req.Header.Get("Authorization"), "OSS id:"), IsTrue)
+	f.c.Assert(req.Header.Get("X-Oss-Security-Token"), Equals, "sts")
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/dumps"), "/")
+	if len(key) == 0 {
+		f.list(w, req)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(req.Body)
+		f.c.Assert(err, IsNil)
+		f.objects[key] = data
+		return
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		if req.Method != http.MethodHead {
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+		}
+		return
+	}
+	http.ServeContent(w, req, key, time.Time{}, strings.NewReader(string(data)))
+}
+
+func (f *fakeOSS) list(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	f.c.Assert(err, IsNil)
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("marker") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		IsTruncated bool     `xml:"IsTruncated"`
+		NextMarker  string   `xml:"NextMarker"`
+		Objects     []object `xml:"Contents"`
+	}{}
+	// list 2 objects at most, to test the pagination.
+	if maxKeys > 2 {
+		maxKeys = 2
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextMarker = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Objects = append(result.Objects, object{Key: key, Size: len(f.objects[key])})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	f.c.Assert(xml.NewEncoder(w).Encode(&result), IsNil)
+}
+
+func (s *testOSSSuite) TestStorage(c *C) {
+	fake := &fakeOSS{c: c, objects: map[string][]byte{
+		"db/db-schema-create.sql":   []byte("CREATE DATABASE db;"),
+		"db/db.t-schema.sql":        []byte("CREATE TABLE t (a int);"),
+		"db/data/":                  nil,
+		"db/data/db.t.000000.sql":   []byte("INSERT INTO t VALUES (1),(2),(3);"),
+		"db/data/db.t.000001.sql":   []byte("INSERT INTO t VALUES (5);"),
+		"other/db.t.000000.sql":     []byte("INSERT INTO t VALUES (4);"),
+		"db2/db2-schema-create.sql": []byte("CREATE DATABASE db2;"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client, err := oss.New(server.URL, "id", "secret", oss.SecurityToken("sts"), oss.ForcePathStyle(true))
+	c.Assert(err, IsNil)
+	bucket, err := client.Bucket("dumps")
+	c.Assert(err, IsNil)
+	store := newOSSStorage(bucket, "db")
+	ctx := context.Background()
+
+	var paths []string
+	err = store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, fmt.Sprintf("%s:%d", path, size))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{
+		"data/db.t.000000.sql:33",
+		"data/db.t.000001.sql:25",
+		"db-schema-create.sql:19",
+		"db.t-schema.sql:23",
+	})
+
+	exists, err := store.FileExists(ctx, "db.t-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "db.u-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	data, err := store.Read(ctx, "db-schema-create.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "CREATE DATABASE db;")
+
+	reader, err := store.Open(ctx, "data/db.t.000000.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	_, err = reader.Seek(21, io.SeekStart)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "(1),(2),(3);")
+	_, err = reader.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ",(3);")
+
+	_, err = store.Open(ctx, "db.u-schema.sql")
+	c.Assert(err, ErrorMatches, "open db.u-schema.sql: .*StatusCode=404.*")
+
+	c.Assert(store.Write(ctx, "checkpoint.pb", []byte("checkpoint")), IsNil)
+	c.Assert(string(fake.objects["db/checkpoint.pb"]), Equals, "checkpoint")
+}
+
+func (s *testOSSSuite) TestRAMRoleCredentials(c *C) {
+	var requests int
+	expiration := time.Now().Add(time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		c.Assert(req.URL.Path, Equals, "/lightning")
+		fmt.Fprintf(w, `{"AccessKeyId":"STS.id","AccessKeySecret":"secret","SecurityToken":"sts%d","Expiration":"%s","Code":"Success"}`,
+			requests, expiration.UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+	endpoint := ossMetadataEndpoint
+	ossMetadataEndpoint = server.URL + "/"
+	defer func() { ossMetadataEndpoint = endpoint }()
+
+	provider := &ossRAMRoleCredentials{role: "lightning"}
+	creds := provider.GetCredentials()
+	c.Assert(creds.GetAccessKeyID(), Equals, "STS.id")
+	c.Assert(creds.GetSecurityToken(), Equals, "sts1")
+	// the credentials are cached until 5 minutes before they expire.
+	c.Assert(provider.GetCredentials().GetSecurityToken(), Equals, "sts1")
+	expiration = time.Now().Add(time.Minute)
+	provider.creds.Expiration = expiration
+	c.Assert(provider.GetCredentials().GetSecurityToken(), Equals, "sts2")
+	c.Assert(provider.GetCredentials().GetSecurityToken(), Equals, "sts3")
+
+	// the stale credentials are kept if the refresh failed.
+	server.Close()
+	creds, err := provider.GetCredentialsE()
+	c.Assert(err, ErrorMatches, "fetch the oss ram role credentials.*")
+	c.Assert(creds.GetSecurityToken(), Equals, "sts3")
+}
@@ -14,10 +14,8 @@
 package mydump
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -49,9 +47,9 @@ type blockParser struct {
 	// Current file offset.
 	pos int64
 
-	// cache
-	remainBuf *bytes.Buffer
-	appendBuf *bytes.Buffer
+	// bufArena is the backing array of `buf`. It grows to hold a value
+	// spanning many blocks, and is released after the value is consumed.
+	bufArena []byte
 
 	// the Logger associated with this parser for reporting failure
 	Logger log.Logger
@@ -59,11 +57,9 @@ type blockParser struct {
 
 func makeBlockParser(reader ReadSeekCloser, blockBufSize int64, ioWorkers *worker.Pool) blockParser {
 	return blockParser{
-		reader:    MakePooledReader(reader, ioWorkers),
-		blockBuf:  make([]byte, blockBufSize*config.BufferSizeScale),
-		remainBuf: &bytes.Buffer{},
-		appendBuf: &bytes.Buffer{},
-		Logger:    log.L(),
+		reader:   MakePooledReader(reader, ioWorkers),
+		blockBuf: make([]byte, blockBufSize*config.BufferSizeScale),
+		Logger:   log.L(),
 		rowPool: &sync.Pool{
 			New: func() interface{} {
 				return make([]types.Datum, 0, 16)
@@ -93,6 +89,10 @@ type Chunk struct {
 type Row struct {
 	RowID int64
 	Row   []types.Datum
+	// Missing marks the values of the row which are missing from the data
+	// file, e.g. the keys missing from a JSON object, so the columns are filled
+	// with the defaults. It is nil if no value is missing.
+	Missing []bool
 }
 
 type backslashEscapeFlavor uint8
@@ -243,14 +243,7 @@ func (parser *blockParser) readBlock() error {
 		parser.isLastChunk = true
 		fallthrough
 	case nil:
-		// `parser.buf` reference to `appendBuf.Bytes`, so should use remainBuf to
-		// hold the `parser.buf` rest data to prevent slice overlap
-		parser.remainBuf.Reset()
-		parser.remainBuf.Write(parser.buf)
-		parser.appendBuf.Reset()
-		parser.appendBuf.Write(parser.remainBuf.Bytes())
-		parser.appendBuf.Write(parser.blockBuf[:n])
-		parser.buf = parser.appendBuf.Bytes()
+		parser.appendBlock(parser.blockBuf[:n])
 		metric.ChunkParserReadBlockSecondsHistogram.Observe(time.Since(startTime).Seconds())
 		return nil
 	default:
@@ -258,7 +251,39 @@ func (parser *blockParser) readBlock() error {
 	}
 }
 
-var unescapeRegexp = regexp.MustCompile(`(?s)\\.`)
+// appendBlock appends the block after the unconsumed content of `buf`.
+//
+// The unconsumed content is moved to the front of the arena in place, and the
+// arena grows geometrically, so a long value (e.g. a 256 MiB LONGBLOB)
+// spanning many blocks is copied a constant number of times on average rather
+// than once per block. Once such a value is consumed, the enlarged arena is
+// released instead of being kept for the rest of the file.
+func (parser *blockParser) appendBlock(block []byte) {
+	remain := len(parser.buf)
+	size := remain + len(block)
+	retainSize := 2 * len(parser.blockBuf)
+
+	arena := parser.bufArena[:cap(parser.bufArena)]
+	switch {
+	case size > len(arena):
+		newSize := 2 * len(arena)
+		if newSize < size {
+			newSize = size
+		}
+		arena = make([]byte, newSize)
+		copy(arena, parser.buf)
+	case len(arena) > retainSize && size <= retainSize:
+		arena = make([]byte, retainSize)
+		copy(arena, parser.buf)
+	default:
+		// `buf` is always a suffix of the arena, copy handles the overlap.
+		copy(arena, parser.buf)
+	}
+	copy(arena[remain:], block)
+
+	parser.bufArena = arena
+	parser.buf = arena[:size]
+}
 
 func unescape(
 	input string,
@@ -272,28 +297,43 @@ func unescape(
 		}
 	}
 	if escFlavor != backslashEscapeFlavorNone && strings.IndexByte(input, '\\') != -1 {
-		input = unescapeRegexp.ReplaceAllStringFunc(input, func(substr string) string {
-			switch substr[1] {
-			case '0':
-				return "\x00"
-			case 'b':
-				return "\b"
-			case 'n':
-				return "\n"
-			case 'r':
-				return "\r"
-			case 't':
-				return "\t"
-			case 'Z':
-				return "\x1a"
-			default:
-				return substr[1:]
-			}
-		})
+		input = unescapeBackslash(input)
 	}
 	return input
 }
 
+// unescapeBackslash decodes the backslash escape sequences in a single pass,
+// so unescaping a long value allocates only the result.
+func unescapeBackslash(input string) string {
+	var sb strings.Builder
+	sb.Grow(len(input))
+	for i := 0; i < len(input); i++ {
+		c := input[i]
+		if c != '\\' || i+1 == len(input) {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = input[i]; c {
+		case '0':
+			sb.WriteByte(0)
+		case 'b':
+			sb.WriteByte('\b')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'Z':
+			sb.WriteByte('\x1a')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
 func (parser *ChunkParser) unescapeString(input string) string {
 	if len(input) >= 2 {
 		switch input[0] {
@@ -16,6 +16,7 @@ package mydump_test
 import (
 	"context"
 	"io"
+	"strings"
 
 	. "github.com/pingcap/check"
 	"github.com/pingcap/errors"
@@ -347,6 +348,21 @@ func (s *testMydumpParserSuite) TestVariousSyntax(c *C) {
 			input:    `(CONVERT("[1,2,3]" USING UTF8MB4))`,
 			expected: [][]types.Datum{{types.NewStringDatum("[1,2,3]")}},
 		},
+		{
+			// the legacy numeric forms written by old dumpers in one row.
+			input: "INSERT INTO `t` VALUES (x'ABCD',b'1010',X'',0xabcd,0b1010,1.5e3,-2E-3,+7.25E+2,1e400);",
+			expected: [][]types.Datum{{
+				types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0xab, 0xcd})),
+				types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0x0a})),
+				types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{})),
+				types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0xab, 0xcd})),
+				types.NewBinaryLiteralDatum(types.BinaryLiteral([]byte{0x0a})),
+				types.NewStringDatum("1.5e3"),
+				types.NewStringDatum("-2E-3"),
+				types.NewStringDatum("+7.25E+2"),
+				types.NewStringDatum("1e400"),
+			}},
+		},
 	}
 
 	s.runTestCases(c, mysql.ModeNone, config.ReadBlockSize, testCases)
@@ -373,6 +389,26 @@ func (s *testMydumpParserSuite) TestContinuation(c *C) {
 	s.runTestCases(c, mysql.ModeNone, 1, testCases)
 }
 
+func (s *testMydumpParserSuite) TestLongValues(c *C) {
+	// the values span many blocks, and the escapes cross the block boundaries.
+	long := strings.Repeat("abc\\\\d\\'e''f\\n", 50000)
+	expected := strings.Repeat("abc\\d'e'f\n", 50000)
+
+	testCases := []testCase{
+		{
+			input: "INSERT INTO t VALUES (1,'" + long + "'),(2,'x'),(3,'" + long + "'),(4,'y');",
+			expected: [][]types.Datum{
+				{types.NewUintDatum(1), types.NewStringDatum(expected)},
+				{types.NewUintDatum(2), types.NewStringDatum("x")},
+				{types.NewUintDatum(3), types.NewStringDatum(expected)},
+				{types.NewUintDatum(4), types.NewStringDatum("y")},
+			},
+		},
+	}
+
+	s.runTestCases(c, mysql.ModeNone, 7, testCases)
+}
+
 func (s *testMydumpParserSuite) TestPseudoKeywords(c *C) {
 	reader := mydump.NewStringReader(`
 		INSERT INTO t (
@@ -0,0 +1,89 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+)
+
+// LoadTablePriorities reads the table priority file, which maps the table
+// names to the import priorities, e.g.
+//
+//	"db.tbl" = 10
+//	[db2]
+//	tbl = 5
+//
+// The file is parsed as JSON if the name ends with ".json", otherwise TOML.
+// In JSON both `{"db.tbl": 10}` and `{"db": {"tbl": 10}}` are accepted.
+func LoadTablePriorities(path string) (map[filter.Table]int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read table priority file '%s'", path)
+	}
+
+	var raw map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		_, err = toml.Decode(string(data), &raw)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to parse table priority file '%s'", path)
+	}
+
+	res := make(map[filter.Table]int)
+	for key, value := range raw {
+		if tables, ok := value.(map[string]interface{}); ok {
+			for table, value := range tables {
+				if err := addTablePriority(res, key, table, value); err != nil {
+					return nil, errors.Annotatef(err, "invalid table priority file '%s'", path)
+				}
+			}
+			continue
+		}
+
+		dot := strings.IndexByte(key, '.')
+		if dot < 0 {
+			return nil, errors.Errorf("invalid table priority file '%s': table name '%s' must be in the form 'db.tbl'", path, key)
+		}
+		if err := addTablePriority(res, key[:dot], key[dot+1:], value); err != nil {
+			return nil, errors.Annotatef(err, "invalid table priority file '%s'", path)
+		}
+	}
+	return res, nil
+}
+
+func addTablePriority(res map[filter.Table]int, schema, table string, value interface{}) error {
+	var priority int
+	switch v := value.(type) {
+	case int64:
+		priority = int(v)
+	case float64:
+		if v != float64(int(v)) {
+			return errors.Errorf("priority of '%s.%s' must be an integer", schema, table)
+		}
+		priority = int(v)
+	default:
+		return errors.Errorf("priority of '%s.%s' must be an integer", schema, table)
+	}
+	res[filter.Table{Schema: schema, Name: table}] = priority
+	return nil
+}
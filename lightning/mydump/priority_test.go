@@ -0,0 +1,96 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testTablePrioritySuite{})
+
+type testTablePrioritySuite struct{}
+
+func (s *testTablePrioritySuite) TestLoadTablePriorities(c *C) {
+	dir := c.MkDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		c.Assert(err, IsNil)
+		return path
+	}
+
+	expected := map[filter.Table]int{
+		{Schema: "db", Name: "a"}:   10,
+		{Schema: "db", Name: "b.c"}: -1,
+		{Schema: "db2", Name: "d"}:  5,
+	}
+
+	priorities, err := LoadTablePriorities(write("p.toml", `
+"db.a" = 10
+"db2.d" = 5
+[db]
+"b.c" = -1
+`))
+	c.Assert(err, IsNil)
+	c.Assert(priorities, DeepEquals, expected)
+
+	priorities, err = LoadTablePriorities(write("p.json", `{"db.a": 10, "db2.d": 5, "db": {"b.c": -1}}`))
+	c.Assert(err, IsNil)
+	c.Assert(priorities, DeepEquals, expected)
+
+	_, err = LoadTablePriorities(write("bad.json", `{"tbl": 10}`))
+	c.Assert(err, ErrorMatches, ".*table name 'tbl' must be in the form 'db.tbl'")
+	_, err = LoadTablePriorities(write("bad2.json", `{"db.tbl": 1.5}`))
+	c.Assert(err, ErrorMatches, ".*priority of 'db.tbl' must be an integer")
+	_, err = LoadTablePriorities(filepath.Join(dir, "missing.toml"))
+	c.Assert(err, ErrorMatches, "failed to read table priority file .*")
+}
+
+func (s *testTablePrioritySuite) TestLoadTableTags(c *C) {
+	dir := c.MkDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		c.Assert(err, IsNil)
+		return path
+	}
+
+	expected := map[filter.Table][]string{
+		{Schema: "db", Name: "a"}:  {"hot", "small"},
+		{Schema: "db2", Name: "d"}: {"cold"},
+	}
+
+	tags, err := LoadTableTags(write("t.toml", `
+"db.a" = ["hot", "Small"]
+[db2]
+d = ["cold"]
+`))
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, expected)
+
+	tags, err = LoadTableTags(write("t.json", `{"db.a": ["hot", "small"], "db2": {"d": "cold"}}`))
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, expected)
+
+	_, err = LoadTableTags(write("bad.json", `{"tbl": ["hot"]}`))
+	c.Assert(err, ErrorMatches, ".*table name 'tbl' must be in the form 'db.tbl'")
+	_, err = LoadTableTags(write("bad2.json", `{"db.tbl": [1]}`))
+	c.Assert(err, ErrorMatches, ".*tags of 'db.tbl' must be a list of strings")
+}
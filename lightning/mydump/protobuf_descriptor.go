@@ -0,0 +1,52 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// loadProtobufMessage reads the FileDescriptorSet at the path, and finds the
+// message type of the full name. The descriptors are validated and linked by
+// protodesc, so the set must contain the imported files too, as written by
+// `protoc --include_imports`.
+func loadProtobufMessage(path string, name string) (protoreflect.MessageDescriptor, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotate(err, "read protobuf descriptor set")
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, errors.Annotatef(err, "invalid protobuf descriptor set '%s'", path)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid protobuf descriptor set '%s'", path)
+	}
+
+	name = strings.TrimPrefix(name, ".")
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	message, ok := desc.(protoreflect.MessageDescriptor)
+	if err != nil || !ok {
+		return nil, errors.Errorf("message type '%s' not found in protobuf descriptor set '%s'", name, path)
+	}
+	return message, nil
+}
@@ -0,0 +1,363 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// protoMaxVarintSize is the size of the longest varint.
+const protoMaxVarintSize = 10
+
+// ProtobufParser reads the files of the varint-delimited protobuf messages,
+// i.e. every message is preceded by its length as a varint, as written by
+// `writeDelimitedTo` of Java or `protodelim` of Go. The messages are decoded
+// by google.golang.org/protobuf as the dynamic messages of the type in the
+// descriptor set. The fields of the messages are imported into the columns of
+// the same names, and the nested messages, the repeated fields and the maps
+// are kept as the JSON text for the JSON columns. The position is the byte
+// offset of the next message.
+type ProtobufParser struct {
+	blockParser
+	message protoreflect.MessageDescriptor
+	// columnFields are the fields of the columns, nil for the columns not in
+	// the message.
+	columnFields []protoreflect.FieldDescriptor
+}
+
+// NewProtobufParser creates a parser of the messages of the table, whose type
+// is given by `mydumper.protobuf.tables`.
+func NewProtobufParser(
+	cfg *config.ProtobufConfig,
+	tableName string,
+	reader ReadSeekCloser,
+	blockBufSize int64,
+	ioWorkers *worker.Pool,
+	columns []string,
+) (*ProtobufParser, error) {
+	table := cfg.Table(tableName)
+	if table == nil {
+		return nil, errors.Errorf("no message type of table %s in `mydumper.protobuf.tables`", tableName)
+	}
+	message, err := loadProtobufMessage(table.DescriptorSet, table.Message)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	parser := &ProtobufParser{
+		blockParser: makeBlockParser(reader, blockBufSize, ioWorkers),
+		message:     message,
+	}
+	parser.SetColumns(columns)
+	return parser, nil
+}
+
+// SetColumns sets the columns which the fields of the messages are mapped to.
+func (parser *ProtobufParser) SetColumns(columns []string) {
+	messageFields := parser.message.Fields()
+	fields := make(map[string]protoreflect.FieldDescriptor, messageFields.Len())
+	for i := 0; i < messageFields.Len(); i++ {
+		field := messageFields.Get(i)
+		fields[strings.ToLower(string(field.Name()))] = field
+	}
+	parser.columns = make([]string, 0, len(columns))
+	parser.columnFields = make([]protoreflect.FieldDescriptor, 0, len(columns))
+	for _, column := range columns {
+		column = strings.ToLower(column)
+		parser.columns = append(parser.columns, column)
+		parser.columnFields = append(parser.columnFields, fields[column])
+	}
+}
+
+// readMessage reads the next message without the length. The result is only
+// valid until the next read.
+func (parser *ProtobufParser) readMessage() ([]byte, error) {
+	for len(parser.buf) < protoMaxVarintSize && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) == 0 {
+		return nil, io.EOF
+	}
+	length, n := binary.Uvarint(parser.buf)
+	if n <= 0 || length > math.MaxInt32 {
+		return nil, errors.Errorf("invalid protobuf message length at offset %d", parser.pos)
+	}
+
+	size := n + int(length)
+	for len(parser.buf) < size && !parser.isLastChunk {
+		if err := parser.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if len(parser.buf) < size {
+		return nil, errors.Errorf("truncated protobuf message at offset %d, expecting %d bytes but only %d remaining",
+			parser.pos, length, len(parser.buf)-n)
+	}
+	message := parser.buf[n:size]
+	parser.buf = parser.buf[size:]
+	parser.pos += int64(size)
+	return message, nil
+}
+
+// ReadRow reads a row from the datafile.
+func (parser *ProtobufParser) ReadRow() error {
+	start := parser.pos
+	data, err := parser.readMessage()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	message := dynamicpb.NewMessage(parser.message)
+	if err := proto.Unmarshal(data, message); err != nil {
+		return errors.Annotatef(err, "invalid protobuf message at offset %d", start)
+	}
+
+	row := &parser.lastRow
+	row.RowID++
+	row.Row = parser.acquireDatumSlice()
+	if cap(row.Row) >= len(parser.columns) {
+		row.Row = row.Row[:len(parser.columns)]
+	} else {
+		row.Row = make([]types.Datum, len(parser.columns))
+	}
+	for i, field := range parser.columnFields {
+		if field == nil {
+			row.Row[i].SetNull()
+			continue
+		}
+		setProtoDatum(&row.Row[i], field, message)
+	}
+	return nil
+}
+
+// setProtoDatum converts the field of the message to the datum. The absent
+// fields with presence are NULL unless they have the default values, and the
+// others are the zero values.
+func setProtoDatum(d *types.Datum, field protoreflect.FieldDescriptor, message protoreflect.Message) {
+	if !message.Has(field) && field.HasPresence() && !field.HasDefault() {
+		d.SetNull()
+		return
+	}
+	setProtoValueDatum(d, field, message.Get(field))
+}
+
+func setProtoValueDatum(d *types.Datum, field protoreflect.FieldDescriptor, value protoreflect.Value) {
+	if field.IsList() || field.IsMap() {
+		d.SetString(string(appendProtoJSON(nil, field, value)), "")
+		return
+	}
+	switch field.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		d.SetInt64(value.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		d.SetUint64(value.Uint())
+	case protoreflect.FloatKind:
+		d.SetFloat32(float32(value.Float()))
+	case protoreflect.DoubleKind:
+		d.SetFloat64(value.Float())
+	case protoreflect.BoolKind:
+		if value.Bool() {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case protoreflect.StringKind:
+		d.SetString(value.String(), "")
+	case protoreflect.BytesKind:
+		d.SetBytes(value.Bytes())
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			d.SetString(string(enumValue.Name()), "")
+		} else {
+			d.SetInt64(int64(value.Enum()))
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		message := value.Message()
+		if t, ok := protoTimestamp(message); ok {
+			d.SetString(t.Format("2006-01-02 15:04:05.999999999"), "")
+		} else if inner, ok := protoWrapped(message); ok {
+			setProtoValueDatum(d, inner, message.Get(inner))
+		} else {
+			d.SetString(string(appendProtoJSON(nil, field, value)), "")
+		}
+	default:
+		d.SetNull()
+	}
+}
+
+// protoTimestamp converts the google.protobuf.Timestamp.
+func protoTimestamp(message protoreflect.Message) (time.Time, bool) {
+	desc := message.Descriptor()
+	if desc.FullName() != "google.protobuf.Timestamp" {
+		return time.Time{}, false
+	}
+	seconds, nanos := desc.Fields().ByNumber(1), desc.Fields().ByNumber(2)
+	if seconds == nil || nanos == nil {
+		return time.Time{}, false
+	}
+	return time.Unix(message.Get(seconds).Int(), message.Get(nanos).Int()).UTC(), true
+}
+
+// protoWrapped returns the value field of the wrappers like
+// google.protobuf.Int64Value.
+func protoWrapped(message protoreflect.Message) (protoreflect.FieldDescriptor, bool) {
+	desc := message.Descriptor()
+	name := string(desc.FullName())
+	if !strings.HasPrefix(name, "google.protobuf.") || !strings.HasSuffix(name, "Value") || desc.Fields().Len() != 1 {
+		return nil, false
+	}
+	field := desc.Fields().Get(0)
+	return field, field.Name() == "value" && !field.IsList() && field.Message() == nil
+}
+
+// appendProtoJSON appends the value of the field as the compact JSON text,
+// like the canonical JSON mapping of protobuf except that the 64-bit integers
+// are numbers and the field names are not converted to the lower camel case.
+// The absent fields of the nested messages are omitted, and the entries of
+// the maps are sorted by the keys.
+func appendProtoJSON(buf []byte, field protoreflect.FieldDescriptor, value protoreflect.Value) []byte {
+	switch {
+	case field.IsMap():
+		entries := value.Map()
+		keys := make([]protoreflect.MapKey, 0, entries.Len())
+		entries.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+			keys = append(keys, key)
+			return true
+		})
+		sortProtoMapKeys(field.MapKey().Kind(), keys)
+		buf = append(buf, '{')
+		for i, key := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendJSONString(buf, key.String())
+			buf = append(buf, ':')
+			buf = appendProtoScalarJSON(buf, field.MapValue(), entries.Get(key))
+		}
+		return append(buf, '}')
+	case field.IsList():
+		list := value.List()
+		buf = append(buf, '[')
+		for i := 0; i < list.Len(); i++ {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = appendProtoScalarJSON(buf, field, list.Get(i))
+		}
+		return append(buf, ']')
+	default:
+		return appendProtoScalarJSON(buf, field, value)
+	}
+}
+
+func appendProtoScalarJSON(buf []byte, field protoreflect.FieldDescriptor, value protoreflect.Value) []byte {
+	switch field.Kind() {
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.AppendInt(buf, value.Int(), 10)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return strconv.AppendUint(buf, value.Uint(), 10)
+	case protoreflect.FloatKind:
+		return appendProtoFloat(buf, value.Float(), 32)
+	case protoreflect.DoubleKind:
+		return appendProtoFloat(buf, value.Float(), 64)
+	case protoreflect.BoolKind:
+		return strconv.AppendBool(buf, value.Bool())
+	case protoreflect.StringKind:
+		return appendJSONString(buf, value.String())
+	case protoreflect.BytesKind:
+		return appendJSONString(buf, base64.StdEncoding.EncodeToString(value.Bytes()))
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return appendJSONString(buf, string(enumValue.Name()))
+		}
+		return strconv.AppendInt(buf, int64(value.Enum()), 10)
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		message := value.Message()
+		if t, ok := protoTimestamp(message); ok {
+			return appendJSONString(buf, t.Format(time.RFC3339Nano))
+		}
+		if inner, ok := protoWrapped(message); ok {
+			return appendProtoScalarJSON(buf, inner, message.Get(inner))
+		}
+		buf = append(buf, '{')
+		fields := message.Descriptor().Fields()
+		first := true
+		for i := 0; i < fields.Len(); i++ {
+			f := fields.Get(i)
+			if !message.Has(f) {
+				continue
+			}
+			if !first {
+				buf = append(buf, ',')
+			}
+			first = false
+			buf = appendJSONString(buf, string(f.Name()))
+			buf = append(buf, ':')
+			buf = appendProtoJSON(buf, f, message.Get(f))
+		}
+		return append(buf, '}')
+	default:
+		return append(buf, "null"...)
+	}
+}
+
+// appendProtoFloat appends the float as a JSON number, or a string for NaN and
+// the infinities like the JSON mapping of protobuf.
+func appendProtoFloat(buf []byte, v float64, bitSize int) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(buf, `"NaN"`...)
+	case math.IsInf(v, 1):
+		return append(buf, `"Infinity"`...)
+	case math.IsInf(v, -1):
+		return append(buf, `"-Infinity"`...)
+	default:
+		return strconv.AppendFloat(buf, v, 'g', -1, bitSize)
+	}
+}
+
+// sortProtoMapKeys sorts the keys of a map, which are the integers, the bools
+// or the strings, as the order of the entries on the wire is not kept.
+func sortProtoMapKeys(kind protoreflect.Kind, keys []protoreflect.MapKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		switch kind {
+		case protoreflect.BoolKind:
+			return !keys[i].Bool() && keys[j].Bool()
+		case protoreflect.StringKind:
+			return keys[i].String() < keys[j].String()
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			return keys[i].Uint() < keys[j].Uint()
+		default:
+			return keys[i].Int() < keys[j].Int()
+		}
+	})
+}
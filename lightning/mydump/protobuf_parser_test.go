@@ -0,0 +1,281 @@
+package mydump_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testMydumpProtobufParserSuite{})
+
+type testMydumpProtobufParserSuite struct {
+	ioWorkers *worker.Pool
+	cfg       config.ProtobufConfig
+}
+
+func pbField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:   typ.Enum(),
+	}
+	if len(typeName) > 0 {
+		field.TypeName = proto.String(typeName)
+	}
+	return field
+}
+
+func pbRepeated(field *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+	field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return field
+}
+
+func pbDefault(field *descriptorpb.FieldDescriptorProto, value string) *descriptorpb.FieldDescriptorProto {
+	field.DefaultValue = proto.String(value)
+	return field
+}
+
+func (s *testMydumpProtobufParserSuite) SetUpSuite(c *C) {
+	s.ioWorkers = worker.NewPool(context.Background(), 5, "test_protobuf")
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{
+		{
+			Name:    proto.String("google/protobuf/timestamp.proto"),
+			Package: proto.String("google.protobuf"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Timestamp"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					pbField("seconds", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					pbField("nanos", 2, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				},
+			}},
+		},
+		{
+			Name:       proto.String("events.proto"),
+			Package:    proto.String("events"),
+			Syntax:     proto.String("proto3"),
+			Dependency: []string{"google/protobuf/timestamp.proto"},
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Event"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					pbField("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					pbField("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					pbField("kind", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".events.Event.Kind"),
+					pbField("delta", 4, descriptorpb.FieldDescriptorProto_TYPE_SINT32, ""),
+					pbRepeated(pbField("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_INT32, "")),
+					pbRepeated(pbField("counts", 6, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".events.Event.CountsEntry")),
+					pbField("at", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".google.protobuf.Timestamp"),
+					pbField("detail", 8, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".events.Detail"),
+					pbField("ok", 9, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+					pbField("big", 10, descriptorpb.FieldDescriptorProto_TYPE_FIXED64, ""),
+					pbField("ratio", 11, descriptorpb.FieldDescriptorProto_TYPE_FLOAT, ""),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("CountsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						pbField("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						pbField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+				EnumType: []*descriptorpb.EnumDescriptorProto{{
+					Name: proto.String("Kind"),
+					Value: []*descriptorpb.EnumValueDescriptorProto{
+						{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+						{Name: proto.String("CLICK"), Number: proto.Int32(1)},
+					},
+				}},
+			}, {
+				Name: proto.String("Detail"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					pbField("score", 1, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+					pbField("raw", 2, descriptorpb.FieldDescriptorProto_TYPE_BYTES, ""),
+				},
+			}},
+		},
+		{
+			Name:    proto.String("legacy.proto"),
+			Package: proto.String("legacy"),
+			Syntax:  proto.String("proto2"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("Legacy"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					pbDefault(pbField("a", 1, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""), "7"),
+					pbField("b", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					pbDefault(pbField("c", 3, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""), "true"),
+				},
+			}},
+		},
+	}}
+	data, err := proto.Marshal(set)
+	c.Assert(err, IsNil)
+	path := filepath.Join(c.MkDir(), "events.pb")
+	c.Assert(ioutil.WriteFile(path, data, 0644), IsNil)
+
+	s.cfg = config.ProtobufConfig{
+		DescriptorSet: path,
+		Tables: []*config.ProtobufTable{
+			{Schema: "db", Table: "events", Message: "events.Event"},
+			{Schema: "db", Table: "legacy", Message: "legacy.Legacy"},
+			{Schema: "db", Table: "unknown", Message: "events.Nope"},
+		},
+	}
+}
+
+func pbVarint(v uint64) string {
+	b := make([]byte, binary.MaxVarintLen64)
+	return string(b[:binary.PutUvarint(b, v)])
+}
+
+func pbKey(field int, wireType int) string {
+	return pbVarint(uint64(field<<3 | wireType))
+}
+
+func pbBytes(field int, data string) string {
+	return pbKey(field, 2) + pbVarint(uint64(len(data))) + data
+}
+
+func pbFixed64(field int, v uint64) string {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return pbKey(field, 1) + string(b)
+}
+
+// pbRecord prefixes the message with its length.
+func pbRecord(message string) string {
+	return pbVarint(uint64(len(message))) + message
+}
+
+func protoString(s string) types.Datum {
+	return types.NewCollationStringDatum(s, "", 0)
+}
+
+func (s *testMydumpProtobufParserSuite) TestReadMessages(c *C) {
+	record1 := pbRecord(
+		pbKey(1, 0) + pbVarint(300) +
+			pbBytes(2, "ab") +
+			pbKey(3, 0) + pbVarint(1) +
+			pbKey(4, 0) + pbVarint(3) +
+			pbBytes(5, "\x01\x02") + pbKey(5, 0) + pbVarint(3) +
+			pbBytes(6, pbBytes(1, "a")+pbKey(2, 0)+pbVarint(5)) +
+			pbBytes(7, pbKey(1, 0)+pbVarint(1577836800)+pbKey(2, 0)+pbVarint(500000000)) +
+			pbBytes(8, pbFixed64(1, math.Float64bits(1.5))+pbBytes(2, "\x00\xff")) +
+			pbKey(9, 0) + pbVarint(1) +
+			pbFixed64(10, math.MaxUint64) +
+			pbKey(11, 5) + "\x00\x00\x00\x3f" +
+			pbKey(99, 0) + pbVarint(7),
+	)
+	record2 := pbRecord("")
+
+	columns := []string{"id", "name", "kind", "delta", "tags", "counts", "at", "detail", "ok", "big", "ratio", "missing"}
+	// the small block size makes the messages span blocks.
+	parser, err := mydump.NewProtobufParser(&s.cfg, "`db`.`events`", mydump.NewStringReader(record1+record2), 8, s.ioWorkers, columns)
+	c.Assert(err, IsNil)
+	c.Assert(parser.Columns(), DeepEquals, columns)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 1,
+		Row: []types.Datum{
+			types.NewIntDatum(300),
+			protoString("ab"),
+			protoString("CLICK"),
+			types.NewIntDatum(-2),
+			protoString("[1,2,3]"),
+			protoString(`{"a":5}`),
+			protoString("2020-01-01 00:00:00.5"),
+			protoString(`{"score":1.5,"raw":"AP8="}`),
+			types.NewIntDatum(1),
+			types.NewUintDatum(math.MaxUint64),
+			types.NewFloat32Datum(0.5),
+			nullDatum,
+		},
+	})
+	c.Assert(parser, posEq, len(record1), 1)
+
+	// the absent fields of proto3 are the zero values, except the messages.
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{
+		RowID: 2,
+		Row: []types.Datum{
+			types.NewIntDatum(0),
+			protoString(""),
+			protoString("UNKNOWN"),
+			types.NewIntDatum(0),
+			protoString("[]"),
+			protoString("{}"),
+			nullDatum,
+			nullDatum,
+			types.NewIntDatum(0),
+			types.NewUintDatum(0),
+			types.NewFloat32Datum(0),
+			nullDatum,
+		},
+	})
+	c.Assert(parser, posEq, len(record1)+len(record2), 2)
+
+	c.Assert(errors.Cause(parser.ReadRow()), Equals, io.EOF)
+}
+
+func (s *testMydumpProtobufParserSuite) TestProto2Defaults(c *C) {
+	input := pbRecord("") + pbRecord(pbKey(2, 2)+pbVarint(1)+"x")
+	parser, err := mydump.NewProtobufParser(&s.cfg, "`db`.`legacy`", mydump.NewStringReader(input), 1024, s.ioWorkers, []string{"a", "b", "c"})
+	c.Assert(err, IsNil)
+
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewIntDatum(7), nullDatum, types.NewIntDatum(1)})
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{types.NewIntDatum(7), protoString("x"), types.NewIntDatum(1)})
+}
+
+func (s *testMydumpProtobufParserSuite) TestSetPos(c *C) {
+	record1 := pbRecord(pbKey(1, 0) + pbVarint(1))
+	record2 := pbRecord(pbKey(1, 0) + pbVarint(2))
+	parser, err := mydump.NewProtobufParser(&s.cfg, "`db`.`events`", mydump.NewStringReader(record1+record2), 1024, s.ioWorkers, []string{"id"})
+	c.Assert(err, IsNil)
+	c.Assert(parser.SetPos(int64(len(record1)), 5), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, mydump.Row{RowID: 6, Row: []types.Datum{types.NewIntDatum(2)}})
+	c.Assert(parser, posEq, len(record1)+len(record2), 6)
+}
+
+func (s *testMydumpProtobufParserSuite) TestInvalidConfig(c *C) {
+	_, err := mydump.NewProtobufParser(&s.cfg, "`db`.`missing`", mydump.NewStringReader(""), 1024, s.ioWorkers, nil)
+	c.Assert(err, ErrorMatches, "no message type of table `db`.`missing` in `mydumper.protobuf.tables`")
+	_, err = mydump.NewProtobufParser(&s.cfg, "`db`.`unknown`", mydump.NewStringReader(""), 1024, s.ioWorkers, nil)
+	c.Assert(err, ErrorMatches, "message type 'events.Nope' not found in protobuf descriptor set '.*'")
+}
+
+func (s *testMydumpProtobufParserSuite) TestInvalidMessages(c *C) {
+	cases := []struct {
+		input string
+		err   string
+	}{
+		{"\x05\x08", "truncated protobuf message at offset 0, expecting 5 bytes but only 1 remaining"},
+		{"\xff\xff", "invalid protobuf message length at offset 0"},
+		// the strings of proto3 must be valid UTF-8.
+		{pbRecord(pbBytes(2, "\xff")), "invalid protobuf message at offset 0: proto:.field events.Event.name contains invalid UTF-8"},
+		{pbRecord(pbKey(2, 2) + pbVarint(5) + "a"), "invalid protobuf message at offset 0: proto:.cannot parse invalid wire-format data"},
+		{pbRecord(pbKey(99, 3)), "invalid protobuf message at offset 0: proto:.cannot parse invalid wire-format data"},
+	}
+	for _, tc := range cases {
+		parser, err := mydump.NewProtobufParser(&s.cfg, "`db`.`events`", mydump.NewStringReader(tc.input), 1024, s.ioWorkers, []string{"id"})
+		c.Assert(err, IsNil)
+		c.Assert(parser.ReadRow(), ErrorMatches, tc.err, Commentf("input = %q", tc.input))
+	}
+}
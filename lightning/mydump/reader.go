@@ -25,6 +25,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/pingcap/errors"
+	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/encoding/simplifiedchinese"
 
 	"github.com/pingcap/tidb-lightning/lightning/log"
@@ -62,6 +63,13 @@ func decodeCharacterSet(data []byte, characterSet string) ([]byte, error) {
 			return nil, errInvalidSchemaEncoding
 		}
 		data = decoded
+	case "latin1":
+		// MySQL's latin1 is actually cp1252.
+		decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		data = decoded
 	default:
 		return nil, errors.Errorf("Unsupported encoding %s", characterSet)
 	}
@@ -149,23 +149,140 @@ func MakeTableRegions(
 			dataFileSizes = append(dataFileSizes, float64(dataFile.Size))
 			continue
 		}
+		if dataFile.FileMeta.Type == SourceTypeAvro {
+			rowIDMax, region, err := makeAvroFileRegion(ctx, store, meta, dataFile, prevRowIDMax)
+			if err != nil {
+				return nil, err
+			}
+			prevRowIDMax = rowIDMax
+			filesRegions = append(filesRegions, region)
+			dataFileSizes = append(dataFileSizes, float64(dataFile.Size))
+			continue
+		}
+		if dataFile.FileMeta.Type == SourceTypeORC {
+			rowIDMax, region, err := makeORCFileRegion(ctx, store, meta, dataFile, prevRowIDMax)
+			if err != nil {
+				return nil, err
+			}
+			prevRowIDMax = rowIDMax
+			filesRegions = append(filesRegions, region)
+			dataFileSizes = append(dataFileSizes, float64(dataFile.Size))
+			continue
+		}
+		if dataFile.FileMeta.Type == SourceTypeArrow {
+			var (
+				regions      []*TableRegion
+				subFileSizes []float64
+			)
+			prevRowIDMax, regions, subFileSizes, err = makeArrowFileRegions(ctx, store, meta, dataFile, cfg.Mydumper.MaxRegionSize, prevRowIDMax)
+			if err != nil {
+				return nil, err
+			}
+			filesRegions = append(filesRegions, regions...)
+			dataFileSizes = append(dataFileSizes, subFileSizes...)
+			continue
+		}
+		if dataFile.FileMeta.Type == SourceTypeXLSX {
+			rowIDMax, region, err := makeXLSXSheetRegion(ctx, store, meta, dataFile, &cfg.Mydumper.XLSX, prevRowIDMax)
+			if err != nil {
+				return nil, err
+			}
+			prevRowIDMax = rowIDMax
+			filesRegions = append(filesRegions, region)
+			dataFileSizes = append(dataFileSizes, float64(dataFile.Size))
+			continue
+		}
+
+		if dataFile.Size == StreamFileSize {
+			// the stream is read until EOF as a single region, with enough row
+			// IDs reserved since its size is unknown.
+			divisor := int64(columns)
+			if dataFile.FileMeta.Type != SourceTypeCSV {
+				divisor += 2
+			}
+			rowIDMax := prevRowIDMax + TableFileSizeINF/divisor
+			filesRegions = append(filesRegions, &TableRegion{
+				DB:       meta.DB,
+				Table:    meta.Name,
+				FileMeta: dataFile.FileMeta,
+				Chunk: Chunk{
+					Offset:       0,
+					EndOffset:    TableFileSizeINF,
+					PrevRowIDMax: prevRowIDMax,
+					RowIDMax:     rowIDMax,
+				},
+			})
+			prevRowIDMax = rowIDMax
+			dataFileSizes = append(dataFileSizes, 0)
+			continue
+		}
 
 		dataFileSize := dataFile.Size
+		// the regions of a compressed file are sized by the decompressed data.
+		// An indexed gzip file can seek to any offset, so its exact size is
+		// computed to split it like an uncompressed file.
+		isCompressed := dataFile.FileMeta.Compression != CompressionNone
+		var gzipIndex GzipIndex
+		if dataFile.FileMeta.Compression == CompressionGZ {
+			if gzipIndex, err = ReadGzipIndex(ctx, store, dataFile.FileMeta.Path); err != nil {
+				return nil, err
+			}
+		}
+		isIndexed := gzipIndex != nil
+		switch {
+		case isIndexed:
+			if dataFileSize, err = gzipRealSize(ctx, store, dataFile.FileMeta.Path, gzipIndex); err != nil {
+				return nil, err
+			}
+		case isCompressed:
+			if dataFileSize, err = EstimateRealSize(ctx, store, dataFile); err != nil {
+				return nil, err
+			}
+		}
 
 		divisor := int64(columns)
 		isCsvFile := dataFile.FileMeta.Type == SourceTypeCSV
-		if !isCsvFile {
+		switch {
+		case dataFile.FileMeta.Type == SourceTypeFixedWidth:
+			// the records are at least a byte if they are separated by newlines.
+			divisor = int64(cfg.Mydumper.FixedWidth.RecordLength)
+			if divisor <= 0 {
+				divisor = 1
+			}
+		case !isCsvFile:
 			divisor += 2
 		}
 
 		// If a csv file is overlarge, we need to split it into multiple regions.
 		// Note: We can only split a csv file whose format is strict.
-		if isCsvFile && dataFileSize > cfg.Mydumper.MaxRegionSize && cfg.Mydumper.StrictFormat {
+		splittable := isCsvFile && (!isCompressed || isIndexed) && dataFileSize > cfg.Mydumper.MaxRegionSize && cfg.Mydumper.StrictFormat
+		fileCfg := cfg
+		if splittable && (cfg.Mydumper.FileDirectives || len(dataFile.FileMeta.Directives) > 0) {
+			directives, err := LoadFileDirectives(ctx, store, dataFile.FileMeta, cfg.Mydumper.FileDirectives)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case directives == nil:
+			case directives.Length > 0:
+				// the file declaring its own format is not split by the
+				// format of the config.
+				splittable = false
+			default:
+				// the file is split by the format given by its routing rule.
+				routedCfg := *cfg
+				routedCfg.Mydumper.CSV = *directives.CSV(&cfg.Mydumper.CSV)
+				fileCfg = &routedCfg
+			}
+		}
+		if splittable {
 			var (
 				regions      []*TableRegion
 				subFileSizes []float64
 			)
-			prevRowIDMax, regions, subFileSizes, err = SplitLargeFile(ctx, meta, cfg, dataFile, divisor, prevRowIDMax, ioWorkers, store)
+			sizedFile := dataFile
+			sizedFile.Size = dataFileSize
+			prevRowIDMax, regions, subFileSizes, err = SplitLargeFile(ctx, meta, fileCfg, sizedFile, divisor, prevRowIDMax, ioWorkers, store)
 			if err != nil {
 				return nil, err
 			}
@@ -173,27 +290,34 @@ func MakeTableRegions(
 			filesRegions = append(filesRegions, regions...)
 			continue
 		}
-		rowIDMax := prevRowIDMax + dataFile.Size/divisor
+		rowIDMax := prevRowIDMax + dataFileSize/divisor
+		endOffset := dataFileSize
+		if isCompressed && !isIndexed {
+			// the estimated size may be short of the real one, so the file is
+			// read until EOF, with enough row IDs reserved.
+			rowIDMax = prevRowIDMax + dataFileSize*compressSizeFactor/divisor
+			endOffset = TableFileSizeINF
+		}
 		tableRegion := &TableRegion{
 			DB:       meta.DB,
 			Table:    meta.Name,
 			FileMeta: dataFile.FileMeta,
 			Chunk: Chunk{
 				Offset:       0,
-				EndOffset:    dataFile.Size,
+				EndOffset:    endOffset,
 				PrevRowIDMax: prevRowIDMax,
 				RowIDMax:     rowIDMax,
 			},
 		}
 		filesRegions = append(filesRegions, tableRegion)
-		if tableRegion.Size() > tableRegionSizeWarningThreshold {
+		if dataFileSize > tableRegionSizeWarningThreshold {
 			log.L().Warn(
 				"file is too big to be processed efficiently; we suggest splitting it at 256 MB each",
 				zap.String("file", dataFile.FileMeta.Path),
 				zap.Int64("size", dataFileSize))
 		}
 		prevRowIDMax = rowIDMax
-		dataFileSizes = append(dataFileSizes, float64(dataFile.Size))
+		dataFileSizes = append(dataFileSizes, float64(dataFileSize))
 	}
 
 	log.L().Debug("in makeTableRegions",
@@ -204,6 +328,16 @@ func MakeTableRegions(
 	return filesRegions, nil
 }
 
+// EstimateChunkCount returns the number of chunks the data file would be split
+// into by MakeTableRegions, without reading the file.
+func EstimateChunkCount(cfg *config.Config, dataFile FileInfo) int64 {
+	if dataFile.FileMeta.Type == SourceTypeCSV && dataFile.FileMeta.Compression == CompressionNone &&
+		cfg.Mydumper.StrictFormat && dataFile.Size > cfg.Mydumper.MaxRegionSize {
+		return (dataFile.Size + cfg.Mydumper.MaxRegionSize - 1) / cfg.Mydumper.MaxRegionSize
+	}
+	return 1
+}
+
 // because parquet files can't seek efficiently, there is no benefit in split.
 // parquet file are column orient, so the offset is read line number
 func makeParquetFileRegion(
@@ -240,6 +374,141 @@ func makeParquetFileRegion(
 	return rowIDMax, region, nil
 }
 
+// makeAvroFileRegion makes a region of the whole avro file. Like the parquet
+// files, the offsets are the row numbers, and the rows are counted from the
+// headers of the blocks.
+func makeAvroFileRegion(
+	ctx context.Context,
+	store storage.ExternalStorage,
+	meta *MDTableMeta,
+	dataFile FileInfo,
+	prevRowIDMax int64,
+) (int64, *TableRegion, error) {
+	r, err := store.Open(ctx, dataFile.FileMeta.Path)
+	if err != nil {
+		return prevRowIDMax, nil, errors.Trace(err)
+	}
+	ap, err := NewAvroParser(r)
+	if err != nil {
+		r.Close()
+		return prevRowIDMax, nil, errors.Annotatef(err, "failed to read avro file %s", dataFile.FileMeta.Path)
+	}
+	defer ap.Close()
+
+	numberRows, err := ap.countRows()
+	if err != nil {
+		return prevRowIDMax, nil, errors.Annotatef(err, "failed to read avro file %s", dataFile.FileMeta.Path)
+	}
+	rowIDMax := prevRowIDMax + numberRows
+	region := &TableRegion{
+		DB:       meta.DB,
+		Table:    meta.Name,
+		FileMeta: dataFile.FileMeta,
+		Chunk: Chunk{
+			Offset:       0,
+			EndOffset:    numberRows,
+			PrevRowIDMax: prevRowIDMax,
+			RowIDMax:     rowIDMax,
+		},
+	}
+	return rowIDMax, region, nil
+}
+
+// makeORCFileRegion makes a region of the whole orc file. Like the parquet
+// files, the offsets are the row numbers, and the rows are counted from the
+// footer.
+func makeORCFileRegion(
+	ctx context.Context,
+	store storage.ExternalStorage,
+	meta *MDTableMeta,
+	dataFile FileInfo,
+	prevRowIDMax int64,
+) (int64, *TableRegion, error) {
+	r, err := store.Open(ctx, dataFile.FileMeta.Path)
+	if err != nil {
+		return prevRowIDMax, nil, errors.Trace(err)
+	}
+	op, err := NewORCParser(r)
+	if err != nil {
+		r.Close()
+		return prevRowIDMax, nil, errors.Annotatef(err, "failed to read orc file %s", dataFile.FileMeta.Path)
+	}
+	defer op.Close()
+
+	numberRows := op.numRows
+	rowIDMax := prevRowIDMax + numberRows
+	region := &TableRegion{
+		DB:       meta.DB,
+		Table:    meta.Name,
+		FileMeta: dataFile.FileMeta,
+		Chunk: Chunk{
+			Offset:       0,
+			EndOffset:    numberRows,
+			PrevRowIDMax: prevRowIDMax,
+			RowIDMax:     rowIDMax,
+		},
+	}
+	return rowIDMax, region, nil
+}
+
+// makeArrowFileRegions splits the arrow file into regions at the boundaries
+// of the record batches, each of about `maxRegionSize` bytes. Like the parquet
+// files, the offsets are the row numbers.
+func makeArrowFileRegions(
+	ctx context.Context,
+	store storage.ExternalStorage,
+	meta *MDTableMeta,
+	dataFile FileInfo,
+	maxRegionSize int64,
+	prevRowIDMax int64,
+) (int64, []*TableRegion, []float64, error) {
+	r, err := store.Open(ctx, dataFile.FileMeta.Path)
+	if err != nil {
+		return prevRowIDMax, nil, nil, errors.Trace(err)
+	}
+	ap, err := NewArrowParser(r)
+	if err != nil {
+		r.Close()
+		return prevRowIDMax, nil, nil, errors.Annotatef(err, "failed to read arrow file %s", dataFile.FileMeta.Path)
+	}
+	defer ap.Close()
+
+	var (
+		regions  []*TableRegion
+		sizes    []float64
+		startRow int64
+		size     int64
+	)
+	addRegion := func(endRow int64) {
+		rowIDMax := prevRowIDMax + endRow - startRow
+		regions = append(regions, &TableRegion{
+			DB:       meta.DB,
+			Table:    meta.Name,
+			FileMeta: dataFile.FileMeta,
+			Chunk: Chunk{
+				Offset:       startRow,
+				EndOffset:    endRow,
+				PrevRowIDMax: prevRowIDMax,
+				RowIDMax:     rowIDMax,
+			},
+		})
+		sizes = append(sizes, float64(size))
+		prevRowIDMax = rowIDMax
+		startRow = endRow
+		size = 0
+	}
+	for _, batch := range ap.batches {
+		size += batch.size
+		if size >= maxRegionSize {
+			addRegion(batch.startRow + batch.rows)
+		}
+	}
+	if startRow < ap.numRows || len(regions) == 0 {
+		addRegion(ap.numRows)
+	}
+	return prevRowIDMax, regions, sizes, nil
+}
+
 // SplitLargeFile splits a large csv file into multiple regions, the size of
 // each regions is specified by `config.MaxRegionSize`.
 // Note: We split the file coarsely, thus the format of csv file is needed to be
@@ -247,6 +516,8 @@ func makeParquetFileRegion(
 // e.g.
 // - CSV file with header is invalid
 // - a complete tuple split into multiple lines is invalid
+// An indexed gzip file is split by the offsets of its decompressed data, with
+// the size of dataFile being the decompressed size.
 func SplitLargeFile(
 	ctx context.Context,
 	meta *MDTableMeta,
@@ -260,9 +531,28 @@ func SplitLargeFile(
 	maxRegionSize := cfg.Mydumper.MaxRegionSize
 	dataFileSizes = make([]float64, 0, dataFile.Size/maxRegionSize+1)
 	startOffset, endOffset := int64(0), maxRegionSize
+	var index GzipIndex
+	if dataFile.FileMeta.Compression == CompressionGZ {
+		if index, err = ReadGzipIndex(ctx, store, dataFile.FileMeta.Path); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	open := func() (ReadSeekCloser, error) {
+		r, err := store.Open(ctx, dataFile.FileMeta.Path)
+		if err != nil || index == nil {
+			return r, err
+		}
+		decompressed, err := NewIndexedDecompressReader(r, index)
+		if err != nil {
+			r.Close()
+			return nil, errors.Annotatef(err, "open %s", dataFile.FileMeta.Path)
+		}
+		return decompressed, nil
+	}
+
 	var columns []string
 	if cfg.Mydumper.CSV.Header {
-		r, err := store.Open(ctx, dataFile.FileMeta.Path)
+		r, err := open()
 		if err != nil {
 			return 0, nil, nil, err
 		}
@@ -278,7 +568,7 @@ func SplitLargeFile(
 		curRowsCnt := (endOffset - startOffset) / divisor
 		rowIDMax := prevRowIdxMax + curRowsCnt
 		if endOffset != dataFile.Size {
-			r, err := store.Open(ctx, dataFile.FileMeta.Path)
+			r, err := open()
 			if err != nil {
 				return 0, nil, nil, err
 			}
@@ -318,3 +608,44 @@ func SplitLargeFile(
 	}
 	return prevRowIdxMax, regions, dataFileSizes, nil
 }
+
+// makeXLSXSheetRegion makes a region of the whole sheet of an xlsx file. Like
+// the parquet files, the offsets are the row numbers.
+func makeXLSXSheetRegion(
+	ctx context.Context,
+	store storage.ExternalStorage,
+	meta *MDTableMeta,
+	dataFile FileInfo,
+	cfg *config.XLSXConfig,
+	prevRowIDMax int64,
+) (int64, *TableRegion, error) {
+	path, sheet := SplitXLSXSheetPath(dataFile.FileMeta.Path)
+	r, err := store.Open(ctx, path)
+	if err != nil {
+		return prevRowIDMax, nil, errors.Trace(err)
+	}
+	xp, err := NewXLSXParser(cfg, r, sheet)
+	if err != nil {
+		r.Close()
+		return prevRowIDMax, nil, errors.Annotatef(err, "failed to read xlsx file %s", path)
+	}
+	defer xp.Close()
+
+	numberRows, err := xp.numRows()
+	if err != nil {
+		return prevRowIDMax, nil, errors.Annotatef(err, "failed to read xlsx file %s", path)
+	}
+	rowIDMax := prevRowIDMax + numberRows
+	region := &TableRegion{
+		DB:       meta.DB,
+		Table:    meta.Name,
+		FileMeta: dataFile.FileMeta,
+		Chunk: Chunk{
+			Offset:       0,
+			EndOffset:    numberRows,
+			PrevRowIDMax: prevRowIDMax,
+			RowIDMax:     rowIDMax,
+		},
+	}
+	return rowIDMax, region, nil
+}
@@ -260,3 +260,22 @@ func (s *testMydumpRegionSuite) TestSplitLargeFile(c *C) {
 		}
 	}
 }
+
+func (s *testMydumpRegionSuite) TestEstimateChunkCount(c *C) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.MaxRegionSize = 100
+
+	csvFile := FileInfo{FileMeta: SourceFileMeta{Type: SourceTypeCSV}, Size: 250}
+	sqlFile := FileInfo{FileMeta: SourceFileMeta{Type: SourceTypeSQL}, Size: 250}
+
+	// only the CSV files in strict format are split.
+	c.Assert(EstimateChunkCount(cfg, csvFile), Equals, int64(1))
+	c.Assert(EstimateChunkCount(cfg, sqlFile), Equals, int64(1))
+
+	cfg.Mydumper.StrictFormat = true
+	c.Assert(EstimateChunkCount(cfg, csvFile), Equals, int64(3))
+	c.Assert(EstimateChunkCount(cfg, sqlFile), Equals, int64(1))
+
+	csvFile.Size = 100
+	c.Assert(EstimateChunkCount(cfg, csvFile), Equals, int64(1))
+}
@@ -0,0 +1,322 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// maxReadRetryBackoff caps the backoff doubled on every retry.
+const maxReadRetryBackoff = 30 * time.Second
+
+// RetryOptions configures the retries and the throttling of the reads.
+type RetryOptions struct {
+	// MaxRetry is the number of the retries of the failed reads of each file,
+	// and of every other operation.
+	MaxRetry int
+	// Backoff is the wait before the first retry, doubled on every retry.
+	Backoff time.Duration
+	// Bandwidth is the bytes read from the storage per second, shared by all
+	// files. 0 means no limit.
+	Bandwidth int64
+}
+
+// retryStorage wraps a storage to retry the operations failed by the
+// transient errors, e.g. EIO of an overloaded NFS mount, and to throttle the
+// reads. A failed read of an opened file is retried on the file reopened at
+// the same offset. The methods not overridden are passed to the wrapped
+// storage as is.
+type retryStorage struct {
+	storage.ExternalStorage
+	opts    RetryOptions
+	limiter *rate.Limiter
+}
+
+// NewRetryStorage wraps the storage with the retries and the throttling.
+func NewRetryStorage(inner storage.ExternalStorage, opts RetryOptions) storage.ExternalStorage {
+	s := &retryStorage{ExternalStorage: inner, opts: opts}
+	if opts.Bandwidth > 0 {
+		// a second worth of bandwidth may be read at once.
+		s.limiter = rate.NewLimiter(rate.Limit(opts.Bandwidth), int(opts.Bandwidth))
+	}
+	return s
+}
+
+// WrapRetryStorage wraps the storage of the data source with the retries and
+// the throttling in the config, or returns the storage as is if neither is
+// configured.
+func WrapRetryStorage(inner storage.ExternalStorage, cfg *config.MydumperRuntime) storage.ExternalStorage {
+	if cfg.ReadErrorRetry <= 0 && cfg.ReadBandwidth <= 0 {
+		return inner
+	}
+	return NewRetryStorage(inner, RetryOptions{
+		MaxRetry:  cfg.ReadErrorRetry,
+		Backoff:   cfg.ReadRetryBackoff.Duration,
+		Bandwidth: cfg.ReadBandwidth,
+	})
+}
+
+// isRetryableError returns whether the operation may succeed if retried. The
+// errors telling the file is missing or inaccessible are permanent, and so is
+// reading a file closed on purpose, e.g. to abort a hung read.
+func isRetryableError(err error) bool {
+	cause := errors.Cause(err)
+	switch cause {
+	case nil, io.EOF, context.Canceled, context.DeadlineExceeded, os.ErrClosed:
+		return false
+	}
+	if pathErr, ok := cause.(*os.PathError); ok && pathErr.Err == os.ErrClosed {
+		return false
+	}
+	return !os.IsNotExist(cause) && !os.IsPermission(cause)
+}
+
+// retryBackoff tracks the retries of an operation or a file.
+type retryBackoff struct {
+	retry   int
+	backoff time.Duration
+}
+
+// wait sleeps for the backoff before the next retry, or returns the error if
+// it is not retryable or the retries are exhausted.
+func (s *retryStorage) wait(ctx context.Context, b *retryBackoff, op string, name string, err error) error {
+	if !isRetryableError(err) {
+		return err
+	}
+	if b.retry >= s.opts.MaxRetry {
+		if b.retry == 0 {
+			return err
+		}
+		return errors.Annotatef(err, "%s '%s' failed after %d retries", op, name, b.retry)
+	}
+	if b.backoff <= 0 {
+		b.backoff = s.opts.Backoff
+	}
+	b.retry++
+	log.L().Warn("operation on the data source failed, retry after backoff",
+		zap.String("op", op),
+		zap.String("path", name),
+		zap.Int("retry", b.retry),
+		zap.Duration("backoff", b.backoff),
+		log.ShortError(err),
+	)
+	common.RecordWarning("", common.WarningRetriedRead, fmt.Sprintf("%s '%s' failed, retried", op, name))
+
+	timer := time.NewTimer(b.backoff)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+	}
+	b.backoff *= 2
+	if b.backoff > maxReadRetryBackoff {
+		b.backoff = maxReadRetryBackoff
+	}
+	return nil
+}
+
+// throttle waits until the n bytes read are within the bandwidth.
+func (s *retryStorage) throttle(ctx context.Context, n int) error {
+	if s.limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		m := n
+		if burst := s.limiter.Burst(); m > burst {
+			m = burst
+		}
+		if err := s.limiter.WaitN(ctx, m); err != nil {
+			return errors.Trace(err)
+		}
+		n -= m
+	}
+	return nil
+}
+
+func (s *retryStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	var b retryBackoff
+	for {
+		data, err := s.ExternalStorage.Read(ctx, name)
+		if err == nil {
+			return data, s.throttle(ctx, len(data))
+		}
+		if err = s.wait(ctx, &b, "read", name, err); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *retryStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	var b retryBackoff
+	for {
+		exists, err := s.ExternalStorage.FileExists(ctx, name)
+		if err == nil {
+			return exists, nil
+		}
+		if err = s.wait(ctx, &b, "stat", name, err); err != nil {
+			return false, err
+		}
+	}
+}
+
+func (s *retryStorage) Open(ctx context.Context, path string) (storage.ReadSeekCloser, error) {
+	r := &retryReader{ctx: ctx, storage: s, path: path}
+	if err := r.reopen(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// walkError marks the error returned by the callback of WalkDir, which is not
+// retried.
+type walkError struct {
+	err error
+}
+
+func (e walkError) Error() string {
+	return e.err.Error()
+}
+
+// WalkDir retries the walk failed in the middle by skipping the files already
+// visited, since the files are listed in the same order every time.
+func (s *retryStorage) WalkDir(ctx context.Context, opt *storage.WalkOption, fn func(path string, size int64) error) error {
+	var b retryBackoff
+	visited := 0
+	for {
+		skip := visited
+		err := s.ExternalStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+			if skip > 0 {
+				skip--
+				return nil
+			}
+			if err := fn(path, size); err != nil {
+				return walkError{err: err}
+			}
+			visited++
+			return nil
+		})
+		if e, ok := errors.Cause(err).(walkError); ok {
+			return e.err
+		}
+		if err == nil {
+			return nil
+		}
+		if err = s.wait(ctx, &b, "walk", "", err); err != nil {
+			return err
+		}
+	}
+}
+
+// retryReader reads a file, and retries a failed read on the file reopened at
+// the same offset. The retries are counted for the whole file.
+type retryReader struct {
+	ctx     context.Context
+	storage *retryStorage
+	path    string
+	backoff retryBackoff
+
+	// reader is nil after a failed read until reopened.
+	reader storage.ReadSeekCloser
+	pos    int64
+}
+
+// reopen opens the file at the current offset, with retries.
+func (r *retryReader) reopen() error {
+	for {
+		reader, err := r.storage.ExternalStorage.Open(r.ctx, r.path)
+		if err == nil && r.pos > 0 {
+			if _, err = reader.Seek(r.pos, io.SeekStart); err != nil {
+				reader.Close()
+			}
+		}
+		if err == nil {
+			r.reader = reader
+			return nil
+		}
+		if err = r.storage.wait(r.ctx, &r.backoff, "open", r.path, err); err != nil {
+			return err
+		}
+	}
+}
+
+// Read implements io.Reader
+func (r *retryReader) Read(p []byte) (int, error) {
+	for {
+		if r.reader == nil {
+			if err := r.reopen(); err != nil {
+				return 0, err
+			}
+		}
+		reader := r.reader
+		n, err := reader.Read(p)
+		r.pos += int64(n)
+		if n > 0 {
+			// the data read is returned first, and the error, if still
+			// there, is retried on the next read.
+			if isRetryableError(err) {
+				err = nil
+			}
+			if werr := r.storage.throttle(r.ctx, n); werr != nil {
+				return n, werr
+			}
+			return n, err
+		}
+		if err == nil {
+			return 0, nil
+		}
+		if err = r.storage.wait(r.ctx, &r.backoff, "read", r.path, err); err != nil {
+			return 0, err
+		}
+		reader.Close()
+		r.reader = nil
+	}
+}
+
+// Seek implements io.Seeker
+func (r *retryReader) Seek(offset int64, whence int) (int64, error) {
+	if r.reader == nil {
+		if err := r.reopen(); err != nil {
+			return r.pos, err
+		}
+	}
+	pos, err := r.reader.Seek(offset, whence)
+	if err == nil {
+		r.pos = pos
+	}
+	return pos, err
+}
+
+// Close implements io.Closer
+func (r *retryReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.reader = nil
+	return err
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testRetryStorageSuite{})
+
+type testRetryStorageSuite struct{}
+
+// flakyStorage fails the first `failures` opens and walks, and the reads of
+// the files opened meanwhile after `failAt` bytes, with EIO.
+type flakyStorage struct {
+	storage.ExternalStorage
+	failures int
+	failAt   int
+	opens    int
+	walks    int
+}
+
+func (s *flakyStorage) Open(ctx context.Context, path string) (storage.ReadSeekCloser, error) {
+	s.opens++
+	reader, err := s.ExternalStorage.Open(ctx, path)
+	if err != nil || s.opens > s.failures {
+		return reader, err
+	}
+	return &flakyReader{ReadSeekCloser: reader, remain: s.failAt}, nil
+}
+
+func (s *flakyStorage) WalkDir(ctx context.Context, opt *storage.WalkOption, fn func(path string, size int64) error) error {
+	s.walks++
+	visited := 0
+	return s.ExternalStorage.WalkDir(ctx, opt, func(path string, size int64) error {
+		if s.walks <= s.failures && visited == 1 {
+			return syscall.EIO
+		}
+		visited++
+		return fn(path, size)
+	})
+}
+
+type flakyReader struct {
+	storage.ReadSeekCloser
+	remain int
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	if r.remain <= 0 {
+		return 0, syscall.EIO
+	}
+	if len(p) > r.remain {
+		p = p[:r.remain]
+	}
+	n, err := r.ReadSeekCloser.Read(p)
+	r.remain -= n
+	return n, err
+}
+
+func (s *testRetryStorageSuite) prepare(c *C, failures int) *flakyStorage {
+	dir := c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.1.sql"), []byte("0123456789"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.2.sql"), bytes.Repeat([]byte("x"), 15000), 0644), IsNil)
+	inner, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	return &flakyStorage{ExternalStorage: inner, failures: failures, failAt: 4}
+}
+
+func (s *testRetryStorageSuite) TestRetryRead(c *C) {
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+
+	flaky := s.prepare(c, 2)
+	store := mydump.NewRetryStorage(flaky, mydump.RetryOptions{MaxRetry: 2, Backoff: time.Millisecond})
+	ctx := context.Background()
+
+	reader, err := store.Open(ctx, "db.t.1.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	// the file fails at the offsets 4 and 8, and is reopened at them.
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123456789")
+	c.Assert(flaky.opens, Equals, 3)
+	warnings := common.Warnings.Summary()
+	c.Assert(warnings, HasLen, 1)
+	c.Assert(warnings[0].Kind, Equals, common.WarningRetriedRead)
+	c.Assert(warnings[0].Count, Equals, 2)
+
+	// the retries are counted for the whole file.
+	flaky = s.prepare(c, 3)
+	store = mydump.NewRetryStorage(flaky, mydump.RetryOptions{MaxRetry: 2, Backoff: time.Millisecond})
+	reader, err = store.Open(ctx, "db.t.2.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	_, err = ioutil.ReadAll(reader)
+	c.Assert(err, ErrorMatches, "read 'db.t.2.sql' failed after 2 retries: input/output error")
+
+	// the missing file is not retried.
+	flaky = s.prepare(c, 0)
+	store = mydump.NewRetryStorage(flaky, mydump.RetryOptions{MaxRetry: 2, Backoff: time.Millisecond})
+	_, err = store.Open(ctx, "db.t.3.sql")
+	c.Assert(err, NotNil)
+	c.Assert(flaky.opens, Equals, 1)
+}
+
+func (s *testRetryStorageSuite) TestRetryWalkDir(c *C) {
+	flaky := s.prepare(c, 1)
+	store := mydump.NewRetryStorage(flaky, mydump.RetryOptions{MaxRetry: 1, Backoff: time.Millisecond})
+
+	var paths []string
+	err := store.WalkDir(context.Background(), &storage.WalkOption{}, func(path string, size int64) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"db.t.1.sql", "db.t.2.sql"})
+	c.Assert(flaky.walks, Equals, 2)
+}
+
+func (s *testRetryStorageSuite) TestBandwidth(c *C) {
+	flaky := s.prepare(c, 0)
+	store := mydump.NewRetryStorage(flaky, mydump.RetryOptions{Bandwidth: 10000})
+
+	// the first 10000 bytes are read at once, and the rest in 0.5 seconds.
+	start := time.Now()
+	data, err := store.Read(context.Background(), "db.t.2.sql")
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 15000)
+	c.Assert(time.Since(start), GreaterEqual, 400*time.Millisecond)
+}
@@ -22,15 +22,41 @@ const (
 	SourceTypeSQL
 	SourceTypeCSV
 	SourceTypeParquet
+	SourceTypeSchemaPost
+	SourceTypeAvro
+	SourceTypeORC
+	SourceTypeJSON
+	SourceTypeFixedWidth
+	SourceTypeXLSX
+	SourceTypeArrow
+	SourceTypeMsgpack
+	SourceTypeBSON
+	SourceTypeMongoExport
+	SourceTypeProtobuf
 )
 
 const (
-	SchemaSchema = "schema-schema"
-	TableSchema  = "table-schema"
-	TypeSQL      = "sql"
-	TypeCSV      = "csv"
-	TypeParquet  = "parquet"
-	TypeIgnore   = "ignore"
+	SchemaSchema    = "schema-schema"
+	TableSchema     = "table-schema"
+	SchemaPost      = "schema-post"
+	TypeSQL         = "sql"
+	TypeCSV         = "csv"
+	TypeTSV         = "tsv"
+	TypeParquet     = "parquet"
+	TypeAvro        = "avro"
+	TypeORC         = "orc"
+	TypeJSON        = "json"
+	TypeNDJSON      = "ndjson"
+	TypeFixedWidth  = "fixed-width"
+	TypeXLSX        = "xlsx"
+	TypeArrow       = "arrow"
+	TypeArrows      = "arrows"
+	TypeFeather     = "feather"
+	TypeMsgpack     = "msgpack"
+	TypeBSON        = "bson"
+	TypeMongoExport = "mongoexport"
+	TypeProtobuf    = "protobuf"
+	TypeIgnore      = "ignore"
 )
 
 type Compression int
@@ -41,6 +67,8 @@ const (
 	CompressionLZ4
 	CompressionZStd
 	CompressionXZ
+	CompressionBZ2
+	CompressionSnappy
 )
 
 func parseSourceType(t string) (SourceType, error) {
@@ -49,12 +77,34 @@ func parseSourceType(t string) (SourceType, error) {
 		return SourceTypeSchemaSchema, nil
 	case TableSchema:
 		return SourceTypeTableSchema, nil
+	case SchemaPost:
+		return SourceTypeSchemaPost, nil
 	case TypeSQL:
 		return SourceTypeSQL, nil
-	case TypeCSV:
+	case TypeCSV, TypeTSV:
 		return SourceTypeCSV, nil
 	case TypeParquet:
 		return SourceTypeParquet, nil
+	case TypeAvro:
+		return SourceTypeAvro, nil
+	case TypeORC:
+		return SourceTypeORC, nil
+	case TypeJSON, TypeNDJSON:
+		return SourceTypeJSON, nil
+	case TypeFixedWidth:
+		return SourceTypeFixedWidth, nil
+	case TypeXLSX:
+		return SourceTypeXLSX, nil
+	case TypeArrow, TypeArrows, TypeFeather:
+		return SourceTypeArrow, nil
+	case TypeMsgpack:
+		return SourceTypeMsgpack, nil
+	case TypeBSON:
+		return SourceTypeBSON, nil
+	case TypeMongoExport:
+		return SourceTypeMongoExport, nil
+	case TypeProtobuf:
+		return SourceTypeProtobuf, nil
 	case TypeIgnore:
 		return SourceTypeIgnore, nil
 	default:
@@ -68,27 +118,72 @@ func (s SourceType) String() string {
 		return SchemaSchema
 	case SourceTypeTableSchema:
 		return TableSchema
+	case SourceTypeSchemaPost:
+		return SchemaPost
 	case SourceTypeCSV:
 		return TypeCSV
 	case SourceTypeSQL:
 		return TypeSQL
 	case SourceTypeParquet:
 		return TypeParquet
+	case SourceTypeAvro:
+		return TypeAvro
+	case SourceTypeORC:
+		return TypeORC
+	case SourceTypeJSON:
+		return TypeJSON
+	case SourceTypeFixedWidth:
+		return TypeFixedWidth
+	case SourceTypeXLSX:
+		return TypeXLSX
+	case SourceTypeArrow:
+		return TypeArrow
+	case SourceTypeMsgpack:
+		return TypeMsgpack
+	case SourceTypeBSON:
+		return TypeBSON
+	case SourceTypeMongoExport:
+		return TypeMongoExport
+	case SourceTypeProtobuf:
+		return TypeProtobuf
 	default:
 		return TypeIgnore
 	}
 }
 
+func (c Compression) String() string {
+	switch c {
+	case CompressionGZ:
+		return "gz"
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZStd:
+		return "zstd"
+	case CompressionXZ:
+		return "xz"
+	case CompressionBZ2:
+		return "bz2"
+	case CompressionSnappy:
+		return "snappy"
+	default:
+		return "none"
+	}
+}
+
 func parseCompressionType(t string) (Compression, error) {
 	switch strings.ToLower(strings.TrimSpace(t)) {
 	case "gz":
 		return CompressionGZ, nil
 	case "lz4":
 		return CompressionLZ4, nil
-	case "zstd":
+	case "zstd", "zst":
 		return CompressionZStd, nil
 	case "xz":
 		return CompressionXZ, nil
+	case "bz2", "bzip2":
+		return CompressionBZ2, nil
+	case "snappy", "sz":
+		return CompressionSnappy, nil
 	case "":
 		return CompressionNone, nil
 	default:
@@ -102,14 +197,20 @@ var (
 
 var (
 	defaultFileRouteRules = []*config.FileRouteRule{
-		// ignore *-schema-view.sql,-schema-trigger.sql,-schema-post.sql files
-		{Pattern: `(?i).*(-schema-view|-schema-trigger|-schema-post)\.sql`, Type: "ignore"},
+		// ignore *-schema-view.sql,-schema-trigger.sql files
+		{Pattern: `(?i).*(-schema-view|-schema-trigger)\.sql`, Type: "ignore"},
+		// post schema file pattern, matches files like '{schema}-schema-post.sql' or '{schema}.{table}-schema-post.sql'
+		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)(?:\.(.*?))?-schema-post\.sql`, Schema: "$1", Table: "$2", Type: SchemaPost},
 		// db schema create file pattern, matches files like '{schema}-schema-create.sql'
 		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)-schema-create\.sql`, Schema: "$1", Table: "", Type: SchemaSchema},
 		// table schema create file pattern, matches files like '{schema}.{table}-schema.sql'
 		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)\.(.*?)-schema\.sql`, Schema: "$1", Table: "$2", Type: TableSchema},
-		// source file pattern, matches files like '{schema}.{table}.0001.{sql|csv}'
-		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)\.(.*?)(?:\.([0-9]+))?\.(sql|csv|parquet)$`, Schema: "$1", Table: "$2", Type: "$4", Key: "$3"},
+		// source file pattern, matches files like '{schema}.{table}.0001.{sql|csv|tsv|parquet|avro|orc|json|ndjson|arrow|arrows|feather|msgpack|bson}'
+		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)\.(.*?)(?:\.([0-9]+))?\.(sql|csv|tsv|parquet|avro|orc|json|ndjson|arrow|arrows|feather|msgpack|bson)$`, Schema: "$1", Table: "$2", Type: "$4", Key: "$3"},
+		// compressed source file pattern, matches files like '{schema}.{table}.0001.{sql|csv|tsv}.{gz|lz4|zst|xz|bz2|snappy}'
+		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)\.(.*?)(?:\.([0-9]+))?\.(sql|csv|tsv)\.(gz|lz4|zst|xz|bz2|snappy)$`, Schema: "$1", Table: "$2", Type: "$4", Key: "$3", Compression: "$5"},
+		// sheet pattern of the excel workbooks, matches the sheets like '{schema}.xlsx/{table}'
+		{Pattern: `(?i)^(?:[^/]*/)*([^/.]+)\.xlsx/([^/]+)$`, Schema: "$1", Table: "$2", Type: TypeXLSX},
 	}
 )
 
@@ -136,6 +237,12 @@ func (c chainRouters) Route(path string) (*RouteResult, error) {
 	return nil, nil
 }
 
+// DefaultFileRouteRules returns the file routing rules applied when
+// `mydumper.default-file-rules` is enabled.
+func DefaultFileRouteRules() []*config.FileRouteRule {
+	return append([]*config.FileRouteRule(nil), defaultFileRouteRules...)
+}
+
 func NewFileRouter(cfg []*config.FileRouteRule) (FileRouter, error) {
 	res := make([]FileRouter, 0, len(cfg))
 	p := regexRouterParser{}
@@ -155,6 +262,7 @@ func NewFileRouter(cfg []*config.FileRouteRule) (FileRouter, error) {
 type RegexRouter struct {
 	pattern    *regexp.Regexp
 	extractors []patExpander
+	directives string
 }
 
 func (r *RegexRouter) Route(path string) (*RouteResult, error) {
@@ -162,7 +270,7 @@ func (r *RegexRouter) Route(path string) (*RouteResult, error) {
 	if len(indexes) == 0 {
 		return nil, nil
 	}
-	result := &RouteResult{}
+	result := &RouteResult{Directives: r.directives}
 	for _, e := range r.extractors {
 		err := e.Expand(r.pattern, path, indexes, result)
 		if err != nil {
@@ -261,6 +369,15 @@ func (p regexRouterParser) Parse(r *config.FileRouteRule) (*RegexRouter, error)
 		}
 	}
 
+	// the directives are not templates, and are checked here so a mistake
+	// fails the task before any file is read.
+	if len(r.Directives) > 0 {
+		if err := (&FileDirectives{}).parse(r.Directives); err != nil {
+			return nil, errors.Annotatef(err, "invalid directives '%s' in [[mydumper.files]]", r.Directives)
+		}
+		rule.directives = r.Directives
+	}
+
 	return rule, nil
 }
 
@@ -330,4 +447,6 @@ type RouteResult struct {
 	Key         string
 	Compression Compression
 	Type        SourceType
+	// Directives are the parsing settings of the file given by the rule.
+	Directives string
 }
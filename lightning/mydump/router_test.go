@@ -221,3 +221,91 @@ func (t *testFileRouterSuite) TestRouteWithPath(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(res, IsNil)
 }
+
+func (t *testFileRouterSuite) TestRouteWithDirectives(c *C) {
+	router, err := NewFileRouter([]*config.FileRouteRule{
+		{Pattern: `^daily/([^/.]+)\.([^/.]+)\.([0-9]+)\.csv$`, Schema: "$1", Table: "$2", Type: "csv", Key: "$3", Directives: "separator='|', header=false"},
+		{Path: "db.t.sql", Schema: "db", Table: "t", Type: "sql", Directives: "charset=$gbk"},
+	})
+	c.Assert(err, ErrorMatches, `invalid directives 'charset=\$gbk' in \[\[mydumper.files\]\]: unsupported charset "\$gbk"`)
+
+	router, err = NewFileRouter([]*config.FileRouteRule{
+		{Pattern: `^daily/([^/.]+)\.([^/.]+)\.([0-9]+)\.csv$`, Schema: "$1", Table: "$2", Type: "csv", Key: "$3", Directives: "separator='|', header=false"},
+		{Path: "db.t.sql", Schema: "db", Table: "t", Type: "sql", Directives: "charset=gbk, null='$1'"},
+	})
+	c.Assert(err, IsNil)
+	res, err := router.Route("daily/db.t.20201110.csv")
+	c.Assert(err, IsNil)
+	c.Assert(res, DeepEquals, &RouteResult{
+		Table:      filter.Table{Schema: "db", Name: "t"},
+		Key:        "20201110",
+		Type:       SourceTypeCSV,
+		Directives: "separator='|', header=false",
+	})
+	// the directives are not templates.
+	res, err = router.Route("db.t.sql")
+	c.Assert(err, IsNil)
+	c.Assert(res.Directives, Equals, "charset=gbk, null='$1'")
+}
+
+func (t *testFileRouterSuite) TestDefaultRouteDataFiles(c *C) {
+	router, err := NewFileRouter(defaultFileRouteRules)
+	c.Assert(err, IsNil)
+
+	inputOutputMap := map[string][]string{
+		"db.tbl.sql":              {"db", "tbl", "", TypeSQL},
+		"db.tbl.0001.csv":         {"db", "tbl", "0001", TypeCSV},
+		"dir/db.tbl.0002.parquet": {"db", "tbl", "0002", TypeParquet},
+		"dir/db.tbl.0003.avro":    {"db", "tbl", "0003", TypeAvro},
+		"db.tbl.AVRO":             {"db", "tbl", "", TypeAvro},
+		"dir/db.tbl.0004.orc":     {"db", "tbl", "0004", TypeORC},
+		"db.tbl.0005.json":        {"db", "tbl", "0005", TypeJSON},
+		"db.tbl.ndjson":           {"db", "tbl", "", TypeJSON},
+		"dir/db.xlsx/Sheet 1":     {"db", "Sheet 1", "", TypeXLSX},
+		"db.XLSX/t.v":             {"db", "t.v", "", TypeXLSX},
+		"db.tbl.arrow":            {"db", "tbl", "", TypeArrow},
+		"dir/db.tbl.0006.feather": {"db", "tbl", "0006", TypeArrow},
+		"db.tbl.arrows":           {"db", "tbl", "", TypeArrow},
+		"db.tbl.0007.msgpack":     {"db", "tbl", "0007", TypeMsgpack},
+		"db.tbl.0008.bson":        {"db", "tbl", "0008", TypeBSON},
+		"db.tbl.0009.tsv":         {"db", "tbl", "0009", TypeCSV},
+	}
+	for path, fields := range inputOutputMap {
+		res, err := router.Route(path)
+		c.Assert(err, IsNil)
+		c.Assert(res, NotNil, Commentf("path: %s", path))
+		c.Assert(res.Schema, Equals, fields[0])
+		c.Assert(res.Name, Equals, fields[1])
+		c.Assert(res.Key, Equals, fields[2])
+		c.Assert(res.Type.String(), Equals, fields[3])
+	}
+}
+
+func (t *testFileRouterSuite) TestDefaultRouteCompressedFiles(c *C) {
+	router, err := NewFileRouter(defaultFileRouteRules)
+	c.Assert(err, IsNil)
+
+	inputOutputMap := map[string][]string{
+		"db.tbl.sql.gz":           {"db", "tbl", "", TypeSQL, "gz"},
+		"db.tbl.0001.csv.zst":     {"db", "tbl", "0001", TypeCSV, "zstd"},
+		"dir/db.tbl.0002.csv.lz4": {"db", "tbl", "0002", TypeCSV, "lz4"},
+		"db.tbl.0003.TSV.LZ4":     {"db", "tbl", "0003", TypeCSV, "lz4"},
+		"db.tbl.0004.sql.xz":      {"db", "tbl", "0004", TypeSQL, "xz"},
+		"db.tbl.0005.csv.bz2":     {"db", "tbl", "0005", TypeCSV, "bz2"},
+		"db.tbl.0006.csv.snappy":  {"db", "tbl", "0006", TypeCSV, "snappy"},
+	}
+	for path, fields := range inputOutputMap {
+		res, err := router.Route(path)
+		c.Assert(err, IsNil)
+		c.Assert(res, NotNil, Commentf("path: %s", path))
+		c.Assert(res.Schema, Equals, fields[0])
+		c.Assert(res.Name, Equals, fields[1])
+		c.Assert(res.Key, Equals, fields[2])
+		c.Assert(res.Type.String(), Equals, fields[3])
+		c.Assert(res.Compression.String(), Equals, fields[4])
+	}
+
+	res, err := router.Route("db.tbl.parquet.lz4")
+	c.Assert(err, IsNil)
+	c.Assert(res, IsNil)
+}
@@ -0,0 +1,356 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+const (
+	s3Endpoint        = "endpoint"
+	s3Region          = "region"
+	s3AccessKey       = "access-key"
+	s3SecretAccessKey = "secret-access-key"
+	s3ForcePathStyle  = "force-path-style"
+
+	s3DefaultRegion = "us-east-1"
+	// s3ListPageSize is the number of the objects listed in each request.
+	s3ListPageSize = 1000
+	// s3CustomerAlgorithm is the only algorithm of SSE-C.
+	s3CustomerAlgorithm = "AES256"
+	// s3RoleSessionName names the sessions of the assumed role in the logs of
+	// CloudTrail.
+	s3RoleSessionName = "tidb-lightning"
+)
+
+// isS3URL returns whether the data source is in Amazon S3, i.e.
+// `s3://bucket/path`.
+func isS3URL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "s3://")
+}
+
+// S3Options locates the bucket and the credentials of the data source in
+// Amazon S3 or a compatible service, in the same parameters as BR.
+type S3Options struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	Region   string
+	// AccessKey and SecretAccessKey are the static credentials. The default
+	// credential chain of AWS is used without them.
+	AccessKey       string
+	SecretAccessKey string
+	ForcePathStyle  bool
+	// CustomerKey is the AES-256 key of the server-side encryption with the
+	// customer-provided keys (SSE-C), which is sent with every request
+	// reading or writing the objects.
+	CustomerKey []byte
+	// RoleARN is the role assumed with the credentials above, and ExternalID
+	// is passed to the role if required by its trust policy.
+	RoleARN    string
+	ExternalID string
+	// RequesterPays acknowledges that the requests are charged to the reader.
+	RequesterPays bool
+}
+
+// ApplyConfig applies the options of `mydumper.s3` which the URL does not
+// give.
+func (o *S3Options) ApplyConfig(cfg *config.S3Config) {
+	if cfg == nil {
+		return
+	}
+	if len(o.Endpoint) == 0 {
+		o.Endpoint = cfg.BucketEndpoints[o.Bucket]
+	}
+	o.RoleARN = cfg.RoleARN
+	o.ExternalID = cfg.ExternalID
+	o.RequesterPays = cfg.RequesterPays
+}
+
+// ParseS3URL parses the options from the data source URL, e.g.
+//
+//	s3://bucket/path?region=us-west-2&access-key=...&secret-access-key=...
+func ParseS3URL(sourceDir string, customerKey []byte) (*S3Options, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("missing the bucket of the s3 url")
+	}
+	query := u.Query()
+	opts := &S3Options{
+		Bucket:          u.Host,
+		Prefix:          strings.Trim(u.Path, "/"),
+		Endpoint:        query.Get(s3Endpoint),
+		Region:          query.Get(s3Region),
+		AccessKey:       query.Get(s3AccessKey),
+		SecretAccessKey: query.Get(s3SecretAccessKey),
+		ForcePathStyle:  true,
+		CustomerKey:     customerKey,
+	}
+	if len(opts.Region) == 0 {
+		opts.Region = s3DefaultRegion
+	}
+	if forcePathStyle := query.Get(s3ForcePathStyle); len(forcePathStyle) > 0 {
+		opts.ForcePathStyle, err = strconv.ParseBool(forcePathStyle)
+		if err != nil {
+			return nil, errors.Errorf("invalid s3 force-path-style '%s'", forcePathStyle)
+		}
+	}
+	if (len(opts.AccessKey) == 0) != (len(opts.SecretAccessKey) == 0) {
+		return nil, errors.New("the access-key and the secret-access-key of s3 must be set together")
+	}
+	return opts, nil
+}
+
+// s3Storage reads the data source from a bucket of S3 encrypted with the
+// customer-provided key, of a role to be assumed, or of requester pays, which
+// BR does not support. The methods of
+// storage.ExternalStorage not overridden, which are not used on the data
+// source, are not supported.
+type s3Storage struct {
+	storage.ExternalStorage
+	svc         *s3.S3
+	bucket      string
+	prefix      string
+	customerKey *string
+	// requestPayer is nil unless the bucket is of requester pays.
+	requestPayer *string
+}
+
+// NewS3Storage opens the bucket of the options.
+func NewS3Storage(opts *S3Options) (storage.ExternalStorage, error) {
+	return newS3Storage(opts, nil)
+}
+
+func newS3Storage(opts *S3Options, httpClient *http.Client) (*s3Storage, error) {
+	cfg := aws.NewConfig().WithRegion(opts.Region)
+	if len(opts.AccessKey) > 0 {
+		cfg.WithCredentials(credentials.NewStaticCredentials(opts.AccessKey, opts.SecretAccessKey, ""))
+	}
+	if httpClient != nil {
+		cfg.WithHTTPClient(httpClient)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "create the s3 session")
+	}
+	if len(opts.RoleARN) > 0 {
+		// the temporary credentials of the role are requested from STS with
+		// the credentials of the session, and refreshed before expired.
+		sess = sess.Copy(aws.NewConfig().WithCredentials(stscreds.NewCredentials(sess, opts.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = s3RoleSessionName
+			if len(opts.ExternalID) > 0 {
+				p.ExternalID = aws.String(opts.ExternalID)
+			}
+		})))
+	}
+
+	// the endpoint is of S3 only, and not of STS.
+	s3Cfg := aws.NewConfig().WithS3ForcePathStyle(opts.ForcePathStyle)
+	if len(opts.Endpoint) > 0 {
+		s3Cfg.WithEndpoint(opts.Endpoint)
+	}
+	prefix := opts.Prefix
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	s := &s3Storage{svc: s3.New(sess, s3Cfg), bucket: opts.Bucket, prefix: prefix}
+	if len(opts.CustomerKey) > 0 {
+		// the SDK encodes the key and adds its digest to the requests.
+		s.customerKey = aws.String(string(opts.CustomerKey))
+	}
+	if opts.RequesterPays {
+		s.requestPayer = aws.String(s3.RequestPayerRequester)
+	}
+	return s, nil
+}
+
+// sseAlgorithm returns the algorithm of the SSE-C headers, or nil without the
+// customer key.
+func (s *s3Storage) sseAlgorithm() *string {
+	if s.customerKey == nil {
+		return nil
+	}
+	return aws.String(s3CustomerAlgorithm)
+}
+
+func (s *s3Storage) Write(ctx context.Context, name string, data []byte) error {
+	_, err := s.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(s.prefix + name),
+		Body:                 bytes.NewReader(data),
+		SSECustomerAlgorithm: s.sseAlgorithm(),
+		SSECustomerKey:       s.customerKey,
+		RequestPayer:         s.requestPayer,
+	})
+	return errors.Annotatef(err, "write %s", name)
+}
+
+func (s *s3Storage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *s3Storage) head(ctx context.Context, name string) (*s3.HeadObjectOutput, error) {
+	return s.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(s.bucket),
+		Key:                  aws.String(s.prefix + name),
+		SSECustomerAlgorithm: s.sseAlgorithm(),
+		SSECustomerKey:       s.customerKey,
+		RequestPayer:         s.requestPayer,
+	})
+}
+
+func (s *s3Storage) FileExists(ctx context.Context, name string) (bool, error) {
+	_, err := s.head(ctx, name)
+	if err != nil {
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, errors.Annotatef(err, "stat %s", name)
+	}
+	return true, nil
+}
+
+func (s *s3Storage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	output, err := s.head(ctx, name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	return &s3Reader{ctx: ctx, storage: s, name: name, size: aws.Int64Value(output.ContentLength)}, nil
+}
+
+// WalkDir lists all objects under the prefix. The objects are listed in the
+// lexicographical order of their keys.
+func (s *s3Storage) WalkDir(ctx context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:       aws.String(s.bucket),
+		Prefix:       aws.String(s.prefix),
+		MaxKeys:      aws.Int64(s3ListPageSize),
+		RequestPayer: s.requestPayer,
+	}
+	for {
+		res, err := s.svc.ListObjectsV2WithContext(ctx, input)
+		if err != nil {
+			return errors.Annotate(err, "list s3 objects")
+		}
+		for _, object := range res.Contents {
+			key := aws.StringValue(object.Key)
+			// skip the placeholders of the directories.
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			if err := fn(strings.TrimPrefix(key, s.prefix), aws.Int64Value(object.Size)); err != nil {
+				return err
+			}
+		}
+		if !aws.BoolValue(res.IsTruncated) {
+			return nil
+		}
+		input.ContinuationToken = res.NextContinuationToken
+	}
+}
+
+// s3Reader reads an object from the offset with a single ranged GET, which
+// restarts after seeking.
+type s3Reader struct {
+	ctx     context.Context
+	storage *s3Storage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		s := r.storage
+		output, err := s.svc.GetObjectWithContext(r.ctx, &s3.GetObjectInput{
+			Bucket:               aws.String(s.bucket),
+			Key:                  aws.String(s.prefix + r.name),
+			Range:                aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+			SSECustomerAlgorithm: s.sseAlgorithm(),
+			SSECustomerKey:       s.customerKey,
+			RequestPayer:         s.requestPayer,
+		})
+		if err != nil {
+			return 0, errors.Annotatef(err, "read %s", r.name)
+		}
+		r.body = output.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.Errorf("invalid seek offset %d of %s", offset, r.name)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
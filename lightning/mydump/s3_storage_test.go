@@ -0,0 +1,269 @@
+package mydump
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&testS3Suite{})
+
+type testS3Suite struct{}
+
+func (s *testS3Suite) TestParseURL(c *C) {
+	opts, err := ParseS3URL("s3://dumps/2020/db/?region=us-west-2&access-key=id&secret-access-key=secret&force-path-style=false", []byte("key"))
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &S3Options{
+		Bucket:          "dumps",
+		Prefix:          "2020/db",
+		Region:          "us-west-2",
+		AccessKey:       "id",
+		SecretAccessKey: "secret",
+		CustomerKey:     []byte("key"),
+	})
+
+	opts, err = ParseS3URL("s3://dumps?endpoint=https://127.0.0.1:9000", nil)
+	c.Assert(err, IsNil)
+	c.Assert(opts.Endpoint, Equals, "https://127.0.0.1:9000")
+	c.Assert(opts.Region, Equals, "us-east-1")
+	c.Assert(opts.ForcePathStyle, IsTrue)
+
+	_, err = ParseS3URL("s3:///db", nil)
+	c.Assert(err, ErrorMatches, "missing the bucket of the s3 url")
+	_, err = ParseS3URL("s3://dumps/db?force-path-style=maybe", nil)
+	c.Assert(err, ErrorMatches, "invalid s3 force-path-style 'maybe'")
+	_, err = ParseS3URL("s3://dumps/db?access-key=id", nil)
+	c.Assert(err, ErrorMatches, "the access-key and the secret-access-key of s3 must be set together")
+}
+
+func (s *testS3Suite) TestApplyConfig(c *C) {
+	cfg := &config.S3Config{
+		RoleARN:       "arn:aws:iam::123456789012:role/import",
+		ExternalID:    "lightning",
+		RequesterPays: true,
+		BucketEndpoints: map[string]string{
+			"dumps": "https://s3.eu-central-1.amazonaws.com",
+		},
+	}
+	opts, err := ParseS3URL("s3://dumps/db", nil)
+	c.Assert(err, IsNil)
+	opts.ApplyConfig(cfg)
+	c.Assert(opts.Endpoint, Equals, "https://s3.eu-central-1.amazonaws.com")
+	c.Assert(opts.RoleARN, Equals, "arn:aws:iam::123456789012:role/import")
+	c.Assert(opts.ExternalID, Equals, "lightning")
+	c.Assert(opts.RequesterPays, IsTrue)
+
+	// the endpoint in the url is kept.
+	opts, err = ParseS3URL("s3://dumps/db?endpoint=http://127.0.0.1:9000", nil)
+	c.Assert(err, IsNil)
+	opts.ApplyConfig(cfg)
+	c.Assert(opts.Endpoint, Equals, "http://127.0.0.1:9000")
+
+	opts, err = ParseS3URL("s3://other/db", nil)
+	c.Assert(err, IsNil)
+	opts.ApplyConfig(cfg)
+	c.Assert(opts.Endpoint, Equals, "")
+	opts.ApplyConfig(nil)
+	c.Assert(opts.RequesterPays, IsTrue)
+}
+
+// fakeS3 serves the S3 operations used by the data source over the objects
+// of a bucket in memory, with the path-style URLs, and requires the SSE-C
+// headers of the key, if any, on the objects, and the header of requester
+// pays on all requests if the bucket is so.
+type fakeS3 struct {
+	c             *C
+	key           []byte
+	requesterPays bool
+	mu            sync.Mutex
+	objects       map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.c.Assert(strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/"), IsTrue)
+	f.c.Assert(req.Header.Get("X-Amz-Request-Payer") == "requester", Equals, f.requesterPays)
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, "/dumps"), "/")
+	if len(key) == 0 {
+		f.list(w, req)
+		return
+	}
+
+	if f.key != nil {
+		digest := md5.Sum(f.key)
+		f.c.Assert(req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm"), Equals, "AES256")
+		f.c.Assert(req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key"), Equals, base64.StdEncoding.EncodeToString(f.key))
+		f.c.Assert(req.Header.Get("X-Amz-Server-Side-Encryption-Customer-Key-Md5"), Equals, base64.StdEncoding.EncodeToString(digest[:]))
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		data, err := ioutil.ReadAll(req.Body)
+		f.c.Assert(err, IsNil)
+		f.objects[key] = data
+		return
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		if req.Method != http.MethodHead {
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+		}
+		return
+	}
+	http.ServeContent(w, req, key, time.Time{}, strings.NewReader(string(data)))
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	f.c.Assert(query.Get("list-type"), Equals, "2")
+	maxKeys, err := strconv.Atoi(query.Get("max-keys"))
+	f.c.Assert(err, IsNil)
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) && key > query.Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type object struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool     `xml:"IsTruncated"`
+		NextContinuationToken string   `xml:"NextContinuationToken"`
+		Objects               []object `xml:"Contents"`
+	}{}
+	// list 2 objects at most, to test the pagination.
+	if maxKeys > 2 {
+		maxKeys = 2
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, key := range keys {
+		result.Objects = append(result.Objects, object{Key: key, Size: len(f.objects[key])})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	f.c.Assert(xml.NewEncoder(w).Encode(&result), IsNil)
+}
+
+func (s *testS3Suite) TestStorage(c *C) {
+	customerKey := []byte("0123456789abcdef0123456789abcdef")
+	fake := &fakeS3{c: c, key: customerKey, objects: map[string][]byte{
+		"db/db-schema-create.sql":   []byte("CREATE DATABASE db;"),
+		"db/db.t-schema.sql":        []byte("CREATE TABLE t (a int);"),
+		"db/data/":                  nil,
+		"db/data/db.t.000000.sql":   []byte("INSERT INTO t VALUES (1),(2),(3);"),
+		"db/data/db.t.000001.sql":   []byte("INSERT INTO t VALUES (5);"),
+		"other/db.t.000000.sql":     []byte("INSERT INTO t VALUES (4);"),
+		"db2/db2-schema-create.sql": []byte("CREATE DATABASE db2;"),
+	}}
+	// the SDK refuses to send the customer key over plain HTTP.
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+
+	opts, err := ParseS3URL(fmt.Sprintf("s3://dumps/db?endpoint=%s&access-key=id&secret-access-key=secret", server.URL), customerKey)
+	c.Assert(err, IsNil)
+	store, err := newS3Storage(opts, server.Client())
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	var paths []string
+	err = store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, fmt.Sprintf("%s:%d", path, size))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{
+		"data/db.t.000000.sql:33",
+		"data/db.t.000001.sql:25",
+		"db-schema-create.sql:19",
+		"db.t-schema.sql:23",
+	})
+
+	exists, err := store.FileExists(ctx, "db.t-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "db.u-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	data, err := store.Read(ctx, "db-schema-create.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "CREATE DATABASE db;")
+
+	reader, err := store.Open(ctx, "data/db.t.000000.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	_, err = reader.Seek(21, io.SeekStart)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "(1),(2),(3);")
+	_, err = reader.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ",(3);")
+
+	_, err = store.Open(ctx, "db.u-schema.sql")
+	c.Assert(err, ErrorMatches, "(?s)open db.u-schema.sql: .*404.*")
+
+	c.Assert(store.Write(ctx, "checkpoint.pb", []byte("checkpoint")), IsNil)
+	c.Assert(string(fake.objects["db/checkpoint.pb"]), Equals, "checkpoint")
+}
+
+func (s *testS3Suite) TestRequesterPays(c *C) {
+	fake := &fakeS3{c: c, requesterPays: true, objects: map[string][]byte{
+		"db/db.t.000000.sql": []byte("INSERT INTO t VALUES (1);"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	opts, err := ParseS3URL("s3://dumps/db?access-key=id&secret-access-key=secret", nil)
+	c.Assert(err, IsNil)
+	opts.ApplyConfig(&config.S3Config{
+		RequesterPays:   true,
+		BucketEndpoints: map[string]string{"dumps": server.URL},
+	})
+	store, err := newS3Storage(opts, server.Client())
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	var paths []string
+	err = store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{"db.t.000000.sql"})
+	exists, err := store.FileExists(ctx, "db.t.000000.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	data, err := store.Read(ctx, "db.t.000000.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "INSERT INTO t VALUES (1);")
+}
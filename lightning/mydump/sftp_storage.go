@@ -0,0 +1,309 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pkg/sftp"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	sftpIdentityFile = "identity-file"
+	sftpPassphrase   = "key-passphrase"
+	sftpKnownHosts   = "known-hosts"
+	sftpConnections  = "connections"
+
+	defaultSFTPConnections = 4
+)
+
+// isSFTPURL returns whether the data source is on an SSH server, i.e.
+// `sftp://user@host:port/path`.
+func isSFTPURL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "sftp://")
+}
+
+// SFTPOptions locates the directory and the credentials of the data source
+// on an SSH server.
+type SFTPOptions struct {
+	// Addr is the host and the port of the SSH server.
+	Addr string
+	User string
+	Path string
+	// IdentityFiles are the private keys tried in order, after the keys of
+	// the SSH agent.
+	IdentityFiles []string
+	// Passphrase decrypts the encrypted private keys.
+	Passphrase string
+	// KnownHosts is the file of the trusted host keys.
+	KnownHosts string
+	// Connections is the number of the SSH connections, which the opened
+	// files are spread over.
+	Connections int
+}
+
+// ParseSFTPURL parses the options from the data source URL, e.g.
+//
+//	sftp://user@bastion:22/dumps/db?identity-file=/home/user/.ssh/id_ed25519&connections=8
+//
+// The user defaults to the current user, and the identity files default to
+// ~/.ssh/id_ed25519, ~/.ssh/id_ecdsa and ~/.ssh/id_rsa. The host key must be
+// in the known hosts file, which defaults to ~/.ssh/known_hosts.
+func ParseSFTPURL(sourceDir string) (*SFTPOptions, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Hostname()) == 0 {
+		return nil, errors.New("missing the host of the sftp url")
+	}
+	query := u.Query()
+	home, _ := os.UserHomeDir()
+
+	opts := &SFTPOptions{
+		Addr:        u.Host,
+		Path:        u.Path,
+		Passphrase:  query.Get(sftpPassphrase),
+		KnownHosts:  query.Get(sftpKnownHosts),
+		Connections: defaultSFTPConnections,
+	}
+	if len(u.Port()) == 0 {
+		opts.Addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	if len(opts.Path) == 0 {
+		opts.Path = "."
+	}
+	if u.User != nil {
+		opts.User = u.User.Username()
+	}
+	if len(opts.User) == 0 {
+		opts.User = os.Getenv("USER")
+	}
+	if identityFiles, ok := query[sftpIdentityFile]; ok {
+		opts.IdentityFiles = identityFiles
+	} else if len(home) > 0 {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			opts.IdentityFiles = append(opts.IdentityFiles, filepath.Join(home, ".ssh", name))
+		}
+	}
+	if len(opts.KnownHosts) == 0 && len(home) > 0 {
+		opts.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	if connections := query.Get(sftpConnections); len(connections) > 0 {
+		opts.Connections, err = strconv.Atoi(connections)
+		if err != nil || opts.Connections <= 0 {
+			return nil, errors.Errorf("invalid sftp connections '%s'", connections)
+		}
+	}
+	return opts, nil
+}
+
+// sftpStorage reads the data source from a directory on an SSH server. The
+// methods of storage.ExternalStorage not overridden, which are not used on
+// the data source, are not supported.
+type sftpStorage struct {
+	storage.ExternalStorage
+	clients []*sftp.Client
+	dir     string
+	next    uint32
+}
+
+// NewSFTPStorage connects to the SSH server of the options. The connections
+// are kept until the process exits.
+func NewSFTPStorage(ctx context.Context, opts *SFTPOptions) (storage.ExternalStorage, error) {
+	sshConfig, err := newSSHClientConfig(opts)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	clients := make([]*sftp.Client, 0, opts.Connections)
+	closeAll := func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}
+	var dialer net.Dialer
+	for i := 0; i < opts.Connections; i++ {
+		conn, err := dialer.DialContext(ctx, "tcp", opts.Addr)
+		if err != nil {
+			closeAll()
+			return nil, errors.Annotatef(err, "connect to sftp server %s", opts.Addr)
+		}
+		sshConn, chans, reqs, err := ssh.NewClientConn(conn, opts.Addr, sshConfig)
+		if err != nil {
+			conn.Close()
+			closeAll()
+			return nil, errors.Annotatef(err, "connect to sftp server %s", opts.Addr)
+		}
+		client, err := sftp.NewClient(ssh.NewClient(sshConn, chans, reqs))
+		if err != nil {
+			sshConn.Close()
+			closeAll()
+			return nil, errors.Annotatef(err, "start sftp session on %s", opts.Addr)
+		}
+		clients = append(clients, client)
+	}
+	log.L().Info("connected to sftp server", zap.String("addr", opts.Addr),
+		zap.String("user", opts.User), zap.Int("connections", len(clients)))
+	return newSFTPStorage(clients, opts.Path), nil
+}
+
+func newSFTPStorage(clients []*sftp.Client, dir string) *sftpStorage {
+	return &sftpStorage{clients: clients, dir: dir}
+}
+
+func newSSHClientConfig(opts *SFTPOptions) (*ssh.ClientConfig, error) {
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); len(sock) > 0 {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	for _, identityFile := range opts.IdentityFiles {
+		data, err := ioutil.ReadFile(identityFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "read sftp identity file %s", identityFile)
+		}
+		var signer ssh.Signer
+		if len(opts.Passphrase) > 0 {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(opts.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(data)
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "parse sftp identity file %s", identityFile)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no private key for sftp, please set `identity-file` or start an ssh agent")
+	}
+
+	hostKeyCallback, err := knownhosts.New(opts.KnownHosts)
+	if err != nil {
+		return nil, errors.Annotatef(err, "read sftp known hosts %s", opts.KnownHosts)
+	}
+	return &ssh.ClientConfig{
+		User:            opts.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+// client returns the connections in turn, so the files read concurrently
+// are spread over them.
+func (s *sftpStorage) client() *sftp.Client {
+	return s.clients[atomic.AddUint32(&s.next, 1)%uint32(len(s.clients))]
+}
+
+func (s *sftpStorage) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+func (s *sftpStorage) Write(_ context.Context, name string, data []byte) error {
+	client := s.client()
+	if err := client.MkdirAll(path.Dir(s.path(name))); err != nil {
+		return errors.Annotatef(err, "write %s", name)
+	}
+	file, err := client.Create(s.path(name))
+	if err != nil {
+		return errors.Annotatef(err, "write %s", name)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return errors.Annotatef(err, "write %s", name)
+}
+
+func (s *sftpStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	file, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *sftpStorage) FileExists(_ context.Context, name string) (bool, error) {
+	info, err := s.client().Stat(s.path(name))
+	if err == nil {
+		return info.Mode().IsRegular(), nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, errors.Annotatef(err, "stat %s", name)
+}
+
+func (s *sftpStorage) Open(_ context.Context, name string) (storage.ReadSeekCloser, error) {
+	file, err := s.client().Open(s.path(name))
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	return file, nil
+}
+
+// WalkDir lists all regular files under the directory recursively. The files
+// are listed in the lexicographical order of the names in each directory,
+// and the symbolic links are not followed.
+func (s *sftpStorage) WalkDir(_ context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	return s.walk(s.client(), "", fn)
+}
+
+func (s *sftpStorage) walk(client *sftp.Client, dir string, fn func(path string, size int64) error) error {
+	infos, err := client.ReadDir(s.path(dir))
+	if err != nil {
+		return errors.Annotatef(err, "list sftp directory '%s'", s.path(dir))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	for _, info := range infos {
+		name := path.Join(dir, info.Name())
+		switch {
+		case info.IsDir():
+			err = s.walk(client, name, fn)
+		case info.Mode().IsRegular():
+			err = fn(name, info.Size())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
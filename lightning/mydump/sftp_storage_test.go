@@ -0,0 +1,134 @@
+package mydump
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pkg/sftp"
+)
+
+var _ = Suite(&testSFTPSuite{})
+
+type testSFTPSuite struct{}
+
+func (s *testSFTPSuite) TestParseURL(c *C) {
+	opts, err := ParseSFTPURL("sftp://alice@bastion/dumps/db?identity-file=/keys/a&identity-file=/keys/b&known-hosts=/keys/known_hosts&connections=8")
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &SFTPOptions{
+		Addr:          "bastion:22",
+		User:          "alice",
+		Path:          "/dumps/db",
+		IdentityFiles: []string{"/keys/a", "/keys/b"},
+		KnownHosts:    "/keys/known_hosts",
+		Connections:   8,
+	})
+
+	user := os.Getenv("USER")
+	os.Setenv("USER", "bob")
+	defer os.Setenv("USER", user)
+	opts, err = ParseSFTPURL("sftp://[::1]:2222?key-passphrase=secret")
+	c.Assert(err, IsNil)
+	c.Assert(opts.Addr, Equals, "[::1]:2222")
+	c.Assert(opts.User, Equals, "bob")
+	c.Assert(opts.Path, Equals, ".")
+	c.Assert(opts.Passphrase, Equals, "secret")
+	c.Assert(opts.Connections, Equals, defaultSFTPConnections)
+
+	_, err = ParseSFTPURL("sftp:///dumps")
+	c.Assert(err, ErrorMatches, "missing the host of the sftp url")
+	_, err = ParseSFTPURL("sftp://bastion/dumps?connections=0")
+	c.Assert(err, ErrorMatches, "invalid sftp connections '0'")
+}
+
+// pipeSFTPClient connects to an in-process sftp server of the local file
+// system.
+func pipeSFTPClient(c *C) *sftp.Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	c.Assert(err, IsNil)
+	go func() {
+		// the client is closed after the server stops.
+		_ = server.Serve()
+		serverWriter.Close()
+	}()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	c.Assert(err, IsNil)
+	return client
+}
+
+func (s *testSFTPSuite) TestStorage(c *C) {
+	dir := c.MkDir()
+	write := func(name string, content string) {
+		c.Assert(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+	write("db-schema-create.sql", "CREATE DATABASE db;")
+	write("db.t-schema.sql", "CREATE TABLE t (a int);")
+	write("data/db.t.000001.sql", "INSERT INTO t VALUES (5);")
+	write("data/db.t.000000.sql", "INSERT INTO t VALUES (1),(2),(3);")
+	c.Assert(os.Symlink(filepath.Join(dir, "db.t-schema.sql"), filepath.Join(dir, "link.sql")), IsNil)
+
+	clients := []*sftp.Client{pipeSFTPClient(c), pipeSFTPClient(c)}
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	store := newSFTPStorage(clients, dir)
+	ctx := context.Background()
+
+	var paths []string
+	err := store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, path)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{
+		"data/db.t.000000.sql",
+		"data/db.t.000001.sql",
+		"db-schema-create.sql",
+		"db.t-schema.sql",
+	})
+
+	exists, err := store.FileExists(ctx, "db.t-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "data")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+	exists, err = store.FileExists(ctx, "db.u-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	data, err := store.Read(ctx, "db-schema-create.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "CREATE DATABASE db;")
+
+	// the files are opened on the connections in turn.
+	for i := 0; i < 2; i++ {
+		reader, err := store.Open(ctx, "data/db.t.000000.sql")
+		c.Assert(err, IsNil)
+		_, err = reader.Seek(21, io.SeekStart)
+		c.Assert(err, IsNil)
+		data, err = ioutil.ReadAll(reader)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "(1),(2),(3);")
+		c.Assert(reader.Close(), IsNil)
+	}
+
+	_, err = store.Open(ctx, "db.u-schema.sql")
+	c.Assert(err, ErrorMatches, "open db.u-schema.sql: .*")
+
+	c.Assert(store.Write(ctx, "checkpoints/cp.pb", []byte("checkpoint")), IsNil)
+	data, err = ioutil.ReadFile(filepath.Join(dir, "checkpoints", "cp.pb"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "checkpoint")
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// OpenStorage opens the storage of the data source URL. The `faulty+` prefix
+// of the scheme wraps the storage with the faults given by the `fault-*`
+// query parameters, e.g.
+//
+//	faulty+s3://bucket/path?fault-error-rate=0.01&fault-latency=50ms&fault-truncate-rate=0.001
+//
+// The customerKey, if not empty, is the key encrypting the objects of S3 or
+// GCS, see config.MydumperRuntime.SourceCustomerKey. The s3Cfg, if set, is the
+// options of the data source in S3 beyond the URL.
+func OpenStorage(
+	ctx context.Context,
+	sourceDir string,
+	opts *storage.BackendOptions,
+	customerKey []byte,
+	s3Cfg *config.S3Config,
+) (storage.ExternalStorage, error) {
+	faulty := strings.HasPrefix(sourceDir, FaultySchemePrefix)
+	// BR does not send the customer key, assume roles, nor pay for the
+	// requests, so the objects are read by the clients of our own.
+	if isS3URL(sourceDir) && (len(customerKey) > 0 || s3Cfg.IsSet()) {
+		s3Opts, err := ParseS3URL(sourceDir, customerKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s3Opts.ApplyConfig(s3Cfg)
+		store, err := NewS3Storage(s3Opts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if s3Cfg.IsSet() && !faulty {
+		return nil, errors.New("the `mydumper.s3` options are only supported by the data source in s3")
+	}
+	if len(customerKey) > 0 {
+		switch {
+		case isGCSURL(sourceDir):
+			gcsOpts, err := ParseGCSURL(sourceDir, customerKey)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			store, err := NewGCSStorage(ctx, gcsOpts)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			return NewArchiveStorage(store), nil
+		case !faulty:
+			return nil, errors.New("the customer-supplied encryption key is only supported by the data source in s3 or gcs")
+		}
+	}
+	if isAzureBlobURL(sourceDir) {
+		azOpts, err := ParseAzureBlobURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := NewAzureBlobStorage(ctx, azOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if isWebHDFSURL(sourceDir) {
+		hdfsOpts, err := ParseWebHDFSURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(NewWebHDFSStorage(hdfsOpts)), nil
+	}
+	if isWebDAVURL(sourceDir) {
+		davOpts, err := ParseWebDAVURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := NewWebDAVStorage(davOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if isSFTPURL(sourceDir) {
+		sftpOpts, err := ParseSFTPURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := NewSFTPStorage(ctx, sftpOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if isOSSURL(sourceDir) {
+		ossOpts, err := ParseOSSURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := NewOSSStorage(ctx, ossOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if isHTTPURL(sourceDir) {
+		httpOpts, err := ParseHTTPURL(sourceDir)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := NewHTTPStorage(httpOpts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return NewArchiveStorage(store), nil
+	}
+	if !faulty {
+		u, err := storage.ParseBackend(sourceDir, opts)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		store, err := storage.Create(ctx, u, true)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		// the archives in the source are read as the directories.
+		return NewArchiveStorage(store), nil
+	}
+
+	u, err := url.Parse(strings.TrimPrefix(sourceDir, FaultySchemePrefix))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	faultOpts, err := parseFaultOptions(u)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	inner, err := OpenStorage(ctx, u.String(), opts, customerKey, s3Cfg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	log.L().Warn("the data source is injected with faults",
+		zap.Float64("errorRate", faultOpts.ErrorRate),
+		zap.Duration("latency", faultOpts.Latency),
+		zap.Float64("truncateRate", faultOpts.TruncateRate),
+		zap.Int64("seed", faultOpts.Seed),
+	)
+	return NewFaultyStorage(inner, faultOpts), nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// StreamFileSize is the size of the data file read from a stream, which is
+// unknown until the end of the stream.
+const StreamFileSize int64 = -1
+
+// streamStorage is the storage of a single data file read from stdin or a
+// named pipe. The file is named by the data-source-dir, i.e. "-" for stdin,
+// and can only be opened once since the stream cannot be rewound.
+type streamStorage struct {
+	name   string
+	stdin  io.Reader
+	mu     sync.Mutex
+	opened bool
+}
+
+// NewStreamStorage returns the storage of the stream of data-source-dir,
+// which is "-" for stdin or the path of a named pipe.
+func NewStreamStorage(sourceDir string) storage.ExternalStorage {
+	return &streamStorage{name: sourceDir, stdin: os.Stdin}
+}
+
+func (s *streamStorage) Write(_ context.Context, name string, _ []byte) error {
+	return errors.Errorf("write %s: the stream data source is read-only", name)
+}
+
+func (s *streamStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+// FileExists returns whether the name is the stream.
+func (s *streamStorage) FileExists(_ context.Context, name string) (bool, error) {
+	return name == s.name, nil
+}
+
+// Open returns the reader of the stream, which blocks until a writer opens
+// the named pipe.
+func (s *streamStorage) Open(_ context.Context, name string) (storage.ReadSeekCloser, error) {
+	if name != s.name {
+		return nil, errors.Errorf("open %s: the stream data source only has %s", name, s.name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opened {
+		return nil, errors.Errorf("open %s: the stream can only be read once", name)
+	}
+	s.opened = true
+
+	if name == config.StreamSourceDir {
+		return &streamReader{name: name, reader: s.stdin}, nil
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &streamReader{name: name, reader: file, closer: file}, nil
+}
+
+// WalkDir lists the stream with an unknown size.
+func (s *streamStorage) WalkDir(_ context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	return fn(s.name, StreamFileSize)
+}
+
+// streamReader reads the stream sequentially. Seeking forward skips the data,
+// so the chunk restored from the checkpoint continues at its offset when the
+// same data is streamed again.
+type streamReader struct {
+	name   string
+	reader io.Reader
+	closer io.Closer
+	offset int64
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	default:
+		return r.offset, errors.Errorf("cannot seek from the end of the stream %s", r.name)
+	}
+	if offset < r.offset {
+		return r.offset, errors.Errorf("cannot seek backward to offset %d of the stream %s", offset, r.name)
+	}
+	n, err := io.CopyN(ioutil.Discard, r.reader, offset-r.offset)
+	r.offset += n
+	if err == io.EOF {
+		err = errors.Errorf("the stream %s ended before offset %d", r.name, offset)
+	}
+	return r.offset, errors.Trace(err)
+}
+
+func (r *streamReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// streamDatabases returns the table of `mydumper.stream-table`, whose only
+// data file is the stream.
+func streamDatabases(cfg *config.MydumperRuntime) ([]*MDDatabaseMeta, error) {
+	schema, table, err := cfg.StreamTableName()
+	if err != nil {
+		return nil, err
+	}
+	sourceType := SourceTypeSQL
+	if cfg.StreamFormat == config.StreamFormatCSV {
+		sourceType = SourceTypeCSV
+	}
+	dataFile := FileInfo{
+		TableName: filter.Table{Schema: schema, Name: table},
+		FileMeta:  SourceFileMeta{Path: cfg.SourceDir, Type: sourceType},
+		Size:      StreamFileSize,
+	}
+	tableMeta := &MDTableMeta{
+		DB:        schema,
+		Name:      table,
+		DataFiles: []FileInfo{dataFile},
+		charSet:   cfg.CharacterSet,
+	}
+	return []*MDDatabaseMeta{{Name: schema, Tables: []*MDTableMeta{tableMeta}, charSet: cfg.CharacterSet}}, nil
+}
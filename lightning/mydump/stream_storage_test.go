@@ -0,0 +1,103 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&testStreamSuite{})
+
+type testStreamSuite struct{}
+
+func (s *testStreamSuite) TestStorage(c *C) {
+	path := filepath.Join(c.MkDir(), "pipe")
+	c.Assert(ioutil.WriteFile(path, []byte("INSERT INTO t VALUES (1),(2);"), 0644), IsNil)
+	store := NewStreamStorage(path)
+	ctx := context.Background()
+
+	var paths []string
+	var sizes []int64
+	err := store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, path)
+		sizes = append(sizes, size)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{path})
+	c.Assert(sizes, DeepEquals, []int64{StreamFileSize})
+
+	exists, err := store.FileExists(ctx, "metadata")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	reader, err := store.Open(ctx, path)
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	// seeking forward skips the data.
+	pos, err := reader.Seek(21, io.SeekStart)
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, int64(21))
+	data := make([]byte, 4)
+	_, err = io.ReadFull(reader, data)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "(1),")
+	_, err = reader.Seek(0, io.SeekStart)
+	c.Assert(err, ErrorMatches, "cannot seek backward to offset 0 of the stream .*")
+	_, err = reader.Seek(10, io.SeekCurrent)
+	c.Assert(err, ErrorMatches, "the stream .* ended before offset 35")
+
+	_, err = store.Open(ctx, path)
+	c.Assert(err, ErrorMatches, "open .*: the stream can only be read once")
+	_, err = store.Open(ctx, "metadata")
+	c.Assert(err, ErrorMatches, "open metadata: the stream data source only has .*")
+}
+
+func (s *testStreamSuite) TestLoader(c *C) {
+	path := filepath.Join(c.MkDir(), "pipe")
+	c.Assert(ioutil.WriteFile(path, []byte("a,b\n1,2\n"), 0644), IsNil)
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = path
+	cfg.Mydumper.StreamTable = "db.t"
+	cfg.Mydumper.StreamFormat = config.StreamFormatCSV
+
+	loader, err := NewMyDumpLoader(context.Background(), cfg)
+	c.Assert(err, IsNil)
+	dbs := loader.GetDatabases()
+	c.Assert(dbs, HasLen, 1)
+	c.Assert(dbs[0].Name, Equals, "db")
+	c.Assert(dbs[0].Tables, HasLen, 1)
+	table := dbs[0].Tables[0]
+	c.Assert(table.Name, Equals, "t")
+	c.Assert(table.DataFiles, HasLen, 1)
+	c.Assert(table.DataFiles[0].FileMeta.Path, Equals, path)
+	c.Assert(table.DataFiles[0].FileMeta.Type, Equals, SourceTypeCSV)
+
+	ioWorkers := worker.NewPool(context.Background(), 1, "io")
+	regions, err := MakeTableRegions(context.Background(), table, 2, cfg, ioWorkers, loader.GetStore())
+	c.Assert(err, IsNil)
+	c.Assert(regions, HasLen, 1)
+	c.Assert(regions[0].Chunk.Offset, Equals, int64(0))
+	c.Assert(regions[0].Chunk.EndOffset, Equals, TableFileSizeINF)
+	c.Assert(regions[0].Chunk.RowIDMax, Equals, TableFileSizeINF/2)
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pingcap/errors"
+	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
+)
+
+// LoadTableTags reads the table tags file, which maps the table names to the
+// lists of tags, e.g.
+//
+//	"db.tbl" = ["hot", "small"]
+//	[db2]
+//	tbl = ["cold"]
+//
+// The file is parsed as JSON if the name ends with ".json", otherwise TOML.
+// The tags are lower-cased.
+func LoadTableTags(path string) (map[filter.Table][]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to read table tags file '%s'", path)
+	}
+
+	var raw map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &raw)
+	} else {
+		_, err = toml.Decode(string(data), &raw)
+	}
+	if err != nil {
+		return nil, errors.Annotatef(err, "failed to parse table tags file '%s'", path)
+	}
+
+	res := make(map[filter.Table][]string)
+	for key, value := range raw {
+		if tables, ok := value.(map[string]interface{}); ok {
+			for table, value := range tables {
+				if err := addTableTags(res, key, table, value); err != nil {
+					return nil, errors.Annotatef(err, "invalid table tags file '%s'", path)
+				}
+			}
+			continue
+		}
+
+		dot := strings.IndexByte(key, '.')
+		if dot < 0 {
+			return nil, errors.Errorf("invalid table tags file '%s': table name '%s' must be in the form 'db.tbl'", path, key)
+		}
+		if err := addTableTags(res, key[:dot], key[dot+1:], value); err != nil {
+			return nil, errors.Annotatef(err, "invalid table tags file '%s'", path)
+		}
+	}
+	return res, nil
+}
+
+func addTableTags(res map[filter.Table][]string, schema, table string, value interface{}) error {
+	var list []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		list = v
+	case string:
+		list = []interface{}{v}
+	default:
+		return errors.Errorf("tags of '%s.%s' must be a list of strings", schema, table)
+	}
+	tags := make([]string, 0, len(list))
+	for _, item := range list {
+		tag, ok := item.(string)
+		if !ok {
+			return errors.Errorf("tags of '%s.%s' must be a list of strings", schema, table)
+		}
+		tags = append(tags, strings.ToLower(strings.TrimSpace(tag)))
+	}
+	res[filter.Table{Schema: schema, Name: table}] = tags
+	return nil
+}
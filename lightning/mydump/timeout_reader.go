@@ -0,0 +1,231 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var (
+	errOpenTimeout = errors.New("open source file timed out")
+	errReadTimeout = errors.New("read source file timed out")
+)
+
+type openFunc func(ctx context.Context) (storage.ReadSeekCloser, error)
+
+// OpenSourceFile opens a data file for restoring, applying the open and read
+// timeouts in the config.
+//
+// When a single read makes no progress within `mydumper.read-timeout` (e.g.
+// a hung NFS mount or a stuck S3 connection), the file is closed to abort the
+// read, then reopened at the same offset to retry the read, for at most
+// `mydumper.read-retry` times.
+func OpenSourceFile(ctx context.Context, store storage.ExternalStorage, path string, cfg *config.MydumperRuntime) (storage.ReadSeekCloser, error) {
+	open := func(ctx context.Context) (storage.ReadSeekCloser, error) {
+		return store.Open(ctx, path)
+	}
+	return openWithTimeout(ctx, open, path, cfg.OpenTimeout.Duration, cfg.ReadTimeout.Duration, cfg.ReadRetry)
+}
+
+func openWithTimeout(
+	ctx context.Context,
+	open openFunc,
+	path string,
+	openTimeout time.Duration,
+	readTimeout time.Duration,
+	maxRetry int,
+) (storage.ReadSeekCloser, error) {
+	reader, err := openOnce(ctx, open, path, openTimeout)
+	if err != nil || readTimeout <= 0 {
+		return reader, err
+	}
+	return &timeoutReader{
+		ctx:         ctx,
+		open:        open,
+		path:        path,
+		openTimeout: openTimeout,
+		readTimeout: readTimeout,
+		maxRetry:    maxRetry,
+		reader:      reader,
+	}, nil
+}
+
+type openResult struct {
+	reader storage.ReadSeekCloser
+	err    error
+}
+
+func openOnce(ctx context.Context, open openFunc, path string, timeout time.Duration) (storage.ReadSeekCloser, error) {
+	if timeout <= 0 {
+		reader, err := open(ctx)
+		return reader, errors.Trace(err)
+	}
+
+	ch := make(chan openResult, 1)
+	go func() {
+		reader, err := open(ctx)
+		ch <- openResult{reader: reader, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res.reader, errors.Trace(res.err)
+	case <-timer.C:
+		// the hung open may still succeed later, close it then to avoid leaking
+		// the file.
+		go func() {
+			if res := <-ch; res.err == nil {
+				res.reader.Close()
+			}
+		}()
+		return nil, errors.Annotatef(errOpenTimeout, "file '%s' not opened within %s", path, timeout)
+	}
+}
+
+type readResult struct {
+	n   int
+	err error
+}
+
+// timeoutReader is a source file reader where every Read() call is limited by
+// a timeout, and the hung reads are retried on a reopened file.
+type timeoutReader struct {
+	ctx         context.Context
+	open        openFunc
+	path        string
+	openTimeout time.Duration
+	readTimeout time.Duration
+	maxRetry    int
+
+	// reader is nil after a hung read was aborted and the file cannot be
+	// reopened.
+	reader storage.ReadSeekCloser
+	pos    int64
+	// buf receives the data of the underlying reader. it is dropped after
+	// a read is aborted, since the hung read may still write into it.
+	buf []byte
+}
+
+// Read implements io.Reader
+func (tr *timeoutReader) Read(p []byte) (int, error) {
+	for retry := 0; ; retry++ {
+		if tr.reader == nil {
+			return 0, errors.Annotatef(errReadTimeout, "file '%s' is not reopened after a hung read", tr.path)
+		}
+		n, err := tr.readOnce(p)
+		tr.pos += int64(n)
+		if errors.Cause(err) != errReadTimeout {
+			return n, err
+		}
+		if retry >= tr.maxRetry {
+			return n, errors.Annotatef(err, "file '%s' made no progress for %s after %d retries", tr.path, tr.readTimeout, retry)
+		}
+
+		log.L().Warn("read source file made no progress, reopen and retry",
+			zap.String("path", tr.path),
+			zap.Int64("offset", tr.pos),
+			zap.Duration("timeout", tr.readTimeout),
+			zap.Int("retry", retry+1),
+		)
+		common.RecordWarning("", common.WarningHungRead,
+			fmt.Sprintf("read '%s' made no progress for %s, retried", tr.path, tr.readTimeout))
+
+		if err := tr.reopen(); err != nil {
+			return 0, errors.Trace(err)
+		}
+	}
+}
+
+func (tr *timeoutReader) readOnce(p []byte) (int, error) {
+	if cap(tr.buf) < len(p) {
+		tr.buf = make([]byte, len(p))
+	}
+	buf := tr.buf[:len(p)]
+	reader := tr.reader
+
+	ch := make(chan readResult, 1)
+	go func() {
+		n, err := reader.Read(buf)
+		ch <- readResult{n: n, err: err}
+	}()
+
+	timer := time.NewTimer(tr.readTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		copy(p, buf[:res.n])
+		return res.n, res.err
+	case <-tr.ctx.Done():
+		tr.abort()
+		return 0, tr.ctx.Err()
+	case <-timer.C:
+		tr.abort()
+		return 0, errReadTimeout
+	}
+}
+
+// abort closes the underlying reader to kill the hung read.
+func (tr *timeoutReader) abort() {
+	tr.buf = nil
+	tr.reader.Close()
+	tr.reader = nil
+}
+
+func (tr *timeoutReader) reopen() error {
+	reader, err := openOnce(tr.ctx, tr.open, tr.path, tr.openTimeout)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if _, err := reader.Seek(tr.pos, io.SeekStart); err != nil {
+		reader.Close()
+		return errors.Trace(err)
+	}
+	tr.reader = reader
+	return nil
+}
+
+// Seek implements io.Seeker
+func (tr *timeoutReader) Seek(offset int64, whence int) (int64, error) {
+	if tr.reader == nil {
+		return 0, errors.Annotatef(errReadTimeout, "file '%s' is not reopened after a hung read", tr.path)
+	}
+	pos, err := tr.reader.Seek(offset, whence)
+	if err == nil {
+		tr.pos = pos
+	}
+	return pos, err
+}
+
+// Close implements io.Closer
+func (tr *timeoutReader) Close() error {
+	if tr.reader == nil {
+		return nil
+	}
+	err := tr.reader.Close()
+	tr.reader = nil
+	return err
+}
@@ -0,0 +1,80 @@
+package mydump
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+)
+
+var _ = Suite(&testTimeoutReaderSuite{})
+
+type testTimeoutReaderSuite struct{}
+
+// hungReader blocks every Read() until it is closed.
+type hungReader struct {
+	StringReader
+	closed chan struct{}
+}
+
+func (r *hungReader) Read(p []byte) (int, error) {
+	<-r.closed
+	return 0, errors.New("read on closed file")
+}
+
+func (r *hungReader) Close() error {
+	close(r.closed)
+	return nil
+}
+
+func (s *testTimeoutReaderSuite) TestRetryHungRead(c *C) {
+	const content = "0123456789abcdef"
+	opened := 0
+	open := func(context.Context) (storage.ReadSeekCloser, error) {
+		opened++
+		if opened == 1 {
+			return &hungReader{StringReader: NewStringReader(content), closed: make(chan struct{})}, nil
+		}
+		return NewStringReader(content), nil
+	}
+
+	reader, err := openWithTimeout(context.Background(), open, "a.csv", 0, 50*time.Millisecond, 1)
+	c.Assert(err, IsNil)
+	_, err = reader.Seek(4, io.SeekStart)
+	c.Assert(err, IsNil)
+
+	// the first read hangs, which is aborted and retried at the same offset.
+	data, err := ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "456789abcdef")
+	c.Assert(opened, Equals, 2)
+	c.Assert(reader.Close(), IsNil)
+}
+
+func (s *testTimeoutReaderSuite) TestHungReadExceedsRetry(c *C) {
+	open := func(context.Context) (storage.ReadSeekCloser, error) {
+		return &hungReader{StringReader: NewStringReader("whatever"), closed: make(chan struct{})}, nil
+	}
+
+	reader, err := openWithTimeout(context.Background(), open, "a.csv", 0, 10*time.Millisecond, 2)
+	c.Assert(err, IsNil)
+	_, err = reader.Read(make([]byte, 4))
+	c.Assert(errors.Cause(err), Equals, errReadTimeout)
+	c.Assert(err, ErrorMatches, ".*after 2 retries.*")
+}
+
+func (s *testTimeoutReaderSuite) TestOpenTimeout(c *C) {
+	block := make(chan struct{})
+	defer close(block)
+	open := func(context.Context) (storage.ReadSeekCloser, error) {
+		<-block
+		return NewStringReader(""), nil
+	}
+
+	_, err := openWithTimeout(context.Background(), open, "a.csv", 10*time.Millisecond, 0, 0)
+	c.Assert(errors.Cause(err), Equals, errOpenTimeout)
+}
@@ -0,0 +1,339 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+)
+
+const (
+	webHDFSUser            = "user"
+	webHDFSDelegationToken = "delegation-token"
+)
+
+// isWebHDFSURL returns whether the data source is in HDFS accessed through
+// the WebHDFS REST API, i.e. `webhdfs://namenode:9870/path`, or
+// `swebhdfs://` over HTTPS.
+func isWebHDFSURL(sourceDir string) bool {
+	return strings.HasPrefix(sourceDir, "webhdfs://") || strings.HasPrefix(sourceDir, "swebhdfs://")
+}
+
+// WebHDFSOptions locates the directory and the credentials of the data source
+// in HDFS.
+type WebHDFSOptions struct {
+	// Endpoint is the HTTP address of the name node.
+	Endpoint string
+	Path     string
+	// User is the user of the simple authentication.
+	User string
+	// DelegationToken authenticates the requests instead of the user, e.g.
+	// on the secured clusters.
+	DelegationToken string
+}
+
+// ParseWebHDFSURL parses the options from the data source URL, e.g.
+//
+//	webhdfs://namenode:9870/dumps/db?user=hdfs
+//
+// The user defaults to the environment variable HADOOP_USER_NAME.
+func ParseWebHDFSURL(sourceDir string) (*WebHDFSOptions, error) {
+	u, err := url.Parse(sourceDir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(u.Host) == 0 {
+		return nil, errors.New("missing the name node of the webhdfs url")
+	}
+	scheme := "http"
+	if u.Scheme == "swebhdfs" {
+		scheme = "https"
+	}
+	query := u.Query()
+	opts := &WebHDFSOptions{
+		Endpoint:        scheme + "://" + u.Host,
+		Path:            "/" + strings.Trim(u.Path, "/"),
+		User:            query.Get(webHDFSUser),
+		DelegationToken: query.Get(webHDFSDelegationToken),
+	}
+	if len(opts.User) == 0 {
+		opts.User = os.Getenv("HADOOP_USER_NAME")
+	}
+	return opts, nil
+}
+
+// webHDFSStorage reads the data source from a directory of HDFS. The methods
+// of storage.ExternalStorage not overridden, which are not used on the data
+// source, are not supported.
+type webHDFSStorage struct {
+	storage.ExternalStorage
+	opts   WebHDFSOptions
+	client *http.Client
+}
+
+// NewWebHDFSStorage opens the directory of the options.
+func NewWebHDFSStorage(opts *WebHDFSOptions) storage.ExternalStorage {
+	return &webHDFSStorage{opts: *opts, client: http.DefaultClient}
+}
+
+// webHDFSFileStatus is the FileStatus JSON object of WebHDFS.
+type webHDFSFileStatus struct {
+	PathSuffix string `json:"pathSuffix"`
+	Type       string `json:"type"`
+	Length     int64  `json:"length"`
+}
+
+// webHDFSRemoteException is the error JSON object of WebHDFS.
+type webHDFSRemoteException struct {
+	Exception string `json:"exception"`
+	Message   string `json:"message"`
+}
+
+func (e *webHDFSRemoteException) Error() string {
+	return e.Exception + ": " + e.Message
+}
+
+func (s *webHDFSStorage) url(name string, op string, params url.Values) string {
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("op", op)
+	if len(s.opts.DelegationToken) > 0 {
+		params.Set("delegation", s.opts.DelegationToken)
+	} else if len(s.opts.User) > 0 {
+		params.Set("user.name", s.opts.User)
+	}
+	p := (&url.URL{Path: path.Join("/webhdfs/v1", s.opts.Path, name)}).EscapedPath()
+	return s.opts.Endpoint + p + "?" + params.Encode()
+}
+
+// do sends the request, and converts the remote exception into an error.
+func (s *webHDFSStorage) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if resp.StatusCode < http.StatusBadRequest {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var body struct {
+		RemoteException webHDFSRemoteException `json:"RemoteException"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || len(body.RemoteException.Exception) == 0 {
+		return nil, errors.Errorf("webhdfs request failed with status %s", resp.Status)
+	}
+	return nil, &body.RemoteException
+}
+
+func isWebHDFSNotFound(err error) bool {
+	remoteErr, ok := errors.Cause(err).(*webHDFSRemoteException)
+	return ok && remoteErr.Exception == "FileNotFoundException"
+}
+
+func (s *webHDFSStorage) getJSON(ctx context.Context, name string, op string, params url.Values, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, s.url(name, op, params), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := s.do(s.client, req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errors.Trace(json.NewDecoder(resp.Body).Decode(v))
+}
+
+func (s *webHDFSStorage) stat(ctx context.Context, name string) (*webHDFSFileStatus, error) {
+	var body struct {
+		FileStatus webHDFSFileStatus `json:"FileStatus"`
+	}
+	if err := s.getJSON(ctx, name, "GETFILESTATUS", nil, &body); err != nil {
+		return nil, err
+	}
+	return &body.FileStatus, nil
+}
+
+// Write creates the file in two steps: the name node redirects the request to
+// a data node, which receives the content.
+func (s *webHDFSStorage) Write(ctx context.Context, name string, data []byte) error {
+	noRedirect := *s.client
+	noRedirect.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	req, err := http.NewRequest(http.MethodPut, s.url(name, "CREATE", url.Values{"overwrite": {"true"}}), nil)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	resp, err := s.do(&noRedirect, req.WithContext(ctx))
+	if err != nil {
+		return errors.Annotatef(err, "write %s", name)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return errors.Annotatef(err, "write %s: webhdfs did not redirect to a data node", name)
+	}
+
+	req, err = http.NewRequest(http.MethodPut, location.String(), bytes.NewReader(data))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = s.do(s.client, req.WithContext(ctx))
+	if err != nil {
+		return errors.Annotatef(err, "write %s", name)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *webHDFSStorage) Read(ctx context.Context, name string) ([]byte, error) {
+	reader, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	return data, errors.Annotatef(err, "read %s", name)
+}
+
+func (s *webHDFSStorage) FileExists(ctx context.Context, name string) (bool, error) {
+	status, err := s.stat(ctx, name)
+	if err == nil {
+		return status.Type == "FILE", nil
+	}
+	if isWebHDFSNotFound(err) {
+		return false, nil
+	}
+	return false, errors.Annotatef(err, "stat %s", name)
+}
+
+func (s *webHDFSStorage) Open(ctx context.Context, name string) (storage.ReadSeekCloser, error) {
+	status, err := s.stat(ctx, name)
+	if err != nil {
+		return nil, errors.Annotatef(err, "open %s", name)
+	}
+	if status.Type != "FILE" {
+		return nil, errors.Errorf("open %s: not a file", name)
+	}
+	return &webHDFSReader{ctx: ctx, storage: s, name: name, size: status.Length}, nil
+}
+
+// WalkDir lists all files under the directory recursively. The files are
+// listed in the lexicographical order of the names in each directory.
+func (s *webHDFSStorage) WalkDir(ctx context.Context, _ *storage.WalkOption, fn func(path string, size int64) error) error {
+	return s.walk(ctx, "", fn)
+}
+
+func (s *webHDFSStorage) walk(ctx context.Context, dir string, fn func(path string, size int64) error) error {
+	var body struct {
+		FileStatuses struct {
+			FileStatus []webHDFSFileStatus `json:"FileStatus"`
+		} `json:"FileStatuses"`
+	}
+	if err := s.getJSON(ctx, dir, "LISTSTATUS", nil, &body); err != nil {
+		return errors.Annotatef(err, "list webhdfs directory '%s'", path.Join(s.opts.Path, dir))
+	}
+	statuses := body.FileStatuses.FileStatus
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].PathSuffix < statuses[j].PathSuffix })
+	for _, status := range statuses {
+		name := path.Join(dir, status.PathSuffix)
+		var err error
+		switch status.Type {
+		case "DIRECTORY":
+			err = s.walk(ctx, name, fn)
+		case "FILE":
+			err = fn(name, status.Length)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webHDFSReader reads a file from the offset with a single OPEN request,
+// which restarts after seeking.
+type webHDFSReader struct {
+	ctx     context.Context
+	storage *webHDFSStorage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (r *webHDFSReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		params := url.Values{"offset": {strconv.FormatInt(r.offset, 10)}}
+		req, err := http.NewRequest(http.MethodGet, r.storage.url(r.name, "OPEN", params), nil)
+		if err != nil {
+			return 0, errors.Trace(err)
+		}
+		resp, err := r.storage.do(r.storage.client, req.WithContext(r.ctx))
+		if err != nil {
+			return 0, errors.Annotatef(err, "read %s", r.name)
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *webHDFSReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.Errorf("invalid seek offset %d of %s", offset, r.name)
+	}
+	if offset != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *webHDFSReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
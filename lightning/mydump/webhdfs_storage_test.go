@@ -0,0 +1,214 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/pingcap/check"
+
+	. "github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&testWebHDFSSuite{})
+
+type testWebHDFSSuite struct{}
+
+// fakeWebHDFS serves the WebHDFS operations used by the data source over the
+// files in memory.
+type fakeWebHDFS struct {
+	c     *C
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *fakeWebHDFS) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if strings.HasPrefix(req.URL.Path, "/datanode/") {
+		data, err := ioutil.ReadAll(req.Body)
+		f.c.Assert(err, IsNil)
+		f.files[strings.TrimPrefix(req.URL.Path, "/datanode")] = data
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	query := req.URL.Query()
+	f.c.Assert(query.Get("user.name"), Equals, "alice")
+	name := strings.TrimPrefix(req.URL.Path, "/webhdfs/v1")
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"RemoteException":{"exception":"FileNotFoundException","javaClassName":"java.io.FileNotFoundException","message":"File does not exist: ` + name + `"}}`))
+	}
+	status := func(suffix string, p string) map[string]interface{} {
+		if data, ok := f.files[p]; ok {
+			return map[string]interface{}{"pathSuffix": suffix, "type": "FILE", "length": len(data)}
+		}
+		for file := range f.files {
+			if strings.HasPrefix(file, p+"/") {
+				return map[string]interface{}{"pathSuffix": suffix, "type": "DIRECTORY", "length": 0}
+			}
+		}
+		return nil
+	}
+
+	switch query.Get("op") {
+	case "GETFILESTATUS":
+		st := status("", name)
+		if st == nil {
+			notFound()
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"FileStatus": st})
+	case "LISTSTATUS":
+		children := make(map[string]struct{})
+		for file := range f.files {
+			if strings.HasPrefix(file, name+"/") {
+				children[strings.SplitN(strings.TrimPrefix(file, name+"/"), "/", 2)[0]] = struct{}{}
+			}
+		}
+		if len(children) == 0 {
+			notFound()
+			return
+		}
+		statuses := make([]map[string]interface{}, 0, len(children))
+		for child := range children {
+			statuses = append(statuses, status(child, path.Join(name, child)))
+		}
+		// the order of the names should not matter.
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i]["pathSuffix"].(string) > statuses[j]["pathSuffix"].(string)
+		})
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"FileStatuses": map[string]interface{}{"FileStatus": statuses},
+		})
+	case "OPEN":
+		data, ok := f.files[name]
+		if !ok {
+			notFound()
+			return
+		}
+		offset, err := strconv.Atoi(query.Get("offset"))
+		f.c.Assert(err, IsNil)
+		_, _ = w.Write(data[offset:])
+	case "CREATE":
+		f.c.Assert(req.Method, Equals, http.MethodPut)
+		f.c.Assert(query.Get("overwrite"), Equals, "true")
+		http.Redirect(w, req, "/datanode"+name, http.StatusTemporaryRedirect)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (s *testWebHDFSSuite) TestParseURL(c *C) {
+	opts, err := ParseWebHDFSURL("webhdfs://namenode:9870/dumps/db/?user=alice")
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &WebHDFSOptions{
+		Endpoint: "http://namenode:9870",
+		Path:     "/dumps/db",
+		User:     "alice",
+	})
+
+	os.Setenv("HADOOP_USER_NAME", "hdfs")
+	defer os.Unsetenv("HADOOP_USER_NAME")
+	opts, err = ParseWebHDFSURL("swebhdfs://namenode:9871?delegation-token=abc")
+	c.Assert(err, IsNil)
+	c.Assert(opts, DeepEquals, &WebHDFSOptions{
+		Endpoint:        "https://namenode:9871",
+		Path:            "/",
+		User:            "hdfs",
+		DelegationToken: "abc",
+	})
+
+	_, err = ParseWebHDFSURL("webhdfs:///dumps")
+	c.Assert(err, ErrorMatches, "missing the name node of the webhdfs url")
+}
+
+func (s *testWebHDFSSuite) TestStorage(c *C) {
+	fake := &fakeWebHDFS{c: c, files: map[string][]byte{
+		"/dumps/db/db-schema-create.sql":  []byte("CREATE DATABASE db;"),
+		"/dumps/db/db.t-schema.sql":       []byte("CREATE TABLE t (a int);"),
+		"/dumps/db/data/db.t.000000.sql":  []byte("INSERT INTO t VALUES (1),(2),(3);"),
+		"/dumps/other/db.t.000000.sql":    []byte("INSERT INTO t VALUES (4);"),
+		"/dumps/db/data/db.t.000001.sql":  []byte("INSERT INTO t VALUES (5);"),
+		"/dumps/db/data/nested/README.md": []byte("ignored"),
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	ctx := context.Background()
+	opts, err := ParseWebHDFSURL("webhdfs://" + strings.TrimPrefix(server.URL, "http://") + "/dumps/db?user=alice")
+	c.Assert(err, IsNil)
+	store := NewWebHDFSStorage(opts)
+
+	var paths []string
+	err = store.WalkDir(ctx, nil, func(path string, size int64) error {
+		paths = append(paths, path+":"+strconv.FormatInt(size, 10))
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(paths, DeepEquals, []string{
+		"data/db.t.000000.sql:33",
+		"data/db.t.000001.sql:25",
+		"data/nested/README.md:7",
+		"db-schema-create.sql:19",
+		"db.t-schema.sql:23",
+	})
+
+	exists, err := store.FileExists(ctx, "db.t-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsTrue)
+	exists, err = store.FileExists(ctx, "data")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+	exists, err = store.FileExists(ctx, "db.u-schema.sql")
+	c.Assert(err, IsNil)
+	c.Assert(exists, IsFalse)
+
+	data, err := store.Read(ctx, "db-schema-create.sql")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "CREATE DATABASE db;")
+
+	reader, err := store.Open(ctx, "data/db.t.000000.sql")
+	c.Assert(err, IsNil)
+	defer reader.Close()
+	_, err = reader.Seek(21, io.SeekStart)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "(1),(2),(3);")
+	_, err = reader.Seek(-5, io.SeekEnd)
+	c.Assert(err, IsNil)
+	data, err = ioutil.ReadAll(reader)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ",(3);")
+
+	_, err = store.Open(ctx, "db.u-schema.sql")
+	c.Assert(err, ErrorMatches, "open db.u-schema.sql: FileNotFoundException: File does not exist: .*")
+
+	c.Assert(store.Write(ctx, "checkpoint.pb", []byte("checkpoint")), IsNil)
+	c.Assert(string(fake.files["/dumps/db/checkpoint.pb"]), Equals, "checkpoint")
+}
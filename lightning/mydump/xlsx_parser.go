@@ -0,0 +1,615 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mydump
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/xml"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// The sheets of an Excel workbook are routed and imported as if they were the
+// files under the directory of the workbook, e.g. the sheet "Sheet1" of
+// "db.xlsx" has the path "db.xlsx/Sheet1". The sheet names cannot contain
+// '/', so the path is split at the last '/'.
+const xlsxExt = ".xlsx"
+
+func isXLSXFile(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), xlsxExt)
+}
+
+// XLSXSheetPath returns the path of the sheet in the workbook.
+func XLSXSheetPath(path string, sheet string) string {
+	return path + "/" + sheet
+}
+
+// SplitXLSXSheetPath splits the path of a sheet into the path of the workbook
+// and the sheet name.
+func SplitXLSXSheetPath(sheetPath string) (path string, sheet string) {
+	i := strings.LastIndexByte(sheetPath, '/')
+	if i < 0 {
+		return sheetPath, ""
+	}
+	return sheetPath[:i], sheetPath[i+1:]
+}
+
+// XLSXSheet is a sheet in the workbook.
+type XLSXSheet struct {
+	Name string
+	// Size is the compressed size of the sheet in the workbook.
+	Size int64
+}
+
+type xlsxWorkbookXML struct {
+	WorkbookPr struct {
+		Date1904 bool `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationshipsXML struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxRichText is a string item, which is either a plain text, or the runs of
+// rich text.
+type xlsxRichText struct {
+	T *string `xml:"t"`
+	R []struct {
+		T string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (rt *xlsxRichText) String() string {
+	var sb strings.Builder
+	if rt.T != nil {
+		sb.WriteString(*rt.T)
+	}
+	for _, r := range rt.R {
+		sb.WriteString(r.T)
+	}
+	return sb.String()
+}
+
+type xlsxSharedStringsXML struct {
+	Items []xlsxRichText `xml:"si"`
+}
+
+type xlsxStylesXML struct {
+	NumFmts []struct {
+		ID   int    `xml:"numFmtId,attr"`
+		Code string `xml:"formatCode,attr"`
+	} `xml:"numFmts>numFmt"`
+	CellXfs []struct {
+		NumFmtID int `xml:"numFmtId,attr"`
+	} `xml:"cellXfs>xf"`
+}
+
+type xlsxRowXML struct {
+	Cells []xlsxCell `xml:"c"`
+}
+
+type xlsxCell struct {
+	Ref   string        `xml:"r,attr"`
+	Type  string        `xml:"t,attr"`
+	Style int           `xml:"s,attr"`
+	V     *string       `xml:"v"`
+	IS    *xlsxRichText `xml:"is"`
+}
+
+// xlsxReaderAt adapts the reader for the zip reader, which reads the central
+// directory and the files at their offsets.
+type xlsxReaderAt struct {
+	ReadSeekCloser
+}
+
+func (r xlsxReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, b)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// xlsxWorkbook is an opened workbook. The files in the workbook are read from
+// the reader on demand, so only the files being decoded are in the memory.
+type xlsxWorkbook struct {
+	files    map[string]*zip.File
+	sheets   []XLSXSheet
+	paths    map[string]string
+	date1904 bool
+}
+
+func openXLSXWorkbook(reader ReadSeekCloser) (*xlsxWorkbook, error) {
+	size, err := reader.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	zr, err := zip.NewReader(xlsxReaderAt{reader}, size)
+	if err != nil {
+		return nil, errors.Annotate(err, "not an xlsx file")
+	}
+	wb := &xlsxWorkbook{
+		files: make(map[string]*zip.File, len(zr.File)),
+		paths: make(map[string]string),
+	}
+	for _, f := range zr.File {
+		wb.files[f.Name] = f
+	}
+
+	var workbook xlsxWorkbookXML
+	if err := wb.decode("xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+	var rels xlsxRelationshipsXML
+	if err := wb.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string, len(rels.Relationships))
+	for _, rel := range rels.Relationships {
+		target := rel.Target
+		if strings.HasPrefix(target, "/") {
+			target = target[1:]
+		} else {
+			target = path.Join("xl", target)
+		}
+		targets[rel.ID] = target
+	}
+
+	wb.date1904 = workbook.WorkbookPr.Date1904
+	for _, sheet := range workbook.Sheets {
+		target, ok := targets[sheet.RID]
+		if !ok {
+			return nil, errors.Errorf("sheet '%s' not found in the xlsx file", sheet.Name)
+		}
+		f, ok := wb.files[target]
+		if !ok {
+			return nil, errors.Errorf("sheet '%s' not found in the xlsx file", sheet.Name)
+		}
+		wb.sheets = append(wb.sheets, XLSXSheet{Name: sheet.Name, Size: int64(f.CompressedSize64)})
+		wb.paths[sheet.Name] = target
+	}
+	return wb, nil
+}
+
+// decode decodes the XML file in the workbook. A missing file is left as
+// zero.
+func (wb *xlsxWorkbook) decode(name string, v interface{}) error {
+	r, err := wb.open(name)
+	if err != nil || r == nil {
+		return err
+	}
+	defer r.Close()
+	if err := xml.NewDecoder(r).Decode(v); err != nil {
+		return errors.Annotatef(err, "invalid %s in the xlsx file", name)
+	}
+	return nil
+}
+
+// open opens the file in the workbook, or returns nil if the file is missing.
+func (wb *xlsxWorkbook) open(name string) (io.ReadCloser, error) {
+	f, ok := wb.files[name]
+	if !ok {
+		return nil, nil
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, errors.Annotatef(err, "invalid %s in the xlsx file", name)
+	}
+	return r, nil
+}
+
+// dateStyles returns whether each cell style is a date or time format.
+func (wb *xlsxWorkbook) dateStyles() ([]bool, error) {
+	var styles xlsxStylesXML
+	if err := wb.decode("xl/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	codes := make(map[int]string, len(styles.NumFmts))
+	for _, numFmt := range styles.NumFmts {
+		codes[numFmt.ID] = numFmt.Code
+	}
+	result := make([]bool, 0, len(styles.CellXfs))
+	for _, xf := range styles.CellXfs {
+		code, ok := codes[xf.NumFmtID]
+		result = append(result, isXLSXDateFormat(xf.NumFmtID, code, ok))
+	}
+	return result, nil
+}
+
+// isXLSXDateFormat returns whether the number format shows the numbers as
+// the dates or times, either built-in or custom.
+func isXLSXDateFormat(id int, code string, custom bool) bool {
+	if !custom {
+		return (id >= 14 && id <= 22) || (id >= 45 && id <= 47)
+	}
+	// remove the literal texts, the escaped characters, and the colors and
+	// the conditions in brackets.
+	var sb strings.Builder
+	inQuote := false
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case inQuote:
+			inQuote = c != '"'
+		case c == '"':
+			inQuote = true
+		case c == '[':
+			end := strings.IndexByte(code[i:], ']')
+			if end < 0 {
+				end = len(code) - i
+			}
+			// keep the elapsed time, e.g. [h]:mm
+			if bracket := strings.ToLower(code[i+1 : i+end]); len(bracket) > 0 && strings.Trim(bracket, "hms") == "" {
+				sb.WriteString(bracket)
+			}
+			i += end
+		case c == '\\' || c == '_' || c == '*':
+			i++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return strings.ContainsAny(strings.ToLower(sb.String()), "ymdhs")
+}
+
+// xlsxSerialToTime converts the serial number of the date and time to the
+// text. The integral part is the days since the epoch, and the fractional
+// part is the time of the day.
+func xlsxSerialToTime(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	t := epoch.Add(time.Duration(math.Round(serial*86400)) * time.Second)
+	switch {
+	case serial >= 0 && serial < 1:
+		return t.Format("15:04:05")
+	case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0:
+		return t.Format("2006-01-02")
+	default:
+		return t.Format("2006-01-02 15:04:05")
+	}
+}
+
+// xlsxColumnIndex returns the zero-based column index of the cell reference,
+// e.g. 27 for "AB12".
+func xlsxColumnIndex(ref string) (int, bool) {
+	index := 0
+	n := 0
+	for ; n < len(ref); n++ {
+		c := ref[n]
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c < 'A' || c > 'Z' {
+			break
+		}
+		index = index*26 + int(c-'A'+1)
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return index - 1, true
+}
+
+// ListXLSXSheets returns the sheets in the workbook.
+func ListXLSXSheets(ctx context.Context, store storage.ExternalStorage, path string) ([]XLSXSheet, error) {
+	r, err := store.Open(ctx, path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer r.Close()
+	wb, err := openXLSXWorkbook(r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return wb.sheets, nil
+}
+
+// XLSXParser reads a sheet of the Excel workbook. The offsets are the row
+// numbers like the parquet files, excluding the header row. The empty cells
+// are NULL, and the rows without any value are skipped. The numbers keep
+// the literal, except that the numbers of the date or time formats are
+// converted to the text like "2006-01-02 15:04:05".
+//
+// The rows are decoded from the sheet one by one, and the rows are padded to
+// the width of the dimension of the sheet or of the header. The shared strings
+// of the workbook are loaded into the memory, since the cells refer to them by
+// the index.
+type XLSXParser struct {
+	reader        ReadSeekCloser
+	sheet         string
+	sheetReader   io.ReadCloser
+	decoder       *xml.Decoder
+	sharedStrings []xlsxRichText
+	dateStyles    []bool
+	date1904      bool
+	columns       []string
+	width         int
+
+	pos     int64
+	lastRow Row
+	logger  log.Logger
+}
+
+// NewXLSXParser creates a parser of the sheet in the workbook. If the header
+// is enabled, the first row with any value names the columns.
+func NewXLSXParser(cfg *config.XLSXConfig, reader ReadSeekCloser, sheet string) (*XLSXParser, error) {
+	wb, err := openXLSXWorkbook(reader)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	sheetPath, ok := wb.paths[sheet]
+	if !ok {
+		return nil, errors.Errorf("sheet '%s' not found in the xlsx file", sheet)
+	}
+	var sharedStrings xlsxSharedStringsXML
+	if err := wb.decode("xl/sharedStrings.xml", &sharedStrings); err != nil {
+		return nil, err
+	}
+	dateStyles, err := wb.dateStyles()
+	if err != nil {
+		return nil, err
+	}
+	sheetReader, err := wb.open(sheetPath)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &XLSXParser{
+		reader:        reader,
+		sheet:         sheet,
+		sheetReader:   sheetReader,
+		decoder:       xml.NewDecoder(sheetReader),
+		sharedStrings: sharedStrings.Items,
+		dateStyles:    dateStyles,
+		date1904:      wb.date1904,
+		logger:        log.L(),
+	}
+	if cfg.Header {
+		row, err := parser.readRow()
+		switch {
+		case err == io.EOF:
+			// the sheet is empty.
+		case err != nil:
+			sheetReader.Close()
+			return nil, err
+		default:
+			// the row is padded to the dimension of the sheet.
+			for len(row) > 0 && row[len(row)-1].IsNull() {
+				row = row[:len(row)-1]
+			}
+			parser.columns = make([]string, 0, len(row))
+			for _, d := range row {
+				parser.columns = append(parser.columns, strings.ToLower(strings.TrimSpace(d.GetString())))
+			}
+			if len(parser.columns) > parser.width {
+				parser.width = len(parser.columns)
+			}
+		}
+	}
+	return parser, nil
+}
+
+// readRow decodes the next row with any value in the sheet.
+func (p *XLSXParser) readRow() ([]types.Datum, error) {
+	for {
+		tok, err := p.decoder.Token()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid sheet '%s' in the xlsx file", p.sheet)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "dimension":
+			// the dimension, e.g. "A1:E6", precedes the rows.
+			for _, attr := range start.Attr {
+				if attr.Name.Local != "ref" {
+					continue
+				}
+				ref := attr.Value[strings.LastIndexByte(attr.Value, ':')+1:]
+				if col, ok := xlsxColumnIndex(ref); ok && col+1 > p.width {
+					p.width = col + 1
+				}
+			}
+		case "row":
+			var rowXML xlsxRowXML
+			if err := p.decoder.DecodeElement(&rowXML, &start); err != nil {
+				return nil, errors.Annotatef(err, "invalid sheet '%s' in the xlsx file", p.sheet)
+			}
+			row, empty, err := p.decodeRow(&rowXML)
+			if err != nil {
+				return nil, err
+			}
+			if !empty {
+				return row, nil
+			}
+		}
+	}
+}
+
+func (p *XLSXParser) decodeRow(rowXML *xlsxRowXML) (row []types.Datum, empty bool, err error) {
+	row = make([]types.Datum, 0, p.width)
+	empty = true
+	for i := range rowXML.Cells {
+		cell := &rowXML.Cells[i]
+		col, ok := xlsxColumnIndex(cell.Ref)
+		if !ok {
+			col = len(row)
+		}
+		if col < len(row) {
+			return nil, false, errors.Errorf("invalid cell reference '%s' at column %d in sheet '%s'", cell.Ref, i+1, p.sheet)
+		}
+		for len(row) <= col {
+			row = append(row, types.Datum{})
+		}
+		if err := setXLSXDatum(&row[col], cell, p.sharedStrings, p.dateStyles, p.date1904); err != nil {
+			return nil, false, errors.Annotatef(err, "invalid cell '%s' in sheet '%s'", cell.Ref, p.sheet)
+		}
+		if !row[col].IsNull() {
+			empty = false
+		}
+	}
+	for len(row) < p.width {
+		row = append(row, types.Datum{})
+	}
+	return row, empty, nil
+}
+
+func setXLSXDatum(d *types.Datum, cell *xlsxCell, sharedStrings []xlsxRichText, dateStyles []bool, date1904 bool) error {
+	if cell.Type == "inlineStr" {
+		if cell.IS == nil {
+			d.SetNull()
+		} else {
+			d.SetString(cell.IS.String(), "")
+		}
+		return nil
+	}
+	if cell.V == nil {
+		d.SetNull()
+		return nil
+	}
+	v := *cell.V
+	switch cell.Type {
+	case "s":
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 || i >= len(sharedStrings) {
+			return errors.Errorf("invalid shared string index '%s'", v)
+		}
+		d.SetString(sharedStrings[i].String(), "")
+	case "b":
+		if v == "1" {
+			d.SetInt64(1)
+		} else {
+			d.SetInt64(0)
+		}
+	case "", "n":
+		if cell.Style >= 0 && cell.Style < len(dateStyles) && dateStyles[cell.Style] {
+			serial, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return errors.Errorf("invalid number '%s'", v)
+			}
+			d.SetString(xlsxSerialToTime(serial, date1904), "")
+			return nil
+		}
+		d.SetString(v, "")
+	default:
+		// the strings of the formulas, the errors like "#DIV/0!" and the
+		// ISO 8601 dates are kept as is.
+		d.SetString(v, "")
+	}
+	return nil
+}
+
+// numRows reads the rest of the sheet, and returns the number of the rows
+// read by the parser.
+func (p *XLSXParser) numRows() (int64, error) {
+	for {
+		if err := p.ReadRow(); err == io.EOF {
+			return p.pos, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (p *XLSXParser) Pos() (pos int64, rowID int64) {
+	return p.pos, p.lastRow.RowID
+}
+
+// SetPos skips the rows before pos. Seeking back is not supported, since the
+// rows are decoded one by one.
+func (p *XLSXParser) SetPos(pos int64, rowID int64) error {
+	if pos < p.pos {
+		return errors.Errorf("cannot seek back xlsx sheet from row %d to %d", p.pos, pos)
+	}
+	for p.pos < pos {
+		if _, err := p.readRow(); err == io.EOF {
+			return errors.Errorf("cannot seek xlsx sheet to row %d, the sheet has %d rows", pos, p.pos)
+		} else if err != nil {
+			return err
+		}
+		p.pos++
+	}
+	p.lastRow.RowID = rowID
+	return nil
+}
+
+func (p *XLSXParser) Close() error {
+	if p.sheetReader != nil {
+		p.sheetReader.Close()
+	}
+	return p.reader.Close()
+}
+
+func (p *XLSXParser) ReadRow() error {
+	row, err := p.readRow()
+	if err != nil {
+		return err
+	}
+	p.lastRow.Row = row
+	p.lastRow.RowID++
+	p.pos++
+	return nil
+}
+
+func (p *XLSXParser) LastRow() Row {
+	return p.lastRow
+}
+
+func (p *XLSXParser) RecycleRow(row Row) {
+}
+
+// Columns returns the _lower-case_ column names in the header row, or nil if
+// the header is disabled.
+func (p *XLSXParser) Columns() []string {
+	return p.columns
+}
+
+// SetColumns set restored column names to parser
+func (p *XLSXParser) SetColumns(cols []string) {
+	// just do nothing
+}
+
+func (p *XLSXParser) SetLogger(l log.Logger) {
+	p.logger = l
+}
@@ -0,0 +1,283 @@
+package mydump
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/tidb/types"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+type testXLSXParserSuite struct{}
+
+var _ = Suite(testXLSXParserSuite{})
+
+const (
+	testXLSXWorkbook = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<workbookPr/>
+<sheets>
+<sheet name="Users" sheetId="1" r:id="rId1"/>
+<sheet name="Empty Sheet" sheetId="2" r:id="rId2"/>
+</sheets>
+</workbook>`
+
+	testXLSXRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="/xl/worksheets/sheet2.xml"/>
+<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+	testXLSXSharedStrings = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" count="4" uniqueCount="4">
+<si><t>ID</t></si>
+<si><t>Name</t></si>
+<si><r><t>Act</t></r><r><rPr><b/></rPr><t>ive</t></r></si>
+<si><t xml:space="preserve"> alice </t></si>
+</sst>`
+
+	testXLSXStyles = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2">
+<numFmt numFmtId="164" formatCode="yyyy/mm/dd\ hh:mm"/>
+<numFmt numFmtId="165" formatCode="[Red]0.00"/>
+</numFmts>
+<cellXfs count="4">
+<xf numFmtId="0"/>
+<xf numFmtId="14"/>
+<xf numFmtId="164"/>
+<xf numFmtId="165"/>
+</cellXfs>
+</styleSheet>`
+
+	testXLSXSheet1 = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<dimension ref="A1:E6"/>
+<sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="inlineStr"><is><t>Born</t></is></c><c r="D1" t="s"><v>2</v></c></row>
+<row r="2"><c r="A2"><v>1</v></c><c r="B2" t="s"><v>3</v></c><c r="C2" s="1"><v>36526</v></c><c r="D2" t="b"><v>1</v></c></row>
+<row r="3"><c r="B3" s="1"/></row>
+<row r="4"><c r="A4"><v>2.50</v></c><c r="C4" s="2"><v>36526.5</v></c></row>
+<row r="6"><c r="B6" t="str"><f>UPPER("x")</f><v>X</v></c><c r="D6" s="3"><v>0.25</v></c><c r="E6"><v>9</v></c></row>
+</sheetData>
+</worksheet>`
+
+	testXLSXSheet2 = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`
+)
+
+func writeTestXLSX(c *C, dir string, name string) {
+	writeTestXLSXWithSheet(c, dir, name, testXLSXSheet1)
+}
+
+func writeTestXLSXWithSheet(c *C, dir string, name string, sheet1 string) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, file := range []struct{ name, content string }{
+		{"xl/workbook.xml", testXLSXWorkbook},
+		{"xl/_rels/workbook.xml.rels", testXLSXRels},
+		{"xl/sharedStrings.xml", testXLSXSharedStrings},
+		{"xl/styles.xml", testXLSXStyles},
+		{"xl/worksheets/sheet1.xml", sheet1},
+		{"xl/worksheets/sheet2.xml", testXLSXSheet2},
+	} {
+		w, err := zw.Create(file.name)
+		c.Assert(err, IsNil)
+		_, err = w.Write([]byte(file.content))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644), IsNil)
+}
+
+func xlsxString(s string) types.Datum {
+	return types.NewCollationStringDatum(s, "", 0)
+}
+
+func (s testXLSXParserSuite) TestReadSheet(c *C) {
+	dir := c.MkDir()
+	writeTestXLSX(c, dir, "db.xlsx")
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	sheets, err := ListXLSXSheets(ctx, store, "db.xlsx")
+	c.Assert(err, IsNil)
+	c.Assert(sheets, HasLen, 2)
+	c.Assert(sheets[0].Name, Equals, "Users")
+	c.Assert(sheets[1].Name, Equals, "Empty Sheet")
+
+	r, err := store.Open(ctx, "db.xlsx")
+	c.Assert(err, IsNil)
+	parser, err := NewXLSXParser(&config.XLSXConfig{Header: true}, r, "Users")
+	c.Assert(err, IsNil)
+	defer parser.Close()
+	c.Assert(parser.Columns(), DeepEquals, []string{"id", "name", "born", "active"})
+
+	expected := [][]types.Datum{
+		{xlsxString("1"), xlsxString(" alice "), xlsxString("2000-01-01"), types.NewIntDatum(1), {}},
+		{xlsxString("2.50"), {}, xlsxString("2000-01-01 12:00:00"), {}, {}},
+		{{}, xlsxString("X"), {}, xlsxString("0.25"), xlsxString("9")},
+	}
+	for i, row := range expected {
+		c.Assert(parser.ReadRow(), IsNil)
+		c.Assert(parser.LastRow(), DeepEquals, Row{RowID: int64(i) + 1, Row: row}, Commentf("row = %d", i+1))
+		pos, rowID := parser.Pos()
+		c.Assert(pos, Equals, int64(i)+1)
+		c.Assert(rowID, Equals, int64(i)+1)
+	}
+	c.Assert(parser.ReadRow(), Equals, io.EOF)
+	c.Assert(parser.SetPos(2, 10), ErrorMatches, "cannot seek back xlsx sheet from row 3 to 2")
+
+	r, err = store.Open(ctx, "db.xlsx")
+	c.Assert(err, IsNil)
+	parser, err = NewXLSXParser(&config.XLSXConfig{Header: true}, r, "Users")
+	c.Assert(err, IsNil)
+	c.Assert(parser.SetPos(2, 10), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow(), DeepEquals, Row{RowID: 11, Row: expected[2]})
+	c.Assert(parser.SetPos(4, 0), ErrorMatches, "cannot seek xlsx sheet to row 4, the sheet has 3 rows")
+	c.Assert(parser.Close(), IsNil)
+}
+
+func (s testXLSXParserSuite) TestStreamSheet(c *C) {
+	var sb strings.Builder
+	sb.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i := 1; i <= 20000; i++ {
+		fmt.Fprintf(&sb, `<row r="%[1]d"><c r="A%[1]d"><v>%[1]d</v></c><c r="B%[1]d" t="inlineStr"><is><t>row %[1]d</t></is></c></row>`, i)
+	}
+	sb.WriteString(`</sheetData></worksheet>`)
+	sheetSize := int64(sb.Len())
+
+	dir := c.MkDir()
+	writeTestXLSXWithSheet(c, dir, "db.xlsx", sb.String())
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	r, err := store.Open(context.Background(), "db.xlsx")
+	c.Assert(err, IsNil)
+	parser, err := NewXLSXParser(&config.XLSXConfig{Header: false}, r, "Users")
+	c.Assert(err, IsNil)
+	defer parser.Close()
+
+	// the position advances while most of the sheet is not decoded yet.
+	for i := 1; i <= 3; i++ {
+		c.Assert(parser.ReadRow(), IsNil)
+		pos, rowID := parser.Pos()
+		c.Assert(pos, Equals, int64(i))
+		c.Assert(rowID, Equals, int64(i))
+		c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{xlsxString(strconv.Itoa(i)), xlsxString(fmt.Sprintf("row %d", i))})
+	}
+	c.Assert(parser.decoder.InputOffset() < sheetSize/10, IsTrue, Commentf("offset = %d", parser.decoder.InputOffset()))
+
+	numRows, err := parser.numRows()
+	c.Assert(err, IsNil)
+	c.Assert(numRows, Equals, int64(20000))
+	c.Assert(parser.decoder.InputOffset(), Equals, sheetSize)
+}
+
+func (s testXLSXParserSuite) TestNoHeader(c *C) {
+	dir := c.MkDir()
+	writeTestXLSX(c, dir, "db.xlsx")
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	ctx := context.Background()
+
+	r, err := store.Open(ctx, "db.xlsx")
+	c.Assert(err, IsNil)
+	parser, err := NewXLSXParser(&config.XLSXConfig{Header: false}, r, "Users")
+	c.Assert(err, IsNil)
+	c.Assert(parser.Columns(), IsNil)
+	c.Assert(parser.ReadRow(), IsNil)
+	c.Assert(parser.LastRow().Row, DeepEquals, []types.Datum{xlsxString("ID"), xlsxString("Name"), xlsxString("Born"), xlsxString("Active"), {}})
+	numRows, err := parser.numRows()
+	c.Assert(err, IsNil)
+	c.Assert(numRows, Equals, int64(4))
+	c.Assert(parser.Close(), IsNil)
+
+	r, err = store.Open(ctx, "db.xlsx")
+	c.Assert(err, IsNil)
+	_, err = NewXLSXParser(&config.XLSXConfig{Header: false}, r, "Sheet1")
+	c.Assert(err, ErrorMatches, "sheet 'Sheet1' not found in the xlsx file")
+	r.Close()
+}
+
+func (s testXLSXParserSuite) TestMakeSheetRegion(c *C) {
+	dir := c.MkDir()
+	writeTestXLSX(c, dir, "db.xlsx")
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+
+	meta := &MDTableMeta{DB: "db", Name: "users"}
+	dataFile := FileInfo{FileMeta: SourceFileMeta{Path: XLSXSheetPath("db.xlsx", "Users"), Type: SourceTypeXLSX}}
+	rowIDMax, region, err := makeXLSXSheetRegion(context.Background(), store, meta, dataFile, &config.XLSXConfig{Header: true}, 100)
+	c.Assert(err, IsNil)
+	c.Assert(rowIDMax, Equals, int64(103))
+	c.Assert(region.Chunk, DeepEquals, Chunk{Offset: 0, EndOffset: 3, PrevRowIDMax: 100, RowIDMax: 103})
+}
+
+func (s testXLSXParserSuite) TestSheetPath(c *C) {
+	path := XLSXSheetPath("dir/db.xlsx", "Sheet 1")
+	c.Assert(path, Equals, "dir/db.xlsx/Sheet 1")
+	workbook, sheet := SplitXLSXSheetPath(path)
+	c.Assert(workbook, Equals, "dir/db.xlsx")
+	c.Assert(sheet, Equals, "Sheet 1")
+	c.Assert(isXLSXFile(workbook), IsTrue)
+	c.Assert(isXLSXFile(path), IsFalse)
+}
+
+func (s testXLSXParserSuite) TestDateFormat(c *C) {
+	c.Assert(isXLSXDateFormat(0, "", false), IsFalse)
+	c.Assert(isXLSXDateFormat(14, "", false), IsTrue)
+	c.Assert(isXLSXDateFormat(46, "", false), IsTrue)
+	c.Assert(isXLSXDateFormat(164, "yyyy-mm-dd", true), IsTrue)
+	c.Assert(isXLSXDateFormat(164, "[h]:mm", true), IsTrue)
+	c.Assert(isXLSXDateFormat(164, "[Magenta]#,##0.00", true), IsFalse)
+	c.Assert(isXLSXDateFormat(164, `0.00" days"`, true), IsFalse)
+	c.Assert(isXLSXDateFormat(164, `0\d`, true), IsFalse)
+
+	c.Assert(xlsxSerialToTime(43831, false), Equals, "2020-01-01")
+	c.Assert(xlsxSerialToTime(42369, true), Equals, "2020-01-01")
+	c.Assert(xlsxSerialToTime(43831.75, false), Equals, "2020-01-01 18:00:00")
+	c.Assert(xlsxSerialToTime(0.5, false), Equals, "12:00:00")
+}
+
+func (s testXLSXParserSuite) TestLoadSheets(c *C) {
+	dir := c.MkDir()
+	writeTestXLSX(c, dir, "db.xlsx")
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "db.t.csv"), []byte("1\n"), 0644), IsNil)
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = dir
+	cfg.Mydumper.Filter = []string{"*.*"}
+	cfg.Mydumper.DefaultFileRules = true
+	cfg.Mydumper.NoSchema = true
+	mdl, err := NewMyDumpLoader(context.Background(), cfg)
+	c.Assert(err, IsNil)
+
+	dbMetas := mdl.GetDatabases()
+	c.Assert(dbMetas, HasLen, 1)
+	c.Assert(dbMetas[0].Name, Equals, "db")
+	paths := make(map[string]SourceType)
+	for _, tblMeta := range dbMetas[0].Tables {
+		for _, dataFile := range tblMeta.DataFiles {
+			c.Assert(dataFile.TableName.Name, Equals, tblMeta.Name)
+			paths[dataFile.FileMeta.Path] = dataFile.FileMeta.Type
+		}
+	}
+	c.Assert(paths, DeepEquals, map[string]SourceType{
+		"db.t.csv":            SourceTypeCSV,
+		"db.xlsx/Users":       SourceTypeXLSX,
+		"db.xlsx/Empty Sheet": SourceTypeXLSX,
+	})
+}
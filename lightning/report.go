@@ -0,0 +1,211 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightning
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/restore"
+	"github.com/pingcap/tidb-lightning/lightning/web"
+)
+
+// reportUploadTimeout limits the upload after the task ends, which does not
+// use the context of the task since the task may have been canceled.
+const reportUploadTimeout = 5 * time.Minute
+
+const (
+	reportStatusSucceeded = "succeeded"
+	reportStatusPartial   = "partial"
+	reportStatusFailed    = "failed"
+)
+
+// taskReport is the report.json uploaded when the task ends.
+type taskReport struct {
+	TaskID    int64     `json:"task-id"`
+	StartTime time.Time `json:"start-time"`
+	EndTime   time.Time `json:"end-time"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	// Progress is the progress of the tables, the same as the web interface.
+	Progress json.RawMessage  `json:"progress,omitempty"`
+	Warnings []common.Warning `json:"warnings"`
+	// DumpMetadata is the metadata file of the data source, if found.
+	DumpMetadata *mydump.DumpMetadata `json:"dump-metadata,omitempty"`
+}
+
+func newTaskReport(taskCfg *config.Config, startTime time.Time, dumpMeta *mydump.DumpMetadata, taskErr error) *taskReport {
+	report := &taskReport{
+		TaskID:       taskCfg.TaskID,
+		StartTime:    startTime,
+		EndTime:      time.Now(),
+		Status:       reportStatusSucceeded,
+		Warnings:     common.Warnings.Summary(),
+		DumpMetadata: dumpMeta,
+	}
+	switch {
+	case taskErr == nil:
+	case errors.Cause(taskErr) == restore.ErrImportPartial:
+		report.Status = reportStatusPartial
+		report.Error = taskErr.Error()
+	default:
+		report.Status = reportStatusFailed
+		report.Error = taskErr.Error()
+	}
+	if progress, err := web.MarshalTaskProgress(); err == nil {
+		report.Progress = progress
+	}
+	return report
+}
+
+// uploadReport uploads the report, the tail of the log file and the snapshot
+// of the checkpoints to the upload URL, named with the prefix of the task ID.
+// Only the failure of the report itself is returned, the others are logged.
+func uploadReport(
+	taskCfg *config.Config,
+	logFile string,
+	startTime time.Time,
+	dumpMeta *mydump.DumpMetadata,
+	taskErr error,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), reportUploadTimeout)
+	defer cancel()
+
+	u, err := storage.ParseBackend(taskCfg.Report.UploadURL, &storage.BackendOptions{})
+	if err != nil {
+		return errors.Annotate(err, "invalid `report.upload-url`")
+	}
+	store, err := storage.Create(ctx, u, true)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the local storage does not create the directories, so the files of
+	// the tasks are told apart by the prefix instead.
+	prefix := strconv.FormatInt(taskCfg.TaskID, 10) + "."
+
+	data, err := json.MarshalIndent(newTaskReport(taskCfg, startTime, dumpMeta, taskErr), "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := store.Write(ctx, prefix+"report.json", data); err != nil {
+		return errors.Annotate(err, "upload report")
+	}
+
+	if taskCfg.Report.LogTailSize > 0 && len(logFile) > 0 {
+		if err := uploadLogTail(ctx, store, prefix+"lightning.log", logFile, taskCfg.Report.LogTailSize); err != nil {
+			log.L().Warn("upload the tail of the log failed", zap.String("file", logFile), log.ShortError(err))
+		}
+	}
+	if taskCfg.Report.Checkpoints && taskCfg.Checkpoint.Enable {
+		if err := uploadCheckpoints(ctx, store, prefix, taskCfg); err != nil {
+			log.L().Warn("upload the checkpoints failed", log.ShortError(err))
+		}
+	}
+	log.L().Info("uploaded the report of the task", zap.Int64("taskID", taskCfg.TaskID))
+	return nil
+}
+
+// readLogTail reads the last size bytes of the log file, from the beginning
+// of the first complete line within them.
+func readLogTail(path string, size int64) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	offset := info.Size() - size
+	if offset <= 0 {
+		return ioutil.ReadAll(file)
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, errors.Trace(err)
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	return data, nil
+}
+
+func uploadLogTail(ctx context.Context, store storage.ExternalStorage, name string, logFile string, size int64) error {
+	// flush the buffered entries so the tail contains the end of the task.
+	_ = log.L().Sync()
+	data, err := readLogTail(logFile, size)
+	if err != nil {
+		return err
+	}
+	return errors.Trace(store.Write(ctx, name, data))
+}
+
+// uploadCheckpoints uploads the checkpoint file of the file driver as is, or
+// the CSV dumps of the MySQL driver like `tidb-lightning-ctl --checkpoint-dump`.
+func uploadCheckpoints(ctx context.Context, store storage.ExternalStorage, prefix string, taskCfg *config.Config) error {
+	if taskCfg.Checkpoint.Driver == config.CheckpointDriverFile {
+		data, err := ioutil.ReadFile(taskCfg.Checkpoint.DSN)
+		if os.IsNotExist(err) {
+			// the checkpoints are removed after the task succeeded.
+			return nil
+		}
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(store.Write(ctx, prefix+"checkpoint.pb", data))
+	}
+
+	cpdb, err := restore.OpenCheckpointsDB(ctx, taskCfg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer cpdb.Close()
+
+	for _, dump := range []struct {
+		name string
+		fn   func(context.Context, io.Writer) error
+	}{
+		{"tables.csv", cpdb.DumpTables},
+		{"engines.csv", cpdb.DumpEngines},
+		{"chunks.csv", cpdb.DumpChunks},
+	} {
+		var buf bytes.Buffer
+		if err := dump.fn(ctx, &buf); err != nil {
+			return errors.Annotatef(err, "dump %s", dump.name)
+		}
+		if err := store.Write(ctx, prefix+dump.name, buf.Bytes()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+	return nil
+}
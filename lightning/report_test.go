@@ -0,0 +1,94 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightning
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/restore"
+)
+
+type reportSuite struct{}
+
+var _ = Suite(&reportSuite{})
+
+func (s *reportSuite) TestReadLogTail(c *C) {
+	path := filepath.Join(c.MkDir(), "lightning.log")
+	c.Assert(ioutil.WriteFile(path, []byte("line 1\nline 2\nline 3\n"), 0644), IsNil)
+
+	data, err := readLogTail(path, 100)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "line 1\nline 2\nline 3\n")
+
+	// the partial line at the beginning of the tail is dropped.
+	data, err = readLogTail(path, 10)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "line 3\n")
+
+	_, err = readLogTail(filepath.Join(c.MkDir(), "not-exists.log"), 10)
+	c.Assert(err, NotNil)
+}
+
+func (s *reportSuite) TestUploadReport(c *C) {
+	dir := c.MkDir()
+	logFile := filepath.Join(c.MkDir(), "lightning.log")
+	c.Assert(ioutil.WriteFile(logFile, []byte("begin\nend\n"), 0644), IsNil)
+	cpFile := filepath.Join(c.MkDir(), "cp.pb")
+	c.Assert(ioutil.WriteFile(cpFile, []byte("checkpoints"), 0644), IsNil)
+
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+	common.RecordWarning("`db`.`t`", "test", "something")
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 1234
+	cfg.Report.UploadURL = "local://" + dir
+	cfg.Checkpoint.Enable = true
+	cfg.Checkpoint.Driver = config.CheckpointDriverFile
+	cfg.Checkpoint.DSN = cpFile
+
+	startTime := time.Now().Add(-time.Minute)
+	dumpMeta := &mydump.DumpMetadata{StartTime: "2020-11-10 10:40:19", Consistency: "none"}
+	err := uploadReport(cfg, logFile, startTime, dumpMeta, errors.Annotate(restore.ErrImportPartial, "stopped"))
+	c.Assert(err, IsNil)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "1234.report.json"))
+	c.Assert(err, IsNil)
+	var report taskReport
+	c.Assert(json.Unmarshal(data, &report), IsNil)
+	c.Assert(report.TaskID, Equals, int64(1234))
+	c.Assert(report.Status, Equals, reportStatusPartial)
+	c.Assert(report.Error, Matches, "stopped: .*")
+	c.Assert(report.StartTime.Equal(startTime), IsTrue)
+	c.Assert(report.Warnings, DeepEquals, []common.Warning{
+		{Table: "`db`.`t`", Kind: "test", Message: "something", Count: 1},
+	})
+	c.Assert(report.DumpMetadata, DeepEquals, dumpMeta)
+
+	data, err = ioutil.ReadFile(filepath.Join(dir, "1234.lightning.log"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "begin\nend\n")
+	data, err = ioutil.ReadFile(filepath.Join(dir, "1234.checkpoint.pb"))
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "checkpoints")
+}
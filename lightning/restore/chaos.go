@@ -0,0 +1,75 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"os"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// ErrChaos is the failure injected by the `--enable-chaos` flags.
+var ErrChaos = errors.New("failure injected by --enable-chaos")
+
+// chaosInjector injects the failures configured by the hidden chaos flags,
+// which are used to rehearse the recovery from the checkpoints.
+type chaosInjector struct {
+	cfg    config.Chaos
+	logger log.Logger
+
+	engineImports int32
+}
+
+func newChaosInjector(cfg config.Chaos, logger log.Logger) *chaosInjector {
+	if !cfg.Enabled() {
+		return nil
+	}
+	logger.Warn("failure injection is enabled, do not use it in production", zap.Reflect("chaos", cfg))
+	return &chaosInjector{cfg: cfg, logger: logger}
+}
+
+// afterEngineImported is called after an engine is successfully imported.
+func (c *chaosInjector) afterEngineImported(tableName string, engineID int32) error {
+	if c == nil || c.cfg.FailAfterEngineImports <= 0 {
+		return nil
+	}
+	if atomic.AddInt32(&c.engineImports, 1) != int32(c.cfg.FailAfterEngineImports) {
+		return nil
+	}
+	return c.fail("after engine imported", zap.String("table", tableName), zap.Int32("engineID", engineID))
+}
+
+// duringChecksum is called instead of the checksum of the table.
+func (c *chaosInjector) duringChecksum(tableName string) error {
+	if c == nil || (c.cfg.FailDuringChecksum != "*" && c.cfg.FailDuringChecksum != tableName) {
+		return nil
+	}
+	return c.fail("during checksum", zap.String("table", tableName))
+}
+
+func (c *chaosInjector) fail(point string, fields ...zap.Field) error {
+	c.logger.Warn("inject failure "+point, fields...)
+	if c.cfg.Kill {
+		// the process is killed without flushing anything, like a crash.
+		if proc, err := os.FindProcess(os.Getpid()); err == nil {
+			_ = proc.Kill()
+		}
+	}
+	return errors.Annotate(ErrChaos, point)
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&chaosSuite{})
+
+type chaosSuite struct{}
+
+func (s *chaosSuite) TestDisabled(c *C) {
+	chaos := newChaosInjector(config.Chaos{Kill: true}, log.L())
+	c.Assert(chaos, IsNil)
+	c.Assert(chaos.afterEngineImported("`db`.`t`", 0), IsNil)
+	c.Assert(chaos.duringChecksum("`db`.`t`"), IsNil)
+}
+
+func (s *chaosSuite) TestFailAfterEngineImports(c *C) {
+	chaos := newChaosInjector(config.Chaos{FailAfterEngineImports: 3}, log.L())
+	c.Assert(chaos.afterEngineImported("`db`.`t1`", 0), IsNil)
+	c.Assert(chaos.afterEngineImported("`db`.`t1`", -1), IsNil)
+	err := chaos.afterEngineImported("`db`.`t2`", 0)
+	c.Assert(errors.Cause(err), Equals, ErrChaos)
+	c.Assert(err, ErrorMatches, "after engine imported: failure injected by --enable-chaos")
+	// the failure is only injected once.
+	c.Assert(chaos.afterEngineImported("`db`.`t2`", -1), IsNil)
+	c.Assert(chaos.duringChecksum("`db`.`t2`"), IsNil)
+}
+
+func (s *chaosSuite) TestFailDuringChecksum(c *C) {
+	chaos := newChaosInjector(config.Chaos{FailDuringChecksum: "`db`.`t2`"}, log.L())
+	c.Assert(chaos.duringChecksum("`db`.`t1`"), IsNil)
+	c.Assert(errors.Cause(chaos.duringChecksum("`db`.`t2`")), Equals, ErrChaos)
+	c.Assert(chaos.afterEngineImported("`db`.`t2`", 0), IsNil)
+
+	chaos = newChaosInjector(config.Chaos{FailDuringChecksum: "*"}, log.L())
+	c.Assert(errors.Cause(chaos.duringChecksum("`db`.`t1`")), Equals, ErrChaos)
+	c.Assert(errors.Cause(chaos.duringChecksum("`db`.`t2`")), Equals, ErrChaos)
+}
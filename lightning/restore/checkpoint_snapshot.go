@@ -0,0 +1,70 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// checkpointExporter periodically exports the snapshots of the checkpoints to
+// the external storage, so losing the host of the checkpoints in the middle
+// of the import does not restart the import from zero.
+type checkpointExporter struct {
+	logger   log.Logger
+	cpdb     checkpoints.CheckpointsDB
+	store    storage.ExternalStorage
+	interval time.Duration
+}
+
+// newCheckpointExporter returns nil if `checkpoint.snapshot-url` is not
+// configured.
+func newCheckpointExporter(ctx context.Context, cfg *config.Config, cpdb checkpoints.CheckpointsDB) (*checkpointExporter, error) {
+	if !cfg.Checkpoint.Enable || len(cfg.Checkpoint.SnapshotURL) == 0 {
+		return nil, nil
+	}
+	u, err := storage.ParseBackend(cfg.Checkpoint.SnapshotURL, &storage.BackendOptions{})
+	if err != nil {
+		return nil, errors.Annotate(err, "invalid `checkpoint.snapshot-url`")
+	}
+	store, err := storage.Create(ctx, u, true)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &checkpointExporter{
+		logger:   log.L(),
+		cpdb:     cpdb,
+		store:    store,
+		interval: cfg.Checkpoint.SnapshotInterval.Duration,
+	}, nil
+}
+
+// exportOrWarn exports a snapshot, and only logs the failure, which does not
+// affect the import itself.
+func (e *checkpointExporter) exportOrWarn(ctx context.Context) {
+	if e == nil {
+		return
+	}
+	task := e.logger.Begin(zap.InfoLevel, "export checkpoint snapshot")
+	err := checkpoints.ExportSnapshot(ctx, e.cpdb, e.store)
+	task.End(zap.WarnLevel, err)
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/web"
+)
+
+// slowestChunksCount is the number of the slowest chunks in the report.
+const slowestChunksCount = 10
+
+// the phases of restoring a table besides encoding and delivering the chunks.
+// The durations of a phase running concurrently on several engines are summed
+// like those of the chunks.
+const (
+	phaseScan     = "scan"
+	phaseEncode   = "encode"
+	phaseDeliver  = "deliver"
+	phaseClose    = "close"
+	phaseImport   = "import"
+	phaseChecksum = "checksum"
+	phaseAnalyze  = "analyze"
+)
+
+// chunkTiming is the time and the IO spent on restoring a chunk. The encode
+// time is the wall time spent on decoding and encoding the rows, and the CPU
+// time is the CPU consumed by the encoding goroutine, including reading,
+// decompressing and parsing the file. The read time includes waiting for the
+// storage, and the deliver time includes waiting for the backend. The read
+// bytes are the bytes of the source file consumed, and the KV bytes are the
+// size of the KV pairs delivered to the backend.
+type chunkTiming struct {
+	tableName string
+	path      string
+	read      time.Duration
+	encode    time.Duration
+	cpu       time.Duration
+	deliver   time.Duration
+	total     time.Duration
+	readBytes int64
+	kvBytes   int64
+}
+
+type tableTiming struct {
+	chunks    int
+	read      time.Duration
+	encode    time.Duration
+	cpu       time.Duration
+	deliver   time.Duration
+	readBytes int64
+	kvBytes   int64
+
+	scan     time.Duration
+	close    time.Duration
+	imp      time.Duration
+	checksum time.Duration
+	analyze  time.Duration
+}
+
+// chunkStats accounts the time spent on every chunk, to find the
+// pathological files (e.g. ultra-wide rows or badly compressed files).
+type chunkStats struct {
+	logger log.Logger
+
+	mu      sync.Mutex
+	tables  map[string]*tableTiming
+	slowest []chunkTiming
+}
+
+func newChunkStats(logger log.Logger) *chunkStats {
+	return &chunkStats{
+		logger: logger,
+		tables: make(map[string]*tableTiming),
+	}
+}
+
+func (s *chunkStats) table(tableName string) *tableTiming {
+	tt, ok := s.tables[tableName]
+	if !ok {
+		tt = &tableTiming{}
+		s.tables[tableName] = tt
+	}
+	return tt
+}
+
+func (s *chunkStats) record(timing chunkTiming) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tt := s.table(timing.tableName)
+	tt.chunks++
+	tt.read += timing.read
+	tt.encode += timing.encode
+	tt.cpu += timing.cpu
+	tt.deliver += timing.deliver
+	tt.readBytes += timing.readBytes
+	tt.kvBytes += timing.kvBytes
+	web.BroadcastTablePhase(timing.tableName, phaseEncode, tt.encode)
+	web.BroadcastTablePhase(timing.tableName, phaseDeliver, tt.deliver)
+
+	// keep the slowest chunks sorted in descending order of the total time.
+	i := sort.Search(len(s.slowest), func(i int) bool { return s.slowest[i].total < timing.total })
+	if i >= slowestChunksCount {
+		return
+	}
+	if len(s.slowest) < slowestChunksCount {
+		s.slowest = append(s.slowest, chunkTiming{})
+	}
+	copy(s.slowest[i+1:], s.slowest[i:])
+	s.slowest[i] = timing
+}
+
+// recordPhase accounts the time spent on a phase of the table other than
+// encoding and delivering.
+func (s *chunkStats) recordPhase(tableName string, phase string, dur time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tt := s.table(tableName)
+	var total *time.Duration
+	switch phase {
+	case phaseScan:
+		total = &tt.scan
+	case phaseClose:
+		total = &tt.close
+	case phaseImport:
+		total = &tt.imp
+	case phaseChecksum:
+		total = &tt.checksum
+	case phaseAnalyze:
+		total = &tt.analyze
+	default:
+		return
+	}
+	*total += dur
+	web.BroadcastTablePhase(tableName, phase, *total)
+}
+
+func (s *chunkStats) emitLog() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tables) == 0 {
+		return
+	}
+
+	tableNames := make([]string, 0, len(s.tables))
+	for tableName := range s.tables {
+		tableNames = append(tableNames, tableName)
+	}
+	sort.Strings(tableNames)
+	s.logger.Info("time spent on tables", zap.Int("count", len(tableNames)))
+	for _, tableName := range tableNames {
+		tt := s.tables[tableName]
+		s.logger.Info("-",
+			zap.String("table", tableName),
+			zap.Int("chunks", tt.chunks),
+			zap.Duration("readDur", tt.read),
+			zap.Duration("encodeDur", tt.encode),
+			zap.Duration("encodeCPU", tt.cpu),
+			zap.Duration("deliverDur", tt.deliver),
+			zap.Int64("readBytes", tt.readBytes),
+			zap.Int64("kvBytes", tt.kvBytes),
+			zap.Duration("scanDur", tt.scan),
+			zap.Duration("closeDur", tt.close),
+			zap.Duration("importDur", tt.imp),
+			zap.Duration("checksumDur", tt.checksum),
+			zap.Duration("analyzeDur", tt.analyze),
+		)
+	}
+
+	s.logger.Info("slowest chunks", zap.Int("count", len(s.slowest)))
+	for _, timing := range s.slowest {
+		s.logger.Info("-",
+			zap.String("table", timing.tableName),
+			zap.String("path", timing.path),
+			zap.Duration("totalDur", timing.total),
+			zap.Duration("readDur", timing.read),
+			zap.Duration("encodeDur", timing.encode),
+			zap.Duration("encodeCPU", timing.cpu),
+			zap.Duration("deliverDur", timing.deliver),
+			zap.Int64("readBytes", timing.readBytes),
+			zap.Int64("kvBytes", timing.kvBytes),
+		)
+	}
+}
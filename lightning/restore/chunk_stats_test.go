@@ -0,0 +1,115 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&chunkStatsSuite{})
+
+type chunkStatsSuite struct{}
+
+func (s *chunkStatsSuite) TestNil(c *C) {
+	var stats *chunkStats
+	stats.record(chunkTiming{tableName: "`db`.`t`", total: time.Second})
+	stats.recordPhase("`db`.`t`", phaseImport, time.Second)
+	stats.emitLog()
+}
+
+func (s *chunkStatsSuite) TestSlowestChunks(c *C) {
+	logger, buffer := log.MakeTestLogger()
+	stats := newChunkStats(logger)
+
+	// no report without any chunk.
+	stats.emitLog()
+	c.Assert(buffer.Stripped(), Equals, "")
+
+	for i := 1; i <= 15; i++ {
+		// the chunks in the middle are the slowest.
+		total := time.Duration(8-abs(i-8)) * time.Second
+		stats.record(chunkTiming{
+			tableName: fmt.Sprintf("`db`.`t%d`", i%2),
+			path:      fmt.Sprintf("db.t.%d.sql:0", i),
+			read:      total / 4,
+			encode:    total / 2,
+			cpu:       total / 4,
+			deliver:   total / 4,
+			total:     total,
+			readBytes: int64(i) * 100,
+			kvBytes:   int64(i) * 300,
+		})
+	}
+
+	c.Assert(stats.slowest, HasLen, slowestChunksCount)
+	for i := 1; i < len(stats.slowest); i++ {
+		c.Assert(stats.slowest[i-1].total >= stats.slowest[i].total, IsTrue)
+	}
+	c.Assert(stats.slowest[0].path, Equals, "db.t.8.sql:0")
+	c.Assert(stats.slowest[0].total, Equals, 8*time.Second)
+	c.Assert(stats.slowest[slowestChunksCount-1].total, Equals, 3*time.Second)
+
+	c.Assert(stats.tables, HasLen, 2)
+	c.Assert(stats.tables["`db`.`t0`"].chunks, Equals, 7)
+	c.Assert(stats.tables["`db`.`t1`"].chunks, Equals, 8)
+	c.Assert(stats.tables["`db`.`t0`"].encode, Equals, 16*time.Second)
+	c.Assert(stats.tables["`db`.`t0`"].readBytes, Equals, int64(5600))
+	c.Assert(stats.tables["`db`.`t0`"].kvBytes, Equals, int64(16800))
+
+	stats.emitLog()
+	lines := buffer.Lines()
+	c.Assert(lines, HasLen, 2+1+slowestChunksCount+1)
+	c.Assert(lines[0], Equals, `{"$lvl":"INFO","$msg":"time spent on tables","count":2}`)
+	c.Assert(lines[1], Equals, `{"$lvl":"INFO","$msg":"-","table":"`+"`db`.`t0`"+`","chunks":7,"readDur":"8s","encodeDur":"16s","encodeCPU":"8s","deliverDur":"8s","readBytes":5600,"kvBytes":16800,"scanDur":"0s","closeDur":"0s","importDur":"0s","checksumDur":"0s","analyzeDur":"0s"}`)
+	c.Assert(lines[3], Equals, `{"$lvl":"INFO","$msg":"slowest chunks","count":10}`)
+	c.Assert(lines[4], Equals, `{"$lvl":"INFO","$msg":"-","table":"`+"`db`.`t0`"+`","path":"db.t.8.sql:0","totalDur":"8s","readDur":"2s","encodeDur":"4s","encodeCPU":"2s","deliverDur":"2s","readBytes":800,"kvBytes":2400}`)
+}
+
+func (s *chunkStatsSuite) TestPhases(c *C) {
+	logger, buffer := log.MakeTestLogger()
+	stats := newChunkStats(logger)
+
+	stats.recordPhase("`db`.`t`", phaseScan, 2*time.Second)
+	stats.recordPhase("`db`.`t`", phaseClose, time.Second)
+	stats.recordPhase("`db`.`t`", phaseImport, 3*time.Second)
+	stats.recordPhase("`db`.`t`", phaseClose, time.Second)
+	stats.recordPhase("`db`.`t`", phaseImport, 5*time.Second)
+	stats.recordPhase("`db`.`t`", phaseChecksum, 4*time.Second)
+	stats.recordPhase("`db`.`t`", phaseAnalyze, 6*time.Second)
+	// the encode and deliver phases are accounted by the chunks.
+	stats.recordPhase("`db`.`t`", phaseEncode, time.Hour)
+	stats.record(chunkTiming{tableName: "`db`.`t`", encode: 7 * time.Second, deliver: 8 * time.Second})
+
+	tt := stats.tables["`db`.`t`"]
+	c.Assert(tt.scan, Equals, 2*time.Second)
+	c.Assert(tt.close, Equals, 2*time.Second)
+	c.Assert(tt.imp, Equals, 8*time.Second)
+	c.Assert(tt.encode, Equals, 7*time.Second)
+
+	stats.emitLog()
+	lines := buffer.Lines()
+	c.Assert(lines[1], Equals, `{"$lvl":"INFO","$msg":"-","table":"`+"`db`.`t`"+`","chunks":1,"readDur":"0s","encodeDur":"7s","encodeCPU":"0s","deliverDur":"8s","readBytes":0,"kvBytes":0,"scanDur":"2s","closeDur":"2s","importDur":"8s","checksumDur":"4s","analyzeDur":"6s"}`)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,98 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	"github.com/pingcap/errors"
+	pd "github.com/tikv/pd/client"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// tsoClient is the subset of the PD client used to read the cluster clock.
+type tsoClient interface {
+	GetTS(ctx context.Context) (physical int64, logical int64, err error)
+}
+
+// measureClockSkew returns how much the local clock is ahead of the physical
+// time of a TSO (negative if behind), excluding the uncertainty caused by the
+// round trip to PD.
+func measureClockSkew(ctx context.Context, cli tsoClient) (time.Duration, error) {
+	before := time.Now()
+	physical, _, err := cli.GetTS(ctx)
+	after := time.Now()
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+
+	// assume the TSO was allocated in the middle of the round trip.
+	uncertainty := after.Sub(before) / 2
+	pdTime := time.Unix(0, physical*int64(time.Millisecond))
+	skew := before.Add(uncertainty).Sub(pdTime)
+	switch {
+	case skew > uncertainty:
+		return skew - uncertainty, nil
+	case skew < -uncertainty:
+		return skew + uncertainty, nil
+	default:
+		return 0, nil
+	}
+}
+
+// checkClockSkew compares the local clock with the TSO of PD. A skewed clock
+// (e.g. NTP drift) breaks the certificate validation and the snapshots used by
+// checksum, which otherwise surface as unrelated errors later. The TiDB
+// backend never talks to PD, so the check is skipped there.
+func (rc *RestoreController) checkClockSkew(ctx context.Context) error {
+	maxSkew := rc.cfg.App.MaxClockSkew.Duration
+	if maxSkew <= 0 || len(rc.cfg.TiDB.PdAddr) == 0 {
+		return nil
+	}
+	switch rc.cfg.TikvImporter.Backend {
+	case config.BackendLocal, config.BackendImporter:
+	default:
+		return nil
+	}
+
+	pdCli, err := pd.NewClient([]string{rc.cfg.TiDB.PdAddr}, rc.tls.ToPDSecurityOption())
+	if err != nil {
+		log.L().Warn("cannot connect to PD to check clock skew", log.ShortError(err))
+		return nil
+	}
+	defer pdCli.Close()
+
+	skew, err := measureClockSkew(ctx, pdCli)
+	if err != nil {
+		log.L().Warn("cannot get TSO to check clock skew", log.ShortError(err))
+		return nil
+	}
+	logger := log.With(zap.Duration("skew", skew), zap.Duration("maxClockSkew", maxSkew))
+	if skew <= maxSkew && skew >= -maxSkew {
+		logger.Info("clock skew checked")
+		return nil
+	}
+
+	if rc.cfg.App.CheckRequirements {
+		return errors.Errorf("the local clock differs from the PD clock by %s, which exceeds `lightning.max-clock-skew` (%s), "+
+			"please synchronize the clock (e.g. with NTP) or set `lightning.check-requirements` to false to skip this check",
+			skew, maxSkew)
+	}
+	logger.Warn("the local clock differs from the PD clock, please synchronize the clock (e.g. with NTP)")
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"time"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&clockSuite{})
+
+type clockSuite struct{}
+
+// fakeTSOClient returns TSOs whose physical time is offset from the local
+// clock.
+type fakeTSOClient struct {
+	offset time.Duration
+	err    error
+}
+
+func (cli fakeTSOClient) GetTS(context.Context) (int64, int64, error) {
+	if cli.err != nil {
+		return 0, 0, cli.err
+	}
+	physical := time.Now().Add(cli.offset).UnixNano() / int64(time.Millisecond)
+	return physical, 0, nil
+}
+
+func (s *clockSuite) TestMeasureClockSkew(c *C) {
+	ctx := context.Background()
+
+	skew, err := measureClockSkew(ctx, fakeTSOClient{})
+	c.Assert(err, IsNil)
+	c.Assert(skew < 10*time.Millisecond && skew > -10*time.Millisecond, IsTrue, Commentf("skew: %s", skew))
+
+	// the local clock is behind PD.
+	skew, err = measureClockSkew(ctx, fakeTSOClient{offset: time.Minute})
+	c.Assert(err, IsNil)
+	c.Assert(skew < -59*time.Second && skew > -61*time.Second, IsTrue, Commentf("skew: %s", skew))
+
+	// the local clock is ahead of PD.
+	skew, err = measureClockSkew(ctx, fakeTSOClient{offset: -time.Minute})
+	c.Assert(err, IsNil)
+	c.Assert(skew > 59*time.Second && skew < 61*time.Second, IsTrue, Commentf("skew: %s", skew))
+
+	_, err = measureClockSkew(ctx, fakeTSOClient{err: errors.New("pd is down")})
+	c.Assert(err, ErrorMatches, "pd is down")
+}
+
+func (s *clockSuite) TestCheckClockSkewSkipped(c *C) {
+	ctx := context.Background()
+	cfg := config.NewConfig()
+	cfg.TiDB.PdAddr = "127.0.0.1:1"
+	cfg.TikvImporter.Backend = config.BackendLocal
+	rc := &RestoreController{cfg: cfg}
+
+	// disabled by default.
+	c.Assert(cfg.App.MaxClockSkew.Duration, Equals, time.Duration(0))
+	c.Assert(rc.checkClockSkew(ctx), IsNil)
+
+	// the TiDB backend does not need PD at all.
+	cfg.App.MaxClockSkew.Duration = time.Second
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	c.Assert(rc.checkClockSkew(ctx), IsNil)
+}
@@ -0,0 +1,322 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/types"
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+const (
+	// keyEncodingSchema is the schema on the target holding the scratch
+	// tables to verify the key encoding.
+	keyEncodingSchema = "lightning_task_info"
+	// keyEncodingSampleRows is the number of rows sampled from the first data
+	// file of the table to verify the key encoding.
+	keyEncodingSampleRows = 100
+)
+
+func isBinaryCollation(collate string) bool {
+	return len(collate) == 0 || collate == charset.CollationBin || strings.HasSuffix(collate, "_bin")
+}
+
+// collationSensitiveIndices returns the indices containing any string column
+// of a non-binary collation, and all columns referred by these indices, in
+// the order of the table columns.
+func collationSensitiveIndices(tableInfo *model.TableInfo) ([]*model.ColumnInfo, []*model.IndexInfo) {
+	var indices []*model.IndexInfo
+	referred := make(map[int]struct{})
+	for _, index := range tableInfo.Indices {
+		sensitive := false
+		for _, idxCol := range index.Columns {
+			col := tableInfo.Columns[idxCol.Offset]
+			if types.IsString(col.Tp) && !isBinaryCollation(col.Collate) {
+				sensitive = true
+				break
+			}
+		}
+		if !sensitive {
+			continue
+		}
+		indices = append(indices, index)
+		for _, idxCol := range index.Columns {
+			referred[idxCol.Offset] = struct{}{}
+		}
+	}
+
+	var columns []*model.ColumnInfo
+	for _, col := range tableInfo.Columns {
+		if _, ok := referred[col.Offset]; ok {
+			columns = append(columns, col)
+		}
+	}
+	return columns, indices
+}
+
+// createScratchTableSQL builds the table with only the given columns and
+// indices. The indices are never unique, so duplicated sample rows can still
+// be inserted.
+func createScratchTableSQL(tableName string, columns []*model.ColumnInfo, indices []*model.IndexInfo) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "CREATE TABLE %s (", tableName)
+	for i, col := range columns {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		common.WriteMySQLIdentifier(&sb, col.Name.O)
+		sb.WriteByte(' ')
+		sb.WriteString(col.FieldType.String())
+	}
+	for _, index := range indices {
+		sb.WriteString(",KEY ")
+		common.WriteMySQLIdentifier(&sb, index.Name.O)
+		sb.WriteByte('(')
+		for i, idxCol := range index.Columns {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			common.WriteMySQLIdentifier(&sb, idxCol.Name.O)
+			if idxCol.Length != types.UnspecifiedLength {
+				fmt.Fprintf(&sb, "(%d)", idxCol.Length)
+			}
+		}
+		sb.WriteByte(')')
+	}
+	sb.WriteByte(')')
+	return sb.String()
+}
+
+// verifyKeyEncoding cross-checks the keys of the indices on non-binary
+// collation string columns encoded by Lightning against the target TiDB,
+// since a mismatch (e.g. the new collation framework is enabled on the target)
+// would only be found by the checksum after the whole table is imported.
+//
+// The sample rows from the first data file are inserted into a scratch table
+// via SQL, and the checksum of the scratch table is compared with the one
+// encoded locally using the scratch table info.
+func (t *TableRestore) verifyKeyEncoding(ctx context.Context, rc *RestoreController, cp *checkpoints.TableCheckpoint) error {
+	columns, indices := collationSensitiveIndices(t.tableInfo.Core)
+	if len(indices) == 0 {
+		return nil
+	}
+	engine, ok := cp.Engines[0]
+	if !ok || len(engine.Chunks) == 0 {
+		return nil
+	}
+
+	task := t.logger.Begin(zap.InfoLevel, "verify key encoding")
+	rows, err := t.sampleKeyEncodingRows(ctx, rc, engine.Chunks[0], columns)
+	if err == nil && len(rows) > 0 {
+		err = t.compareKeyEncoding(ctx, rc, columns, indices, rows)
+	}
+	task.End(zap.ErrorLevel, err)
+	return errors.Trace(err)
+}
+
+// sampleKeyEncodingRows reads the rows from the start of the chunk, and
+// returns the values of the given columns.
+func (t *TableRestore) sampleKeyEncodingRows(
+	ctx context.Context,
+	rc *RestoreController,
+	chunk *checkpoints.ChunkCheckpoint,
+	columns []*model.ColumnInfo,
+) ([][]types.Datum, error) {
+	sampleChunk := *chunk
+	sampleChunk.Chunk.Offset = chunk.Key.Offset
+	cr, err := newChunkRestore(ctx, 0, rc.cfg, &sampleChunk, rc.ioWorkers, rc.store, t.tableName, t.tableInfo)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer cr.close()
+
+	var decoders map[int]mydump.ColumnDecoder
+	var maskers map[int]mydump.ColumnMasker
+	rows := make([][]types.Datum, 0, keyEncodingSampleRows)
+	for len(rows) < keyEncodingSampleRows {
+		if offset, _ := cr.parser.Pos(); offset >= sampleChunk.Chunk.EndOffset {
+			break
+		}
+		err := cr.parser.ReadRow()
+		if errors.Cause(err) == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if len(rows) == 0 {
+			if len(sampleChunk.ColumnPermutation) == 0 {
+				if err := t.initializeColumns(cr.parser.Columns(), &sampleChunk); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			if decoders, err = t.columnDecoders(rc.cfg, sampleChunk.ColumnPermutation); err != nil {
+				return nil, errors.Trace(err)
+			}
+			if maskers, err = t.columnMaskers(rc.cfg, sampleChunk.ColumnPermutation); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+
+		lastRow := cr.parser.LastRow()
+		if err := mydump.DecodeColumns(lastRow.Row, decoders); err != nil {
+			return nil, errors.Trace(err)
+		}
+		mydump.MaskColumns(lastRow.Row, maskers)
+		row := make([]types.Datum, len(columns))
+		for i, col := range columns {
+			if j := sampleChunk.ColumnPermutation[col.Offset]; j >= 0 && j < len(lastRow.Row) {
+				row[i] = lastRow.Row[j]
+			}
+		}
+		rows = append(rows, row)
+		cr.parser.RecycleRow(lastRow)
+	}
+	return rows, nil
+}
+
+func (t *TableRestore) compareKeyEncoding(
+	ctx context.Context,
+	rc *RestoreController,
+	columns []*model.ColumnInfo,
+	indices []*model.IndexInfo,
+	rows [][]types.Datum,
+) error {
+	exec := common.SQLWithRetry{DB: rc.tidbMgr.db, Logger: t.logger}
+	scratchName := fmt.Sprintf("verify_%d", t.tableInfo.ID)
+	scratchTable := common.UniqueTable(keyEncodingSchema, scratchName)
+
+	var createDatabase strings.Builder
+	createDatabase.WriteString("CREATE DATABASE IF NOT EXISTS ")
+	common.WriteMySQLIdentifier(&createDatabase, keyEncodingSchema)
+	if err := exec.Exec(ctx, "create scratch schema", createDatabase.String()); err != nil {
+		return errors.Trace(err)
+	}
+	if err := exec.Exec(ctx, "drop scratch table", "DROP TABLE IF EXISTS "+scratchTable); err != nil {
+		return errors.Trace(err)
+	}
+	if err := exec.Exec(ctx, "create scratch table", createScratchTableSQL(scratchTable, columns, indices)); err != nil {
+		return errors.Trace(err)
+	}
+	defer func() {
+		if err := exec.Exec(ctx, "drop scratch table", "DROP TABLE IF EXISTS "+scratchTable); err != nil {
+			t.logger.Warn("failed to drop the scratch table, please drop it manually",
+				zap.String("scratchTable", scratchTable), log.ShortError(err))
+		}
+	}()
+
+	scratchInfo, err := rc.backend.FetchRemoteTableModels(keyEncodingSchema)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	var scratchTableInfo *model.TableInfo
+	for _, tblInfo := range scratchInfo {
+		if tblInfo.Name.L == scratchName {
+			scratchTableInfo = tblInfo
+			break
+		}
+	}
+	if scratchTableInfo == nil {
+		return errors.Errorf("scratch table %s not found", scratchTable)
+	}
+
+	// encode locally. rows failing to encode would fail the import anyway,
+	// and are excluded from the comparison.
+	tbl, err := tables.TableFromMeta(kv.NewPanickingAllocators(0), scratchTableInfo)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	encoder := rc.backend.NewEncoder(tbl, &kv.SessionOptions{
+		SQLMode:          rc.cfg.TableSQLMode(t.tableName),
+		RowFormatVersion: rc.rowFormatVer,
+	})
+	defer encoder.Close()
+
+	colPerm := make([]int, 0, len(columns)+1)
+	for i := range columns {
+		colPerm = append(colPerm, i)
+	}
+	colPerm = append(colPerm, -1)
+
+	var localChecksum verify.KVChecksum
+	dataKVs, indexKVs := rc.backend.MakeEmptyRows(), rc.backend.MakeEmptyRows()
+	args := make([]interface{}, 0, len(rows)*len(columns))
+	var insert strings.Builder
+	fmt.Fprintf(&insert, "INSERT INTO %s VALUES ", scratchTable)
+	encoded := 0
+	for _, row := range rows {
+		// the scratch table is new, so TiDB allocates the row IDs from 1.
+		kvs, err := encoder.Encode(log.L(), row, int64(encoded+1), colPerm)
+		if err != nil {
+			continue
+		}
+		kvs.ClassifyAndAppend(&dataKVs, &localChecksum, &indexKVs, &localChecksum)
+
+		if encoded > 0 {
+			insert.WriteByte(',')
+		}
+		insert.WriteString("(?" + strings.Repeat(",?", len(columns)-1) + ")")
+		for i := range row {
+			args = append(args, row[i].GetValue())
+		}
+		encoded++
+	}
+	if encoded == 0 {
+		return nil
+	}
+	if err := exec.Exec(ctx, "insert sample rows", insert.String(), args...); err != nil {
+		return errors.Trace(err)
+	}
+
+	remoteChecksum, err := DoChecksum(ctx, rc.tidbMgr.db, scratchTable)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if remoteChecksum.Checksum != localChecksum.Sum() ||
+		remoteChecksum.TotalKVs != localChecksum.SumKVS() ||
+		remoteChecksum.TotalBytes != localChecksum.SumSize() {
+		names := make([]string, 0, len(columns))
+		for _, col := range columns {
+			if types.IsString(col.Tp) && !isBinaryCollation(col.Collate) {
+				names = append(names, fmt.Sprintf("%s (%s)", col.Name.O, col.Collate))
+			}
+		}
+		return errors.Errorf("key encoding of %d sample rows mismatched remote vs local => (checksum: %d vs %d) (total_kvs: %d vs %d) (total_bytes:%d vs %d), "+
+			"the collation of columns %s is likely encoded differently by the target (e.g. the new collation framework is enabled), "+
+			"and the table would fail the checksum after import",
+			encoded,
+			remoteChecksum.Checksum, localChecksum.Sum(),
+			remoteChecksum.TotalKVs, localChecksum.SumKVS(),
+			remoteChecksum.TotalBytes, localChecksum.SumSize(),
+			strings.Join(names, ", "),
+		)
+	}
+
+	t.logger.Info("key encoding verified", zap.Int("sampleRows", encoded), zap.Object("local", &localChecksum))
+	return nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/tidb/ddl"
+	tmock "github.com/pingcap/tidb/util/mock"
+)
+
+var _ = Suite(&collationSuite{})
+
+type collationSuite struct{}
+
+func (s *collationSuite) TestCollationSensitiveIndices(c *C) {
+	node, err := parser.New().ParseOneStmt(`
+		CREATE TABLE t (
+			id INT PRIMARY KEY,
+			name VARCHAR(20) COLLATE utf8mb4_general_ci,
+			code VARCHAR(10) COLLATE utf8mb4_bin,
+			n INT,
+			raw VARBINARY(10),
+			KEY idx_name (name(8), n),
+			KEY idx_code (code),
+			UNIQUE KEY idx_raw (raw)
+		)
+	`, "", "")
+	c.Assert(err, IsNil)
+	tableInfo, err := ddl.MockTableInfo(tmock.NewContext(), node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+
+	columns, indices := collationSensitiveIndices(tableInfo)
+	c.Assert(indices, HasLen, 1)
+	c.Assert(indices[0].Name.O, Equals, "idx_name")
+	c.Assert(columns, HasLen, 2)
+	c.Assert(columns[0].Name.O, Equals, "name")
+	c.Assert(columns[1].Name.O, Equals, "n")
+
+	sql := createScratchTableSQL("`lightning_task_info`.`verify_1`", columns, indices)
+	c.Assert(sql, Matches, "CREATE TABLE `lightning_task_info`.`verify_1` \\(`name` varchar\\(20\\) .*COLLATE utf8mb4_general_ci,`n` int.*,KEY `idx_name`\\(`name`\\(8\\),`n`\\)\\)")
+}
+
+func (s *collationSuite) TestIsBinaryCollation(c *C) {
+	c.Assert(isBinaryCollation(""), IsTrue)
+	c.Assert(isBinaryCollation("binary"), IsTrue)
+	c.Assert(isBinaryCollation("utf8mb4_bin"), IsTrue)
+	c.Assert(isBinaryCollation("utf8mb4_general_ci"), IsFalse)
+	c.Assert(isBinaryCollation("utf8mb4_unicode_ci"), IsFalse)
+}
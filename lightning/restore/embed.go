@@ -0,0 +1,162 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// ImportObserver is notified of the progress of the tables imported by
+// ImportTable. The methods may be called concurrently.
+type ImportObserver interface {
+	// Delivered is called after the rows parsed from `bytes` bytes of the
+	// source files are delivered to the backend.
+	Delivered(tableName string, rows int64, bytes int64)
+	// Finished is called once the table is imported, or failed to.
+	Finished(tableName string, err error)
+}
+
+// ImportTableOptions are the optional dependencies of ImportTable.
+type ImportTableOptions struct {
+	// Checkpoints stores the progress of the table to resume from. The caller
+	// owns and closes it. Defaults to no checkpoints.
+	Checkpoints checkpoints.CheckpointsDB
+	// Observer is notified of the progress. Defaults to none.
+	Observer ImportObserver
+	// Pauser pauses the delivery of the rows. Defaults to a pauser private to
+	// this call.
+	Pauser *common.Pauser
+}
+
+// ImportTable imports the data files of a single table into the target
+// through the given backend, running the same chunk restore pipeline and post
+// processing (checksum, analyze) as a full Lightning task. The table must
+// already exist on the target.
+//
+// Unlike RestoreController.Run, it does not switch TiKV into import mode, nor
+// compact the cluster at the end, which are left to the caller.
+func ImportTable(
+	ctx context.Context,
+	cfg *config.Config,
+	tableMeta *mydump.MDTableMeta,
+	store storage.ExternalStorage,
+	backend kv.Backend,
+	opts ImportTableOptions,
+) error {
+	tls, err := cfg.ToTLS()
+	if err != nil {
+		return errors.Trace(err)
+	}
+	cpdb := opts.Checkpoints
+	if cpdb == nil {
+		cpdb = checkpoints.NewNullCheckpointsDB()
+	}
+	pauser := opts.Pauser
+	if pauser == nil {
+		pauser = common.NewPauser()
+	}
+
+	taskCp, err := cpdb.TaskCheckpoint(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := verifyCheckpoint(cfg, taskCp); err != nil {
+		return errors.Trace(err)
+	}
+
+	tidbMgr, err := NewTiDBManager(cfg.TiDB.DDLStore(), tls)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer tidbMgr.Close()
+
+	dbMetas := []*mydump.MDDatabaseMeta{{Name: tableMeta.DB, Tables: []*mydump.MDTableMeta{tableMeta}}}
+	dbInfos, err := tidbMgr.LoadSchemaInfo(ctx, dbMetas, backend.FetchRemoteTableModels)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	dbInfo := dbInfos[tableMeta.DB]
+	tableInfo, ok := dbInfo.Tables[tableMeta.Name]
+	if !ok {
+		return errors.Errorf("table info %s.%s not found", tableMeta.DB, tableMeta.Name)
+	}
+	if err := cpdb.Initialize(ctx, cfg, dbInfos); err != nil {
+		return errors.Trace(err)
+	}
+
+	rc := &RestoreController{
+		cfg:           cfg,
+		dbMetas:       dbMetas,
+		dbInfos:       dbInfos,
+		tableWorkers:  worker.NewPool(ctx, cfg.App.TableConcurrency, "table"),
+		indexWorkers:  worker.NewPool(ctx, cfg.App.IndexConcurrency, "index"),
+		regionWorkers: worker.NewPool(ctx, cfg.App.RegionConcurrency, "region"),
+		ioWorkers:     worker.NewPool(ctx, cfg.App.IOConcurrency, "io"),
+		pauser:        pauser,
+		backend:       backend,
+		tidbMgr:       tidbMgr,
+		rowFormatVer:  ObtainRowFormatVersion(ctx, tidbMgr.db),
+		tls:           tls,
+		compat:        targetCompat(cfg),
+
+		errorSummaries:    makeErrorSummaries(log.L()),
+		observer:          opts.Observer,
+		checkpointsDB:     cpdb,
+		saveCpCh:          make(chan saveCp),
+		closedEngineLimit: worker.NewPool(ctx, cfg.App.TableConcurrency*2, "closed-engine"),
+
+		store: store,
+	}
+	go rc.listenCheckpointUpdates()
+	defer rc.waitCheckpointFinish()
+
+	tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+	cp, err := cpdb.Get(ctx, tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if cp.Status <= checkpoints.CheckpointStatusMaxInvalid {
+		return errors.Errorf("the checkpoint of table %s is invalid (status %d), please resolve the error of the last run first", tableName, cp.Status)
+	}
+	if cp.TableID > 0 && cp.TableID != tableInfo.ID {
+		return errors.Errorf("the checkpoint of table %s does not match the table on the target, please remove the checkpoint first", tableName)
+	}
+
+	tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	task := tr.logger.Begin(zap.InfoLevel, "import table")
+	ctx = context.WithValue(ctx, &gcLifeTimeKey, newGCLifeTimeManager(rc.compat))
+	err = tr.restoreTable(ctx, rc, cp)
+	err = errors.Annotatef(err, "import table %s failed", tableName)
+	task.End(zap.ErrorLevel, err)
+	if rc.observer != nil {
+		rc.observer.Finished(tableName, err)
+	}
+	return err
+}
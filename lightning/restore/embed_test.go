@@ -0,0 +1,52 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&embedSuite{})
+
+type embedSuite struct{}
+
+func (s *embedSuite) TestImportTableVerifiesCheckpoint(c *C) {
+	cpdb, err := checkpoints.NewFileCheckpointsDB(filepath.Join(c.MkDir(), "cp.pb"))
+	c.Assert(err, IsNil)
+	defer cpdb.Close()
+	ctx := context.Background()
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 123
+	cfg.TikvImporter.Backend = config.BackendLocal
+	cfg.TikvImporter.SortedKVDir = "/tmp/sorted-kv"
+	err = cpdb.Initialize(ctx, cfg, map[string]*checkpoints.TidbDBInfo{})
+	c.Assert(err, IsNil)
+
+	// the checkpoints of another backend must not be resumed, which is
+	// rejected before connecting to the target.
+	cfg2 := config.NewConfig()
+	cfg2.TaskID = 123
+	cfg2.TikvImporter.Backend = config.BackendTiDB
+	tableMeta := &mydump.MDTableMeta{DB: "db", Name: "t"}
+	err = ImportTable(ctx, cfg2, tableMeta, nil, nil, ImportTableOptions{Checkpoints: cpdb})
+	c.Assert(err, ErrorMatches, "config 'tikv-importer.backend' value 'tidb' different from checkpoint value 'local'.*")
+}
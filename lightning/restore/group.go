@@ -0,0 +1,207 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+const (
+	groupStatusCompleted  = "completed"
+	groupStatusFailed     = "failed"
+	groupStatusIncomplete = "incomplete"
+)
+
+type deferredChecksum struct {
+	tableName string
+	run       func(context.Context) error
+}
+
+type tableGroupState struct {
+	total    int
+	arrived  map[string]struct{}
+	checked  int
+	failed   map[string]error
+	deferred []deferredChecksum
+	start    time.Time
+	finish   time.Time
+}
+
+// tableGroupTracker defers the checksum of the tables in a table group until
+// all members are imported, so the whole group is verified in the same
+// window, and summarizes the status of every group at the end of the import.
+type tableGroupTracker struct {
+	logger log.Logger
+
+	mu     sync.Mutex
+	groups map[string]*tableGroupState
+}
+
+// newTableGroupTracker returns nil if no table belongs to any group.
+func newTableGroupTracker(logger log.Logger, dbMetas []*mydump.MDDatabaseMeta) *tableGroupTracker {
+	groups := make(map[string]*tableGroupState)
+	for _, dbMeta := range dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			if len(tableMeta.Group) == 0 {
+				continue
+			}
+			state, ok := groups[tableMeta.Group]
+			if !ok {
+				state = &tableGroupState{
+					arrived: make(map[string]struct{}),
+					failed:  make(map[string]error),
+				}
+				groups[tableMeta.Group] = state
+			}
+			state.total++
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	return &tableGroupTracker{logger: logger, groups: groups}
+}
+
+// get returns nil if the table is not in any group. The groups are never
+// changed after creation, so it is safe to call without the lock.
+func (g *tableGroupTracker) get(group string) *tableGroupState {
+	if g == nil || len(group) == 0 {
+		return nil
+	}
+	return g.groups[group]
+}
+
+// start records the beginning of the import window of the group.
+func (g *tableGroupTracker) start(group string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if state := g.get(group); state != nil && state.start.IsZero() {
+		state.start = time.Now()
+	}
+}
+
+// fail records a member failed to be imported. Failures after the member
+// arrived are recorded by the checksum of the group instead.
+func (g *tableGroupTracker) fail(group string, tableName string, err error) {
+	if g == nil || err == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if state := g.get(group); state != nil {
+		if _, ok := state.arrived[tableName]; !ok {
+			state.failed[tableName] = err
+		}
+	}
+}
+
+// arrive runs the checksum of the imported table. If the table belongs to a
+// group, the checksum is deferred until the last member arrives, which then
+// runs the checksums of all members.
+func (g *tableGroupTracker) arrive(ctx context.Context, group string, tableName string, checksum func(context.Context) error) error {
+	if g.get(group) == nil {
+		return checksum(ctx)
+	}
+	g.mu.Lock()
+	state := g.get(group)
+	state.arrived[tableName] = struct{}{}
+	state.deferred = append(state.deferred, deferredChecksum{tableName: tableName, run: checksum})
+	if arrived := len(state.arrived); arrived < state.total {
+		g.mu.Unlock()
+		g.logger.Info("checksum deferred until the table group is imported",
+			zap.String("table", tableName),
+			zap.String("group", group),
+			zap.Int("imported", arrived),
+			zap.Int("total", state.total),
+		)
+		return nil
+	}
+	deferred := state.deferred
+	state.deferred = nil
+	g.mu.Unlock()
+
+	var firstErr error
+	for _, d := range deferred {
+		err := d.run(ctx)
+		g.mu.Lock()
+		if err != nil {
+			state.failed[d.tableName] = err
+		} else {
+			state.checked++
+		}
+		g.mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = errors.Annotatef(err, "table %s of group %s", d.tableName, group)
+		}
+	}
+
+	g.mu.Lock()
+	state.finish = time.Now()
+	g.mu.Unlock()
+	return firstErr
+}
+
+// emitLog reports the status of every table group.
+func (g *tableGroupTracker) emitLog() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	names := make([]string, 0, len(g.groups))
+	for name := range g.groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		state := g.groups[name]
+		fields := []zap.Field{
+			zap.String("group", name),
+			zap.Int("tables", state.total),
+			zap.Int("imported", len(state.arrived)),
+			zap.Int("checked", state.checked),
+		}
+		if !state.finish.IsZero() {
+			fields = append(fields, zap.Duration("window", state.finish.Sub(state.start)))
+		}
+
+		switch {
+		case len(state.failed) > 0:
+			failed := make([]string, 0, len(state.failed))
+			for tableName := range state.failed {
+				failed = append(failed, tableName)
+			}
+			sort.Strings(failed)
+			g.logger.Error("table group", append(fields, zap.String("status", groupStatusFailed), zap.Strings("failedTables", failed))...)
+		case state.checked == state.total:
+			g.logger.Info("table group", append(fields, zap.String("status", groupStatusCompleted))...)
+		default:
+			g.logger.Warn("table group", append(fields, zap.String("status", groupStatusIncomplete))...)
+		}
+	}
+}
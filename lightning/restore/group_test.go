@@ -0,0 +1,98 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&tableGroupSuite{})
+
+type tableGroupSuite struct{}
+
+func (s *tableGroupSuite) TestNoGroup(c *C) {
+	g := newTableGroupTracker(log.L(), []*mydump.MDDatabaseMeta{
+		{Name: "db", Tables: []*mydump.MDTableMeta{{DB: "db", Name: "t"}}},
+	})
+	c.Assert(g, IsNil)
+
+	// all methods are no-op on nil, and the checksum runs right away.
+	g.start("")
+	g.fail("", "`db`.`t`", errors.New("error"))
+	called := false
+	err := g.arrive(context.Background(), "", "`db`.`t`", func(context.Context) error {
+		called = true
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(called, IsTrue)
+	g.emitLog()
+}
+
+func (s *tableGroupSuite) TestDeferChecksum(c *C) {
+	logger, buffer := log.MakeTestLogger()
+	g := newTableGroupTracker(logger, []*mydump.MDDatabaseMeta{
+		{Name: "db", Tables: []*mydump.MDTableMeta{
+			{DB: "db", Name: "a", Group: "g1"},
+			{DB: "db", Name: "b", Group: "g1"},
+			{DB: "db", Name: "c", Group: "g2"},
+			{DB: "db", Name: "d", Group: "g2"},
+			{DB: "db", Name: "e", Group: "g3"},
+			{DB: "db", Name: "f", Group: "g3"},
+			{DB: "db", Name: "other"},
+		}},
+	})
+	c.Assert(g, NotNil)
+
+	ctx := context.Background()
+	var checked []string
+	checksum := func(tableName string, err error) func(context.Context) error {
+		return func(context.Context) error {
+			checked = append(checked, tableName)
+			return err
+		}
+	}
+
+	g.start("g1")
+	c.Assert(g.arrive(ctx, "g1", "a", checksum("a", nil)), IsNil)
+	c.Assert(checked, HasLen, 0)
+	c.Assert(g.arrive(ctx, "g1", "b", checksum("b", nil)), IsNil)
+	c.Assert(checked, DeepEquals, []string{"a", "b"})
+
+	g.start("g2")
+	c.Assert(g.arrive(ctx, "g2", "c", checksum("c", errors.New("checksum mismatched"))), IsNil)
+	err := g.arrive(ctx, "g2", "d", checksum("d", nil))
+	c.Assert(err, ErrorMatches, "table c of group g2: checksum mismatched")
+	g.fail("g2", "d", err)
+	c.Assert(checked, DeepEquals, []string{"a", "b", "c", "d"})
+
+	g.start("g3")
+	c.Assert(g.arrive(ctx, "g3", "e", checksum("e", nil)), IsNil)
+	g.fail("g3", "f", errors.New("write failed"))
+	c.Assert(checked, DeepEquals, []string{"a", "b", "c", "d"})
+
+	buffer.Reset()
+	g.emitLog()
+	lines := buffer.Lines()
+	c.Assert(lines, HasLen, 3)
+	c.Assert(lines[0], Matches, `\{"\$lvl":"INFO","\$msg":"table group","group":"g1","tables":2,"imported":2,"checked":2,"window":.*,"status":"completed"\}`)
+	c.Assert(lines[1], Matches, `\{"\$lvl":"ERROR","\$msg":"table group","group":"g2","tables":2,"imported":2,"checked":1,"window":.*,"status":"failed","failedTables":\["c"\]\}`)
+	c.Assert(lines[2], Equals, `{"$lvl":"ERROR","$msg":"table group","group":"g3","tables":2,"imported":1,"checked":0,"status":"failed","failedTables":["f"]}`)
+}
@@ -0,0 +1,356 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+const (
+	mib = float64(1 << 20)
+
+	// the bytes of the source each region worker encodes per second, which
+	// bounds the import rate by the region concurrency.
+	encodeRatePerWorker = 16 * mib
+
+	// the bytes written to the disk of each replica per byte of the source,
+	// for the SSTs ingested by the local and importer backends (the SST and
+	// its compaction), and for the transactions of the TiDB backend (the raft
+	// log, the WAL, the flush and the compaction).
+	ingestWriteAmplification = 2.0
+	txnWriteAmplification    = 4.0
+
+	// the CPU cores of TiKV used per MiB/s of the source written to each
+	// replica.
+	ingestCPUPerMiB = 0.02
+	txnCPUPerMiB    = 0.1
+
+	promQueryTimeout = 30 * time.Second
+)
+
+// the queries of the metrics of TiKV, by the instances of the stores. The
+// queries of the peak usage are formatted with the window.
+const (
+	cpuCoresQuery    = `max by (instance) (tikv_server_cpu_cores_quota)`
+	regionCountQuery = `max by (instance) (tikv_raftstore_region_count{type="region"})`
+	cpuUsedQuery     = `max_over_time(sum by (instance) (rate(tikv_thread_cpu_seconds_total[1m]))[%s:1m])`
+)
+
+// the disk writes are summed from the peaks of these queries, which is never
+// lower than the peak of the sum.
+var diskWriteQueries = []string{
+	`max_over_time(sum by (instance) (rate(tikv_engine_flow_bytes{db="kv", type="wal_file_bytes"}[1m]))[%s:1m])`,
+	`max_over_time(sum by (instance) (rate(tikv_engine_compaction_flow_bytes{db="kv", type="bytes_written"}[1m]))[%s:1m])`,
+}
+
+// StoreUsage is the usage of a TiKV store, the peak over the window except
+// the region count which is the latest.
+type StoreUsage struct {
+	Instance string
+	CPUCores float64
+	CPUUsed  float64
+	// WriteBytes is the bytes written to the disk per second.
+	WriteBytes float64
+	Regions    int64
+}
+
+// ImpactReport is the result of the simulate-impact mode.
+type ImpactReport struct {
+	Stores     []StoreUsage
+	Replicas   int
+	SourceSize int64
+
+	// the headroom of the most loaded store, in CPU cores, disk bytes per
+	// second and regions.
+	CPUHeadroom    float64
+	WriteHeadroom  float64
+	RegionHeadroom int64
+	// NewRegionsPerStore is the regions added to each store by the import.
+	NewRegionsPerStore int64
+	// MaxImportRate is the bytes of the source per second the cluster can
+	// absorb within the headroom.
+	MaxImportRate float64
+
+	// the recommended `lightning.region-concurrency` and
+	// `mydumper.read-bandwidth`, where 0 means no limit.
+	RegionConcurrency int
+	ReadBandwidth     int64
+	// EstimatedDuration is the duration of writing the source at the
+	// recommended settings.
+	EstimatedDuration time.Duration
+
+	// Issues are the reasons the import does not fit in the headroom.
+	Issues []string
+}
+
+// SimulateImpact analyzes the headroom of the target cluster from the
+// historical metrics in Prometheus, simulates the load of importing the data
+// source with the configured backend, and recommends the region concurrency
+// and the read bandwidth keeping the stores within the headroom. Nothing is
+// written into the target. An error is returned if the import does not fit in
+// the headroom, and the details are logged.
+func SimulateImpact(
+	ctx context.Context,
+	cfg *config.Config,
+	dbMetas []*mydump.MDDatabaseMeta,
+	tls *common.TLS,
+) (*ImpactReport, error) {
+	var sourceSize int64
+	for _, dbMeta := range dbMetas {
+		for _, tableMeta := range dbMeta.Tables {
+			sourceSize += tableMeta.TotalSize
+		}
+	}
+
+	replicateCfg, err := kv.GetReplicateConfig(tls.WithHost(cfg.TiDB.PdAddr))
+	if err != nil {
+		return nil, errors.Annotate(err, "get the replication config of PD")
+	}
+	prom := &promClient{
+		client: &http.Client{Timeout: promQueryTimeout},
+		addrs:  cfg.Simulate.PrometheusAddrs,
+	}
+	stores, err := prom.storeUsages(ctx, cfg.Simulate.Window.Duration)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	report := simulateImpact(cfg, sourceSize, replicateCfg.MaxReplicas, stores)
+	for _, store := range report.Stores {
+		log.L().Info("store usage",
+			zap.String("instance", store.Instance),
+			zap.Float64("cpuCores", store.CPUCores),
+			zap.Float64("peakCPUUsed", store.CPUUsed),
+			zap.Float64("peakWriteBytes", store.WriteBytes),
+			zap.Int64("regions", store.Regions))
+	}
+	fields := []zap.Field{
+		zap.Int64("sourceSize", report.SourceSize),
+		zap.Int("replicas", report.Replicas),
+		zap.Float64("cpuHeadroom", report.CPUHeadroom),
+		zap.Float64("writeHeadroom", report.WriteHeadroom),
+		zap.Int64("regionHeadroom", report.RegionHeadroom),
+		zap.Int64("newRegionsPerStore", report.NewRegionsPerStore),
+	}
+	if len(report.Issues) > 0 {
+		log.L().Warn("the import does not fit in the headroom of the target cluster",
+			append(fields, zap.Strings("issues", report.Issues))...)
+		return report, errors.Errorf("the import does not fit in the headroom of the target cluster: %s",
+			strings.Join(report.Issues, "; "))
+	}
+	log.L().Info("recommended settings of the import", append(fields,
+		zap.Float64("maxImportRate", report.MaxImportRate),
+		zap.Int("region-concurrency", report.RegionConcurrency),
+		zap.Int64("read-bandwidth", report.ReadBandwidth),
+		zap.Duration("estimatedDuration", report.EstimatedDuration),
+	)...)
+	return report, nil
+}
+
+// simulateImpact computes the report from the usage of the stores. The load of
+// the import is spread evenly over the stores, so the most loaded store
+// bounds the import rate.
+func simulateImpact(cfg *config.Config, sourceSize int64, replicas int, stores []StoreUsage) *ImpactReport {
+	report := &ImpactReport{
+		Stores:         stores,
+		Replicas:       replicas,
+		SourceSize:     sourceSize,
+		CPUHeadroom:    math.Inf(1),
+		WriteHeadroom:  math.Inf(1),
+		RegionHeadroom: math.MaxInt64,
+	}
+	sim := &cfg.Simulate
+	for _, store := range stores {
+		cpuHeadroom := store.CPUCores*sim.MaxUtilization - store.CPUUsed
+		if cpuHeadroom <= 0 {
+			report.Issues = append(report.Issues, fmt.Sprintf("the CPU of store %s is already used beyond %.0f%% at the peak",
+				store.Instance, sim.MaxUtilization*100))
+		}
+		writeHeadroom := float64(sim.StoreWriteBandwidth)*sim.MaxUtilization - store.WriteBytes
+		if writeHeadroom <= 0 {
+			report.Issues = append(report.Issues, fmt.Sprintf("the disk of store %s is already written beyond %.0f%% at the peak",
+				store.Instance, sim.MaxUtilization*100))
+		}
+		report.CPUHeadroom = math.Min(report.CPUHeadroom, cpuHeadroom)
+		report.WriteHeadroom = math.Min(report.WriteHeadroom, writeHeadroom)
+		if h := sim.MaxRegionsPerStore - store.Regions; h < report.RegionHeadroom {
+			report.RegionHeadroom = h
+		}
+	}
+
+	// each region is replicated to the distinct stores.
+	stored := float64(replicas)
+	if stored > float64(len(stores)) {
+		stored = float64(len(stores))
+	}
+	regionSize := cfg.TikvImporter.RegionSplitSize
+	if regionSize <= 0 {
+		regionSize = config.SplitRegionSize
+	}
+	newRegions := (sourceSize + regionSize - 1) / regionSize
+	report.NewRegionsPerStore = int64(math.Ceil(float64(newRegions) * stored / float64(len(stores))))
+	if report.NewRegionsPerStore > report.RegionHeadroom {
+		report.Issues = append(report.Issues, fmt.Sprintf("the import adds about %d regions to each store, beyond the headroom of %d regions",
+			report.NewRegionsPerStore, report.RegionHeadroom))
+	}
+	if len(report.Issues) > 0 {
+		return report
+	}
+
+	amplification, cpuPerMiB := ingestWriteAmplification, ingestCPUPerMiB
+	if cfg.TikvImporter.Backend == config.BackendTiDB {
+		amplification, cpuPerMiB = txnWriteAmplification, txnCPUPerMiB
+	}
+	// the bytes of the source per second writes stored/len(stores) of them
+	// to each store.
+	spread := float64(len(stores)) / stored
+	report.MaxImportRate = math.Min(
+		report.WriteHeadroom/amplification*spread,
+		report.CPUHeadroom/cpuPerMiB*mib*spread,
+	)
+
+	report.RegionConcurrency = cfg.App.RegionConcurrency
+	rate := float64(cfg.App.RegionConcurrency) * encodeRatePerWorker
+	if report.MaxImportRate < rate {
+		report.RegionConcurrency = int(math.Ceil(report.MaxImportRate / encodeRatePerWorker))
+		report.ReadBandwidth = int64(report.MaxImportRate)
+		rate = report.MaxImportRate
+	}
+	report.EstimatedDuration = time.Duration(float64(sourceSize) / rate * float64(time.Second))
+	return report
+}
+
+// promClient queries the Prometheus servers in order until one answers.
+type promClient struct {
+	client *http.Client
+	addrs  []string
+}
+
+type promResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Metric map[string]string `json:"metric"`
+			// Value is the timestamp and the value as a string.
+			Value []interface{} `json:"value"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// query runs the instant query, returning the values keyed by the instances.
+func (p *promClient) query(ctx context.Context, query string) (map[string]float64, error) {
+	var lastErr error
+	for _, addr := range p.addrs {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		var resp promResponse
+		err := common.GetJSON(p.client, strings.TrimSuffix(addr, "/")+"/api/v1/query?query="+url.QueryEscape(query), &resp)
+		if err == nil && resp.Status != "success" {
+			err = errors.Errorf("query failed: %s", resp.Error)
+		}
+		if err != nil {
+			log.L().Warn("failed to query Prometheus", zap.String("addr", addr), zap.String("query", query), log.ShortError(err))
+			lastErr = err
+			continue
+		}
+		if resp.Data.ResultType != "vector" {
+			return nil, errors.Errorf("unexpected result type '%s' of query %s", resp.Data.ResultType, query)
+		}
+		values := make(map[string]float64, len(resp.Data.Result))
+		for _, sample := range resp.Data.Result {
+			if len(sample.Value) != 2 {
+				return nil, errors.Errorf("malformed sample %v of query %s", sample.Value, query)
+			}
+			s, _ := sample.Value[1].(string)
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, errors.Annotatef(err, "malformed sample %v of query %s", sample.Value, query)
+			}
+			values[sample.Metric["instance"]] = v
+		}
+		return values, nil
+	}
+	return nil, errors.Annotate(lastErr, "query Prometheus")
+}
+
+// storeUsages returns the usage of the TiKV stores, sorted by the instances.
+// The stores are those reporting the region count.
+func (p *promClient) storeUsages(ctx context.Context, window time.Duration) ([]StoreUsage, error) {
+	// the durations in the queries are in seconds, as the combined units are
+	// not accepted by the older Prometheus.
+	rangeWindow := fmt.Sprintf("%ds", int64(window.Seconds()))
+
+	regions, err := p.query(ctx, regionCountQuery)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if len(regions) == 0 {
+		return nil, errors.New("no TiKV store is found in the metrics")
+	}
+	cpuCores, err := p.query(ctx, cpuCoresQuery)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	cpuUsed, err := p.query(ctx, fmt.Sprintf(cpuUsedQuery, rangeWindow))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	writeBytes := make(map[string]float64, len(regions))
+	for _, query := range diskWriteQueries {
+		values, err := p.query(ctx, fmt.Sprintf(query, rangeWindow))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		for instance, v := range values {
+			writeBytes[instance] += v
+		}
+	}
+
+	stores := make([]StoreUsage, 0, len(regions))
+	for instance, count := range regions {
+		cores, ok := cpuCores[instance]
+		if !ok || cores <= 0 {
+			return nil, errors.Errorf("the CPU cores of store %s are not found in the metrics", instance)
+		}
+		stores = append(stores, StoreUsage{
+			Instance:   instance,
+			CPUCores:   cores,
+			CPUUsed:    cpuUsed[instance],
+			WriteBytes: writeBytes[instance],
+			Regions:    int64(count),
+		})
+	}
+	sort.Slice(stores, func(i, j int) bool { return stores[i].Instance < stores[j].Instance })
+	return stores, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&impactSuite{})
+
+type impactSuite struct{}
+
+func (s *impactSuite) newConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.App.RegionConcurrency = 8
+	cfg.Simulate.MaxUtilization = 0.5
+	return cfg
+}
+
+func (s *impactSuite) stores() []StoreUsage {
+	return []StoreUsage{
+		{Instance: "tikv-1", CPUCores: 8, CPUUsed: 1, WriteBytes: 10 * mib, Regions: 1000},
+		{Instance: "tikv-2", CPUCores: 8, CPUUsed: 2, WriteBytes: 28 * mib, Regions: 2000},
+		{Instance: "tikv-3", CPUCores: 16, CPUUsed: 2, WriteBytes: 0, Regions: 1500},
+	}
+}
+
+func (s *impactSuite) TestSimulateImpact(c *C) {
+	cfg := s.newConfig()
+	report := simulateImpact(cfg, int64(9600*mib), 3, s.stores())
+	c.Assert(report.Issues, HasLen, 0)
+	c.Assert(report.CPUHeadroom, Equals, 2.0)
+	c.Assert(report.WriteHeadroom, Equals, 100*mib)
+	c.Assert(report.RegionHeadroom, Equals, int64(28000))
+	c.Assert(report.NewRegionsPerStore, Equals, int64(100))
+	// the disk of tikv-2 bounds the rate at 100 MiB/s / 2.
+	c.Assert(report.MaxImportRate, Equals, 50*mib)
+	c.Assert(report.RegionConcurrency, Equals, 4)
+	c.Assert(report.ReadBandwidth, Equals, int64(50*mib))
+	c.Assert(report.EstimatedDuration, Equals, 192*time.Second)
+
+	// the transactions of the TiDB backend cost more CPU, which bounds the
+	// rate at 2 cores / 0.1 instead of the disk.
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	report = simulateImpact(cfg, int64(9600*mib), 3, s.stores())
+	c.Assert(report.Issues, HasLen, 0)
+	c.Assert(report.MaxImportRate < 25*mib, IsTrue)
+	c.Assert(report.RegionConcurrency, Equals, 2)
+
+	// the region concurrency bounds the rate below the headroom.
+	cfg.TikvImporter.Backend = config.BackendImporter
+	cfg.App.RegionConcurrency = 2
+	report = simulateImpact(cfg, int64(9600*mib), 3, s.stores())
+	c.Assert(report.RegionConcurrency, Equals, 2)
+	c.Assert(report.ReadBandwidth, Equals, int64(0))
+	c.Assert(report.EstimatedDuration, Equals, 300*time.Second)
+}
+
+func (s *impactSuite) TestSimulateImpactBeyondHeadroom(c *C) {
+	stores := s.stores()
+	stores[0].CPUUsed = 5
+	stores[2].Regions = 29950
+	report := simulateImpact(s.newConfig(), int64(9600*mib), 3, stores)
+	c.Assert(report.Issues, DeepEquals, []string{
+		"the CPU of store tikv-1 is already used beyond 50% at the peak",
+		"the import adds about 100 regions to each store, beyond the headroom of 50 regions",
+	})
+	c.Assert(report.RegionConcurrency, Equals, 0)
+}
+
+type fakePrometheus struct {
+	c       *C
+	samples map[string]map[string]float64
+}
+
+func (p *fakePrometheus) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.c.Assert(req.URL.Path, Equals, "/api/v1/query")
+	query := req.URL.Query().Get("query")
+	var result []map[string]interface{}
+	for metric, values := range p.samples {
+		if !strings.Contains(query, metric) {
+			continue
+		}
+		if strings.HasPrefix(query, "max_over_time") {
+			p.c.Assert(query, Matches, `.*\[86400s:1m\]\)$`)
+		}
+		for instance, v := range values {
+			result = append(result, map[string]interface{}{
+				"metric": map[string]string{"instance": instance},
+				"value":  []interface{}{1600000000.0, strconv.FormatFloat(v, 'f', -1, 64)},
+			})
+		}
+	}
+	resp := map[string]interface{}{
+		"status": "success",
+		"data":   map[string]interface{}{"resultType": "vector", "result": result},
+	}
+	p.c.Assert(json.NewEncoder(w).Encode(resp), IsNil)
+}
+
+func (s *impactSuite) TestSimulateImpactFromPrometheus(c *C) {
+	prom := httptest.NewServer(&fakePrometheus{c: c, samples: map[string]map[string]float64{
+		"tikv_raftstore_region_count":       {"tikv-2": 2000, "tikv-1": 1000},
+		"tikv_server_cpu_cores_quota":       {"tikv-1": 8, "tikv-2": 8},
+		"tikv_thread_cpu_seconds_total":     {"tikv-1": 1, "tikv-2": 2},
+		"wal_file_bytes":                    {"tikv-1": 4 * mib, "tikv-2": 10 * mib},
+		"tikv_engine_compaction_flow_bytes": {"tikv-1": 6 * mib, "tikv-2": 18 * mib},
+	}})
+	defer prom.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	pd := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Assert(req.URL.Path, Equals, "/pd/api/v1/config/replicate")
+		c.Assert(json.NewEncoder(w).Encode(map[string]interface{}{"max-replicas": 2}), IsNil)
+	}))
+	defer pd.Close()
+	u, err := url.Parse(pd.URL)
+	c.Assert(err, IsNil)
+
+	cfg := s.newConfig()
+	cfg.TiDB.PdAddr = u.Host
+	// the unavailable server is skipped.
+	cfg.Simulate.PrometheusAddrs = []string{down.URL, prom.URL + "/"}
+	dbMetas := []*mydump.MDDatabaseMeta{{Name: "db", Tables: []*mydump.MDTableMeta{
+		{DB: "db", Name: "t1", TotalSize: int64(4000 * mib)},
+		{DB: "db", Name: "t2", TotalSize: int64(5600 * mib)},
+	}}}
+
+	report, err := SimulateImpact(context.Background(), cfg, dbMetas, common.NewTLSFromMockServer(pd))
+	c.Assert(err, IsNil)
+	c.Assert(report.Stores, DeepEquals, []StoreUsage{
+		{Instance: "tikv-1", CPUCores: 8, CPUUsed: 1, WriteBytes: 10 * mib, Regions: 1000},
+		{Instance: "tikv-2", CPUCores: 8, CPUUsed: 2, WriteBytes: 28 * mib, Regions: 2000},
+	})
+	c.Assert(report.Replicas, Equals, 2)
+	c.Assert(report.SourceSize, Equals, int64(9600*mib))
+	c.Assert(report.MaxImportRate, Equals, 50*mib)
+	c.Assert(report.RegionConcurrency, Equals, 4)
+
+	// the stores beyond the headroom fail the simulation.
+	cfg.Simulate.MaxUtilization = 0.1
+	_, err = SimulateImpact(context.Background(), cfg, dbMetas, common.NewTLSFromMockServer(pd))
+	c.Assert(err, ErrorMatches, "the import does not fit in the headroom of the target cluster: the CPU of store tikv-1 .*")
+
+	cfg.Simulate.PrometheusAddrs = []string{down.URL}
+	_, err = SimulateImpact(context.Background(), cfg, dbMetas, common.NewTLSFromMockServer(pd))
+	c.Assert(err, ErrorMatches, "query Prometheus: .*http status code != 200.*")
+}
@@ -0,0 +1,244 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+type tableKVUsage struct {
+	// sourceBytes is the size of the source data delivered.
+	sourceBytes int64
+	// kvBytes is the size of the KV pairs encoded from the delivered data.
+	kvBytes uint64
+	// pendingBytes is the size of the source data not yet delivered in the
+	// opened engines.
+	pendingBytes int64
+}
+
+// ratio returns the size of the KV pairs encoded from each byte of the source
+// data, or 0 if nothing is delivered yet.
+func (u *tableKVUsage) ratio() float64 {
+	if u.sourceBytes <= 0 {
+		return 0
+	}
+	return float64(u.kvBytes) / float64(u.sourceBytes)
+}
+
+// kvUsage accounts the size of the KV pairs encoded from the source data of
+// every table. With the local backend, the KV pairs of the opened engines are
+// kept in `sorted-kv-dir` until imported, so the space needed to complete the
+// opened engines is forecast from the ratio, to warn before the disk is full.
+type kvUsage struct {
+	logger log.Logger
+	// sortedKVDir is empty if the KV pairs are not stored locally.
+	sortedKVDir string
+	freeSpace   func(path string) (uint64, error)
+
+	mu     sync.Mutex
+	tables map[string]*tableKVUsage
+	// exhausted is whether the space is forecast to be insufficient in the
+	// last check, to only warn once until the forecast recovers.
+	exhausted bool
+}
+
+func newKVUsage(logger log.Logger, cfg *config.Config) *kvUsage {
+	u := &kvUsage{
+		logger:    logger,
+		freeSpace: kv.DiskFreeSpace,
+		tables:    make(map[string]*tableKVUsage),
+	}
+	if cfg.TikvImporter.Backend == config.BackendLocal {
+		u.sortedKVDir = cfg.TikvImporter.SortedKVDir
+	}
+	return u
+}
+
+func (u *kvUsage) get(tableName string) *tableKVUsage {
+	tu, ok := u.tables[tableName]
+	if !ok {
+		tu = &tableKVUsage{}
+		u.tables[tableName] = tu
+	}
+	return tu
+}
+
+// hasByteOffsets returns whether the offsets of the chunk are in bytes,
+// rather than in rows.
+func hasByteOffsets(chunk *checkpoints.ChunkCheckpoint) bool {
+	// the end offsets of the compressed files are unknown.
+	if chunk.FileMeta.Compression != mydump.CompressionNone {
+		return false
+	}
+	switch chunk.FileMeta.Type {
+	case mydump.SourceTypeParquet, mydump.SourceTypeAvro, mydump.SourceTypeORC, mydump.SourceTypeXLSX,
+		mydump.SourceTypeArrow:
+		return false
+	default:
+		return true
+	}
+}
+
+func remainingBytes(cp *checkpoints.EngineCheckpoint) int64 {
+	var remaining int64
+	for _, chunk := range cp.Chunks {
+		if hasByteOffsets(chunk) && chunk.Chunk.EndOffset > chunk.Chunk.Offset {
+			remaining += chunk.Chunk.EndOffset - chunk.Chunk.Offset
+		}
+	}
+	return remaining
+}
+
+// openEngine adds the remaining data of the engine to be delivered.
+func (u *kvUsage) openEngine(tableName string, cp *checkpoints.EngineCheckpoint) {
+	if u == nil {
+		return
+	}
+	remaining := remainingBytes(cp)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.get(tableName).pendingBytes += remaining
+}
+
+// closeEngine removes the data of the engine which is never delivered, e.g.
+// after the engine failed.
+func (u *kvUsage) closeEngine(tableName string, cp *checkpoints.EngineCheckpoint) {
+	if u == nil {
+		return
+	}
+	remaining := remainingBytes(cp)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.get(tableName).pendingBytes -= remaining
+}
+
+// deliver records the KV pairs encoded from the source data of the chunk.
+func (u *kvUsage) deliver(tableName string, chunk *checkpoints.ChunkCheckpoint, sourceBytes int64, kvBytes uint64) {
+	if u == nil || !hasByteOffsets(chunk) {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	tu := u.get(tableName)
+	tu.sourceBytes += sourceBytes
+	tu.kvBytes += kvBytes
+	tu.pendingBytes -= sourceBytes
+}
+
+// forecast returns the size of the KV pairs to be written to complete the
+// opened engines. The tables without any data delivered yet are estimated
+// with the overall ratio.
+func (u *kvUsage) forecast() uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var total tableKVUsage
+	for _, tu := range u.tables {
+		total.sourceBytes += tu.sourceBytes
+		total.kvBytes += tu.kvBytes
+	}
+	var needed float64
+	for _, tu := range u.tables {
+		if tu.pendingBytes <= 0 {
+			continue
+		}
+		ratio := tu.ratio()
+		if ratio == 0 {
+			ratio = total.ratio()
+		}
+		needed += float64(tu.pendingBytes) * ratio
+	}
+	return uint64(needed)
+}
+
+// checkSortedKVDir warns if the free space of `sorted-kv-dir` is forecast to
+// run out before the opened engines are completed.
+func (u *kvUsage) checkSortedKVDir() {
+	if u == nil || len(u.sortedKVDir) == 0 {
+		return
+	}
+	free, err := u.freeSpace(u.sortedKVDir)
+	if err != nil {
+		u.logger.Debug("cannot get the free space of sorted-kv-dir", log.ShortError(err))
+		return
+	}
+	needed := u.forecast()
+	fields := []zap.Field{
+		zap.String("sortedKVDir", u.sortedKVDir),
+		zap.Uint64("free", free),
+		zap.Uint64("needed", needed),
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if needed <= free {
+		if u.exhausted {
+			u.logger.Info("sorted-kv-dir is forecast to have enough space", fields...)
+		}
+		u.exhausted = false
+		return
+	}
+	if u.exhausted {
+		return
+	}
+	u.exhausted = true
+	u.logger.Warn("sorted-kv-dir is forecast to run out of space before the opened engines are completed, "+
+		"please reduce `lightning.table-concurrency` or `mydumper.batch-size` to open fewer or smaller engines at once, "+
+		"or free up the disk", fields...)
+	common.RecordWarning("", common.WarningSortedKVDirSpace, fmt.Sprintf(
+		"sorted-kv-dir %s was forecast to run out of space, with %d bytes needed and %d bytes free",
+		u.sortedKVDir, needed, free,
+	))
+}
+
+// emitLog logs the ratio of the KV size to the source size of every table.
+func (u *kvUsage) emitLog() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	tableNames := make([]string, 0, len(u.tables))
+	for tableName, tu := range u.tables {
+		if tu.sourceBytes > 0 {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	if len(tableNames) == 0 {
+		return
+	}
+	sort.Strings(tableNames)
+	u.logger.Info("size of encoded KV pairs", zap.Int("count", len(tableNames)))
+	for _, tableName := range tableNames {
+		tu := u.tables[tableName]
+		u.logger.Info("-",
+			zap.String("table", tableName),
+			zap.Int64("sourceBytes", tu.sourceBytes),
+			zap.Uint64("kvBytes", tu.kvBytes),
+			zap.Float64("ratio", tu.ratio()),
+		)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&kvUsageSuite{})
+
+type kvUsageSuite struct{}
+
+func newTestChunk(typ mydump.SourceType, offset, endOffset int64) *checkpoints.ChunkCheckpoint {
+	return &checkpoints.ChunkCheckpoint{
+		FileMeta: mydump.SourceFileMeta{Type: typ},
+		Chunk:    mydump.Chunk{Offset: offset, EndOffset: endOffset},
+	}
+}
+
+func (s *kvUsageSuite) TestNil(c *C) {
+	var u *kvUsage
+	u.openEngine("`db`.`t`", &checkpoints.EngineCheckpoint{})
+	u.deliver("`db`.`t`", newTestChunk(mydump.SourceTypeSQL, 0, 1), 1, 1)
+	u.closeEngine("`db`.`t`", &checkpoints.EngineCheckpoint{})
+	u.checkSortedKVDir()
+	u.emitLog()
+}
+
+func (s *kvUsageSuite) TestForecastSortedKVDir(c *C) {
+	logger, buffer := log.MakeTestLogger()
+	cfg := config.NewConfig()
+	cfg.TikvImporter.Backend = config.BackendLocal
+	cfg.TikvImporter.SortedKVDir = "/tmp/sorted-kv"
+	u := newKVUsage(logger, cfg)
+	var free uint64
+	u.freeSpace = func(path string) (uint64, error) {
+		c.Assert(path, Equals, "/tmp/sorted-kv")
+		return free, nil
+	}
+
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+
+	t1Chunk := newTestChunk(mydump.SourceTypeSQL, 0, 1000)
+	t1Parquet := newTestChunk(mydump.SourceTypeParquet, 0, 50)
+	t1Engine := &checkpoints.EngineCheckpoint{Chunks: []*checkpoints.ChunkCheckpoint{
+		t1Chunk,
+		t1Parquet,
+		newTestChunk(mydump.SourceTypeSQL, 1000, 1000),
+	}}
+	t2Engine := &checkpoints.EngineCheckpoint{Chunks: []*checkpoints.ChunkCheckpoint{
+		newTestChunk(mydump.SourceTypeCSV, 0, 2000),
+	}}
+	u.openEngine("`db`.`t1`", t1Engine)
+	u.openEngine("`db`.`t2`", t2Engine)
+	c.Assert(u.forecast(), Equals, uint64(0))
+
+	// the rows of parquet files are not counted.
+	u.deliver("`db`.`t1`", t1Chunk, 400, 1200)
+	t1Chunk.Chunk.Offset = 400
+	u.deliver("`db`.`t1`", t1Parquet, 10, 5000)
+	c.Assert(u.tables["`db`.`t1`"].ratio(), Equals, 3.0)
+	// t2 is estimated with the ratio of t1.
+	c.Assert(u.forecast(), Equals, uint64(600*3+2000*3))
+
+	free = 10000
+	u.checkSortedKVDir()
+	c.Assert(buffer.Stripped(), Equals, "")
+
+	// only warns once until the forecast recovers.
+	free = 5000
+	u.checkSortedKVDir()
+	u.checkSortedKVDir()
+	lines := buffer.Lines()
+	c.Assert(lines, HasLen, 1)
+	c.Assert(lines[0], Matches, `\{"\$lvl":"WARN","\$msg":"sorted-kv-dir is forecast to run out of space .*","sortedKVDir":"/tmp/sorted-kv","free":5000,"needed":7800\}`)
+	c.Assert(common.Warnings.Summary(), HasLen, 1)
+	c.Assert(common.Warnings.Summary()[0].Kind, Equals, common.WarningSortedKVDirSpace)
+
+	buffer.Reset()
+	u.closeEngine("`db`.`t2`", t2Engine)
+	c.Assert(u.forecast(), Equals, uint64(1800))
+	u.checkSortedKVDir()
+	c.Assert(buffer.Lines(), DeepEquals, []string{
+		`{"$lvl":"INFO","$msg":"sorted-kv-dir is forecast to have enough space","sortedKVDir":"/tmp/sorted-kv","free":5000,"needed":1800}`,
+	})
+
+	buffer.Reset()
+	u.emitLog()
+	c.Assert(buffer.Lines(), DeepEquals, []string{
+		`{"$lvl":"INFO","$msg":"size of encoded KV pairs","count":1}`,
+		`{"$lvl":"INFO","$msg":"-","table":"` + "`db`.`t1`" + `","sourceBytes":400,"kvBytes":1200,"ratio":3}`,
+	})
+}
+
+func (s *kvUsageSuite) TestNotLocalBackend(c *C) {
+	cfg := config.NewConfig()
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	u := newKVUsage(log.L(), cfg)
+	u.freeSpace = func(path string) (uint64, error) {
+		c.Fatal("the free space should not be queried")
+		return 0, nil
+	}
+	u.checkSortedKVDir()
+}
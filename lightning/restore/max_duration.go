@@ -0,0 +1,117 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// ErrImportPartial is returned when the task is stopped by
+// `lightning.max-duration` before all tables are imported. The checkpoints
+// are kept, so the import is resumed by running again.
+var ErrImportPartial = errors.New("the import is stopped by `lightning.max-duration` and is partial, run again to resume")
+
+// windDown stops starting new tables and engines after `lightning.max-duration`
+// since the task started. The engines already started still restore all their
+// chunks and are imported, since the checkpoint of an engine is closed only
+// after all its chunks are written.
+type windDown struct {
+	logger   log.Logger
+	deadline time.Time
+	now      func() time.Time
+
+	mu      sync.Mutex
+	reached bool
+	// skipped is the tables with any engine not started.
+	skipped []string
+}
+
+// newWindDown returns nil if the duration is not limited.
+func newWindDown(logger log.Logger, cfg *config.Config) *windDown {
+	if cfg.App.MaxDuration.Duration <= 0 {
+		return nil
+	}
+	return &windDown{
+		logger:   logger,
+		deadline: time.Now().Add(cfg.App.MaxDuration.Duration),
+		now:      time.Now,
+	}
+}
+
+// shouldStop returns whether the deadline is reached, so nothing new should be
+// started.
+func (w *windDown) shouldStop() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.reached {
+		return true
+	}
+	if w.now().Before(w.deadline) {
+		return false
+	}
+	w.reached = true
+	w.logger.Warn("lightning.max-duration is reached, no more tables or engines will be started, "+
+		"going to exit after the running engines are imported", zap.Time("deadline", w.deadline))
+	return true
+}
+
+// skip records that the table is not completely restored because of the
+// deadline.
+func (w *windDown) skip(tableName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, name := range w.skipped {
+		if name == tableName {
+			return
+		}
+	}
+	w.skipped = append(w.skipped, tableName)
+}
+
+// partial returns whether any table is skipped because of the deadline.
+func (w *windDown) partial() bool {
+	if w == nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.skipped) > 0
+}
+
+// emitLog logs the tables to be resumed in the next run.
+func (w *windDown) emitLog() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.skipped) == 0 {
+		return
+	}
+	w.logger.Warn("the import is partial because of lightning.max-duration, run again with the same checkpoints to resume",
+		zap.Int("count", len(w.skipped)))
+	for _, tableName := range w.skipped {
+		w.logger.Info("-", zap.String("table", tableName))
+	}
+}
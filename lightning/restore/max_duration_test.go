@@ -0,0 +1,72 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"time"
+
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&windDownSuite{})
+
+type windDownSuite struct{}
+
+func (s *windDownSuite) TestUnlimited(c *C) {
+	cfg := config.NewConfig()
+	w := newWindDown(log.L(), cfg)
+	c.Assert(w, IsNil)
+	c.Assert(w.shouldStop(), IsFalse)
+	c.Assert(w.partial(), IsFalse)
+	w.emitLog()
+}
+
+func (s *windDownSuite) TestShouldStop(c *C) {
+	logger, buffer := log.MakeTestLogger()
+	cfg := config.NewConfig()
+	cfg.App.MaxDuration.Duration = time.Hour
+	w := newWindDown(logger, cfg)
+	c.Assert(w, NotNil)
+
+	now := w.deadline.Add(-time.Minute)
+	w.now = func() time.Time { return now }
+	c.Assert(w.shouldStop(), IsFalse)
+	c.Assert(buffer.Stripped(), Equals, "")
+
+	// warns only once after the deadline.
+	now = w.deadline
+	c.Assert(w.shouldStop(), IsTrue)
+	c.Assert(buffer.Lines(), HasLen, 1)
+	c.Assert(buffer.Lines()[0], Matches, `.*"\$msg":"lightning.max-duration is reached.*`)
+	buffer.Reset()
+	now = w.deadline.Add(time.Minute)
+	c.Assert(w.shouldStop(), IsTrue)
+	c.Assert(buffer.Stripped(), Equals, "")
+
+	c.Assert(w.partial(), IsFalse)
+	w.skip("`db`.`t2`")
+	w.skip("`db`.`t1`")
+	w.skip("`db`.`t2`")
+	c.Assert(w.partial(), IsTrue)
+
+	w.emitLog()
+	lines := buffer.Lines()
+	c.Assert(lines, HasLen, 3)
+	c.Assert(lines[0], Matches, `.*"\$msg":"the import is partial because of lightning.max-duration.*"count":2.*`)
+	c.Assert(lines[1], Matches, ".*\"table\":\"`db`.`t2`\".*")
+	c.Assert(lines[2], Matches, ".*\"table\":\"`db`.`t1`\".*")
+}
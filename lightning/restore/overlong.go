@@ -0,0 +1,192 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// lengthField is a string column checked by the overlongValueGuard.
+type lengthField struct {
+	field   int
+	column  string
+	charset string
+	// maxLen is the length of the column, in characters for CHAR and VARCHAR
+	// columns, or in bytes for BINARY, VARBINARY, BLOB and TEXT columns.
+	maxLen  int64
+	inBytes bool
+}
+
+// columnMaxLength returns the max length of the values of a string column,
+// and whether the length is in bytes. LONGBLOB and LONGTEXT columns are not
+// limited in practice and are not checked.
+func columnMaxLength(col *model.ColumnInfo) (maxLen int64, inBytes bool, ok bool) {
+	switch col.Tp {
+	case mysql.TypeString, mysql.TypeVarchar, mysql.TypeVarString:
+		if col.Flen < 0 {
+			return 0, false, false
+		}
+		return int64(col.Flen), col.Charset == charset.CharsetBin, true
+	case mysql.TypeTinyBlob:
+		return 255, true, true
+	case mysql.TypeBlob:
+		return 65535, true, true
+	case mysql.TypeMediumBlob:
+		return 16777215, true, true
+	default:
+		return 0, false, false
+	}
+}
+
+// measure returns the length of the longest prefix of the value which fits
+// the column, and the reason if the whole value does not fit. Besides the
+// length, the characters invalid in the charset of the column do not fit,
+// e.g. the 4-byte emojis in a utf8 (utf8mb3) column.
+func (f *lengthField) measure(data []byte) (int, string) {
+	if f.charset == charset.CharsetBin {
+		if int64(len(data)) > f.maxLen {
+			return int(f.maxLen), fmt.Sprintf("value of %d bytes exceeds the length of column `%s` (%d bytes)", len(data), f.column, f.maxLen)
+		}
+		return len(data), ""
+	}
+
+	chars := int64(0)
+	for pos := 0; pos < len(data); {
+		r, size := utf8.DecodeRune(data[pos:])
+		invalid := false
+		switch f.charset {
+		case charset.CharsetUTF8MB4:
+			invalid = r == utf8.RuneError && size == 1
+		case charset.CharsetUTF8:
+			invalid = (r == utf8.RuneError && size == 1) || r > 0xFFFF
+		}
+		if invalid {
+			return pos, fmt.Sprintf("invalid %s character at byte %d in column `%s`", f.charset, pos, f.column)
+		}
+		if f.inBytes && int64(pos+size) > f.maxLen {
+			return pos, fmt.Sprintf("value of %d bytes exceeds the length of column `%s` (%d bytes)", len(data), f.column, f.maxLen)
+		}
+		if !f.inBytes && chars >= f.maxLen {
+			return pos, fmt.Sprintf("value of %d characters exceeds the length of column `%s` (%d characters)", utf8.RuneCount(data), f.column, f.maxLen)
+		}
+		chars++
+		pos += size
+	}
+	return len(data), ""
+}
+
+// overlongValueGuard checks the strings against the lengths and the charsets
+// of the target columns at encode time, and handles the values which do not
+// fit according to `mydumper.on-overlong-value`. Without the guard, these
+// values either fail the encoder in the strict SQL mode, or are truncated
+// silently otherwise.
+type overlongValueGuard struct {
+	action    string
+	tableName string
+	chunkKey  *checkpoints.ChunkCheckpointKey
+	fields    []lengthField
+}
+
+// newOverlongValueGuard creates the guard for a chunk. Returns nil if the
+// values are not checked, or the chunk has no string columns.
+func newOverlongValueGuard(cfg *config.Config, t *TableRestore, chunk *checkpoints.ChunkCheckpoint) *overlongValueGuard {
+	if cfg.Mydumper.OnOverlongValue == "" || cfg.Mydumper.OnOverlongValue == config.OverlongValueNone {
+		return nil
+	}
+
+	g := &overlongValueGuard{
+		action:    cfg.Mydumper.OnOverlongValue,
+		tableName: t.tableName,
+		chunkKey:  &chunk.Key,
+	}
+	for i, col := range t.tableInfo.Core.Columns {
+		if i >= len(chunk.ColumnPermutation) || chunk.ColumnPermutation[i] < 0 {
+			continue
+		}
+		maxLen, inBytes, ok := columnMaxLength(col)
+		if !ok {
+			continue
+		}
+		g.fields = append(g.fields, lengthField{
+			field:   chunk.ColumnPermutation[i],
+			column:  col.Name.O,
+			charset: col.Charset,
+			maxLen:  maxLen,
+			inBytes: inBytes,
+		})
+	}
+	if len(g.fields) == 0 {
+		return nil
+	}
+	return g
+}
+
+// check inspects the row read at the given offset, and returns whether the
+// row should be skipped. Truncation is done on the row in place.
+func (g *overlongValueGuard) check(row []types.Datum, offset int64) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+	for _, f := range g.fields {
+		if f.field >= len(row) {
+			continue
+		}
+		d := &row[f.field]
+		if kind := d.Kind(); kind != types.KindString && kind != types.KindBytes {
+			continue
+		}
+		data := d.GetBytes()
+		keep, reason := f.measure(data)
+		if len(reason) == 0 {
+			continue
+		}
+
+		switch g.action {
+		case config.OverlongValueSkip:
+			g.report(offset, reason, "skipped")
+			return true, nil
+		case config.OverlongValueTruncate:
+			d.SetBytes(data[:keep])
+			g.report(offset, reason, "truncated")
+		default:
+			return false, errors.New(reason)
+		}
+	}
+	return false, nil
+}
+
+func (g *overlongValueGuard) report(offset int64, reason string, action string) {
+	log.L().Warn("overlong value",
+		zap.String("table", g.tableName),
+		zap.Stringer("path", g.chunkKey),
+		zap.Int64("offset", offset),
+		zap.String("reason", reason),
+		zap.String("action", action),
+	)
+	common.RecordWarning(g.tableName, common.WarningOverlongValue,
+		fmt.Sprintf("row in '%s' at offset %d %s: %s", g.chunkKey.Path, offset, action, reason))
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&overlongSuite{})
+
+type overlongSuite struct{}
+
+func (s *overlongSuite) newGuard(c *C, action string) *overlongValueGuard {
+	newColumn := func(name string, tp byte, flen int, cs string) *model.ColumnInfo {
+		col := &model.ColumnInfo{Name: model.NewCIStr(name)}
+		col.Tp = tp
+		col.Flen = flen
+		col.Charset = cs
+		return col
+	}
+	tr := &TableRestore{
+		tableName: "`db`.`t`",
+		dbInfo:    &TidbDBInfo{Name: "db"},
+		tableInfo: &TidbTableInfo{
+			Name: "t",
+			Core: &model.TableInfo{
+				Columns: []*model.ColumnInfo{
+					newColumn("id", mysql.TypeLong, 11, charset.CharsetBin),
+					newColumn("name", mysql.TypeVarchar, 4, charset.CharsetUTF8MB4),
+					newColumn("code", mysql.TypeVarchar, 4, charset.CharsetUTF8),
+					newColumn("hash", mysql.TypeString, 2, charset.CharsetBin),
+					newColumn("note", mysql.TypeTinyBlob, -1, charset.CharsetUTF8MB4),
+				},
+			},
+		},
+	}
+	chunk := &ChunkCheckpoint{
+		Key:               ChunkCheckpointKey{Path: "/data/db.t.1.csv", Offset: 0},
+		ColumnPermutation: []int{0, 1, 2, 3, 4, -1},
+	}
+	cfg := config.NewConfig()
+	cfg.Mydumper.OnOverlongValue = action
+	guard := newOverlongValueGuard(cfg, tr, chunk)
+	c.Assert(guard, NotNil)
+	c.Assert(guard.fields, HasLen, 4)
+	return guard
+}
+
+func (s *overlongSuite) newRow(name, code, hash, note string) []types.Datum {
+	return []types.Datum{
+		types.NewIntDatum(1),
+		types.NewStringDatum(name),
+		types.NewStringDatum(code),
+		types.NewBytesDatum([]byte(hash)),
+		types.NewStringDatum(note),
+	}
+}
+
+func (s *overlongSuite) TestMeasure(c *C) {
+	varchar := lengthField{column: "c", charset: charset.CharsetUTF8MB4, maxLen: 4}
+	keep, reason := varchar.measure([]byte("好好好好"))
+	c.Assert(keep, Equals, 12)
+	c.Assert(reason, Equals, "")
+	keep, reason = varchar.measure([]byte("a😀😀😀😀"))
+	c.Assert(keep, Equals, 13)
+	c.Assert(reason, Equals, "value of 5 characters exceeds the length of column `c` (4 characters)")
+	keep, reason = varchar.measure([]byte("ab\xffc"))
+	c.Assert(keep, Equals, 2)
+	c.Assert(reason, Equals, "invalid utf8mb4 character at byte 2 in column `c`")
+
+	utf8mb3 := lengthField{column: "c", charset: charset.CharsetUTF8, maxLen: 4}
+	keep, reason = utf8mb3.measure([]byte("a😀"))
+	c.Assert(keep, Equals, 1)
+	c.Assert(reason, Equals, "invalid utf8 character at byte 1 in column `c`")
+
+	text := lengthField{column: "c", charset: charset.CharsetUTF8MB4, maxLen: 5, inBytes: true}
+	keep, reason = text.measure([]byte("a好好"))
+	c.Assert(keep, Equals, 4)
+	c.Assert(reason, Equals, "value of 7 bytes exceeds the length of column `c` (5 bytes)")
+
+	binary := lengthField{column: "c", charset: charset.CharsetBin, maxLen: 2, inBytes: true}
+	keep, reason = binary.measure([]byte("好"))
+	c.Assert(keep, Equals, 2)
+	c.Assert(reason, Equals, "value of 3 bytes exceeds the length of column `c` (2 bytes)")
+}
+
+func (s *overlongSuite) TestOverlongValueGuard(c *C) {
+	cfg := config.NewConfig()
+	c.Assert(newOverlongValueGuard(cfg, nil, nil), IsNil)
+	cfg.Mydumper.OnOverlongValue = config.OverlongValueNone
+	c.Assert(newOverlongValueGuard(cfg, nil, nil), IsNil)
+
+	guard := s.newGuard(c, config.OverlongValueError)
+	skip, err := guard.check(s.newRow("abcd", "好好好好", "ab", strings.Repeat("x", 255)), 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsFalse)
+	_, err = guard.check(s.newRow("abcd", "😀", "ab", ""), 10)
+	c.Assert(err, ErrorMatches, "invalid utf8 character at byte 0 in column `code`")
+	_, err = guard.check(s.newRow("abcd", "", "ab", strings.Repeat("x", 256)), 10)
+	c.Assert(err, ErrorMatches, "value of 256 bytes exceeds the length of column `note` \\(255 bytes\\)")
+
+	guard = s.newGuard(c, config.OverlongValueSkip)
+	skip, err = guard.check(s.newRow("abcde", "", "", ""), 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsTrue)
+}
+
+func (s *overlongSuite) TestTruncateOverlongValue(c *C) {
+	guard := s.newGuard(c, config.OverlongValueTruncate)
+	row := s.newRow("😀😀😀😀😀", "ab😀c", "abc", "")
+	row = append(row, types.NewStringDatum("not in the table"))
+	skip, err := guard.check(row, 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsFalse)
+	c.Assert(row[1].GetString(), Equals, "😀😀😀😀")
+	c.Assert(row[2].GetString(), Equals, "ab")
+	c.Assert(row[3].GetBytes(), DeepEquals, []byte("ab"))
+	c.Assert(row[5].GetString(), Equals, "not in the table")
+}
@@ -0,0 +1,277 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/tidb/types"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+type truncateField struct {
+	field  int
+	binary bool
+}
+
+// oversizedRowGuard detects the rows exceeding `mydumper.max-row-size` at
+// encode time, before they fail the write to TiKV, and handles them according
+// to `mydumper.on-oversized-row`.
+//
+// The size of a row is estimated by the total size of its field values.
+type oversizedRowGuard struct {
+	maxSize   int64
+	action    string
+	tableName string
+	chunkKey  *checkpoints.ChunkCheckpointKey
+
+	// positions of the truncatable columns in the data file.
+	truncateFields []truncateField
+
+	routePath string
+	routeFile *os.File
+	// resumeOffset is the offset of the chunk checkpoint. The rows up to it
+	// were routed by the previous runs, and those after it are routed again.
+	resumeOffset int64
+}
+
+// newOversizedRowGuard creates the guard for a chunk. Returns nil if the row
+// size is not limited.
+func newOversizedRowGuard(cfg *config.Config, t *TableRestore, chunk *checkpoints.ChunkCheckpoint) *oversizedRowGuard {
+	if cfg.Mydumper.MaxRowSize <= 0 {
+		return nil
+	}
+
+	g := &oversizedRowGuard{
+		maxSize:   cfg.Mydumper.MaxRowSize,
+		action:    cfg.Mydumper.OnOversizedRow,
+		tableName: t.tableName,
+		chunkKey:  &chunk.Key,
+	}
+	switch g.action {
+	case config.OversizedRowTruncate:
+		for i, col := range t.tableInfo.Core.Columns {
+			if i >= len(chunk.ColumnPermutation) || chunk.ColumnPermutation[i] < 0 {
+				continue
+			}
+			for _, pattern := range cfg.Mydumper.TruncateColumns {
+				if config.MatchColumnPattern(pattern, t.dbInfo.Name, t.tableInfo.Name, col.Name.O) {
+					g.truncateFields = append(g.truncateFields, truncateField{
+						field:  chunk.ColumnPermutation[i],
+						binary: col.Charset == charset.CharsetBin,
+					})
+					break
+				}
+			}
+		}
+	case config.OversizedRowRoute:
+		fileName := fmt.Sprintf("%s.%s.%s.%d.json", t.dbInfo.Name, t.tableInfo.Name, filepath.Base(chunk.Key.Path), chunk.Key.Offset)
+		g.routePath = filepath.Join(cfg.Mydumper.OversizedRowDir, fileName)
+		g.resumeOffset = chunk.Chunk.Offset
+	}
+	return g
+}
+
+func rowSize(row []types.Datum) int64 {
+	var size int64
+	for i := range row {
+		switch row[i].Kind() {
+		case types.KindNull:
+		case types.KindString, types.KindBytes:
+			size += int64(len(row[i].GetBytes()))
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// check inspects the row read at the given offset, and returns whether the
+// row should be skipped. Truncation is done on the row in place.
+func (g *oversizedRowGuard) check(row []types.Datum, offset int64) (bool, error) {
+	if g == nil {
+		return false, nil
+	}
+	size := rowSize(row)
+	if size <= g.maxSize {
+		return false, nil
+	}
+
+	switch g.action {
+	case config.OversizedRowSkip:
+		g.report(size, offset, "skipped")
+		return true, nil
+	case config.OversizedRowTruncate:
+		if g.truncate(row, size-g.maxSize) {
+			g.report(size, offset, "truncated")
+			return false, nil
+		}
+	case config.OversizedRowRoute:
+		if err := g.route(row, offset); err != nil {
+			return false, errors.Trace(err)
+		}
+		g.report(size, offset, fmt.Sprintf("routed to '%s'", g.routePath))
+		return true, nil
+	}
+	return false, errors.Errorf("row of %d bytes exceeds `mydumper.max-row-size` (%d bytes)", size, g.maxSize)
+}
+
+// truncate cuts the truncatable columns, longest first, until the excess size
+// is removed. Returns false if the row still does not fit.
+func (g *oversizedRowGuard) truncate(row []types.Datum, excess int64) bool {
+	fields := make([]truncateField, 0, len(g.truncateFields))
+	for _, f := range g.truncateFields {
+		if f.field >= len(row) {
+			continue
+		}
+		if kind := row[f.field].Kind(); kind == types.KindString || kind == types.KindBytes {
+			fields = append(fields, f)
+		}
+	}
+	sort.SliceStable(fields, func(i, j int) bool {
+		return len(row[fields[i].field].GetBytes()) > len(row[fields[j].field].GetBytes())
+	})
+
+	for _, f := range fields {
+		if excess <= 0 {
+			break
+		}
+		data := row[f.field].GetBytes()
+		keep := 0
+		if int64(len(data)) > excess {
+			keep = len(data) - int(excess)
+		}
+		// never split a multi-byte character of a text column.
+		for !f.binary && keep > 0 && !utf8.RuneStart(data[keep]) {
+			keep--
+		}
+		excess -= int64(len(data) - keep)
+		row[f.field].SetBytes(data[:keep])
+	}
+	return excess <= 0
+}
+
+type routedRow struct {
+	File   string    `json:"file"`
+	Offset int64     `json:"offset"`
+	Row    []*string `json:"row"`
+}
+
+// route appends the row as a JSON line to the sidecar file of the chunk.
+func (g *oversizedRowGuard) route(row []types.Datum, offset int64) error {
+	if g.routeFile == nil {
+		f, err := openRouteFile(g.routePath, g.resumeOffset)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		g.routeFile = f
+	}
+
+	routed := routedRow{File: g.chunkKey.Path, Offset: offset, Row: make([]*string, 0, len(row))}
+	for i := range row {
+		if row[i].IsNull() {
+			routed.Row = append(routed.Row, nil)
+			continue
+		}
+		str, err := row[i].ToString()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		routed.Row = append(routed.Row, &str)
+	}
+	line, err := json.Marshal(&routed)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	_, err = g.routeFile.Write(append(line, '\n'))
+	return errors.Trace(err)
+}
+
+// openRouteFile opens the sidecar file of a chunk for appending. The rows
+// after the resume offset, which were routed by a previous run after its last
+// checkpoint and are read again in this run, are removed from the file, so
+// they are not routed twice.
+func openRouteFile(path string, resumeOffset int64) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var kept []byte
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		for len(data) > 0 {
+			line := data
+			if i := bytes.IndexByte(data, '\n'); i >= 0 {
+				line, data = data[:i+1], data[i+1:]
+			} else {
+				data = nil
+			}
+			var routed routedRow
+			// a broken line is left by an interrupted write, drop it.
+			if json.Unmarshal(line, &routed) == nil && line[len(line)-1] == '\n' && routed.Offset <= resumeOffset {
+				kept = append(kept, line...)
+			}
+		}
+	case !os.IsNotExist(err):
+		return nil, errors.Trace(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if _, err := f.Write(kept); err != nil {
+		f.Close()
+		return nil, errors.Trace(err)
+	}
+	return f, nil
+}
+
+func (g *oversizedRowGuard) report(size int64, offset int64, action string) {
+	log.L().Warn("oversized row",
+		zap.String("table", g.tableName),
+		zap.Stringer("path", g.chunkKey),
+		zap.Int64("offset", offset),
+		zap.Int64("size", size),
+		zap.String("action", action),
+	)
+	// the offsets and the sizes are only logged, so the warnings of a file
+	// are aggregated.
+	common.RecordWarning(g.tableName, common.WarningOversizedRow,
+		fmt.Sprintf("rows in '%s' exceeding `mydumper.max-row-size` (%d bytes) are %s", g.chunkKey.Path, g.maxSize, action))
+}
+
+func (g *oversizedRowGuard) close() error {
+	if g == nil || g.routeFile == nil {
+		return nil
+	}
+	err := g.routeFile.Close()
+	g.routeFile = nil
+	return errors.Trace(err)
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/types"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&oversizedSuite{})
+
+type oversizedSuite struct{}
+
+func (s *oversizedSuite) newGuard(c *C, cfg *config.Config) *oversizedRowGuard {
+	return s.newGuardAt(c, cfg, 0)
+}
+
+func (s *oversizedSuite) newGuardAt(c *C, cfg *config.Config, resumeOffset int64) *oversizedRowGuard {
+	tr := &TableRestore{
+		tableName: "`db`.`t`",
+		dbInfo:    &TidbDBInfo{Name: "db"},
+		tableInfo: &TidbTableInfo{
+			Name: "t",
+			Core: &model.TableInfo{
+				Columns: []*model.ColumnInfo{
+					{Name: model.NewCIStr("id")},
+					{Name: model.NewCIStr("body")},
+				},
+			},
+		},
+	}
+	chunk := &ChunkCheckpoint{
+		Key:               ChunkCheckpointKey{Path: "/data/db.t.1.csv", Offset: 0},
+		ColumnPermutation: []int{0, 1},
+	}
+	chunk.Chunk.Offset = resumeOffset
+	guard := newOversizedRowGuard(cfg, tr, chunk)
+	c.Assert(guard, NotNil)
+	return guard
+}
+
+func (s *oversizedSuite) newRow() []types.Datum {
+	return []types.Datum{types.NewIntDatum(1), types.NewStringDatum(strings.Repeat("好", 10))}
+}
+
+func (s *oversizedSuite) TestOversizedRowGuard(c *C) {
+	cfg := config.NewConfig()
+	c.Assert(newOversizedRowGuard(cfg, nil, nil), IsNil)
+
+	cfg.Mydumper.MaxRowSize = 20
+	cfg.Mydumper.OnOversizedRow = config.OversizedRowError
+	guard := s.newGuard(c, cfg)
+	skip, err := guard.check([]types.Datum{types.NewIntDatum(1), types.NewStringDatum("short")}, 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsFalse)
+	_, err = guard.check(s.newRow(), 10)
+	c.Assert(err, ErrorMatches, "row of 38 bytes exceeds `mydumper.max-row-size` \\(20 bytes\\)")
+
+	cfg.Mydumper.OnOversizedRow = config.OversizedRowSkip
+	skip, err = s.newGuard(c, cfg).check(s.newRow(), 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsTrue)
+}
+
+func (s *oversizedSuite) TestTruncateOversizedRow(c *C) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.MaxRowSize = 20
+	cfg.Mydumper.OnOversizedRow = config.OversizedRowTruncate
+	cfg.Mydumper.TruncateColumns = []string{"db.*.body"}
+
+	row := s.newRow()
+	skip, err := s.newGuard(c, cfg).check(row, 10)
+	c.Assert(err, IsNil)
+	c.Assert(skip, IsFalse)
+	// 8 bytes for the id, and 4 whole characters of 3 bytes.
+	c.Assert(row[1].GetString(), Equals, strings.Repeat("好", 4))
+
+	cfg.Mydumper.TruncateColumns = []string{"db.*.id"}
+	_, err = s.newGuard(c, cfg).check(s.newRow(), 10)
+	c.Assert(err, ErrorMatches, "row of 38 bytes exceeds.*")
+}
+
+func (s *oversizedSuite) TestRouteOversizedRow(c *C) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.MaxRowSize = 20
+	cfg.Mydumper.OnOversizedRow = config.OversizedRowRoute
+	cfg.Mydumper.OversizedRowDir = filepath.Join(c.MkDir(), "oversized")
+
+	guard := s.newGuard(c, cfg)
+	row := s.newRow()
+	row[0].SetNull()
+	for _, offset := range []int64{10, 20} {
+		skip, err := guard.check(row, offset)
+		c.Assert(err, IsNil)
+		c.Assert(skip, IsTrue)
+	}
+	c.Assert(guard.close(), IsNil)
+
+	content, err := ioutil.ReadFile(filepath.Join(cfg.Mydumper.OversizedRowDir, "db.t.db.t.1.csv.0.json"))
+	c.Assert(err, IsNil)
+	body := strings.Repeat("好", 10)
+	c.Assert(string(content), Equals,
+		`{"file":"/data/db.t.1.csv","offset":10,"row":[null,"`+body+`"]}`+"\n"+
+			`{"file":"/data/db.t.1.csv","offset":20,"row":[null,"`+body+`"]}`+"\n")
+}
+
+func (s *oversizedSuite) TestRouteOversizedRowResume(c *C) {
+	cfg := config.NewConfig()
+	cfg.Mydumper.MaxRowSize = 20
+	cfg.Mydumper.OnOversizedRow = config.OversizedRowRoute
+	cfg.Mydumper.OversizedRowDir = filepath.Join(c.MkDir(), "oversized")
+
+	route := func(guard *oversizedRowGuard, offsets ...int64) {
+		for _, offset := range offsets {
+			skip, err := guard.check(s.newRow(), offset)
+			c.Assert(err, IsNil)
+			c.Assert(skip, IsTrue)
+		}
+		c.Assert(guard.close(), IsNil)
+	}
+	// the previous run routed the rows at 10 and 20, but only saved the
+	// checkpoint at 10.
+	route(s.newGuard(c, cfg), 10, 20)
+	route(s.newGuardAt(c, cfg, 10), 20, 30)
+
+	content, err := ioutil.ReadFile(filepath.Join(cfg.Mydumper.OversizedRowDir, "db.t.db.t.1.csv.0.json"))
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	c.Assert(lines, HasLen, 3)
+	for i, offset := range []string{"10", "20", "30"} {
+		c.Assert(lines[i], Matches, `\{"file":"/data/db.t.1.csv","offset":`+offset+`,.*`)
+	}
+}
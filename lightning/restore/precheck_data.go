@@ -0,0 +1,389 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/pingcap/br/pkg/storage"
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/charset"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/types"
+	"github.com/pingcap/tidb/util/mock"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+// maxDataIssueSamples is the number of issues kept in the report of each data
+// file for the details.
+const maxDataIssueSamples = 5
+
+// DataFileReport is the result of parsing a data file in the precheck-data
+// mode.
+type DataFileReport struct {
+	Table string
+	Path  string
+	// Rows is the number of rows parsed.
+	Rows int64
+	// MalformedRows is the number of syntax errors. The parser cannot
+	// continue after a syntax error, so the rest of the region is skipped.
+	MalformedRows int64
+	// CharsetIssues is the number of rows with the strings not valid in the
+	// charset of the columns.
+	CharsetIssues int64
+	// ConversionErrors is the number of rows failed to be encoded, e.g. the
+	// values not convertible to the column types.
+	ConversionErrors int64
+	// Samples are the first issues found, with the offsets.
+	Samples []string
+}
+
+// HasIssues returns whether any issue is found in the data file.
+func (r *DataFileReport) HasIssues() bool {
+	return r.MalformedRows+r.CharsetIssues+r.ConversionErrors > 0
+}
+
+func (r *DataFileReport) addIssue(counter *int64, offset int64, err error) {
+	*counter++
+	if len(r.Samples) < maxDataIssueSamples {
+		r.Samples = append(r.Samples, fmt.Sprintf("offset %d: %s", offset, err.Error()))
+	}
+}
+
+func (r *DataFileReport) merge(other *DataFileReport) {
+	r.Rows += other.Rows
+	r.MalformedRows += other.MalformedRows
+	r.CharsetIssues += other.CharsetIssues
+	r.ConversionErrors += other.ConversionErrors
+	for _, sample := range other.Samples {
+		if len(r.Samples) >= maxDataIssueSamples {
+			break
+		}
+		r.Samples = append(r.Samples, sample)
+	}
+}
+
+// PrecheckData parses every data file of the source fully and encodes the
+// rows without writing anything into the target, to find the data quality
+// issues before the import. The schemas are read from the schema files, or
+// from the target if `mydumper.no-schema` is set. An error is returned if any
+// issue is found, and the details are logged per file.
+func PrecheckData(
+	ctx context.Context,
+	cfg *config.Config,
+	dbMetas []*mydump.MDDatabaseMeta,
+	store storage.ExternalStorage,
+) ([]*DataFileReport, error) {
+	var dbInfos map[string]*checkpoints.TidbDBInfo
+	var err error
+	if cfg.Mydumper.NoSchema {
+		dbInfos, err = loadRemoteSchemas(ctx, cfg, dbMetas)
+	} else {
+		dbInfos, err = loadSchemaFiles(ctx, cfg, dbMetas, store)
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rc := &RestoreController{
+		cfg:       cfg,
+		dbMetas:   dbMetas,
+		dbInfos:   dbInfos,
+		ioWorkers: worker.NewPool(ctx, cfg.App.IOConcurrency, "io"),
+		store:     store,
+	}
+
+	type chunkTask struct {
+		tr    *TableRestore
+		chunk *checkpoints.ChunkCheckpoint
+	}
+	var tasks []chunkTask
+	for _, dbMeta := range dbMetas {
+		dbInfo := dbInfos[dbMeta.Name]
+		for _, tableMeta := range dbMeta.Tables {
+			tableInfo, ok := dbInfo.Tables[tableMeta.Name]
+			if !ok {
+				return nil, errors.Errorf("table info %s.%s not found", dbMeta.Name, tableMeta.Name)
+			}
+			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+			cp := &checkpoints.TableCheckpoint{Engines: make(map[int32]*checkpoints.EngineCheckpoint)}
+			tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			if err := tr.populateChunks(ctx, rc, cp); err != nil {
+				return nil, errors.Trace(err)
+			}
+			for engineID := int32(0); engineID < int32(len(cp.Engines)); engineID++ {
+				engine, ok := cp.Engines[engineID]
+				if !ok {
+					continue
+				}
+				for _, chunk := range engine.Chunks {
+					tasks = append(tasks, chunkTask{tr: tr, chunk: chunk})
+				}
+			}
+		}
+	}
+
+	chunkReports := make([]*DataFileReport, len(tasks))
+	eg, egCtx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, cfg.App.RegionConcurrency)
+	for i, task := range tasks {
+		i, task := i, task
+		sem <- struct{}{}
+		eg.Go(func() error {
+			defer func() { <-sem }()
+			report, err := task.tr.precheckChunk(egCtx, rc, i, task.chunk)
+			chunkReports[i] = report
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var reports []*DataFileReport
+	fileReports := make(map[string]*DataFileReport)
+	for _, report := range chunkReports {
+		if fileReport, ok := fileReports[report.Path]; ok {
+			fileReport.merge(report)
+			continue
+		}
+		fileReports[report.Path] = report
+		reports = append(reports, report)
+	}
+
+	issueFiles := 0
+	for _, report := range reports {
+		fields := []zap.Field{
+			zap.String("table", report.Table),
+			zap.String("path", report.Path),
+			zap.Int64("rows", report.Rows),
+		}
+		if !report.HasIssues() {
+			log.L().Info("data file checked", fields...)
+			continue
+		}
+		issueFiles++
+		log.L().Warn("data file has issues", append(fields,
+			zap.Int64("malformedRows", report.MalformedRows),
+			zap.Int64("charsetIssues", report.CharsetIssues),
+			zap.Int64("conversionErrors", report.ConversionErrors),
+			zap.Strings("samples", report.Samples),
+		)...)
+	}
+	if issueFiles > 0 {
+		return reports, errors.Errorf("found issues in %d of %d data files, see the log for details", issueFiles, len(reports))
+	}
+	return reports, nil
+}
+
+// precheckChunk parses and encodes all rows of the chunk.
+func (t *TableRestore) precheckChunk(
+	ctx context.Context,
+	rc *RestoreController,
+	index int,
+	chunk *checkpoints.ChunkCheckpoint,
+) (*DataFileReport, error) {
+	report := &DataFileReport{Table: t.tableName, Path: chunk.Key.Path}
+	cr, err := newChunkRestore(ctx, index, rc.cfg, chunk, rc.ioWorkers, rc.store, t.tableName, t.tableInfo)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer cr.close()
+
+	kvEncoder := kv.NewTableKVEncoder(t.encTable, &kv.SessionOptions{
+		SQLMode:          rc.cfg.TableSQLMode(t.tableName),
+		Timestamp:        chunk.Timestamp,
+		RowFormatVersion: "1",
+	})
+	defer kvEncoder.Close()
+
+	logger := t.logger.With(zap.String("path", chunk.Key.Path))
+	cr.parser.SetLogger(logger)
+	var decoders map[int]mydump.ColumnDecoder
+	initializedColumns := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		offset, _ := cr.parser.Pos()
+		if offset >= chunk.Chunk.EndOffset {
+			return report, nil
+		}
+		err := cr.parser.ReadRow()
+		newOffset, _ := cr.parser.Pos()
+		switch errors.Cause(err) {
+		case nil:
+		case io.EOF:
+			return report, nil
+		default:
+			report.addIssue(&report.MalformedRows, newOffset, err)
+			return report, nil
+		}
+		report.Rows++
+
+		if !initializedColumns {
+			if len(chunk.ColumnPermutation) == 0 {
+				if err := t.initializeColumns(cr.parser.Columns(), chunk); err != nil {
+					report.addIssue(&report.MalformedRows, newOffset, err)
+					return report, nil
+				}
+			}
+			if decoders, err = t.columnDecoders(rc.cfg, chunk.ColumnPermutation); err != nil {
+				return nil, errors.Trace(err)
+			}
+			initializedColumns = true
+		}
+
+		lastRow := cr.parser.LastRow()
+		if err := cr.directives.DecodeStrings(lastRow.Row); err != nil {
+			report.addIssue(&report.CharsetIssues, newOffset, err)
+		} else if err := mydump.DecodeColumns(lastRow.Row, decoders); err != nil {
+			report.addIssue(&report.ConversionErrors, newOffset, err)
+		} else if err := t.checkRowCharset(lastRow.Row, chunk.ColumnPermutation); err != nil {
+			report.addIssue(&report.CharsetIssues, newOffset, err)
+		} else if _, err := kvEncoder.Encode(logger, lastRow.Row, lastRow.RowID, chunk.ColumnPermutation); err != nil {
+			report.addIssue(&report.ConversionErrors, newOffset, err)
+		}
+		cr.parser.RecycleRow(lastRow)
+	}
+}
+
+// checkRowCharset checks the strings of the row are valid in the charsets of
+// the columns. The encoder skips the check, so the invalid strings would be
+// imported as is.
+func (t *TableRestore) checkRowCharset(row []types.Datum, colPerm []int) error {
+	for i, col := range t.tableInfo.Core.Columns {
+		if i >= len(colPerm) || colPerm[i] < 0 || colPerm[i] >= len(row) {
+			continue
+		}
+		d := &row[colPerm[i]]
+		if d.Kind() != types.KindString || !types.IsString(col.Tp) {
+			continue
+		}
+		switch col.Charset {
+		case charset.CharsetUTF8MB4:
+			if !utf8.ValidString(d.GetString()) {
+				return errors.Errorf("invalid utf8mb4 string in column `%s`", col.Name.O)
+			}
+		case charset.CharsetUTF8:
+			for _, r := range d.GetString() {
+				if r == utf8.RuneError || r > 0xFFFF {
+					return errors.Errorf("invalid utf8 string in column `%s`", col.Name.O)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// loadSchemaFiles builds the table infos from the schema files, without
+// connecting to the target.
+func loadSchemaFiles(
+	ctx context.Context,
+	cfg *config.Config,
+	dbMetas []*mydump.MDDatabaseMeta,
+	store storage.ExternalStorage,
+) (map[string]*checkpoints.TidbDBInfo, error) {
+	p := parser.New()
+	p.SetSQLMode(cfg.TiDB.SQLMode)
+	se := mock.NewContext()
+
+	dbInfos := make(map[string]*checkpoints.TidbDBInfo, len(dbMetas))
+	tableID := int64(0)
+	for _, dbMeta := range dbMetas {
+		dbInfo := &checkpoints.TidbDBInfo{
+			Name:   dbMeta.Name,
+			Tables: make(map[string]*checkpoints.TidbTableInfo, len(dbMeta.Tables)),
+		}
+		for _, tableMeta := range dbMeta.Tables {
+			if len(tableMeta.SchemaFile.FileMeta.Path) == 0 {
+				return nil, errors.Errorf("schema file of table %s.%s is missing, set `mydumper.no-schema` to read the schema from the target",
+					dbMeta.Name, tableMeta.Name)
+			}
+			schema, err := tableMeta.ReadSchemaFile(ctx, store, tableMeta.SchemaFile)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			tableID++
+			core, err := mockTableInfo(p, se, schema, tableID)
+			if err != nil {
+				return nil, errors.Annotatef(err, "failed to parse the schema file %s", tableMeta.SchemaFile.FileMeta.Path)
+			}
+			core.Name = model.NewCIStr(tableMeta.Name)
+			dbInfo.Tables[tableMeta.Name] = &checkpoints.TidbTableInfo{
+				ID:   tableID,
+				Name: tableMeta.Name,
+				Core: core,
+			}
+		}
+		dbInfos[dbMeta.Name] = dbInfo
+	}
+	return dbInfos, nil
+}
+
+// mockTableInfo builds the table info of the CREATE TABLE statement in the
+// schema.
+func mockTableInfo(p *parser.Parser, se *mock.Context, schema string, tableID int64) (*model.TableInfo, error) {
+	schema, _ = extractSchemaFeatures(schema)
+	schema, _ = extractTTLOptions(schema)
+	stmts, _, err := p.Parse(schema, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, stmt := range stmts {
+		if createTableNode, ok := stmt.(*ast.CreateTableStmt); ok {
+			return ddl.MockTableInfo(se, createTableNode, tableID)
+		}
+	}
+	return nil, errors.New("no CREATE TABLE statement found")
+}
+
+// loadRemoteSchemas reads the table infos from the target, which must have
+// the tables created.
+func loadRemoteSchemas(
+	ctx context.Context,
+	cfg *config.Config,
+	dbMetas []*mydump.MDDatabaseMeta,
+) (map[string]*checkpoints.TidbDBInfo, error) {
+	tls, err := cfg.ToTLS()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	tidbMgr, err := NewTiDBManager(cfg.TiDB, tls)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer tidbMgr.Close()
+
+	backend := kv.NewTiDBBackend(tidbMgr.db, cfg.TikvImporter.OnDuplicate)
+	return tidbMgr.LoadSchemaInfo(ctx, dbMetas, backend.FetchRemoteTableModels)
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/mysql"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&precheckDataSuite{})
+
+type precheckDataSuite struct{}
+
+func (s *precheckDataSuite) TestPrecheckData(c *C) {
+	dir := c.MkDir()
+	files := map[string]string{
+		"db-schema-create.sql": "CREATE DATABASE db;\n",
+		"db.t-schema.sql":      "CREATE TABLE t (id INT NOT NULL, name VARCHAR(4));\n",
+		"db.t.1.csv":           "1,abc\nx,abc\n3,abcdef\n4,\xff\n",
+		"db.t.2.csv":           "6,ok\n7,\"a",
+	}
+	for name, content := range files {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = dir
+	cfg.Mydumper.Filter = []string{"*.*"}
+	cfg.Mydumper.DefaultFileRules = true
+	cfg.Mydumper.CSV.Header = false
+	cfg.Mydumper.BatchSize = 100 << 30
+	cfg.Mydumper.BatchImportRatio = 0.75
+	cfg.App.TableConcurrency = 2
+	cfg.App.RegionConcurrency = 2
+	cfg.TiDB.SQLMode = mysql.ModeStrictAllTables
+
+	ctx := context.Background()
+	store, err := storage.NewLocalStorage(dir)
+	c.Assert(err, IsNil)
+	mdl, err := mydump.NewMyDumpLoaderWithStore(ctx, cfg, store)
+	c.Assert(err, IsNil)
+
+	reports, err := PrecheckData(ctx, cfg, mdl.GetDatabases(), store)
+	c.Assert(err, ErrorMatches, "found issues in 2 of 2 data files, see the log for details")
+	c.Assert(reports, HasLen, 2)
+
+	c.Assert(reports[0].Table, Equals, "`db`.`t`")
+	c.Assert(reports[0].Path, Equals, "db.t.1.csv")
+	c.Assert(reports[0].Rows, Equals, int64(4))
+	c.Assert(reports[0].MalformedRows, Equals, int64(0))
+	c.Assert(reports[0].ConversionErrors, Equals, int64(2))
+	c.Assert(reports[0].CharsetIssues, Equals, int64(1))
+	c.Assert(reports[0].Samples, HasLen, 3)
+	c.Assert(reports[0].Samples[2], Equals, "offset 25: invalid utf8mb4 string in column `name`")
+
+	c.Assert(reports[1].Path, Equals, "db.t.2.csv")
+	c.Assert(reports[1].Rows, Equals, int64(1))
+	c.Assert(reports[1].MalformedRows, Equals, int64(1))
+	c.Assert(reports[1].HasIssues(), IsTrue)
+}
+
+func (s *precheckDataSuite) TestDataFileReportMerge(c *C) {
+	report := &DataFileReport{Path: "a.csv", Rows: 3, ConversionErrors: 1, Samples: []string{"1", "2", "3"}}
+	report.merge(&DataFileReport{Path: "a.csv", Rows: 5, MalformedRows: 1, Samples: []string{"4", "5", "6"}})
+	c.Assert(report, DeepEquals, &DataFileReport{
+		Path:             "a.csv",
+		Rows:             8,
+		MalformedRows:    1,
+		ConversionErrors: 1,
+		Samples:          []string{"1", "2", "3", "4", "5"},
+	})
+	c.Assert((&DataFileReport{Rows: 1}).HasIssues(), IsFalse)
+}
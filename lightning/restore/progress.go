@@ -0,0 +1,196 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	progressStatusImporting = "importing"
+	progressStatusCompleted = "completed"
+	progressStatusFailed    = "failed"
+)
+
+type tableProgress struct {
+	rows   int64
+	bytes  int64
+	status string
+	dirty  bool
+}
+
+// progressReporter collects the rows and bytes delivered of each table, and
+// periodically upserts them into the progress table on the target, so the
+// progress can be monitored via SQL without access to Lightning's host.
+type progressReporter struct {
+	db      *sql.DB
+	schema  string
+	table   string
+	taskID  int64
+	created bool
+
+	mu     sync.Mutex
+	tables map[string]*tableProgress
+}
+
+// newProgressReporter returns nil if the progress table is not configured.
+func newProgressReporter(db *sql.DB, cfg *config.Config) *progressReporter {
+	if len(cfg.App.ProgressTable) == 0 {
+		return nil
+	}
+	parts := strings.SplitN(cfg.App.ProgressTable, ".", 2)
+	return &progressReporter{
+		db:     db,
+		schema: parts[0],
+		table:  parts[1],
+		taskID: cfg.TaskID,
+		tables: make(map[string]*tableProgress),
+	}
+}
+
+func (p *progressReporter) get(tableName string) *tableProgress {
+	tp, ok := p.tables[tableName]
+	if !ok {
+		tp = &tableProgress{status: progressStatusImporting}
+		p.tables[tableName] = tp
+	}
+	tp.dirty = true
+	return tp
+}
+
+// add records the rows and source bytes delivered to the backend.
+func (p *progressReporter) add(tableName string, rows, bytes int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp := p.get(tableName)
+	tp.rows += rows
+	tp.bytes += bytes
+}
+
+// finish marks the table as completed or failed.
+func (p *progressReporter) finish(tableName string, err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tp := p.get(tableName)
+	if err != nil {
+		tp.status = progressStatusFailed
+	} else {
+		tp.status = progressStatusCompleted
+	}
+}
+
+func (p *progressReporter) createTable(ctx context.Context, exec common.SQLWithRetry) error {
+	var createDatabase strings.Builder
+	createDatabase.WriteString("CREATE DATABASE IF NOT EXISTS ")
+	common.WriteMySQLIdentifier(&createDatabase, p.schema)
+	if err := exec.Exec(ctx, "create progress schema", createDatabase.String()); err != nil {
+		return errors.Trace(err)
+	}
+	return exec.Exec(ctx, "create progress table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			task_id bigint NOT NULL,
+			table_name varchar(261) NOT NULL,
+			rows_delivered bigint NOT NULL DEFAULT 0,
+			bytes_delivered bigint NOT NULL DEFAULT 0,
+			status varchar(16) NOT NULL,
+			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (task_id, table_name)
+		);
+	`, common.UniqueTable(p.schema, p.table)))
+}
+
+// report upserts the progress of the tables updated since the last report.
+// The numbers count what is delivered since Lightning started.
+func (p *progressReporter) report(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+	exec := common.SQLWithRetry{DB: p.db, Logger: log.L()}
+	if !p.created {
+		if err := p.createTable(ctx, exec); err != nil {
+			return errors.Trace(err)
+		}
+		p.created = true
+	}
+
+	type row struct {
+		tableName string
+		tableProgress
+	}
+	p.mu.Lock()
+	rows := make([]row, 0, len(p.tables))
+	for tableName, tp := range p.tables {
+		if tp.dirty {
+			rows = append(rows, row{tableName: tableName, tableProgress: *tp})
+			tp.dirty = false
+		}
+	}
+	p.mu.Unlock()
+	if len(rows) == 0 {
+		return nil
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].tableName < rows[j].tableName })
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "INSERT INTO %s (task_id, table_name, rows_delivered, bytes_delivered, status) VALUES ", common.UniqueTable(p.schema, p.table))
+	args := make([]interface{}, 0, len(rows)*5)
+	for i, r := range rows {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, p.taskID, r.tableName, r.rows, r.bytes, r.status)
+	}
+	sb.WriteString(" ON DUPLICATE KEY UPDATE rows_delivered = VALUES(rows_delivered), bytes_delivered = VALUES(bytes_delivered), status = VALUES(status);")
+
+	if err := exec.Exec(ctx, "report progress", sb.String(), args...); err != nil {
+		// report again next time.
+		p.mu.Lock()
+		for _, r := range rows {
+			p.tables[r.tableName].dirty = true
+		}
+		p.mu.Unlock()
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// reportOrWarn reports the progress, where failures are not fatal to the
+// import.
+func (p *progressReporter) reportOrWarn(ctx context.Context) {
+	if err := p.report(ctx); err != nil {
+		log.L().Warn("report progress to the target failed",
+			zap.String("table", common.UniqueTable(p.schema, p.table)),
+			log.ShortError(err),
+		)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+var _ = Suite(&progressSuite{})
+
+type progressSuite struct{}
+
+func (s *progressSuite) TestDisabled(c *C) {
+	cfg := config.NewConfig()
+	p := newProgressReporter(nil, cfg)
+	c.Assert(p, IsNil)
+	// all methods are no-op on nil.
+	p.add("`db`.`t`", 1, 1)
+	p.finish("`db`.`t`", nil)
+	c.Assert(p.report(context.Background()), IsNil)
+}
+
+func (s *progressSuite) TestReport(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 123
+	cfg.App.ProgressTable = "lightning_task_info.progress"
+	p := newProgressReporter(db, cfg)
+	c.Assert(p, NotNil)
+
+	ctx := context.Background()
+	p.add("`db`.`t2`", 10, 100)
+	p.add("`db`.`t1`", 5, 50)
+	p.add("`db`.`t1`", 5, 70)
+
+	mock.ExpectExec("CREATE DATABASE IF NOT EXISTS `lightning_task_info`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS `lightning_task_info`\\.`progress`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO `lightning_task_info`\\.`progress` .* ON DUPLICATE KEY UPDATE .*").
+		WithArgs(int64(123), "`db`.`t1`", int64(10), int64(120), "importing", int64(123), "`db`.`t2`", int64(10), int64(100), "importing").
+		WillReturnResult(sqlmock.NewResult(2, 2))
+	c.Assert(p.report(ctx), IsNil)
+
+	// nothing changed, nothing to report.
+	c.Assert(p.report(ctx), IsNil)
+
+	p.finish("`db`.`t1`", nil)
+	mock.ExpectExec("INSERT INTO `lightning_task_info`\\.`progress` .*").
+		WithArgs(int64(123), "`db`.`t1`", int64(10), int64(120), "completed").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	c.Assert(p.report(ctx), IsNil)
+
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
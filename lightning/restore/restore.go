@@ -19,6 +19,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,6 +36,8 @@ import (
 	"github.com/pingcap/tidb/meta/autoid"
 	"github.com/pingcap/tidb/table"
 	"github.com/pingcap/tidb/table/tables"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
 	"go.uber.org/zap"
 	"modernc.org/mathutil"
 
@@ -62,6 +66,10 @@ const (
 	indexEngineID = -1
 )
 
+// schemaCheckpointBatchSize is the number of created tables recorded into the
+// checkpoints at once during the schema phase.
+const schemaCheckpointBatchSize = 256
+
 const (
 	compactStateIdle int32 = iota
 	compactStateDoing
@@ -127,23 +135,52 @@ func (es *errorSummaries) record(tableName string, err error, status CheckpointS
 }
 
 type RestoreController struct {
-	cfg             *config.Config
-	dbMetas         []*mydump.MDDatabaseMeta
-	dbInfos         map[string]*TidbDBInfo
-	tableWorkers    *worker.Pool
-	indexWorkers    *worker.Pool
-	regionWorkers   *worker.Pool
+	cfg           *config.Config
+	dbMetas       []*mydump.MDDatabaseMeta
+	dbInfos       map[string]*TidbDBInfo
+	tableWorkers  *worker.Pool
+	indexWorkers  *worker.Pool
+	regionWorkers *worker.Pool
+	// checksumWorkers runs the post-processing of the imported tables in the
+	// background, or is nil to run it in the index workers.
+	checksumWorkers *worker.Pool
 	ioWorkers       *worker.Pool
 	pauser          *common.Pauser
 	backend         kv.Backend
 	tidbMgr         *TiDBManager
+	// dataMgr is the connection of the data user writing the rows by the TiDB
+	// backend, or nil if it is the same as tidbMgr.
+	dataMgr         *TiDBManager
 	postProcessLock sync.Mutex // a simple way to ensure post-processing is not concurrent without using complicated goroutines
 	alterTableLock  sync.Mutex
 	compactState    int32
 	rowFormatVer    string
 	tls             *common.TLS
+	// compat is the features of the pinned target version, or nil to probe
+	// the cluster instead.
+	compat *kv.Compat
 
 	errorSummaries errorSummaries
+	progress       *progressReporter
+	cpExporter     *checkpointExporter
+	tableGroups    *tableGroupTracker
+	chunkStats     *chunkStats
+	kvUsage        *kvUsage
+	windDown       *windDown
+	staging        *stagingStores
+	chaos          *chaosInjector
+	observer       ImportObserver
+	// ttlPaused is the set of the tables whose TTL jobs are disabled during
+	// the import.
+	ttlPaused map[string]struct{}
+	// cachedTables is the set of the tables to be cached after the import.
+	cachedTables map[string]struct{}
+	// uninspectedTables is the set of the tables created before the restart,
+	// whose schemas are not read until their data are restored.
+	uninspectedTables map[string]struct{}
+	// rollback is the plan to roll back the task if it fails, or nil if
+	// `lightning.atomic` is disabled.
+	rollback *rollbackPlan
 
 	checkpointsDB CheckpointsDB
 	saveCpCh      chan saveCp
@@ -185,11 +222,25 @@ func NewRestoreControllerWithPauser(
 		return nil, errors.Trace(err)
 	}
 
-	tidbMgr, err := NewTiDBManager(cfg.TiDB, tls)
+	tidbMgr, err := NewTiDBManager(cfg.TiDB.DDLStore(), tls)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
+	compat := targetCompat(cfg)
+
+	// the rows are written by the data user if the DDL statements are
+	// executed by a separate user.
+	var dataMgr *TiDBManager
+	dataDB := tidbMgr.db
+	if cfg.TikvImporter.Backend == config.BackendTiDB && cfg.TiDB.HasDDLUser() {
+		if dataMgr, err = NewTiDBManager(cfg.TiDB, tls); err != nil {
+			tidbMgr.Close()
+			return nil, errors.Trace(err)
+		}
+		dataDB = dataMgr.db
+	}
+
 	var backend kv.Backend
 	switch cfg.TikvImporter.Backend {
 	case config.BackendImporter:
@@ -199,11 +250,22 @@ func NewRestoreControllerWithPauser(
 			return nil, err
 		}
 	case config.BackendTiDB:
-		backend = kv.NewTiDBBackend(tidbMgr.db, cfg.TikvImporter.OnDuplicate)
+		if len(cfg.TikvImporter.VersionColumn) > 0 {
+			backend = kv.NewTiDBBackendWithVersionColumn(dataDB, cfg.TikvImporter.OnDuplicate, cfg.TikvImporter.VersionColumn)
+		} else {
+			backend = kv.NewTiDBBackend(dataDB, cfg.TikvImporter.OnDuplicate)
+		}
+		if len(cfg.TiDB.TableSQLModes) > 0 {
+			modes := make(map[string]string, len(cfg.TiDB.TableSQLModes))
+			for _, tableMode := range cfg.TiDB.TableSQLModes {
+				modes[strings.ToLower(common.UniqueTable(tableMode.Schema, tableMode.Table))] = tableMode.StrSQLMode
+			}
+			kv.SetTiDBBackendTableSQLModes(backend, modes)
+		}
 	case config.BackendLocal:
 		backend, err = kv.NewLocalBackend(ctx, tls, cfg.TiDB.PdAddr, cfg.TikvImporter.RegionSplitSize,
 			cfg.TikvImporter.SortedKVDir, cfg.TikvImporter.RangeConcurrency, cfg.TikvImporter.SendKVPairs,
-			cfg.Checkpoint.Enable)
+			cfg.Checkpoint.Enable, compat)
 		if err != nil {
 			return nil, err
 		}
@@ -221,16 +283,38 @@ func NewRestoreControllerWithPauser(
 		pauser:        pauser,
 		backend:       backend,
 		tidbMgr:       tidbMgr,
+		dataMgr:       dataMgr,
 		rowFormatVer:  "1",
 		tls:           tls,
+		compat:        compat,
 
 		errorSummaries:    makeErrorSummaries(log.L()),
+		progress:          newProgressReporter(tidbMgr.db, cfg),
+		tableGroups:       newTableGroupTracker(log.L(), dbMetas),
+		chunkStats:        newChunkStats(log.L()),
+		kvUsage:           newKVUsage(log.L(), cfg),
+		windDown:          newWindDown(log.L(), cfg),
+		chaos:             newChaosInjector(cfg.Chaos, log.L()),
 		checkpointsDB:     cpdb,
 		saveCpCh:          make(chan saveCp),
 		closedEngineLimit: worker.NewPool(ctx, cfg.App.TableConcurrency*2, "closed-engine"),
 
 		store: s,
 	}
+	if rc.cpExporter, err = newCheckpointExporter(ctx, cfg, cpdb); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if rc.staging, err = newStagingStores(ctx, log.L(), tls, cfg); err != nil {
+		return nil, errors.Trace(err)
+	}
+	if cfg.PostRestore.ChecksumConcurrency > 0 {
+		rc.checksumWorkers = worker.NewPool(ctx, cfg.PostRestore.ChecksumConcurrency, "checksum")
+	}
+	if cfg.App.Atomic {
+		if rc.rollback, err = loadRollbackPlan(cfg.App.RollbackFile); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 
 	return rc, nil
 }
@@ -254,7 +338,27 @@ func OpenCheckpointsDB(ctx context.Context, cfg *config.Config) (CheckpointsDB,
 		return cpdb, nil
 
 	case config.CheckpointDriverFile:
-		return NewFileCheckpointsDB(cfg.Checkpoint.DSN), nil
+		// the copy of the checkpoint file is kept in the target, to salvage
+		// the checkpoints from if the file is corrupted.
+		param := common.MySQLConnectParam{
+			Host:             cfg.TiDB.Host,
+			Port:             cfg.TiDB.Port,
+			User:             cfg.TiDB.User,
+			Password:         cfg.TiDB.Psw,
+			SQLMode:          cfg.TiDB.StrSQLMode,
+			MaxAllowedPacket: cfg.TiDB.MaxAllowedPacket,
+			TLS:              cfg.TiDB.TLS,
+		}
+		db, err := sql.Open("mysql", param.ToDSN())
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		cpdb, err := NewFileCheckpointsDBWithTarget(ctx, cfg.Checkpoint.DSN, db)
+		if err != nil {
+			db.Close()
+			return nil, errors.Trace(err)
+		}
+		return cpdb, nil
 
 	default:
 		return nil, errors.Errorf("Unknown checkpoint driver %s", cfg.Checkpoint.Driver)
@@ -264,6 +368,9 @@ func OpenCheckpointsDB(ctx context.Context, cfg *config.Config) (CheckpointsDB,
 func (rc *RestoreController) Close() {
 	rc.backend.Close()
 	rc.tidbMgr.Close()
+	if rc.dataMgr != nil {
+		rc.dataMgr.Close()
+	}
 }
 
 func (rc *RestoreController) Run(ctx context.Context) error {
@@ -271,6 +378,7 @@ func (rc *RestoreController) Run(ctx context.Context) error {
 		rc.checkRequirements,
 		rc.restoreSchema,
 		rc.restoreTables,
+		rc.restorePostSchema,
 		rc.fullCompact,
 		rc.switchToNormalMode,
 		rc.cleanCheckpoints,
@@ -294,6 +402,9 @@ outside:
 			logger.Info("task canceled")
 			err = nil
 			break outside
+		case errors.Cause(err) == ErrImportPartial:
+			logger.Warn("task stopped by lightning.max-duration")
+			break outside
 		default:
 			logger.Error("run failed")
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
@@ -306,48 +417,138 @@ outside:
 		rc.waitCheckpointFinish()
 	}
 
+	// the canceled task is resumed with the same rollback plan.
+	if rc.rollback != nil {
+		switch {
+		case err == nil && finished:
+			if removeErr := rc.rollback.remove(); removeErr != nil {
+				log.L().Warn("failed to remove the rollback file", log.ShortError(removeErr))
+			}
+		case err != nil && errors.Cause(err) != ErrImportPartial:
+			rc.rollbackTask(context.Background())
+		}
+	}
+
 	task.End(zap.ErrorLevel, err)
 	rc.errorSummaries.emitLog()
+	rc.tableGroups.emitLog()
+	rc.chunkStats.emitLog()
+	rc.kvUsage.emitLog()
+	rc.windDown.emitLog()
+	common.Warnings.EmitLog(log.L())
 
 	return errors.Trace(err)
 }
 
 func (rc *RestoreController) restoreSchema(ctx context.Context) error {
-	tidbMgr, err := NewTiDBManager(rc.cfg.TiDB, rc.tls)
+	tidbMgr, err := NewTiDBManager(rc.cfg.TiDB.DDLStore(), rc.tls)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	defer tidbMgr.Close()
 
+	// the rollback plan is saved before any change on the target.
+	if rc.rollback != nil {
+		if err := rc.rollback.inspect(ctx, tidbMgr.db, rc.dbMetas); err != nil {
+			return errors.Annotate(err, "prepare the rollback of the atomic task")
+		}
+	}
+
 	if !rc.cfg.Mydumper.NoSchema {
 		tidbMgr.db.ExecContext(ctx, "SET SQL_MODE = ?", rc.cfg.TiDB.StrSQLMode)
 
-		for _, dbMeta := range rc.dbMetas {
-			task := log.With(zap.String("db", dbMeta.Name)).Begin(zap.InfoLevel, "restore table schema")
+		// skip the tables created before the restart.
+		createdTables, err := rc.checkpointsDB.GetSchemaCheckpoints(ctx)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		tidbMgr.schemaConcurrency = rc.cfg.App.RegionConcurrency
+		var newlyCreated []string
+		flushCreated := func() error {
+			err := rc.checkpointsDB.InsertSchemaCheckpoints(ctx, newlyCreated)
+			newlyCreated = newlyCreated[:0]
+			return errors.Trace(err)
+		}
 
+		// only the schemas of the tables not created yet are read here, the
+		// tables created before the restart are inspected when their data
+		// are restored, see inspectCreatedTable.
+		schemas := rc.readTableSchemas(ctx, createdTables)
+		rc.cachedTables = make(map[string]struct{})
+		rc.uninspectedTables = make(map[string]struct{})
+		for _, dbMeta := range rc.dbMetas {
 			tablesSchema := make(map[string]string)
+			tables := make([]*mydump.MDTableMeta, 0, len(dbMeta.Tables))
 			for _, tblMeta := range dbMeta.Tables {
-				tablesSchema[tblMeta.Name] = tblMeta.GetSchema(ctx, rc.store)
+				tableName := common.UniqueTable(dbMeta.Name, tblMeta.Name)
+				if _, ok := createdTables[tableName]; ok {
+					rc.uninspectedTables[tableName] = struct{}{}
+					tables = append(tables, tblMeta)
+					continue
+				}
+				schema := schemas[tableName]
+				create, importData := rc.inspectSchema(tableName, schema)
+				if importData {
+					tables = append(tables, tblMeta)
+				}
+				if !create {
+					continue
+				}
+				if err := rc.verifyShardSchemas(ctx, tidbMgr, tblMeta, schema); err != nil {
+					return errors.Annotatef(err, "verify schemas of table %s failed", tableName)
+				}
+				tablesSchema[tblMeta.Name] = schema
+			}
+			hasTables := len(dbMeta.Tables) > 0
+			dbMeta.Tables = tables
+			if hasTables && len(tablesSchema) == 0 {
+				log.L().Info("table schema already restored", zap.String("db", dbMeta.Name))
+				continue
+			}
+
+			task := log.With(zap.String("db", dbMeta.Name)).Begin(zap.InfoLevel, "restore table schema")
+			err = tidbMgr.InitSchema(ctx, dbMeta.Name, tablesSchema, func(tableName string) error {
+				newlyCreated = append(newlyCreated, common.UniqueTable(dbMeta.Name, tableName))
+				if len(newlyCreated) >= schemaCheckpointBatchSize {
+					return flushCreated()
+				}
+				return nil
+			})
+			// keep the progress even if the schema phase fails, so the restart
+			// resumes from the failed table.
+			if flushErr := flushCreated(); err == nil {
+				err = flushErr
 			}
-			err = tidbMgr.InitSchema(ctx, dbMeta.Name, tablesSchema)
 
 			task.End(zap.ErrorLevel, err)
 			if err != nil {
 				return errors.Annotatef(err, "restore table schema %s failed", dbMeta.Name)
 			}
 		}
+
+		if rc.cfg.TiDB.PauseTTLJobs {
+			if err := rc.pauseTTLJobs(ctx, tidbMgr.db, schemas); err != nil {
+				return errors.Trace(err)
+			}
+		}
 	}
 	dbInfos, err := tidbMgr.LoadSchemaInfo(ctx, rc.dbMetas, rc.backend.FetchRemoteTableModels)
 	if err != nil {
 		return errors.Trace(err)
 	}
 	rc.dbInfos = dbInfos
+	if err := rc.checkColumnTypes(); err != nil {
+		return errors.Trace(err)
+	}
 
 	// Load new checkpoints
 	err = rc.checkpointsDB.Initialize(ctx, rc.cfg, dbInfos)
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err := rc.resumeFromTarget(ctx, tidbMgr.db); err != nil {
+		return errors.Trace(err)
+	}
 	failpoint.Inject("InitializeCheckpointExit", func() {
 		log.L().Warn("exit triggered", zap.String("failpoint", "InitializeCheckpointExit"))
 		os.Exit(0)
@@ -362,6 +563,50 @@ func (rc *RestoreController) restoreSchema(ctx context.Context) error {
 	return nil
 }
 
+// readTableSchemas reads the schema files of all tables except the skipped
+// ones, keyed by the unique table names. The files are read concurrently,
+// since splitting the statements of a table with thousands of partitions takes
+// a while.
+func (rc *RestoreController) readTableSchemas(ctx context.Context, skipped map[string]struct{}) map[string]string {
+	var (
+		tableNames []string
+		tables     []*mydump.MDTableMeta
+	)
+	for _, dbMeta := range rc.dbMetas {
+		for _, tblMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(dbMeta.Name, tblMeta.Name)
+			if _, ok := skipped[tableName]; ok {
+				continue
+			}
+			tableNames = append(tableNames, tableName)
+			tables = append(tables, tblMeta)
+		}
+	}
+	schemas := make([]string, len(tables))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, rc.cfg.App.IOConcurrency)
+	for i, tblMeta := range tables {
+		i, tblMeta := i, tblMeta
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			schemas[i] = tblMeta.GetSchema(ctx, rc.store)
+		}()
+	}
+	wg.Wait()
+
+	res := make(map[string]string, len(tables))
+	for i, tableName := range tableNames {
+		res[tableName] = schemas[i]
+	}
+	return res
+}
+
 // verifyCheckpoint check whether previous task checkpoint is compatible with task config
 func verifyCheckpoint(cfg *config.Config, taskCp *TaskCheckpoint) error {
 	if taskCp == nil {
@@ -375,8 +620,8 @@ func verifyCheckpoint(cfg *config.Config, taskCp *TaskCheckpoint) error {
 
 	if cfg.App.CheckRequirements {
 		errorFmt := "config '%s' value '%s' different from checkpoint value '%s'. You may set 'check-requirements = false' to skip this check or " + retryUsage
-		if cfg.Mydumper.SourceDir != taskCp.SourceDir {
-			return errors.Errorf(errorFmt, "mydumper.data-source-dir", cfg.Mydumper.SourceDir, taskCp.SourceDir)
+		if sourceDir := cfg.Mydumper.TaskSourceDir(); sourceDir != taskCp.SourceDir {
+			return errors.Errorf(errorFmt, "mydumper.data-source-dir", sourceDir, taskCp.SourceDir)
 		}
 
 		if cfg.TikvImporter.Backend == config.BackendLocal && cfg.TikvImporter.SortedKVDir != taskCp.SortedKVDir {
@@ -541,6 +786,20 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 		switchModeChan = make(chan time.Time)
 	}
 
+	var reportProgressChan <-chan time.Time
+	if rc.progress != nil {
+		reportProgressTicker := time.NewTicker(rc.cfg.Cron.ReportProgress.Duration)
+		defer reportProgressTicker.Stop()
+		reportProgressChan = reportProgressTicker.C
+	}
+
+	var exportCheckpointChan <-chan time.Time
+	if rc.cpExporter != nil {
+		exportCheckpointTicker := time.NewTicker(rc.cpExporter.interval)
+		defer exportCheckpointTicker.Stop()
+		exportCheckpointChan = exportCheckpointTicker.C
+	}
+
 	start := time.Now()
 
 	for {
@@ -550,8 +809,16 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 			return
 		case <-stop:
 			log.L().Info("everything imported, stopping periodic actions")
+			rc.progress.reportOrWarn(ctx)
+			rc.cpExporter.exportOrWarn(ctx)
 			return
 
+		case <-reportProgressChan:
+			rc.progress.reportOrWarn(ctx)
+
+		case <-exportCheckpointChan:
+			rc.cpExporter.exportOrWarn(ctx)
+
 		case <-switchModeChan:
 			// periodically switch to import mode, as requested by TiKV 3.0
 			rc.switchToImportMode(ctx)
@@ -578,6 +845,7 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 				state = "writing"
 				remaining = zap.Skip()
 			}
+			rc.kvUsage.checkSortedKVDir()
 
 			// Note: a speed of 28 MiB/s roughly corresponds to 100 GiB/hour.
 			log.L().Info("progress",
@@ -591,15 +859,43 @@ func (rc *RestoreController) runPeriodicActions(ctx context.Context, stop <-chan
 	}
 }
 
+// targetCompat returns the features of the pinned target version, or nil if
+// the version is not pinned.
+func targetCompat(cfg *config.Config) *kv.Compat {
+	if cfg.TiDB.TargetVersion == nil {
+		return nil
+	}
+	return kv.NewCompat(*cfg.TiDB.TargetVersion)
+}
+
 type gcLifeTimeManager struct {
 	runningJobsLock sync.Mutex
 	runningJobs     int
 	oriGCLifeTime   string
+	// useVariable is whether the GC lifetime is the system variable
+	// tidb_gc_life_time rather than the row of mysql.tidb.
+	useVariable bool
+}
+
+func newGCLifeTimeManager(compat *kv.Compat) *gcLifeTimeManager {
+	// Default values of the other members are enough to initialize this struct
+	return &gcLifeTimeManager{
+		useVariable: compat != nil && compat.GCLifeTimeVariable,
+	}
+}
+
+func (m *gcLifeTimeManager) obtain(ctx context.Context, db *sql.DB) (string, error) {
+	if m.useVariable {
+		return ObtainGCLifeTimeVariable(ctx, db)
+	}
+	return ObtainGCLifeTime(ctx, db)
 }
 
-func newGCLifeTimeManager() *gcLifeTimeManager {
-	// Default values of three member are enough to initialize this struct
-	return &gcLifeTimeManager{}
+func (m *gcLifeTimeManager) update(ctx context.Context, db *sql.DB, gcLifeTime string) error {
+	if m.useVariable {
+		return UpdateGCLifeTimeVariable(ctx, db, gcLifeTime)
+	}
+	return UpdateGCLifeTime(ctx, db, gcLifeTime)
 }
 
 // Pre- and post-condition:
@@ -611,7 +907,7 @@ func (m *gcLifeTimeManager) addOneJob(ctx context.Context, db *sql.DB) error {
 	defer m.runningJobsLock.Unlock()
 
 	if m.runningJobs == 0 {
-		oriGCLifeTime, err := ObtainGCLifeTime(ctx, db)
+		oriGCLifeTime, err := m.obtain(ctx, db)
 		if err != nil {
 			return err
 		}
@@ -635,12 +931,15 @@ func (m *gcLifeTimeManager) removeOneJob(ctx context.Context, db *sql.DB) {
 
 	m.runningJobs -= 1
 	if m.runningJobs == 0 {
-		err := UpdateGCLifeTime(ctx, db, m.oriGCLifeTime)
+		err := m.update(ctx, db, m.oriGCLifeTime)
 		if err != nil {
 			query := fmt.Sprintf(
 				"UPDATE mysql.tidb SET VARIABLE_VALUE = '%s' WHERE VARIABLE_NAME = 'tikv_gc_life_time'",
 				m.oriGCLifeTime,
 			)
+			if m.useVariable {
+				query = fmt.Sprintf("SET GLOBAL tidb_gc_life_time = '%s'", m.oriGCLifeTime)
+			}
 			log.L().Warn("revert GC lifetime failed, please reset the GC lifetime manually after Lightning completed",
 				zap.String("query", query),
 				log.ShortError(err),
@@ -668,20 +967,48 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 	taskCh := make(chan task, rc.cfg.App.IndexConcurrency)
 	defer close(taskCh)
 
-	manager := newGCLifeTimeManager()
+	manager := newGCLifeTimeManager(rc.compat)
 	ctx2 := context.WithValue(ctx, &gcLifeTimeKey, manager)
+	finishTable := func(task task, tableLogTask *log.Task, err error) {
+		defer wg.Done()
+		if errors.Cause(err) == ErrImportPartial {
+			// the table is to be resumed in the next run, so it is
+			// neither completed nor failed.
+			tableLogTask.End(zap.WarnLevel, err)
+			return
+		}
+		err = rc.resumeTTLJob(ctx2, task.tr.tableName, err)
+		err = rc.cacheTable(ctx2, task.tr.tableName, err)
+		err = errors.Annotatef(err, "restore table %s failed", task.tr.tableName)
+		rc.tableGroups.fail(task.tr.tableMeta.Group, task.tr.tableName, err)
+		tableLogTask.End(zap.ErrorLevel, err)
+		web.BroadcastError(task.tr.tableName, err)
+		metric.RecordTableCount("completed", err)
+		rc.progress.finish(task.tr.tableName, err)
+		restoreErr.Set(err)
+	}
 	for i := 0; i < rc.cfg.App.IndexConcurrency; i++ {
 		go func() {
 			for task := range taskCh {
 				tableLogTask := task.tr.logger.Begin(zap.InfoLevel, "restore table")
 				web.BroadcastTableCheckpoint(task.tr.tableName, task.cp)
-				err := task.tr.restoreTable(ctx2, rc, task.cp)
-				err = errors.Annotatef(err, "restore table %s failed", task.tr.tableName)
-				tableLogTask.End(zap.ErrorLevel, err)
-				web.BroadcastError(task.tr.tableName, err)
-				metric.RecordTableCount("completed", err)
-				restoreErr.Set(err)
-				wg.Done()
+				rc.tableGroups.start(task.tr.tableMeta.Group)
+				err := task.tr.importTable(ctx2, rc, task.cp)
+				if err == nil && rc.checksumWorkers != nil {
+					// hand the post-processing over to the checksum workers,
+					// so this worker can start importing the next table.
+					go func(task task, tableLogTask *log.Task) {
+						w := rc.checksumWorkers.Apply()
+						err := task.tr.postProcess(ctx2, rc, task.cp)
+						rc.checksumWorkers.Recycle(w)
+						finishTable(task, tableLogTask, errors.Trace(err))
+					}(task, tableLogTask)
+					continue
+				}
+				if err == nil {
+					err = errors.Trace(task.tr.postProcess(ctx2, rc, task.cp))
+				}
+				finishTable(task, tableLogTask, err)
 			}
 		}()
 	}
@@ -711,6 +1038,10 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 			} else if cp.TableID > 0 && cp.TableID != tableInfo.ID {
 				allDirtyCheckpoints[tableName] = struct{}{}
 			}
+			// the rules left by the tables imported by the previous run.
+			if cp.Status >= CheckpointStatusIndexImported {
+				rc.staging.release(tableName, tableInfo.Core)
+			}
 		}
 	}
 
@@ -767,27 +1098,39 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 		return errors.New("TiDB Lightning has detected tables with illegal checkpoints; please remove these checkpoints first")
 	}
 
-	for _, dbMeta := range rc.dbMetas {
-		dbInfo := rc.dbInfos[dbMeta.Name]
-		for _, tableMeta := range dbMeta.Tables {
-			tableInfo := dbInfo.Tables[tableMeta.Name]
-			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
-			cp, err := rc.checkpointsDB.Get(ctx, tableName)
-			if err != nil {
-				return errors.Trace(err)
-			}
-			tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
-			if err != nil {
-				return errors.Trace(err)
-			}
+	if err := rc.checkColumnMasks(); err != nil {
+		return errors.Trace(err)
+	}
 
-			wg.Add(1)
-			select {
-			case taskCh <- task{tr: tr, cp: cp}:
-			case <-ctx.Done():
-				return ctx.Err()
+	for _, tableMeta := range rc.tablesInPriorityOrder() {
+		dbInfo := rc.dbInfos[tableMeta.DB]
+		tableInfo := dbInfo.Tables[tableMeta.Name]
+		tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+		cp, err := rc.checkpointsDB.Get(ctx, tableName)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if _, ok := rc.uninspectedTables[tableName]; ok && cp.Status < CheckpointStatusAnalyzeSkipped {
+			if !rc.inspectCreatedTable(ctx, tableMeta, tableName) {
+				continue
 			}
 		}
+		// the tables whose data are all imported are still post-processed.
+		if cp.Status < CheckpointStatusIndexImported && rc.windDown.shouldStop() {
+			rc.windDown.skip(tableName)
+			continue
+		}
+		tr, err := NewTableRestore(tableName, tableMeta, dbInfo, tableInfo, cp)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		wg.Add(1)
+		select {
+		case taskCh <- task{tr: tr, cp: cp}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
 	wg.Wait()
@@ -795,13 +1138,64 @@ func (rc *RestoreController) restoreTables(ctx context.Context) error {
 
 	err := restoreErr.Get()
 	logTask.End(zap.ErrorLevel, err)
+	if err == nil && rc.windDown.partial() {
+		return errors.Trace(ErrImportPartial)
+	}
 	return err
 }
 
+// tablesInPriorityOrder lists all tables to restore, where the tables of
+// higher priority come first. Tables of the same priority keep the order
+// given by the loader. The members of a table group are moved next to the
+// first member, so the group is imported in the same window.
+func (rc *RestoreController) tablesInPriorityOrder() []*mydump.MDTableMeta {
+	var tables []*mydump.MDTableMeta
+	for _, dbMeta := range rc.dbMetas {
+		tables = append(tables, dbMeta.Tables...)
+	}
+	sort.SliceStable(tables, func(i, j int) bool {
+		return tables[i].Priority > tables[j].Priority
+	})
+
+	members := make(map[string][]*mydump.MDTableMeta)
+	for _, tableMeta := range tables {
+		if len(tableMeta.Group) > 0 {
+			members[tableMeta.Group] = append(members[tableMeta.Group], tableMeta)
+		}
+	}
+	if len(members) == 0 {
+		return tables
+	}
+	ordered := make([]*mydump.MDTableMeta, 0, len(tables))
+	for _, tableMeta := range tables {
+		if len(tableMeta.Group) == 0 {
+			ordered = append(ordered, tableMeta)
+		} else if group, ok := members[tableMeta.Group]; ok {
+			ordered = append(ordered, group...)
+			delete(members, tableMeta.Group)
+		}
+	}
+	return ordered
+}
+
 func (t *TableRestore) restoreTable(
 	ctx context.Context,
 	rc *RestoreController,
 	cp *TableCheckpoint,
+) error {
+	if err := t.importTable(ctx, rc, cp); err != nil {
+		return err
+	}
+	// 4. Post-process
+	return errors.Trace(t.postProcess(ctx, rc, cp))
+}
+
+// importTable restores the data of the table, leaving the post-processing to
+// the caller.
+func (t *TableRestore) importTable(
+	ctx context.Context,
+	rc *RestoreController,
+	cp *TableCheckpoint,
 ) error {
 	// 1. Load the table info.
 
@@ -817,10 +1211,16 @@ func (t *TableRestore) restoreTable(
 			zap.Int("enginesCnt", len(cp.Engines)),
 			zap.Int("filesCnt", cp.CountChunks()),
 		)
+		t.restoreChunkDirectives(cp)
 	} else if cp.Status < CheckpointStatusAllWritten {
+		scanStart := time.Now()
 		if err := t.populateChunks(ctx, rc, cp); err != nil {
 			return errors.Trace(err)
 		}
+		rc.chunkStats.recordPhase(t.tableName, phaseScan, time.Since(scanStart))
+		if err := t.rebaseChunkRowIDs(ctx, rc, cp); err != nil {
+			return errors.Trace(err)
+		}
 		if err := rc.checkpointsDB.InsertEngineCheckpoints(ctx, t.tableName, cp.Engines); err != nil {
 			return errors.Trace(err)
 		}
@@ -842,14 +1242,27 @@ func (t *TableRestore) restoreTable(
 		}
 	}
 
-	// 2. Restore engines (if still needed)
-	err := t.restoreEngines(ctx, rc, cp)
-	if err != nil {
-		return errors.Trace(err)
+	// 2. Verify the key encoding against the target before writing any data.
+	if rc.cfg.TikvImporter.VerifyKeyEncoding && cp.Status < CheckpointStatusAllWritten {
+		if err := t.verifyKeyEncoding(ctx, rc, cp); err != nil {
+			return errors.Trace(err)
+		}
 	}
 
-	// 3. Post-process
-	return errors.Trace(t.postProcess(ctx, rc, cp))
+	// the table resumed from the records on the target has no engines, see
+	// resumeFromTarget.
+	if len(cp.Engines) == 0 {
+		return nil
+	}
+
+	// 3. Restore engines (if still needed)
+	if cp.Status < CheckpointStatusIndexImported {
+		if err := rc.staging.place(t.tableName, t.tableInfo.Core); err != nil {
+			return errors.Trace(err)
+		}
+		defer rc.staging.release(t.tableName, t.tableInfo.Core)
+	}
+	return errors.Trace(t.restoreEngines(ctx, rc, cp))
 }
 
 func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
@@ -886,8 +1299,18 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 		logTask := t.logger.Begin(zap.InfoLevel, "import whole table")
 		var wg sync.WaitGroup
 		var engineErr common.OnceError
+		windDownSkipped := false
 
-		for engineID, engine := range cp.Engines {
+		// the incremental dumps must be applied after the older generations,
+		// so the engines are restored one by one in order.
+		sequential := len(rc.cfg.Mydumper.Generations) > 0 || t.writesInSourceOrder(rc)
+		engineIDs := engineRestoreOrder(sequential, cp.Engines)
+		if !sort.SliceIsSorted(engineIDs, func(i, j int) bool { return engineIDs[i] < engineIDs[j] }) {
+			t.logger.Info("resume the engines closest to completion first", zap.Int32s("order", engineIDs))
+		}
+
+		for _, engineID := range engineIDs {
+			engine := cp.Engines[engineID]
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
@@ -903,6 +1326,10 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 			}
 
 			if engine.Status < CheckpointStatusImported {
+				if rc.windDown.shouldStop() {
+					windDownSkipped = true
+					continue
+				}
 				wg.Add(1)
 
 				// Note: We still need tableWorkers to control the concurrency of tables.
@@ -931,12 +1358,21 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 						engineErr.Set(err)
 					}
 				}(restoreWorker, engineID, engine)
+				if sequential {
+					wg.Wait()
+				}
 			}
 		}
 
 		wg.Wait()
 
 		err = engineErr.Get()
+		if err == nil && windDownSkipped {
+			// the index engine is kept open to be resumed with the remaining
+			// data engines in the next run.
+			rc.windDown.skip(t.tableName)
+			err = errors.Trace(ErrImportPartial)
+		}
 		logTask.End(zap.ErrorLevel, err)
 		if err != nil {
 			return errors.Trace(err)
@@ -947,7 +1383,9 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 		if indexEngineCp.Status == CheckpointStatusClosed {
 			closedIndexEngine, err = rc.backend.UnsafeCloseEngine(ctx, t.tableName, indexEngineID)
 		} else {
+			closeStart := time.Now()
 			closedIndexEngine, err = indexEngine.Close(ctx)
+			rc.chunkStats.recordPhase(t.tableName, phaseClose, time.Since(closeStart))
 			rc.saveStatusCheckpoint(t.tableName, indexEngineID, err, CheckpointStatusClosed)
 		}
 		if err != nil {
@@ -962,11 +1400,16 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 			if !rc.isLocalBackend() {
 				rc.postProcessLock.Lock()
 			}
+			importStart := time.Now()
 			err = t.importKV(ctx, closedIndexEngine)
+			rc.chunkStats.recordPhase(t.tableName, phaseImport, time.Since(importStart))
 			if !rc.isLocalBackend() {
 				rc.postProcessLock.Unlock()
 			}
 			rc.saveStatusCheckpoint(t.tableName, indexEngineID, err, CheckpointStatusImported)
+			if err == nil {
+				err = rc.chaos.afterEngineImported(t.tableName, indexEngineID)
+			}
 		}
 
 		failpoint.Inject("FailBeforeIndexEngineImported", func() {
@@ -981,6 +1424,75 @@ func (t *TableRestore) restoreEngines(ctx context.Context, rc *RestoreController
 	return nil
 }
 
+// chunkRestoreOrder returns the indices of the chunks in the order to restore.
+// The chunks of the incremental dumps are restored after the older
+// generations, so the newer rows replace the older ones. Within a generation,
+// the chunks partially restored by the previous run come first, since their
+// files are likely still in the page cache of the host, and the rows they
+// wrote are already in the local engine files. Each of them is restored by
+// the region worker of the previous run if it is idle, see
+// ChunkCheckpoint.Worker.
+func chunkRestoreOrder(generations []string, chunks []*ChunkCheckpoint) []int {
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := chunks[order[i]], chunks[order[j]]
+		if len(generations) > 0 {
+			ga, gb := config.DataGeneration(generations, a.Key.Path), config.DataGeneration(generations, b.Key.Path)
+			if ga != gb {
+				return ga < gb
+			}
+		}
+		return isPartiallyRestored(a) && !isPartiallyRestored(b)
+	})
+	return order
+}
+
+// isPartiallyRestored returns whether the chunk was left half-done by the
+// previous run.
+func isPartiallyRestored(chunk *ChunkCheckpoint) bool {
+	return chunk.Chunk.Offset > chunk.Key.Offset && chunk.Chunk.Offset < chunk.Chunk.EndOffset
+}
+
+// engineRestoreOrder returns the IDs of the engines in the order to restore.
+// The engines closest to completion come first, i.e. the engines already
+// closed or written, and then the engines with the most chunks restored by the
+// previous run, so the partial work reaches the imported state early and the
+// space of its engine files is released. The engines of the same progress,
+// e.g. all engines of a fresh run, are restored in the order of the IDs, and
+// so are all engines if they must be restored sequentially.
+func engineRestoreOrder(sequential bool, engines map[int32]*EngineCheckpoint) []int32 {
+	engineIDs := make([]int32, 0, len(engines))
+	for engineID := range engines {
+		engineIDs = append(engineIDs, engineID)
+	}
+	sort.Slice(engineIDs, func(i, j int) bool { return engineIDs[i] < engineIDs[j] })
+	if sequential {
+		return engineIDs
+	}
+	sort.SliceStable(engineIDs, func(i, j int) bool {
+		a, b := engines[engineIDs[i]], engines[engineIDs[j]]
+		if a.Status != b.Status {
+			return a.Status > b.Status
+		}
+		return finishedChunks(a) > finishedChunks(b)
+	})
+	return engineIDs
+}
+
+// finishedChunks returns the number of the chunks of the engine fully restored.
+func finishedChunks(cp *EngineCheckpoint) int {
+	finished := 0
+	for _, chunk := range cp.Chunks {
+		if chunk.Chunk.Offset >= chunk.Chunk.EndOffset {
+			finished++
+		}
+	}
+	return finished
+}
+
 func (t *TableRestore) restoreEngine(
 	ctx context.Context,
 	rc *RestoreController,
@@ -1005,15 +1517,36 @@ func (t *TableRestore) restoreEngine(
 	if err != nil {
 		return nil, nil, errors.Trace(err)
 	}
+	rc.kvUsage.openEngine(t.tableName, cp)
 
 	var wg sync.WaitGroup
 	var chunkErr common.OnceError
 
+	generations := rc.cfg.Mydumper.Generations
+	generation := 0
+
+	partialChunks := 0
+	for _, chunk := range cp.Chunks {
+		if isPartiallyRestored(chunk) {
+			partialChunks++
+		}
+	}
+	if partialChunks > 0 {
+		logTask.Info("resume the partially restored chunks first", zap.Int("chunks", partialChunks))
+	}
+
+	inSourceOrder := t.writesInSourceOrder(rc)
+
 	// Restore table data
-	for chunkIndex, chunk := range cp.Chunks {
+	for _, chunkIndex := range chunkRestoreOrder(generations, cp.Chunks) {
+		chunk := cp.Chunks[chunkIndex]
 		if chunk.Chunk.Offset >= chunk.Chunk.EndOffset {
 			continue
 		}
+		if g := config.DataGeneration(generations, chunk.Key.Path); g != generation {
+			wg.Wait()
+			generation = g
+		}
 
 		select {
 		case <-ctx.Done():
@@ -1030,13 +1563,15 @@ func (t *TableRestore) restoreEngine(
 		// 	2. sql -> kvs
 		// 	3. load kvs data (into kv deliver server)
 		// 	4. flush kvs data (into tikv node)
-		cr, err := newChunkRestore(ctx, chunkIndex, rc.cfg, chunk, rc.ioWorkers, rc.store, t.tableInfo)
+		cr, err := newChunkRestore(ctx, chunkIndex, rc.cfg, chunk, rc.ioWorkers, rc.store, t.tableName, t.tableInfo)
 		if err != nil {
 			return nil, nil, errors.Trace(err)
 		}
 		metric.ChunkCounter.WithLabelValues(metric.ChunkStatePending).Inc()
 
-		restoreWorker := rc.regionWorkers.Apply()
+		restoreWorker := rc.regionWorkers.ApplyPreferred(chunk.Worker)
+		cr.worker = restoreWorker
+		chunk.Worker = restoreWorker.ID
 		wg.Add(1)
 		go func(w *worker.Worker, cr *chunkRestore) {
 			// Restore a chunk.
@@ -1054,16 +1589,24 @@ func (t *TableRestore) restoreEngine(
 			metric.ChunkCounter.WithLabelValues(metric.ChunkStateFailed).Inc()
 			chunkErr.Set(err)
 		}(restoreWorker, cr)
+		if inSourceOrder {
+			wg.Wait()
+		}
 	}
 
 	wg.Wait()
+	rc.kvUsage.closeEngine(t.tableName, cp)
 
 	// Report some statistics into the log for debugging.
 	totalKVSize := uint64(0)
 	totalSQLSize := int64(0)
 	for _, chunk := range cp.Chunks {
 		totalKVSize += chunk.Checksum.SumSize()
-		totalSQLSize += chunk.Chunk.EndOffset - chunk.Chunk.Offset
+		if chunk.Chunk.EndOffset == mydump.TableFileSizeINF {
+			totalSQLSize += chunk.Chunk.Offset - chunk.Key.Offset
+		} else {
+			totalSQLSize += chunk.Chunk.EndOffset - chunk.Chunk.Offset
+		}
 	}
 
 	err = chunkErr.Get()
@@ -1083,7 +1626,9 @@ func (t *TableRestore) restoreEngine(
 	}
 
 	dataWorker := rc.closedEngineLimit.Apply()
+	closeStart := time.Now()
 	closedDataEngine, err := dataEngine.Close(ctx)
+	rc.chunkStats.recordPhase(t.tableName, phaseClose, time.Since(closeStart))
 	// For local backend, if checkpoint is enabled, we must flush index engine to avoid data loss.
 	// this flush action impact up to 10% of the performance, so we only do it if necessary.
 	if err == nil && rc.cfg.Checkpoint.Enable && rc.isLocalBackend() {
@@ -1124,7 +1669,9 @@ func (t *TableRestore) importEngine(
 	if !rc.isLocalBackend() {
 		rc.postProcessLock.Lock()
 	}
+	importStart := time.Now()
 	err := t.importKV(ctx, closedEngine)
+	rc.chunkStats.recordPhase(t.tableName, phaseImport, time.Since(importStart))
 	if !rc.isLocalBackend() {
 		rc.postProcessLock.Unlock()
 	}
@@ -1132,6 +1679,9 @@ func (t *TableRestore) importEngine(
 	if err != nil {
 		return errors.Trace(err)
 	}
+	if err := rc.chaos.afterEngineImported(t.tableName, engineID); err != nil {
+		return errors.Trace(err)
+	}
 
 	// 2. perform a level-1 compact if idling.
 	if rc.cfg.PostRestore.Level1Compact &&
@@ -1151,7 +1701,7 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 	if !rc.backend.ShouldPostProcess() {
 		t.logger.Debug("skip post-processing, not supported by backend")
 		rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
-		return nil
+		return rc.tableGroups.arrive(ctx, t.tableMeta.Group, t.tableName, func(context.Context) error { return nil })
 	}
 
 	// 3. alter table set auto_increment
@@ -1160,7 +1710,15 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 		tblInfo := t.tableInfo.Core
 		var err error
 		if tblInfo.PKIsHandle && tblInfo.ContainsAutoRandomBits() {
-			err = AlterAutoRandom(ctx, rc.tidbMgr.db, t.tableName, t.alloc.Get(autoid.AutoRandomType).Base()+1)
+			base := t.alloc.Get(autoid.AutoRandomType).Base() + 1
+			if rc.compat != nil && !rc.compat.AutoRandomBase {
+				// the target cannot rebase the auto_random IDs, the new rows
+				// may conflict with the imported ones until they are rebased.
+				t.logger.Warn("skip rebasing auto_random, not supported by the target version",
+					zap.Stringer("version", rc.compat.Version), zap.Int64("auto_random_base", base))
+			} else {
+				err = AlterAutoRandom(ctx, rc.tidbMgr.db, t.tableName, base)
+			}
 		} else if common.TableHasAutoRowID(tblInfo) || tblInfo.GetAutoIncrementColInfo() != nil {
 			// only alter auto increment id iff table contains auto-increment column or generated handle
 			err = AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, t.alloc.Get(autoid.RowIDAllocType).Base()+1)
@@ -1172,6 +1730,24 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 		}
 	}
 
+	// compact the imported ranges before checksum, instead of leaving it to
+	// TiKV to cause latency spikes long after the import finished.
+	if cp.Status < CheckpointStatusChecksummed {
+		if rc.cfg.ShouldCompactTable(t.tableName) {
+			t.compactTable(ctx, rc)
+		} else if rc.cfg.PostRestore.TableCompact {
+			t.logger.Info("skip table compaction")
+		}
+	}
+
+	// the checksum of a table group runs after all members are imported.
+	return rc.tableGroups.arrive(ctx, t.tableMeta.Group, t.tableName, func(ctx context.Context) error {
+		return t.checksumAndAnalyze(ctx, rc, cp)
+	})
+}
+
+// checksumAndAnalyze runs the steps after the table is imported and compacted.
+func (t *TableRestore) checksumAndAnalyze(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
 	// 4. do table checksum
 	var localChecksum verify.KVChecksum
 	for _, engine := range cp.Engines {
@@ -1186,11 +1762,17 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 			t.logger.Info("skip checksum")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusChecksumSkipped)
 		} else {
-			err := t.compareChecksum(ctx, rc.tidbMgr.db, localChecksum)
+			checksumStart := time.Now()
+			err := rc.chaos.duringChecksum(t.tableName)
+			if err == nil {
+				err = t.compareChecksum(ctx, rc.tidbMgr.db, localChecksum)
+			}
+			rc.chunkStats.recordPhase(t.tableName, phaseChecksum, time.Since(checksumStart))
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusChecksummed)
 			if err != nil {
 				return errors.Trace(err)
 			}
+			t.recordImported(ctx, rc, &localChecksum)
 		}
 	}
 
@@ -1200,7 +1782,9 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 			t.logger.Info("skip analyze")
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, nil, CheckpointStatusAnalyzeSkipped)
 		} else {
+			analyzeStart := time.Now()
 			err := t.analyzeTable(ctx, rc.tidbMgr.db)
+			rc.chunkStats.recordPhase(t.tableName, phaseAnalyze, time.Since(analyzeStart))
 			rc.saveStatusCheckpoint(t.tableName, WholeTableEngineID, err, CheckpointStatusAnalyzed)
 			if err != nil {
 				return errors.Trace(err)
@@ -1211,6 +1795,87 @@ func (t *TableRestore) postProcess(ctx context.Context, rc *RestoreController, c
 	return nil
 }
 
+// compactTable performs a full compaction of the key ranges of the table on
+// every TiKV store. The failure is not fatal and only reported as a warning.
+func (t *TableRestore) compactTable(ctx context.Context, rc *RestoreController) {
+	// wait until any existing level-1 compact to complete first.
+	for !atomic.CompareAndSwapInt32(&rc.compactState, compactStateIdle, compactStateDoing) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer atomic.StoreInt32(&rc.compactState, compactStateIdle)
+
+	tls := rc.tls.WithHost(rc.cfg.TiDB.PdAddr)
+	ranges := tableKeyRanges(t.tableInfo.Core)
+	task := t.logger.Begin(zap.InfoLevel, "compact table")
+	var err error
+	for i, keyRange := range ranges {
+		err = kv.ForAllStores(
+			ctx,
+			tls,
+			kv.StoreStateDisconnected,
+			func(c context.Context, store *kv.Store) error {
+				return kv.CompactRange(c, tls, store.Address, keyRange, FullLevelCompact)
+			},
+		)
+		if err != nil {
+			break
+		}
+		t.logger.Info("compact table progress", zap.Int("ranges", i+1), zap.Int("total", len(ranges)))
+	}
+	task.End(zap.WarnLevel, err)
+	metric.RecordTableCount(metric.TableStateCompacted, err)
+	if err != nil {
+		common.RecordWarning(t.tableName, common.WarningCompactFailed, err.Error())
+	}
+}
+
+// tableKeyRanges returns the encoded key ranges of the table, one for each
+// physical table.
+func tableKeyRanges(tableInfo *model.TableInfo) []*sstpb.Range {
+	ids := []int64{tableInfo.ID}
+	if pi := tableInfo.GetPartitionInfo(); pi != nil {
+		for _, def := range pi.Definitions {
+			ids = append(ids, def.ID)
+		}
+	}
+	ranges := make([]*sstpb.Range, 0, len(ids))
+	for _, id := range ids {
+		ranges = append(ranges, &sstpb.Range{
+			Start: codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(id)),
+			End:   codec.EncodeBytes(nil, tablecodec.EncodeTablePrefix(id+1)),
+		})
+	}
+	return ranges
+}
+
+// restorePostSchema executes the post schema files after all tables are
+// imported.
+func (rc *RestoreController) restorePostSchema(ctx context.Context) error {
+	if !rc.cfg.PostRestore.ExecPostSchema {
+		return nil
+	}
+
+	for _, dbMeta := range rc.dbMetas {
+		for _, file := range dbMeta.PostSchemaFiles {
+			task := log.With(zap.String("db", dbMeta.Name), zap.String("path", file.FileMeta.Path)).
+				Begin(zap.InfoLevel, "restore post schema")
+			postSchema, err := dbMeta.GetPostSchema(ctx, rc.store, file)
+			if err == nil {
+				err = rc.tidbMgr.ExecPostSchema(ctx, dbMeta.Name, postSchema)
+			}
+			task.End(zap.ErrorLevel, err)
+			if err != nil {
+				return errors.Annotatef(err, "restore post schema %s failed", file.FileMeta.Path)
+			}
+		}
+	}
+	return nil
+}
+
 // do full compaction for the whole data.
 func (rc *RestoreController) fullCompact(ctx context.Context) error {
 	if !rc.cfg.PostRestore.Compact {
@@ -1273,7 +1938,12 @@ func (rc *RestoreController) switchTiKVMode(ctx context.Context, mode sstpb.Swit
 	)
 }
 
-func (rc *RestoreController) checkRequirements(_ context.Context) error {
+func (rc *RestoreController) checkRequirements(ctx context.Context) error {
+	// the clock skew is always checked, but only fails with requirement check.
+	if err := rc.checkClockSkew(ctx); err != nil {
+		return errors.Trace(err)
+	}
+
 	// skip requirement check if explicitly turned off
 	if !rc.cfg.App.CheckRequirements {
 		return nil
@@ -1310,6 +1980,15 @@ func (rc *RestoreController) cleanCheckpoints(ctx context.Context) error {
 	return errors.Annotate(err, "clean checkpoints")
 }
 
+// writesInSourceOrder is whether the chunks of the table are written one at a
+// time in the order of the source files. With `mydumper.preserve-row-order`,
+// the TiDB backend does not write the implicit row IDs of the tables without
+// primary keys, which are allocated by the target in the order of insertion.
+func (t *TableRestore) writesInSourceOrder(rc *RestoreController) bool {
+	return rc.cfg.Mydumper.PreserveRowOrder && rc.cfg.TikvImporter.Backend == config.BackendTiDB &&
+		common.TableHasAutoRowID(t.tableInfo.Core)
+}
+
 func (rc *RestoreController) isLocalBackend() bool {
 	return rc.cfg.TikvImporter.Backend == "local"
 }
@@ -1318,6 +1997,11 @@ type chunkRestore struct {
 	parser mydump.Parser
 	index  int
 	chunk  *ChunkCheckpoint
+	// directives are the settings declared by the data file, if any.
+	directives *mydump.FileDirectives
+	// worker is the region worker restoring the chunk, touched on every
+	// delivery to report the progress to the health check.
+	worker *worker.Worker
 }
 
 func newChunkRestore(
@@ -1327,32 +2011,110 @@ func newChunkRestore(
 	chunk *ChunkCheckpoint,
 	ioWorkers *worker.Pool,
 	store storage.ExternalStorage,
+	tableName string,
 	tableInfo *TidbTableInfo,
 ) (*chunkRestore, error) {
 	blockBufSize := cfg.Mydumper.ReadBlockSize
 
-	reader, err := store.Open(ctx, chunk.Key.Path)
+	// the path of a sheet is under the path of the xlsx file.
+	path, sheet := chunk.Key.Path, ""
+	if chunk.FileMeta.Type == mydump.SourceTypeXLSX {
+		path, sheet = mydump.SplitXLSXSheetPath(path)
+	}
+	reader, err := mydump.OpenSourceFile(ctx, store, path, &cfg.Mydumper)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
+	if chunk.FileMeta.Compression != mydump.CompressionNone {
+		// the index of a gzip file lets the chunk start in the middle of it.
+		var gzipIndex mydump.GzipIndex
+		if chunk.FileMeta.Compression == mydump.CompressionGZ {
+			if gzipIndex, err = mydump.ReadGzipIndex(ctx, store, path); err != nil {
+				reader.Close()
+				return nil, errors.Trace(err)
+			}
+		}
+		var decompressed mydump.ReadSeekCloser
+		if gzipIndex != nil {
+			decompressed, err = mydump.NewIndexedDecompressReader(reader, gzipIndex)
+		} else {
+			decompressed, err = mydump.NewDecompressReader(reader, chunk.FileMeta.Compression)
+		}
+		if err != nil {
+			reader.Close()
+			return nil, errors.Annotatef(err, "open %s", path)
+		}
+		reader = decompressed
+	}
+
+	// the directives of the routing rule and the directive lines at the top
+	// of the file override the config, and the lines are skipped by the
+	// parser.
+	var directives *mydump.FileDirectives
+	offset := chunk.Chunk.Offset
+	if (cfg.Mydumper.FileDirectives || len(chunk.FileMeta.Directives) > 0) &&
+		(chunk.FileMeta.Type == mydump.SourceTypeCSV || chunk.FileMeta.Type == mydump.SourceTypeSQL) {
+		if directives, err = mydump.LoadFileDirectives(ctx, store, chunk.FileMeta, cfg.Mydumper.FileDirectives); err != nil {
+			reader.Close()
+			return nil, errors.Trace(err)
+		}
+		if directives != nil && offset < directives.Length {
+			offset = directives.Length
+		}
+	}
 
 	var parser mydump.Parser
 	switch chunk.FileMeta.Type {
 	case mydump.SourceTypeCSV:
-		hasHeader := cfg.Mydumper.CSV.Header && chunk.Chunk.Offset == 0
-		parser = mydump.NewCSVParser(&cfg.Mydumper.CSV, reader, blockBufSize, ioWorkers, hasHeader)
+		csvCfg := directives.CSV(&cfg.Mydumper.CSV)
+		hasHeader := csvCfg.Header && chunk.Chunk.Offset == 0
+		parser = mydump.NewCSVParser(csvCfg, reader, blockBufSize, ioWorkers, hasHeader)
 	case mydump.SourceTypeSQL:
 		parser = mydump.NewChunkParser(cfg.TiDB.SQLMode, reader, blockBufSize, ioWorkers)
+	case mydump.SourceTypeJSON:
+		parser = mydump.NewJSONParser(&cfg.Mydumper.JSON, reader, blockBufSize, ioWorkers, tableColumnNames(tableInfo.Core))
+	case mydump.SourceTypeBSON, mydump.SourceTypeMongoExport:
+		extendedJSON := chunk.FileMeta.Type == mydump.SourceTypeMongoExport
+		parser = mydump.NewBSONParser(&cfg.Mydumper.BSON, reader, blockBufSize, ioWorkers, tableColumnNames(tableInfo.Core), extendedJSON)
+	case mydump.SourceTypeFixedWidth:
+		parser = mydump.NewFixedWidthParser(&cfg.Mydumper.FixedWidth, reader, blockBufSize, ioWorkers)
+	case mydump.SourceTypeMsgpack:
+		parser = mydump.NewMsgpackParser(reader, blockBufSize, ioWorkers)
+	case mydump.SourceTypeProtobuf:
+		parser, err = mydump.NewProtobufParser(&cfg.Mydumper.Protobuf, tableName, reader, blockBufSize, ioWorkers, tableColumnNames(tableInfo.Core))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
 	case mydump.SourceTypeParquet:
 		parser, err = mydump.NewParquetParser(ctx, store, reader, chunk.Key.Path)
 		if err != nil {
 			return nil, errors.Trace(err)
 		}
+	case mydump.SourceTypeAvro:
+		parser, err = mydump.NewAvroParser(reader)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	case mydump.SourceTypeORC:
+		parser, err = mydump.NewORCParser(reader)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	case mydump.SourceTypeXLSX:
+		parser, err = mydump.NewXLSXParser(&cfg.Mydumper.XLSX, reader, sheet)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	case mydump.SourceTypeArrow:
+		parser, err = mydump.NewArrowParser(reader)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
 	default:
 		panic(fmt.Sprintf("file '%s' with unknown source type '%s'", chunk.Key.Path, chunk.FileMeta.Type.String()))
 	}
 
-	if err = parser.SetPos(chunk.Chunk.Offset, chunk.Chunk.PrevRowIDMax); err != nil {
+	if err = parser.SetPos(offset, chunk.Chunk.PrevRowIDMax); err != nil {
 		return nil, errors.Trace(err)
 	}
 	if len(chunk.ColumnPermutation) > 0 {
@@ -1360,9 +2122,10 @@ func newChunkRestore(
 	}
 
 	return &chunkRestore{
-		parser: parser,
-		index:  index,
-		chunk:  chunk,
+		parser:     parser,
+		index:      index,
+		chunk:      chunk,
+		directives: directives,
 	}, nil
 }
 
@@ -1410,9 +2173,32 @@ func (tr *TableRestore) Close() {
 	tr.logger.Info("restore done")
 }
 
+// restoreChunkDirectives fills the directives given by the routing rules
+// into the chunks loaded from the checkpoint, which does not save them.
+func (t *TableRestore) restoreChunkDirectives(cp *TableCheckpoint) {
+	directives := make(map[string]string)
+	for _, dataFile := range t.tableMeta.DataFiles {
+		if len(dataFile.FileMeta.Directives) > 0 {
+			directives[dataFile.FileMeta.Path] = dataFile.FileMeta.Directives
+		}
+	}
+	if len(directives) == 0 {
+		return
+	}
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			chunk.FileMeta.Directives = directives[chunk.FileMeta.Path]
+		}
+	}
+}
+
 func (t *TableRestore) populateChunks(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
 	task := t.logger.Begin(zap.InfoLevel, "load engines and files")
 	chunks, err := mydump.MakeTableRegions(ctx, t.tableMeta, len(t.tableInfo.Core.Columns), rc.cfg, rc.ioWorkers, rc.store)
+	var shardPerms map[string][]int
+	if err == nil {
+		shardPerms, err = t.shardColumnPermutations(ctx, rc)
+	}
 	if err == nil {
 		timestamp := time.Now().Unix()
 		failpoint.Inject("PopulateChunkTimestamp", func(v failpoint.Value) {
@@ -1442,6 +2228,8 @@ func (t *TableRestore) populateChunks(ctx context.Context, rc *RestoreController
 					return errors.Trace(err)
 				}
 				ccp.ColumnPermutation = perms
+			} else if perms, ok := shardPerms[chunk.FileMeta.Path]; ok {
+				ccp.ColumnPermutation = perms
 			}
 			engine.Chunks = append(engine.Chunks, ccp)
 		}
@@ -1456,6 +2244,44 @@ func (t *TableRestore) populateChunks(ctx context.Context, rc *RestoreController
 	return err
 }
 
+// rebaseChunkRowIDs moves the row IDs of all chunks above the row IDs which may
+// already be used by the table. Without a primary key, the row IDs are the
+// handles of the imported rows, and the backends writing the KV pairs directly
+// would silently overwrite the existing rows having the same handles. The
+// range is then reserved by bumping the AUTO_INCREMENT of the table, so the
+// other Lightning instances importing into the same table, and the rows
+// inserted meanwhile, receive the row IDs above it.
+func (t *TableRestore) rebaseChunkRowIDs(ctx context.Context, rc *RestoreController, cp *TableCheckpoint) error {
+	if rc.cfg.TikvImporter.Backend == config.BackendTiDB || !common.TableHasAutoRowID(t.tableInfo.Core) {
+		return nil
+	}
+
+	rc.alterTableLock.Lock()
+	defer rc.alterTableLock.Unlock()
+
+	base, err := ObtainRowIDBase(ctx, rc.tidbMgr.db, t.tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if base <= 0 {
+		return nil
+	}
+
+	maxRowID := base
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			chunk.Chunk.PrevRowIDMax += base
+			chunk.Chunk.RowIDMax += base
+			maxRowID = mathutil.MaxInt64(maxRowID, chunk.Chunk.RowIDMax)
+		}
+	}
+	cp.AllocBase = mathutil.MaxInt64(cp.AllocBase, base)
+	t.logger.Info("allocate row IDs above the existing rows",
+		zap.Int64("base", base), zap.Int64("maxRowID", maxRowID))
+
+	return errors.Trace(AlterAutoIncrement(ctx, rc.tidbMgr.db, t.tableName, maxRowID+1))
+}
+
 // initializeColumns computes the "column permutation" for an INSERT INTO
 // statement. Suppose a table has columns (a, b, c, d) in canonical order, and
 // we execute `INSERT INTO (d, b, a) VALUES ...`, we will need to remap the
@@ -1494,6 +2320,133 @@ func (t *TableRestore) initializeColumns(columns []string, ccp *ChunkCheckpoint)
 	return nil
 }
 
+// columnDecoders returns the decoders configured for this table, indexed by
+// the field position in the data file.
+func (t *TableRestore) columnDecoders(cfg *config.Config, colPerm []int) (map[int]mydump.ColumnDecoder, error) {
+	var decoders map[int]mydump.ColumnDecoder
+	for _, rule := range cfg.Mydumper.ColumnDecoders {
+		if !strings.EqualFold(common.UniqueTable(rule.Schema, rule.Table), t.tableName) {
+			continue
+		}
+		colIndex := -1
+		for i, col := range t.tableInfo.Core.Columns {
+			if col.Name.L == strings.ToLower(rule.Column) {
+				colIndex = i
+				break
+			}
+		}
+		if colIndex < 0 {
+			return nil, errors.Errorf("unknown column `%s` in the column decoders of table %s", rule.Column, t.tableName)
+		}
+		// the column is missing from the data file, nothing to decode.
+		if colIndex >= len(colPerm) || colPerm[colIndex] < 0 {
+			continue
+		}
+		decoder, err := mydump.NewColumnDecoder(rule.Decoders)
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid column decoders of %s.`%s`", t.tableName, rule.Column)
+		}
+		if decoders == nil {
+			decoders = make(map[int]mydump.ColumnDecoder)
+		}
+		decoders[colPerm[colIndex]] = decoder
+	}
+	return decoders, nil
+}
+
+// columnMaskers returns the maskers configured for this table, indexed by
+// the field position in the data file. The first matching rule of each
+// column is used. checkColumnMasks reports the invalid rules before the
+// import starts.
+func (t *TableRestore) columnMaskers(cfg *config.Config, colPerm []int) (map[int]mydump.ColumnMasker, error) {
+	if len(cfg.Mydumper.ColumnMasks) == 0 {
+		return nil, nil
+	}
+	var maskers map[int]mydump.ColumnMasker
+	for i, col := range t.tableInfo.Core.Columns {
+		if i >= len(colPerm) || colPerm[i] < 0 {
+			continue
+		}
+		for _, rule := range cfg.Mydumper.ColumnMasks {
+			if !rule.Match(t.dbInfo.Name, t.tableInfo.Name, col.Name.O) {
+				continue
+			}
+			masker, err := mydump.NewColumnMasker(rule.Masker, &col.FieldType)
+			if err != nil {
+				return nil, errors.Annotatef(err, "invalid column mask of %s.`%s`", t.tableName, col.Name.O)
+			}
+			if maskers == nil {
+				maskers = make(map[int]mydump.ColumnMasker)
+			}
+			maskers[colPerm[i]] = masker
+			break
+		}
+	}
+	return maskers, nil
+}
+
+// checkColumnMasks checks that the maskers of all tables support the types
+// of the masked columns, so an invalid rule fails the task before importing
+// any table rather than halfway.
+func (rc *RestoreController) checkColumnMasks() error {
+	if len(rc.cfg.Mydumper.ColumnMasks) == 0 {
+		return nil
+	}
+	for _, dbInfo := range rc.dbInfos {
+		for _, tableInfo := range dbInfo.Tables {
+			for _, col := range tableInfo.Core.Columns {
+				for _, rule := range rc.cfg.Mydumper.ColumnMasks {
+					if !rule.Match(dbInfo.Name, tableInfo.Name, col.Name.O) {
+						continue
+					}
+					if _, err := mydump.NewColumnMasker(rule.Masker, &col.FieldType); err != nil {
+						return errors.Annotatef(err, "invalid column mask of %s.`%s`", common.UniqueTable(dbInfo.Name, tableInfo.Name), col.Name.O)
+					}
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkColumnTypes checks that the backend can encode the types of the
+// columns of all tables, and reports all the offending columns together with
+// the backends supporting them.
+func (rc *RestoreController) checkColumnTypes() error {
+	var unsupported map[string][]kv.UnsupportedColumn
+	for _, dbInfo := range rc.dbInfos {
+		for _, tableInfo := range dbInfo.Tables {
+			columns := kv.UnsupportedColumns(rc.cfg.TikvImporter.Backend, tableInfo.Core)
+			if len(columns) == 0 {
+				continue
+			}
+			if unsupported == nil {
+				unsupported = make(map[string][]kv.UnsupportedColumn)
+			}
+			unsupported[common.UniqueTable(dbInfo.Name, tableInfo.Name)] = columns
+		}
+	}
+	if len(unsupported) > 0 {
+		return &kv.UnsupportedColumnsError{Backend: rc.cfg.TikvImporter.Backend, Tables: unsupported}
+	}
+	return nil
+}
+
+// missingColumnPermutation returns the column permutation of a row with some
+// values missing from the data file, where the columns of the missing values
+// are dropped so the encoder fills their default values. The result reuses the
+// buffer.
+func missingColumnPermutation(buf []int, colPerm []int, missing []bool) []int {
+	buf = append(buf[:0], colPerm...)
+	for i, field := range buf {
+		if field >= 0 && field < len(missing) && missing[field] {
+			buf[i] = -1
+		}
+	}
+	return buf
+}
+
 func (t *TableRestore) parseColumnPermutations(columns []string) ([]int, error) {
 	colPerm := make([]int, 0, len(t.tableInfo.Core.Columns)+1)
 
@@ -1522,10 +2475,10 @@ func (t *TableRestore) parseColumnPermutations(columns []string) ([]int, error)
 		if i, ok := columnMap[colInfo.Name.L]; ok {
 			colPerm = append(colPerm, i)
 		} else {
-			t.logger.Warn("column missing from data file, going to fill with default value",
-				zap.String("colName", colInfo.Name.O),
-				zap.Stringer("colType", &colInfo.FieldType),
-			)
+			common.RecordWarning(t.tableName, common.WarningMissingColumn, fmt.Sprintf(
+				"column `%s` (%s) missing from data file, going to fill with default value",
+				colInfo.Name.O, colInfo.FieldType.String(),
+			))
 			colPerm = append(colPerm, -1)
 		}
 	}
@@ -1549,6 +2502,16 @@ func getColumnNames(tableInfo *model.TableInfo, permutation []int) []string {
 	return names
 }
 
+// tableColumnNames returns the lower-case names of all columns of the table,
+// which the fields of the JSON objects and the documents are mapped to.
+func tableColumnNames(tableInfo *model.TableInfo) []string {
+	names := make([]string, 0, len(tableInfo.Columns))
+	for _, col := range tableInfo.Columns {
+		names = append(names, col.Name.L)
+	}
+	return names
+}
+
 func (tr *TableRestore) importKV(ctx context.Context, closedEngine *kv.ClosedEngine) error {
 	task := closedEngine.Logger().Begin(zap.InfoLevel, "import and cleanup engine")
 
@@ -1668,7 +2631,7 @@ func increaseGCLifeTime(ctx context.Context, db *sql.DB) (err error) {
 	}
 
 	if increaseGCLifeTime {
-		err = UpdateGCLifeTime(ctx, db, defaultGCLifeTime.String())
+		err = manager.update(ctx, db, defaultGCLifeTime.String())
 		if err != nil {
 			return err
 		}
@@ -1687,7 +2650,9 @@ var (
 )
 
 type deliveredKVs struct {
-	kvs     kv.Row // if kvs is nil, this indicated we've got the last message.
+	// kvs is nil if the row is skipped (e.g. sampled out), which only
+	// advances the offset of the chunk.
+	kvs     kv.Row
 	columns []string
 	offset  int64
 	rowID   int64
@@ -1719,7 +2684,7 @@ func (cr *chunkRestore) deliverLoop(
 
 	for !channelClosed {
 		var dataChecksum, indexChecksum verify.KVChecksum
-		var offset, rowID int64
+		var offset, rowID, rows, received int64
 		var columns []string
 		var kvPacket []deliveredKVs
 		// Fetch enough KV pairs from the source.
@@ -1731,9 +2696,13 @@ func (cr *chunkRestore) deliverLoop(
 					channelClosed = true
 					break populate
 				}
+				received += int64(len(kvPacket))
 				for _, p := range kvPacket {
-					p.kvs.ClassifyAndAppend(&dataKVs, &dataChecksum, &indexKVs, &indexChecksum)
-					columns = p.columns
+					if p.kvs != nil {
+						p.kvs.ClassifyAndAppend(&dataKVs, &dataChecksum, &indexKVs, &indexChecksum)
+						columns = p.columns
+						rows++
+					}
 					offset = p.offset
 					rowID = p.rowID
 				}
@@ -1743,6 +2712,12 @@ func (cr *chunkRestore) deliverLoop(
 			}
 		}
 
+		// the version column must be referred by name, so the column names
+		// are always needed.
+		if len(columns) == 0 && len(rc.cfg.TikvImporter.VersionColumn) > 0 {
+			columns = getColumnNames(t.tableInfo.Core, cr.chunk.ColumnPermutation)
+		}
+
 		// Write KVs into the engine
 		start := time.Now()
 
@@ -1771,12 +2746,21 @@ func (cr *chunkRestore) deliverLoop(
 		// No need to apply a lock since this is the only thread updating these variables.
 		cr.chunk.Checksum.Add(&dataChecksum)
 		cr.chunk.Checksum.Add(&indexChecksum)
-		cr.chunk.Chunk.Offset = offset
-		cr.chunk.Chunk.PrevRowIDMax = rowID
-		// IN local mode, we should write these checkpoint after engine flushed
-		if !rc.isLocalBackend() && (dataChecksum.SumKVS() != 0 || indexChecksum.SumKVS() != 0) {
-			// No need to save checkpoint if nothing was delivered.
-			saveCheckpoint(rc, t, engineID, cr.chunk)
+		cr.worker.Touch()
+		if received > 0 {
+			rc.progress.add(t.tableName, rows, offset-cr.chunk.Chunk.Offset)
+			rc.kvUsage.deliver(t.tableName, cr.chunk, offset-cr.chunk.Chunk.Offset, dataChecksum.SumSize()+indexChecksum.SumSize())
+			if rc.observer != nil {
+				rc.observer.Delivered(t.tableName, rows, offset-cr.chunk.Chunk.Offset)
+			}
+			// the skipped rows still advance the offset, otherwise a chunk
+			// ending with the skipped rows never reaches its end offset.
+			cr.chunk.Chunk.Offset = offset
+			cr.chunk.Chunk.PrevRowIDMax = rowID
+			// IN local mode, we should write these checkpoint after engine flushed
+			if !rc.isLocalBackend() {
+				saveCheckpoint(rc, t, engineID, cr.chunk)
+			}
 		}
 		failpoint.Inject("FailAfterWriteRows", func() {
 			time.Sleep(time.Second)
@@ -1816,6 +2800,7 @@ func saveCheckpoint(rc *RestoreController, t *TableRestore, engineID int32, chun
 			Pos:               chunk.Chunk.Offset,
 			RowID:             chunk.Chunk.PrevRowIDMax,
 			ColumnPermutation: chunk.ColumnPermutation,
+			Worker:            chunk.Worker,
 		},
 	}
 }
@@ -1849,6 +2834,18 @@ func (cr *chunkRestore) encodeLoop(
 
 	pauser, maxKvPairsCnt := rc.pauser, rc.cfg.TikvImporter.MaxKVPairs
 	initializedColumns, reachEOF := false, false
+	var decoders map[int]mydump.ColumnDecoder
+	var maskers map[int]mydump.ColumnMasker
+	var sampler *rowSampler
+	var guard *oversizedRowGuard
+	var lengthGuard *overlongValueGuard
+	// the column permutation of the rows missing some values, reused.
+	var rowPerm []int
+	defer func() {
+		if closeErr := guard.close(); err == nil {
+			err = closeErr
+		}
+	}()
 	for !reachEOF {
 		if err = pauser.Wait(ctx); err != nil {
 			return
@@ -1876,6 +2873,15 @@ func (cr *chunkRestore) encodeLoop(
 							return
 						}
 					}
+					if decoders, err = t.columnDecoders(rc.cfg, cr.chunk.ColumnPermutation); err != nil {
+						return
+					}
+					if maskers, err = t.columnMaskers(rc.cfg, cr.chunk.ColumnPermutation); err != nil {
+						return
+					}
+					sampler = newRowSampler(rc.cfg.Mydumper.SampleRatio, t.tableInfo.Core, cr.chunk.ColumnPermutation)
+					guard = newOversizedRowGuard(rc.cfg, t, cr.chunk)
+					lengthGuard = newOverlongValueGuard(rc.cfg, t, cr.chunk)
 					initializedColumns = true
 				}
 			case io.EOF:
@@ -1886,16 +2892,51 @@ func (cr *chunkRestore) encodeLoop(
 				return
 			}
 			readDur += time.Since(readDurStart)
+			if sampler != nil && !sampler.keep(cr.parser.LastRow().Row) {
+				cr.parser.RecycleRow(cr.parser.LastRow())
+				kvPacket = append(kvPacket, deliveredKVs{offset: newOffset, rowID: rowID})
+				if len(kvPacket) >= maxKvPairsCnt || newOffset == cr.chunk.Chunk.EndOffset {
+					canDeliver = true
+				}
+				continue
+			}
 			encodeDurStart := time.Now()
 			lastRow := cr.parser.LastRow()
-			// sql -> kv
-			kvs, encodeErr := kvEncoder.Encode(logger, lastRow.Row, lastRow.RowID, cr.chunk.ColumnPermutation)
+			var kvs kv.Row
+			skipped := false
+			encodeErr := cr.directives.DecodeStrings(lastRow.Row)
+			if encodeErr == nil {
+				encodeErr = mydump.DecodeColumns(lastRow.Row, decoders)
+			}
+			if encodeErr == nil {
+				mydump.MaskColumns(lastRow.Row, maskers)
+				skipped, encodeErr = lengthGuard.check(lastRow.Row, newOffset)
+			}
+			if encodeErr == nil && !skipped {
+				skipped, encodeErr = guard.check(lastRow.Row, newOffset)
+			}
+			if encodeErr == nil && !skipped {
+				colPerm := cr.chunk.ColumnPermutation
+				if len(lastRow.Missing) > 0 {
+					rowPerm = missingColumnPermutation(rowPerm, colPerm, lastRow.Missing)
+					colPerm = rowPerm
+				}
+				// sql -> kv
+				kvs, encodeErr = kvEncoder.Encode(logger, lastRow.Row, lastRow.RowID, colPerm)
+			}
 			encodeDur += time.Since(encodeDurStart)
 			cr.parser.RecycleRow(lastRow)
 			if encodeErr != nil {
 				err = errors.Annotatef(encodeErr, "in file %s at offset %d", &cr.chunk.Key, newOffset)
 				return
 			}
+			if skipped {
+				kvPacket = append(kvPacket, deliveredKVs{offset: newOffset, rowID: rowID})
+				if len(kvPacket) >= maxKvPairsCnt || newOffset == cr.chunk.Chunk.EndOffset {
+					canDeliver = true
+				}
+				continue
+			}
 			kvPacket = append(kvPacket, deliveredKVs{kvs: kvs, columns: columnNames, offset: newOffset, rowID: rowID})
 			if len(kvPacket) >= maxKvPairsCnt || newOffset == cr.chunk.Chunk.EndOffset {
 				canDeliver = true
@@ -1929,9 +2970,10 @@ func (cr *chunkRestore) restore(
 ) error {
 	// Create the encoder.
 	kvEncoder := rc.backend.NewEncoder(t.encTable, &kv.SessionOptions{
-		SQLMode:          rc.cfg.TiDB.SQLMode,
+		SQLMode:          rc.cfg.TableSQLMode(t.tableName),
 		Timestamp:        cr.chunk.Timestamp,
 		RowFormatVersion: rc.rowFormatVer,
+		GroupRowsByKey:   rc.cfg.TikvImporter.GroupRowsByKey,
 	})
 	kvsCh := make(chan []deliveredKVs, maxKVQueueSize)
 	deliverCompleteCh := make(chan deliverResult)
@@ -1956,8 +2998,16 @@ func (cr *chunkRestore) restore(
 		zap.Int("fileIndex", cr.index),
 		zap.Stringer("path", &cr.chunk.Key),
 	).Begin(zap.InfoLevel, "restore file")
+	start := time.Now()
+	startOffset, startKVBytes := cr.chunk.Chunk.Offset, cr.chunk.Checksum.SumSize()
 
+	// the encoding goroutine is locked to the thread, so the CPU time of the
+	// thread is the CPU time spent on reading and encoding the chunk.
+	runtime.LockOSThread()
+	startCPU := threadCPUTime()
 	readTotalDur, encodeTotalDur, err := cr.encodeLoop(ctx, kvsCh, t, logTask.Logger, kvEncoder, deliverCompleteCh, rc)
+	encodeCPU := threadCPUTime() - startCPU
+	runtime.UnlockOSThread()
 	if err != nil {
 		return err
 	}
@@ -1967,9 +3017,21 @@ func (cr *chunkRestore) restore(
 		logTask.End(zap.ErrorLevel, deliverResult.err,
 			zap.Duration("readDur", readTotalDur),
 			zap.Duration("encodeDur", encodeTotalDur),
+			zap.Duration("encodeCPU", encodeCPU),
 			zap.Duration("deliverDur", deliverResult.totalDur),
 			zap.Object("checksum", &cr.chunk.Checksum),
 		)
+		rc.chunkStats.record(chunkTiming{
+			tableName: t.tableName,
+			path:      cr.chunk.Key.String(),
+			read:      readTotalDur,
+			encode:    encodeTotalDur,
+			cpu:       encodeCPU,
+			deliver:   deliverResult.totalDur,
+			total:     time.Since(start),
+			readBytes: cr.chunk.Chunk.Offset - startOffset,
+			kvBytes:   int64(cr.chunk.Checksum.SumSize() - startKVBytes),
+		})
 		return errors.Trace(deliverResult.err)
 	case <-ctx.Done():
 		return ctx.Err()
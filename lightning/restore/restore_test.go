@@ -33,8 +33,11 @@ import (
 	"github.com/pingcap/parser/ast"
 	"github.com/pingcap/parser/model"
 	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/parser/types"
 	filter "github.com/pingcap/tidb-tools/pkg/table-filter"
 	"github.com/pingcap/tidb/ddl"
+	"github.com/pingcap/tidb/tablecodec"
+	"github.com/pingcap/tidb/util/codec"
 	tmock "github.com/pingcap/tidb/util/mock"
 	uuid "github.com/satori/go.uuid"
 
@@ -104,6 +107,210 @@ func (s *restoreSuite) TestNewTableRestoreFailure(c *C) {
 	c.Assert(err, ErrorMatches, `failed to tables\.TableFromMeta.*`)
 }
 
+func (s *restoreSuite) TestEncodeMissingJSONKey(c *C) {
+	p := parser.New()
+	node, err := p.ParseOneStmt("CREATE TABLE `t` (`id` int, `v` int NOT NULL DEFAULT 7)", "", "")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(tmock.NewContext(), node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	core.State = model.StatePublic
+	tableInfo := &TidbTableInfo{Name: "t", Core: core}
+	dbInfo := &TidbDBInfo{Name: "db", Tables: map[string]*TidbTableInfo{"t": tableInfo}}
+	tr, err := NewTableRestore("`db`.`t`", nil, dbInfo, tableInfo, &TableCheckpoint{})
+	c.Assert(err, IsNil)
+
+	cfg := config.NewConfig()
+	ioWorkers := worker.NewPool(context.Background(), 1, "io")
+	input := `{"id": 1}` + "\n" + `{"id": 1, "v": 7}`
+	jsonParser := mydump.NewJSONParser(&cfg.Mydumper.JSON, mydump.NewStringReader(input), 1024, ioWorkers, tableColumnNames(core))
+	chunk := &ChunkCheckpoint{}
+	c.Assert(tr.initializeColumns(jsonParser.Columns(), chunk), IsNil)
+
+	encoder := kv.NewTableKVEncoder(tr.encTable, &kv.SessionOptions{
+		SQLMode:          mysql.ModeStrictAllTables,
+		Timestamp:        1234567890,
+		RowFormatVersion: "1",
+	})
+	logger := log.L()
+
+	// the column missing from the object is filled with the default instead
+	// of NULL.
+	c.Assert(jsonParser.ReadRow(), IsNil)
+	row := jsonParser.LastRow()
+	c.Assert(row.Missing, DeepEquals, []bool{false, true})
+	colPerm := missingColumnPermutation(nil, chunk.ColumnPermutation, row.Missing)
+	c.Assert(colPerm, DeepEquals, []int{0, -1, -1})
+	missingKVs, err := encoder.Encode(logger, row.Row, 1, colPerm)
+	c.Assert(err, IsNil)
+
+	c.Assert(jsonParser.ReadRow(), IsNil)
+	row = jsonParser.LastRow()
+	c.Assert(row.Missing, IsNil)
+	explicitKVs, err := encoder.Encode(logger, row.Row, 1, chunk.ColumnPermutation)
+	c.Assert(err, IsNil)
+	c.Assert(missingKVs, DeepEquals, explicitKVs)
+}
+
+func (s *restoreSuite) TestTablesInPriorityOrder(c *C) {
+	rc := &RestoreController{dbMetas: []*mydump.MDDatabaseMeta{
+		{Name: "db1", Tables: []*mydump.MDTableMeta{
+			{DB: "db1", Name: "a"},
+			{DB: "db1", Name: "b", Priority: 5},
+		}},
+		{Name: "db2", Tables: []*mydump.MDTableMeta{
+			{DB: "db2", Name: "c", Priority: 10},
+			{DB: "db2", Name: "d"},
+		}},
+	}}
+
+	var names []string
+	for _, tableMeta := range rc.tablesInPriorityOrder() {
+		names = append(names, tableMeta.DB+"."+tableMeta.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"db2.c", "db1.b", "db1.a", "db2.d"})
+}
+
+func (s *restoreSuite) TestTablesInPriorityOrderWithGroups(c *C) {
+	rc := &RestoreController{dbMetas: []*mydump.MDDatabaseMeta{
+		{Name: "db1", Tables: []*mydump.MDTableMeta{
+			{DB: "db1", Name: "a"},
+			{DB: "db1", Name: "b", Priority: 5, Group: "g"},
+			{DB: "db1", Name: "e"},
+		}},
+		{Name: "db2", Tables: []*mydump.MDTableMeta{
+			{DB: "db2", Name: "c", Priority: 10},
+			{DB: "db2", Name: "d", Group: "g"},
+		}},
+	}}
+
+	var names []string
+	for _, tableMeta := range rc.tablesInPriorityOrder() {
+		names = append(names, tableMeta.DB+"."+tableMeta.Name)
+	}
+	c.Assert(names, DeepEquals, []string{"db2.c", "db1.b", "db2.d", "db1.a", "db1.e"})
+}
+
+func (s *restoreSuite) TestCheckColumnTypes(c *C) {
+	column := func(name string, tp byte) *model.ColumnInfo {
+		return &model.ColumnInfo{Name: model.NewCIStr(name), FieldType: *types.NewFieldType(tp)}
+	}
+	rc := &RestoreController{
+		cfg: config.NewConfig(),
+		dbInfos: map[string]*TidbDBInfo{
+			"db": {Name: "db", Tables: map[string]*TidbTableInfo{
+				"plain": {Name: "plain", Core: &model.TableInfo{Columns: []*model.ColumnInfo{
+					column("a", mysql.TypeLong),
+					column("j", mysql.TypeJSON),
+				}}},
+				"geo": {Name: "geo", Core: &model.TableInfo{Columns: []*model.ColumnInfo{
+					column("a", mysql.TypeLong),
+					column("g", mysql.TypeGeometry),
+					column("h", mysql.TypeGeometry),
+				}}},
+			}},
+			"other": {Name: "other", Tables: map[string]*TidbTableInfo{
+				"area": {Name: "area", Core: &model.TableInfo{Columns: []*model.ColumnInfo{
+					column("shape", mysql.TypeGeometry),
+				}}},
+			}},
+		},
+	}
+
+	rc.cfg.TikvImporter.Backend = config.BackendLocal
+	err := rc.checkColumnTypes()
+	c.Assert(err, ErrorMatches, "the local backend cannot encode the column types of 2 table\\(s\\): "+
+		"`db`.`geo` \\[`g` geometry \\(supported by 'tidb'\\), `h` geometry \\(supported by 'tidb'\\)\\]; "+
+		"`other`.`area` \\[`shape` geometry \\(supported by 'tidb'\\)\\]; .*")
+	unsupported, ok := err.(*kv.UnsupportedColumnsError)
+	c.Assert(ok, IsTrue)
+	c.Assert(unsupported.Tables, DeepEquals, map[string][]kv.UnsupportedColumn{
+		"`db`.`geo`": {
+			{Name: "g", Type: "geometry", Backends: []string{config.BackendTiDB}},
+			{Name: "h", Type: "geometry", Backends: []string{config.BackendTiDB}},
+		},
+		"`other`.`area`": {
+			{Name: "shape", Type: "geometry", Backends: []string{config.BackendTiDB}},
+		},
+	})
+
+	rc.cfg.TikvImporter.Backend = config.BackendTiDB
+	c.Assert(rc.checkColumnTypes(), IsNil)
+}
+
+func (s *restoreSuite) TestChunkRestoreOrder(c *C) {
+	newChunk := func(path string, start int64, offset int64) *ChunkCheckpoint {
+		return &ChunkCheckpoint{
+			Key:   ChunkCheckpointKey{Path: path, Offset: start},
+			Chunk: mydump.Chunk{Offset: offset, EndOffset: start + 100},
+		}
+	}
+	chunks := []*ChunkCheckpoint{
+		newChunk("incr/db.t.1.sql", 0, 0),
+		newChunk("db.t.1.sql", 0, 0),
+		newChunk("db.t.1.sql", 100, 150),
+		newChunk("incr/db.t.2.sql", 0, 30),
+		newChunk("db.t.2.sql", 0, 0),
+		newChunk("db.t.3.sql", 0, 100),
+	}
+	c.Assert(chunkRestoreOrder(nil, chunks), DeepEquals, []int{2, 3, 0, 1, 4, 5})
+	c.Assert(chunkRestoreOrder([]string{"incr"}, chunks), DeepEquals, []int{2, 1, 4, 5, 3, 0})
+	c.Assert(isPartiallyRestored(chunks[2]), IsTrue)
+	c.Assert(isPartiallyRestored(chunks[4]), IsFalse)
+	c.Assert(isPartiallyRestored(chunks[5]), IsFalse)
+}
+
+func (s *restoreSuite) TestEngineRestoreOrder(c *C) {
+	newEngine := func(status CheckpointStatus, offsets ...int64) *EngineCheckpoint {
+		engine := &EngineCheckpoint{Status: status}
+		for _, offset := range offsets {
+			engine.Chunks = append(engine.Chunks, &ChunkCheckpoint{
+				Chunk: mydump.Chunk{Offset: offset, EndOffset: 100},
+			})
+		}
+		return engine
+	}
+	engines := map[int32]*EngineCheckpoint{
+		indexEngineID: newEngine(CheckpointStatusLoaded),
+		0:             newEngine(CheckpointStatusImported, 100, 100),
+		1:             newEngine(CheckpointStatusLoaded, 0, 0, 0),
+		2:             newEngine(CheckpointStatusLoaded, 100, 50, 0),
+		3:             newEngine(CheckpointStatusClosed, 100, 100),
+		4:             newEngine(CheckpointStatusLoaded, 100, 100, 0),
+		5:             newEngine(CheckpointStatusLoaded, 0, 50, 0),
+	}
+	c.Assert(engineRestoreOrder(false, engines), DeepEquals, []int32{0, 3, 4, 2, indexEngineID, 1, 5})
+	c.Assert(engineRestoreOrder(true, engines), DeepEquals, []int32{indexEngineID, 0, 1, 2, 3, 4, 5})
+
+	fresh := map[int32]*EngineCheckpoint{
+		indexEngineID: newEngine(CheckpointStatusLoaded),
+		0:             newEngine(CheckpointStatusLoaded, 0, 0),
+		1:             newEngine(CheckpointStatusLoaded, 0, 0),
+	}
+	c.Assert(engineRestoreOrder(false, fresh), DeepEquals, []int32{indexEngineID, 0, 1})
+}
+
+func (s *restoreSuite) TestTableKeyRanges(c *C) {
+	tableInfo := &model.TableInfo{
+		ID: 100,
+		Partition: &model.PartitionInfo{
+			Enable:      true,
+			Definitions: []model.PartitionDefinition{{ID: 101}, {ID: 102}},
+		},
+	}
+	ranges := tableKeyRanges(tableInfo)
+	c.Assert(ranges, HasLen, 3)
+	for i, keyRange := range ranges {
+		_, start, err := codec.DecodeBytes(keyRange.Start, nil)
+		c.Assert(err, IsNil)
+		_, end, err := codec.DecodeBytes(keyRange.End, nil)
+		c.Assert(err, IsNil)
+		c.Assert(start, DeepEquals, []byte(tablecodec.EncodeTablePrefix(int64(100+i))))
+		c.Assert(end, DeepEquals, []byte(tablecodec.EncodeTablePrefix(int64(101+i))))
+	}
+
+	c.Assert(tableKeyRanges(&model.TableInfo{ID: 5}), HasLen, 1)
+}
+
 func (s *restoreSuite) TestErrorSummaries(c *C) {
 	logger, buffer := log.MakeTestLogger()
 
@@ -123,7 +330,7 @@ func (s *restoreSuite) TestErrorSummaries(c *C) {
 
 func MockDoChecksumCtx() context.Context {
 	ctx := context.Background()
-	manager := newGCLifeTimeManager()
+	manager := newGCLifeTimeManager(nil)
 	return context.WithValue(ctx, &gcLifeTimeKey, manager)
 }
 
@@ -268,7 +475,8 @@ func (s *restoreSuite) TestDoChecksumWithErrorAndLongOriginalLifetime(c *C) {
 
 func (s *restoreSuite) TestVerifyCheckpoint(c *C) {
 	dir := c.MkDir()
-	cpdb := checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "cp.pb"))
+	cpdb, err := checkpoints.NewFileCheckpointsDB(filepath.Join(dir, "cp.pb"))
+	c.Assert(err, IsNil)
 	defer cpdb.Close()
 	ctx := context.Background()
 
@@ -642,6 +850,68 @@ func (s *tableRestoreSuite) TestAnalyzeTable(c *C) {
 	c.Assert(mock.ExpectationsWereMet(), IsNil)
 }
 
+func (s *tableRestoreSuite) TestRebaseChunkRowIDs(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("\\QSELECT MAX(_tidb_rowid) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(_tidb_rowid)"}).AddRow(100))
+	mock.ExpectQuery("\\QSHOW TABLE `db`.`table` NEXT_ROW_ID\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"DB_NAME", "TABLE_NAME", "COLUMN_NAME", "NEXT_GLOBAL_ROW_ID"}).
+			AddRow("db", "table", "_tidb_rowid", 30001))
+	mock.ExpectCommit()
+	mock.ExpectExec("\\QALTER TABLE `db`.`table` AUTO_INCREMENT=30021\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	cp := &TableCheckpoint{Engines: map[int32]*EngineCheckpoint{
+		0: {Chunks: []*ChunkCheckpoint{
+			{Chunk: mydump.Chunk{PrevRowIDMax: 0, RowIDMax: 10}},
+			{Chunk: mydump.Chunk{PrevRowIDMax: 10, RowIDMax: 20}},
+		}},
+	}}
+	rc := &RestoreController{cfg: s.cfg, tidbMgr: NewTiDBManagerWithDB(db, defaultSQLMode)}
+	err = s.tr.rebaseChunkRowIDs(context.Background(), rc, cp)
+	c.Assert(err, IsNil)
+
+	// the row IDs are moved above the next global row ID.
+	chunks := cp.Engines[0].Chunks
+	c.Assert(chunks[0].Chunk.PrevRowIDMax, Equals, int64(30000))
+	c.Assert(chunks[0].Chunk.RowIDMax, Equals, int64(30010))
+	c.Assert(chunks[1].Chunk.PrevRowIDMax, Equals, int64(30010))
+	c.Assert(chunks[1].Chunk.RowIDMax, Equals, int64(30020))
+	c.Assert(cp.AllocBase, Equals, int64(30000))
+
+	c.Assert(db.Close(), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *tableRestoreSuite) TestRebaseChunkRowIDsEmptyTable(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("\\QSELECT MAX(_tidb_rowid) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(_tidb_rowid)"}).AddRow(nil))
+	mock.ExpectQuery("\\QSHOW TABLE `db`.`table` NEXT_ROW_ID\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"DB_NAME", "TABLE_NAME", "COLUMN_NAME", "NEXT_GLOBAL_ROW_ID"}).
+			AddRow("db", "table", "_tidb_rowid", 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	cp := &TableCheckpoint{Engines: map[int32]*EngineCheckpoint{
+		0: {Chunks: []*ChunkCheckpoint{{Chunk: mydump.Chunk{PrevRowIDMax: 0, RowIDMax: 10}}}},
+	}}
+	rc := &RestoreController{cfg: s.cfg, tidbMgr: NewTiDBManagerWithDB(db, defaultSQLMode)}
+	err = s.tr.rebaseChunkRowIDs(context.Background(), rc, cp)
+	c.Assert(err, IsNil)
+	c.Assert(cp.Engines[0].Chunks[0].Chunk.RowIDMax, Equals, int64(10))
+
+	c.Assert(db.Close(), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
 func (s *tableRestoreSuite) TestImportKVSuccess(c *C) {
 	controller := gomock.NewController(c)
 	defer controller.Finish()
@@ -714,7 +984,7 @@ func (s *chunkRestoreSuite) SetUpTest(c *C) {
 	}
 
 	var err error
-	s.cr, err = newChunkRestore(context.Background(), 1, s.cfg, &chunk, w, s.store, nil)
+	s.cr, err = newChunkRestore(context.Background(), 1, s.cfg, &chunk, w, s.store, s.tr.tableName, nil)
 	c.Assert(err, IsNil)
 }
 
@@ -762,6 +1032,37 @@ func (s *chunkRestoreSuite) TestDeliverLoopEmptyData(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *chunkRestoreSuite) TestDeliverLoopSkippedRows(c *C) {
+	ctx := context.Background()
+
+	controller := gomock.NewController(c)
+	defer controller.Finish()
+	mockBackend := mock.NewMockBackend(controller)
+	importer := kv.MakeBackend(mockBackend)
+
+	mockBackend.EXPECT().OpenEngine(ctx, gomock.Any()).Return(nil).Times(2)
+	mockBackend.EXPECT().MakeEmptyRows().Return(kv.MakeRowsFromKvPairs(nil)).AnyTimes()
+
+	dataEngine, err := importer.OpenEngine(ctx, s.tr.tableName, 0)
+	c.Assert(err, IsNil)
+	indexEngine, err := importer.OpenEngine(ctx, s.tr.tableName, -1)
+	c.Assert(err, IsNil)
+
+	// the last rows of the chunk are all skipped, the offset still reaches
+	// the end and is saved.
+	saveCpCh := make(chan saveCp, 2)
+	rc := &RestoreController{cfg: &config.Config{}, saveCpCh: saveCpCh, backend: importer}
+	kvsCh := make(chan []deliveredKVs, 2)
+	kvsCh <- []deliveredKVs{{offset: 20, rowID: 3}, {offset: 36, rowID: 4}}
+	kvsCh <- []deliveredKVs{}
+	_, err = s.cr.deliverLoop(ctx, kvsCh, s.tr, 0, dataEngine, indexEngine, rc)
+	c.Assert(err, IsNil)
+	c.Assert(saveCpCh, HasLen, 2)
+	c.Assert(s.cr.chunk.Chunk.Offset, Equals, int64(36))
+	c.Assert(s.cr.chunk.Chunk.PrevRowIDMax, Equals, int64(4))
+	c.Assert(s.cr.chunk.Checksum.SumKVS(), Equals, uint64(0))
+}
+
 func (s *chunkRestoreSuite) TestDeliverLoop(c *C) {
 	ctx := context.Background()
 	kvsCh := make(chan []deliveredKVs)
@@ -0,0 +1,260 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+const (
+	truncateTablePrefix = "TRUNCATE TABLE "
+	dropTablePrefix     = "DROP TABLE IF EXISTS "
+	dropDatabasePrefix  = "DROP DATABASE IF EXISTS "
+
+	rollbackFileHeader = "-- the statements to roll back the changes of the tidb-lightning task on the target.\n" +
+		"-- they are executed automatically if the task fails, and can be executed again safely.\n"
+)
+
+// rollbackPlan is the statements to undo the changes of an atomic task
+// (`lightning.atomic`) on the target. The tables created by the task are
+// dropped, and the tables existing before the task, which must be empty, are
+// truncated. The plan is saved in a file before any change is made, so it
+// survives the restarts of the task.
+type rollbackPlan struct {
+	path string
+
+	truncateTables []string
+	dropTables     []string
+	dropDatabases  []string
+
+	// covered is the set of the tables and databases already in the plan.
+	covered map[string]struct{}
+}
+
+// loadRollbackPlan loads the plan saved by the previous run of the task, or
+// creates an empty plan if there is none.
+func loadRollbackPlan(path string) (*rollbackPlan, error) {
+	p := &rollbackPlan{path: path, covered: make(map[string]struct{})}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(strings.TrimSpace(scanner.Text()), ";")
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, "--"):
+		case strings.HasPrefix(line, truncateTablePrefix):
+			p.addTruncateTable(strings.TrimPrefix(line, truncateTablePrefix))
+		case strings.HasPrefix(line, dropTablePrefix):
+			p.addDropTable(strings.TrimPrefix(line, dropTablePrefix))
+		case strings.HasPrefix(line, dropDatabasePrefix):
+			p.addDropDatabase(strings.TrimPrefix(line, dropDatabasePrefix))
+		default:
+			return nil, errors.Errorf("unexpected statement in the rollback file %s: %s", path, line)
+		}
+	}
+	return p, errors.Trace(scanner.Err())
+}
+
+func (p *rollbackPlan) addTruncateTable(tableName string) {
+	p.truncateTables = append(p.truncateTables, tableName)
+	p.covered[tableName] = struct{}{}
+}
+
+func (p *rollbackPlan) addDropTable(tableName string) {
+	p.dropTables = append(p.dropTables, tableName)
+	p.covered[tableName] = struct{}{}
+}
+
+func (p *rollbackPlan) addDropDatabase(dbName string) {
+	p.dropDatabases = append(p.dropDatabases, dbName)
+	p.covered[dbName] = struct{}{}
+}
+
+// statements returns the statements to execute in order, which drop the
+// databases after the tables.
+func (p *rollbackPlan) statements() []string {
+	stmts := make([]string, 0, len(p.truncateTables)+len(p.dropTables)+len(p.dropDatabases))
+	for _, tableName := range p.truncateTables {
+		stmts = append(stmts, truncateTablePrefix+tableName)
+	}
+	for _, tableName := range p.dropTables {
+		stmts = append(stmts, dropTablePrefix+tableName)
+	}
+	for _, dbName := range p.dropDatabases {
+		stmts = append(stmts, dropDatabasePrefix+dbName)
+	}
+	return stmts
+}
+
+func (p *rollbackPlan) save() error {
+	var sb strings.Builder
+	sb.WriteString(rollbackFileHeader)
+	for _, stmt := range p.statements() {
+		sb.WriteString(stmt)
+		sb.WriteString(";\n")
+	}
+	tmpPath := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, []byte(sb.String()), 0644); err != nil {
+		return errors.Trace(err)
+	}
+	return errors.Trace(os.Rename(tmpPath, p.path))
+}
+
+func escapeDatabase(dbName string) string {
+	var sb strings.Builder
+	common.WriteMySQLIdentifier(&sb, dbName)
+	return sb.String()
+}
+
+// inspect adds the databases and the tables to import into the plan, and
+// saves the plan. The existing tables must be empty, since the rows in them
+// cannot be told from the imported rows.
+func (p *rollbackPlan) inspect(ctx context.Context, db *sql.DB, dbMetas []*mydump.MDDatabaseMeta) error {
+	sqlExec := common.SQLWithRetry{DB: db, Logger: log.L()}
+	for _, dbMeta := range dbMetas {
+		dbName := escapeDatabase(dbMeta.Name)
+		if _, ok := p.covered[dbName]; ok {
+			continue
+		}
+
+		var dbCount int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?", dbMeta.Name).Scan(&dbCount)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if dbCount == 0 {
+			p.addDropDatabase(dbName)
+			continue
+		}
+
+		existing, err := existingTables(ctx, db, dbMeta.Name)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		for _, tblMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(dbMeta.Name, tblMeta.Name)
+			if _, ok := p.covered[tableName]; ok {
+				continue
+			}
+			if _, ok := existing[strings.ToLower(tblMeta.Name)]; !ok {
+				p.addDropTable(tableName)
+				continue
+			}
+			var nonEmpty int
+			err := sqlExec.QueryRow(ctx, "check table emptiness",
+				"SELECT COUNT(*) FROM (SELECT 1 FROM "+tableName+" LIMIT 1) t", &nonEmpty)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if nonEmpty > 0 {
+				return errors.Errorf("`lightning.atomic` requires the existing table %s to be empty to roll back", tableName)
+			}
+			p.addTruncateTable(tableName)
+		}
+	}
+	return p.save()
+}
+
+func existingTables(ctx context.Context, db *sql.DB, dbName string) (map[string]struct{}, error) {
+	rows, err := db.QueryContext(ctx, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?", dbName)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+	tables := make(map[string]struct{})
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, errors.Trace(err)
+		}
+		tables[strings.ToLower(name)] = struct{}{}
+	}
+	return tables, errors.Trace(rows.Err())
+}
+
+// rollback executes the statements of the plan, and removes the file after
+// all are executed. The file is kept for the manual cleanup if any fails.
+func (p *rollbackPlan) rollback(ctx context.Context, db *sql.DB) error {
+	sqlExec := common.SQLWithRetry{DB: db, Logger: log.L()}
+	for _, stmt := range p.statements() {
+		if err := sqlExec.Exec(ctx, "roll back", stmt); err != nil {
+			return errors.Annotatef(err, "roll back failed, execute the statements in %s to clean up", p.path)
+		}
+	}
+	return p.remove()
+}
+
+// remove removes the file after the task succeeds or is rolled back.
+func (p *rollbackPlan) remove() error {
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	return nil
+}
+
+// rollbackTask undoes the changes of the failed atomic task, cleans up the
+// engines and removes the checkpoints, so the target is left as before the
+// task and the next run starts over.
+func (rc *RestoreController) rollbackTask(ctx context.Context) {
+	logger := log.L()
+	task := logger.Begin(zap.WarnLevel, "roll back the atomic task")
+
+	if rc.cfg.TikvImporter.Backend != config.BackendTiDB && rc.cfg.Checkpoint.Enable {
+		for _, dbInfo := range rc.dbInfos {
+			for _, tableInfo := range dbInfo.Tables {
+				tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+				cp, err := rc.checkpointsDB.Get(ctx, tableName)
+				if err != nil {
+					continue
+				}
+				for engineID := range cp.Engines {
+					closedEngine, err := rc.backend.UnsafeCloseEngine(ctx, tableName, engineID)
+					if err == nil {
+						err = closedEngine.Cleanup(ctx)
+					}
+					if err != nil {
+						logger.Warn("failed to clean up engine", zap.String("table", tableName),
+							zap.Int32("engine", engineID), log.ShortError(err))
+					}
+				}
+			}
+		}
+	}
+
+	err := rc.rollback.rollback(ctx, rc.tidbMgr.db)
+	if err == nil && rc.cfg.Checkpoint.Enable {
+		err = errors.Annotate(rc.checkpointsDB.RemoveCheckpoint(ctx, "all"), "remove checkpoints")
+	}
+	task.End(zap.ErrorLevel, err)
+}
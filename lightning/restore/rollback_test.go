@@ -0,0 +1,140 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	. "github.com/pingcap/check"
+	tmysql "github.com/pingcap/parser/mysql"
+
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&rollbackSuite{})
+
+type rollbackSuite struct{}
+
+func rollbackTestMetas() []*mydump.MDDatabaseMeta {
+	return []*mydump.MDDatabaseMeta{
+		{Name: "old", Tables: []*mydump.MDTableMeta{{DB: "old", Name: "empty"}, {DB: "old", Name: "new"}}},
+		{Name: "new", Tables: []*mydump.MDTableMeta{{DB: "new", Name: "t"}}},
+	}
+}
+
+func (s *rollbackSuite) TestInspectAndRollback(c *C) {
+	ctx := context.Background()
+	path := filepath.Join(c.MkDir(), "rollback.sql")
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectQuery("\\QSELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("EMPTY").AddRow("other"))
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `old`.`empty` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?\\E").
+		WithArgs("new").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+
+	plan, err := loadRollbackPlan(path)
+	c.Assert(err, IsNil)
+	c.Assert(plan.inspect(ctx, db, rollbackTestMetas()), IsNil)
+	expected := []string{
+		"TRUNCATE TABLE `old`.`empty`",
+		"DROP TABLE IF EXISTS `old`.`new`",
+		"DROP DATABASE IF EXISTS `new`",
+	}
+	c.Assert(plan.statements(), DeepEquals, expected)
+	content, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, rollbackFileHeader+
+		"TRUNCATE TABLE `old`.`empty`;\nDROP TABLE IF EXISTS `old`.`new`;\nDROP DATABASE IF EXISTS `new`;\n")
+
+	// the restarted task loads the plan, and the covered tables are not
+	// inspected again, since they are no longer empty.
+	plan, err = loadRollbackPlan(path)
+	c.Assert(err, IsNil)
+	c.Assert(plan.statements(), DeepEquals, expected)
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectQuery("\\QSELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("empty").AddRow("new"))
+	c.Assert(plan.inspect(ctx, db, rollbackTestMetas()), IsNil)
+	c.Assert(plan.statements(), DeepEquals, expected)
+
+	// the file is kept if the rollback fails.
+	mock.ExpectExec("\\QTRUNCATE TABLE `old`.`empty`\\E").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("\\QDROP TABLE IF EXISTS `old`.`new`\\E").WillReturnError(&mysql.MySQLError{
+		Number:  tmysql.ErrTableaccessDenied,
+		Message: "DROP command denied",
+	})
+	err = plan.rollback(ctx, db)
+	c.Assert(err, ErrorMatches, "roll back failed, execute the statements in .*rollback.sql to clean up.*")
+	_, err = os.Stat(path)
+	c.Assert(err, IsNil)
+
+	mock.ExpectExec("\\QTRUNCATE TABLE `old`.`empty`\\E").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("\\QDROP TABLE IF EXISTS `old`.`new`\\E").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("\\QDROP DATABASE IF EXISTS `new`\\E").WillReturnResult(sqlmock.NewResult(0, 0))
+	c.Assert(plan.rollback(ctx, db), IsNil)
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), IsTrue)
+
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *rollbackSuite) TestInspectNonEmptyTable(c *C) {
+	ctx := context.Background()
+	path := filepath.Join(c.MkDir(), "rollback.sql")
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM information_schema.SCHEMATA WHERE SCHEMA_NAME = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectQuery("\\QSELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = ?\\E").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows([]string{"TABLE_NAME"}).AddRow("empty"))
+	mock.ExpectQuery("\\QSELECT COUNT(*) FROM (SELECT 1 FROM `old`.`empty` LIMIT 1) t\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+
+	plan, err := loadRollbackPlan(path)
+	c.Assert(err, IsNil)
+	err = plan.inspect(ctx, db, rollbackTestMetas()[:1])
+	c.Assert(err, ErrorMatches, "`lightning.atomic` requires the existing table `old`.`empty` to be empty to roll back")
+	// nothing is saved before the target is changed.
+	_, err = os.Stat(path)
+	c.Assert(os.IsNotExist(err), IsTrue)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *rollbackSuite) TestLoadInvalidRollbackFile(c *C) {
+	path := filepath.Join(c.MkDir(), "rollback.sql")
+	c.Assert(ioutil.WriteFile(path, []byte("-- comment\nDELETE FROM t;\n"), 0644), IsNil)
+	_, err := loadRollbackPlan(path)
+	c.Assert(err, ErrorMatches, "unexpected statement in the rollback file .*: DELETE FROM t")
+}
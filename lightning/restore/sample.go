@@ -0,0 +1,123 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"hash/fnv"
+
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+)
+
+const sampleScale = 1000000
+
+// rowSampler decides whether a row belongs to the deterministic sample of the
+// table, by the hash of the sample key.
+//
+// The sample key is the primary key, so the same rows are sampled in every
+// run. If the table has foreign keys, the columns of the first foreign key are
+// used instead, so a child row is kept exactly when the parent row it refers
+// to is kept (assuming the parent is sampled by its primary key). Note that
+// this only preserves one level of references.
+type rowSampler struct {
+	threshold uint64
+	// positions of the sample key in the data file. nil means using the
+	// whole row.
+	fields []int
+}
+
+// newRowSampler creates a sampler keeping about `ratio` of the rows. Returns
+// nil if all rows should be kept.
+func newRowSampler(ratio float64, tableInfo *model.TableInfo, colPerm []int) *rowSampler {
+	if ratio <= 0 || ratio >= 1 {
+		return nil
+	}
+
+	var keyCols []model.CIStr
+	if len(tableInfo.ForeignKeys) > 0 {
+		keyCols = tableInfo.ForeignKeys[0].Cols
+	} else {
+		keyCols = primaryKeyColumns(tableInfo)
+	}
+
+	fields := make([]int, 0, len(keyCols))
+	for _, name := range keyCols {
+		col := model.FindColumnInfo(tableInfo.Columns, name.L)
+		if col == nil || col.Offset >= len(colPerm) || colPerm[col.Offset] < 0 {
+			// the key is not fully present in the data file, fallback to the
+			// whole row.
+			fields = nil
+			break
+		}
+		fields = append(fields, colPerm[col.Offset])
+	}
+
+	return &rowSampler{
+		threshold: uint64(ratio * sampleScale),
+		fields:    fields,
+	}
+}
+
+func primaryKeyColumns(tableInfo *model.TableInfo) []model.CIStr {
+	if tableInfo.PKIsHandle {
+		for _, col := range tableInfo.Columns {
+			if mysql.HasPriKeyFlag(col.Flag) {
+				return []model.CIStr{col.Name}
+			}
+		}
+	}
+	for _, index := range tableInfo.Indices {
+		if index.Primary {
+			cols := make([]model.CIStr, 0, len(index.Columns))
+			for _, col := range index.Columns {
+				cols = append(cols, col.Name)
+			}
+			return cols
+		}
+	}
+	return nil
+}
+
+// keep returns whether the row is in the sample.
+func (s *rowSampler) keep(row []types.Datum) bool {
+	h := fnv.New64a()
+	write := func(d *types.Datum) {
+		if d.IsNull() {
+			h.Write([]byte{0})
+			return
+		}
+		// the same value may be parsed as different kinds in different data
+		// files (e.g. 1 and '1'), so hash the string form.
+		str, err := d.ToString()
+		if err != nil {
+			str = d.GetString()
+		}
+		h.Write([]byte{1})
+		h.Write([]byte(str))
+	}
+
+	if s.fields == nil {
+		for i := range row {
+			write(&row[i])
+		}
+	} else {
+		for _, i := range s.fields {
+			if i < len(row) {
+				write(&row[i])
+			}
+		}
+	}
+	return h.Sum64()%sampleScale < s.threshold
+}
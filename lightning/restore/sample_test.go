@@ -0,0 +1,72 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"strconv"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+	"github.com/pingcap/parser/mysql"
+	"github.com/pingcap/tidb/types"
+)
+
+var _ = Suite(&sampleSuite{})
+
+type sampleSuite struct{}
+
+func (s *sampleSuite) TestRowSampler(c *C) {
+	pk := &model.ColumnInfo{Name: model.NewCIStr("id"), Offset: 0}
+	pk.Flag = mysql.PriKeyFlag
+	parent := &model.TableInfo{
+		Name:       model.NewCIStr("parent"),
+		PKIsHandle: true,
+		Columns:    []*model.ColumnInfo{pk, {Name: model.NewCIStr("name"), Offset: 1}},
+	}
+	child := &model.TableInfo{
+		Name: model.NewCIStr("child"),
+		Columns: []*model.ColumnInfo{
+			{Name: model.NewCIStr("name"), Offset: 0},
+			{Name: model.NewCIStr("parent_id"), Offset: 1},
+		},
+		ForeignKeys: []*model.FKInfo{{
+			Cols:     []model.CIStr{model.NewCIStr("parent_id")},
+			RefTable: model.NewCIStr("parent"),
+			RefCols:  []model.CIStr{model.NewCIStr("id")},
+		}},
+	}
+
+	c.Assert(newRowSampler(0, parent, []int{0, 1}), IsNil)
+	c.Assert(newRowSampler(1, parent, []int{0, 1}), IsNil)
+
+	parentSampler := newRowSampler(0.1, parent, []int{0, 1})
+	// the child data file has the columns in a different order.
+	childSampler := newRowSampler(0.1, child, []int{1, 0})
+	c.Assert(parentSampler.fields, DeepEquals, []int{0})
+	c.Assert(childSampler.fields, DeepEquals, []int{0})
+
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		id := strconv.Itoa(i)
+		keepParent := parentSampler.keep([]types.Datum{types.NewUintDatum(uint64(i)), types.NewStringDatum("p" + id)})
+		keepChild := childSampler.keep([]types.Datum{types.NewStringDatum(id), types.NewStringDatum("c" + id)})
+		c.Assert(keepChild, Equals, keepParent, Commentf("id = %d", i))
+		// the sample is deterministic.
+		c.Assert(parentSampler.keep([]types.Datum{types.NewUintDatum(uint64(i)), types.NewStringDatum("x")}), Equals, keepParent)
+		if keepParent {
+			kept++
+		}
+	}
+	c.Assert(kept > 800 && kept < 1200, IsTrue, Commentf("kept = %d", kept))
+}
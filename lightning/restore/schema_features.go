@@ -0,0 +1,126 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+const (
+	temporaryLocal  = "LOCAL"
+	temporaryGlobal = "GLOBAL"
+)
+
+var (
+	temporaryRegexp  = regexp.MustCompile(`(?i)\bCREATE\s+(?:(GLOBAL|LOCAL)\s+)?TEMPORARY\s+TABLE\b`)
+	onCommitRegexp   = regexp.MustCompile(`(?i)\s*\bON\s+COMMIT\s+(?:DELETE|PRESERVE)\s+ROWS\b`)
+	cacheTableRegexp = regexp.MustCompile(`(?i)\bALTER\s+TABLE\s+[^;]+?\s+(NO)?CACHE\s*(?:;|$)`)
+)
+
+// schemaFeatures are the features of a table schema file which are unknown to
+// the parser, and so are removed from the schema and handled aside.
+type schemaFeatures struct {
+	// temporary is temporaryLocal or temporaryGlobal for the temporary tables,
+	// or empty otherwise.
+	temporary string
+	// cache is whether the table is turned into a cached table by
+	// `ALTER TABLE ... CACHE`.
+	cache bool
+}
+
+// extractSchemaFeatures removes the temporary table and the cached table
+// definitions from the schema file, leaving a plain CREATE TABLE statement.
+func extractSchemaFeatures(schema string) (string, schemaFeatures) {
+	var features schemaFeatures
+	schema = temporaryRegexp.ReplaceAllStringFunc(schema, func(s string) string {
+		if strings.EqualFold(temporaryRegexp.FindStringSubmatch(s)[1], temporaryGlobal) {
+			features.temporary = temporaryGlobal
+		} else {
+			features.temporary = temporaryLocal
+		}
+		return "CREATE TABLE"
+	})
+	if len(features.temporary) > 0 {
+		schema = onCommitRegexp.ReplaceAllString(schema, "")
+	}
+	schema = cacheTableRegexp.ReplaceAllStringFunc(schema, func(s string) string {
+		features.cache = len(cacheTableRegexp.FindStringSubmatch(s)[1]) == 0
+		return ""
+	})
+	return schema, features
+}
+
+// inspectSchema records the features of the table. It returns whether the
+// table should be created, and whether the data of the table should be
+// imported.
+func (rc *RestoreController) inspectSchema(tableName string, schema string) (create bool, importData bool) {
+	_, features := extractSchemaFeatures(schema)
+	if features.cache {
+		rc.cachedTables[tableName] = struct{}{}
+	}
+
+	switch features.temporary {
+	case temporaryLocal:
+		common.RecordWarning(tableName, common.WarningTemporaryTable,
+			"the local temporary table is not created and its data files are skipped, since it only lives in a session")
+		return false, false
+	case temporaryGlobal:
+		common.RecordWarning(tableName, common.WarningTemporaryTable,
+			"the global temporary table is created but its data files are skipped, since its rows do not persist across transactions")
+		return true, false
+	default:
+		return true, true
+	}
+}
+
+// inspectCreatedTable reads and inspects the schema of a table created before
+// the restart. It returns whether the data of the table should be imported.
+func (rc *RestoreController) inspectCreatedTable(ctx context.Context, tableMeta *mydump.MDTableMeta, tableName string) bool {
+	_, importData := rc.inspectSchema(tableName, tableMeta.GetSchema(ctx, rc.store))
+	return importData
+}
+
+// cacheTable turns the table into a cached table after it is imported, since
+// writing into a cached table is slow. If the import failed, the table is
+// left uncached.
+func (rc *RestoreController) cacheTable(ctx context.Context, tableName string, importErr error) error {
+	if _, ok := rc.cachedTables[tableName]; !ok || importErr != nil {
+		return importErr
+	}
+	return AlterTableCache(ctx, rc.tidbMgr.db, tableName)
+}
+
+// AlterTableCache turns the table into a cached table.
+func AlterTableCache(ctx context.Context, db *sql.DB, tableName string) error {
+	sql := common.SQLWithRetry{
+		DB:     db,
+		Logger: log.With(zap.String("table", tableName)),
+	}
+	query := fmt.Sprintf("ALTER TABLE %s CACHE", tableName)
+	task := sql.Logger.Begin(zap.InfoLevel, "alter table cache")
+	err := sql.Exec(ctx, "alter table cache", query)
+	task.End(zap.ErrorLevel, err)
+	return errors.Annotatef(err, "%s", query)
+}
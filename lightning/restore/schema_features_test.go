@@ -0,0 +1,116 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pingcap/br/pkg/storage"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	tmysql "github.com/pingcap/parser/mysql"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&schemaFeaturesSuite{})
+
+type schemaFeaturesSuite struct{}
+
+func (s *schemaFeaturesSuite) TestExtractSchemaFeatures(c *C) {
+	schema, features := extractSchemaFeatures("CREATE TABLE t (a INT);")
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT);")
+	c.Assert(features, Equals, schemaFeatures{})
+
+	schema, features = extractSchemaFeatures("create temporary table t (a INT);")
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT);")
+	c.Assert(features, Equals, schemaFeatures{temporary: temporaryLocal})
+
+	schema, features = extractSchemaFeatures("CREATE GLOBAL TEMPORARY TABLE t (a INT) ENGINE=InnoDB ON COMMIT DELETE ROWS;")
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT) ENGINE=InnoDB;")
+	c.Assert(features, Equals, schemaFeatures{temporary: temporaryGlobal})
+
+	schema, features = extractSchemaFeatures("CREATE TABLE t (a INT);\nALTER TABLE `t` CACHE;\n")
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT);\n\n")
+	c.Assert(features, Equals, schemaFeatures{cache: true})
+
+	schema, features = extractSchemaFeatures("CREATE TABLE t (a INT); alter table t nocache")
+	c.Assert(schema, Equals, "CREATE TABLE t (a INT); ")
+	c.Assert(features, Equals, schemaFeatures{})
+}
+
+func (s *schemaFeaturesSuite) TestInspectSchema(c *C) {
+	rc := &RestoreController{cachedTables: make(map[string]struct{})}
+
+	create, importData := rc.inspectSchema("`db`.`t`", "CREATE TABLE t (a INT); ALTER TABLE t CACHE;")
+	c.Assert(create, IsTrue)
+	c.Assert(importData, IsTrue)
+	c.Assert(rc.cachedTables, HasKey, "`db`.`t`")
+
+	create, importData = rc.inspectSchema("`db`.`local`", "CREATE TEMPORARY TABLE t (a INT);")
+	c.Assert(create, IsFalse)
+	c.Assert(importData, IsFalse)
+
+	create, importData = rc.inspectSchema("`db`.`global`", "CREATE GLOBAL TEMPORARY TABLE t (a INT) ON COMMIT DELETE ROWS;")
+	c.Assert(create, IsTrue)
+	c.Assert(importData, IsFalse)
+	c.Assert(rc.cachedTables, HasLen, 1)
+}
+
+func (s *schemaFeaturesSuite) TestReadTableSchemasSkipsCreated(c *C) {
+	store, err := storage.NewLocalStorage(c.MkDir())
+	c.Assert(err, IsNil)
+	cfg := config.NewConfig()
+	rc := &RestoreController{
+		cfg:   cfg,
+		store: store,
+		dbMetas: []*mydump.MDDatabaseMeta{{
+			Name: "db",
+			Tables: []*mydump.MDTableMeta{
+				{DB: "db", Name: "created"},
+				{DB: "db", Name: "new"},
+			},
+		}},
+	}
+
+	schemas := rc.readTableSchemas(context.Background(), map[string]struct{}{"`db`.`created`": {}})
+	c.Assert(schemas, HasLen, 1)
+	c.Assert(schemas, HasKey, "`db`.`new`")
+}
+
+func (s *schemaFeaturesSuite) TestCacheTable(c *C) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defaultSQLMode, err := tmysql.GetSQLMode(tmysql.DefaultSQLMode)
+	c.Assert(err, IsNil)
+	rc := &RestoreController{
+		tidbMgr:      NewTiDBManagerWithDB(db, defaultSQLMode),
+		cachedTables: map[string]struct{}{"`db`.`cached`": {}},
+	}
+
+	mock.ExpectExec("\\QALTER TABLE `db`.`cached` CACHE\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	c.Assert(rc.cacheTable(ctx, "`db`.`other`", nil), IsNil)
+	importErr := errors.New("import failed")
+	c.Assert(rc.cacheTable(ctx, "`db`.`cached`", importErr), Equals, importErr)
+	c.Assert(rc.cacheTable(ctx, "`db`.`cached`", nil), IsNil)
+
+	rc.tidbMgr.Close()
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
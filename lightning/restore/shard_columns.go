@@ -0,0 +1,186 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+// shardColumn is a column in the schema file of a shard.
+type shardColumn struct {
+	name     string
+	nullable bool
+}
+
+// parseShardColumns returns the columns of the table in the schema file, in
+// the order of the definition.
+func parseShardColumns(p *parser.Parser, schema string) ([]shardColumn, error) {
+	schema, _ = extractSchemaFeatures(schema)
+	schema, _ = extractTTLOptions(schema)
+	stmts, _, err := p.Parse(schema, "", "")
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	for _, stmt := range stmts {
+		createTableNode, ok := stmt.(*ast.CreateTableStmt)
+		if !ok {
+			continue
+		}
+		columns := make([]shardColumn, 0, len(createTableNode.Cols))
+		for _, col := range createTableNode.Cols {
+			column := shardColumn{name: col.Name.Name.L, nullable: true}
+			for _, option := range col.Options {
+				switch option.Tp {
+				case ast.ColumnOptionNotNull, ast.ColumnOptionPrimaryKey:
+					column.nullable = false
+				}
+			}
+			columns = append(columns, column)
+		}
+		return columns, nil
+	}
+	return nil, errors.New("no CREATE TABLE statement found")
+}
+
+// shardColumnPermutations computes the column permutations of the data files
+// of the shards whose schema files define the columns in a different order
+// from the table. The columns of such data files are matched to the table by
+// name rather than by position. The columns missing from the shard are filled
+// with the default values, and the extra nullable columns of the shard are
+// discarded. The result maps the path of the data files to the permutations.
+func (t *TableRestore) shardColumnPermutations(ctx context.Context, rc *RestoreController) (map[string][]int, error) {
+	if len(t.tableMeta.ShardDataFiles) == 0 {
+		return nil, nil
+	}
+
+	p := parser.New()
+	p.SetSQLMode(rc.cfg.TiDB.SQLMode)
+
+	// shardPerms maps the path of the schema files to the permutations, which
+	// is nil if the shard is consistent with the table.
+	shardPerms := make(map[string][]int)
+	perms := make(map[string][]int)
+	for _, dataFile := range t.tableMeta.DataFiles {
+		// the columns of the parquet, avro, orc, arrow, json, fixed-width, bson
+		// and protobuf files are always named.
+		switch dataFile.FileMeta.Type {
+		case mydump.SourceTypeParquet, mydump.SourceTypeAvro, mydump.SourceTypeORC, mydump.SourceTypeArrow,
+			mydump.SourceTypeJSON, mydump.SourceTypeFixedWidth, mydump.SourceTypeBSON, mydump.SourceTypeMongoExport,
+			mydump.SourceTypeProtobuf:
+			continue
+		}
+		schemaFile, ok := t.tableMeta.ShardDataFiles[dataFile.FileMeta.Path]
+		if !ok {
+			continue
+		}
+		perm, ok := shardPerms[schemaFile.FileMeta.Path]
+		if !ok {
+			schema, err := t.tableMeta.ReadSchemaFile(ctx, rc.store, schemaFile)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			columns, err := parseShardColumns(p, schema)
+			if err != nil {
+				return nil, errors.Annotatef(err, "failed to parse the schema file %s", schemaFile.FileMeta.Path)
+			}
+			perm, err = t.reconcileShardColumns(rc.cfg, schemaFile.FileMeta.Path, columns)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			shardPerms[schemaFile.FileMeta.Path] = perm
+		}
+		if perm != nil {
+			perms[dataFile.FileMeta.Path] = perm
+		}
+	}
+	return perms, nil
+}
+
+// reconcileShardColumns computes the column permutation of the shard with the
+// columns defined in the schema file, and logs the mapping of the columns. It
+// returns nil if the columns are identical to the table.
+func (t *TableRestore) reconcileShardColumns(cfg *config.Config, schemaPath string, columns []shardColumn) ([]int, error) {
+	tableColumns := t.tableInfo.Core.Columns
+	identical := len(columns) == len(tableColumns)
+	shardColumnMap := make(map[string]int, len(columns))
+	for i, col := range columns {
+		shardColumnMap[col.name] = i
+		if identical && tableColumns[i].Name.L != col.name {
+			identical = false
+		}
+	}
+	if identical {
+		return nil, nil
+	}
+
+	tableColumnMap := make(map[string]struct{}, len(tableColumns))
+	for _, col := range tableColumns {
+		tableColumnMap[col.Name.L] = struct{}{}
+	}
+	var discarded []string
+	for _, col := range columns {
+		if _, ok := tableColumnMap[col.name]; ok {
+			continue
+		}
+		if !col.nullable {
+			return nil, errors.Errorf("column `%s` of the schema file %s is not nullable and missing from table %s",
+				col.name, schemaPath, t.tableName)
+		}
+		discarded = append(discarded, col.name)
+	}
+	// the TiDB backend writes every field of the rows.
+	if len(discarded) > 0 && cfg.TikvImporter.Backend == config.BackendTiDB {
+		return nil, errors.Errorf("columns %v of the schema file %s are missing from table %s, which is not supported by the TiDB backend",
+			discarded, schemaPath, t.tableName)
+	}
+
+	perm := make([]int, 0, len(tableColumns)+1)
+	mapping := make([]string, 0, len(tableColumns))
+	var missing []string
+	for _, col := range tableColumns {
+		if i, ok := shardColumnMap[col.Name.L]; ok {
+			perm = append(perm, i)
+			mapping = append(mapping, fmt.Sprintf("%s=#%d", col.Name.O, i))
+		} else {
+			perm = append(perm, -1)
+			missing = append(missing, col.Name.O)
+		}
+	}
+	if common.TableHasAutoRowID(t.tableInfo.Core) {
+		perm = append(perm, -1)
+	}
+
+	for _, col := range missing {
+		common.RecordWarning(t.tableName, common.WarningMissingColumn, fmt.Sprintf(
+			"column `%s` missing from the schema file %s, going to fill with default value", col, schemaPath,
+		))
+	}
+	t.logger.Info("reconcile columns of shard by name",
+		zap.String("schemaFile", schemaPath),
+		zap.Strings("mapping", mapping),
+		zap.Strings("missing", missing),
+		zap.Strings("discarded", discarded),
+	)
+	return perm, nil
+}
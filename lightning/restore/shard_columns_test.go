@@ -0,0 +1,174 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser"
+	"github.com/pingcap/parser/ast"
+	"github.com/pingcap/parser/model"
+	router "github.com/pingcap/tidb-tools/pkg/table-router"
+	"github.com/pingcap/tidb/ddl"
+	tmock "github.com/pingcap/tidb/util/mock"
+
+	"github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+	"github.com/pingcap/tidb-lightning/lightning/worker"
+)
+
+var _ = Suite(&shardColumnsSuite{})
+
+type shardColumnsSuite struct{}
+
+func (s *shardColumnsSuite) newTableRestore(c *C, tblMeta *mydump.MDTableMeta, createStmt string) *TableRestore {
+	node, err := parser.New().ParseOneStmt(createStmt, "utf8mb4", "utf8mb4_bin")
+	c.Assert(err, IsNil)
+	core, err := ddl.MockTableInfo(tmock.NewContext(), node.(*ast.CreateTableStmt), 1)
+	c.Assert(err, IsNil)
+	core.State = model.StatePublic
+	tableInfo := &TidbTableInfo{Name: "t", Core: core}
+	dbInfo := &TidbDBInfo{Name: "db", Tables: map[string]*TidbTableInfo{"t": tableInfo}}
+	tr, err := NewTableRestore("`db`.`t`", tblMeta, dbInfo, tableInfo, &checkpoints.TableCheckpoint{})
+	c.Assert(err, IsNil)
+	return tr
+}
+
+func (s *shardColumnsSuite) TestParseShardColumns(c *C) {
+	columns, err := parseShardColumns(parser.New(), "CREATE TABLE t (A INT PRIMARY KEY, b INT NOT NULL, c TEXT) TTL = `c` + INTERVAL 1 DAY;")
+	c.Assert(err, IsNil)
+	c.Assert(columns, DeepEquals, []shardColumn{
+		{name: "a", nullable: false},
+		{name: "b", nullable: false},
+		{name: "c", nullable: true},
+	})
+
+	_, err = parseShardColumns(parser.New(), "CREATE DATABASE db;")
+	c.Assert(err, ErrorMatches, "no CREATE TABLE statement found")
+}
+
+func (s *shardColumnsSuite) TestShardColumnPermutations(c *C) {
+	dir := c.MkDir()
+	files := map[string]string{
+		"s1-schema-create.sql": "CREATE DATABASE s1;",
+		"s1.t-schema.sql":      "CREATE TABLE t (a INT, b INT);",
+		"s1.t.1.sql":           "INSERT INTO t VALUES (1, 2);",
+		"s2.t-schema.sql":      "CREATE TABLE t (a INT, b INT);",
+		"s2.t.1.sql":           "INSERT INTO t VALUES (1, 2);",
+		"s3.t-schema.sql":      "CREATE TABLE t (b INT, a INT);",
+		"s3.t.1.sql":           "INSERT INTO t VALUES (2, 1);",
+		"s3.t.2.sql":           "INSERT INTO t VALUES (2, 1);",
+		"s4.t-schema.sql":      "CREATE TABLE t (a INT, c INT, b INT);",
+		"s4.t.1.sql":           "INSERT INTO t VALUES (1, 3, 2);",
+		"s5.t-schema.sql":      "CREATE TABLE t (b INT);",
+		"s5.t.1.sql":           "INSERT INTO t VALUES (2);",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = dir
+	cfg.Routes = []*router.TableRule{{SchemaPattern: "s*", TablePattern: "t", TargetSchema: "db", TargetTable: "t"}}
+	ctx := context.Background()
+	loader, err := mydump.NewMyDumpLoader(ctx, cfg)
+	c.Assert(err, IsNil)
+	var tblMeta *mydump.MDTableMeta
+	for _, dbMeta := range loader.GetDatabases() {
+		if dbMeta.Name == "db" {
+			c.Assert(dbMeta.Tables, HasLen, 1)
+			tblMeta = dbMeta.Tables[0]
+		}
+	}
+	c.Assert(tblMeta, NotNil)
+	c.Assert(tblMeta.ShardDataFiles, HasLen, 5)
+
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+
+	rc := &RestoreController{cfg: cfg, store: loader.GetStore()}
+	tr := s.newTableRestore(c, tblMeta, "CREATE TABLE t (a INT, b INT)")
+	perms, err := tr.shardColumnPermutations(ctx, rc)
+	c.Assert(err, IsNil)
+	// the last column is the _tidb_rowid.
+	c.Assert(perms, DeepEquals, map[string][]int{
+		"s3.t.1.sql": {1, 0, -1},
+		"s3.t.2.sql": {1, 0, -1},
+		"s4.t.1.sql": {0, 2, -1},
+		"s5.t.1.sql": {-1, 0, -1},
+	})
+
+	var missing []string
+	for _, w := range common.Warnings.Summary() {
+		if w.Kind == common.WarningMissingColumn {
+			missing = append(missing, w.Message)
+		}
+	}
+	c.Assert(missing, DeepEquals, []string{
+		"column `a` missing from the schema file s5.t-schema.sql, going to fill with default value",
+	})
+
+	// the chunks of the shards are populated with the permutations.
+	cp := &checkpoints.TableCheckpoint{Engines: make(map[int32]*checkpoints.EngineCheckpoint)}
+	rc.ioWorkers = worker.NewPool(ctx, 1, "io")
+	err = tr.populateChunks(ctx, rc, cp)
+	c.Assert(err, IsNil)
+	colPerms := make(map[string][]int)
+	for _, engine := range cp.Engines {
+		for _, chunk := range engine.Chunks {
+			colPerms[chunk.Key.Path] = chunk.ColumnPermutation
+		}
+	}
+	c.Assert(colPerms, DeepEquals, map[string][]int{
+		"s1.t.1.sql": nil,
+		"s2.t.1.sql": nil,
+		"s3.t.1.sql": {1, 0, -1},
+		"s3.t.2.sql": {1, 0, -1},
+		"s4.t.1.sql": {0, 2, -1},
+		"s5.t.1.sql": {-1, 0, -1},
+	})
+}
+
+func (s *shardColumnsSuite) TestReconcileShardColumnsFailure(c *C) {
+	tr := s.newTableRestore(c, &mydump.MDTableMeta{}, "CREATE TABLE t (a INT, b INT)")
+	cfg := config.NewConfig()
+
+	_, err := tr.reconcileShardColumns(cfg, "s.t-schema.sql", []shardColumn{
+		{name: "a", nullable: true},
+		{name: "c", nullable: false},
+		{name: "b", nullable: true},
+	})
+	c.Assert(err, ErrorMatches, "column `c` of the schema file s.t-schema.sql is not nullable and missing from table `db`.`t`")
+
+	cfg.TikvImporter.Backend = config.BackendTiDB
+	_, err = tr.reconcileShardColumns(cfg, "s.t-schema.sql", []shardColumn{
+		{name: "a", nullable: true},
+		{name: "c", nullable: true},
+		{name: "b", nullable: true},
+	})
+	c.Assert(err, ErrorMatches, `columns \[c\] of the schema file s.t-schema.sql are missing from table .*, which is not supported by the TiDB backend`)
+
+	perm, err := tr.reconcileShardColumns(cfg, "s.t-schema.sql", []shardColumn{
+		{name: "a", nullable: true},
+		{name: "b", nullable: true},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(perm, IsNil)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+// verifyShardSchemas checks the schema files of the shards routed into the
+// table are equivalent to the schema the table is created with, after
+// normalizing the table name and the formatting. The files are read ahead
+// concurrently, and the identical files are only parsed once, see
+// TiDBManager.schemaCache.
+func (rc *RestoreController) verifyShardSchemas(
+	ctx context.Context,
+	tidbMgr *TiDBManager,
+	tblMeta *mydump.MDTableMeta,
+	schema string,
+) error {
+	files := tblMeta.ShardSchemaFiles
+	if len(files) == 0 {
+		return nil
+	}
+	tableName := common.UniqueTable(tblMeta.DB, tblMeta.Name)
+	expected, err := tidbMgr.createTableIfNotExistsStmt(schema, tblMeta.Name)
+	if err != nil {
+		// the error is reported when creating the table.
+		return nil
+	}
+
+	shardSchemas := make([]string, len(files))
+	eg, egCtx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, rc.cfg.App.IOConcurrency)
+	for i, file := range files {
+		i, file := i, file
+		sem <- struct{}{}
+		eg.Go(func() error {
+			defer func() { <-sem }()
+			shardSchema, err := tblMeta.ReadSchemaFile(egCtx, rc.store, file)
+			shardSchemas[i] = shardSchema
+			return err
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// normalized maps the content of the schema files to the normalized
+	// statements.
+	normalized := map[string]string{schema: expected}
+	drifted := 0
+	for i, shardSchema := range shardSchemas {
+		stmt, ok := normalized[shardSchema]
+		if !ok {
+			stmt, err = tidbMgr.createTableIfNotExistsStmt(shardSchema, tblMeta.Name)
+			if err != nil {
+				stmt = ""
+			}
+			normalized[shardSchema] = stmt
+		}
+		if stmt != expected {
+			drifted++
+			common.RecordWarning(tableName, common.WarningSchemaDrift, fmt.Sprintf(
+				"the schema file %s differs from %s which the table is created with",
+				files[i].FileMeta.Path, tblMeta.SchemaFile.FileMeta.Path,
+			))
+		}
+	}
+
+	logger := log.With(zap.String("table", tableName))
+	fields := []zap.Field{
+		zap.Int("shards", len(files)+1),
+		zap.Int("distinctSchemas", len(normalized)),
+		zap.Int("drifted", drifted),
+	}
+	if drifted > 0 {
+		logger.Warn("schemas of shards are not equivalent", fields...)
+	} else {
+		logger.Info("schemas of shards are equivalent", fields...)
+	}
+	return nil
+}
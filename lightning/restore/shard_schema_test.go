@@ -0,0 +1,104 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+	tmysql "github.com/pingcap/parser/mysql"
+	router "github.com/pingcap/tidb-tools/pkg/table-router"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/mydump"
+)
+
+var _ = Suite(&shardSchemaSuite{})
+
+type shardSchemaSuite struct{}
+
+func (s *shardSchemaSuite) TestVerifyShardSchemas(c *C) {
+	dir := c.MkDir()
+	files := map[string]string{
+		"s1-schema-create.sql": "CREATE DATABASE s1;",
+		"s1.t-schema.sql":      "CREATE TABLE `s1`.`t` (a INT);",
+		"s2.t-schema.sql":      "CREATE TABLE `s2`.`t` (a INT);",
+		"s3.t-schema.sql":      "/* formatted differently */ create table t(\n  a int\n);",
+		"s4.t-schema.sql":      "CREATE TABLE `s4`.`t` (a BIGINT);",
+		"s5.t-schema.sql":      "CREATE TABLE `s4`.`t` (a BIGINT);",
+	}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		c.Assert(err, IsNil)
+	}
+
+	cfg := config.NewConfig()
+	cfg.Mydumper.SourceDir = dir
+	cfg.App.IOConcurrency = 2
+	cfg.Routes = []*router.TableRule{{SchemaPattern: "s*", TablePattern: "t", TargetSchema: "db", TargetTable: "t"}}
+	ctx := context.Background()
+	loader, err := mydump.NewMyDumpLoader(ctx, cfg)
+	c.Assert(err, IsNil)
+	var tblMeta *mydump.MDTableMeta
+	for _, dbMeta := range loader.GetDatabases() {
+		if dbMeta.Name == "db" {
+			c.Assert(dbMeta.Tables, HasLen, 1)
+			tblMeta = dbMeta.Tables[0]
+		}
+	}
+	c.Assert(tblMeta, NotNil)
+	c.Assert(tblMeta.SchemaFile.FileMeta.Path, Equals, "s1.t-schema.sql")
+	c.Assert(tblMeta.ShardSchemaFiles, HasLen, 4)
+
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defaultSQLMode, err := tmysql.GetSQLMode(tmysql.DefaultSQLMode)
+	c.Assert(err, IsNil)
+	tidbMgr := NewTiDBManagerWithDB(db, defaultSQLMode)
+	mock.ExpectClose()
+	defer func() {
+		tidbMgr.Close()
+		c.Assert(mock.ExpectationsWereMet(), IsNil)
+	}()
+
+	rc := &RestoreController{cfg: cfg, store: loader.GetStore()}
+
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+	err = rc.verifyShardSchemas(ctx, tidbMgr, tblMeta, tblMeta.GetSchema(ctx, rc.store))
+	c.Assert(err, IsNil)
+
+	var drifted []string
+	for _, w := range common.Warnings.Summary() {
+		if w.Kind == common.WarningSchemaDrift {
+			c.Assert(w.Table, Equals, "`db`.`t`")
+			drifted = append(drifted, w.Message)
+		}
+	}
+	c.Assert(drifted, DeepEquals, []string{
+		"the schema file s4.t-schema.sql differs from s1.t-schema.sql which the table is created with",
+		"the schema file s5.t-schema.sql differs from s1.t-schema.sql which the table is created with",
+	})
+
+	// the missing schema files fail the verification.
+	missing := tblMeta.ShardSchemaFiles[0]
+	missing.FileMeta.Path = "s6.t-schema.sql"
+	tblMeta.ShardSchemaFiles = append(tblMeta.ShardSchemaFiles, missing)
+	err = rc.verifyShardSchemas(ctx, tidbMgr, tblMeta, tblMeta.GetSchema(ctx, rc.store))
+	c.Assert(err, ErrorMatches, ".*failed to extract table schema from s6.t-schema.sql.*")
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pingcap/errors"
+	"github.com/pingcap/parser/model"
+	"go.uber.org/zap"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+const (
+	// stagingRuleIDPrefix prefixes the IDs of the placement rules of the
+	// staging stores, which are followed by the table ID and the index of
+	// the physical table.
+	stagingRuleIDPrefix = "lightning-staging-"
+	// stagingRuleIndex orders the rules after the default rule of PD, whose
+	// index is 0, so they override it in the key ranges of the tables.
+	stagingRuleIndex = 100
+)
+
+// stagingStores places the regions of the tables being imported on the TiKV
+// stores of `tikv-importer.staging-store-labels`, by the placement rules of
+// PD overriding the default rule in the key ranges of the tables. The rules
+// are deleted after the tables are imported, so PD moves the regions back to
+// all stores at its own pace, and the stores serving the other tables are not
+// affected by the burst of ingesting the SSTs.
+type stagingStores struct {
+	logger   log.Logger
+	tls      *common.TLS
+	labels   map[string]string
+	replicas int
+
+	mu sync.Mutex
+	// rules is the IDs of the rules added and not deleted yet, including
+	// those left by the previous run.
+	rules map[string]struct{}
+}
+
+// newStagingStores returns nil if the staging stores are not configured. The
+// placement rules must be enabled in PD, and the staging stores must be
+// enough for all replicas of the regions.
+func newStagingStores(ctx context.Context, logger log.Logger, tls *common.TLS, cfg *config.Config) (*stagingStores, error) {
+	if len(cfg.TikvImporter.StagingStoreLabels) == 0 {
+		return nil, nil
+	}
+	tls = tls.WithHost(cfg.TiDB.PdAddr)
+	replicateCfg, err := kv.GetReplicateConfig(tls)
+	if err != nil {
+		return nil, errors.Annotate(err, "get the replication config of PD")
+	}
+	if !replicateCfg.PlacementRulesEnabled() {
+		return nil, errors.New("`tikv-importer.staging-store-labels` requires the placement rules enabled in PD")
+	}
+
+	labels := cfg.TikvImporter.StagingStoreLabels
+	var matched int32
+	err = kv.ForAllStores(ctx, tls, kv.StoreStateUp, func(_ context.Context, store *kv.Store) error {
+		if store.HasLabels(labels) {
+			atomic.AddInt32(&matched, 1)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Annotate(err, "get the stores of PD")
+	}
+	if int(matched) < replicateCfg.MaxReplicas {
+		return nil, errors.Errorf("only %d stores up with the labels of `tikv-importer.staging-store-labels`, fewer than the %d replicas",
+			matched, replicateCfg.MaxReplicas)
+	}
+
+	s := &stagingStores{
+		logger:   logger,
+		tls:      tls,
+		labels:   labels,
+		replicas: replicateCfg.MaxReplicas,
+		rules:    make(map[string]struct{}),
+	}
+	// the rules left by the previous run are deleted with the tables, or
+	// when the tables are found imported.
+	rules, err := kv.GetPlacementRules(tls, kv.PlacementRuleGroup)
+	if err != nil {
+		return nil, errors.Annotate(err, "get the placement rules of PD")
+	}
+	for _, rule := range rules {
+		if strings.HasPrefix(rule.ID, stagingRuleIDPrefix) {
+			s.rules[rule.ID] = struct{}{}
+		}
+	}
+	logger.Info("import to the staging stores",
+		zap.Any("labels", labels),
+		zap.Int32("stores", matched),
+		zap.Int("staleRules", len(s.rules)),
+	)
+	return s, nil
+}
+
+// tableRules returns the placement rules of the physical tables of the table.
+func (s *stagingStores) tableRules(tableInfo *model.TableInfo) []*kv.PlacementRule {
+	keys := make([]string, 0, len(s.labels))
+	for key := range s.labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	constraints := make([]kv.LabelConstraint, 0, len(keys))
+	for _, key := range keys {
+		constraints = append(constraints, kv.LabelConstraint{Key: key, Op: "in", Values: []string{s.labels[key]}})
+	}
+
+	ranges := tableKeyRanges(tableInfo)
+	rules := make([]*kv.PlacementRule, 0, len(ranges))
+	for i, keyRange := range ranges {
+		rules = append(rules, &kv.PlacementRule{
+			GroupID:          kv.PlacementRuleGroup,
+			ID:               fmt.Sprintf("%s%d-%d", stagingRuleIDPrefix, tableInfo.ID, i),
+			Index:            stagingRuleIndex,
+			Override:         true,
+			StartKeyHex:      hex.EncodeToString(keyRange.Start),
+			EndKeyHex:        hex.EncodeToString(keyRange.End),
+			Role:             "voter",
+			Count:            s.replicas,
+			LabelConstraints: constraints,
+		})
+	}
+	return rules
+}
+
+// place adds the placement rules of the table before it is imported.
+func (s *stagingStores) place(tableName string, tableInfo *model.TableInfo) error {
+	if s == nil {
+		return nil
+	}
+	for _, rule := range s.tableRules(tableInfo) {
+		if err := kv.SetPlacementRule(s.tls, rule); err != nil {
+			return errors.Annotatef(err, "place the table %s on the staging stores", tableName)
+		}
+		s.mu.Lock()
+		s.rules[rule.ID] = struct{}{}
+		s.mu.Unlock()
+	}
+	s.logger.Info("placed the table on the staging stores", zap.String("table", tableName))
+	return nil
+}
+
+// release deletes the placement rules of the table, if any, so PD moves its
+// regions back to all stores. The failure is not fatal and only reported as
+// a warning, since the rule can be deleted manually.
+func (s *stagingStores) release(tableName string, tableInfo *model.TableInfo) {
+	if s == nil {
+		return
+	}
+	for _, rule := range s.tableRules(tableInfo) {
+		s.mu.Lock()
+		_, ok := s.rules[rule.ID]
+		s.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if err := kv.DeletePlacementRule(s.tls, rule.GroupID, rule.ID); err != nil {
+			s.logger.Warn("release the table from the staging stores failed",
+				zap.String("table", tableName), zap.String("rule", rule.ID), log.ShortError(err))
+			common.RecordWarning(tableName, common.WarningStagingRule,
+				fmt.Sprintf("delete the placement rule '%s/%s' failed, delete it manually by pd-ctl: %s", rule.GroupID, rule.ID, err))
+			continue
+		}
+		s.mu.Lock()
+		delete(s.rules, rule.ID)
+		s.mu.Unlock()
+		s.logger.Info("released the table from the staging stores", zap.String("table", tableName), zap.String("rule", rule.ID))
+	}
+}
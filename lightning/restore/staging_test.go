@@ -0,0 +1,200 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var _ = Suite(&stagingSuite{})
+
+type stagingSuite struct{}
+
+// fakePD serves the stores, the replication config and the placement rules.
+type fakePD struct {
+	c              *C
+	rulesEnabled   bool
+	importStores   int
+	mu             sync.Mutex
+	rules          map[string]*kv.PlacementRule
+	failDeleteRule bool
+}
+
+func (f *fakePD) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var resp interface{}
+	switch {
+	case req.URL.Path == "/pd/api/v1/config/replicate":
+		enabled := "false"
+		if f.rulesEnabled {
+			enabled = "true"
+		}
+		resp = map[string]interface{}{"max-replicas": 3, "enable-placement-rules": enabled}
+	case req.URL.Path == "/pd/api/v1/stores":
+		stores := []map[string]interface{}{
+			{"store": map[string]interface{}{"address": "tikv0:20160", "state_name": "Up"}},
+		}
+		for i := 0; i < f.importStores; i++ {
+			stores = append(stores, map[string]interface{}{"store": map[string]interface{}{
+				"address":    "tikv-import:20160",
+				"state_name": "Up",
+				"labels":     []map[string]string{{"key": "zone", "value": "z1"}, {"key": "role", "value": "import"}},
+			}})
+		}
+		stores = append(stores, map[string]interface{}{"store": map[string]interface{}{
+			"address":    "tikv-offline:20160",
+			"state_name": "Offline",
+			"labels":     []map[string]string{{"key": "role", "value": "import"}},
+		}})
+		resp = map[string]interface{}{"stores": stores}
+	case req.URL.Path == "/pd/api/v1/config/rules/group/pd":
+		rules := make([]*kv.PlacementRule, 0, len(f.rules))
+		for _, rule := range f.rules {
+			rules = append(rules, rule)
+		}
+		resp = rules
+	case req.URL.Path == "/pd/api/v1/config/rule" && req.Method == http.MethodPost:
+		var rule kv.PlacementRule
+		f.c.Assert(json.NewDecoder(req.Body).Decode(&rule), IsNil)
+		f.rules[rule.ID] = &rule
+		return
+	case strings.HasPrefix(req.URL.Path, "/pd/api/v1/config/rule/pd/") && req.Method == http.MethodDelete:
+		if f.failDeleteRule {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		delete(f.rules, strings.TrimPrefix(req.URL.Path, "/pd/api/v1/config/rule/pd/"))
+		return
+	default:
+		f.c.Fatalf("unexpected request %s %s", req.Method, req.URL.Path)
+	}
+	f.c.Assert(json.NewEncoder(w).Encode(resp), IsNil)
+}
+
+func (f *fakePD) ruleIDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := make([]string, 0, len(f.rules))
+	for id := range f.rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (s *stagingSuite) newStagingStores(c *C, server *httptest.Server) (*stagingStores, error) {
+	u, err := url.Parse(server.URL)
+	c.Assert(err, IsNil)
+	cfg := config.NewConfig()
+	cfg.TiDB.PdAddr = u.Host
+	cfg.TikvImporter.StagingStoreLabels = map[string]string{"role": "import"}
+	return newStagingStores(context.Background(), log.L(), common.NewTLSFromMockServer(server), cfg)
+}
+
+func (s *stagingSuite) TestNotConfigured(c *C) {
+	staging, err := newStagingStores(context.Background(), log.L(), nil, config.NewConfig())
+	c.Assert(err, IsNil)
+	c.Assert(staging, IsNil)
+	c.Assert(staging.place("`db`.`t`", &model.TableInfo{ID: 100}), IsNil)
+	staging.release("`db`.`t`", &model.TableInfo{ID: 100})
+}
+
+func (s *stagingSuite) TestRequirements(c *C) {
+	pd := &fakePD{c: c, importStores: 3}
+	server := httptest.NewTLSServer(pd)
+	defer server.Close()
+	_, err := s.newStagingStores(c, server)
+	c.Assert(err, ErrorMatches, "`tikv-importer.staging-store-labels` requires the placement rules enabled in PD")
+
+	pd.rulesEnabled = true
+	pd.importStores = 2
+	_, err = s.newStagingStores(c, server)
+	c.Assert(err, ErrorMatches, "only 2 stores up with the labels of `tikv-importer.staging-store-labels`, fewer than the 3 replicas")
+}
+
+func (s *stagingSuite) TestPlaceAndRelease(c *C) {
+	common.Warnings.Reset()
+	defer common.Warnings.Reset()
+
+	pd := &fakePD{c: c, rulesEnabled: true, importStores: 3, rules: map[string]*kv.PlacementRule{
+		"default":                  {GroupID: "pd", ID: "default"},
+		"lightning-staging-90-0":   {GroupID: "pd", ID: "lightning-staging-90-0"},
+		"lightning-staging-9999-0": {GroupID: "pd", ID: "lightning-staging-9999-0"},
+	}}
+	server := httptest.NewTLSServer(pd)
+	defer server.Close()
+	staging, err := s.newStagingStores(c, server)
+	c.Assert(err, IsNil)
+	c.Assert(staging.replicas, Equals, 3)
+
+	tableInfo := &model.TableInfo{
+		ID: 100,
+		Partition: &model.PartitionInfo{
+			Enable:      true,
+			Definitions: []model.PartitionDefinition{{ID: 101}},
+		},
+	}
+	c.Assert(staging.place("`db`.`t`", tableInfo), IsNil)
+	c.Assert(pd.ruleIDs(), DeepEquals, []string{
+		"default",
+		"lightning-staging-100-0",
+		"lightning-staging-100-1",
+		"lightning-staging-90-0",
+		"lightning-staging-9999-0",
+	})
+	rule := pd.rules["lightning-staging-100-1"]
+	c.Assert(rule.Index, Equals, stagingRuleIndex)
+	c.Assert(rule.Override, IsTrue)
+	c.Assert(rule.Count, Equals, 3)
+	c.Assert(rule.LabelConstraints, DeepEquals, []kv.LabelConstraint{{Key: "role", Op: "in", Values: []string{"import"}}})
+	ranges := tableKeyRanges(tableInfo)
+	c.Assert(rule.StartKeyHex, Equals, hex.EncodeToString(ranges[1].Start))
+	c.Assert(rule.EndKeyHex, Equals, hex.EncodeToString(ranges[1].End))
+
+	staging.release("`db`.`t`", tableInfo)
+	// the stale rule of the previous run is released with the table.
+	staging.release("`db`.`u`", &model.TableInfo{ID: 90})
+	// the rule is not of this task.
+	staging.release("`db`.`v`", &model.TableInfo{ID: 80})
+	c.Assert(pd.ruleIDs(), DeepEquals, []string{"default", "lightning-staging-9999-0"})
+
+	pd.failDeleteRule = true
+	c.Assert(staging.place("`db`.`t`", tableInfo), IsNil)
+	staging.release("`db`.`t`", tableInfo)
+	warnings := common.Warnings.Summary()
+	c.Assert(warnings, HasLen, 2)
+	for _, warning := range warnings {
+		c.Assert(warning.Kind, Equals, common.WarningStagingRule)
+		c.Assert(warning.Message, Matches, "delete the placement rule 'pd/lightning-staging-100-.' failed, delete it manually by pd-ctl: .*")
+	}
+	c.Assert(pd.ruleIDs(), HasLen, 4)
+}
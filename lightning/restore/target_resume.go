@@ -0,0 +1,218 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+// importedTablesTable is the table in keyEncodingSchema on the target
+// recording the tables imported and checksummed, see resumeFromTarget.
+const importedTablesTable = "imported_tables"
+
+// importedTable is the record of a table imported and checksummed.
+type importedTable struct {
+	taskID     int64
+	sourceDir  string
+	rowCount   int64
+	totalKVs   uint64
+	totalBytes uint64
+	checksum   uint64
+}
+
+func importedTablesName() string {
+	return common.UniqueTable(keyEncodingSchema, importedTablesTable)
+}
+
+// recordedSourceDir returns the data sources of the task with the credentials
+// redacted, which the records on the target are compared by.
+func recordedSourceDir(cfg *config.Config) string {
+	dirs := cfg.Mydumper.DataSources()
+	redacted := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		redacted = append(redacted, config.RedactURL(dir))
+	}
+	return strings.Join(redacted, ",")
+}
+
+func countRows(ctx context.Context, db *sql.DB, tableName string) (int64, error) {
+	var rowCount int64
+	err := common.SQLWithRetry{DB: db, Logger: log.With(zap.String("table", tableName))}.
+		QueryRow(ctx, "count rows", "SELECT COUNT(*) FROM "+tableName, &rowCount)
+	return rowCount, errors.Trace(err)
+}
+
+// recordImportedTable records the table imported and checksummed on the target,
+// together with the row count and the checksum.
+func recordImportedTable(ctx context.Context, db *sql.DB, cfg *config.Config, tableName string, localChecksum *verify.KVChecksum) error {
+	rowCount, err := countRows(ctx, db, tableName)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	exec := common.SQLWithRetry{DB: db, Logger: log.With(zap.String("table", tableName))}
+	var createDatabase strings.Builder
+	createDatabase.WriteString("CREATE DATABASE IF NOT EXISTS ")
+	common.WriteMySQLIdentifier(&createDatabase, keyEncodingSchema)
+	if err := exec.Exec(ctx, "create task info schema", createDatabase.String()); err != nil {
+		return errors.Trace(err)
+	}
+	err = exec.Exec(ctx, "create imported tables table", fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s (
+			table_name varchar(261) NOT NULL PRIMARY KEY,
+			task_id bigint NOT NULL,
+			source_dir text NOT NULL,
+			row_count bigint NOT NULL,
+			total_kvs bigint unsigned NOT NULL,
+			total_bytes bigint unsigned NOT NULL,
+			checksum bigint unsigned NOT NULL,
+			update_time timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		);
+	`, importedTablesName()))
+	if err != nil {
+		return errors.Trace(err)
+	}
+	return exec.Exec(ctx, "record imported table", fmt.Sprintf(
+		"REPLACE INTO %s (table_name, task_id, source_dir, row_count, total_kvs, total_bytes, checksum) VALUES (?, ?, ?, ?, ?, ?, ?);",
+		importedTablesName()),
+		tableName, cfg.TaskID, recordedSourceDir(cfg), rowCount,
+		localChecksum.SumKVS(), localChecksum.SumSize(), localChecksum.Sum(),
+	)
+}
+
+// recordImported records the table after the checksum passed, so it can be
+// skipped by `checkpoint.resume-from-target` even if the checkpoints are lost.
+// The failure only makes the table imported again after such a disaster, so
+// it is not fatal.
+func (t *TableRestore) recordImported(ctx context.Context, rc *RestoreController, localChecksum *verify.KVChecksum) {
+	if !rc.cfg.Checkpoint.ResumeFromTarget {
+		return
+	}
+	task := t.logger.Begin(zap.InfoLevel, "record imported table on the target")
+	err := recordImportedTable(ctx, rc.tidbMgr.db, rc.cfg, t.tableName, localChecksum)
+	task.End(zap.WarnLevel, err)
+}
+
+// loadImportedTables returns the records of the tables imported and
+// checksummed, keyed by the unique table names. Nothing is recorded if the
+// table of the records does not exist.
+func loadImportedTables(ctx context.Context, db *sql.DB) (map[string]importedTable, error) {
+	records := make(map[string]importedTable)
+	exec := common.SQLWithRetry{DB: db, Logger: log.L()}
+	err := exec.Transact(ctx, "load imported tables", func(ctx context.Context, tx *sql.Tx) error {
+		var count int
+		err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?",
+			keyEncodingSchema, importedTablesTable,
+		).Scan(&count)
+		if err != nil || count == 0 {
+			return errors.Trace(err)
+		}
+
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(
+			"SELECT table_name, task_id, source_dir, row_count, total_kvs, total_bytes, checksum FROM %s",
+			importedTablesName()))
+		if err != nil {
+			return errors.Trace(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				tableName string
+				record    importedTable
+			)
+			if err := rows.Scan(&tableName, &record.taskID, &record.sourceDir, &record.rowCount,
+				&record.totalKVs, &record.totalBytes, &record.checksum); err != nil {
+				return errors.Trace(err)
+			}
+			records[tableName] = record
+		}
+		return errors.Trace(rows.Err())
+	})
+	return records, errors.Trace(err)
+}
+
+// resumeFromTarget reconstructs the progress of the tables without any
+// progress in the checkpoints, e.g. after the checkpoints are lost, from the
+// records on the target. The table recorded from the same data sources is
+// marked as checksummed if the rows on the target still match the recorded row
+// count, so only the other tables are imported again. The progress in the
+// checkpoints is always trusted over the records.
+func (rc *RestoreController) resumeFromTarget(ctx context.Context, db *sql.DB) error {
+	if !rc.cfg.Checkpoint.ResumeFromTarget {
+		return nil
+	}
+	records, err := loadImportedTables(ctx, db)
+	if err != nil || len(records) == 0 {
+		return errors.Annotate(err, "load imported tables from the target")
+	}
+
+	sourceDir := recordedSourceDir(rc.cfg)
+	diffs := make(map[string]*TableCheckpointDiff)
+	for _, dbInfo := range rc.dbInfos {
+		for _, tableInfo := range dbInfo.Tables {
+			tableName := common.UniqueTable(dbInfo.Name, tableInfo.Name)
+			record, ok := records[tableName]
+			if !ok {
+				continue
+			}
+			cp, err := rc.checkpointsDB.Get(ctx, tableName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if cp.Status > CheckpointStatusLoaded || len(cp.Engines) > 0 {
+				continue
+			}
+
+			logger := log.With(zap.String("table", tableName), zap.Int64("recordedTaskID", record.taskID))
+			if record.sourceDir != sourceDir {
+				logger.Warn("table is recorded from other data sources, going to import it again",
+					zap.String("recordedSourceDir", record.sourceDir))
+				continue
+			}
+			rowCount, err := countRows(ctx, db, tableName)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			if rowCount != record.rowCount {
+				logger.Warn("rows on the target differ from the record, going to import the table again",
+					zap.Int64("rows", rowCount), zap.Int64("recordedRows", record.rowCount))
+				continue
+			}
+
+			logger.Info("skip the table imported and checksummed before",
+				zap.Int64("rows", rowCount), zap.Uint64("totalKVs", record.totalKVs), zap.Uint64("checksum", record.checksum))
+			diff := NewTableCheckpointDiff()
+			merger := &StatusCheckpointMerger{EngineID: WholeTableEngineID, Status: CheckpointStatusChecksummed}
+			merger.MergeInto(diff)
+			diffs[tableName] = diff
+		}
+	}
+	if len(diffs) > 0 {
+		rc.checkpointsDB.Update(diffs)
+	}
+	return nil
+}
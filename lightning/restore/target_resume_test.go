@@ -0,0 +1,138 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/parser/model"
+
+	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	verify "github.com/pingcap/tidb-lightning/lightning/verification"
+)
+
+var _ = Suite(&targetResumeSuite{})
+
+type targetResumeSuite struct{}
+
+func (s *targetResumeSuite) TestRecordImportedTable(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 123
+	cfg.Mydumper.SourceDir = "s3://bucket/dump?secret-access-key=abc"
+	checksum := verify.MakeKVChecksum(300, 20, 12345)
+
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `db`\\.`t`").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(10))
+	mock.ExpectExec("CREATE DATABASE IF NOT EXISTS `lightning_task_info`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS `lightning_task_info`\\.`imported_tables`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("REPLACE INTO `lightning_task_info`\\.`imported_tables` .*").
+		WithArgs("`db`.`t`", int64(123), "s3://bucket/dump?secret-access-key=xxxxx", int64(10), int64(20), int64(300), int64(12345)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = recordImportedTable(context.Background(), db, cfg, "`db`.`t`", &checksum)
+	c.Assert(err, IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
+
+func (s *targetResumeSuite) TestResumeFromTarget(c *C) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+
+	cfg := config.NewConfig()
+	cfg.TaskID = 456
+	cfg.Mydumper.SourceDir = "file:///data"
+	cfg.Checkpoint.ResumeFromTarget = true
+
+	dbInfo := &TidbDBInfo{Name: "db", Tables: map[string]*TidbTableInfo{}}
+	for i, name := range []string{"intact", "changed", "moved", "unrecorded", "progressing"} {
+		dbInfo.Tables[name] = &TidbTableInfo{
+			ID:   int64(i + 1),
+			Name: name,
+			Core: &model.TableInfo{ID: int64(i + 1), Name: model.NewCIStr(name)},
+		}
+	}
+	dbInfos := map[string]*TidbDBInfo{"db": dbInfo}
+	cpdb, err := NewFileCheckpointsDB(filepath.Join(c.MkDir(), "cp.pb"))
+	c.Assert(err, IsNil)
+	defer cpdb.Close()
+	c.Assert(cpdb.Initialize(ctx, cfg, dbInfos), IsNil)
+
+	// the progress in the checkpoints is trusted over the records.
+	diff := NewTableCheckpointDiff()
+	(&StatusCheckpointMerger{EngineID: WholeTableEngineID, Status: CheckpointStatusAllWritten}).MergeInto(diff)
+	cpdb.Update(map[string]*TableCheckpointDiff{"`db`.`progressing`": diff})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM information_schema\\.TABLES .*").
+		WithArgs("lightning_task_info", "imported_tables").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(1))
+	mock.ExpectQuery("SELECT table_name, task_id, source_dir, row_count, total_kvs, total_bytes, checksum FROM `lightning_task_info`\\.`imported_tables`").
+		WillReturnRows(sqlmock.NewRows([]string{"table_name", "task_id", "source_dir", "row_count", "total_kvs", "total_bytes", "checksum"}).
+			AddRow("`db`.`intact`", 123, "file:///data", 10, 20, 300, 12345).
+			AddRow("`db`.`changed`", 123, "file:///data", 10, 20, 300, 12345).
+			AddRow("`db`.`moved`", 123, "file:///other", 10, 20, 300, 12345).
+			AddRow("`db`.`progressing`", 123, "file:///data", 10, 20, 300, 12345).
+			AddRow("`other`.`dropped`", 123, "file:///data", 10, 20, 300, 12345))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `db`\\.`intact`").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(10))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM `db`\\.`changed`").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(7))
+
+	rc := &RestoreController{cfg: cfg, dbInfos: dbInfos, checkpointsDB: cpdb}
+	c.Assert(rc.resumeFromTarget(ctx, db), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+
+	for name, status := range map[string]CheckpointStatus{
+		"intact":      CheckpointStatusChecksummed,
+		"changed":     CheckpointStatusLoaded,
+		"moved":       CheckpointStatusLoaded,
+		"unrecorded":  CheckpointStatusLoaded,
+		"progressing": CheckpointStatusAllWritten,
+	} {
+		cp, err := cpdb.Get(ctx, common.UniqueTable("db", name))
+		c.Assert(err, IsNil)
+		c.Assert(cp.Status, Equals, status, Commentf("table %s", name))
+	}
+}
+
+func (s *targetResumeSuite) TestResumeFromTargetNotRecorded(c *C) {
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	cfg := config.NewConfig()
+	cfg.Checkpoint.ResumeFromTarget = true
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM information_schema\\.TABLES .*").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(0))
+	mock.ExpectCommit()
+
+	rc := &RestoreController{cfg: cfg}
+	c.Assert(rc.resumeFromTarget(context.Background(), db), IsNil)
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
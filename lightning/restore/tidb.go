@@ -17,8 +17,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 
 	tmysql "github.com/go-sql-driver/mysql"
 	"github.com/pingcap/errors"
@@ -29,6 +31,7 @@ import (
 	"github.com/pingcap/parser/mysql"
 	"github.com/pingcap/parser/terror"
 
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
 	. "github.com/pingcap/tidb-lightning/lightning/checkpoints"
 	"github.com/pingcap/tidb-lightning/lightning/common"
 	"github.com/pingcap/tidb-lightning/lightning/config"
@@ -37,11 +40,26 @@ import (
 	"github.com/pingcap/tidb-lightning/lightning/mydump"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 type TiDBManager struct {
-	db     *sql.DB
-	parser *parser.Parser
+	db      *sql.DB
+	parser  *parser.Parser
+	sqlMode mysql.SQLMode
+	// schemaConcurrency is the number of the table schemas rewritten
+	// concurrently by InitSchema.
+	schemaConcurrency int
+	// schemaCache maps the schemaCacheKey to the rewritten statements, so the
+	// identical schemas of the shards are only parsed once.
+	schemaCache sync.Map
+}
+
+// schemaCacheKey identifies a rewritten schema. The table name is a part of
+// the key since it is substituted into the statements.
+type schemaCacheKey struct {
+	schema    string
+	tableName string
 }
 
 // getSQLErrCode returns error code if err is a mysql error
@@ -82,6 +100,9 @@ func NewTiDBManager(dsn config.DBStore, tls *common.TLS) (*TiDBManager, error) {
 			"allow_auto_random_explicit_insert": "1",
 		},
 	}
+	if dsn.TargetVersion != nil && !kv.NewCompat(*dsn.TargetVersion).AutoRandomExplicitInsert {
+		delete(param.Vars, "allow_auto_random_explicit_insert")
+	}
 	db, err := param.Connect()
 	if err != nil {
 		if isUnknownSystemVariableErr(err) {
@@ -106,8 +127,10 @@ func NewTiDBManagerWithDB(db *sql.DB, sqlMode mysql.SQLMode) *TiDBManager {
 	parser.SetSQLMode(sqlMode)
 
 	return &TiDBManager{
-		db:     db,
-		parser: parser,
+		db:                db,
+		parser:            parser,
+		sqlMode:           sqlMode,
+		schemaConcurrency: runtime.NumCPU(),
 	}
 }
 
@@ -115,7 +138,14 @@ func (timgr *TiDBManager) Close() {
 	timgr.db.Close()
 }
 
-func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, tablesSchema map[string]string) error {
+// InitSchema creates the database and the tables in it. The optional onCreated
+// callback is invoked after each table is created.
+func (timgr *TiDBManager) InitSchema(
+	ctx context.Context,
+	database string,
+	tablesSchema map[string]string,
+	onCreated func(tableName string) error,
+) error {
 	sql := common.SQLWithRetry{
 		DB:     timgr.db,
 		Logger: log.With(zap.String("db", database)),
@@ -136,14 +166,15 @@ func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, table
 		return errors.Trace(err)
 	}
 
+	createTables, err := timgr.splitTableSchemas(ctx, tablesSchema)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
 	task := sql.Logger.Begin(zap.InfoLevel, "create tables")
-	for tbl, sqlCreateTable := range tablesSchema {
+	for tbl, sqlCreateTable := range createTables {
 		task.Debug("create table", zap.String("schema", sqlCreateTable))
 
-		sqlCreateTable, err = timgr.createTableIfNotExistsStmt(sqlCreateTable, tbl)
-		if err != nil {
-			break
-		}
 		sql2 := common.SQLWithRetry{
 			DB:           timgr.db,
 			Logger:       sql.Logger.With(zap.String("table", common.UniqueTable(database, tbl))),
@@ -153,14 +184,93 @@ func (timgr *TiDBManager) InitSchema(ctx context.Context, database string, table
 		if err != nil {
 			break
 		}
+		if onCreated != nil {
+			if err = onCreated(tbl); err != nil {
+				break
+			}
+		}
 	}
 	task.End(zap.ErrorLevel, err)
 
 	return errors.Trace(err)
 }
 
+// splitTableSchemas rewrites the schemas of the tables into the statements
+// creating them. The schemas are parsed concurrently, since a table with
+// thousands of partitions takes seconds to parse, and each worker has its own
+// parser which is not safe for concurrent use.
+func (timgr *TiDBManager) splitTableSchemas(ctx context.Context, tablesSchema map[string]string) (map[string]string, error) {
+	tables := make([]string, 0, len(tablesSchema))
+	for tbl := range tablesSchema {
+		tables = append(tables, tbl)
+	}
+	stmts := make([]string, len(tables))
+
+	concurrency := timgr.schemaConcurrency
+	if concurrency > len(tables) {
+		concurrency = len(tables)
+	}
+	indices := make(chan int, len(tables))
+	for i := range tables {
+		indices <- i
+	}
+	close(indices)
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	for w := 0; w < concurrency; w++ {
+		eg.Go(func() error {
+			p := parser.New()
+			p.SetSQLMode(timgr.sqlMode)
+			for i := range indices {
+				if err := egCtx.Err(); err != nil {
+					return err
+				}
+				stmt, err := timgr.cachedCreateTableStmt(p, tablesSchema[tables[i]], tables[i])
+				if err != nil {
+					return errors.Annotatef(err, "parse the schema of table %s", tables[i])
+				}
+				stmts[i] = stmt
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	createTables := make(map[string]string, len(tables))
+	for i, tbl := range tables {
+		createTables[tbl] = stmts[i]
+	}
+	return createTables, nil
+}
+
 func (timgr *TiDBManager) createTableIfNotExistsStmt(createTable, tblName string) (string, error) {
-	stmts, _, err := timgr.parser.Parse(createTable, "", "")
+	return timgr.cachedCreateTableStmt(timgr.parser, createTable, tblName)
+}
+
+// cachedCreateTableStmt is createTableIfNotExistsStmt looking up the schema
+// cache first. The errors are not cached.
+func (timgr *TiDBManager) cachedCreateTableStmt(p *parser.Parser, createTable, tblName string) (string, error) {
+	key := schemaCacheKey{schema: createTable, tableName: tblName}
+	if stmt, ok := timgr.schemaCache.Load(key); ok {
+		return stmt.(string), nil
+	}
+	stmt, err := createTableIfNotExistsStmt(p, createTable, tblName)
+	if err != nil {
+		return "", err
+	}
+	timgr.schemaCache.Store(key, stmt)
+	return stmt, nil
+}
+
+func createTableIfNotExistsStmt(p *parser.Parser, createTable, tblName string) (string, error) {
+	// the TTL options and temporary tables are unknown to the parser, and are
+	// put back after restoring the statement. The cached table definitions are
+	// applied after the import.
+	createTable, features := extractSchemaFeatures(createTable)
+	createTable, ttl := extractTTLOptions(createTable)
+	stmts, _, err := p.Parse(createTable, "", "")
 	if err != nil {
 		return "", err
 	}
@@ -170,20 +280,92 @@ func (timgr *TiDBManager) createTableIfNotExistsStmt(createTable, tblName string
 	ctx := format.NewRestoreCtx(format.DefaultRestoreFlags, &res)
 
 	for _, stmt := range stmts {
-		if createTableNode, ok := stmt.(*ast.CreateTableStmt); ok {
-			createTableNode.Table.Schema = model.NewCIStr("")
-			createTableNode.Table.Name = model.NewCIStr(tblName)
-			createTableNode.IfNotExists = true
+		createTableNode, ok := stmt.(*ast.CreateTableStmt)
+		if !ok {
+			if err := stmt.Restore(ctx); err != nil {
+				return "", err
+			}
+			ctx.WritePlain(";")
+			continue
+		}
+
+		createTableNode.Table.Schema = model.NewCIStr("")
+		createTableNode.Table.Name = model.NewCIStr(tblName)
+		createTableNode.IfNotExists = true
+		// the table options must precede the partition options.
+		partition := createTableNode.Partition
+		if ttl != nil {
+			createTableNode.Partition = nil
 		}
-		if err := stmt.Restore(ctx); err != nil {
+		var createStmt strings.Builder
+		createCtx := format.NewRestoreCtx(format.DefaultRestoreFlags, &createStmt)
+		if err := createTableNode.Restore(createCtx); err != nil {
 			return "", err
 		}
+		if ttl != nil {
+			ttl.writeTo(&createStmt)
+			if partition != nil {
+				createCtx.WritePlain(" ")
+				if err := partition.Restore(createCtx); err != nil {
+					return "", err
+				}
+			}
+		}
+		if features.temporary == temporaryGlobal {
+			res.WriteString("CREATE GLOBAL TEMPORARY")
+			res.WriteString(strings.TrimPrefix(createStmt.String(), "CREATE"))
+			res.WriteString(" ON COMMIT DELETE ROWS")
+		} else {
+			res.WriteString(createStmt.String())
+		}
 		ctx.WritePlain(";")
 	}
 
 	return res.String(), nil
 }
 
+// ExecPostSchema executes the statements of a post schema file in the
+// database. CREATE USER statements are rewritten to CREATE USER IF NOT EXISTS,
+// so the file can be executed again when resuming from the checkpoint.
+func (timgr *TiDBManager) ExecPostSchema(ctx context.Context, database string, postSchema string) error {
+	stmts, _, err := timgr.parser.Parse(postSchema, "", "")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// USE only changes the current database of the session, so all the
+	// statements must run on the same connection rather than the pool.
+	conn, err := timgr.db.Conn(ctx)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer conn.Close()
+
+	var useDB strings.Builder
+	useDB.WriteString("USE ")
+	common.WriteMySQLIdentifier(&useDB, database)
+	if _, err := conn.ExecContext(ctx, useDB.String()); err != nil {
+		return errors.Annotatef(err, "use database %s", database)
+	}
+
+	// the statements may contain the password hashes, so they are not logged.
+	for i, stmt := range stmts {
+		query := stmt.Text()
+		if createUserNode, ok := stmt.(*ast.CreateUserStmt); ok && !createUserNode.IfNotExists {
+			createUserNode.IfNotExists = true
+			var res strings.Builder
+			if err := createUserNode.Restore(format.NewRestoreCtx(format.DefaultRestoreFlags, &res)); err != nil {
+				return errors.Trace(err)
+			}
+			query = res.String()
+		}
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return errors.Annotatef(err, "execute post schema statement #%d", i+1)
+		}
+	}
+	return nil
+}
+
 func (timgr *TiDBManager) DropTable(ctx context.Context, tableName string) error {
 	sql := common.SQLWithRetry{
 		DB:     timgr.db,
@@ -253,6 +435,29 @@ func UpdateGCLifeTime(ctx context.Context, db *sql.DB, gcLifeTime string) error
 	)
 }
 
+// ObtainGCLifeTimeVariable reads the GC lifetime from the system variable
+// tidb_gc_life_time, which replaces the row of mysql.tidb since TiDB 5.0.
+func ObtainGCLifeTimeVariable(ctx context.Context, db *sql.DB) (string, error) {
+	var gcLifeTime string
+	err := common.SQLWithRetry{DB: db, Logger: log.L()}.QueryRow(ctx, "obtain GC lifetime",
+		"SELECT @@GLOBAL.tidb_gc_life_time",
+		&gcLifeTime,
+	)
+	return gcLifeTime, err
+}
+
+// UpdateGCLifeTimeVariable sets the system variable tidb_gc_life_time.
+func UpdateGCLifeTimeVariable(ctx context.Context, db *sql.DB, gcLifeTime string) error {
+	sql := common.SQLWithRetry{
+		DB:     db,
+		Logger: log.With(zap.String("gcLifeTime", gcLifeTime)),
+	}
+	return sql.Exec(ctx, "update GC lifetime",
+		"SET GLOBAL tidb_gc_life_time = ?",
+		gcLifeTime,
+	)
+}
+
 func ObtainRowFormatVersion(ctx context.Context, db *sql.DB) (rowFormatVersion string) {
 	err := common.SQLWithRetry{DB: db, Logger: log.L()}.QueryRow(ctx, "obtain row format version",
 		"SELECT @@tidb_row_format_version",
@@ -282,6 +487,61 @@ func AlterAutoIncrement(ctx context.Context, db *sql.DB, tableName string, incr
 	return errors.Annotatef(err, "%s", query)
 }
 
+// ObtainRowIDBase returns the largest _tidb_rowid which may already be used by
+// the table, which is the maximum of the existing rows and the row IDs
+// allocated by TiDB or reserved by other Lightning instances. A table without
+// any rows and any allocated IDs returns 0.
+func ObtainRowIDBase(ctx context.Context, db *sql.DB, tableName string) (int64, error) {
+	var base int64
+	exec := common.SQLWithRetry{
+		DB:     db,
+		Logger: log.With(zap.String("table", tableName)),
+	}
+	err := exec.Transact(ctx, "obtain row ID base", func(ctx context.Context, tx *sql.Tx) error {
+		var maxRowID sql.NullInt64
+		query := fmt.Sprintf("SELECT MAX(_tidb_rowid) FROM %s", tableName)
+		if err := tx.QueryRowContext(ctx, query).Scan(&maxRowID); err != nil {
+			return errors.Annotatef(err, "%s", query)
+		}
+		base = maxRowID.Int64
+
+		query = fmt.Sprintf("SHOW TABLE %s NEXT_ROW_ID", tableName)
+		rows, err := tx.QueryContext(ctx, query)
+		if err != nil {
+			return errors.Annotatef(err, "%s", query)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			return errors.Trace(err)
+		}
+		values := make([]sql.RawBytes, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				return errors.Trace(err)
+			}
+			for i, column := range columns {
+				if !strings.EqualFold(column, "NEXT_GLOBAL_ROW_ID") {
+					continue
+				}
+				next, err := strconv.ParseInt(string(values[i]), 10, 64)
+				if err != nil {
+					return errors.Annotatef(err, "invalid next row ID %q", values[i])
+				}
+				if next-1 > base {
+					base = next - 1
+				}
+			}
+		}
+		return errors.Trace(rows.Err())
+	})
+	return base, err
+}
+
 func AlterAutoRandom(ctx context.Context, db *sql.DB, tableName string, randomBase int64) error {
 	sql := common.SQLWithRetry{
 		DB:     db,
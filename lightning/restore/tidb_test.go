@@ -15,6 +15,7 @@ package restore
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"testing"
 
@@ -137,6 +138,45 @@ func (s *tidbSuite) TestCreateTableIfNotExistsStmt(c *C) {
 		Equals,
 		"SET NAMES 'binary';SET @@SESSION.`FOREIGN_KEY_CHECKS`=0;CREATE TABLE IF NOT EXISTS `m` (`z` DOUBLE) ENGINE = InnoDB AUTO_INCREMENT = 8343230 DEFAULT CHARACTER SET = UTF8;",
 	)
+
+	// TTL options
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE t (a int, t datetime) TTL = `t` + INTERVAL 1 DAY TTL_ENABLE = 'off';", "t"),
+		Equals,
+		"CREATE TABLE IF NOT EXISTS `t` (`a` INT,`t` DATETIME) /*T![ttl] TTL=`t` + INTERVAL 1 DAY TTL_ENABLE='OFF' */;",
+	)
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE `t` (`t` datetime) ENGINE=InnoDB /*T![ttl] TTL=`t` + INTERVAL 1 DAY */ /*T![ttl] TTL_ENABLE='ON' */ /*T![ttl] TTL_JOB_INTERVAL='1h' */;", "t"),
+		Equals,
+		"CREATE TABLE IF NOT EXISTS `t` (`t` DATETIME) ENGINE = InnoDB /*T![ttl] TTL=`t` + INTERVAL 1 DAY TTL_ENABLE='ON' TTL_JOB_INTERVAL='1h' */;",
+	)
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE `t` (`t` datetime) TTL=`t` + INTERVAL 1 DAY, DEFAULT CHARSET=utf8;", "t"),
+		Equals,
+		"CREATE TABLE IF NOT EXISTS `t` (`t` DATETIME) DEFAULT CHARACTER SET = UTF8 /*T![ttl] TTL=`t` + INTERVAL 1 DAY */;",
+	)
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE `t` (`ttl` int COMMENT 'TTL = `t` + INTERVAL 1 DAY');", "t"),
+		Equals,
+		"CREATE TABLE IF NOT EXISTS `t` (`ttl` INT COMMENT 'TTL = `t` + INTERVAL 1 DAY');",
+	)
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE `t` (`a` int, `t` datetime) TTL=`t` + INTERVAL 1 DAY PARTITION BY HASH(`a`) PARTITIONS 4;", "t"),
+		Matches,
+		"CREATE TABLE IF NOT EXISTS `t` \\(`a` INT,`t` DATETIME\\) /\\*T!\\[ttl\\] TTL=`t` \\+ INTERVAL 1 DAY \\*/ PARTITION BY HASH .*;",
+	)
+
+	// temporary and cached tables
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE GLOBAL TEMPORARY TABLE `x`.`y` (a int) ON COMMIT DELETE ROWS;", "t"),
+		Equals,
+		"CREATE GLOBAL TEMPORARY TABLE IF NOT EXISTS `t` (`a` INT) ON COMMIT DELETE ROWS;",
+	)
+	c.Assert(
+		createTableIfNotExistsStmt("CREATE TABLE `y` (a int);\nALTER TABLE `y` CACHE;", "t"),
+		Equals,
+		"CREATE TABLE IF NOT EXISTS `t` (`a` INT);",
+	)
 }
 
 func (s *tidbSuite) TestInitSchema(c *C) {
@@ -161,7 +201,34 @@ func (s *tidbSuite) TestInitSchema(c *C) {
 	err := s.timgr.InitSchema(ctx, "db", map[string]string{
 		"t1": "create table t1 (a int primary key, b varchar(200));",
 		"t2": "/*!40014 SET FOREIGN_KEY_CHECKS=0*/;CREATE TABLE `db`.`t2` (xx TEXT) AUTO_INCREMENT=11203;",
-	})
+	}, nil)
+	s.mockDB.MatchExpectationsInOrder(true)
+	c.Assert(err, IsNil)
+}
+
+func (s *tidbSuite) TestInitSchemaConcurrently(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("CREATE DATABASE IF NOT EXISTS `db`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	tablesSchema := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		tbl := fmt.Sprintf("t%d", i)
+		tablesSchema[tbl] = fmt.Sprintf("create table `db`.`%s` (a int) partition by hash(a) partitions %d;", tbl, i+1)
+		s.mockDB.
+			ExpectExec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS `%s` \\(`a` INT\\) PARTITION BY HASH .* PARTITIONS %d;", tbl, i+1)).
+			WillReturnResult(sqlmock.NewResult(2, 1))
+	}
+	s.mockDB.
+		ExpectClose()
+
+	s.timgr.schemaConcurrency = 4
+	s.mockDB.MatchExpectationsInOrder(false) // maps are unordered.
+	err := s.timgr.InitSchema(ctx, "db", tablesSchema, nil)
 	s.mockDB.MatchExpectationsInOrder(true)
 	c.Assert(err, IsNil)
 }
@@ -180,8 +247,8 @@ func (s *tidbSuite) TestInitSchemaSyntaxError(c *C) {
 
 	err := s.timgr.InitSchema(ctx, "db", map[string]string{
 		"t1": "create table `t1` with invalid syntax;",
-	})
-	c.Assert(err, NotNil)
+	}, nil)
+	c.Assert(err, ErrorMatches, "parse the schema of table t1.*")
 }
 
 func (s *tidbSuite) TestInitSchemaUnsupportedSchemaError(c *C) {
@@ -204,10 +271,89 @@ func (s *tidbSuite) TestInitSchemaUnsupportedSchemaError(c *C) {
 
 	err := s.timgr.InitSchema(ctx, "db", map[string]string{
 		"t1": "create table `t1` (a VARCHAR(999999999));",
-	})
+	}, nil)
 	c.Assert(err, ErrorMatches, ".*Column length too big.*")
 }
 
+func (s *tidbSuite) TestInitSchemaReportsCreatedTables(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("CREATE DATABASE IF NOT EXISTS `db`").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("CREATE TABLE IF NOT EXISTS `t1`.*").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	s.mockDB.
+		ExpectClose()
+
+	var created []string
+	err := s.timgr.InitSchema(ctx, "db", map[string]string{
+		"t1": "create table t1 (a int primary key);",
+	}, func(tableName string) error {
+		created = append(created, tableName)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(created, DeepEquals, []string{"t1"})
+}
+
+func (s *tidbSuite) TestCreateTableStmtCache(c *C) {
+	schema := "CREATE TABLE `shard_01`(`a` INT);"
+	stmt, err := s.timgr.createTableIfNotExistsStmt(schema, "t")
+	c.Assert(err, IsNil)
+	c.Assert(stmt, Equals, "CREATE TABLE IF NOT EXISTS `t` (`a` INT);")
+	cached, ok := s.timgr.schemaCache.Load(schemaCacheKey{schema: schema, tableName: "t"})
+	c.Assert(ok, IsTrue)
+	c.Assert(cached, Equals, stmt)
+
+	// the table name is a part of the key.
+	stmt, err = s.timgr.createTableIfNotExistsStmt(schema, "u")
+	c.Assert(err, IsNil)
+	c.Assert(stmt, Equals, "CREATE TABLE IF NOT EXISTS `u` (`a` INT);")
+
+	// the errors are not cached.
+	_, err = s.timgr.createTableIfNotExistsStmt("CREATE TABLE", "t")
+	c.Assert(err, NotNil)
+	_, ok = s.timgr.schemaCache.Load(schemaCacheKey{schema: "CREATE TABLE", tableName: "t"})
+	c.Assert(ok, IsFalse)
+}
+
+func (s *tidbSuite) TestExecPostSchema(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectExec("USE `db`").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QCREATE USER IF NOT EXISTS\\E.*u1.*@.*%").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QCREATE USER IF NOT EXISTS 'u2'@'localhost'\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectExec("\\QGRANT SELECT ON db.* TO 'u1'@'%'\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectClose()
+
+	err := s.timgr.ExecPostSchema(ctx, "db", "CREATE USER 'u1'@'%';"+
+		"CREATE USER IF NOT EXISTS 'u2'@'localhost';"+
+		"GRANT SELECT ON db.* TO 'u1'@'%';")
+	c.Assert(err, IsNil)
+}
+
+func (s *tidbSuite) TestExecPostSchemaSyntaxError(c *C) {
+	s.mockDB.
+		ExpectClose()
+
+	err := s.timgr.ExecPostSchema(context.Background(), "db", "GRANT SELECT ON TO;")
+	c.Assert(err, ErrorMatches, ".*syntax error.*")
+}
+
 func (s *tidbSuite) TestDropTable(c *C) {
 	ctx := context.Background()
 
@@ -301,6 +447,26 @@ func (s *tidbSuite) TestSetGCLifetime(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *tidbSuite) TestGCLifetimeVariable(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.
+		ExpectQuery("\\QSELECT @@GLOBAL.tidb_gc_life_time\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"@@GLOBAL.tidb_gc_life_time"}).AddRow("10m0s"))
+	s.mockDB.
+		ExpectExec("\\QSET GLOBAL tidb_gc_life_time = ?\\E").
+		WithArgs("12m").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mockDB.
+		ExpectClose()
+
+	res, err := ObtainGCLifeTimeVariable(ctx, s.timgr.db)
+	c.Assert(err, IsNil)
+	c.Assert(res, Equals, "10m0s")
+	err = UpdateGCLifeTimeVariable(ctx, s.timgr.db, "12m")
+	c.Assert(err, IsNil)
+}
+
 func (s *tidbSuite) TestAlterAutoInc(c *C) {
 	ctx := context.Background()
 
@@ -327,6 +493,46 @@ func (s *tidbSuite) TestAlterAutoRandom(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *tidbSuite) TestObtainRowIDBase(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectQuery("\\QSELECT MAX(_tidb_rowid) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(_tidb_rowid)"}).AddRow(100))
+	s.mockDB.
+		ExpectQuery("\\QSHOW TABLE `db`.`table` NEXT_ROW_ID\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"DB_NAME", "TABLE_NAME", "COLUMN_NAME", "NEXT_GLOBAL_ROW_ID"}).
+			AddRow("db", "table", "_tidb_rowid", 30001))
+	s.mockDB.ExpectCommit()
+	s.mockDB.
+		ExpectClose()
+
+	base, err := ObtainRowIDBase(ctx, s.timgr.db, "`db`.`table`")
+	c.Assert(err, IsNil)
+	c.Assert(base, Equals, int64(30000))
+}
+
+func (s *tidbSuite) TestObtainRowIDBaseEmptyTable(c *C) {
+	ctx := context.Background()
+
+	s.mockDB.ExpectBegin()
+	s.mockDB.
+		ExpectQuery("\\QSELECT MAX(_tidb_rowid) FROM `db`.`table`\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"MAX(_tidb_rowid)"}).AddRow(nil))
+	s.mockDB.
+		ExpectQuery("\\QSHOW TABLE `db`.`table` NEXT_ROW_ID\\E").
+		WillReturnRows(sqlmock.NewRows([]string{"DB_NAME", "TABLE_NAME", "COLUMN_NAME", "NEXT_GLOBAL_ROW_ID"}).
+			AddRow("db", "table", "_tidb_rowid", 1))
+	s.mockDB.ExpectCommit()
+	s.mockDB.
+		ExpectClose()
+
+	base, err := ObtainRowIDBase(ctx, s.timgr.db, "`db`.`table`")
+	c.Assert(err, IsNil)
+	c.Assert(base, Equals, int64(0))
+}
+
 func (s *tidbSuite) TestObtainRowFormatVersionSucceed(c *C) {
 	ctx := context.Background()
 
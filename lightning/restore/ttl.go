@@ -0,0 +1,205 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+var (
+	// ttlCommentRegexp matches the TTL options in the versioned comment, as
+	// written by SHOW CREATE TABLE, e.g. "/*T![ttl] TTL=`t` + INTERVAL 1 DAY */".
+	ttlCommentRegexp = regexp.MustCompile(`/\*T!\[ttl\]((?:[^*]|\*+[^*/])*)\*+/`)
+	ttlRegexp        = regexp.MustCompile("(?i)(?:,\\s*)?\\bTTL\\s*=\\s*((?:`[^`]+`|\\w+)\\s*\\+\\s*INTERVAL\\s+(?:'[^']*'|[^\\s,;]+)\\s+\\w+)")
+	ttlEnableRegexp  = regexp.MustCompile(`(?i)(?:,\s*)?\bTTL_ENABLE\s*=\s*'(\w*)'`)
+	ttlIntervalRegex = regexp.MustCompile(`(?i)(?:,\s*)?\bTTL_JOB_INTERVAL\s*=\s*'([^']*)'`)
+	leadingComma     = regexp.MustCompile(`^\s*,`)
+)
+
+// ttlOptions are the TTL table options of TiDB, which are unknown to the
+// parser, and so are carried aside when rewriting the CREATE TABLE statement.
+type ttlOptions struct {
+	// ttl is the expression of the expiry time, e.g. "`t` + INTERVAL 1 DAY".
+	ttl string
+	// enable is either "ON" or "OFF", or empty if unspecified.
+	enable string
+	// jobInterval is the interval of the TTL jobs, or empty if unspecified.
+	jobInterval string
+}
+
+// enabled returns whether TTL jobs run on the table after it is created.
+func (o *ttlOptions) enabled() bool {
+	return o != nil && len(o.ttl) > 0 && !strings.EqualFold(o.enable, "OFF")
+}
+
+// writeTo appends the options in the versioned comment, so the statement is
+// still accepted by the versions of TiDB without TTL support.
+func (o *ttlOptions) writeTo(sb *strings.Builder) {
+	sb.WriteString(" /*T![ttl] TTL=")
+	sb.WriteString(o.ttl)
+	if len(o.enable) > 0 {
+		sb.WriteString(" TTL_ENABLE='")
+		sb.WriteString(o.enable)
+		sb.WriteString("'")
+	}
+	if len(o.jobInterval) > 0 {
+		sb.WriteString(" TTL_JOB_INTERVAL='")
+		sb.WriteString(o.jobInterval)
+		sb.WriteString("'")
+	}
+	sb.WriteString(" */")
+}
+
+// extractTTLOptions removes the TTL options from the table options of the
+// CREATE TABLE statement. The returned options are nil if there is none.
+func extractTTLOptions(createTable string) (string, *ttlOptions) {
+	offset := tableOptionsOffset(createTable)
+	if offset < 0 {
+		return createTable, nil
+	}
+
+	opts := &ttlOptions{}
+	found := false
+	extract := func(s string) string {
+		if m := ttlRegexp.FindStringSubmatch(s); m != nil {
+			opts.ttl = m[1]
+			found = true
+		}
+		if m := ttlEnableRegexp.FindStringSubmatch(s); m != nil {
+			opts.enable = strings.ToUpper(m[1])
+			found = true
+		}
+		if m := ttlIntervalRegex.FindStringSubmatch(s); m != nil {
+			opts.jobInterval = m[1]
+			found = true
+		}
+		s = ttlRegexp.ReplaceAllString(s, "")
+		s = ttlEnableRegexp.ReplaceAllString(s, "")
+		return ttlIntervalRegex.ReplaceAllString(s, "")
+	}
+
+	tableOptions := ttlCommentRegexp.ReplaceAllStringFunc(createTable[offset:], func(comment string) string {
+		extract(ttlCommentRegexp.FindStringSubmatch(comment)[1])
+		return ""
+	})
+	tableOptions = extract(tableOptions)
+	if !found {
+		return createTable, nil
+	}
+	return createTable[:offset] + leadingComma.ReplaceAllString(tableOptions, " "), opts
+}
+
+// tableOptionsOffset returns the offset just after the column definitions of
+// the CREATE TABLE statement, or -1 if not found.
+func tableOptionsOffset(createTable string) int {
+	depth := 0
+	for i := 0; i < len(createTable); i++ {
+		switch c := createTable[i]; c {
+		case '\'', '"', '`':
+			// skip the quoted string, where the quote is escaped by doubling
+			// or by the backslash.
+			for i++; i < len(createTable); i++ {
+				if createTable[i] == '\\' && c != '`' {
+					i++
+				} else if createTable[i] == c {
+					if i+1 < len(createTable) && createTable[i+1] == c {
+						i++
+					} else {
+						break
+					}
+				}
+			}
+		case '/':
+			if i+1 < len(createTable) && createTable[i+1] == '*' {
+				end := strings.Index(createTable[i+2:], "*/")
+				if end < 0 {
+					return -1
+				}
+				i += end + 3
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// AlterTTLEnable enables or disables the TTL jobs of the table.
+func AlterTTLEnable(ctx context.Context, db *sql.DB, tableName string, enable bool) error {
+	value := "OFF"
+	if enable {
+		value = "ON"
+	}
+	sql := common.SQLWithRetry{
+		DB:     db,
+		Logger: log.With(zap.String("table", tableName), zap.String("ttl_enable", value)),
+	}
+	query := fmt.Sprintf("ALTER TABLE %s TTL_ENABLE='%s'", tableName, value)
+	task := sql.Logger.Begin(zap.InfoLevel, "alter table ttl_enable")
+	err := sql.Exec(ctx, "alter table ttl_enable", query)
+	task.End(zap.ErrorLevel, err)
+	return errors.Annotatef(err, "%s", query)
+}
+
+// pauseTTLJobs disables the TTL jobs of the tables with TTL enabled in the
+// schema files, until the tables are imported.
+func (rc *RestoreController) pauseTTLJobs(ctx context.Context, db *sql.DB, schemas map[string]string) error {
+	rc.ttlPaused = make(map[string]struct{})
+	for _, dbMeta := range rc.dbMetas {
+		for _, tblMeta := range dbMeta.Tables {
+			tableName := common.UniqueTable(dbMeta.Name, tblMeta.Name)
+			_, ttl := extractTTLOptions(schemas[tableName])
+			if !ttl.enabled() {
+				continue
+			}
+			if err := AlterTTLEnable(ctx, db, tableName, false); err != nil {
+				return errors.Trace(err)
+			}
+			rc.ttlPaused[tableName] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// resumeTTLJob enables the TTL jobs of the table paused by pauseTTLJobs after
+// the table is imported. If the import failed, the jobs are left disabled, so
+// the expired rows are kept until the import is resumed.
+func (rc *RestoreController) resumeTTLJob(ctx context.Context, tableName string, importErr error) error {
+	if _, ok := rc.ttlPaused[tableName]; !ok {
+		return importErr
+	}
+	if importErr != nil {
+		common.RecordWarning(tableName, common.WarningTTLPaused, fmt.Sprintf(
+			"the TTL jobs are disabled during the import, please run `ALTER TABLE %s TTL_ENABLE='ON'` after the import is resumed",
+			tableName,
+		))
+		return importErr
+	}
+	return AlterTTLEnable(ctx, rc.tidbMgr.db, tableName, true)
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package restore
+
+import (
+	"context"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+	tmysql "github.com/pingcap/parser/mysql"
+)
+
+var _ = Suite(&ttlSuite{})
+
+type ttlSuite struct{}
+
+func (s *ttlSuite) TestExtractTTLOptions(c *C) {
+	stmt, ttl := extractTTLOptions("CREATE TABLE t (a INT);")
+	c.Assert(stmt, Equals, "CREATE TABLE t (a INT);")
+	c.Assert(ttl, IsNil)
+	c.Assert(ttl.enabled(), IsFalse)
+
+	// the TTL options in the column definitions are not table options.
+	stmt, ttl = extractTTLOptions("CREATE TABLE t (a INT COMMENT 'TTL_ENABLE=''ON''') COMMENT 'x)';")
+	c.Assert(stmt, Equals, "CREATE TABLE t (a INT COMMENT 'TTL_ENABLE=''ON''') COMMENT 'x)';")
+	c.Assert(ttl, IsNil)
+
+	stmt, ttl = extractTTLOptions("CREATE TABLE t (t DATETIME) TTL=t + INTERVAL '1' MONTH;")
+	c.Assert(stmt, Equals, "CREATE TABLE t (t DATETIME) ;")
+	c.Assert(ttl, DeepEquals, &ttlOptions{ttl: "t + INTERVAL '1' MONTH"})
+	c.Assert(ttl.enabled(), IsTrue)
+
+	stmt, ttl = extractTTLOptions("CREATE TABLE t (t DATETIME) ENGINE=InnoDB, TTL=`t` + INTERVAL 1 DAY, TTL_ENABLE='OFF', TTL_JOB_INTERVAL='24h';")
+	c.Assert(stmt, Equals, "CREATE TABLE t (t DATETIME) ENGINE=InnoDB;")
+	c.Assert(ttl, DeepEquals, &ttlOptions{ttl: "`t` + INTERVAL 1 DAY", enable: "OFF", jobInterval: "24h"})
+	c.Assert(ttl.enabled(), IsFalse)
+
+	stmt, ttl = extractTTLOptions("/* (comment */ CREATE TABLE t (t DATETIME) /*T![ttl] TTL=`t` + INTERVAL 1 DAY */;")
+	c.Assert(stmt, Equals, "/* (comment */ CREATE TABLE t (t DATETIME) ;")
+	c.Assert(ttl, DeepEquals, &ttlOptions{ttl: "`t` + INTERVAL 1 DAY"})
+}
+
+func (s *ttlSuite) TestResumeTTLJob(c *C) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	c.Assert(err, IsNil)
+	defaultSQLMode, err := tmysql.GetSQLMode(tmysql.DefaultSQLMode)
+	c.Assert(err, IsNil)
+	rc := &RestoreController{
+		tidbMgr:   NewTiDBManagerWithDB(db, defaultSQLMode),
+		ttlPaused: map[string]struct{}{"`db`.`paused`": {}},
+	}
+
+	mock.ExpectExec("\\QALTER TABLE `db`.`paused` TTL_ENABLE='ON'\\E").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectClose()
+
+	// the tables not paused are not altered.
+	c.Assert(rc.resumeTTLJob(ctx, "`db`.`other`", nil), IsNil)
+	importErr := errors.New("import failed")
+	c.Assert(rc.resumeTTLJob(ctx, "`db`.`other`", importErr), Equals, importErr)
+
+	// the paused tables are left disabled if failed to import.
+	c.Assert(rc.resumeTTLJob(ctx, "`db`.`paused`", importErr), Equals, importErr)
+	c.Assert(rc.resumeTTLJob(ctx, "`db`.`paused`", nil), IsNil)
+
+	rc.tidbMgr.Close()
+	c.Assert(mock.ExpectationsWereMet(), IsNil)
+}
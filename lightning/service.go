@@ -0,0 +1,147 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightning
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"strconv"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+	"github.com/pingcap/tidb-lightning/lightning/log"
+)
+
+// The states reported to the service manager, see sd_notify(3).
+const (
+	sdNotifyReady     = "READY=1"
+	sdNotifyReloading = "RELOADING=1"
+	sdNotifyStopping  = "STOPPING=1"
+)
+
+// sdListenFDsStart is the first file descriptor passed by systemd with the
+// socket activation, see sd_listen_fds(3).
+const sdListenFDsStart = 3
+
+// sdNotify reports the states to the service manager if Lightning is started
+// by systemd as a service of `Type=notify`, and does nothing otherwise. The
+// failure is only logged, which does not affect the import.
+func sdNotify(states ...string) {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if len(addr) == 0 {
+		return
+	}
+	// the socket is in the abstract namespace.
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	var msg []byte
+	for _, state := range states {
+		msg = append(msg, state...)
+		msg = append(msg, '\n')
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err == nil {
+		_, err = conn.Write(msg)
+		conn.Close()
+	}
+	if err != nil {
+		log.L().Warn("notify the service manager failed", zap.Strings("states", states), log.ShortError(err))
+	}
+}
+
+// sdStatus is the free-form status reported to the service manager, which is
+// shown by `systemctl status`.
+func sdStatus(status string) string {
+	return "STATUS=" + status
+}
+
+// activatedListener returns the socket passed by systemd with the socket
+// activation, or nil if Lightning is not socket activated.
+func activatedListener() (net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds == 0 {
+		return nil, nil
+	}
+	if fds != 1 {
+		return nil, errors.Errorf("the status address takes a single socket from systemd, but got %d", fds)
+	}
+	// the sockets are not passed to the child processes.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(sdListenFDsStart, "LISTEN_FD_3")
+	defer file.Close()
+	listener, err := net.FileListener(file)
+	return listener, errors.Annotate(err, "use the socket from systemd")
+}
+
+// Reload applies the global config re-read on SIGHUP. Only the following
+// settings are changed at runtime:
+//
+//   - the log level, and
+//   - the defaults of the tasks submitted afterwards in the server mode.
+//
+// The other settings of [lightning] and [security], e.g. the status address
+// and the log file, are kept until restarted.
+func (l *Lightning) Reload(globalCfg *config.GlobalConfig) {
+	sdNotify(sdNotifyReloading)
+	defer sdNotify(sdNotifyReady)
+
+	l.globalCfgLock.Lock()
+	defer l.globalCfgLock.Unlock()
+
+	oldCfg := l.globalCfg
+	level := globalCfg.App.Config.Level
+	if level == "warning" {
+		level = "warn"
+	}
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		log.L().Warn("invalid log level, keep the current level", zap.String("level", level))
+		globalCfg.App.Config.Level = oldCfg.App.Config.Level
+	} else if oldLevel := log.SetLevel(zapLevel); oldLevel != zapLevel {
+		log.L().Info("changed log level", zap.Stringer("old", oldLevel), zap.Stringer("new", zapLevel))
+	}
+
+	newApp := globalCfg.App
+	newApp.Config.Level = oldCfg.App.Config.Level
+	if newApp != oldCfg.App || !reflect.DeepEqual(globalCfg.Security, oldCfg.Security) {
+		log.L().Warn("the changes of [lightning] and [security] other than the log level take effect after restarted")
+	}
+	level = globalCfg.App.Config.Level
+	globalCfg.App = oldCfg.App
+	globalCfg.App.Config.Level = level
+	globalCfg.Security = oldCfg.Security
+
+	l.globalCfg = globalCfg
+	log.L().Info("reloaded config")
+}
+
+// globalConfig returns the current global config, which may be replaced by
+// Reload.
+func (l *Lightning) globalConfig() *config.GlobalConfig {
+	l.globalCfgLock.RLock()
+	defer l.globalCfgLock.RUnlock()
+	return l.globalCfg
+}
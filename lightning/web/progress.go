@@ -3,6 +3,7 @@ package web
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/pingcap/errors"
 
@@ -63,7 +64,8 @@ func (cpm *checkpointsMap) update(diffs map[string]*checkpoints.TableCheckpointD
 		tw := int64(0)
 		for _, engine := range cp.Engines {
 			for _, chunk := range engine.Chunks {
-				if engine.Status >= checkpoints.CheckpointStatusAllWritten {
+				// the compressed files are read until EOF without a known end offset.
+				if engine.Status >= checkpoints.CheckpointStatusAllWritten && chunk.Chunk.EndOffset != mydump.TableFileSizeINF {
 					tw += chunk.Chunk.EndOffset - chunk.Key.Offset
 				} else {
 					tw += chunk.Chunk.Offset - chunk.Key.Offset
@@ -98,6 +100,8 @@ type tableInfo struct {
 	TotalSize    int64      `json:"z"`
 	Status       taskStatus `json:"s"`
 	Message      string     `json:"m,omitempty"`
+	// Phases is the time spent on each phase of the table in seconds.
+	Phases map[string]float64 `json:"p,omitempty"`
 }
 
 type taskProgress struct {
@@ -165,6 +169,18 @@ func BroadcastCheckpointDiff(diffs map[string]*checkpoints.TableCheckpointDiff)
 	currentProgress.mu.Unlock()
 }
 
+// BroadcastTablePhase sets the total time spent on a phase of the table.
+func BroadcastTablePhase(tableName string, phase string, dur time.Duration) {
+	currentProgress.mu.Lock()
+	if tbl := currentProgress.Tables[tableName]; tbl != nil {
+		if tbl.Phases == nil {
+			tbl.Phases = make(map[string]float64)
+		}
+		tbl.Phases[phase] = dur.Seconds()
+	}
+	currentProgress.mu.Unlock()
+}
+
 func BroadcastError(tableName string, err error) {
 	errString := errors.ErrorStack(err)
 
@@ -182,6 +198,10 @@ func MarshalTaskProgress() ([]byte, error) {
 	return json.Marshal(&currentProgress)
 }
 
+func MarshalWarnings() ([]byte, error) {
+	return json.Marshal(common.Warnings.Summary())
+}
+
 func MarshalTableCheckpoints(tableName string) ([]byte, error) {
 	return currentProgress.checkpoints.marshal(tableName)
 }
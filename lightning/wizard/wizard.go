@@ -0,0 +1,470 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wizard implements `tidb-lightning init`, which interviews the user
+// and writes a validated configuration file for a first import.
+package wizard
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/coreos/go-semver/semver"
+	"github.com/pingcap/errors"
+
+	kv "github.com/pingcap/tidb-lightning/lightning/backend"
+	"github.com/pingcap/tidb-lightning/lightning/common"
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+// draftSuffix is appended to the path of the config file to save the answers
+// given so far, so an interrupted interview is resumed from them.
+const draftSuffix = ".draft.json"
+
+// localMinTiDBVersion is the minimum TiDB version of the local backend.
+var localMinTiDBVersion = *semver.New("4.0.0")
+
+// Wizard interviews the user through the input and the output, and writes
+// the config file at the path.
+type Wizard struct {
+	in     *bufio.Reader
+	out    io.Writer
+	path   string
+	draft  map[string]string
+	resume bool
+
+	// the probes of the environment, replaced in the tests.
+	diskFreeSpace func(path string) (uint64, error)
+	tidbVersion   func(statusAddr string) (*semver.Version, error)
+}
+
+// New creates a wizard writing the config file at the path.
+func New(in io.Reader, out io.Writer, path string) *Wizard {
+	return &Wizard{
+		in:            bufio.NewReader(in),
+		out:           out,
+		path:          path,
+		draft:         make(map[string]string),
+		diskFreeSpace: kv.DiskFreeSpace,
+		tidbVersion: func(statusAddr string) (*semver.Version, error) {
+			tls, err := common.NewTLS("", "", "", statusAddr)
+			if err != nil {
+				return nil, err
+			}
+			return kv.FetchTiDBVersion(tls)
+		},
+	}
+}
+
+func (w *Wizard) draftPath() string {
+	return w.path + draftSuffix
+}
+
+func (w *Wizard) printf(format string, args ...interface{}) {
+	fmt.Fprintf(w.out, format, args...)
+}
+
+// readLine reads a line of the answer. An EOF before any character aborts the
+// interview, keeping the draft for the next run.
+func (w *Wizard) readLine() (string, error) {
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		if err == io.EOF {
+			return "", errors.Errorf("interview aborted, the answers are saved in %s, run again to resume", w.draftPath())
+		}
+		return "", errors.Trace(err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// ask asks the question of the key, until the answer is valid. When resuming,
+// the saved answer is used without asking. The answer is saved to the draft
+// before the next question.
+func (w *Wizard) ask(key, question, defaultValue string, validate func(string) error) (string, error) {
+	if answer, ok := w.draft[key]; ok && w.resume {
+		w.printf("%s: %s (saved)\n", question, answer)
+		return answer, nil
+	}
+	if answer, ok := w.draft[key]; ok {
+		defaultValue = answer
+	}
+	for {
+		if len(defaultValue) > 0 {
+			w.printf("%s [%s]: ", question, defaultValue)
+		} else {
+			w.printf("%s: ", question)
+		}
+		answer, err := w.readLine()
+		if err != nil {
+			return "", err
+		}
+		if len(answer) == 0 {
+			answer = defaultValue
+		}
+		if validate != nil {
+			if err := validate(answer); err != nil {
+				w.printf("  %s\n", err)
+				continue
+			}
+		}
+		w.draft[key] = answer
+		if err := w.saveDraft(); err != nil {
+			return "", err
+		}
+		return answer, nil
+	}
+}
+
+func (w *Wizard) askChoice(key, question, defaultValue string, choices ...string) (string, error) {
+	question = fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/"))
+	return w.ask(key, question, defaultValue, func(answer string) error {
+		for _, choice := range choices {
+			if answer == choice {
+				return nil
+			}
+		}
+		return errors.Errorf("please answer one of %s", strings.Join(choices, ", "))
+	})
+}
+
+func (w *Wizard) askYesNo(key, question string, defaultValue bool) (bool, error) {
+	answer, err := w.askChoice(key, question, yesNo(defaultValue), "yes", "no")
+	return answer == "yes", err
+}
+
+// confirm asks a yes-or-no question which is not saved to the draft.
+func (w *Wizard) confirm(question string, defaultValue bool) (bool, error) {
+	for {
+		w.printf("%s (yes/no) [%s]: ", question, yesNo(defaultValue))
+		answer, err := w.readLine()
+		if err != nil {
+			return false, err
+		}
+		switch answer {
+		case "":
+			return defaultValue, nil
+		case "yes":
+			return true, nil
+		case "no":
+			return false, nil
+		}
+		w.printf("  please answer one of yes, no\n")
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func (w *Wizard) loadDraft() error {
+	data, err := ioutil.ReadFile(w.draftPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Trace(err)
+	}
+	if err := json.Unmarshal(data, &w.draft); err != nil {
+		return errors.Annotatef(err, "invalid draft %s, remove it to start over", w.draftPath())
+	}
+	return nil
+}
+
+func (w *Wizard) saveDraft() error {
+	data, err := json.Marshal(w.draft)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	// the draft contains the password.
+	return errors.Trace(ioutil.WriteFile(w.draftPath(), data, 0600))
+}
+
+// Run interviews the user, and writes the config file.
+func (w *Wizard) Run() error {
+	if err := w.loadDraft(); err != nil {
+		return err
+	}
+	if len(w.draft) > 0 {
+		w.printf("Found the answers saved in %s.\n", w.draftPath())
+		resume, err := w.confirm("Resume from the saved answers?", true)
+		if err != nil {
+			return err
+		}
+		w.resume = resume
+	}
+
+	doc, err := w.interview()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# generated by `tidb-lightning init`, see tidb-lightning.toml for all options.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return errors.Trace(err)
+	}
+	if err := validate(buf.Bytes()); err != nil {
+		return errors.Annotatef(err, "the generated config is invalid, the answers are saved in %s", w.draftPath())
+	}
+
+	if _, err := os.Stat(w.path); err == nil {
+		overwrite, err := w.confirm(fmt.Sprintf("%s exists, overwrite it?", w.path), false)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			return errors.Errorf("%s exists, the answers are saved in %s", w.path, w.draftPath())
+		}
+	}
+	// the config contains the password.
+	if err := ioutil.WriteFile(w.path, buf.Bytes(), 0600); err != nil {
+		return errors.Trace(err)
+	}
+	if err := os.Remove(w.draftPath()); err != nil && !os.IsNotExist(err) {
+		return errors.Trace(err)
+	}
+	w.printf("\nThe config is written to %s, start the import by\n\n    tidb-lightning -config %s\n", w.path, w.path)
+	return nil
+}
+
+// validate checks the generated config like a task of the config.
+func validate(data []byte) error {
+	cfg := config.NewConfig()
+	if err := cfg.LoadFromTOML(data); err != nil {
+		return err
+	}
+	return cfg.Adjust()
+}
+
+// interview asks all questions, and returns the sections of the config file.
+func (w *Wizard) interview() (map[string]map[string]interface{}, error) {
+	doc := map[string]map[string]interface{}{
+		"mydumper":      {},
+		"tidb":          {},
+		"tikv-importer": {},
+	}
+
+	// the data source.
+	sourceDir, err := w.ask("source-dir", "Where are the data files? (a local directory, or a URL like s3://bucket/path)", "", func(answer string) error {
+		if len(answer) == 0 {
+			return errors.New("the data source is required")
+		}
+		if !strings.Contains(answer, "://") {
+			if info, err := os.Stat(answer); err != nil || !info.IsDir() {
+				return errors.Errorf("%s is not a directory", answer)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	doc["mydumper"]["data-source-dir"] = sourceDir
+
+	var sourceSize int64
+	detected := "sql"
+	if !strings.Contains(sourceDir, "://") {
+		var counts map[string]int
+		sourceSize, counts = scanSource(sourceDir)
+		w.printf("  found %s of data files\n", formatSize(uint64(sourceSize)))
+		for _, format := range []string{"csv", "tsv", "parquet"} {
+			if counts[format] > counts[detected] {
+				detected = format
+			}
+		}
+	}
+	format, err := w.askChoice("format", "What is the format of the data files?", detected, "sql", "csv", "tsv", "parquet")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "csv":
+		csv := map[string]interface{}{}
+		separator, err := w.ask("csv-separator", "What separates the fields?", ",", func(answer string) error {
+			if len(answer) != 1 {
+				return errors.New("the separator must be exactly one byte long")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		csv["separator"] = separator
+		if csv["header"], err = w.askYesNo("csv-header", "Is the first line of the files the column names?", true); err != nil {
+			return nil, err
+		}
+		doc["mydumper"]["csv"] = csv
+	case "tsv":
+		csv := map[string]interface{}{"format": config.CSVFormatTSV}
+		if csv["header"], err = w.askYesNo("csv-header", "Is the first line of the files the column names? (not for SELECT INTO OUTFILE)", false); err != nil {
+			return nil, err
+		}
+		doc["mydumper"]["csv"] = csv
+	}
+
+	// the target cluster.
+	host, err := w.ask("tidb-host", "What is the host of TiDB?", "127.0.0.1", nil)
+	if err != nil {
+		return nil, err
+	}
+	port, err := w.askPort("tidb-port", "What is the port of TiDB?", "4000")
+	if err != nil {
+		return nil, err
+	}
+	user, err := w.ask("tidb-user", "Which user connects to TiDB?", "root", nil)
+	if err != nil {
+		return nil, err
+	}
+	password, err := w.ask("tidb-password", "What is the password of the user? (empty if none)", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	statusPort, err := w.askPort("tidb-status-port", "What is the status port of TiDB?", "10080")
+	if err != nil {
+		return nil, err
+	}
+	pdAddr, err := w.ask("pd-addr", "What is the address of PD?", net.JoinHostPort(host, "2379"), nil)
+	if err != nil {
+		return nil, err
+	}
+	doc["tidb"]["host"] = host
+	doc["tidb"]["port"] = port
+	doc["tidb"]["user"] = user
+	doc["tidb"]["password"] = password
+	doc["tidb"]["status-port"] = statusPort
+	doc["tidb"]["pd-addr"] = pdAddr
+
+	defaultBackend := config.BackendLocal
+	version, err := w.tidbVersion(net.JoinHostPort(host, strconv.Itoa(statusPort)))
+	if err != nil {
+		w.printf("  cannot fetch the version of TiDB, please check the host and the status port: %s\n", err)
+	} else {
+		w.printf("  found TiDB %s\n", version)
+		if version.LessThan(localMinTiDBVersion) {
+			w.printf("  the local backend requires TiDB %s or later\n", localMinTiDBVersion)
+			defaultBackend = config.BackendImporter
+		}
+	}
+
+	// the backend.
+	w.printf("\nThe backends to import the data:\n" +
+		"  local    - sort the data locally and ingest into TiKV, the fastest, requires TiDB 4.0+ and free disk space\n" +
+		"  importer - ingest through a tikv-importer server\n" +
+		"  tidb     - execute the INSERT statements, the slowest but the cluster stays online\n")
+	backend, err := w.askChoice("backend", "Which backend to use?", defaultBackend,
+		config.BackendLocal, config.BackendImporter, config.BackendTiDB)
+	if err != nil {
+		return nil, err
+	}
+	doc["tikv-importer"]["backend"] = backend
+	switch backend {
+	case config.BackendLocal:
+		sortedKVDir, err := w.ask("sorted-kv-dir", "Where to sort the data? (a directory on a fast disk)", "/tmp/sorted-kv-dir", func(answer string) error {
+			if !filepath.IsAbs(answer) {
+				return errors.New("please use an absolute path")
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		doc["tikv-importer"]["sorted-kv-dir"] = sortedKVDir
+		w.checkDiskSpace(sortedKVDir, sourceSize)
+	case config.BackendImporter:
+		addr, err := w.ask("importer-addr", "What is the address of tikv-importer?", "127.0.0.1:8287", nil)
+		if err != nil {
+			return nil, err
+		}
+		doc["tikv-importer"]["addr"] = addr
+	}
+	return doc, nil
+}
+
+func (w *Wizard) askPort(key, question, defaultValue string) (int, error) {
+	answer, err := w.ask(key, question, defaultValue, func(answer string) error {
+		if port, err := strconv.Atoi(answer); err != nil || port <= 0 || port > 65535 {
+			return errors.New("please answer a port number")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	port, _ := strconv.Atoi(answer)
+	return port, nil
+}
+
+// checkDiskSpace warns if the free space of the disk of the sorted KV
+// directory, which may not exist yet, is less than the size of the data.
+func (w *Wizard) checkDiskSpace(dir string, sourceSize int64) {
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	free, err := w.diskFreeSpace(dir)
+	if err != nil {
+		w.printf("  cannot check the free space of %s: %s\n", dir, err)
+		return
+	}
+	w.printf("  found %s free on the disk of %s\n", formatSize(free), dir)
+	if sourceSize > 0 && free < uint64(sourceSize) {
+		w.printf("  the free space may be insufficient, the sorted data is about as large as the data files\n")
+	}
+}
+
+func formatSize(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 4 {
+		value /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exp])
+}
+
+// scanSource returns the total size of the files in the directory, and the
+// number of the files of each extension, ignoring the compression.
+func scanSource(dir string) (size int64, counts map[string]int) {
+	counts = make(map[string]int)
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		size += info.Size()
+		name := strings.ToLower(info.Name())
+		for _, ext := range []string{".gz", ".zst", ".lz4", ".xz"} {
+			name = strings.TrimSuffix(name, ext)
+		}
+		counts[strings.TrimPrefix(filepath.Ext(name), ".")]++
+		return nil
+	})
+	return
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 PingCAP, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wizard
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-semver/semver"
+	. "github.com/pingcap/check"
+	"github.com/pingcap/errors"
+
+	"github.com/pingcap/tidb-lightning/lightning/config"
+)
+
+func TestWizard(t *testing.T) {
+	TestingT(t)
+}
+
+var _ = Suite(&wizardSuite{})
+
+type wizardSuite struct {
+	sourceDir string
+	path      string
+}
+
+func (s *wizardSuite) SetUpTest(c *C) {
+	s.sourceDir = c.MkDir()
+	c.Assert(ioutil.WriteFile(filepath.Join(s.sourceDir, "db.t.0001.csv"), []byte("a,b\n1,2\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.sourceDir, "db.t-schema.sql"), []byte("create table t (a int, b int);"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.sourceDir, "db.t.0002.csv.gz"), []byte("gz"), 0644), IsNil)
+	s.path = filepath.Join(c.MkDir(), "lightning.toml")
+}
+
+func (s *wizardSuite) newWizard(input string, version string) (*Wizard, *bytes.Buffer) {
+	var out bytes.Buffer
+	w := New(strings.NewReader(input), &out, s.path)
+	w.diskFreeSpace = func(string) (uint64, error) {
+		return 1 << 30, nil
+	}
+	w.tidbVersion = func(string) (*semver.Version, error) {
+		if len(version) == 0 {
+			return nil, errors.New("connection refused")
+		}
+		return semver.NewVersion(version)
+	}
+	return w, &out
+}
+
+func (s *wizardSuite) loadConfig(c *C) *config.Config {
+	data, err := ioutil.ReadFile(s.path)
+	c.Assert(err, IsNil)
+	cfg := config.NewConfig()
+	c.Assert(cfg.LoadFromTOML(data), IsNil)
+	return cfg
+}
+
+func (s *wizardSuite) TestRunLocal(c *C) {
+	w, out := s.newWizard(strings.Join([]string{
+		s.sourceDir,
+		"",   // the detected format csv
+		"|",  // separator
+		"no", // header
+		"tidb.local",
+		"",    // port
+		"",    // user
+		"pwd", // password
+		"",    // status port
+		"",    // PD
+		"",    // backend
+		"/tmp/sorted-kv-dir",
+	}, "\n")+"\n", "5.0.0")
+	c.Assert(w.Run(), IsNil)
+	c.Assert(out.String(), Matches, `(?s).*found 40 B of data files.*found TiDB 5\.0\.0.*found 1\.0 GiB free on the disk of .*`)
+
+	cfg := s.loadConfig(c)
+	c.Assert(cfg.Mydumper.SourceDir, Equals, s.sourceDir)
+	c.Assert(cfg.Mydumper.CSV.Separator, Equals, "|")
+	c.Assert(cfg.Mydumper.CSV.Header, IsFalse)
+	c.Assert(cfg.TiDB.Host, Equals, "tidb.local")
+	c.Assert(cfg.TiDB.Port, Equals, 4000)
+	c.Assert(cfg.TiDB.User, Equals, "root")
+	c.Assert(cfg.TiDB.Psw, Equals, "pwd")
+	c.Assert(cfg.TiDB.StatusPort, Equals, 10080)
+	c.Assert(cfg.TiDB.PdAddr, Equals, "tidb.local:2379")
+	c.Assert(cfg.TikvImporter.Backend, Equals, config.BackendLocal)
+	c.Assert(cfg.TikvImporter.SortedKVDir, Equals, "/tmp/sorted-kv-dir")
+
+	_, err := os.Stat(w.draftPath())
+	c.Assert(os.IsNotExist(err), IsTrue)
+}
+
+func (s *wizardSuite) TestInvalidAnswers(c *C) {
+	w, out := s.newWizard(strings.Join([]string{
+		filepath.Join(s.sourceDir, "not-exists"),
+		s.sourceDir,
+		"xml",
+		"tsv",
+		"", // header
+		"", // host
+		"port",
+		"",
+		"", // user
+		"", // password
+		"", // status port
+		"", // PD
+		"", // backend
+		"", // importer
+	}, "\n")+"\n", "3.0.0")
+	c.Assert(w.Run(), IsNil)
+	c.Assert(out.String(), Matches, `(?s).*is not a directory.*please answer one of sql, csv, tsv, parquet.*please answer a port number.*`+
+		`the local backend requires TiDB 4\.0\.0 or later.*`)
+
+	cfg := s.loadConfig(c)
+	c.Assert(cfg.Mydumper.CSV.Format, Equals, config.CSVFormatTSV)
+	c.Assert(cfg.Mydumper.CSV.Header, IsFalse)
+	c.Assert(cfg.TikvImporter.Backend, Equals, config.BackendImporter)
+	c.Assert(cfg.TikvImporter.Addr, Equals, "127.0.0.1:8287")
+}
+
+func (s *wizardSuite) TestResume(c *C) {
+	w, _ := s.newWizard(strings.Join([]string{s.sourceDir, "sql", "tidb.local"}, "\n")+"\n", "")
+	c.Assert(w.Run(), ErrorMatches, "interview aborted, the answers are saved in .*, run again to resume")
+	_, err := os.Stat(w.draftPath())
+	c.Assert(err, IsNil)
+
+	w, out := s.newWizard(strings.Join([]string{
+		"", // resume
+		"4001",
+		"", // user
+		"", // password
+		"", // status port
+		"", // PD
+		"tidb",
+	}, "\n")+"\n", "")
+	c.Assert(w.Run(), IsNil)
+	c.Assert(out.String(), Matches, `(?s).*What is the host of TiDB\?: tidb.local \(saved\).*cannot fetch the version of TiDB.*`)
+
+	cfg := s.loadConfig(c)
+	c.Assert(cfg.Mydumper.SourceDir, Equals, s.sourceDir)
+	c.Assert(cfg.TiDB.Host, Equals, "tidb.local")
+	c.Assert(cfg.TiDB.Port, Equals, 4001)
+	c.Assert(cfg.TikvImporter.Backend, Equals, config.BackendTiDB)
+
+	// the existing config is not overwritten without the confirmation.
+	w, _ = s.newWizard(strings.Join([]string{s.sourceDir, "", "", "", "", "", "", "", "", "", "tidb", ""}, "\n")+"\n", "")
+	c.Assert(w.Run(), ErrorMatches, ".* exists, the answers are saved in .*")
+}
@@ -15,6 +15,9 @@ package worker
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingcap/tidb-lightning/lightning/metric"
@@ -24,12 +27,35 @@ type Pool struct {
 	limit   int
 	workers chan *Worker
 	name    string
+
+	mu   sync.Mutex
+	busy map[int64]*Worker
 }
 
 type Worker struct {
 	ID int64
+	// lastActive is the Unix time in nanoseconds when the worker is applied or
+	// last touched.
+	lastActive int64
+}
+
+// Touch records the worker is still making progress.
+func (worker *Worker) Touch() {
+	if worker != nil {
+		atomic.StoreInt64(&worker.lastActive, time.Now().UnixNano())
+	}
 }
 
+// LastActive returns the time the worker is applied or last touched.
+func (worker *Worker) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&worker.lastActive))
+}
+
+var (
+	registryLock sync.Mutex
+	registry     = make(map[string]*Pool)
+)
+
 func NewPool(ctx context.Context, limit int, name string) *Pool {
 	workers := make(chan *Worker, limit)
 	for i := 0; i < limit; i++ {
@@ -37,16 +63,51 @@ func NewPool(ctx context.Context, limit int, name string) *Pool {
 	}
 
 	metric.IdleWorkersGauge.WithLabelValues(name).Set(float64(limit))
-	return &Pool{
+	pool := &Pool{
 		limit:   limit,
 		workers: workers,
 		name:    name,
+		busy:    make(map[int64]*Worker),
 	}
+
+	// the pools of a new task replace the ones of the same name of the
+	// previous task.
+	registryLock.Lock()
+	registry[name] = pool
+	registryLock.Unlock()
+	return pool
 }
 
 func (pool *Pool) Apply() *Worker {
+	start := time.Now()
+	return pool.markBusy(<-pool.workers, start)
+}
+
+// ApplyPreferred applies the worker of the ID if it is idle, or any idle
+// worker otherwise. Like Apply, it waits until a worker is idle.
+func (pool *Pool) ApplyPreferred(id int64) *Worker {
 	start := time.Now()
 	worker := <-pool.workers
+	// the other idle workers are put back, which never blocks since the
+	// worker taken out leaves a vacancy in the channel.
+scan:
+	for n := len(pool.workers); worker.ID != id && n > 0; n-- {
+		select {
+		case other := <-pool.workers:
+			pool.workers <- worker
+			worker = other
+		default:
+			break scan
+		}
+	}
+	return pool.markBusy(worker, start)
+}
+
+func (pool *Pool) markBusy(worker *Worker, start time.Time) *Worker {
+	worker.Touch()
+	pool.mu.Lock()
+	pool.busy[worker.ID] = worker
+	pool.mu.Unlock()
 	metric.IdleWorkersGauge.WithLabelValues(pool.name).Set(float64(len(pool.workers)))
 	metric.ApplyWorkerSecondsHistogram.WithLabelValues(pool.name).Observe(time.Since(start).Seconds())
 	return worker
@@ -56,6 +117,9 @@ func (pool *Pool) Recycle(worker *Worker) {
 	if worker == nil {
 		panic("invalid restore worker")
 	}
+	pool.mu.Lock()
+	delete(pool.busy, worker.ID)
+	pool.mu.Unlock()
 	pool.workers <- worker
 	metric.IdleWorkersGauge.WithLabelValues(pool.name).Set(float64(len(pool.workers)))
 }
@@ -63,3 +127,45 @@ func (pool *Pool) Recycle(worker *Worker) {
 func (pool *Pool) HasWorker() bool {
 	return len(pool.workers) > 0
 }
+
+// BusyWorker is a worker applied from a pool.
+type BusyWorker struct {
+	ID         int64     `json:"id"`
+	LastActive time.Time `json:"last-active"`
+}
+
+// PoolStatus is the status of a worker pool.
+type PoolStatus struct {
+	Name  string       `json:"name"`
+	Limit int          `json:"limit"`
+	Busy  []BusyWorker `json:"busy"`
+}
+
+// Status returns the workers currently applied from the pool.
+func (pool *Pool) Status() PoolStatus {
+	pool.mu.Lock()
+	busy := make([]BusyWorker, 0, len(pool.busy))
+	for _, worker := range pool.busy {
+		busy = append(busy, BusyWorker{ID: worker.ID, LastActive: worker.LastActive()})
+	}
+	pool.mu.Unlock()
+	sort.Slice(busy, func(i, j int) bool { return busy[i].ID < busy[j].ID })
+	return PoolStatus{Name: pool.name, Limit: pool.limit, Busy: busy}
+}
+
+// AllPoolStatus returns the status of the latest pool of every name.
+func AllPoolStatus() []PoolStatus {
+	registryLock.Lock()
+	pools := make([]*Pool, 0, len(registry))
+	for _, pool := range registry {
+		pools = append(pools, pool)
+	}
+	registryLock.Unlock()
+
+	res := make([]PoolStatus, 0, len(pools))
+	for _, pool := range pools {
+		res = append(res, pool.Status())
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
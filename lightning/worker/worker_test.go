@@ -16,6 +16,7 @@ package worker_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	. "github.com/pingcap/check"
 
@@ -54,3 +55,57 @@ func (s *testWorkerPool) TestApplyRecycle(c *C) {
 
 	c.Assert(func() { pool.Recycle(nil) }, PanicMatches, "invalid restore worker")
 }
+
+func (s *testWorkerPool) TestApplyPreferred(c *C) {
+	pool := worker.NewPool(context.Background(), 3, "test-preferred")
+
+	w2 := pool.ApplyPreferred(2)
+	c.Assert(w2.ID, Equals, int64(2))
+	w3 := pool.ApplyPreferred(3)
+	c.Assert(w3.ID, Equals, int64(3))
+	// the preferred worker is busy, so any idle worker is applied.
+	w1 := pool.ApplyPreferred(2)
+	c.Assert(w1.ID, Equals, int64(1))
+	c.Assert(pool.HasWorker(), IsFalse)
+
+	pool.Recycle(w1)
+	pool.Recycle(w2)
+	pool.Recycle(w3)
+	c.Assert(pool.ApplyPreferred(1), Equals, w1)
+	c.Assert(pool.ApplyPreferred(0), NotNil)
+	c.Assert(pool.ApplyPreferred(2), NotNil)
+	c.Assert(pool.HasWorker(), IsFalse)
+}
+
+func (s *testWorkerPool) TestStatus(c *C) {
+	pool := worker.NewPool(context.Background(), 2, "test-status")
+
+	status := pool.Status()
+	c.Assert(status.Name, Equals, "test-status")
+	c.Assert(status.Limit, Equals, 2)
+	c.Assert(status.Busy, HasLen, 0)
+
+	before := time.Now()
+	w1 := pool.Apply()
+	applied := w1.LastActive()
+	c.Assert(applied.Before(before), IsFalse)
+
+	time.Sleep(10 * time.Millisecond)
+	w1.Touch()
+	c.Assert(w1.LastActive().After(applied), IsTrue)
+
+	status = pool.Status()
+	c.Assert(status.Busy, DeepEquals, []worker.BusyWorker{{ID: 1, LastActive: w1.LastActive()}})
+
+	var found bool
+	for _, st := range worker.AllPoolStatus() {
+		if st.Name == "test-status" {
+			found = true
+			c.Assert(st.Busy, HasLen, 1)
+		}
+	}
+	c.Assert(found, IsTrue)
+
+	pool.Recycle(w1)
+	c.Assert(pool.Status().Busy, HasLen, 0)
+}
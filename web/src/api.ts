@@ -56,6 +56,7 @@ export interface TableInfo {
     z: number
     s: TaskStatus
     m?: string
+    p?: { [phase: string]: number }
 }
 
 export interface TaskProgress {